@@ -0,0 +1,193 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"xdcrDiffer/stats"
+
+	"github.com/couchbase/gocbcore/v10"
+)
+
+// Shares one KV agent per cluster and bucket across the phases of a run, i.e. the checkpoint managers of the
+// DCP drivers and the mutation differ, so that connections to every node, the cluster map and authentication
+// are set up once per cluster instead of once per phase.
+// An agent is created from the config of whichever phase asks for it first, and is kept open until the pool is closed.
+// DCP agents are not pooled, as each DCP connection has its own stream and flow control.
+// A nil pool creates a new agent for every caller
+type AgentPool struct {
+	// Every agent is created with at least this many requests allowed to be queued, so that it can serve the
+	// phase with the most requests in flight
+	minQueueSize int
+
+	mtx    sync.Mutex
+	agents map[string]*pooledAgent
+	closed bool
+}
+
+type pooledAgent struct {
+	// Closed once the agent has been created or failed to be
+	ready chan bool
+	agent *gocbcore.Agent
+	err   error
+}
+
+func NewAgentPool(minQueueSize int) *AgentPool {
+	return &AgentPool{
+		minQueueSize: minQueueSize,
+		agents:       make(map[string]*pooledAgent),
+	}
+}
+
+// Returns the agent of the given cluster and the bucket of config, creating it from config if there is none yet
+func (p *AgentPool) Get(clusterName string, config *gocbcore.AgentConfig, setupTimeout time.Duration) (*gocbcore.Agent, error) {
+	if p == nil {
+		return CreateAgent(config, setupTimeout)
+	}
+
+	key := clusterName + FileDirDelimiter + config.BucketName
+	p.mtx.Lock()
+	if p.closed {
+		p.mtx.Unlock()
+		return nil, fmt.Errorf("agent pool is closed")
+	}
+	pooled, exists := p.agents[key]
+	if !exists {
+		pooled = &pooledAgent{ready: make(chan bool)}
+		p.agents[key] = pooled
+	}
+	p.mtx.Unlock()
+
+	if exists {
+		<-pooled.ready
+		if pooled.err == nil {
+			stats.Default.Counter(fmt.Sprintf(stats.KvAgentsReused, clusterName)).Add(1)
+		}
+		return pooled.agent, pooled.err
+	}
+
+	if config.KVConfig.MaxQueueSize < p.minQueueSize {
+		config.KVConfig.MaxQueueSize = p.minQueueSize
+	}
+	pooled.agent, pooled.err = CreateAgent(config, setupTimeout)
+	if pooled.err != nil {
+		// Whoever asks next tries again
+		p.mtx.Lock()
+		delete(p.agents, key)
+		p.mtx.Unlock()
+	} else {
+		stats.Default.Counter(fmt.Sprintf(stats.KvAgentsCreated, clusterName)).Add(1)
+	}
+	close(pooled.ready)
+	return pooled.agent, pooled.err
+}
+
+// Closes every agent in the pool. Agents cannot be used once closed
+func (p *AgentPool) Close() {
+	if p == nil {
+		return
+	}
+	p.mtx.Lock()
+	p.closed = true
+	agents := p.agents
+	p.agents = make(map[string]*pooledAgent)
+	p.mtx.Unlock()
+
+	for _, pooled := range agents {
+		<-pooled.ready
+		if pooled.agent != nil {
+			pooled.agent.Close()
+		}
+	}
+}
+
+// Pings the KV service of every agent in the pool, so that connections left idle, i.e. between the phases of a run,
+// are not dropped by firewalls. Returns why each agent that did not answer on every connection did not, by the
+// cluster and bucket it is of. The SDK connects again in the background where a connection was dropped
+func (p *AgentPool) Ping(timeout time.Duration) map[string]error {
+	if p == nil {
+		return nil
+	}
+	agents := make(map[string]*gocbcore.Agent)
+	p.mtx.Lock()
+	for key, pooled := range p.agents {
+		select {
+		case <-pooled.ready:
+			if pooled.agent != nil {
+				agents[key] = pooled.agent
+			}
+		default:
+			// Still being created, which keeps its connections busy enough
+		}
+	}
+	p.mtx.Unlock()
+
+	failures := make(map[string]error)
+	for key, agent := range agents {
+		if err := pingAgent(agent, timeout); err != nil {
+			failures[key] = err
+		}
+	}
+	return failures
+}
+
+func pingAgent(agent *gocbcore.Agent, timeout time.Duration) error {
+	signal := make(chan error, 1)
+	_, err := agent.Ping(gocbcore.PingOptions{
+		ServiceTypes: []gocbcore.ServiceType{gocbcore.MemdService},
+		KVDeadline:   time.Now().Add(timeout),
+	}, func(result *gocbcore.PingResult, err error) {
+		if err == nil {
+			for _, endpoint := range result.Services[gocbcore.MemdService] {
+				if endpoint.Error != nil {
+					err = fmt.Errorf("%v: %v", endpoint.Endpoint, endpoint.Error)
+					break
+				}
+			}
+		}
+		signal <- err
+	})
+	if err != nil {
+		return err
+	}
+	return <-signal
+}
+
+// Creates an agent and waits until its connections to KV are up. The agent is closed if they do not come up in time
+func CreateAgent(config *gocbcore.AgentConfig, setupTimeout time.Duration) (*gocbcore.Agent, error) {
+	agent, err := gocbcore.CreateAgent(config)
+	if err != nil {
+		return nil, err
+	}
+
+	options := gocbcore.WaitUntilReadyOptions{
+		DesiredState:  gocbcore.ClusterStateOnline,
+		ServiceTypes:  []gocbcore.ServiceType{gocbcore.MemdService},
+		RetryStrategy: &RetryStrategy{},
+	}
+
+	signal := make(chan error, 1)
+	_, err = agent.WaitUntilReady(time.Now().Add(setupTimeout),
+		options, func(res *gocbcore.WaitUntilReadyResult, er error) {
+			signal <- er
+		})
+
+	if err == nil {
+		err = <-signal
+	}
+
+	if err != nil {
+		errClosing := agent.Close()
+		return nil, fmt.Errorf("Closing agent %v because of err=%v, error while closing=%v", config.UserAgent, err, errClosing)
+	}
+	return agent, nil
+}
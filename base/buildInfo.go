@@ -0,0 +1,52 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Set when building, i.e. by make, with -ldflags "-X xdcrDiffer/base.Version=... -X xdcrDiffer/base.Commit=..."
+// Left as they are for builds that do not set them, such as go build or go test
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = ""
+)
+
+// Which build of the tool produced a run, so that its output can be traced back to the sources
+type BuildInfo struct {
+	Version   string
+	Commit    string
+	BuildTime string `json:",omitempty"`
+	GoVersion string
+	GOOS      string
+	GOARCH    string
+}
+
+func GetBuildInfo() *BuildInfo {
+	return &BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+	}
+}
+
+func (b *BuildInfo) String() string {
+	built := ""
+	if b.BuildTime != "" {
+		built = fmt.Sprintf(" built %v", b.BuildTime)
+	}
+	return fmt.Sprintf("xdcrDiffer %v (commit %v%v) %v %v/%v", b.Version, b.Commit, built, b.GoVersion, b.GOOS, b.GOARCH)
+}
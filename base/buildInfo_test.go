@@ -0,0 +1,33 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildInfo(t *testing.T) {
+	fmt.Println("============== Test case start: TestBuildInfo =================")
+	assert := assert.New(t)
+
+	// Tests are built without the linker flags
+	info := GetBuildInfo()
+	assert.Equal("dev", info.Version)
+	assert.Equal(runtime.GOARCH, info.GOARCH)
+	assert.Equal(fmt.Sprintf("xdcrDiffer dev (commit unknown) %v %v/%v", runtime.Version(), runtime.GOOS, runtime.GOARCH), info.String())
+
+	info = &BuildInfo{Version: "v1.4.0", Commit: "3f2a9c1", BuildTime: "2026-10-16T09:12:00Z", GoVersion: "go1.21.5", GOOS: "linux", GOARCH: "arm64"}
+	assert.Equal("xdcrDiffer v1.4.0 (commit 3f2a9c1 built 2026-10-16T09:12:00Z) go1.21.5 linux/arm64", info.String())
+	fmt.Println("============== Test case end: TestBuildInfo =================")
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// Each capture file written by the DCP handlers starts with a self-describing header
+// so that older capture directories remain readable after the record layout changes
+//
+//	magic           - 4 bytes
+//	version         - 2 bytes
+//	flags           - 2 bytes
+//	collectionIdLen - 1 byte (width of the collectionId field of each record)
+//	reserved        - 3 bytes
+//	checksum        - 4 bytes (CRC32-C of the preceding 12 bytes)
+//
+// Files created before the header was introduced are treated as CaptureFileVersionLegacy
+const CaptureFileMagic = "XDCD"
+const CaptureFileHeaderLen = 16
+const CaptureRecordChecksumLen = 4
+const CaptureCollectionIdLen = 4
+
+const (
+	CaptureFileVersionLegacy uint16 = 0
+	CaptureFileVersion1      uint16 = 1
+)
+
+const CaptureFileCurrentVersion = CaptureFileVersion1
+
+// When set, each record is followed by a CRC32-C checksum of the record
+const CaptureFileFlagRecordChecksum uint16 = 0x1
+
+// When set, the hash of each record covers the document body alone, and is followed by
+// a separate hash of the xattrs that are compared, so that the two can be told apart
+const CaptureFileFlagXattrHash uint16 = 0x2
+
+// Length of the xattr hash of a record, which is zeroed when no compared xattrs exist
+const CaptureXattrHashLen = 8
+
+// When set, each record carries the Sync Gateway revision of the document, which is empty
+// unless the document was captured in Sync Gateway mode and has been imported by Sync Gateway
+const CaptureFileFlagSyncRev uint16 = 0x4
+
+// When set, documents were streamed without their bodies, so that the body hash of each record
+// is of an empty body and only the metadata and xattrs of the document can be compared
+const CaptureFileFlagNoValue uint16 = 0x8
+
+// When set, each record carries the size of the value of the document, xattrs included, as it was streamed, so
+// that the documents to verify can be fetched in batches of about the same number of bytes
+const CaptureFileFlagValueSize uint16 = 0x10
+
+var ErrNoCaptureFileHeader = errors.New("capture file does not have a header")
+var ErrCaptureFileCorrupted = errors.New("capture file is corrupted")
+
+// Upper bound of the HLV length of a single record, which is an xattr and limited in size by KV
+// Anything larger means the length field itself cannot be trusted
+const MaxCaptureHlvLen = 1024 * 1024
+
+var captureChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+type CaptureFileHeader struct {
+	Version         uint16
+	Flags           uint16
+	CollectionIdLen uint8
+}
+
+func NewCaptureFileHeader() *CaptureFileHeader {
+	return &CaptureFileHeader{
+		Version:         CaptureFileCurrentVersion,
+		Flags:           CaptureFileFlagRecordChecksum | CaptureFileFlagXattrHash | CaptureFileFlagSyncRev | CaptureFileFlagValueSize,
+		CollectionIdLen: CaptureCollectionIdLen,
+	}
+}
+
+// Header used for files that were written without one
+func NewLegacyCaptureFileHeader() *CaptureFileHeader {
+	return &CaptureFileHeader{
+		Version:         CaptureFileVersionLegacy,
+		CollectionIdLen: CaptureCollectionIdLen,
+	}
+}
+
+func (h *CaptureFileHeader) HasRecordChecksum() bool {
+	return h.Flags&CaptureFileFlagRecordChecksum > 0
+}
+
+func (h *CaptureFileHeader) HasXattrHash() bool {
+	return h.Flags&CaptureFileFlagXattrHash > 0
+}
+
+func (h *CaptureFileHeader) HasSyncRev() bool {
+	return h.Flags&CaptureFileFlagSyncRev > 0
+}
+
+func (h *CaptureFileHeader) HasNoValue() bool {
+	return h.Flags&CaptureFileFlagNoValue > 0
+}
+
+func (h *CaptureFileHeader) HasValueSize() bool {
+	return h.Flags&CaptureFileFlagValueSize > 0
+}
+
+func (h *CaptureFileHeader) Encode() []byte {
+	ret := make([]byte, CaptureFileHeaderLen)
+	copy(ret[0:4], CaptureFileMagic)
+	binary.BigEndian.PutUint16(ret[4:6], h.Version)
+	binary.BigEndian.PutUint16(ret[6:8], h.Flags)
+	ret[8] = h.CollectionIdLen
+	binary.BigEndian.PutUint32(ret[12:16], CaptureChecksum(ret[0:12]))
+	return ret
+}
+
+// Returns ErrNoCaptureFileHeader if data does not start with the capture file magic,
+// in which case the data should be read as CaptureFileVersionLegacy records
+func DecodeCaptureFileHeader(data []byte) (*CaptureFileHeader, error) {
+	if len(data) < len(CaptureFileMagic) || !bytes.Equal(data[0:4], []byte(CaptureFileMagic)) {
+		return nil, ErrNoCaptureFileHeader
+	}
+	if len(data) < CaptureFileHeaderLen {
+		return nil, fmt.Errorf("%w: header is truncated to %v bytes", ErrCaptureFileCorrupted, len(data))
+	}
+	if binary.BigEndian.Uint32(data[12:16]) != CaptureChecksum(data[0:12]) {
+		return nil, fmt.Errorf("%w: header checksum mismatch", ErrCaptureFileCorrupted)
+	}
+
+	header := &CaptureFileHeader{
+		Version:         binary.BigEndian.Uint16(data[4:6]),
+		Flags:           binary.BigEndian.Uint16(data[6:8]),
+		CollectionIdLen: data[8],
+	}
+	if header.Version > CaptureFileCurrentVersion {
+		return nil, fmt.Errorf("capture file version %v is newer than the supported version %v", header.Version, CaptureFileCurrentVersion)
+	}
+	if header.CollectionIdLen != CaptureCollectionIdLen {
+		return nil, fmt.Errorf("capture file collection ID width %v is not supported", header.CollectionIdLen)
+	}
+	return header, nil
+}
+
+func CaptureChecksum(data []byte) uint32 {
+	return crc32.Checksum(data, captureChecksumTable)
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaptureFileHeaderRoundTrip(t *testing.T) {
+	fmt.Println("============== Test case start: TestCaptureFileHeaderRoundTrip =================")
+	assert := assert.New(t)
+
+	header := NewCaptureFileHeader()
+	encoded := header.Encode()
+	assert.Equal(CaptureFileHeaderLen, len(encoded))
+
+	decoded, err := DecodeCaptureFileHeader(encoded)
+	assert.Nil(err)
+	assert.Equal(header, decoded)
+	assert.True(decoded.HasRecordChecksum())
+	assert.True(decoded.HasXattrHash())
+	assert.True(decoded.HasSyncRev())
+	assert.True(decoded.HasValueSize())
+	assert.False(decoded.HasNoValue())
+	assert.False(NewLegacyCaptureFileHeader().HasXattrHash())
+	assert.False(NewLegacyCaptureFileHeader().HasValueSize())
+
+	header.Flags |= CaptureFileFlagNoValue
+	decoded, err = DecodeCaptureFileHeader(header.Encode())
+	assert.Nil(err)
+	assert.True(decoded.HasNoValue())
+	fmt.Println("============== Test case end: TestCaptureFileHeaderRoundTrip =================")
+}
+
+func TestCaptureFileHeaderLegacyAndCorrupted(t *testing.T) {
+	fmt.Println("============== Test case start: TestCaptureFileHeaderLegacyAndCorrupted =================")
+	assert := assert.New(t)
+
+	// A legacy file starts with the 2-byte key length of its first record
+	_, err := DecodeCaptureFileHeader([]byte{0, 5, 'a', 'b', 'c', 'd', 'e'})
+	assert.Equal(ErrNoCaptureFileHeader, err)
+	_, err = DecodeCaptureFileHeader(nil)
+	assert.Equal(ErrNoCaptureFileHeader, err)
+
+	encoded := NewCaptureFileHeader().Encode()
+	_, err = DecodeCaptureFileHeader(encoded[:CaptureFileHeaderLen-1])
+	assert.NotNil(err)
+	assert.NotEqual(ErrNoCaptureFileHeader, err)
+
+	encoded[6] ^= 0xff
+	_, err = DecodeCaptureFileHeader(encoded)
+	assert.NotNil(err)
+	assert.NotEqual(ErrNoCaptureFileHeader, err)
+	fmt.Println("============== Test case end: TestCaptureFileHeaderLegacyAndCorrupted =================")
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Links the capture files of a previous run into toDir, which must not hold any files yet, so that the run
+// only has to stream what changed since. Files that cannot be hard linked, i.e. across file systems, are cloned
+// Returns the names of the files linked
+func LinkCaptureFiles(fromDir, toDir string) ([]string, error) {
+	existing, err := ioutil.ReadDir(toDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, fileInfo := range existing {
+		if fileInfo.Mode().IsRegular() {
+			return nil, fmt.Errorf("%v already holds capture files", toDir)
+		}
+	}
+	if err = os.MkdirAll(toDir, 0777); err != nil {
+		return nil, err
+	}
+
+	fileInfos, err := ioutil.ReadDir(fromDir)
+	if err != nil {
+		return nil, err
+	}
+	var linked []string
+	for _, fileInfo := range fileInfos {
+		if !fileInfo.Mode().IsRegular() {
+			continue
+		}
+		from, to := filepath.Join(fromDir, fileInfo.Name()), filepath.Join(toDir, fileInfo.Name())
+		if err = os.Link(from, to); err != nil {
+			if err = CloneFile(from, to); err != nil {
+				return linked, err
+			}
+		}
+		linked = append(linked, fileInfo.Name())
+	}
+	return linked, nil
+}
+
+// Gives a capture file that is hard linked from another run a copy of its own, so that appending to it leaves
+// the other run untouched. The copy is a reflink where the file system supports it, which shares the data until
+// either side is written to
+func UnshareFile(fileName string) error {
+	fileInfo, err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !isShared(fileInfo) {
+		return nil
+	}
+	// Left over if a previous run stopped half way through
+	tmpFileName := fileName + ".unshare"
+	os.Remove(tmpFileName)
+	if err = CloneFile(fileName, tmpFileName); err != nil {
+		os.Remove(tmpFileName)
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+// Copies from to a new file to, as a reflink where supported
+func CloneFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, FileModeReadWrite)
+	if err != nil {
+		return err
+	}
+	if reflink(src, dst) != nil {
+		if _, err = io.Copy(dst, src); err != nil {
+			dst.Close()
+			return err
+		}
+	}
+	return dst.Close()
+}
@@ -0,0 +1,31 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"os"
+	"syscall"
+)
+
+// FICLONE ioctl, supported by i.e. btrfs and xfs
+const ioctlFileClone = 0x40049409
+
+func reflink(src, dst *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ioctlFileClone, src.Fd())
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func isShared(fileInfo os.FileInfo) bool {
+	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+	return !ok || stat.Nlink > 1
+}
@@ -0,0 +1,26 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+//go:build !linux
+
+package base
+
+import (
+	"errors"
+	"os"
+)
+
+func reflink(src, dst *os.File) error {
+	return errors.New("reflinks are not supported on this platform")
+}
+
+// Link counts are not checked, so every file is taken to be shared and is copied before being appended to
+func isShared(fileInfo os.FileInfo) bool {
+	return true
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinkAndUnshareCaptureFiles(t *testing.T) {
+	fmt.Println("============== Test case start: TestLinkAndUnshareCaptureFiles =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "captureReuse")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	baselineDir, captureDir := filepath.Join(dir, "baseline"), filepath.Join(dir, "capture")
+	assert.Nil(os.MkdirAll(baselineDir, 0777))
+	assert.Nil(ioutil.WriteFile(filepath.Join(baselineDir, "diffTool_0_0"), []byte("vb0"), 0644))
+	assert.Nil(ioutil.WriteFile(filepath.Join(baselineDir, "diffTool_1_0"), []byte("vb1"), 0644))
+
+	linked, err := LinkCaptureFiles(baselineDir, captureDir)
+	assert.Nil(err)
+	assert.Equal([]string{"diffTool_0_0", "diffTool_1_0"}, linked)
+
+	// Appending to an unshared file leaves the baseline as it was
+	fileName := filepath.Join(captureDir, "diffTool_0_0")
+	assert.Nil(UnshareFile(fileName))
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(err)
+	_, err = file.Write([]byte("+new"))
+	assert.Nil(err)
+	assert.Nil(file.Close())
+	data, err := ioutil.ReadFile(fileName)
+	assert.Nil(err)
+	assert.Equal("vb0+new", string(data))
+	data, err = ioutil.ReadFile(filepath.Join(baselineDir, "diffTool_0_0"))
+	assert.Nil(err)
+	assert.Equal("vb0", string(data))
+
+	// Files that do not exist yet have nothing to unshare
+	assert.Nil(UnshareFile(filepath.Join(captureDir, "diffTool_2_0")))
+
+	// A capture is never linked over another one
+	_, err = LinkCaptureFiles(baselineDir, captureDir)
+	assert.NotNil(err)
+
+	fmt.Println("============== Test case end: TestLinkAndUnshareCaptureFiles =================")
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"xdcrDiffer/stats"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+var ErrRetryBudgetExhausted = errors.New("retry budget is exhausted")
+
+// Keeps the operations on a cluster that is failing from being retried to exhaustion. The outcomes of the latest
+// CircuitBreakerWindow operations are counted, and once the share of errors among them exceeds the threshold, the
+// breaker opens and onTrip is called. Retries are drawn from a budget of a share of all the operations made, so
+// that however many batches fail at once, retries never add more than that share to the load of the cluster.
+// A nil breaker never opens and allows every retry
+type CircuitBreaker struct {
+	cluster          string
+	thresholdPercent float64
+	budgetPercent    float64
+	onTrip           func(reason string)
+
+	mtx sync.Mutex
+	// Whether each of the latest operations failed, as a ring
+	window  []bool
+	next    int
+	counted int
+	errors  int
+
+	operations int64
+	retries    int64
+	// Why the breaker is open. Empty while it is closed
+	openReason string
+	trips      int
+}
+
+// A threshold of 0 never opens the breaker, and a budget of 0 allows every retry
+func NewCircuitBreaker(cluster string, thresholdPercent, budgetPercent float64, onTrip func(reason string)) *CircuitBreaker {
+	return &CircuitBreaker{
+		cluster:          cluster,
+		thresholdPercent: thresholdPercent,
+		budgetPercent:    budgetPercent,
+		onTrip:           onTrip,
+		window:           make([]bool, CircuitBreakerWindow),
+	}
+}
+
+// Counts the outcomes of operations, errors of them having failed
+func (b *CircuitBreaker) Record(operations, errors int) {
+	if b == nil || operations == 0 {
+		return
+	}
+	b.mtx.Lock()
+	b.operations += int64(operations)
+	for i := 0; i < operations; i++ {
+		failed := i < errors
+		if b.counted == len(b.window) {
+			if b.window[b.next] {
+				b.errors--
+			}
+		} else {
+			b.counted++
+		}
+		b.window[b.next] = failed
+		if failed {
+			b.errors++
+		}
+		b.next = (b.next + 1) % len(b.window)
+	}
+
+	var reason string
+	errorPercent := float64(b.errors) * 100 / float64(b.counted)
+	if b.openReason == "" && b.thresholdPercent > 0 && b.counted >= CircuitBreakerMinOperations && errorPercent > b.thresholdPercent {
+		reason = fmt.Sprintf("%v of the last %v operations on %v failed (%.0f%%, over the threshold of %v%%)",
+			b.errors, b.counted, b.cluster, errorPercent, b.thresholdPercent)
+		b.openReason = reason
+		b.trips++
+	}
+	b.mtx.Unlock()
+
+	if reason != "" {
+		stats.Default.Counter(fmt.Sprintf(stats.KvCircuitBreakerTrips, b.cluster)).Add(1)
+		if b.onTrip != nil {
+			b.onTrip(reason)
+		}
+	}
+}
+
+// Takes the retry of the given number of operations from the budget. Returns why it may not be retried otherwise
+func (b *CircuitBreaker) AllowRetry(operations int) error {
+	if b == nil {
+		return nil
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.openReason != "" {
+		return fmt.Errorf("%w on %v: %v", ErrCircuitOpen, b.cluster, b.openReason)
+	}
+	if b.budgetPercent > 0 {
+		budget := int64(float64(b.operations)*b.budgetPercent/100) + RetryBudgetMinRetries
+		if b.retries+int64(operations) > budget {
+			stats.Default.Counter(fmt.Sprintf(stats.KvRetriesDenied, b.cluster)).Add(int64(operations))
+			return fmt.Errorf("%w on %v: %v retries of %v operations, over %v%% of them", ErrRetryBudgetExhausted,
+				b.cluster, b.retries, b.operations, b.budgetPercent)
+		}
+	}
+	b.retries += int64(operations)
+	return nil
+}
+
+// Why no more operations are to be sent to the cluster. Nil while the breaker is closed
+func (b *CircuitBreaker) Open() error {
+	if b == nil {
+		return nil
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.openReason == "" {
+		return nil
+	}
+	return fmt.Errorf("%w on %v: %v", ErrCircuitOpen, b.cluster, b.openReason)
+}
+
+// Closes the breaker, forgetting the outcomes counted so far, i.e. once the run has been resumed
+func (b *CircuitBreaker) Reset() {
+	if b == nil {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.openReason = ""
+	b.next, b.counted, b.errors = 0, 0, 0
+}
+
+func (b *CircuitBreaker) Cluster() string {
+	return b.cluster
+}
+
+// How many times the breaker opened, and how many retries it allowed out of how many operations
+func (b *CircuitBreaker) Status() (trips int, retries, operations int64) {
+	if b == nil {
+		return 0, 0, 0
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.trips, b.retries, b.operations
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	fmt.Println("============== Test case start: TestCircuitBreaker =================")
+	assert := assert.New(t)
+
+	var nilBreaker *CircuitBreaker
+	nilBreaker.Record(10, 10)
+	assert.Nil(nilBreaker.AllowRetry(10))
+	assert.Nil(nilBreaker.Open())
+
+	var trips []string
+	breaker := NewCircuitBreaker("target", 50, 0, func(reason string) {
+		trips = append(trips, reason)
+	})
+	// Not enough operations counted yet
+	breaker.Record(CircuitBreakerMinOperations-1, CircuitBreakerMinOperations-1)
+	assert.Nil(breaker.Open())
+	// The latest window is counted, so that the errors of long ago are forgotten
+	breaker.Record(CircuitBreakerWindow, 0)
+	breaker.Record(CircuitBreakerWindow/2, CircuitBreakerWindow/2)
+	assert.Nil(breaker.Open())
+	breaker.Record(1, 1)
+	assert.Len(trips, 1)
+	assert.Equal("501 of the last 1000 operations on target failed (50%, over the threshold of 50%)", trips[0])
+	assert.True(errors.Is(breaker.Open(), ErrCircuitOpen))
+	assert.True(errors.Is(breaker.AllowRetry(1), ErrCircuitOpen))
+	// Trips once until reset
+	breaker.Record(10, 10)
+	assert.Len(trips, 1)
+
+	breaker.Reset()
+	assert.Nil(breaker.Open())
+	breaker.Record(CircuitBreakerMinOperations, CircuitBreakerMinOperations)
+	assert.Len(trips, 2)
+	tripCount, _, _ := breaker.Status()
+	assert.Equal(2, tripCount)
+
+	breaker = NewCircuitBreaker("source", 0, 10, nil)
+	breaker.Record(1000, 1000)
+	assert.Nil(breaker.Open())
+	// 10% of 1000 operations and the minimum
+	assert.Nil(breaker.AllowRetry(100 + RetryBudgetMinRetries))
+	err := breaker.AllowRetry(1)
+	assert.True(errors.Is(err, ErrRetryBudgetExhausted))
+	// Retries add to the operations the budget is a share of
+	breaker.Record(100, 0)
+	assert.Nil(breaker.AllowRetry(10))
+	_, retries, operations := breaker.Status()
+	assert.Equal(int64(210), retries)
+	assert.Equal(int64(1100), operations)
+	fmt.Println("============== Test case end: TestCircuitBreaker =================")
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ports of a cluster that are not the defaults, and addresses its nodes are to be reached at instead of the ones
+// they report, i.e. behind a port-mapped proxy. Nil ports leave everything as the cluster reports it
+type ClusterPorts struct {
+	// REST port, for URLs given without one
+	Mgmt uint16
+	// KV ports the SDK is bootstrapped over instead of the defaults, without and with TLS
+	Kv    uint16
+	KvTls uint16
+	// Network of alternate addresses the SDK is to connect to the nodes over, i.e. "external"
+	Network string
+	// Options of the connection string the cluster was given by, if any. See ParseConnStr
+	KvTimeout   time.Duration
+	BootstrapOn string
+	// Reported host:port -> host:port to connect to
+	NodeAddresses map[string]string
+}
+
+// Names of the ports as given to ParseClusterPorts
+const (
+	ClusterPortMgmt    = "mgmt"
+	ClusterPortKv      = "kv"
+	ClusterPortKvTls   = "kvTls"
+	ClusterPortNetwork = "network"
+)
+
+// Ports of the source and target clusters, set once the options are parsed
+var SourcePorts, TargetPorts *ClusterPorts
+
+func PortsOf(isSource bool) *ClusterPorts {
+	if isSource {
+		return SourcePorts
+	}
+	return TargetPorts
+}
+
+// Parses comma separated name=value pairs, where the name is one of the ports, or the address a node reports, i.e.
+// "mgmt=18091,kv=21210,kvTls=21207,10.0.0.5:11210=proxy.example.com:31210"
+func ParseClusterPorts(spec string) (*ClusterPorts, error) {
+	ports := &ClusterPorts{NodeAddresses: make(map[string]string)}
+	for _, pair := range strings.Split(spec, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid port %v. Expected name=value", pair)
+		}
+		name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch name {
+		case ClusterPortMgmt, ClusterPortKv, ClusterPortKvTls:
+			port, err := strconv.ParseUint(value, 10, 16)
+			if err != nil || port == 0 {
+				return nil, fmt.Errorf("Invalid port %v. The value has to be a port number", pair)
+			}
+			switch name {
+			case ClusterPortMgmt:
+				ports.Mgmt = uint16(port)
+			case ClusterPortKv:
+				ports.Kv = uint16(port)
+			default:
+				ports.KvTls = uint16(port)
+			}
+		case ClusterPortNetwork:
+			ports.Network = value
+		default:
+			for _, addr := range []string{name, value} {
+				if _, _, err := net.SplitHostPort(addr); err != nil {
+					return nil, fmt.Errorf("Invalid port %v. Accepted names are %v, %v, %v, %v and host:port of a node: %v",
+						pair, ClusterPortMgmt, ClusterPortKv, ClusterPortKvTls, ClusterPortNetwork, err)
+				}
+			}
+			ports.NodeAddresses[name] = value
+		}
+	}
+	return ports, nil
+}
+
+// Adds the REST port to a URL given without one
+func (p *ClusterPorts) Url(url string) string {
+	if p == nil || p.Mgmt == 0 {
+		return url
+	}
+	var scheme string
+	for _, prefix := range []string{HttpPrefix, HttpsPrefix} {
+		if strings.HasPrefix(url, prefix) {
+			scheme, url = prefix, strings.TrimPrefix(url, prefix)
+		}
+	}
+	if _, _, err := net.SplitHostPort(url); err == nil {
+		return scheme + url
+	}
+	return scheme + net.JoinHostPort(strings.Trim(url, "[]"), strconv.Itoa(int(p.Mgmt)))
+}
+
+// The KV port to bootstrap over, or 0 for the default
+func (p *ClusterPorts) KvPort(secure bool) uint16 {
+	if p == nil {
+		return 0
+	}
+	if secure {
+		return p.KvTls
+	}
+	return p.Kv
+}
+
+// The address to connect to for the host:port a node reports
+func (p *ClusterPorts) Translate(addr string) string {
+	if p == nil {
+		return addr
+	}
+	if translated, exists := p.NodeAddresses[addr]; exists {
+		return translated
+	}
+	return addr
+}
+
+func (p *ClusterPorts) NetworkType() string {
+	if p == nil {
+		return ""
+	}
+	return p.Network
+}
+
+// Selects the network of alternate addresses in a connection string of the SDK, if one is given
+func (p *ClusterPorts) TagNetwork(connStr string) string {
+	if p.NetworkType() == "" {
+		return connStr
+	}
+	separator := "?"
+	if strings.Contains(connStr, "?") {
+		separator = "&"
+	}
+	return fmt.Sprintf("%v%vnetwork=%v", connStr, separator, p.Network)
+}
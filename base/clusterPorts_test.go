@@ -0,0 +1,48 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterPorts(t *testing.T) {
+	fmt.Println("============== Test case start: TestClusterPorts =================")
+	assert := assert.New(t)
+
+	var nilPorts *ClusterPorts
+	assert.Equal("http://cb1", nilPorts.Url("http://cb1"))
+	assert.Equal(uint16(0), nilPorts.KvPort(true))
+	assert.Equal("cb1:11210", nilPorts.Translate("cb1:11210"))
+	assert.Equal("couchbase://cb1", nilPorts.TagNetwork("couchbase://cb1"))
+
+	ports, err := ParseClusterPorts("mgmt=18091, kv=21210,kvTls=21207,network=external,10.0.0.5:11210=proxy:31210")
+	assert.Nil(err)
+	assert.Equal("http://cb1:18091", ports.Url("http://cb1"))
+	assert.Equal("cb1:18091", ports.Url("cb1"))
+	assert.Equal("[::1]:18091", ports.Url("::1"))
+	// A port given in the URL is kept
+	assert.Equal("https://cb1:9000", ports.Url("https://cb1:9000"))
+	assert.Equal(uint16(21210), ports.KvPort(false))
+	assert.Equal(uint16(21207), ports.KvPort(true))
+	assert.Equal("proxy:31210", ports.Translate("10.0.0.5:11210"))
+	assert.Equal("10.0.0.6:11210", ports.Translate("10.0.0.6:11210"))
+	assert.Equal("couchbase://cb1?network=external", ports.TagNetwork("couchbase://cb1"))
+	assert.Equal("couchbase://cb1?a=b&network=external", ports.TagNetwork("couchbase://cb1?a=b"))
+
+	for _, spec := range []string{"kv", "kv=0", "kv=70000", "mgmt=x", "10.0.0.5=proxy:31210", "cpu=80"} {
+		_, err = ParseClusterPorts(spec)
+		assert.NotNil(err)
+	}
+	fmt.Println("============== Test case end: TestClusterPorts =================")
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A collection by name, i.e. inventory.airline
+type CollectionName struct {
+	ScopeName      string
+	CollectionName string
+}
+
+func (n CollectionName) String() string {
+	return n.ScopeName + ScopeCollectionDelimiter + n.CollectionName
+}
+
+func parseCollectionName(name string) (CollectionName, error) {
+	parts := strings.Split(strings.TrimSpace(name), ScopeCollectionDelimiter)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return CollectionName{}, fmt.Errorf("Invalid collection %q. Expected scope.collection", name)
+	}
+	return CollectionName{ScopeName: parts[0], CollectionName: parts[1]}, nil
+}
+
+// Source collection -> the target collection it is compared with
+type CollectionMapping map[CollectionName]CollectionName
+
+// Parses comma separated source:target pairs, i.e. "inventory.airline:inventory.airlineCopy,_default._default:backup.docs"
+func ParseCollectionMapping(spec string) (CollectionMapping, error) {
+	mapping := make(CollectionMapping)
+	for _, pair := range strings.Split(spec, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.Split(pair, CollectionMappingDelimiter)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid collection mapping %v. Expected sourceScope.sourceCollection%vtargetScope.targetCollection", pair, CollectionMappingDelimiter)
+		}
+		source, err := parseCollectionName(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		target, err := parseCollectionName(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := mapping[source]; exists {
+			return nil, fmt.Errorf("Source collection %v is mapped more than once", source)
+		}
+		mapping[source] = target
+	}
+	if len(mapping) == 0 {
+		return nil, fmt.Errorf("No collections given in %v", spec)
+	}
+	return mapping, nil
+}
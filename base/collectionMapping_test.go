@@ -0,0 +1,43 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCollectionMapping(t *testing.T) {
+	fmt.Println("============== Test case start: TestParseCollectionMapping =================")
+	assert := assert.New(t)
+
+	mapping, err := ParseCollectionMapping("inventory.airline:inventory.airlineCopy, _default._default:backup.docs")
+	assert.Nil(err)
+	assert.Equal(CollectionMapping{
+		{"inventory", "airline"}: {"inventory", "airlineCopy"},
+		{"_default", "_default"}: {"backup", "docs"},
+	}, mapping)
+	assert.Equal("inventory.airlineCopy", mapping[CollectionName{"inventory", "airline"}].String())
+
+	for _, invalid := range []string{
+		"",
+		"inventory.airline",
+		"inventory:backup.docs",
+		"inventory.airline:backup.docs:other.docs",
+		"inventory.airline.x:backup.docs",
+		"inventory.airline:backup.docs,inventory.airline:backup.other",
+	} {
+		_, err = ParseCollectionMapping(invalid)
+		assert.NotNil(err)
+	}
+	fmt.Println("============== Test case end: TestParseCollectionMapping =================")
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Replications that log conflicts write a record of each to a collection of the source cluster. Documents that the
+// differ finds to differ, and that have such a record, differ as a conflict the replication knows of
+
+// Field of a conflict record that holds the key of the document in conflict, by default
+const DefaultConflictLogKeyField = "docId"
+
+// N1QL statement returning the keys of the documents that have a conflict record in the given bucket.scope.collection,
+// held by the given field of the records, which can be a path, i.e. source.id
+func ConflictLogStatement(collection, keyField string) (string, error) {
+	parts := strings.Split(strings.TrimSpace(collection), ScopeCollectionDelimiter)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", fmt.Errorf("Invalid conflict log collection %q. Expected bucket.scope.collection", collection)
+	}
+	fields := strings.Split(keyField, ".")
+	for _, field := range fields {
+		if field == "" {
+			return "", fmt.Errorf("Invalid conflict log key field %q", keyField)
+		}
+	}
+	// Escaped, as names can have characters N1QL identifiers cannot
+	for _, name := range append(parts, fields...) {
+		if strings.Contains(name, "`") {
+			return "", fmt.Errorf("Invalid conflict log name %q", name)
+		}
+	}
+	keyPath := "c.`" + strings.Join(fields, "`.`") + "`"
+	return fmt.Sprintf("SELECT DISTINCT RAW %v FROM `%v`.`%v`.`%v` AS c WHERE %v IS STRING", keyPath, parts[0], parts[1],
+		parts[2], keyPath), nil
+}
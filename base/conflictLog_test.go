@@ -0,0 +1,38 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflictLogStatement(t *testing.T) {
+	fmt.Println("============== Test case start: TestConflictLogStatement =================")
+	assert := assert.New(t)
+
+	statement, err := ConflictLogStatement("conflicts.xdcr.log", DefaultConflictLogKeyField)
+	assert.Nil(err)
+	assert.Equal("SELECT DISTINCT RAW c.`docId` FROM `conflicts`.`xdcr`.`log` AS c WHERE c.`docId` IS STRING", statement)
+
+	statement, err = ConflictLogStatement("conflicts._default._default", "source.id")
+	assert.Nil(err)
+	assert.Equal("SELECT DISTINCT RAW c.`source`.`id` FROM `conflicts`.`_default`.`_default` AS c WHERE c.`source`.`id` IS STRING", statement)
+
+	for _, collection := range []string{"conflicts", "xdcr.log", "conflicts..log", "con`flicts.xdcr.log"} {
+		_, err = ConflictLogStatement(collection, DefaultConflictLogKeyField)
+		assert.NotNil(err)
+	}
+	_, err = ConflictLogStatement("conflicts.xdcr.log", "source.")
+	assert.NotNil(err)
+	fmt.Println("============== Test case end: TestConflictLogStatement =================")
+}
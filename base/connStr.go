@@ -0,0 +1,168 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options of a connection string of the SDK that are applied to every connection to the cluster
+const (
+	ConnStrKvTimeout   = "kv_timeout"
+	ConnStrNetwork     = "network"
+	ConnStrBootstrapOn = "bootstrap_on"
+)
+
+var connStrBootstrapOn = map[string]bool{"cccp": true, "http": true, "both": true}
+
+func IsConnStr(url string) bool {
+	return strings.HasPrefix(url, CouchbasePrefix) || strings.HasPrefix(url, CouchbaseSecurePrefix)
+}
+
+// Parses a connection string of the SDK, i.e. couchbase://cb1,cb2:21210?network=external&kv_timeout=5s, into the
+// URL of the REST endpoint of its first node, and ports, which are those given merged with what the connection
+// string implies. A port of a node is the KV port, over TLS for couchbases://
+func ParseConnStr(connStr string, ports *ClusterPorts) (string, *ClusterPorts, error) {
+	secure := strings.HasPrefix(connStr, CouchbaseSecurePrefix)
+	rest := strings.TrimPrefix(strings.TrimPrefix(connStr, CouchbaseSecurePrefix), CouchbasePrefix)
+	hostList, query := rest, ""
+	if i := strings.Index(rest, "?"); i >= 0 {
+		hostList, query = rest[:i], rest[i+1:]
+	}
+
+	merged := &ClusterPorts{NodeAddresses: make(map[string]string)}
+	if ports != nil {
+		*merged = *ports
+	}
+	var firstHost string
+	var kvPort uint16
+	for _, host := range strings.Split(hostList, ",") {
+		if host = strings.TrimSpace(host); host == "" {
+			continue
+		}
+		hostName, port := host, ""
+		if h, p, err := net.SplitHostPort(host); err == nil {
+			hostName, port = h, p
+		}
+		if port != "" {
+			parsed, err := strconv.ParseUint(port, 10, 16)
+			if err != nil || parsed == 0 {
+				return "", nil, fmt.Errorf("Invalid port of %v in %v", host, connStr)
+			}
+			if kvPort != 0 && uint16(parsed) != kvPort {
+				return "", nil, fmt.Errorf("The nodes of %v have different KV ports, which is not supported", connStr)
+			}
+			kvPort = uint16(parsed)
+		}
+		if firstHost == "" {
+			firstHost = hostName
+		}
+	}
+	if firstHost == "" {
+		return "", nil, fmt.Errorf("No node in %v", connStr)
+	}
+	if kvPort != 0 {
+		if secure {
+			merged.KvTls = kvPort
+		} else {
+			merged.Kv = kvPort
+		}
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return "", nil, fmt.Errorf("Invalid options of %v: %v", connStr, err)
+	}
+	for name := range values {
+		value := values.Get(name)
+		switch name {
+		case ConnStrKvTimeout:
+			timeout, err := parseConnStrDuration(value)
+			if err != nil {
+				return "", nil, fmt.Errorf("Invalid %v %v: %v", ConnStrKvTimeout, value, err)
+			}
+			merged.KvTimeout = timeout
+		case ConnStrNetwork:
+			if merged.Network != "" && merged.Network != value {
+				return "", nil, fmt.Errorf("%v %v of %v differs from the network of the ports, %v", ConnStrNetwork, value, connStr, merged.Network)
+			}
+			merged.Network = value
+		case ConnStrBootstrapOn:
+			if !connStrBootstrapOn[value] {
+				return "", nil, fmt.Errorf("Invalid %v %v. Accepted values are cccp, http and both", ConnStrBootstrapOn, value)
+			}
+			merged.BootstrapOn = value
+		default:
+			return "", nil, fmt.Errorf("Unsupported option %v of %v. Accepted options are %v, %v and %v", name, connStr,
+				ConnStrKvTimeout, ConnStrNetwork, ConnStrBootstrapOn)
+		}
+	}
+	return merged.Url(firstHost), merged, nil
+}
+
+// As the SDK takes them: a duration such as 2500ms, or a number of milliseconds
+func parseConnStrDuration(value string) (time.Duration, error) {
+	if millis, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return time.Duration(millis) * time.Millisecond, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("has to be positive")
+	}
+	return duration, nil
+}
+
+// Adds the options of the ports to a connection string of gocb, but for those it already has
+func (p *ClusterPorts) TagOptions(connStr string) string {
+	return p.tagOptions(connStr, true)
+}
+
+// Same as TagOptions, for the agents of gocbcore, which do not take kv_timeout, as each of their operations has a
+// deadline of its own
+func (p *ClusterPorts) TagAgentOptions(connStr string) string {
+	return p.tagOptions(connStr, false)
+}
+
+func (p *ClusterPorts) tagOptions(connStr string, withKvTimeout bool) string {
+	connStr = p.TagNetwork(connStr)
+	if p == nil {
+		return connStr
+	}
+	options := [][2]string{{ConnStrBootstrapOn, p.BootstrapOn}}
+	if withKvTimeout {
+		options = append(options, [2]string{ConnStrKvTimeout, durationMillis(p.KvTimeout)})
+	}
+	for _, option := range options {
+		if option[1] == "" || strings.Contains(connStr, option[0]+"=") {
+			continue
+		}
+		separator := "?"
+		if strings.Contains(connStr, "?") {
+			separator = "&"
+		}
+		connStr = fmt.Sprintf("%v%v%v=%v", connStr, separator, option[0], option[1])
+	}
+	return connStr
+}
+
+func durationMillis(duration time.Duration) string {
+	if duration == 0 {
+		return ""
+	}
+	return strconv.FormatInt(duration.Milliseconds(), 10)
+}
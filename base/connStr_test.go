@@ -0,0 +1,58 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConnStr(t *testing.T) {
+	fmt.Println("============== Test case start: TestParseConnStr =================")
+	assert := assert.New(t)
+
+	assert.False(IsConnStr("http://cb1:8091"))
+	assert.True(IsConnStr("couchbases://cb1"))
+
+	restUrl, ports, err := ParseConnStr("couchbase://cb1,cb2?network=external&kv_timeout=2500&bootstrap_on=http", nil)
+	assert.Nil(err)
+	assert.Equal("cb1", restUrl)
+	assert.Equal(uint16(0), ports.KvPort(false))
+	assert.Equal("external", ports.NetworkType())
+	assert.Equal(2500*time.Millisecond, ports.KvTimeout)
+	assert.Equal("couchbase://cb1?network=external&bootstrap_on=http&kv_timeout=2500", ports.TagOptions("couchbase://cb1"))
+	// An option already given is kept, and gocbcore is not given kv_timeout
+	assert.Equal("couchbase://cb1:21210?bootstrap_on=cccp&network=external", ports.TagAgentOptions("couchbase://cb1:21210?bootstrap_on=cccp"))
+
+	// The ports given separately are merged with those of the connection string
+	given, err := ParseClusterPorts("mgmt=18091,10.0.0.5:11210=proxy:31210")
+	assert.Nil(err)
+	restUrl, ports, err = ParseConnStr("couchbases://[::1]:21207?kv_timeout=5s", given)
+	assert.Nil(err)
+	assert.Equal("[::1]:18091", restUrl)
+	assert.Equal(uint16(21207), ports.KvPort(true))
+	assert.Equal(5*time.Second, ports.KvTimeout)
+	assert.Equal("proxy:31210", ports.Translate("10.0.0.5:11210"))
+	assert.Equal(time.Duration(0), given.KvTimeout)
+
+	networkPorts, err := ParseClusterPorts("network=default")
+	assert.Nil(err)
+	_, _, err = ParseConnStr("couchbase://cb1?network=external", networkPorts)
+	assert.NotNil(err)
+	for _, connStr := range []string{"couchbase://", "couchbase://cb1:11210,cb2:21210", "couchbase://cb1:x",
+		"couchbase://cb1?kv_timeout=-1s", "couchbase://cb1?bootstrap_on=gcccp", "couchbase://cb1?query_timeout=5s"} {
+		_, _, err = ParseConnStr(connStr, nil)
+		assert.NotNil(err, connStr)
+	}
+	fmt.Println("============== Test case end: TestParseConnStr =================")
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"time"
+
+	"github.com/couchbase/gocbcore/v10"
+)
+
+// Runs that take many hours have their connections dropped, i.e. by firewalls that time out idle ones. The KV
+// agents are pinged every so often to keep them busy, and DCP streams whose connection drops are opened again
+// from where they left off
+
+// Times in a row a DCP stream whose connection dropped is opened again before the run fails, set once the options
+// are parsed. 0 fails the run as soon as a connection drops
+var MaxDcpReconnects int
+
+const DefaultMaxDcpReconnects = 5
+
+// How often the KV agents are pinged by default, which is well within the idle timeouts firewalls usually have
+const DefaultKeepAliveSecs = 120
+
+// Wait before opening a dropped DCP stream again, doubled with every attempt up to DcpReconnectMaxBackoff
+const DcpReconnectInitialWait = 1 * time.Second
+const DcpReconnectMaxBackoff = 30 * time.Second
+
+// How long a ping of the KV agents is waited on to keep their connections alive
+const KeepAlivePingTimeout = 10 * time.Second
+
+// Whether the given attempt at opening a dropped DCP stream again is to be made
+func DcpReconnects(attempt int) bool {
+	return attempt <= MaxDcpReconnects
+}
+
+func DcpReconnectBackoff(attempt int) time.Duration {
+	backoff := DcpReconnectInitialWait
+	for i := 1; i < attempt && backoff < DcpReconnectMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > DcpReconnectMaxBackoff {
+		backoff = DcpReconnectMaxBackoff
+	}
+	return backoff
+}
+
+// Errors of a connection that dropped, or of a stream that the server ended as its connection did, rather than of
+// the operation or stream itself. What was in flight on the connection can be replayed on a new one
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, connectionErr := range []error{gocbcore.ErrSocketClosed, gocbcore.ErrDCPStreamDisconnected,
+		gocbcore.ErrDCPStreamTooSlow, ErrInjectedDcpDisconnect, io.EOF, io.ErrUnexpectedEOF, syscall.ECONNRESET,
+		syscall.ECONNABORTED, syscall.EPIPE, syscall.ETIMEDOUT} {
+		if errors.Is(err, connectionErr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+
+	"github.com/couchbase/gocbcore/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDcpReconnects(t *testing.T) {
+	fmt.Println("============== Test case start: TestDcpReconnects =================")
+	assert := assert.New(t)
+	defer func(maxDcpReconnects int) { MaxDcpReconnects = maxDcpReconnects }(MaxDcpReconnects)
+
+	MaxDcpReconnects = 0
+	assert.False(DcpReconnects(1))
+	MaxDcpReconnects = 3
+	assert.True(DcpReconnects(3))
+	assert.False(DcpReconnects(4))
+
+	assert.Equal(DcpReconnectInitialWait, DcpReconnectBackoff(1))
+	assert.Equal(4*DcpReconnectInitialWait, DcpReconnectBackoff(3))
+	assert.Equal(DcpReconnectMaxBackoff, DcpReconnectBackoff(100))
+	fmt.Println("============== Test case end: TestDcpReconnects =================")
+}
+
+func TestIsConnectionError(t *testing.T) {
+	fmt.Println("============== Test case start: TestIsConnectionError =================")
+	assert := assert.New(t)
+
+	assert.True(IsConnectionError(gocbcore.ErrSocketClosed))
+	assert.True(IsConnectionError(fmt.Errorf("stream ended: %w", gocbcore.ErrDCPStreamDisconnected)))
+	assert.True(IsConnectionError(ErrInjectedDcpDisconnect))
+	assert.True(IsConnectionError(fmt.Errorf("read: %w", syscall.ECONNRESET)))
+	assert.False(IsConnectionError(nil))
+	assert.False(IsConnectionError(gocbcore.ErrDCPStreamClosed))
+	assert.False(IsConnectionError(errors.New("document not found")))
+	fmt.Println("============== Test case end: TestIsConnectionError =================")
+}
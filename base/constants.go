@@ -15,28 +15,68 @@ const FileNamePrefix = "diffTool"
 const FileNameDelimiter = "_"
 const FileDirDelimiter = "/"
 const BucketBufferCapacity = 100000
+
+// Buffers of written capture files kept for reuse, and filled buffers that can be waiting to be written
+// before DCP handlers stop consuming mutations
+const CaptureBufferPoolSize = 64
+const CaptureBufferHighWatermark = 256
 const FileModeReadWrite = 0666
 const StreamingBucketName = "xdcrDiffTool"
 const VbucketSeqnoStatName = "vbucket-seqno"
 const VbucketHighSeqnoStatsKey = "vb_%v:high_seqno"
 const VbucketUuidStatsKey = "vb_%v:uuid"
+
+// Seqno up to which a vbucket is persisted, in the same stats as its high seqno. Not reported by ephemeral buckets
+const VbucketPersistedSeqnoStatsKey = "vb_%v:last_persisted_seqno"
+
+// How often, in milliseconds, vbuckets are checked for having persisted up to their high seqnos when waiting for them
+const PersistenceWaitInterval = 500
+
+// How often, in milliseconds, memory is sampled for the resource usage of each phase
+const ResourceSampleInterval = 1000
+const VbucketDetailsStatName = "vbucket-details"
+const VbucketMaxCasStatSuffix = ":max_cas"
+const VbucketDriftAheadStatSuffix = ":drift_ahead_threshold_exceeded"
+const VbucketDriftBehindStatSuffix = ":drift_behind_threshold_exceeded"
+
+// Current time of a KV node in seconds, in the general stats
+const TimeStatKey = "time"
 const SourceFileDir = "source"
 const TargetFileDir = "target"
 const CheckpointFileDir = "checkpoint"
 const FileDifferDir = "fileDiff"
 const MutationDifferDir = "mutationDiff"
+const CriticalKeysDir = "criticalKeys"
 const DiffKeysFileName = "diffKeys"
 const DiffDetailsFileName = "diffDetails"
 const DiffKeysSrcMigrationHintSuffix = "hint"
+const DiffKeySizesFileName = "diffKeySizes"
+
+// Keys the file differ found to differ by the hash of their bodies alone, which a metadata compare would not confirm
+const DiffKeysBodyHashFileName = "diffKeysBodyHash"
 const MutationDiffFileName = "mutationDiffDetails"
 const MutationDiffColIdMapping = "mutationDiffColIdMapping"
 const MutationDiffMigrationDetails = "mutationMigrationDetails"
+const MutationDiffAuditFileName = "mutationDiffAudit"
+const MutationDiffVerdictsFileName = "mutationDiffVerdicts"
+const MutationDiffSlowestKeysFileName = "mutationDiffSlowestKeys"
+const MutationDiffQuarantineFileName = "mutationDiffQuarantine"
+const MutationDiffChunkedDetailsFileName = "mutationDiffDetails.zst"
+const MutationDiffChunkedIndexFileName = "mutationDiffDetails.idx"
 const DiffErrorKeysFileName = "diffKeysWithError"
+const DiffErrorDetailsFileName = "diffKeysWithErrorDetails"
 const StatsReportInterval = 5
 const SourceClusterName = "source"
 const TargetClusterName = "target"
+
+// What the clusters are called in logs, stats, file names and reports, i.e. dc-east and dc-west
+// Set from the command line, and the same as the cluster names otherwise
+var SourceClusterLabel = SourceClusterName
+var TargetClusterLabel = TargetClusterName
+
 const SelfReferenceName = "xdcrDifftoolSelfRef"
 const ManifestFileName = "manifest"
+const MonitorEventsFileName = "monitorEvents"
 
 const NodesKey = "nodes"
 const PoolsDefaultBucketPath = "/pools/default/buckets/"
@@ -48,11 +88,46 @@ const BucketOpTimeout uint64 = 20
 const GetStatsRetryInterval uint64 = 2
 const GetStatsMaxBackoff uint64 = 10
 const SendBatchRetryInterval uint64 = 500
+
+// Requests each KV agent allows to be queued per key in a mutation differ batch, to give the SDK some breathing room
+const AgentQueueSizePerBatchKey = 50
 const SendBatchMaxBackoff uint64 = 5
 const GetStatsBackoffFactor = 2
 const SendBatchBackoffFactor = 2
 const MaxNumOfGetStatsRetry = 10
 const MaxNumOfSendBatchRetry = 10
+
+// How long past the deadline of its operations a mutation differ batch waits for the SDK to fail them
+const MutationDiffDeadlineGraceSecs = 5
+
+// Capture backends
+const (
+	CaptureBackendDcp       = "dcp"
+	CaptureBackendRangeScan = "rangeScan"
+)
+
+// Range scans cover every key from RangeScanMinKey to RangeScanMaxKey, the highest UTF-8 character
+const RangeScanMinKey = "\x00"
+const RangeScanMaxKey = "\xf4\x8f\xbf\xbf"
+
+// Documents returned by each continuation of a range scan
+const RangeScanBatchItems = 1000
+
+// Seed of sampling range scans, shared by both clusters
+const RangeScanSampleSeed = 0x5eed
+
+// Entries of the mutation differ output encoded at a time by each of its workers
+const MutationDiffOutputChunkEntries = 1000
+
+// Size of a record of the mutation differ output above which it is quarantined rather than written. Above both sides
+// of the largest document KV holds, base64 encoded
+const MutationDiffMaxRecordBytes = 64 * 1024 * 1024
+
+// Times the operations of a key are to exceed their deadline for it to be reported among the slowest keys
+const SlowKeyMinExceeded = 2
+
+// How many of the slowest keys are reported at the end of a run. All of them are in MutationDiffSlowestKeysFileName
+const SlowestKeysReported = 20
 const DelayBetweenSourceAndTarget uint64 = 2
 const CheckpointInterval = 600
 
@@ -83,8 +158,13 @@ const (
 	JsonBody     = "Body"
 	JsonMetadata = "Metadata"
 	Updated      = "Updated"
+	// In place of the body of a document above the size threshold. See differ.BodyChunks
+	JsonBodyChunks = "BodyChunks"
 )
 
+// Average size of the chunks the bodies of documents above the size threshold are split into, a power of 2
+const BodyChunkAverageSize = 8192
+
 // This function is used to calculate the length of the byte array for serializing a mutation
 // @param keyLen denotes the length of the document key
 // @param size denoted the length of HLV
@@ -117,6 +197,13 @@ const CouchbaseSecurePrefix = "couchbases://"
 var SetupTimeoutSeconds int = 10
 
 const JSONDataType = 1
+const SnappyDataType = 2
+
+// Difference, in percentage points, between the shares of documents of a kind in the two buckets that is reported as a skew
+const DistributionSkewPercent float64 = 5
+
+// Share of all divergences, in percent, that a window of mutation time has to hold to be reported as a hot window
+const HotWindowMinSharePercent float64 = 10
 
 const (
 	MutationCompareTypeMetadata    = "meta" // This is the default
@@ -126,4 +213,200 @@ const (
 
 var MutationDiffCompareType = []string{MutationCompareTypeMetadata, MutationCompareTypeBodyOnly, MutationCompareTypeBodyAndMeta}
 
+// Transaction metadata, which legitimately differs across clusters
+// Active transaction records and client records are documents of their own, while staged mutations are kept in xattrs
+var TxnMetadataKeyPrefixes = []string{"_txn:atr-", "_txn:client-record"}
+var TxnXattrKeys = []string{"txn", "_txn"}
+
+// Documents Sync Gateway keeps for its own bookkeeping, i.e. users, roles and sequence numbers
+const SyncGatewayKeyPrefix = "_sync:"
+
+// Internal bookkeeping of the cluster that is excluded from comparison by default
+// Collections under the system scope hold i.e. query and mobile metadata, while Eventing checkpoints
+// and timers are documents within its metadata collection, which can be any collection
+const SystemScopeName = "_system"
+const EventingMetadataKeyPrefix = "eventing::"
+
+// Separate the scope from the collection, and the source collection from the target one, in a collection mapping
+const ScopeCollectionDelimiter = "."
+const CollectionMappingDelimiter = ":"
+
+// Canary documents written to measure replication latency. They are never captured, so that they do not show up as differences
+const CanaryKeyPrefix = "_xdcrDifferCanary::"
+
+// Canary documents expire on their own, in seconds
+const CanaryExpirySeconds = 600
+
+// Categories of documents that exist on both sides but mismatch, as reported by the file differ
+const (
+	MismatchCategoryBodyDiffers     = "BodyDiffers"
+	MismatchCategoryXattrsDiffer    = "BodyEqualXattrsDiffer"
+	MismatchCategoryMetadataDiffers = "MetadataDiffers"
+	MismatchCategoryTTLDiffers      = "TTLDiffers"
+)
+
+// Documents that differ only as the replication is configured to make them differ, e.g. by TTLs it strips.
+// They are reported by both differs under this category, and are not counted as differences
+const ExpectedByConfigurationCategory = "ExpectedByConfiguration"
+
+// Documents that the mutation differ finds to differ, and that the replication logged a conflict of. They are
+// reported under this category instead of as mismatches, and are not counted as differences by run verdicts
+const KnownConflictCategory = "KnownConflict"
+
+// Documents that stayed locked on either cluster, every time the mutation differ fetched them. They could not be
+// compared, so they are reported under this category rather than as differences or errors
+const LockedCategory = "Locked"
+
+// Documents missing from one cluster whose tombstone on the other is older than the metadata purge interval of the
+// first, which may have purged its own tombstone. They could not be verified, and are not counted as differences
+const PurgedCategory = "UnverifiablePurged"
+
+// How many seconds the mutation differ waits for the locks of documents to expire before fetching them again, which is
+// the default lock time, and how many times. Locks last at most twice as long
+const LockedKeyRetryWaitSecs = 15
+const LockedKeyRetries = 2
+
 const Uint32MaxVal uint32 = 1<<32 - 1
+
+// Throttling on the health of the clusters. See HealthThrottler
+const HealthThrottleMinShare = 0.125
+const HealthThrottlePausePolls = 3
+const HealthThrottleRecoverPolls = 2
+
+// Circuit breaking of the operations of the mutation differ on a cluster. See CircuitBreaker
+// Number of the latest operations whose share of errors trips the breaker, and how many have to be counted first
+const CircuitBreakerWindow = 1000
+const CircuitBreakerMinOperations = 100
+
+// Retries allowed on top of the retry budget, so that the first batches to fail can be retried whatever the budget
+const RetryBudgetMinRetries = 100
+
+// What the run does once a circuit breaker trips
+const (
+	CircuitBreakerPause = "pause"
+	CircuitBreakerAbort = "abort"
+)
+
+// Auto tuning of worker counts and mutation differ concurrency
+// Target share of KV capacity, in percent, that the mutation differ is allowed to consume
+const AutoTuneTargetImpact float64 = 10
+
+// How often the mutation differ concurrency is re-evaluated, in seconds
+const AutoTuneAdjustInterval = 5
+
+// DCP flow control, when not specified, is sized from the bucket
+// The buffer of each DCP connection is this fraction of the bucket RAM quota per node, within the bounds below
+const DcpBufferSizeQuotaDivisor = 256
+const DcpMinBufferSize = 1024 * 1024
+const DcpMaxBufferSize = 64 * 1024 * 1024
+
+// A DCP buffer size that turns flow control off, so that nodes send without waiting for acknowledgements
+const DcpFlowControlOff = -1
+
+// One more DCP connection per node for every so many items, up to DcpMaxConnectionsPerNode
+const DcpItemsPerConnection = 50000000
+const DcpMaxConnectionsPerNode = 4
+
+// Bucket info keys used to size DCP flow control
+const (
+	BucketQuotaKey       = "quota"
+	BucketRawRAMQuotaKey = "rawRAM"
+	BucketBasicStatsKey  = "basicStats"
+	BucketItemCountKey   = "itemCount"
+	BucketDataUsedKey    = "dataUsed"
+	BucketOpsPerSecKey   = "opsPerSec"
+)
+
+// Keys of the vbucket server map of a bucket, under bucket info
+const (
+	VbucketServerMapKey = "vBucketServerMap"
+	ServerListKey       = "serverList"
+	VbucketMapKey       = "vBucketMap"
+)
+
+// Bucket info key of how the bucket compresses the documents it holds, one of the modes below. Passive buckets keep
+// documents compressed as they are written compressed, i.e. by XDCR, and active buckets also compress them on their own
+const BucketCompressionModeKey = "compressionMode"
+
+const (
+	CompressionModeOff     = "off"
+	CompressionModePassive = "passive"
+	CompressionModeActive  = "active"
+)
+
+// Path under a bucket of its collections manifest, including the settings of each collection
+const BucketScopesPath = "/scopes"
+
+// Bucket info key, and key of the auto-compaction settings of the cluster that buckets default to, of the days after
+// which tombstones are purged, i.e. 3 or 0.04 for an hour
+const BucketPurgeIntervalKey = "purgeInterval"
+const AutoCompactionSettingsPath = "/settings/autoCompaction"
+
+// Path under a bucket of its stats, i.e. its disk write queue and resident ratio
+const BucketStatsPath = "/stats"
+
+// Cluster paths of the tasks, which include the status and errors of each replication, and of the log, which has
+// when replications were paused
+const (
+	TasksPath = "/pools/default/tasks"
+	LogsPath  = "/logs"
+)
+
+// Types of OSO (Out of Sequence Order) snapshot markers
+const (
+	OSOSnapshotStart uint32 = 0x1
+	OSOSnapshotEnd   uint32 = 0x2
+)
+
+// Maximum number of paths of a single sub-document lookup
+const MaxSubdocPaths = 16
+
+// Maximum length of a document key accepted by KV, in bytes
+const MaxKeyLength = 250
+
+// Number of offending entries printed when validating an input diff keys file
+const DiffKeysMaxReportedEntries = 10
+
+// Alternative sources of the keys to be verified by the mutation differ
+// Reads the keys from standard input
+const DiffKeysSourceStdin = "-"
+
+// Prefix of a N1QL statement, each row of its result being a key or an object with an "id" field
+const DiffKeysSourceN1QLPrefix = "n1ql:"
+
+// Cluster whose documents are taken to be right when writing the reconciliation scripts, the other being reconciled
+// to it
+const (
+	ReconcileWinnerSource = "source"
+	ReconcileWinnerTarget = "target"
+)
+
+// Written under the mutation differ directory, when a winning cluster is given
+const ReconcileDir = "reconcile"
+const ReconcileDeleteFileName = "deleteOrphans.n1ql"
+const ReconcileReplicateKeysFileName = "replicateKeys"
+const ReconcileImportFileName = "import.jsonl"
+const ReconcileScriptFileName = "reconcile.sh"
+const ReconcileRepairsFileName = "repairs"
+
+// What a repair does to the document on the losing cluster
+const (
+	RepairActionDelete = "delete"
+	RepairActionWrite  = "write"
+)
+
+// Written under the mutation differ directory when repairs are verified
+const RepairVerificationFileName = "repairVerification"
+
+// Seconds to wait, once the repairs are done, before they are verified
+const RepairSettleSecs = 30
+
+// Served by a cluster with its UUID, which reconcile.sh checks before making any change
+const PoolsPath = "/pools"
+
+// Fields of each document of the import dataset that cbimport takes its key and collection from, and leaves out
+const (
+	ReconcileImportKeyField        = "xdcrDifferKey"
+	ReconcileImportScopeField      = "xdcrDifferScope"
+	ReconcileImportCollectionField = "xdcrDifferCollection"
+)
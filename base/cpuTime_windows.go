@@ -0,0 +1,32 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"syscall"
+	"time"
+)
+
+func ProcessCPUTime() (time.Duration, error) {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return 0, err
+	}
+	var creation, exit, kernel, user syscall.Filetime
+	if err = syscall.GetProcessTimes(process, &creation, &exit, &kernel, &user); err != nil {
+		return 0, err
+	}
+	// Filetimes count intervals of 100 nanoseconds
+	return time.Duration(filetimeTicks(kernel)+filetimeTicks(user)) * 100, nil
+}
+
+func filetimeTicks(filetime syscall.Filetime) int64 {
+	return int64(filetime.HighDateTime)<<32 | int64(filetime.LowDateTime)
+}
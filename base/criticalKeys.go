@@ -0,0 +1,81 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// An entry of a critical keys file ending with this is a key prefix rather than a key
+const CriticalKeyPrefixWildcard = "*"
+
+// Documents verified ahead of the rest of the run and once more at its end
+type CriticalKeys struct {
+	Keys     []string
+	Prefixes []string
+	keySet   map[string]bool
+}
+
+// Parses a critical keys file, one key per line. Lines ending with CriticalKeyPrefixWildcard are prefixes, and
+// blank lines and lines starting with # are skipped. Keys encoded by EncodeKey are decoded
+func ParseCriticalKeys(data []byte) (*CriticalKeys, error) {
+	critical := &CriticalKeys{keySet: make(map[string]bool)}
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, MaxKeyLength), 4*MaxKeyLength)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || seen[line] {
+			continue
+		}
+		seen[line] = true
+		isPrefix := strings.HasSuffix(line, CriticalKeyPrefixWildcard)
+		key, err := DecodeKey(strings.TrimSuffix(line, CriticalKeyPrefixWildcard))
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", lineNo, err)
+		}
+		if key == "" {
+			// A prefix of nothing would make every document critical
+			return nil, fmt.Errorf("line %v: empty prefix", lineNo)
+		}
+		if len(key) > MaxKeyLength {
+			return nil, fmt.Errorf("line %v: key is longer than %v bytes", lineNo, MaxKeyLength)
+		}
+		if isPrefix {
+			critical.Prefixes = append(critical.Prefixes, key)
+		} else {
+			critical.Keys = append(critical.Keys, key)
+			critical.keySet[key] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(critical.Keys) == 0 && len(critical.Prefixes) == 0 {
+		return nil, fmt.Errorf("no critical keys given")
+	}
+	return critical, nil
+}
+
+// Whether the key is one of the critical keys or starts with one of the prefixes
+func (c *CriticalKeys) Matches(key string) bool {
+	if c.keySet[key] {
+		return true
+	}
+	for _, prefix := range c.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCriticalKeys(t *testing.T) {
+	fmt.Println("============== Test case start: TestParseCriticalKeys =================")
+	assert := assert.New(t)
+
+	encoded, _ := EncodeKey("order::\xff")
+	critical, err := ParseCriticalKeys([]byte("# payments\ncustomer::1\n\n  account::*  \ncustomer::1\n" + encoded + "\n"))
+	assert.Nil(err)
+	assert.Equal([]string{"customer::1", "order::\xff"}, critical.Keys)
+	assert.Equal([]string{"account::"}, critical.Prefixes)
+	assert.True(critical.Matches("customer::1"))
+	assert.True(critical.Matches("order::\xff"))
+	assert.True(critical.Matches("account::42"))
+	assert.False(critical.Matches("customer::10"))
+	assert.False(critical.Matches("account:"))
+
+	_, err = ParseCriticalKeys([]byte("# nothing\n\n"))
+	assert.NotNil(err)
+	_, err = ParseCriticalKeys([]byte("*\n"))
+	assert.NotNil(err)
+	_, err = ParseCriticalKeys([]byte(strings.Repeat("k", MaxKeyLength+1)))
+	assert.NotNil(err)
+	fmt.Println("============== Test case end: TestParseCriticalKeys =================")
+}
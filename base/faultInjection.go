@@ -0,0 +1,141 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Faults that can be injected to validate how a run copes with them, i.e. in CI
+const (
+	// KV operations of the mutation differ time out
+	FaultKvTimeout = "kvTimeout"
+	// KV operations of the mutation differ are answered with not my vbucket
+	FaultNotMyVbucket = "notMyVbucket"
+	// DCP streams end with an error, as when the connection is dropped
+	FaultDcpDisconnect = "dcpDisconnect"
+	// Writes to capture files only write part of the data
+	FaultPartialWrite = "partialWrite"
+)
+
+var FaultNames = []string{FaultKvTimeout, FaultNotMyVbucket, FaultDcpDisconnect, FaultPartialWrite}
+
+// Environment variable the faults can be given in, in the same format as the command line option
+const FaultInjectionEnvVar = "XDCRDIFFER_INJECT_FAULTS"
+
+var ErrInjectedDcpDisconnect = errors.New("injected fault: DCP connection dropped")
+
+// Faults injected into this run, if any
+var Faults *FaultInjector
+
+// Injects faults at the given probabilities. A nil injector never injects any
+type FaultInjector struct {
+	// fault -> probability, between 0 and 1
+	probabilities map[string]float64
+	mtx           sync.Mutex
+	random        *rand.Rand
+	// fault -> number of times injected
+	injected map[string]*int64
+}
+
+func NewFaultInjector(probabilities map[string]float64, seed int64) *FaultInjector {
+	injector := &FaultInjector{
+		probabilities: probabilities,
+		random:        rand.New(rand.NewSource(seed)),
+		injected:      make(map[string]*int64),
+	}
+	for fault := range probabilities {
+		injector.injected[fault] = new(int64)
+	}
+	return injector
+}
+
+// Parses faults given as a comma separated list of fault=probability, i.e. kvTimeout=0.01,dcpDisconnect=0.001
+func ParseFaults(spec string) (*FaultInjector, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	probabilities := make(map[string]float64)
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%q is not of the form fault=probability", entry)
+		}
+		fault := strings.TrimSpace(parts[0])
+		if !isKnownFault(fault) {
+			return nil, fmt.Errorf("unknown fault %q. Faults that can be injected are %v", fault, FaultNames)
+		}
+		probability, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || probability < 0 || probability > 1 {
+			return nil, fmt.Errorf("probability of %v has to be between 0 and 1, not %q", fault, parts[1])
+		}
+		probabilities[fault] = probability
+	}
+	return NewFaultInjector(probabilities, time.Now().UnixNano()), nil
+}
+
+func isKnownFault(fault string) bool {
+	for _, name := range FaultNames {
+		if name == fault {
+			return true
+		}
+	}
+	return false
+}
+
+// Decides whether the fault is to be injected this time
+func (f *FaultInjector) Inject(fault string) bool {
+	if f == nil {
+		return false
+	}
+	probability := f.probabilities[fault]
+	if probability <= 0 {
+		return false
+	}
+	f.mtx.Lock()
+	inject := f.random.Float64() < probability
+	f.mtx.Unlock()
+	if inject {
+		atomic.AddInt64(f.injected[fault], 1)
+	}
+	return inject
+}
+
+// Number of times each fault was injected so far
+func (f *FaultInjector) Injected() map[string]int64 {
+	injected := make(map[string]int64)
+	if f == nil {
+		return injected
+	}
+	for fault, count := range f.injected {
+		injected[fault] = atomic.LoadInt64(count)
+	}
+	return injected
+}
+
+func (f *FaultInjector) String() string {
+	if f == nil {
+		return ""
+	}
+	var entries []string
+	for fault, probability := range f.probabilities {
+		entries = append(entries, fmt.Sprintf("%v=%v", fault, probability))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFaults(t *testing.T) {
+	fmt.Println("============== Test case start: TestParseFaults =================")
+	assert := assert.New(t)
+
+	injector, err := ParseFaults("")
+	assert.Nil(err)
+	assert.Nil(injector)
+	assert.False(injector.Inject(FaultKvTimeout))
+
+	injector, err = ParseFaults("kvTimeout=0.5, partialWrite=1")
+	assert.Nil(err)
+	assert.Equal("kvTimeout=0.5,partialWrite=1", injector.String())
+
+	_, err = ParseFaults("kvTimeout")
+	assert.NotNil(err)
+	_, err = ParseFaults("diskFull=0.1")
+	assert.NotNil(err)
+	_, err = ParseFaults("kvTimeout=2")
+	assert.NotNil(err)
+
+	fmt.Println("============== Test case end: TestParseFaults =================")
+}
+
+func TestFaultInjectorInject(t *testing.T) {
+	fmt.Println("============== Test case start: TestFaultInjectorInject =================")
+	assert := assert.New(t)
+
+	injector := NewFaultInjector(map[string]float64{FaultPartialWrite: 1, FaultKvTimeout: 0.5, FaultNotMyVbucket: 0}, 1)
+	injectedTimeouts := 0
+	for i := 0; i < 1000; i++ {
+		assert.True(injector.Inject(FaultPartialWrite))
+		assert.False(injector.Inject(FaultNotMyVbucket))
+		assert.False(injector.Inject(FaultDcpDisconnect))
+		if injector.Inject(FaultKvTimeout) {
+			injectedTimeouts++
+		}
+	}
+	assert.True(injectedTimeouts > 400 && injectedTimeouts < 600)
+
+	injected := injector.Injected()
+	assert.Equal(int64(1000), injected[FaultPartialWrite])
+	assert.Equal(int64(injectedTimeouts), injected[FaultKvTimeout])
+	assert.Equal(int64(0), injected[FaultNotMyVbucket])
+
+	fmt.Println("============== Test case end: TestFaultInjectorInject =================")
+}
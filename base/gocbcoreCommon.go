@@ -15,6 +15,8 @@ type GocbcoreAgentCommon struct {
 	BucketName string
 
 	SetupTimeout time.Duration
+	// Network of alternate addresses to connect to the nodes over. The default network if empty
+	NetworkType string
 }
 
 type PasswordAuth struct {
@@ -0,0 +1,61 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"encoding/base64"
+	"strings"
+	"unicode/utf8"
+)
+
+// Document keys are arbitrary bytes, while JSON strings are UTF-8, and encoding/json replaces invalid bytes
+// with U+FFFD. Keys that are not valid UTF-8 are therefore written as KeyEncodingPrefix followed by their
+// base64 encoding. Valid keys that happen to start with the prefix are encoded too, so that decoding is unambiguous
+const KeyEncodingBase64 = "base64"
+const KeyEncodingPrefix = KeyEncodingBase64 + ":"
+
+// Returns the key as it is to be written to any output, and whether it had to be encoded
+func EncodeKey(key string) (string, bool) {
+	if utf8.ValidString(key) && !strings.HasPrefix(key, KeyEncodingPrefix) {
+		return key, false
+	}
+	return KeyEncodingPrefix + base64.StdEncoding.EncodeToString([]byte(key)), true
+}
+
+func EncodeKeys(keys []string) []string {
+	if keys == nil {
+		return nil
+	}
+	encoded := make([]string, len(keys))
+	for i, key := range keys {
+		encoded[i], _ = EncodeKey(key)
+	}
+	return encoded
+}
+
+// The encoding of a key as written, i.e. for the flag of a record that has one. Empty if the key is not encoded
+func KeyEncodingOf(writtenKey string) string {
+	if strings.HasPrefix(writtenKey, KeyEncodingPrefix) {
+		return KeyEncodingBase64
+	}
+	return ""
+}
+
+// Reverses EncodeKey. Keys without the prefix are returned as they are
+func DecodeKey(key string) (string, error) {
+	if !strings.HasPrefix(key, KeyEncodingPrefix) {
+		return key, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(key, KeyEncodingPrefix))
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
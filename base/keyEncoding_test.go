@@ -0,0 +1,42 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyEncodingRoundTrip(t *testing.T) {
+	fmt.Println("============== Test case start: TestKeyEncodingRoundTrip =================")
+	assert := assert.New(t)
+
+	for _, key := range []string{"user_1", "café", "bin\xff\xfe\x00key", KeyEncodingPrefix + "user_1"} {
+		encoded, wasEncoded := EncodeKey(key)
+		assert.Equal(key != "user_1" && key != "café", wasEncoded)
+
+		// The encoded key survives JSON unchanged
+		jsonBytes, err := json.Marshal(encoded)
+		assert.Nil(err)
+		var unmarshalled string
+		assert.Nil(json.Unmarshal(jsonBytes, &unmarshalled))
+
+		decoded, err := DecodeKey(unmarshalled)
+		assert.Nil(err)
+		assert.Equal(key, decoded)
+	}
+
+	_, err := DecodeKey(KeyEncodingPrefix + "!!")
+	assert.NotNil(err)
+	fmt.Println("============== Test case end: TestKeyEncodingRoundTrip =================")
+}
@@ -0,0 +1,21 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import "path/filepath"
+
+// Windows only supports paths longer than MAX_PATH when they are absolute, which the os package then prefixes with
+// \\?\, so directories are made absolute for output nested deep under them to be written
+func LongPathDir(dir string) (string, error) {
+	if dir == "" {
+		return dir, nil
+	}
+	return filepath.Abs(dir)
+}
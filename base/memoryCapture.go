@@ -0,0 +1,141 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Holds capture files in memory instead of on disk, by their cleaned names. Should the files held grow beyond the
+// limit, they are all written out to disk, and from then on capture files are written and read on disk as usual.
+// A nil capture holds nothing, as if it had spilled from the start
+type MemoryCapture struct {
+	mtx   sync.Mutex
+	limit int64
+	size  int64
+	// File name -> contents. Nil once spilled
+	files   map[string][]byte
+	spilled bool
+}
+
+// The capture of this run, if it is held in memory
+var CaptureMemory *MemoryCapture
+
+func NewMemoryCapture(limit int64) *MemoryCapture {
+	return &MemoryCapture{limit: limit, files: make(map[string][]byte)}
+}
+
+// Appends data to the file of the given name. Returns false without appending once the capture has spilled, in
+// which case the file on disk is to be written instead
+func (m *MemoryCapture) Append(fileName string, data []byte) (bool, error) {
+	if m == nil {
+		return false, nil
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.spilled {
+		return false, nil
+	}
+	if m.size+int64(len(data)) > m.limit {
+		if err := m.spillLocked(); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+	fileName = filepath.Clean(fileName)
+	m.files[fileName] = append(m.files[fileName], data...)
+	m.size += int64(len(data))
+	return true, nil
+}
+
+func (m *MemoryCapture) spillLocked() error {
+	for fileName, data := range m.files {
+		file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY|os.O_CREATE, FileModeReadWrite)
+		if err != nil {
+			return fmt.Errorf("Unable to spill capture file %v to disk: %v", fileName, err)
+		}
+		_, err = file.Write(data)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to spill capture file %v to disk: %v", fileName, err)
+		}
+	}
+	m.files = nil
+	m.spilled = true
+	return nil
+}
+
+// A read function over the file of the given name, as it is when opened. False if it is not held in memory
+func (m *MemoryCapture) Open(fileName string) (func([]byte) (int, error), bool) {
+	if m == nil {
+		return nil, false
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	data, ok := m.files[filepath.Clean(fileName)]
+	if !ok {
+		return nil, false
+	}
+	return bytes.NewReader(data).Read, true
+}
+
+func (m *MemoryCapture) Remove(fileName string) {
+	if m == nil {
+		return
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	fileName = filepath.Clean(fileName)
+	if data, ok := m.files[fileName]; ok {
+		m.size -= int64(len(data))
+		delete(m.files, fileName)
+	}
+}
+
+// Names of the files held in the given directory, sorted
+func (m *MemoryCapture) FileNames(dir string) []string {
+	if m == nil {
+		return nil
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	var fileNames []string
+	for fileName := range m.files {
+		if filepath.Dir(fileName) == filepath.Clean(dir) {
+			fileNames = append(fileNames, fileName)
+		}
+	}
+	sort.Strings(fileNames)
+	return fileNames
+}
+
+// Whether capture files are held in memory, i.e. it is set and has not spilled
+func (m *MemoryCapture) InMemory() bool {
+	if m == nil {
+		return false
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return !m.spilled
+}
+
+// Bytes held, or held when the capture spilled to disk, and whether it did
+func (m *MemoryCapture) Status() (int64, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.size, m.spilled
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryCaptureSpill(t *testing.T) {
+	fmt.Println("============== Test case start: TestMemoryCaptureSpill =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "memoryCapture")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	file1, file2 := filepath.Join(dir, "diffTool_0_0"), filepath.Join(dir, "diffTool_1_0")
+
+	capture := NewMemoryCapture(10)
+	inMemory, err := capture.Append(file1, []byte("abcd"))
+	assert.True(inMemory)
+	assert.Nil(err)
+	inMemory, _ = capture.Append(file1, []byte("ef"))
+	assert.True(inMemory)
+	inMemory, _ = capture.Append(file2, []byte("xyz"))
+	assert.True(inMemory)
+	assert.Equal([]string{file1, file2}, capture.FileNames(dir))
+	_, err = os.Stat(file1)
+	assert.True(os.IsNotExist(err))
+
+	read, ok := capture.Open(file1)
+	assert.True(ok)
+	data, err := ioutil.ReadAll(readFunc(read))
+	assert.Nil(err)
+	assert.Equal("abcdef", string(data))
+
+	// Beyond the limit, everything held is written out, and the caller writes what it appends itself
+	inMemory, err = capture.Append(file2, []byte("uvw"))
+	assert.False(inMemory)
+	assert.Nil(err)
+	assert.False(capture.InMemory())
+	_, ok = capture.Open(file1)
+	assert.False(ok)
+	data, err = ioutil.ReadFile(file1)
+	assert.Nil(err)
+	assert.Equal("abcdef", string(data))
+	data, err = ioutil.ReadFile(file2)
+	assert.Nil(err)
+	assert.Equal("xyz", string(data))
+	size, spilled := capture.Status()
+	assert.Equal(int64(9), size)
+	assert.True(spilled)
+
+	var unset *MemoryCapture
+	inMemory, err = unset.Append(file1, []byte("a"))
+	assert.False(inMemory)
+	assert.Nil(err)
+	assert.False(unset.InMemory())
+	fmt.Println("============== Test case end: TestMemoryCaptureSpill =================")
+}
+
+type readFunc func([]byte) (int, error)
+
+func (r readFunc) Read(p []byte) (int, error) {
+	return r(p)
+}
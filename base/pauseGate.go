@@ -0,0 +1,87 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"sync"
+	"time"
+)
+
+// Holds back the work of a run that puts load on the clusters while paused.
+// A nil gate is never paused
+type PauseGate struct {
+	mtx sync.Mutex
+	// Closed on resume. Nil while not paused
+	resumeCh  chan bool
+	pausedAt  time.Time
+	pausedFor time.Duration
+}
+
+func NewPauseGate() *PauseGate {
+	return &PauseGate{}
+}
+
+// Returns false if already paused
+func (g *PauseGate) Pause() bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if g.resumeCh != nil {
+		return false
+	}
+	g.resumeCh = make(chan bool)
+	g.pausedAt = time.Now()
+	return true
+}
+
+// Returns false if not paused
+func (g *PauseGate) Resume() bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if g.resumeCh == nil {
+		return false
+	}
+	close(g.resumeCh)
+	g.resumeCh = nil
+	g.pausedFor += time.Since(g.pausedAt)
+	return true
+}
+
+// Blocks while paused. Returns false if finCh was closed before resuming
+func (g *PauseGate) Wait(finCh chan bool) bool {
+	if g == nil {
+		return true
+	}
+	g.mtx.Lock()
+	resumeCh := g.resumeCh
+	g.mtx.Unlock()
+	if resumeCh == nil {
+		return true
+	}
+	select {
+	case <-resumeCh:
+		return true
+	case <-finCh:
+		return false
+	}
+}
+
+// Whether paused, and for how long in total, including the current pause
+func (g *PauseGate) Status() (paused bool, pausedFor time.Duration) {
+	if g == nil {
+		return false, 0
+	}
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	pausedFor = g.pausedFor
+	if g.resumeCh != nil {
+		pausedFor += time.Since(g.pausedAt)
+	}
+	return g.resumeCh != nil, pausedFor
+}
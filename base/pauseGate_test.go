@@ -0,0 +1,53 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPauseGate(t *testing.T) {
+	fmt.Println("============== Test case start: TestPauseGate =================")
+	assert := assert.New(t)
+
+	var nilGate *PauseGate
+	assert.True(nilGate.Wait(nil))
+
+	gate := NewPauseGate()
+	finCh := make(chan bool)
+	assert.True(gate.Wait(finCh))
+	assert.True(gate.Pause())
+	assert.False(gate.Pause())
+
+	resumed := make(chan bool)
+	go func() {
+		resumed <- gate.Wait(finCh)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	paused, pausedFor := gate.Status()
+	assert.True(paused)
+	assert.True(pausedFor >= 20*time.Millisecond)
+
+	assert.True(gate.Resume())
+	assert.False(gate.Resume())
+	assert.True(<-resumed)
+	paused, _ = gate.Status()
+	assert.False(paused)
+
+	// Stopping releases waiters that are still paused
+	assert.True(gate.Pause())
+	close(finCh)
+	assert.False(gate.Wait(finCh))
+	fmt.Println("============== Test case end: TestPauseGate =================")
+}
@@ -0,0 +1,245 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of progress events
+const (
+	ProgressEventPhase   = "phase"
+	ProgressEventVbucket = "vbucket"
+	ProgressEventBatch   = "batch"
+	ProgressEventError   = "error"
+)
+
+// States of a phase, as of its progress events
+const (
+	PhaseStarted = "started"
+	PhaseDone    = "done"
+	PhaseFailed  = "failed"
+)
+
+// Destinations of progress events other than files
+const (
+	ProgressEventsStdout   = "stdout"
+	ProgressEventsFdPrefix = "fd:"
+)
+
+// One line of the progress event stream. Only the fields of its type are set
+type ProgressEvent struct {
+	Time time.Time
+	Type string
+	// Of phase events
+	Phase string `json:",omitempty"`
+	State string `json:",omitempty"`
+	// Of vbucket events, the cluster and vbucket whose capture completed
+	Cluster string  `json:",omitempty"`
+	Vbucket *uint16 `json:",omitempty"`
+	// Of batch events, the keys of the mutation differ batch and how many times it was sent
+	Keys     int    `json:",omitempty"`
+	Attempts int    `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+// Writes progress events as JSON lines, for wrappers to follow the run without parsing its log. Every method can
+// be called on a nil *ProgressEvents, which writes nothing
+type ProgressEvents struct {
+	lock sync.Mutex
+	w    io.Writer
+	// Closed along with the events, unless the events go to stdout
+	file *os.File
+}
+
+// Emitted to by all modules of the tool. Nil unless progress events were asked for
+var Progress *ProgressEvents
+
+// Opens dest, which is ProgressEventsStdout, ProgressEventsFdPrefix followed by a file descriptor inherited from
+// the parent process, or the name of a file to append to
+func OpenProgressEvents(dest string) (*ProgressEvents, error) {
+	if dest == ProgressEventsStdout {
+		return &ProgressEvents{w: os.Stdout}, nil
+	}
+	var file *os.File
+	if strings.HasPrefix(dest, ProgressEventsFdPrefix) {
+		fd, err := strconv.Atoi(strings.TrimPrefix(dest, ProgressEventsFdPrefix))
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("invalid file descriptor in %v", dest)
+		}
+		if file = os.NewFile(uintptr(fd), dest); file == nil {
+			return nil, fmt.Errorf("file descriptor %v is not open", fd)
+		}
+	} else {
+		var err error
+		if file, err = os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_APPEND, FileModeReadWrite); err != nil {
+			return nil, err
+		}
+	}
+	return &ProgressEvents{w: file, file: file}, nil
+}
+
+// Each event is written in a single write, so that lines are never interleaved. Events that cannot be written are
+// dropped, as losing a reader is no reason to fail the run
+func (p *ProgressEvents) Emit(event *ProgressEvent) {
+	if p == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.w.Write(append(eventBytes, '\n'))
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func (p *ProgressEvents) Phase(phase, state string, err error) {
+	p.Emit(&ProgressEvent{Type: ProgressEventPhase, Phase: phase, State: state, Error: errorString(err)})
+}
+
+// Runs a phase between its started and its done or failed events
+func (p *ProgressEvents) RunPhase(phase string, run func() error) error {
+	p.Phase(phase, PhaseStarted, nil)
+	err := run()
+	if err != nil {
+		p.Phase(phase, PhaseFailed, err)
+	} else {
+		p.Phase(phase, PhaseDone, nil)
+	}
+	return err
+}
+
+func (p *ProgressEvents) Vbucket(cluster string, vbno uint16) {
+	p.Emit(&ProgressEvent{Type: ProgressEventVbucket, Cluster: cluster, Vbucket: &vbno})
+}
+
+func (p *ProgressEvents) Batch(keys, attempts int, err error) {
+	p.Emit(&ProgressEvent{Type: ProgressEventBatch, Keys: keys, Attempts: attempts, Error: errorString(err)})
+}
+
+// An error of the given cluster, or of the run as a whole if cluster is empty
+func (p *ProgressEvents) Error(cluster string, err error) {
+	p.Emit(&ProgressEvent{Type: ProgressEventError, Cluster: cluster, Error: errorString(err)})
+}
+
+func (p *ProgressEvents) Close() error {
+	if p == nil || p.file == nil {
+		return nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.file.Close()
+}
+
+// Errors a ProgressTally keeps, the latest last
+const ProgressTallyErrors = 5
+
+// What the progress events of a run add up to, for the run to be watched from elsewhere
+type ProgressTally struct {
+	// Phases in the order they started, and the latest state of each
+	Phases      []string
+	PhaseStates map[string]string
+	// Vbuckets captured of each cluster
+	Vbuckets      map[string]map[uint16]bool
+	Batches       int
+	FailedBatches int
+	Keys          int
+	Errors        []string
+	LastEvent     time.Time
+}
+
+func NewProgressTally() *ProgressTally {
+	return &ProgressTally{PhaseStates: make(map[string]string), Vbuckets: make(map[string]map[uint16]bool)}
+}
+
+func (t *ProgressTally) Add(event *ProgressEvent) {
+	t.LastEvent = event.Time
+	switch event.Type {
+	case ProgressEventPhase:
+		if _, exists := t.PhaseStates[event.Phase]; !exists {
+			t.Phases = append(t.Phases, event.Phase)
+		}
+		t.PhaseStates[event.Phase] = event.State
+		if event.Error != "" {
+			t.addError(fmt.Sprintf("%v %v: %v", event.Phase, event.State, event.Error))
+		}
+	case ProgressEventVbucket:
+		if event.Vbucket == nil {
+			return
+		}
+		if _, exists := t.Vbuckets[event.Cluster]; !exists {
+			t.Vbuckets[event.Cluster] = make(map[uint16]bool)
+		}
+		t.Vbuckets[event.Cluster][*event.Vbucket] = true
+	case ProgressEventBatch:
+		t.Batches++
+		t.Keys += event.Keys
+		if event.Error != "" {
+			t.FailedBatches++
+		}
+	case ProgressEventError:
+		if event.Cluster != "" {
+			t.addError(fmt.Sprintf("%v: %v", event.Cluster, event.Error))
+		} else {
+			t.addError(event.Error)
+		}
+	}
+}
+
+func (t *ProgressTally) addError(err string) {
+	t.Errors = append(t.Errors, err)
+	if len(t.Errors) > ProgressTallyErrors {
+		t.Errors = t.Errors[len(t.Errors)-ProgressTallyErrors:]
+	}
+}
+
+// The tally as lines of text, i.e. for a terminal
+func (t *ProgressTally) Lines() []string {
+	var lines []string
+	for _, phase := range t.Phases {
+		lines = append(lines, fmt.Sprintf("%v: %v", phase, t.PhaseStates[phase]))
+	}
+	for _, cluster := range []string{SourceClusterLabel, TargetClusterLabel} {
+		if vbuckets := len(t.Vbuckets[cluster]); vbuckets > 0 {
+			lines = append(lines, fmt.Sprintf("%v: %v vbuckets captured", cluster, vbuckets))
+		}
+	}
+	if t.Batches > 0 {
+		lines = append(lines, fmt.Sprintf("mutation differ: %v keys in %v batches, %v of which failed", t.Keys, t.Batches, t.FailedBatches))
+	}
+	if len(t.Errors) > 0 {
+		lines = append(lines, "latest errors:")
+		for _, err := range t.Errors {
+			lines = append(lines, "  "+err)
+		}
+	}
+	if !t.LastEvent.IsZero() {
+		lines = append(lines, fmt.Sprintf("last event at %v", t.LastEvent.Format(time.RFC3339)))
+	}
+	return lines
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressEvents(t *testing.T) {
+	fmt.Println("============== Test case start: TestProgressEvents =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "progressEvents")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "events.jsonl")
+
+	// Nothing is written, nor does anything fail, without a destination
+	var none *ProgressEvents
+	none.Batch(10, 1, nil)
+	assert.Nil(none.RunPhase("capture", func() error { return nil }))
+	assert.Nil(none.Close())
+
+	_, err = OpenProgressEvents("fd:x")
+	assert.NotNil(err)
+
+	events, err := OpenProgressEvents(fileName)
+	assert.Nil(err)
+	assert.Nil(events.RunPhase("capture", func() error {
+		events.Vbucket("source", 0)
+		return nil
+	}))
+	assert.NotNil(events.RunPhase("mutationDiff", func() error {
+		events.Batch(500, 2, errors.New("timeout"))
+		return errors.New("unable to open bucket")
+	}))
+	events.Error("target", errors.New("connection refused"))
+	assert.Nil(events.Close())
+
+	eventBytes, err := ioutil.ReadFile(fileName)
+	assert.Nil(err)
+	lines := strings.Split(strings.TrimSpace(string(eventBytes)), "\n")
+	assert.Len(lines, 7)
+	var decoded []*ProgressEvent
+	for _, line := range lines {
+		event := &ProgressEvent{}
+		assert.Nil(json.Unmarshal([]byte(line), event))
+		assert.False(event.Time.IsZero())
+		decoded = append(decoded, event)
+	}
+	assert.Equal(ProgressEventPhase, decoded[0].Type)
+	assert.Equal(PhaseStarted, decoded[0].State)
+	// Vbucket 0 is written out
+	assert.True(strings.Contains(lines[1], `"Vbucket":0`))
+	assert.Equal("source", decoded[1].Cluster)
+	assert.Equal(PhaseDone, decoded[2].State)
+	assert.Equal(500, decoded[4].Keys)
+	assert.Equal(2, decoded[4].Attempts)
+	assert.Equal("timeout", decoded[4].Error)
+	assert.Equal(PhaseFailed, decoded[5].State)
+	assert.Equal("unable to open bucket", decoded[5].Error)
+	assert.Equal(ProgressEventError, decoded[6].Type)
+	// Only the fields of the type are written
+	assert.False(strings.Contains(lines[0], "Keys"))
+	assert.False(strings.Contains(lines[6], "Phase"))
+	fmt.Println("============== Test case end: TestProgressEvents =================")
+}
+
+func TestProgressTally(t *testing.T) {
+	fmt.Println("============== Test case start: TestProgressTally =================")
+	assert := assert.New(t)
+
+	vb := func(vbno uint16) *uint16 { return &vbno }
+	at := time.Date(2021, 5, 11, 17, 5, 12, 0, time.UTC)
+	tally := NewProgressTally()
+	for _, event := range []*ProgressEvent{
+		{Type: ProgressEventPhase, Phase: "capture", State: PhaseStarted},
+		{Type: ProgressEventVbucket, Cluster: SourceClusterLabel, Vbucket: vb(0)},
+		{Type: ProgressEventVbucket, Cluster: SourceClusterLabel, Vbucket: vb(1)},
+		// Completing twice counts once
+		{Type: ProgressEventVbucket, Cluster: SourceClusterLabel, Vbucket: vb(1)},
+		{Type: ProgressEventVbucket, Cluster: TargetClusterLabel, Vbucket: vb(0)},
+		{Type: ProgressEventPhase, Phase: "capture", State: PhaseDone},
+		{Type: ProgressEventPhase, Phase: "mutationDiff", State: PhaseStarted},
+		{Type: ProgressEventBatch, Keys: 500, Attempts: 1},
+		{Type: ProgressEventBatch, Keys: 200, Attempts: 3, Error: "timeout"},
+		{Type: ProgressEventError, Cluster: TargetClusterLabel, Error: "connection refused", Time: at},
+	} {
+		tally.Add(event)
+	}
+	assert.Equal([]string{
+		"capture: done",
+		"mutationDiff: started",
+		"source: 2 vbuckets captured",
+		"target: 1 vbuckets captured",
+		"mutation differ: 700 keys in 2 batches, 1 of which failed",
+		"latest errors:",
+		"  target: connection refused",
+		"last event at 2021-05-11T17:05:12Z",
+	}, tally.Lines())
+
+	// Only the latest errors are kept
+	for i := 0; i < ProgressTallyErrors+2; i++ {
+		tally.Add(&ProgressEvent{Type: ProgressEventError, Error: fmt.Sprintf("error %v", i)})
+	}
+	assert.Len(tally.Errors, ProgressTallyErrors)
+	assert.Equal(fmt.Sprintf("error %v", ProgressTallyErrors+1), tally.Errors[ProgressTallyErrors-1])
+	fmt.Println("============== Test case end: TestProgressTally =================")
+}
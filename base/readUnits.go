@@ -0,0 +1,133 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"xdcrDiffer/stats"
+)
+
+var ErrReadUnitBudgetSpent = errors.New("read unit budget is spent")
+
+// Capella bills the reads of a bucket by read units, each of up to ReadUnitBytes of a document. Reading a document
+// takes one unit at least, be it its metadata alone or a key that is not found
+const ReadUnitBytes = 4096
+
+// Milliseconds between the checks of the read units used while capturing
+const ReadUnitBudgetCheckInterval = 1000
+
+// Hosts of Capella clusters are under this domain
+const CapellaHostSuffix = ".cloud.couchbase.com"
+
+// Values of capellaClusters
+const (
+	CapellaClustersAuto   = "auto"
+	CapellaClustersSource = "source"
+	CapellaClustersTarget = "target"
+	CapellaClustersBoth   = "both"
+	CapellaClustersNone   = "none"
+)
+
+var capellaClustersValues = map[string]bool{CapellaClustersAuto: true, CapellaClustersSource: true,
+	CapellaClustersTarget: true, CapellaClustersBoth: true, CapellaClustersNone: true}
+
+func ValidCapellaClusters(value string) bool {
+	return capellaClustersValues[value]
+}
+
+// Whether the URL, or connection string, is of a Capella cluster
+func IsCapellaUrl(clusterUrl string) bool {
+	if !strings.Contains(clusterUrl, "://") {
+		clusterUrl = HttpPrefix + clusterUrl
+	}
+	parsed, err := url.Parse(clusterUrl)
+	if err != nil {
+		return false
+	}
+	// Of a connection string, the first node is enough
+	host := strings.Split(parsed.Host, ",")[0]
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	return strings.HasSuffix(strings.ToLower(host), CapellaHostSuffix)
+}
+
+// Of reading a document of the given size
+func ReadUnits(bytes int) int64 {
+	if bytes <= ReadUnitBytes {
+		return 1
+	}
+	return int64((bytes + ReadUnitBytes - 1) / ReadUnitBytes)
+}
+
+// Counts the read units of the Capella clusters of a run against the most the run may use of them. Units are counted
+// for every cluster in the stats, but only those of the Capella clusters are billed. A nil budget has no Capella
+// clusters and no limit
+type ReadUnitBudget struct {
+	clusters []string
+	// Units at most, 0 for no limit
+	limit   int64
+	onSpent func(used int64)
+	spent   sync.Once
+}
+
+func NewReadUnitBudget(capellaClusters []string, limit int64, onSpent func(used int64)) *ReadUnitBudget {
+	return &ReadUnitBudget{clusters: capellaClusters, limit: limit, onSpent: onSpent}
+}
+
+// The Capella clusters, whose read units are counted against the budget
+func (b *ReadUnitBudget) Clusters() []string {
+	if b == nil {
+		return nil
+	}
+	return b.clusters
+}
+
+func (b *ReadUnitBudget) Limit() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.limit
+}
+
+// Read units used so far of the given cluster
+func ClusterReadUnits(cluster string) int64 {
+	return stats.Default.Counter(fmt.Sprintf(stats.ClusterReadUnits, cluster)).Value()
+}
+
+// Read units used so far of the Capella clusters
+func (b *ReadUnitBudget) Used() int64 {
+	var used int64
+	for _, cluster := range b.Clusters() {
+		used += ClusterReadUnits(cluster)
+	}
+	return used
+}
+
+// An error once the units used reach the limit, which is when onSpent is called, once
+func (b *ReadUnitBudget) Spent() error {
+	if b.Limit() == 0 {
+		return nil
+	}
+	used := b.Used()
+	if used < b.limit {
+		return nil
+	}
+	b.spent.Do(func() {
+		if b.onSpent != nil {
+			b.onSpent(used)
+		}
+	})
+	return fmt.Errorf("%w: %v of %v read units of %v used", ErrReadUnitBudgetSpent, used, b.limit, strings.Join(b.clusters, " and "))
+}
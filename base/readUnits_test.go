@@ -0,0 +1,56 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"xdcrDiffer/stats"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadUnits(t *testing.T) {
+	fmt.Println("============== Test case start: TestReadUnits =================")
+	assert := assert.New(t)
+
+	assert.Equal(int64(1), ReadUnits(0))
+	assert.Equal(int64(1), ReadUnits(4096))
+	assert.Equal(int64(2), ReadUnits(4097))
+	assert.Equal(int64(25), ReadUnits(100000))
+
+	assert.True(IsCapellaUrl("cb.abcd1234.cloud.couchbase.com"))
+	assert.True(IsCapellaUrl("https://cb.abcd1234.cloud.couchbase.com:18091"))
+	assert.True(IsCapellaUrl("couchbases://cb.abcd1234.cloud.couchbase.com,cb2.abcd1234.cloud.couchbase.com?network=external"))
+	assert.False(IsCapellaUrl("http://127.0.0.1:8091"))
+	assert.False(IsCapellaUrl("cloud.couchbase.com.example.org"))
+
+	var nilBudget *ReadUnitBudget
+	assert.Nil(nilBudget.Spent())
+	assert.Equal(int64(0), nilBudget.Used())
+
+	// Counted afresh, so that the units of earlier runs of the test are not taken as spent
+	defaultRegistry := stats.Default
+	stats.Default = stats.NewRegistry()
+	defer func() { stats.Default = defaultRegistry }()
+	var spentCalls []int64
+	budget := NewReadUnitBudget([]string{"capellaTarget"}, 100, func(used int64) { spentCalls = append(spentCalls, used) })
+	units := stats.Default.Counter(fmt.Sprintf(stats.ClusterReadUnits, "capellaTarget"))
+	// Units of clusters that are not Capella are not billed
+	stats.Default.Counter(fmt.Sprintf(stats.ClusterReadUnits, "selfManaged")).Add(1000)
+	units.Add(99)
+	assert.Nil(budget.Spent())
+	units.Add(1)
+	assert.True(errors.Is(budget.Spent(), ErrReadUnitBudgetSpent))
+	assert.True(errors.Is(budget.Spent(), ErrReadUnitBudgetSpent))
+	assert.Equal([]int64{100}, spentCalls)
+	fmt.Println("============== Test case end: TestReadUnits =================")
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/gocbcore/v10"
+)
+
+// Tag of SDK messages that name the nodes of neither cluster or of both, i.e. with sameCluster
+const SDKLogUnattributed = "sdk"
+
+var sdkLogLevels = map[string]gocbcore.LogLevel{
+	"error": gocbcore.LogError,
+	"warn":  gocbcore.LogWarn,
+	"info":  gocbcore.LogInfo,
+	"debug": gocbcore.LogDebug,
+	"trace": gocbcore.LogTrace,
+}
+
+func ParseSDKLogLevel(name string) (gocbcore.LogLevel, error) {
+	level, exists := sdkLogLevels[strings.ToLower(name)]
+	if !exists {
+		return 0, fmt.Errorf("unknown SDK log level %q, expected one of error, warn, info, debug or trace", name)
+	}
+	return level, nil
+}
+
+// Routes the log messages of the SDK into the log of the tool, tagged by the cluster they are of. The SDK has a
+// single logger per process, so the cluster of a message is told by the KV nodes it names, as registered when
+// the agents of each cluster are set up. Implements gocbcore.Logger
+type SDKLogRouter struct {
+	level gocbcore.LogLevel
+	logf  func(level gocbcore.LogLevel, message string)
+
+	mtx sync.RWMutex
+	// Host of a KV node -> label of its cluster
+	hosts map[string]string
+}
+
+// Nil unless SDK logging was asked for
+var SDKLogs *SDKLogRouter
+
+// Messages more verbose than level are dropped. The others are passed to logf with their cluster tag
+func NewSDKLogRouter(level gocbcore.LogLevel, logf func(level gocbcore.LogLevel, message string)) *SDKLogRouter {
+	return &SDKLogRouter{
+		level: level,
+		logf:  logf,
+		hosts: make(map[string]string),
+	}
+}
+
+// Registers the nodes of kvVbMap, as they report themselves and as they are connected to, as nodes of cluster
+func (r *SDKLogRouter) RegisterKvNodes(cluster string, kvVbMap map[string][]uint16, ports *ClusterPorts) {
+	if r == nil {
+		return
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for kvAddr := range kvVbMap {
+		for _, addr := range []string{kvAddr, ports.Translate(kvAddr)} {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				host = addr
+			}
+			r.hosts[host] = cluster
+		}
+	}
+}
+
+func (r *SDKLogRouter) Log(level gocbcore.LogLevel, offset int, format string, v ...interface{}) error {
+	if level > r.level {
+		return nil
+	}
+	message := strings.TrimRight(fmt.Sprintf(format, v...), "\n")
+	r.logf(level, fmt.Sprintf("[%v] %v", r.clusterOf(message), message))
+	return nil
+}
+
+// The cluster whose nodes the message names, as host:port
+func (r *SDKLogRouter) clusterOf(message string) string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	cluster := ""
+	for host, hostCluster := range r.hosts {
+		if !namesHost(message, host) || hostCluster == cluster {
+			continue
+		}
+		if cluster != "" {
+			return SDKLogUnattributed
+		}
+		cluster = hostCluster
+	}
+	if cluster == "" {
+		return SDKLogUnattributed
+	}
+	return cluster
+}
+
+// Whether host appears in the message followed by a port, and not as the end of a longer host name
+func namesHost(message, host string) bool {
+	for start := 0; ; {
+		i := strings.Index(message[start:], host+":")
+		if i < 0 {
+			return false
+		}
+		i += start
+		if i == 0 || !isHostNameChar(message[i-1]) {
+			return true
+		}
+		start = i + 1
+	}
+}
+
+func isHostNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-'
+}
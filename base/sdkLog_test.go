@@ -0,0 +1,54 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/couchbase/gocbcore/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSDKLogRouter(t *testing.T) {
+	fmt.Println("============== Test case start: TestSDKLogRouter =================")
+	assert := assert.New(t)
+
+	_, err := ParseSDKLogLevel("verbose")
+	assert.NotNil(err)
+	level, err := ParseSDKLogLevel("Warn")
+	assert.Nil(err)
+
+	var logged []string
+	router := NewSDKLogRouter(level, func(level gocbcore.LogLevel, message string) {
+		logged = append(logged, message)
+	})
+	var nilRouter *SDKLogRouter
+	nilRouter.RegisterKvNodes("source", map[string][]uint16{"10.0.0.1:11210": nil}, nil)
+
+	router.RegisterKvNodes("source", map[string][]uint16{"10.0.0.1:11210": nil, "10.0.0.2:11210": nil}, nil)
+	// The target is reached through a forwarded address
+	targetPorts := &ClusterPorts{NodeAddresses: map[string]string{"192.168.1.5:11210": "localhost:21210"}}
+	router.RegisterKvNodes("target", map[string][]uint16{"192.168.1.5:11210": nil}, targetPorts)
+
+	assert.Nil(router.Log(gocbcore.LogInfo, 0, "Dropped, as more verbose than warn"))
+	router.Log(gocbcore.LogWarn, 0, "Failed to connect to %v: connection refused\n", "10.0.0.2:11210")
+	router.Log(gocbcore.LogError, 0, "Auth failure on localhost:21210")
+	// 10.0.0.12 is not 10.0.0.1
+	router.Log(gocbcore.LogWarn, 0, "NMV from 10.0.0.12:11210")
+	router.Log(gocbcore.LogWarn, 0, "Moving vbuckets from 10.0.0.1:11210 to 192.168.1.5:11210")
+	assert.Equal([]string{
+		"[source] Failed to connect to 10.0.0.2:11210: connection refused",
+		"[target] Auth failure on localhost:21210",
+		"[sdk] NMV from 10.0.0.12:11210",
+		"[sdk] Moving vbuckets from 10.0.0.1:11210 to 192.168.1.5:11210",
+	}, logged)
+	fmt.Println("============== Test case end: TestSDKLogRouter =================")
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Where the DCP stream of a vbucket starts
+const (
+	StreamStartZero       = "zero"
+	StreamStartCheckpoint = "checkpoint"
+	StreamStartSeqno      = "seqno"
+)
+
+type StreamStart struct {
+	From string
+	// For StreamStartSeqno, the seqno to stream from, and the failover UUID it is of. The current vbuuid of the
+	// vbucket is used if Vbuuid is 0
+	Seqno  uint64 `json:",omitempty"`
+	Vbuuid uint64 `json:",omitempty"`
+}
+
+// Where the streams of the vbuckets of a cluster start. Vbuckets that are not listed start from Default, or, if it is
+// not set either, from the checkpoint the run resumes from if any and from zero otherwise
+type StreamStarts struct {
+	Default  *StreamStart            `json:",omitempty"`
+	Vbuckets map[uint16]*StreamStart `json:",omitempty"`
+}
+
+type StreamStartPlan struct {
+	Source *StreamStarts `json:",omitempty"`
+	Target *StreamStarts `json:",omitempty"`
+}
+
+var streamStartZero = &StreamStart{From: StreamStartZero}
+var streamStartCheckpoint = &StreamStart{From: StreamStartCheckpoint}
+
+func ReadStreamStartPlan(fileName string) (*StreamStartPlan, error) {
+	planBytes, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	plan := &StreamStartPlan{}
+	if err = json.Unmarshal(planBytes, plan); err != nil {
+		return nil, fmt.Errorf("%v: %v", fileName, err)
+	}
+	for cluster, starts := range map[string]*StreamStarts{SourceClusterLabel: plan.Source, TargetClusterLabel: plan.Target} {
+		if err = starts.validate(); err != nil {
+			return nil, fmt.Errorf("%v: %v %v", fileName, cluster, err)
+		}
+	}
+	return plan, nil
+}
+
+func (s *StreamStart) validate() error {
+	switch s.From {
+	case StreamStartZero, StreamStartCheckpoint:
+		if s.Seqno != 0 || s.Vbuuid != 0 {
+			return fmt.Errorf("has a seqno or vbuuid, which only a start from %v takes", StreamStartSeqno)
+		}
+	case StreamStartSeqno:
+	default:
+		return fmt.Errorf("starts from %q rather than from %v, %v or %v", s.From, StreamStartZero, StreamStartCheckpoint, StreamStartSeqno)
+	}
+	return nil
+}
+
+func (s *StreamStarts) validate() error {
+	if s == nil {
+		return nil
+	}
+	if s.Default != nil {
+		if err := s.Default.validate(); err != nil {
+			return fmt.Errorf("default %v", err)
+		}
+	}
+	for vbno, start := range s.Vbuckets {
+		if vbno >= NumberOfVbuckets {
+			return fmt.Errorf("vbucket %v does not exist", vbno)
+		}
+		if start == nil {
+			return fmt.Errorf("vbucket %v has no start", vbno)
+		}
+		if err := start.validate(); err != nil {
+			return fmt.Errorf("vbucket %v %v", vbno, err)
+		}
+	}
+	return nil
+}
+
+// Where the stream of the vbucket starts, given whether the run resumes from a checkpoint
+func (s *StreamStarts) For(vbno uint16, hasCheckpoint bool) *StreamStart {
+	if s != nil {
+		if start, exists := s.Vbuckets[vbno]; exists {
+			return start
+		}
+		if s.Default != nil {
+			return s.Default
+		}
+	}
+	if hasCheckpoint {
+		return streamStartCheckpoint
+	}
+	return streamStartZero
+}
+
+// Whether any vbucket is to start from the checkpoint, which then has to be given
+func (s *StreamStarts) NeedsCheckpoint() bool {
+	if s == nil {
+		return false
+	}
+	if s.Default != nil && s.Default.From == StreamStartCheckpoint {
+		return true
+	}
+	for _, start := range s.Vbuckets {
+		if start.From == StreamStartCheckpoint {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamStartPlan(t *testing.T) {
+	fmt.Println("============== Test case start: TestStreamStartPlan =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "streamStart")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "streamStart.json")
+
+	assert.Nil(ioutil.WriteFile(fileName, []byte(`{"Source":{"Default":{"From":"checkpoint"},`+
+		`"Vbuckets":{"3":{"From":"zero"},"7":{"From":"seqno","Seqno":1200,"Vbuuid":42}}}}`), 0644))
+	plan, err := ReadStreamStartPlan(fileName)
+	assert.Nil(err)
+	assert.Equal(&StreamStart{From: StreamStartCheckpoint}, plan.Source.For(0, true))
+	assert.Equal(&StreamStart{From: StreamStartZero}, plan.Source.For(3, true))
+	assert.Equal(&StreamStart{From: StreamStartSeqno, Seqno: 1200, Vbuuid: 42}, plan.Source.For(7, false))
+	assert.True(plan.Source.NeedsCheckpoint())
+
+	// A cluster the plan leaves out resumes from the checkpoint if there is one
+	assert.Nil(plan.Target)
+	assert.Equal(StreamStartCheckpoint, plan.Target.For(7, true).From)
+	assert.Equal(StreamStartZero, plan.Target.For(7, false).From)
+	assert.False(plan.Target.NeedsCheckpoint())
+
+	for _, invalid := range []string{
+		`{"Target":{"Default":{"From":"latest"}}}`,
+		`{"Target":{"Vbuckets":{"1024":{"From":"zero"}}}}`,
+		`{"Target":{"Vbuckets":{"5":{"From":"zero","Seqno":10}}}}`,
+		`{"Target":{"Vbuckets":{"5":null}}}`,
+		`{"Target":[]}`,
+	} {
+		assert.Nil(ioutil.WriteFile(fileName, []byte(invalid), 0644))
+		_, err = ReadStreamStartPlan(fileName)
+		assert.NotNil(err, invalid)
+	}
+	fmt.Println("============== Test case end: TestStreamStartPlan =================")
+}
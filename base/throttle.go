@@ -0,0 +1,252 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Caps how much of the work of a run that puts load on the clusters is done at once, as a share of its usual
+// concurrency. A nil throttle never caps
+type Throttle struct {
+	cond     *sync.Cond
+	share    float64
+	inFlight int
+}
+
+func NewThrottle() *Throttle {
+	return &Throttle{cond: sync.NewCond(&sync.Mutex{}), share: 1}
+}
+
+// share is in (0, 1], 1 being the usual concurrency
+func (t *Throttle) SetShare(share float64) {
+	t.cond.L.Lock()
+	defer t.cond.L.Unlock()
+	t.share = share
+	t.cond.Broadcast()
+}
+
+func (t *Throttle) Share() float64 {
+	if t == nil {
+		return 1
+	}
+	t.cond.L.Lock()
+	defer t.cond.L.Unlock()
+	return t.share
+}
+
+// Blocks until one more unit of work is allowed, of at most concurrency units at the full share. At least one
+// unit is always allowed, so that the work carries on, however slowly
+func (t *Throttle) Acquire(concurrency int) {
+	if t == nil {
+		return
+	}
+	t.cond.L.Lock()
+	defer t.cond.L.Unlock()
+	for t.inFlight > 0 && t.inFlight >= int(t.share*float64(concurrency)) {
+		t.cond.Wait()
+	}
+	t.inFlight++
+}
+
+func (t *Throttle) Release() {
+	if t == nil {
+		return
+	}
+	t.cond.L.Lock()
+	defer t.cond.L.Unlock()
+	t.inFlight--
+	t.cond.Broadcast()
+}
+
+// Health stats of a cluster beyond which it is taken to be under stress. Stats that are 0 are not checked
+type HealthThresholds struct {
+	// Highest CPU utilization of any node, in percent
+	CpuPercent float64
+	// Highest share of memory used of any node, in percent
+	MemoryPercent float64
+	// Items of the bucket waiting to be written to disk
+	DiskQueue float64
+	// Share of active items of the bucket resident in memory, in percent. Stress is when it is below this one
+	ResidentRatio float64
+}
+
+// Names of the thresholds as given to ParseHealthThresholds
+const (
+	HealthThresholdCpu           = "cpu"
+	HealthThresholdMemory        = "memory"
+	HealthThresholdDiskQueue     = "diskQueue"
+	HealthThresholdResidentRatio = "residentRatio"
+)
+
+// Parses comma separated name=value pairs, i.e. "cpu=85,memory=90,diskQueue=1000000,residentRatio=10"
+func ParseHealthThresholds(spec string) (*HealthThresholds, error) {
+	thresholds := &HealthThresholds{}
+	var given int
+	for _, threshold := range strings.Split(spec, ",") {
+		if threshold = strings.TrimSpace(threshold); threshold == "" {
+			continue
+		}
+		parts := strings.SplitN(threshold, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid threshold %v. Expected name=value", threshold)
+		}
+		name := strings.TrimSpace(parts[0])
+		value, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("Invalid threshold %v. The value has to be a number greater than 0", threshold)
+		}
+		switch name {
+		case HealthThresholdCpu:
+			thresholds.CpuPercent = value
+		case HealthThresholdMemory:
+			thresholds.MemoryPercent = value
+		case HealthThresholdDiskQueue:
+			thresholds.DiskQueue = value
+		case HealthThresholdResidentRatio:
+			thresholds.ResidentRatio = value
+		default:
+			return nil, fmt.Errorf("Invalid threshold %v. Accepted names are %v, %v, %v and %v", threshold,
+				HealthThresholdCpu, HealthThresholdMemory, HealthThresholdDiskQueue, HealthThresholdResidentRatio)
+		}
+		given++
+	}
+	if given == 0 {
+		return nil, fmt.Errorf("No thresholds given in %v", spec)
+	}
+	return thresholds, nil
+}
+
+// Health stats of a cluster at one point in time
+type HealthSample struct {
+	Cluster       string
+	CpuPercent    float64
+	MemoryPercent float64
+	DiskQueue     float64
+	ResidentRatio float64
+}
+
+// Describes each threshold the sample is beyond. Empty if the cluster is healthy
+func (t *HealthThresholds) Breaches(sample *HealthSample) []string {
+	var breaches []string
+	if t.CpuPercent > 0 && sample.CpuPercent > t.CpuPercent {
+		breaches = append(breaches, fmt.Sprintf("%v cpu %.1f%% over %v%%", sample.Cluster, sample.CpuPercent, t.CpuPercent))
+	}
+	if t.MemoryPercent > 0 && sample.MemoryPercent > t.MemoryPercent {
+		breaches = append(breaches, fmt.Sprintf("%v memory %.1f%% over %v%%", sample.Cluster, sample.MemoryPercent, t.MemoryPercent))
+	}
+	if t.DiskQueue > 0 && sample.DiskQueue > t.DiskQueue {
+		breaches = append(breaches, fmt.Sprintf("%v disk queue %.0f over %v", sample.Cluster, sample.DiskQueue, t.DiskQueue))
+	}
+	// A bucket without items reports no resident ratio
+	if t.ResidentRatio > 0 && sample.ResidentRatio > 0 && sample.ResidentRatio < t.ResidentRatio {
+		breaches = append(breaches, fmt.Sprintf("%v resident ratio %.1f%% under %v%%", sample.Cluster, sample.ResidentRatio, t.ResidentRatio))
+	}
+	return breaches
+}
+
+// Acts on the health of the clusters as it is polled. While any cluster is under stress, the share of concurrency
+// is halved on every poll down to HealthThrottleMinShare, and the run is paused once the stress lasts
+// HealthThrottlePausePolls polls. After HealthThrottleRecoverPolls healthy polls in a row, a pause it made is
+// lifted, and then the share is doubled back up on every such stretch
+type HealthThrottler struct {
+	mtx        sync.Mutex
+	thresholds *HealthThresholds
+	throttle   *Throttle
+	// Pause and resume the run, returning false if it already was paused or was not paused
+	pause  func() bool
+	resume func() bool
+
+	stressedPolls int
+	healthyPolls  int
+	// A pause made by someone else is theirs to lift
+	paused bool
+	// Why the run is throttled. Empty while it is not
+	reason string
+
+	reductions int
+	pauses     int
+}
+
+func NewHealthThrottler(thresholds *HealthThresholds, throttle *Throttle, pause, resume func() bool) *HealthThrottler {
+	return &HealthThrottler{thresholds: thresholds, throttle: throttle, pause: pause, resume: resume}
+}
+
+// Takes in the latest sample of each cluster, and describes what was done about it. Empty if nothing was
+func (h *HealthThrottler) Observe(samples []*HealthSample) string {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	var breaches []string
+	for _, sample := range samples {
+		breaches = append(breaches, h.thresholds.Breaches(sample)...)
+	}
+	share := h.throttle.Share()
+
+	if len(breaches) > 0 {
+		h.healthyPolls = 0
+		h.stressedPolls++
+		h.reason = strings.Join(breaches, ", ")
+		if share > HealthThrottleMinShare {
+			share /= 2
+			if share < HealthThrottleMinShare {
+				share = HealthThrottleMinShare
+			}
+			h.throttle.SetShare(share)
+			h.reductions++
+			return fmt.Sprintf("Reduced concurrency to %.0f%% as %v", share*100, h.reason)
+		}
+		if h.stressedPolls >= HealthThrottlePausePolls && !h.paused && h.pause() {
+			h.paused = true
+			h.pauses++
+			return fmt.Sprintf("Paused as %v for %v polls", h.reason, h.stressedPolls)
+		}
+		return ""
+	}
+
+	h.stressedPolls = 0
+	if h.paused || share < 1 {
+		h.healthyPolls++
+	}
+	if h.healthyPolls < HealthThrottleRecoverPolls {
+		return ""
+	}
+	h.healthyPolls = 0
+	if h.paused {
+		h.paused = false
+		if h.resume() {
+			return "Resumed as the clusters are healthy again"
+		}
+		return ""
+	}
+	share *= 2
+	if share >= 1 {
+		share = 1
+		h.reason = ""
+	}
+	h.throttle.SetShare(share)
+	return fmt.Sprintf("Raised concurrency to %.0f%% as the clusters are healthy again", share*100)
+}
+
+// Why the run is throttled. Empty while it is not
+func (h *HealthThrottler) Reason() string {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return h.reason
+}
+
+// How many times concurrency was reduced, and the run paused
+func (h *HealthThrottler) Actions() (reductions, pauses int) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return h.reductions, h.pauses
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottle(t *testing.T) {
+	fmt.Println("============== Test case start: TestThrottle =================")
+	assert := assert.New(t)
+
+	var nilThrottle *Throttle
+	nilThrottle.Acquire(1)
+	nilThrottle.Release()
+	assert.Equal(1.0, nilThrottle.Share())
+
+	throttle := NewThrottle()
+	throttle.SetShare(0.1)
+	// Below one unit of work, one is still allowed
+	throttle.Acquire(4)
+	acquired := make(chan bool)
+	go func() {
+		throttle.Acquire(4)
+		acquired <- true
+	}()
+	select {
+	case <-acquired:
+		assert.Fail("Acquired beyond the share")
+	case <-time.After(50 * time.Millisecond):
+	}
+	throttle.SetShare(0.5)
+	<-acquired
+	throttle.Release()
+	throttle.Release()
+	fmt.Println("============== Test case end: TestThrottle =================")
+}
+
+func TestHealthThrottler(t *testing.T) {
+	fmt.Println("============== Test case start: TestHealthThrottler =================")
+	assert := assert.New(t)
+
+	thresholds, err := ParseHealthThresholds("cpu=80, residentRatio=10")
+	assert.Nil(err)
+	assert.Equal(&HealthThresholds{CpuPercent: 80, ResidentRatio: 10}, thresholds)
+	_, err = ParseHealthThresholds("cpu")
+	assert.NotNil(err)
+	_, err = ParseHealthThresholds("disk=1")
+	assert.NotNil(err)
+	_, err = ParseHealthThresholds("memory=-1")
+	assert.NotNil(err)
+	_, err = ParseHealthThresholds(" , ")
+	assert.NotNil(err)
+
+	healthy := []*HealthSample{{Cluster: "source", CpuPercent: 50, ResidentRatio: 100}, {Cluster: "target"}}
+	stressed := []*HealthSample{{Cluster: "source", CpuPercent: 95, ResidentRatio: 5}, {Cluster: "target"}}
+	assert.Len(thresholds.Breaches(stressed[0]), 2)
+	assert.Len(thresholds.Breaches(stressed[1]), 0)
+
+	throttle := NewThrottle()
+	var paused, manuallyPaused bool
+	pause := func() bool {
+		if paused || manuallyPaused {
+			return false
+		}
+		paused = true
+		return true
+	}
+	resume := func() bool {
+		wasPaused := paused
+		paused = false
+		return wasPaused
+	}
+	throttler := NewHealthThrottler(thresholds, throttle, pause, resume)
+	assert.Equal("", throttler.Observe(healthy))
+	assert.Equal(1.0, throttle.Share())
+
+	// Concurrency is halved down to the minimum, and then the run is paused
+	assert.Contains(throttler.Observe(stressed), "Reduced concurrency to 50%")
+	assert.Contains(throttler.Reason(), "source cpu 95.0% over 80%")
+	throttler.Observe(stressed)
+	throttler.Observe(stressed)
+	assert.Equal(HealthThrottleMinShare, throttle.Share())
+	assert.False(paused)
+	assert.Contains(throttler.Observe(stressed), "Paused")
+	assert.True(paused)
+	assert.Equal("", throttler.Observe(stressed))
+
+	// Recovery lifts the pause first, then raises concurrency back up
+	assert.Equal("", throttler.Observe(healthy))
+	assert.Equal("Resumed as the clusters are healthy again", throttler.Observe(healthy))
+	assert.False(paused)
+	for i := 0; i < 3*HealthThrottleRecoverPolls; i++ {
+		throttler.Observe(healthy)
+	}
+	assert.Equal(1.0, throttle.Share())
+	assert.Equal("", throttler.Reason())
+	reductions, pauses := throttler.Actions()
+	assert.Equal(3, reductions)
+	assert.Equal(1, pauses)
+
+	// A pause made by someone else is not lifted
+	manuallyPaused = true
+	for i := 0; i < 3+HealthThrottlePausePolls; i++ {
+		throttler.Observe(stressed)
+	}
+	_, pauses = throttler.Actions()
+	assert.Equal(1, pauses)
+	fmt.Println("============== Test case end: TestHealthThrottler =================")
+}
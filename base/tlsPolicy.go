@@ -0,0 +1,212 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How the certificates presented by the clusters over TLS are verified. A nil policy verifies them against the
+// certificates of the cluster reference, with hostname verification, as before any policy could be given
+type TLSPolicy struct {
+	// Trust the roots of the system on top of the certificates of the cluster reference
+	useSystemRoots bool
+	// PEM of the CA file given, trusted on top of the certificates of the cluster reference
+	caPEM []byte
+	// For lab clusters whose certificates do not name the addresses they are reached at
+	skipHostnameVerification bool
+	// The leaf certificate has to carry one of these DNS, IP or URI SANs, i.e. a SPIFFE ID, if any are given
+	pinnedSANs []string
+	// The leaf certificate has to have one of these SHA-256 fingerprints, if any are given
+	pinnedFingerprints [][]byte
+
+	mtx sync.Mutex
+	// address -> result of probing it, so that every node is probed once
+	probed map[string]error
+}
+
+// The policy verifying certificates the way the tool always has
+var TLSVerification *TLSPolicy
+
+// Without hostname verification the SDK connections are not verified at all, and the SDK has no hook to check
+// pins on them, so pins would only hold for the probe and not for the connections that carry the data
+var ErrPinsWithoutHostnameVerification = errors.New("pinned SANs and fingerprints cannot be combined with skipping hostname verification, as they could not be enforced on the SDK connections")
+
+// Pinned SANs and fingerprints are comma separated. Fingerprints are in hex, with or without colons
+func NewTLSPolicy(useSystemRoots bool, caFile string, skipHostnameVerification bool, pinnedSANs, pinnedFingerprints string) (*TLSPolicy, error) {
+	policy := &TLSPolicy{
+		useSystemRoots:           useSystemRoots,
+		skipHostnameVerification: skipHostnameVerification,
+		probed:                   make(map[string]error),
+	}
+	if caFile != "" {
+		caPEM, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("%v holds no PEM certificates", caFile)
+		}
+		policy.caPEM = caPEM
+	}
+	for _, san := range strings.Split(pinnedSANs, ",") {
+		if san = strings.TrimSpace(san); san != "" {
+			policy.pinnedSANs = append(policy.pinnedSANs, san)
+		}
+	}
+	for _, fingerprint := range strings.Split(pinnedFingerprints, ",") {
+		fingerprint = strings.ToLower(strings.Replace(strings.TrimSpace(fingerprint), ":", "", -1))
+		if fingerprint == "" {
+			continue
+		}
+		decoded, err := hex.DecodeString(fingerprint)
+		if err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("%v is not a SHA-256 fingerprint in hex", fingerprint)
+		}
+		policy.pinnedFingerprints = append(policy.pinnedFingerprints, decoded)
+	}
+	if skipHostnameVerification && (len(policy.pinnedSANs) > 0 || len(policy.pinnedFingerprints) > 0) {
+		return nil, ErrPinsWithoutHostnameVerification
+	}
+	return policy, nil
+}
+
+// Roots to verify the chain of a cluster with the given reference certificates against
+func (p *TLSPolicy) CertPool(refCertificates []byte) (*x509.CertPool, error) {
+	certPool := x509.NewCertPool()
+	if p != nil && p.useSystemRoots {
+		systemPool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the system roots: %v", err)
+		}
+		certPool = systemPool
+	}
+	if len(refCertificates) > 0 && !certPool.AppendCertsFromPEM(refCertificates) {
+		return nil, fmt.Errorf("invalid root CA %s", refCertificates)
+	}
+	if p != nil && len(p.caPEM) > 0 {
+		certPool.AppendCertsFromPEM(p.caPEM)
+	}
+	return certPool, nil
+}
+
+// Whether SDK connections are to skip verification altogether. The SDK cannot skip hostname verification alone,
+// so the chain of every node is checked by Probe instead
+func (p *TLSPolicy) SDKSkipsVerification() bool {
+	return p != nil && p.skipHostnameVerification
+}
+
+// Whether the certificates of the clusters are to be probed, as the policy checks more than the SDK does
+func (p *TLSPolicy) NeedsProbe() bool {
+	return p != nil && (p.skipHostnameVerification || len(p.pinnedSANs) > 0 || len(p.pinnedFingerprints) > 0)
+}
+
+// Verifies the chain presented by the server at serverName, and that the leaf matches the pins
+func (p *TLSPolicy) VerifyPeer(certificates []*x509.Certificate, serverName string, roots *x509.CertPool) error {
+	if len(certificates) == 0 {
+		return fmt.Errorf("no certificate was presented")
+	}
+	leaf := certificates[0]
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	verifyOpts := x509.VerifyOptions{Roots: roots, Intermediates: intermediates}
+	if p == nil || !p.skipHostnameVerification {
+		verifyOpts.DNSName = serverName
+	}
+	if _, err := leaf.Verify(verifyOpts); err != nil {
+		return err
+	}
+	if p == nil {
+		return nil
+	}
+
+	if len(p.pinnedFingerprints) > 0 {
+		fingerprint := sha256.Sum256(leaf.Raw)
+		matched := false
+		for _, pinned := range p.pinnedFingerprints {
+			if bytes.Equal(pinned, fingerprint[:]) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("certificate fingerprint %x is not pinned", fingerprint)
+		}
+	}
+	if len(p.pinnedSANs) > 0 {
+		var sans []string
+		sans = append(sans, leaf.DNSNames...)
+		for _, ip := range leaf.IPAddresses {
+			sans = append(sans, ip.String())
+		}
+		for _, uri := range leaf.URIs {
+			sans = append(sans, uri.String())
+		}
+		for _, pinned := range p.pinnedSANs {
+			for _, san := range sans {
+				if san == pinned {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("certificate SANs %v match none of the pinned ones", sans)
+	}
+	return nil
+}
+
+// Connects to the TLS endpoint at address, i.e. host:port, and verifies what it presents against the policy.
+// Each address is probed once
+func (p *TLSPolicy) Probe(address string, refCertificates []byte, timeout time.Duration) error {
+	if !p.NeedsProbe() {
+		return nil
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if err, probed := p.probed[address]; probed {
+		return err
+	}
+	err := p.probe(address, refCertificates, timeout)
+	if err != nil {
+		err = fmt.Errorf("TLS verification of %v failed: %v", address, err)
+	}
+	p.probed[address] = err
+	return err
+}
+
+func (p *TLSPolicy) probe(address string, refCertificates []byte, timeout time.Duration) error {
+	roots, err := p.CertPool(refCertificates)
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	// The chain is verified by VerifyPeer, so that hostname verification can be skipped on its own
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return p.VerifyPeer(conn.ConnectionState().PeerCertificates, host, roots)
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func selfSignedCertificate(t *testing.T, dnsName string, uri string) (*x509.Certificate, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: dnsName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{dnsName},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if uri != "" {
+		parsed, _ := url.Parse(uri)
+		template.URIs = []*url.URL{parsed}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestTLSPolicyVerifyPeer(t *testing.T) {
+	fmt.Println("============== Test case start: TestTLSPolicyVerifyPeer =================")
+	assert := assert.New(t)
+
+	certificate, certificatePEM := selfSignedCertificate(t, "node1.lab", "spiffe://lab/couchbase")
+	var defaultPolicy *TLSPolicy
+	roots, err := defaultPolicy.CertPool(certificatePEM)
+	assert.Nil(err)
+	assert.Nil(defaultPolicy.VerifyPeer([]*x509.Certificate{certificate}, "node1.lab", roots))
+	assert.NotNil(defaultPolicy.VerifyPeer([]*x509.Certificate{certificate}, "10.0.0.1", roots))
+	assert.False(defaultPolicy.NeedsProbe())
+
+	policy, err := NewTLSPolicy(false, "", true, "", "")
+	assert.Nil(err)
+	assert.True(policy.SDKSkipsVerification())
+	assert.Nil(policy.VerifyPeer([]*x509.Certificate{certificate}, "10.0.0.1", roots))
+	otherCertificate, _ := selfSignedCertificate(t, "node1.lab", "")
+	assert.NotNil(policy.VerifyPeer([]*x509.Certificate{otherCertificate}, "node1.lab", roots))
+
+	fingerprint := sha256.Sum256(certificate.Raw)
+	policy, err = NewTLSPolicy(false, "", false, "spiffe://lab/couchbase", hex.EncodeToString(fingerprint[:]))
+	assert.Nil(err)
+	assert.True(policy.NeedsProbe())
+	assert.Nil(policy.VerifyPeer([]*x509.Certificate{certificate}, "node1.lab", roots))
+
+	policy, err = NewTLSPolicy(false, "", false, "spiffe://lab/other", "")
+	assert.Nil(err)
+	assert.NotNil(policy.VerifyPeer([]*x509.Certificate{certificate}, "node1.lab", roots))
+
+	policy, err = NewTLSPolicy(false, "", false, "", hex.EncodeToString(make([]byte, sha256.Size)))
+	assert.Nil(err)
+	assert.NotNil(policy.VerifyPeer([]*x509.Certificate{certificate}, "node1.lab", roots))
+
+	// Pins could not be enforced on SDK connections that skip verification
+	_, err = NewTLSPolicy(false, "", true, "spiffe://lab/couchbase", "")
+	assert.True(errors.Is(err, ErrPinsWithoutHostnameVerification))
+	_, err = NewTLSPolicy(false, "", true, "", hex.EncodeToString(fingerprint[:]))
+	assert.True(errors.Is(err, ErrPinsWithoutHostnameVerification))
+
+	_, err = NewTLSPolicy(false, "", false, "", "not hex")
+	assert.NotNil(err)
+	_, err = NewTLSPolicy(false, "/nonexistent/ca.pem", false, "", "")
+	assert.NotNil(err)
+
+	fmt.Println("============== Test case end: TestTLSPolicyVerifyPeer =================")
+}
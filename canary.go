@@ -0,0 +1,150 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"xdcrDiffer/base"
+	"xdcrDiffer/results"
+	"xdcrDiffer/utils"
+
+	"github.com/couchbase/gocb/v2"
+	xdcrBase "github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/metadata"
+)
+
+// How often the target is polled for the canary document
+const canaryPollInterval = 50 * time.Millisecond
+
+type canaryDoc struct {
+	WrittenAt time.Time `json:"writtenAt"`
+}
+
+// Writes a canary document to the source and polls the target until it shows up
+func (difftool *xdcrDiffTool) measureCanaryLatency() error {
+	targetCollection := options.canaryTargetCollection
+	if targetCollection == "" {
+		targetCollection = options.canaryCollection
+	}
+
+	sourceCol, closeSource, err := openCanaryCollection(options.sourceUrl, difftool.verificationRef(true), false,
+		difftool.specifiedSpec.SourceBucketName, options.canaryCollection)
+	if err != nil {
+		return fmt.Errorf("source: %v", err)
+	}
+	defer closeSource()
+	targetCol, closeTarget, err := openCanaryCollection(difftool.specifiedRef.HostName_, difftool.verificationRef(false), true,
+		difftool.specifiedSpec.TargetBucketName, targetCollection)
+	if err != nil {
+		return fmt.Errorf("target: %v", err)
+	}
+	defer closeTarget()
+
+	key := base.CanaryKeyPrefix + strconv.FormatInt(time.Now().UnixNano(), 10)
+	latency := &results.CanaryLatency{
+		SourceCollection: options.canaryCollection,
+		TargetCollection: targetCollection,
+		Key:              key,
+	}
+	_, err = sourceCol.Upsert(key, &canaryDoc{WrittenAt: time.Now()}, &gocb.UpsertOptions{
+		Expiry: base.CanaryExpirySeconds * time.Second,
+	})
+	if err != nil {
+		return fmt.Errorf("writing canary %v: %v", key, err)
+	}
+	written := time.Now()
+
+	deadline := written.Add(time.Duration(options.canaryTimeoutSecs) * time.Second)
+	for {
+		_, err = targetCol.Get(key, nil)
+		if err == nil {
+			latency.Latency = time.Since(written)
+			break
+		}
+		if !errors.Is(err, gocb.ErrDocumentNotFound) {
+			difftool.logger.Warnf("Error polling the target for canary %v. err=%v\n", key, err)
+		}
+		if time.Now().After(deadline) {
+			latency.TimedOut = true
+			break
+		}
+		time.Sleep(canaryPollInterval)
+	}
+
+	difftool.canaryLatency = latency
+	if latency.TimedOut {
+		difftool.logger.Warnf("Canary %v written to source %v was not replicated to target %v within %v seconds\n",
+			key, options.canaryCollection, targetCollection, options.canaryTimeoutSecs)
+	} else {
+		difftool.logger.Infof("Canary %v was replicated from source %v to target %v in %v\n",
+			key, options.canaryCollection, targetCollection, latency.Latency)
+	}
+	return nil
+}
+
+// Connects the same way as the DCP drivers do. Client certificates are only used for the target
+func openCanaryCollection(url string, ref *metadata.RemoteClusterReference, isTarget bool, bucketName, namespace string) (*gocb.Collection, func(), error) {
+	scopeAndCollection := strings.Split(namespace, xdcrBase.ScopeCollectionDelimiter)
+	if len(scopeAndCollection) != 2 {
+		return nil, nil, fmt.Errorf("%v is not of the form scope.collection", namespace)
+	}
+
+	clusterOpts := gocb.ClusterOptions{}
+	if isTarget && len(ref.ClientCertificate()) > 0 && len(ref.ClientKey()) > 0 {
+		tlsCert, err := tls.X509KeyPair(ref.ClientCertificate(), ref.ClientKey())
+		if err != nil {
+			return nil, nil, err
+		}
+		clusterOpts.Authenticator = gocb.CertificateAuthenticator{ClientCertificate: &tlsCert}
+	} else {
+		clusterOpts.Authenticator = gocb.PasswordAuthenticator{
+			Username: ref.UserName(),
+			Password: ref.Password(),
+		}
+	}
+
+	ports := base.PortsOf(!isTarget)
+	secure := ref.HttpAuthMech() == xdcrBase.HttpAuthMechHttps
+	cccpString := utils.PopulateCCCPConnectString(url, ports.KvPort(secure))
+	if secure {
+		cccpString = fmt.Sprintf("%v%v", base.CouchbaseSecurePrefix, strings.TrimPrefix(cccpString, base.CouchbasePrefix))
+		if base.TLSVerification != nil {
+			rootCAs, err := base.TLSVerification.CertPool(ref.Certificates())
+			if err != nil {
+				return nil, nil, err
+			}
+			clusterOpts.SecurityConfig = gocb.SecurityConfig{
+				TLSRootCAs:    rootCAs,
+				TLSSkipVerify: base.TLSVerification.SDKSkipsVerification(),
+			}
+		}
+	}
+
+	cluster, err := gocb.Connect(ports.TagOptions(cccpString), clusterOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+	closeFunc := func() {
+		cluster.Close(nil)
+	}
+
+	bucket := cluster.Bucket(bucketName)
+	err = bucket.WaitUntilReady(time.Duration(base.SetupTimeoutSeconds)*time.Second, nil)
+	if err != nil {
+		closeFunc()
+		return nil, nil, err
+	}
+	return bucket.Scope(scopeAndCollection[0]).Collection(scopeAndCollection[1]), closeFunc, nil
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"xdcrDiffer/differ"
+	"xdcrDiffer/utils"
+)
+
+const captureCommand = "capture"
+const captureVerifyCommand = "verify"
+const captureExportCommand = "export"
+
+// Under the output directory of capture export, the CSV of a vbucket, i.e. vb_0.csv
+const captureExportFileNameFormat = "vb_%v.csv"
+
+func runCaptureCommand(args []string) error {
+	if len(args) > 0 && args[0] == captureVerifyCommand {
+		return runCaptureVerifyCommand(args[1:])
+	}
+	if len(args) > 0 && args[0] == captureExportCommand {
+		return runCaptureExportCommand(args[1:])
+	}
+	return fmt.Errorf("Usage: %v %v %v|%v [OPTIONS] captureDir", os.Args[0], captureCommand, captureVerifyCommand, captureExportCommand)
+}
+
+// Reads back a capture directory before it is diffed or handed over, i.e.
+//
+//	xdcrDiffer capture verify -vbuckets 0-63 source
+//
+// Every record is read as the file differ would read it and its checksum is validated. The number of records,
+// distinct keys of each collection and the lowest and highest seqnos are printed for each vbucket
+func runCaptureVerifyCommand(args []string) error {
+	flags := flag.NewFlagSet(captureCommand+" "+captureVerifyCommand, flag.ExitOnError)
+	vbuckets := flags.String("vbuckets", "",
+		"vbuckets to verify, i.e. 0-63,100. Default is all those found")
+	jsonOutput := flags.Bool("json", false,
+		"Print the summary of each vbucket as JSON")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("%v %v takes the capture directory to verify", captureCommand, captureVerifyCommand)
+	}
+	fileDir := flags.Arg(0)
+	vbList, filesByVb, err := captureVbuckets(fileDir, *vbuckets)
+	if err != nil {
+		return err
+	}
+
+	var summaries []*differ.CaptureVbSummary
+	var corrupted, records int
+	keysByCollection := make(map[uint32]int)
+	for _, vbno := range vbList {
+		summary, err := differ.VerifyCaptureVbucket(vbno, filesByVb[vbno])
+		if err != nil {
+			return err
+		}
+		summaries = append(summaries, summary)
+		corrupted += len(summary.Corrupted)
+		records += summary.Records
+		for colId, keys := range summary.Keys {
+			keysByCollection[colId] += keys
+		}
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(summaries); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("%6v %6v %10v %10v %12v %12v\n", "vb", "files", "records", "keys", "lowSeqno", "highSeqno")
+		for _, summary := range summaries {
+			var keys int
+			for _, colKeys := range summary.Keys {
+				keys += colKeys
+			}
+			fmt.Printf("%6v %6v %10v %10v %12v %12v\n", summary.Vbno, summary.Files, summary.Records, keys,
+				summary.LowSeqno, summary.HighSeqno)
+			if !summary.Checksummed && summary.Files > 0 {
+				fmt.Printf("       records of vbucket %v are not checksummed, and were only checked to parse\n", summary.Vbno)
+			}
+			for fileName, reason := range summary.Corrupted {
+				fmt.Printf("       %v is corrupted: %v\n", fileName, reason)
+			}
+		}
+		colIds := make([]uint32, 0, len(keysByCollection))
+		for colId := range keysByCollection {
+			colIds = append(colIds, colId)
+		}
+		sort.Slice(colIds, func(i, j int) bool { return colIds[i] < colIds[j] })
+		for _, colId := range colIds {
+			fmt.Printf("Collection ID %v: %v keys\n", colId, keysByCollection[colId])
+		}
+	}
+
+	if corrupted > 0 {
+		return fmt.Errorf("%v capture files of %v are corrupted", corrupted, fileDir)
+	}
+	if !*jsonOutput {
+		fmt.Printf("%v records in %v vbuckets of %v read back\n", records, len(summaries), fileDir)
+	}
+	return nil
+}
+
+// The capture files of each vbucket of a directory, and the vbuckets asked for, or all those found if none are
+func captureVbuckets(fileDir, vbuckets string) ([]uint16, map[uint16][]string, error) {
+	vbList, err := utils.ParseVbucketList(vbuckets)
+	if err != nil {
+		return nil, nil, err
+	}
+	filesByVb, err := differ.CaptureFilesByVbucket(fileDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(vbList) == 0 {
+		for vbno := range filesByVb {
+			vbList = append(vbList, vbno)
+		}
+		sort.Slice(vbList, func(i, j int) bool { return vbList[i] < vbList[j] })
+	}
+	return vbList, filesByVb, nil
+}
+
+// Converts a capture directory into a CSV file per vbucket, for analysis with other tools, i.e.
+//
+//	xdcrDiffer capture export -output sourceCsv -vbuckets 0-63 source
+//
+// Each row is a record of the capture, with the key, seqno, CAS, revId, datatype and hash of the value of the document
+func runCaptureExportCommand(args []string) error {
+	flags := flag.NewFlagSet(captureCommand+" "+captureExportCommand, flag.ExitOnError)
+	vbuckets := flags.String("vbuckets", "",
+		"vbuckets to export, i.e. 0-63,100. Default is all those found")
+	output := flags.String("output", "captureExport",
+		"Directory to write the CSV file of each vbucket to")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("%v %v takes the capture directory to export", captureCommand, captureExportCommand)
+	}
+	fileDir := flags.Arg(0)
+	vbList, filesByVb, err := captureVbuckets(fileDir, *vbuckets)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(*output, 0777); err != nil {
+		return err
+	}
+
+	var rows int
+	for _, vbno := range vbList {
+		vbRows, err := exportCaptureVbucket(filepath.Join(*output, fmt.Sprintf(captureExportFileNameFormat, vbno)), filesByVb[vbno])
+		if err != nil {
+			return fmt.Errorf("Unable to export vbucket %v: %v", vbno, err)
+		}
+		rows += vbRows
+	}
+	fmt.Printf("%v records in %v vbuckets of %v exported to %v\n", rows, len(vbList), fileDir, *output)
+	return nil
+}
+
+func exportCaptureVbucket(fileName string, captureFiles []string) (int, error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	if err = writer.Write(differ.CaptureExportColumns); err != nil {
+		return 0, err
+	}
+	return differ.ExportCaptureVbucket(captureFiles, writer)
+}
@@ -0,0 +1,294 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+	"xdcrDiffer/base"
+	"xdcrDiffer/differ"
+	"xdcrDiffer/results"
+
+	xdcrLog "github.com/couchbase/goxdcr/log"
+)
+
+const catalogCommand = "catalog"
+const catalogListCommand = "list"
+const catalogTagCommand = "tag"
+const catalogDiffCommand = "diff"
+
+const defaultCatalogDir = "captureCatalog"
+
+func runCatalogCommand(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case catalogListCommand:
+			return runCatalogListCommand(args[1:])
+		case catalogTagCommand:
+			return runCatalogTagCommand(args[1:])
+		case catalogDiffCommand:
+			return runCatalogDiffCommand(args[1:])
+		}
+	}
+	return fmt.Errorf("Usage: %v %v %v|%v|%v [OPTIONS]", os.Args[0], catalogCommand, catalogListCommand, catalogTagCommand,
+		catalogDiffCommand)
+}
+
+// Lists the cataloged captures in the order they were taken, i.e.
+//
+//	xdcrDiffer catalog list -catalog captureCatalog
+func runCatalogListCommand(args []string) error {
+	flags := flag.NewFlagSet(catalogCommand+" "+catalogListCommand, flag.ExitOnError)
+	catalogDir := flags.String("catalog", defaultCatalogDir, "Directory of the catalog")
+	jsonOutput := flags.Bool("json", false, "Print the catalog as JSON")
+	flags.Parse(args)
+
+	catalog, err := results.ReadCaptureCatalog(*catalogDir)
+	if err != nil {
+		return err
+	}
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(catalog)
+	}
+	if len(catalog.Captures) == 0 {
+		fmt.Printf("Nothing is cataloged in %v\n", *catalogDir)
+		return nil
+	}
+	fmt.Printf("%-20v %-12v %-12v %-25v %8v %10v %12v\n", "tag", "cluster", "bucket", "capturedAt", "vbuckets", "records", "seqnos")
+	for _, capture := range catalog.Captures {
+		fmt.Printf("%-20v %-12v %-12v %-25v %8v %10v %12v\n", capture.Tag, capture.Cluster, capture.Bucket,
+			capture.CapturedAt.Format(time.RFC3339), len(capture.Seqnos), capture.Records, capture.SeqnoCount())
+		if capture.Note != "" {
+			fmt.Printf("    %v\n", capture.Note)
+		}
+	}
+	return nil
+}
+
+// Tags a capture directory, i.e.
+//
+//	xdcrDiffer catalog tag -cluster source -bucket travel-sample pre-upgrade source
+//
+// The capture is read back in full, and refused if it is corrupted. Its files are linked into the catalog, or copied
+// where they cannot be, so that the snapshot outlives the next run that captures into the same directory
+func runCatalogTagCommand(args []string) error {
+	flags := flag.NewFlagSet(catalogCommand+" "+catalogTagCommand, flag.ExitOnError)
+	catalogDir := flags.String("catalog", defaultCatalogDir, "Directory of the catalog")
+	cluster := flags.String("cluster", "", "Label of the cluster captured. Default is the name of the capture directory")
+	bucket := flags.String("bucket", "", "Bucket captured")
+	note := flags.String("note", "", "Free form note to keep with the tag")
+	replace := flags.Bool("replace", false, "Whether to replace the capture of a tag that is already in use")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("%v %v takes the tag and the capture directory to tag", catalogCommand, catalogTagCommand)
+	}
+	tag, fileDir := flags.Arg(0), flags.Arg(1)
+	if err := results.ValidateCatalogTag(tag); err != nil {
+		return err
+	}
+	catalog, err := results.ReadCaptureCatalog(*catalogDir)
+	if err != nil {
+		return err
+	}
+	if catalog.Find(tag) != nil && !*replace {
+		return fmt.Errorf("tag %v is already in use. Use -replace to replace its capture", tag)
+	}
+
+	capture, err := describeCapture(fileDir)
+	if err != nil {
+		return err
+	}
+	capture.Tag = tag
+	capture.Cluster = *cluster
+	if capture.Cluster == "" {
+		absDir, err := filepath.Abs(fileDir)
+		if err != nil {
+			return err
+		}
+		capture.Cluster = filepath.Base(absDir)
+	}
+	capture.Bucket = *bucket
+	capture.Note = *note
+
+	// Staged next to the snapshot it replaces, so that a failed link leaves the catalog as it was
+	snapshotDir := results.CatalogSnapshotDir(*catalogDir, tag)
+	stagingDir := snapshotDir + ".staging"
+	if err = os.RemoveAll(stagingDir); err != nil {
+		return err
+	}
+	if _, err = base.LinkCaptureFiles(fileDir, stagingDir); err != nil {
+		os.RemoveAll(stagingDir)
+		return fmt.Errorf("Unable to keep the capture files of %v in the catalog: %v", fileDir, err)
+	}
+	if err = os.RemoveAll(snapshotDir); err != nil {
+		return err
+	}
+	if err = os.Rename(stagingDir, snapshotDir); err != nil {
+		return err
+	}
+	if err = catalog.Add(capture, *replace); err != nil {
+		return err
+	}
+	if err = catalog.Write(*catalogDir); err != nil {
+		return err
+	}
+	fmt.Printf("%v records in %v vbuckets of %v tagged %v\n", capture.Records, len(capture.Seqnos), fileDir, tag)
+	return nil
+}
+
+// Reads back every vbucket of a capture directory to describe it in the catalog
+func describeCapture(fileDir string) (*results.CatalogedCapture, error) {
+	vbList, filesByVb, err := captureVbuckets(fileDir, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(vbList) == 0 {
+		return nil, fmt.Errorf("%v holds no capture files", fileDir)
+	}
+	capture := &results.CatalogedCapture{
+		TaggedAt: time.Now().UTC(),
+		Keys:     make(map[uint32]int),
+		Seqnos:   make(map[uint16]results.SeqnoRange),
+	}
+	if capture.CapturedFrom, err = filepath.Abs(fileDir); err != nil {
+		return nil, err
+	}
+	for _, vbno := range vbList {
+		summary, err := differ.VerifyCaptureVbucket(vbno, filesByVb[vbno])
+		if err != nil {
+			return nil, err
+		}
+		if len(summary.Corrupted) > 0 {
+			return nil, fmt.Errorf("capture files of vbucket %v of %v are corrupted: %v", vbno, fileDir, summary.Corrupted)
+		}
+		capture.Records += summary.Records
+		for colId, keys := range summary.Keys {
+			capture.Keys[colId] += keys
+		}
+		capture.Seqnos[vbno] = results.SeqnoRange{Low: summary.LowSeqno, High: summary.HighSeqno}
+		if summary.Files > capture.Bins {
+			capture.Bins = summary.Files
+		}
+		for _, fileName := range filesByVb[vbno] {
+			fileInfo, err := os.Stat(fileName)
+			if err != nil {
+				return nil, err
+			}
+			if fileInfo.ModTime().After(capture.CapturedAt) {
+				capture.CapturedAt = fileInfo.ModTime().UTC()
+			}
+		}
+	}
+	return capture, nil
+}
+
+// Diffs two cataloged captures with the file differ, the first as the source, i.e.
+//
+//	xdcrDiffer catalog diff -output upgradeDiff pre-upgrade post-upgrade
+//
+// Both are diffed as a whole, each collection against the collection of the same ID, and the number of keys of
+// each category found is printed. The output can be queried further with the results command
+func runCatalogDiffCommand(args []string) error {
+	flags := flag.NewFlagSet(catalogCommand+" "+catalogDiffCommand, flag.ExitOnError)
+	catalogDir := flags.String("catalog", defaultCatalogDir, "Directory of the catalog")
+	output := flags.String("output", "", "Directory to write the file differ output to. Default is catalogDiff_<tag1>_<tag2>")
+	numberOfWorkers := flags.Int("numberOfWorkers", 4, "Number of file differ workers")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("%v %v takes the two tags to diff", catalogCommand, catalogDiffCommand)
+	}
+	catalog, err := results.ReadCaptureCatalog(*catalogDir)
+	if err != nil {
+		return err
+	}
+	var captures [2]*results.CatalogedCapture
+	for i := range captures {
+		if captures[i] = catalog.Find(flags.Arg(i)); captures[i] == nil {
+			return fmt.Errorf("tag %v is not in the catalog of %v", flags.Arg(i), *catalogDir)
+		}
+	}
+	// Keys are binned by the number of bins they were captured with, so those of differently binned captures
+	// cannot be matched up
+	if captures[0].Bins != captures[1].Bins {
+		return fmt.Errorf("%v was captured into %v files per vbucket and %v into %v, so they cannot be diffed",
+			captures[0].Tag, captures[0].Bins, captures[1].Tag, captures[1].Bins)
+	}
+	if *output == "" {
+		*output = fmt.Sprintf("catalogDiff_%v_%v", captures[0].Tag, captures[1].Tag)
+	}
+	if err = os.MkdirAll(*output, 0777); err != nil {
+		return err
+	}
+
+	collectionMapping := make(map[uint32][]uint32)
+	vbucketSet := make(map[uint16]bool)
+	for _, capture := range captures {
+		for colId := range capture.Keys {
+			collectionMapping[colId] = []uint32{colId}
+		}
+		for vbno := range capture.Seqnos {
+			vbucketSet[vbno] = true
+		}
+	}
+	vbuckets := make([]uint16, 0, len(vbucketSet))
+	for vbno := range vbucketSet {
+		vbuckets = append(vbuckets, vbno)
+	}
+	sort.Slice(vbuckets, func(i, j int) bool { return vbuckets[i] < vbuckets[j] })
+
+	logger := xdcrLog.NewLogger("xdcrDiffTool", xdcrLog.DefaultLoggerContext)
+	driver := differ.NewDifferDriver(results.CatalogSnapshotDir(*catalogDir, captures[0].Tag),
+		results.CatalogSnapshotDir(*catalogDir, captures[1].Tag), *output, base.DiffKeysFileName, *numberOfWorkers,
+		captures[0].Bins, 0, collectionMapping, nil, nil, "", "", nil, nil, logger, vbuckets)
+	if err = driver.Run(); err != nil {
+		return fmt.Errorf("File differ failed: %v", err)
+	}
+	if corruptedVbs := driver.CorruptedVbs(); len(corruptedVbs) > 0 {
+		return fmt.Errorf("Capture files of vbuckets %v were found to be corrupted", corruptedVbs)
+	}
+
+	page, err := results.Run(results.PhaseFileDiff, filepath.Join(*output, base.DiffDetailsFileName+base.FileNameDelimiter+"*"),
+		&results.Query{})
+	if err != nil {
+		return err
+	}
+	counts := make(map[string]int)
+	for _, entry := range page.Entries {
+		category := entry.Category
+		if entry.Subcategory != "" {
+			category = entry.Subcategory
+		}
+		counts[category]++
+	}
+	categories := make([]string, 0, len(counts))
+	for category := range counts {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	fmt.Printf("%v (%v documents) diffed against %v (%v documents) in %v vbuckets\n", captures[0].Tag,
+		driver.SourceItemCount(), captures[1].Tag, driver.TargetItemCount(), len(vbuckets))
+	for _, category := range categories {
+		fmt.Printf("%v: %v\n", category, counts[category])
+	}
+	if len(categories) == 0 {
+		fmt.Printf("No differences found\n")
+	}
+	fmt.Printf("Details are in %v\n", *output)
+	return nil
+}
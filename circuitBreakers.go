@@ -0,0 +1,67 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"xdcrDiffer/base"
+)
+
+// Breakers are only set up when asked for, so that by default batches are retried to exhaustion as before
+func (difftool *xdcrDiffTool) setUpCircuitBreakers() {
+	if options.circuitBreakerPercent == 0 && options.retryBudgetPercent == 0 {
+		return
+	}
+	newBreaker := func(cluster string) *base.CircuitBreaker {
+		var breaker *base.CircuitBreaker
+		breaker = base.NewCircuitBreaker(cluster, options.circuitBreakerPercent, options.retryBudgetPercent,
+			func(reason string) { difftool.circuitBreakerTripped(breaker, reason) })
+		return breaker
+	}
+	difftool.sourceBreaker = newBreaker(base.SourceClusterLabel)
+	difftool.targetBreaker = newBreaker(base.TargetClusterLabel)
+}
+
+// Called once the breaker opens. Paused, the breaker is closed again so that the run carries on once resumed.
+// Aborting, it is left open, so that the keys left are listed as keys with error without being sent
+func (difftool *xdcrDiffTool) circuitBreakerTripped(breaker *base.CircuitBreaker, reason string) {
+	cluster := breaker.Cluster()
+	if options.circuitBreakerAction == base.CircuitBreakerAbort {
+		difftool.logger.Errorf("Circuit breaker opened, as %v. Aborting the mutation differ: the keys not verified are listed in %v, and can be verified once %v has recovered with -diffKeysSource\n",
+			reason, base.DiffErrorKeysFileName, cluster)
+		return
+	}
+	difftool.logger.Errorf("Circuit breaker opened, as %v. Pausing the run until it is resumed once %v has recovered, with SIGUSR2 or POST /control/resume on controlListen\n",
+		reason, cluster)
+	difftool.pause(fmt.Sprintf("circuit breaker of %v", cluster))
+	// Batches are held back by the pause from here on
+	breaker.Reset()
+}
+
+// The cluster whose breaker was left open, if any
+func (difftool *xdcrDiffTool) openCircuit() string {
+	for _, breaker := range []*base.CircuitBreaker{difftool.sourceBreaker, difftool.targetBreaker} {
+		if breaker.Open() != nil {
+			return breaker.Cluster()
+		}
+	}
+	return ""
+}
+
+func (difftool *xdcrDiffTool) printCircuitBreakers() {
+	for _, breaker := range []*base.CircuitBreaker{difftool.sourceBreaker, difftool.targetBreaker} {
+		if breaker == nil {
+			continue
+		}
+		trips, retries, operations := breaker.Status()
+		fmt.Printf("Circuit breaker of %v: opened %v times, %v retries out of %v operations\n",
+			breaker.Cluster(), trips, retries, operations)
+	}
+}
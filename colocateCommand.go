@@ -0,0 +1,300 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"xdcrDiffer/base"
+	"xdcrDiffer/colocation"
+	"xdcrDiffer/utils"
+
+	xdcrBase "github.com/couchbase/goxdcr/base"
+	xdcrLog "github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+	xdcrUtils "github.com/couchbase/goxdcr/utils"
+)
+
+const colocateCommand = "colocate"
+const colocateCoordinateCommand = "coordinate"
+const colocateAgentCommand = "agent"
+
+const (
+	colocateAssignmentPath = "/colocate/assignment"
+	colocateReportPath     = "/colocate/report"
+	colocateStatusPath     = "/colocate/status"
+)
+
+// Under the run directory of an agent, the output of its run
+const colocateRunLogFileName = "xdcrDiffer.log"
+
+// A report that does not reach the coordinator is retried, so that a finished run is not taken as still running
+const colocateReportRetries = 5
+const colocateReportRetryInterval = 10 * time.Second
+
+func runColocateCommand(args []string) error {
+	if len(args) > 0 && args[0] == colocateCoordinateCommand {
+		return runColocateCoordinateCommand(args[1:])
+	}
+	if len(args) > 0 && args[0] == colocateAgentCommand {
+		return runColocateAgentCommand(args[1:])
+	}
+	return fmt.Errorf("Usage: %v %v %v|%v [OPTIONS]", os.Args[0], colocateCommand, colocateCoordinateCommand, colocateAgentCommand)
+}
+
+// Splits a run across agents co-located with the KV nodes of the source cluster, i.e.
+//
+//	xdcrDiffer colocate coordinate -listen 0.0.0.0:8767 -- -sourceUrl 10.0.0.1:8091 -sourceBucketName orders ...
+//
+// The options after "--" are those of the run. The vbucket map of the source bucket is looked up with them, and the
+// agent of each node is handed the vbuckets whose active copy the node holds. Returns once every agent has finished
+func runColocateCoordinateCommand(args []string) error {
+	flags := flag.NewFlagSet(colocateCommand+" "+colocateCoordinateCommand, flag.ExitOnError)
+	listen := flags.String("listen", "0.0.0.0:8767", "address to serve the agents on")
+	statusFile := flags.String("statusFile", "colocateStatus.json", "file the state of every agent is written to whenever it changes")
+	flags.Parse(args)
+
+	runArgs := flags.Args()
+	if len(runArgs) == 0 {
+		return fmt.Errorf("%v %v takes the options of the run after \"--\"", colocateCommand, colocateCoordinateCommand)
+	}
+	owners, err := lookUpVbucketOwners(runArgs)
+	if err != nil {
+		return err
+	}
+	for _, node := range sortedNodes(owners) {
+		fmt.Printf("%v owns vbuckets %v\n", node, utils.FormatVbucketList(owners[node]))
+	}
+
+	var coordinator *colocation.Coordinator
+	onChange := func() {
+		status := coordinator.Status()
+		fmt.Printf("%v: %v done, %v failed, %v running, %v pending\n", time.Now().Format(time.RFC3339),
+			status.Done, status.Failed, status.Running, status.Pending)
+		statusBytes, err := json.MarshalIndent(status, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(*statusFile, statusBytes, 0644)
+		}
+		if err != nil {
+			fmt.Printf("Unable to write %v. err=%v\n", *statusFile, err)
+		}
+	}
+	coordinator = colocation.NewCoordinator(owners, runArgs, onChange)
+	server := serveColocation(*listen, coordinator)
+	fmt.Printf("Waiting for the agents of %v nodes on http://%v\n", len(owners), *listen)
+	<-coordinator.Finished()
+	// Lets the last report be answered, so that its agent does not retry it
+	server.Shutdown(context.Background())
+
+	status := coordinator.Status()
+	for _, agent := range status.Agents {
+		if agent.State == colocation.AgentFailed {
+			fmt.Printf("Agent of %v failed: %v\n", agent.Node, agent.Error)
+			continue
+		}
+		fmt.Printf("Agent of %v finished in %v, with its output in %v\n", agent.Node,
+			agent.Finished.Sub(agent.Started).Round(time.Second), agent.RunDir)
+	}
+	fmt.Printf("Once gathered on one host, the run directories of the agents can be combined with %v %v -output merged <runDirs>\n",
+		os.Args[0], mergeCommand)
+	if status.Failed > 0 {
+		return fmt.Errorf("%v of %v agents failed", status.Failed, len(status.Agents))
+	}
+	return nil
+}
+
+// The run options are parsed as a run would parse them, for the source cluster and bucket, and the vbuckets to verify
+func lookUpVbucketOwners(runArgs []string) (map[string][]uint16, error) {
+	os.Args = append(os.Args[:1:1], runArgs...)
+	argParse()
+	vbuckets, err := utils.ParseVbucketList(options.vbuckets)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := metadata.NewRemoteClusterReference("", base.SelfReferenceName, options.sourceUrl, options.sourceUsername, options.sourcePassword,
+		"", false, "", nil, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	connStr, err := ref.MyConnectionStr()
+	if err != nil {
+		return nil, err
+	}
+	logger := xdcrLog.NewLogger("xdcrDiffTool", xdcrLog.DefaultLoggerContext)
+	bucketInfo, err := xdcrUtils.NewUtilities().GetClusterInfo(connStr, xdcrBase.DefaultPoolBucketsPath+options.sourceBucketName, ref.UserName(),
+		ref.Password(), ref.HttpAuthMech(), ref.Certificates(), ref.SANInCertificate(), ref.ClientCertificate(), ref.ClientKey(), logger)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to look up bucket %v on %v: %v", options.sourceBucketName, options.sourceUrl, err)
+	}
+	serverList, vbucketMap, err := utils.GetVbucketServerMapFromBucketInfo(options.sourceBucketName, bucketInfo)
+	if err != nil {
+		return nil, err
+	}
+	return colocation.VbucketOwners(serverList, vbucketMap, vbuckets)
+}
+
+func sortedNodes(owners map[string][]uint16) []string {
+	nodes := make([]string, 0, len(owners))
+	for node := range owners {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+func serveColocation(listen string, coordinator *colocation.Coordinator) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(colocateAssignmentPath, func(w http.ResponseWriter, r *http.Request) {
+		assignment, err := coordinator.Assign(r.URL.Query().Get("node"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(assignment)
+	})
+	mux.HandleFunc(colocateReportPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST is accepted", http.StatusMethodNotAllowed)
+			return
+		}
+		report := &colocation.Report{}
+		if err := json.NewDecoder(r.Body).Decode(report); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := coordinator.Report(report); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+		}
+	})
+	mux.HandleFunc(colocateStatusPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(coordinator.Status())
+	})
+	server := &http.Server{Addr: listen, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("Colocation endpoints stopped. err=%v\n", err)
+			os.Exit(1)
+		}
+	}()
+	return server
+}
+
+// Runs on a KV node of the source cluster, streaming only the vbuckets the node owns, i.e.
+//
+//	xdcrDiffer colocate agent -coordinator http://10.0.0.100:8767 -node 10.0.0.1
+//
+// The run is started with the options the coordinator hands out, bootstrapping from the node itself, and its output
+// is left in runDir for it to be gathered and merged
+func runColocateAgentCommand(args []string) error {
+	flags := flag.NewFlagSet(colocateCommand+" "+colocateAgentCommand, flag.ExitOnError)
+	coordinatorUrl := flags.String("coordinator", "", "URL of the coordinator, i.e. http://10.0.0.100:8767")
+	node := flags.String("node", "", "host of this node, as it appears in the server list of the bucket")
+	localUrl := flags.String("localUrl", "127.0.0.1:8091", "URL of the cluster manager of this node, to bootstrap from")
+	runDir := flags.String("runDir", "colocate", "directory to start the run in")
+	flags.Parse(args)
+
+	if *coordinatorUrl == "" || *node == "" {
+		return fmt.Errorf("%v %v requires the coordinator and the host of this node", colocateCommand, colocateAgentCommand)
+	}
+	coordinatorBase := strings.TrimSuffix(*coordinatorUrl, "/")
+	resp, err := http.Get(coordinatorBase + colocateAssignmentPath + "?node=" + url.QueryEscape(*node))
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Coordinator refused to assign vbuckets to %v: %v", *node, strings.TrimSpace(string(body)))
+	}
+	assignment := &colocation.Assignment{}
+	if err = json.Unmarshal(body, assignment); err != nil {
+		return err
+	}
+
+	report := &colocation.Report{Node: *node, State: colocation.AgentDone}
+	if report.RunDir, err = filepath.Abs(*runDir); err != nil {
+		return err
+	}
+	fmt.Printf("Streaming vbuckets %v of %v, with the output of the run in %v\n", utils.FormatVbucketList(assignment.Vbuckets),
+		*node, filepath.Join(report.RunDir, colocateRunLogFileName))
+	if err = runColocatedAgent(report.RunDir, *localUrl, assignment); err != nil {
+		report.State = colocation.AgentFailed
+		report.Error = err.Error()
+	}
+	if reportErr := reportToCoordinator(coordinatorBase, report); reportErr != nil {
+		return fmt.Errorf("Unable to report the run as %v to the coordinator: %v", report.State, reportErr)
+	}
+	return err
+}
+
+// Options of the agent come after those handed out, so that they take precedence
+func runColocatedAgent(runDir, localUrl string, assignment *colocation.Assignment) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(runDir, 0777); err != nil {
+		return err
+	}
+	logFile, err := os.Create(filepath.Join(runDir, colocateRunLogFileName))
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	args := append([]string{}, assignment.Args...)
+	args = append(args, "-sourceUrl", localUrl, "-vbuckets", utils.FormatVbucketList(assignment.Vbuckets))
+	cmd := exec.Command(executable, args...)
+	cmd.Dir = runDir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	return cmd.Run()
+}
+
+func reportToCoordinator(coordinatorBase string, report *colocation.Report) error {
+	reportBytes, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	for i := 0; ; i++ {
+		var resp *http.Response
+		resp, err = http.Post(coordinatorBase+colocateReportPath, "application/json", bytes.NewReader(reportBytes))
+		if err == nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			// Refused, i.e. as the agent was restarted, which retrying does not change
+			return fmt.Errorf("%v", strings.TrimSpace(string(body)))
+		}
+		if i+1 >= colocateReportRetries {
+			return err
+		}
+		time.Sleep(colocateReportRetryInterval)
+	}
+}
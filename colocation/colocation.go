@@ -0,0 +1,211 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package colocation splits a run across agents co-located with the KV nodes of the source cluster, each streaming
+// only the vbuckets its node owns, and tracks the agents from a central coordinator
+package colocation
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// States of an agent
+const (
+	// Has not asked for its vbuckets yet
+	AgentPending = "pending"
+	AgentRunning = "running"
+	AgentDone    = "done"
+	AgentFailed  = "failed"
+)
+
+// What the agent of a node runs
+type Assignment struct {
+	// Host of the node, as in the server list of the bucket
+	Node     string
+	Vbuckets []uint16
+	// Options of the run, i.e. the clusters, buckets and remote cluster reference
+	Args []string
+}
+
+// What an agent reports once its run has finished
+type Report struct {
+	Node  string
+	State string
+	// Where the run was started on the host of the agent, for its output to be gathered from
+	RunDir string
+	Error  string `json:",omitempty"`
+}
+
+type AgentStatus struct {
+	Node     string
+	Vbuckets int
+	State    string
+	Started  time.Time
+	Finished time.Time
+	RunDir   string `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+type Status struct {
+	Pending int
+	Running int
+	Done    int
+	Failed  int
+	Agents  []*AgentStatus
+}
+
+// Maps each vbucket to the host of the node holding its active copy
+// Returns host -> the vbuckets it owns, of those asked for, or all vbuckets of the map if none are
+func VbucketOwners(serverList []string, vbucketMap [][]int, vbuckets []uint16) (map[string][]uint16, error) {
+	if len(vbuckets) == 0 {
+		for vbno := range vbucketMap {
+			vbuckets = append(vbuckets, uint16(vbno))
+		}
+	}
+	owners := make(map[string][]uint16)
+	for _, vbno := range vbuckets {
+		if int(vbno) >= len(vbucketMap) {
+			return nil, fmt.Errorf("vbucket %v is not in the vbucket map of %v vbuckets", vbno, len(vbucketMap))
+		}
+		if len(vbucketMap[vbno]) == 0 || vbucketMap[vbno][0] < 0 || vbucketMap[vbno][0] >= len(serverList) {
+			return nil, fmt.Errorf("vbucket %v has no active copy", vbno)
+		}
+		host, _, err := net.SplitHostPort(serverList[vbucketMap[vbno][0]])
+		if err != nil {
+			return nil, err
+		}
+		owners[host] = append(owners[host], vbno)
+	}
+	for _, vbnos := range owners {
+		sort.Slice(vbnos, func(i, j int) bool { return vbnos[i] < vbnos[j] })
+	}
+	return owners, nil
+}
+
+// Hands out the vbuckets of each node to its agent, and tracks the agents until all have finished
+type Coordinator struct {
+	args     []string
+	owners   map[string][]uint16
+	onChange func()
+
+	lock   sync.Mutex
+	agents map[string]*AgentStatus
+	// Set once every agent has finished, after which none is assigned anything
+	allFinished bool
+	finished    chan bool
+}
+
+// onChange is called whenever an agent starts or finishes
+func NewCoordinator(owners map[string][]uint16, args []string, onChange func()) *Coordinator {
+	c := &Coordinator{
+		args:     args,
+		owners:   owners,
+		onChange: onChange,
+		agents:   make(map[string]*AgentStatus),
+		finished: make(chan bool),
+	}
+	for node, vbnos := range owners {
+		c.agents[node] = &AgentStatus{Node: node, Vbuckets: len(vbnos), State: AgentPending}
+	}
+	if len(owners) == 0 {
+		close(c.finished)
+	}
+	return c
+}
+
+// Returns the assignment of the agent of a node. An agent that failed, or was restarted while running, is
+// assigned its vbuckets again. Those of an agent that is done are not handed out twice
+func (c *Coordinator) Assign(node string) (*Assignment, error) {
+	c.lock.Lock()
+	agent, ok := c.agents[node]
+	if !ok {
+		c.lock.Unlock()
+		return nil, fmt.Errorf("%v owns no vbuckets of the bucket", node)
+	}
+	if agent.State == AgentDone || c.allFinished {
+		c.lock.Unlock()
+		return nil, fmt.Errorf("the agent of %v has already finished", node)
+	}
+	agent.State = AgentRunning
+	agent.Started = time.Now()
+	agent.Finished = time.Time{}
+	agent.Error = ""
+	c.lock.Unlock()
+
+	c.changed()
+	return &Assignment{Node: node, Vbuckets: c.owners[node], Args: c.args}, nil
+}
+
+// Records how the run of an agent finished
+func (c *Coordinator) Report(report *Report) error {
+	if report.State != AgentDone && report.State != AgentFailed {
+		return fmt.Errorf("an agent can only report being %v or %v, not %v", AgentDone, AgentFailed, report.State)
+	}
+	c.lock.Lock()
+	agent, ok := c.agents[report.Node]
+	if !ok || agent.State != AgentRunning {
+		c.lock.Unlock()
+		return fmt.Errorf("no agent of %v is running", report.Node)
+	}
+	agent.State = report.State
+	agent.Finished = time.Now()
+	agent.RunDir = report.RunDir
+	agent.Error = report.Error
+	c.allFinished = true
+	for _, agent := range c.agents {
+		if agent.State != AgentDone && agent.State != AgentFailed {
+			c.allFinished = false
+		}
+	}
+	allFinished := c.allFinished
+	c.lock.Unlock()
+
+	c.changed()
+	if allFinished {
+		close(c.finished)
+	}
+	return nil
+}
+
+func (c *Coordinator) changed() {
+	if c.onChange != nil {
+		c.onChange()
+	}
+}
+
+// Closed once every agent has finished. Until then, a failed agent can be restarted
+func (c *Coordinator) Finished() <-chan bool {
+	return c.finished
+}
+
+func (c *Coordinator) Status() *Status {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	status := &Status{}
+	for _, agent := range c.agents {
+		agentCopy := *agent
+		status.Agents = append(status.Agents, &agentCopy)
+		switch agent.State {
+		case AgentPending:
+			status.Pending++
+		case AgentRunning:
+			status.Running++
+		case AgentDone:
+			status.Done++
+		case AgentFailed:
+			status.Failed++
+		}
+	}
+	sort.Slice(status.Agents, func(i, j int) bool { return status.Agents[i].Node < status.Agents[j].Node })
+	return status
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package colocation
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVbucketOwners(t *testing.T) {
+	fmt.Println("============== Test case start: TestVbucketOwners =================")
+	assert := assert.New(t)
+
+	serverList := []string{"10.0.0.1:11210", "10.0.0.2:11210", "[fd00::3]:11210"}
+	vbucketMap := [][]int{{0, 1}, {1, 0}, {2, 0}, {0, 2}, {-1, 0}}
+
+	owners, err := VbucketOwners(serverList, vbucketMap, []uint16{3, 0, 1, 2})
+	assert.Nil(err)
+	assert.Equal(map[string][]uint16{"10.0.0.1": {0, 3}, "10.0.0.2": {1}, "fd00::3": {2}}, owners)
+
+	// A vbucket without an active copy cannot be streamed by any agent
+	_, err = VbucketOwners(serverList, vbucketMap, nil)
+	assert.NotNil(err)
+	_, err = VbucketOwners(serverList, vbucketMap, []uint16{5})
+	assert.NotNil(err)
+	fmt.Println("============== Test case end: TestVbucketOwners =================")
+}
+
+func TestCoordinator(t *testing.T) {
+	fmt.Println("============== Test case start: TestCoordinator =================")
+	assert := assert.New(t)
+
+	var changes int
+	args := []string{"-sourceBucketName", "orders"}
+	coordinator := NewCoordinator(map[string][]uint16{"node1": {0, 1}, "node2": {2}}, args, func() { changes++ })
+	assert.Equal(2, coordinator.Status().Pending)
+
+	_, err := coordinator.Assign("node3")
+	assert.NotNil(err)
+	assignment, err := coordinator.Assign("node1")
+	assert.Nil(err)
+	assert.Equal([]uint16{0, 1}, assignment.Vbuckets)
+	assert.Equal(args, assignment.Args)
+	_, err = coordinator.Assign("node2")
+	assert.Nil(err)
+	assert.Equal(2, coordinator.Status().Running)
+
+	// A failed agent is assigned its vbuckets again when restarted
+	assert.Nil(coordinator.Report(&Report{Node: "node2", State: AgentFailed, Error: "exit status 1"}))
+	_, err = coordinator.Assign("node2")
+	assert.Nil(err)
+	assert.Equal("", coordinator.Status().Agents[1].Error)
+
+	// Only running agents report, and only how they finished
+	assert.NotNil(coordinator.Report(&Report{Node: "node1", State: AgentRunning}))
+	assert.Nil(coordinator.Report(&Report{Node: "node1", State: AgentDone, RunDir: "/tmp/run"}))
+	assert.NotNil(coordinator.Report(&Report{Node: "node1", State: AgentDone}))
+	_, err = coordinator.Assign("node1")
+	assert.NotNil(err)
+	select {
+	case <-coordinator.Finished():
+		assert.Fail("finished with an agent still running")
+	default:
+	}
+
+	assert.Nil(coordinator.Report(&Report{Node: "node2", State: AgentFailed, Error: "exit status 1"}))
+	<-coordinator.Finished()
+	status := coordinator.Status()
+	assert.Equal(1, status.Done)
+	assert.Equal(1, status.Failed)
+	assert.Equal("/tmp/run", status.Agents[0].RunDir)
+	assert.Equal("exit status 1", status.Agents[1].Error)
+	assert.Equal(6, changes)
+	// Nothing is assigned once all agents have finished
+	_, err = coordinator.Assign("node2")
+	assert.NotNil(err)
+	fmt.Println("============== Test case end: TestCoordinator =================")
+}
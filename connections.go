@@ -0,0 +1,49 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"xdcrDiffer/base"
+	"xdcrDiffer/stats"
+)
+
+// Pings the pooled KV agents every interval, so that their connections are not dropped while idle, i.e. while the
+// mutation differ waits on hours of capture. Connections found lost, and restored after, are logged
+func (difftool *xdcrDiffTool) keepConnectionsAlive(interval time.Duration, stopCh chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// Agents whose connections were lost, by cluster and bucket
+	lost := make(map[string]bool)
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+		failures := difftool.agentPool.Ping(base.KeepAlivePingTimeout)
+		for agent, err := range failures {
+			if !lost[agent] {
+				difftool.logger.Warnf("KV connection of %v lost: %v. The SDK is connecting again\n", agent, err)
+				lost[agent] = true
+			}
+			cluster := strings.SplitN(agent, base.FileDirDelimiter, 2)[0]
+			stats.Default.Counter(fmt.Sprintf(stats.KvKeepAliveFailures, cluster)).Add(1)
+		}
+		for agent := range lost {
+			if _, failing := failures[agent]; !failing {
+				difftool.logger.Infof("KV connection of %v restored\n", agent)
+				delete(lost, agent)
+			}
+		}
+	}
+}
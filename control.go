@@ -0,0 +1,130 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"xdcrDiffer/base"
+	"xdcrDiffer/dcp"
+	"xdcrDiffer/stats"
+)
+
+// Paths of the control endpoints served on options.controlListen
+const (
+	controlPausePath  = "/control/pause"
+	controlResumePath = "/control/resume"
+	controlStatusPath = "/control/status"
+)
+
+type controlStatus struct {
+	Paused bool
+	// Total time spent paused, including the current pause
+	PausedSecs float64
+	// Share of the usual concurrency the mutation differ runs at, and why it is throttled. With healthThresholds only
+	ConcurrencyShare float64 `json:",omitempty"`
+	ThrottledBy      string  `json:",omitempty"`
+	// Phases running, i.e. capture and fileDiff at once with streamFileDiff
+	Phases []string
+	Stats  *stats.Snapshot
+	// Of the instance serving the status
+	Build *base.BuildInfo `json:",omitempty"`
+}
+
+// Holds back DCP capture and the mutation differ. The position of each DCP stream is checkpointed, so that
+// the run can also be resumed from the checkpoint should it not be resumed in place
+func (difftool *xdcrDiffTool) pause(requestedBy string) bool {
+	if !difftool.pauseGate.Pause() {
+		return false
+	}
+	difftool.logger.Infof("Paused by %v\n", requestedBy)
+
+	difftool.curState.mtx.Lock()
+	defer difftool.curState.mtx.Unlock()
+	if difftool.curState.state == StateDcpStarted {
+		for _, dcpDriver := range []*dcp.DcpDriver{difftool.sourceDcpDriver, difftool.targetDcpDriver} {
+			if err := dcpDriver.SaveCheckpoint(); err != nil {
+				difftool.logger.Warnf("Unable to checkpoint %v on pause. err=%v\n", dcpDriver.Name, err)
+			}
+		}
+	}
+	return true
+}
+
+func (difftool *xdcrDiffTool) resume(requestedBy string) bool {
+	if !difftool.pauseGate.Resume() {
+		return false
+	}
+	_, pausedFor := difftool.pauseGate.Status()
+	difftool.logger.Infof("Resumed by %v after being paused for %v in total\n", requestedBy, pausedFor)
+	return true
+}
+
+// Runs a phase of the run, for the control status and progress events to tell it is running
+func (difftool *xdcrDiffTool) runPhase(phase string, run func() error) error {
+	difftool.phasesLock.Lock()
+	difftool.runningPhases[phase] = true
+	difftool.phasesLock.Unlock()
+	defer func() {
+		difftool.phasesLock.Lock()
+		delete(difftool.runningPhases, phase)
+		difftool.phasesLock.Unlock()
+	}()
+	return base.Progress.RunPhase(phase, run)
+}
+
+func (difftool *xdcrDiffTool) phasesRunning() []string {
+	difftool.phasesLock.Lock()
+	defer difftool.phasesLock.Unlock()
+	phases := make([]string, 0, len(difftool.runningPhases))
+	for phase := range difftool.runningPhases {
+		phases = append(phases, phase)
+	}
+	sort.Strings(phases)
+	return phases
+}
+
+func (difftool *xdcrDiffTool) serveControl(listen string) {
+	mux := http.NewServeMux()
+	toggle := func(toggleFunc func(string) bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Only POST is accepted", http.StatusMethodNotAllowed)
+				return
+			}
+			// Pausing twice or resuming while running is not an error, so that requests can be retried
+			toggleFunc(r.RemoteAddr)
+			difftool.writeControlStatus(w)
+		}
+	}
+	mux.HandleFunc(controlPausePath, toggle(difftool.pause))
+	mux.HandleFunc(controlResumePath, toggle(difftool.resume))
+	mux.HandleFunc(controlStatusPath, func(w http.ResponseWriter, r *http.Request) {
+		difftool.writeControlStatus(w)
+	})
+
+	difftool.logger.Infof("Serving pause and resume on http://%v\n", listen)
+	if err := http.ListenAndServe(listen, mux); err != nil {
+		difftool.logger.Errorf("Control endpoints stopped. err=%v\n", err)
+	}
+}
+
+func (difftool *xdcrDiffTool) writeControlStatus(w http.ResponseWriter) {
+	paused, pausedFor := difftool.pauseGate.Status()
+	status := &controlStatus{Paused: paused, PausedSecs: pausedFor.Seconds(), Phases: difftool.phasesRunning(),
+		Stats: stats.Default.Snapshot(), Build: base.GetBuildInfo()}
+	if difftool.healthThrottler != nil {
+		status.ConcurrencyShare = difftool.throttle.Share()
+		status.ThrottledBy = difftool.healthThrottler.Reason()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
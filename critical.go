@@ -0,0 +1,135 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"xdcrDiffer/base"
+	"xdcrDiffer/differ"
+	"xdcrDiffer/results"
+)
+
+// Under options.criticalKeysDir, next to a directory per pass holding the mutation differ output of the pass
+const criticalKeysReportFileName = "criticalKeysReport.json"
+
+// The keys a pass verifies, in the diff keys format, under the directory of the pass
+const criticalKeysInputFileName = "criticalKeys.json"
+
+// Verifies the critical keys in a pass of their own, and rewrites the report with it. Once capture is done, keys
+// of the capture that match the critical prefixes are verified too
+func (difftool *xdcrDiffTool) verifyCriticalKeys(pass string, includeCapture bool) error {
+	keys, err := difftool.criticalKeysToVerify(includeCapture)
+	if err != nil {
+		return err
+	}
+
+	passDir := filepath.Join(options.criticalKeysDir, pass)
+	if err = os.RemoveAll(passDir); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(passDir, 0777); err != nil {
+		return err
+	}
+	encodedKeys := make(map[uint32][]string, len(keys))
+	for colId, colKeys := range keys {
+		encodedKeys[colId] = base.EncodeKeys(colKeys)
+	}
+	keysBytes, err := json.Marshal(encodedKeys)
+	if err != nil {
+		return err
+	}
+	keysFileName := filepath.Join(passDir, criticalKeysInputFileName)
+	if err = ioutil.WriteFile(keysFileName, keysBytes, 0644); err != nil {
+		return err
+	}
+	difftool.writeRunMetadata(passDir)
+
+	fmt.Printf("Verifying %v critical keys in the %v pass\n", keys.GetTotalCount(), pass)
+	mutationDiffer := difftool.newMutationDiffer(passDir)
+	// The keys are read from a file, which gives the collection of each
+	mutationDiffer.SetDiffKeysSource(keysFileName, difftool.queryKeys, 0)
+	if err = mutationDiffer.Run(); err != nil {
+		return err
+	}
+
+	verified, err := results.NewCriticalKeysPass(pass, keys.GetTotalCount(), passDir)
+	if err != nil {
+		return err
+	}
+	difftool.criticalKeysReport.AddPass(verified)
+	fmt.Printf("Critical keys: %v\n", verified)
+	return difftool.criticalKeysReport.Write(filepath.Join(options.criticalKeysDir, criticalKeysReportFileName))
+}
+
+// The critical keys by source collection ID. Keys are looked up in every collection that is compared, as they are
+// given without one. Keys that only the target captured are verified under the source collection that maps to it
+func (difftool *xdcrDiffTool) criticalKeysToVerify(includeCapture bool) (differ.DiffKeysMap, error) {
+	srcToTgtColIds := difftool.srcToTgtColIdsMap
+	if len(srcToTgtColIds) == 0 {
+		// Legacy mode, where everything is in the default collection
+		srcToTgtColIds = map[uint32][]uint32{0: {0}}
+	}
+	keySets := make(map[uint32]map[string]bool)
+	add := func(colId uint32, keys []string) {
+		if keySets[colId] == nil {
+			keySets[colId] = make(map[string]bool)
+		}
+		for _, key := range keys {
+			keySets[colId][key] = true
+		}
+	}
+	for srcColId := range srcToTgtColIds {
+		add(srcColId, difftool.criticalKeys.Keys)
+	}
+
+	if includeCapture && len(difftool.criticalKeys.Prefixes) > 0 {
+		sourceKeys, err := difftool.captureKeysMatchingCritical(base.SourceClusterLabel, options.sourceFileDir)
+		if err != nil {
+			return nil, err
+		}
+		targetKeys, err := difftool.captureKeysMatchingCritical(base.TargetClusterLabel, options.targetFileDir)
+		if err != nil {
+			return nil, err
+		}
+		for srcColId, tgtColIds := range srcToTgtColIds {
+			add(srcColId, sourceKeys[srcColId])
+			for _, tgtColId := range tgtColIds {
+				add(srcColId, targetKeys[tgtColId])
+			}
+		}
+	}
+
+	keys := make(differ.DiffKeysMap, len(keySets))
+	for colId, keySet := range keySets {
+		for key := range keySet {
+			keys[colId] = append(keys[colId], key)
+		}
+		sort.Strings(keys[colId])
+	}
+	return keys, nil
+}
+
+// Empty if there is no capture, i.e. when data generation is skipped and was never run
+func (difftool *xdcrDiffTool) captureKeysMatchingCritical(label, fileDir string) (differ.DiffKeysMap, error) {
+	if _, err := os.Stat(fileDir); os.IsNotExist(err) {
+		fmt.Printf("No %v capture at %v to match critical key prefixes against\n", label, fileDir)
+		return make(differ.DiffKeysMap), nil
+	}
+	keys, err := differ.CaptureKeysMatching(fileDir, difftool.criticalKeys.Matches)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to look up critical key prefixes in the %v capture: %v", label, err)
+	}
+	return keys, nil
+}
@@ -6,10 +6,12 @@ import (
 	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"xdcrDiffer/base"
+	"xdcrDiffer/results"
 	"xdcrDiffer/utils"
 
 	"github.com/couchbase/gocb/v2"
@@ -30,9 +32,11 @@ type CheckpointManager struct {
 	seqnoMap              map[uint16]*SeqnoWithLock
 	snapshots             map[uint16]*Snapshot
 	endSeqnoMap           map[uint16]uint64
-	filteredCnt           map[uint16]metrics.Counter
-	failedFilterCnt       map[uint16]metrics.Counter
-	finChan               chan bool
+	// High seqnos of the vbuckets when streaming started. Mutations beyond these arrived after the backfill
+	backfillSeqnoMap map[uint16]uint64
+	filteredCnt      map[uint16]metrics.Counter
+	failedFilterCnt  map[uint16]metrics.Counter
+	finChan          chan bool
 	// channel to signal the completion of start vbts computation
 	startVbtsDoneChan     chan bool
 	bucketOpTimeout       time.Duration
@@ -46,6 +50,10 @@ type CheckpointManager struct {
 	completeBySeqno       bool
 	logOnceCount          uint64
 	lastRemainingMap      map[uint16]uint64
+	// Clocks of the KV nodes measured at start. Nil if they could not be measured
+	clocks []*results.NodeClock
+	// Where the streams of the vbuckets start. Nil to resume from the checkpoint if any, and to start from zero otherwise
+	streamStarts *base.StreamStarts
 
 	kvSSLPortMap    xdcrBase.SSLPortMap
 	kvVbMap         map[string][]uint16
@@ -55,7 +63,8 @@ type CheckpointManager struct {
 
 func NewCheckpointManager(dcpDriver *DcpDriver, checkpointFileDir, oldCheckpointFileName, newCheckpointFileName, clusterName string,
 	bucketOpTimeout time.Duration, maxNumOfGetStatsRetry int, getStatsRetryInterval, getStatsMaxBackoff time.Duration,
-	checkpointInterval int, startVbtsDoneChan chan bool, logger *xdcrLog.CommonLogger, completeBySeqno bool) *CheckpointManager {
+	checkpointInterval int, startVbtsDoneChan chan bool, logger *xdcrLog.CommonLogger, completeBySeqno bool,
+	streamStarts *base.StreamStarts) *CheckpointManager {
 	cm := &CheckpointManager{
 		dcpDriver:             dcpDriver,
 		clusterName:           clusterName,
@@ -74,15 +83,16 @@ func NewCheckpointManager(dcpDriver *DcpDriver, checkpointFileDir, oldCheckpoint
 		startVbtsDoneChan:     startVbtsDoneChan,
 		logger:                logger,
 		completeBySeqno:       completeBySeqno,
+		streamStarts:          streamStarts,
 	}
 
 	if checkpointFileDir != "" {
 		if oldCheckpointFileName != "" {
-			cm.oldCheckpointFileName = checkpointFileDir + base.FileDirDelimiter + clusterName + base.FileNameDelimiter + oldCheckpointFileName
+			cm.oldCheckpointFileName = filepath.Join(checkpointFileDir, clusterName+base.FileNameDelimiter+oldCheckpointFileName)
 		}
 
 		if newCheckpointFileName != "" {
-			cm.newCheckpointFileName = checkpointFileDir + base.FileDirDelimiter + clusterName + base.FileNameDelimiter + newCheckpointFileName
+			cm.newCheckpointFileName = filepath.Join(checkpointFileDir, clusterName+base.FileNameDelimiter+newCheckpointFileName)
 		}
 	}
 
@@ -266,6 +276,17 @@ func (cm *CheckpointManager) initialize() error {
 		return err
 	}
 
+	err = cm.waitForPersistence()
+	if err != nil {
+		return err
+	}
+
+	// Clock skew is reported for context, and does not stop the run
+	cm.clocks, err = cm.probeClocks()
+	if err != nil {
+		cm.logger.Warnf("%v unable to measure node clocks. err=%v\n", cm.clusterName, err)
+	}
+
 	if cm.completeBySeqno {
 		cm.logger.Infof("%v endSeqno map retrieved %v\n", cm.clusterName, cm.endSeqnoMap)
 	} else {
@@ -317,6 +338,7 @@ func (cm *CheckpointManager) getVbuuidsAndHighSeqnos() error {
 	cm.logger.Infof("%v total mutations=%v\n", cm.clusterName, sum)
 
 	cm.vbuuidMap = vbuuidMap
+	cm.backfillSeqnoMap = endSeqnoMap
 
 	if cm.dcpDriver.completeBySeqno {
 		cm.endSeqnoMap = endSeqnoMap
@@ -338,6 +360,48 @@ func (cm *CheckpointManager) getVbuuidsAndHighSeqnos() error {
 	return nil
 }
 
+// Waits, for up to the persistence wait timeout of the driver, for the vbuckets to be streamed to persist up to the high
+// seqnos they had when their end seqnos were read, so that the capture reflects a persisted state rather than mutations
+// still in flight. Vbuckets that do not get there in time are only warned about
+func (cm *CheckpointManager) waitForPersistence() error {
+	timeout := cm.dcpDriver.persistenceWait
+	if timeout == 0 {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		statsMap, err := cm.getStatsWithRetry()
+		if err != nil {
+			return err
+		}
+		persistedSeqnoMap, err := utils.ParsePersistedSeqnoStat(statsMap)
+		if err != nil {
+			return err
+		}
+		if len(persistedSeqnoMap) == 0 {
+			cm.logger.Warnf("%v bucket does not persist its vbuckets. Not waiting for persistence\n", cm.clusterName)
+			return nil
+		}
+
+		var pending []uint16
+		for _, vbno := range cm.dcpDriver.vbuckets {
+			if persistedSeqnoMap[vbno] < cm.backfillSeqnoMap[vbno] {
+				pending = append(pending, vbno)
+			}
+		}
+		if len(pending) == 0 {
+			cm.logger.Infof("%v vbuckets persisted up to their high seqnos\n", cm.clusterName)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			cm.logger.Warnf("%v vbuckets %v did not persist up to their high seqnos within %v. Their capture can hold mutations still in flight\n",
+				cm.clusterName, pending, timeout)
+			return nil
+		}
+		time.Sleep(base.PersistenceWaitInterval * time.Millisecond)
+	}
+}
+
 // get stats is likely to time out. add retry
 func (cm *CheckpointManager) getStatsWithRetry() (map[string]map[string]string, error) {
 	var statsMap = make(map[string]map[string]string)
@@ -415,40 +479,72 @@ func (cm *CheckpointManager) setStartVBTS() error {
 	var totalFiltered uint64
 	var totalFailedFilter uint64
 
+	var checkpointDoc *CheckpointDoc
 	if cm.oldCheckpointFileName != "" {
-		checkpointDoc, err := cm.loadCheckpoints()
+		var err error
+		checkpointDoc, err = cm.loadCheckpoints()
 		if err != nil {
 			return err
 		}
+	}
 
-		for vbno, checkpoint := range checkpointDoc.Checkpoints {
-			cm.startVBTS[vbno] = &VBTS{
-				Checkpoint: checkpoint,
-				EndSeqno:   cm.endSeqnoMap[vbno],
+	var vbno uint16
+	for vbno = 0; vbno < base.NumberOfVbuckets; vbno++ {
+		var checkpoint *Checkpoint
+		start := cm.streamStarts.For(vbno, checkpointDoc != nil)
+		switch start.From {
+		case base.StreamStartCheckpoint:
+			if checkpointDoc == nil {
+				return fmt.Errorf("%v vbucket %v is to start from the checkpoint, but no checkpoint file was given", cm.clusterName, vbno)
 			}
-			if cm.dcpDriver.completeBySeqno && checkpoint.Seqno >= cm.endSeqnoMap[vbno] {
-				cm.startVBTS[vbno].NoNeedToStartDcpStream = true
+			checkpoint = checkpointDoc.Checkpoints[vbno]
+			if checkpoint.Seqno > 0 && checkpoint.Vbuuid != cm.vbuuidMap[vbno] {
+				// The vbucket failed over since the checkpoint, and may have rolled back past it. What was captured
+				// of it up to the checkpoint cannot be relied on, so it is captured again from the start
+				cm.logger.Warnf("%v vbucket %v has vbuuid %v rather than %v of the checkpoint. Capturing it again from the start\n",
+					cm.clusterName, vbno, cm.vbuuidMap[vbno], checkpoint.Vbuuid)
+				if err := cm.dcpDriver.discardCapture(vbno); err != nil {
+					return err
+				}
+				checkpoint = &Checkpoint{}
 			}
-
-			// update start Seqno as that in checkpoint doc
-			cm.seqnoMap[vbno].setSeqno(checkpoint.Seqno)
-			sum += checkpoint.Seqno
 			totalFiltered += checkpoint.FilteredCnt
 			totalFailedFilter += checkpoint.FailedFilterCnt
 
 			// Resume previous counters
 			cm.filteredCnt[vbno].Inc(int64(checkpoint.FilteredCnt))
 			cm.failedFilterCnt[vbno].Inc(int64(checkpoint.FailedFilterCnt))
+		case base.StreamStartSeqno:
+			if start.Seqno > cm.backfillSeqnoMap[vbno] {
+				return fmt.Errorf("%v vbucket %v is to start from seqno %v, beyond its high seqno %v", cm.clusterName, vbno,
+					start.Seqno, cm.backfillSeqnoMap[vbno])
+			}
+			vbuuid := start.Vbuuid
+			if vbuuid == 0 {
+				vbuuid = cm.vbuuidMap[vbno]
+			}
+			// Only what is streamed from the seqno on is captured, so what a previous run captured is not added to
+			checkpoint = &Checkpoint{Vbuuid: vbuuid, Seqno: start.Seqno, SnapshotStartSeqno: start.Seqno, SnapshotEndSeqno: start.Seqno}
+		default:
+			checkpoint = &Checkpoint{}
 		}
-	} else {
-		var vbno uint16
-		for vbno = 0; vbno < base.NumberOfVbuckets; vbno++ {
-			// if we are not loading checkpoints, it is ok to leave all fields in Checkpoint with default values, 0
-			cm.startVBTS[vbno] = &VBTS{
-				Checkpoint: &Checkpoint{},
-				EndSeqno:   cm.endSeqnoMap[vbno],
+		if start.From != base.StreamStartCheckpoint && checkpointDoc != nil {
+			if err := cm.dcpDriver.discardCapture(vbno); err != nil {
+				return err
 			}
 		}
+
+		cm.startVBTS[vbno] = &VBTS{
+			Checkpoint: checkpoint,
+			EndSeqno:   cm.endSeqnoMap[vbno],
+		}
+		if start.From != base.StreamStartZero && cm.dcpDriver.completeBySeqno && checkpoint.Seqno >= cm.endSeqnoMap[vbno] {
+			cm.startVBTS[vbno].NoNeedToStartDcpStream = true
+		}
+
+		// update start Seqno as that in checkpoint doc
+		cm.seqnoMap[vbno].setSeqno(checkpoint.Seqno)
+		sum += checkpoint.Seqno
 	}
 
 	cm.logger.Infof("%v starting from %v filtered %v unableToFilter %v\n", cm.clusterName, sum, totalFiltered, totalFailedFilter)
@@ -580,6 +676,19 @@ func (cm *CheckpointManager) RecordFilterEvent(vbno uint16, filterResult base.Fi
 //  2. checkpointManager reads seqnoMap when it saves checkpoints.
 //     This is done after all DcpHandlers are stopped and MutationProcessedEvent cease to happen
 func (cm *CheckpointManager) HandleMutationEvent(mut *Mutation, filterResult base.FilterResultType) bool {
+	if cm.dcpDriver.rangeScan {
+		// Range scans return documents in key order, and complete the vbucket once they are done
+		return cm.RecordFilterEvent(mut.Vbno, filterResult)
+	}
+	if cm.recordOSOSeqno(mut.Vbno, mut.Seqno) {
+		// Within an OSO snapshot, mutations are not in seqno order. The seqno to checkpoint and the vbucket completion
+		// are decided at the end of the snapshot instead
+		if cm.dcpDriver.completeBySeqno && mut.Seqno > cm.endSeqnoMap[mut.Vbno] {
+			return false
+		}
+		return cm.RecordFilterEvent(mut.Vbno, filterResult)
+	}
+
 	if cm.dcpDriver.completeBySeqno {
 		endSeqno := cm.endSeqnoMap[mut.Vbno]
 		if mut.Seqno >= endSeqno {
@@ -597,6 +706,68 @@ func (cm *CheckpointManager) HandleMutationEvent(mut *Mutation, filterResult bas
 	}
 }
 
+// Whether the mutation was made after streaming started, as opposed to being part of the initial backfill
+func (cm *CheckpointManager) isLive(vbno uint16, seqno uint64) bool {
+	return seqno > cm.backfillSeqnoMap[vbno]
+}
+
+// Returns true if the vbucket is within an OSO snapshot, in which case the seqno is recorded against the snapshot
+func (cm *CheckpointManager) recordOSOSeqno(vbno uint16, seqno uint64) bool {
+	snapshot := cm.snapshots[vbno]
+	snapshot.lock.Lock()
+	defer snapshot.lock.Unlock()
+
+	if !snapshot.inOSO {
+		return false
+	}
+	if seqno > snapshot.osoHighSeqno {
+		snapshot.osoHighSeqno = seqno
+	}
+	if seqno > snapshot.osoRecordSeqno && (!cm.dcpDriver.completeBySeqno || seqno <= cm.endSeqnoMap[vbno]) {
+		snapshot.osoRecordSeqno = seqno
+	}
+	return true
+}
+
+func (cm *CheckpointManager) handleOSOSnapshot(vbno uint16, snapshotType uint32) {
+	snapshot := cm.snapshots[vbno]
+	snapshot.lock.Lock()
+	defer snapshot.lock.Unlock()
+
+	if snapshotType&base.OSOSnapshotStart != 0 {
+		snapshot.inOSO = true
+		snapshot.osoHighSeqno = 0
+		snapshot.osoRecordSeqno = 0
+		return
+	}
+	if snapshotType&base.OSOSnapshotEnd == 0 || !snapshot.inOSO {
+		return
+	}
+
+	// All mutations of the snapshot have been received, so checkpointing the highest seqno is now safe.
+	// Until then, checkpoints kept the seqno from before the snapshot
+	snapshot.inOSO = false
+	seqno := cm.seqnoMap[vbno]
+	if snapshot.osoRecordSeqno > seqno.getSeqno() {
+		seqno.setSeqno(snapshot.osoRecordSeqno)
+		snapshot.startSeqno = snapshot.osoRecordSeqno
+		snapshot.endSeqno = snapshot.osoRecordSeqno
+	}
+	if cm.dcpDriver.completeBySeqno && snapshot.osoHighSeqno >= cm.endSeqnoMap[vbno] {
+		cm.dcpDriver.handleVbucketCompletion(vbno, nil, "end Seqno reached in OSO snapshot")
+	}
+}
+
+// Leaves the OSO snapshot the vbucket is in, if any, as its stream is opened again. The seqno to checkpoint stays that
+// of before the snapshot
+func (cm *CheckpointManager) resetOSO(vbno uint16) {
+	snapshot := cm.snapshots[vbno]
+	snapshot.lock.Lock()
+	defer snapshot.lock.Unlock()
+
+	snapshot.inOSO = false
+}
+
 func (cm *CheckpointManager) updateSnapshot(vbno uint16, startSeqno, endSeqno uint64) {
 	snapshot := cm.snapshots[vbno]
 	snapshot.lock.Lock()
@@ -636,34 +807,16 @@ func (cm *CheckpointManager) initializeBucket() (err error) {
 			Auth:              authProvider,
 			AuthMechanisms:    base.ScramShaAuth,
 		},
-		IoConfig: gocbcore.IoConfig{UseCollections: cm.dcpDriver.capabilities.HasCollectionSupport()},
-	}
-
-	agent, err := gocbcore.CreateAgent(agentConfig)
-	if err != nil {
-		return
-	}
-	cm.agent = agent
-
-	options := gocbcore.WaitUntilReadyOptions{
-		DesiredState:  gocbcore.ClusterStateOnline,
-		ServiceTypes:  []gocbcore.ServiceType{gocbcore.MemdService},
-		RetryStrategy: &base.RetryStrategy{},
-	}
-
-	signal := make(chan error, 1)
-	_, err = cm.agent.WaitUntilReady(time.Now().Add(time.Duration(base.SetupTimeoutSeconds)*time.Second),
-		options, func(res *gocbcore.WaitUntilReadyResult, er error) {
-			signal <- er
-		})
-
-	if err == nil {
-		err = <-signal
+		// The agent may be shared with the mutation differ, which fetches documents
+		CompressionConfig: gocbcore.CompressionConfig{Enabled: true},
+		IoConfig: gocbcore.IoConfig{
+			UseCollections: cm.dcpDriver.capabilities.HasCollectionSupport(),
+			NetworkType:    base.PortsOf(cm.dcpDriver.IsSource()).NetworkType(),
+		},
 	}
 
+	cm.agent, err = cm.dcpDriver.agentPool.Get(cm.clusterName, agentConfig, time.Duration(base.SetupTimeoutSeconds)*time.Second)
 	if err != nil {
-		errClosing := cm.agent.Close()
-		err = fmt.Errorf("Closing CheckpointManager.agent because of err=%v, error while closing=%v", err, errClosing)
 		return
 	}
 
@@ -677,7 +830,12 @@ func (cm *CheckpointManager) initializeBucket() (err error) {
 type Snapshot struct {
 	startSeqno uint64
 	endSeqno   uint64
-	lock       sync.RWMutex
+	// Whether an OSO snapshot is being received, and the highest seqnos received within it
+	// so far, i.e. of all mutations and of the mutations up to the end seqno
+	inOSO          bool
+	osoHighSeqno   uint64
+	osoRecordSeqno uint64
+	lock           sync.RWMutex
 }
 
 type SeqnoWithLock struct {
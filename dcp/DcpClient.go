@@ -54,6 +54,10 @@ type DcpClient struct {
 
 	kvSSLPortMap xdcrBase.SSLPortMap
 	kvVbMap      map[string][]uint16
+
+	// Streams closed to be opened again, whose end is expected and not to complete their vbucket
+	reopenLock     sync.Mutex
+	closedToReopen map[uint16]int
 }
 
 func NewDcpClient(dcpDriver *DcpDriver, i int, vbList []uint16, waitGroup *sync.WaitGroup, startVbtsDoneChan chan bool, capabilities metadata.Capability, collectionIds []uint32, colMigrationFilters []string, utils xdcrUtils.UtilsIface, bufferCap int, migrationMapping metadata.CollectionNamespaceMapping) *DcpClient {
@@ -67,6 +71,7 @@ func NewDcpClient(dcpDriver *DcpDriver, i int, vbList []uint16, waitGroup *sync.
 		closeStreamsDoneCh:  make(chan bool),
 		finChan:             make(chan bool),
 		startVbtsDoneChan:   startVbtsDoneChan,
+		closedToReopen:      make(map[uint16]int),
 		logger:              dcpDriver.logger,
 		capabilities:        capabilities,
 		collectionIds:       collectionIds,
@@ -86,7 +91,11 @@ func (c *DcpClient) Start() error {
 		return err
 	}
 
-	go c.handleDcpStreams()
+	if c.dcpDriver.rangeScan {
+		go c.scanVbuckets()
+	} else {
+		go c.handleDcpStreams()
+	}
 
 	return nil
 }
@@ -180,10 +189,13 @@ func (c *DcpClient) initialize() error {
 		return err
 	}
 
-	err = c.initializeBucket()
-	if err != nil {
-		c.logger.Errorf("Error initializing bucket %v - %v", c.Name, err)
-		return err
+	// Range scans go through the KV agent of the checkpoint manager, and need no DCP connection
+	if !c.dcpDriver.rangeScan {
+		err = c.initializeBucket()
+		if err != nil {
+			c.logger.Errorf("Error initializing bucket %v - %v", c.Name, err)
+			return err
+		}
 	}
 
 	err = c.initializeDcpHandlers()
@@ -224,7 +236,7 @@ func initializeClusterWithSecurity(dcpDriver *DcpDriver) (*gocb.Cluster, error)
 	useCouchbaseSecureStr := dcpDriver.ref.HttpAuthMech() == xdcrBase.HttpAuthMechHttps
 
 	// If it is a source cluster, use cbauth username/pw and not client certs
-	if dcpDriver.Name != base.SourceClusterName && len(dcpDriver.ref.ClientCertificate()) > 0 && len(dcpDriver.ref.ClientKey()) > 0 {
+	if !dcpDriver.IsSource() && len(dcpDriver.ref.ClientCertificate()) > 0 && len(dcpDriver.ref.ClientKey()) > 0 {
 		tlsCert, err := tls.X509KeyPair(dcpDriver.ref.ClientCertificate(), dcpDriver.ref.ClientKey())
 		if err != nil {
 			dcpDriver.logger.Errorf("error generating tlsCert from the cluster reference: %v\n", err)
@@ -239,11 +251,13 @@ func initializeClusterWithSecurity(dcpDriver *DcpDriver) (*gocb.Cluster, error)
 		}
 	}
 
-	cccpString := utils.PopulateCCCPConnectString(dcpDriver.url)
+	ports := base.PortsOf(dcpDriver.IsSource())
+	cccpString := utils.PopulateCCCPConnectString(dcpDriver.url, ports.KvPort(useCouchbaseSecureStr))
 	if useCouchbaseSecureStr {
 		cccpString = strings.TrimPrefix(cccpString, base.CouchbasePrefix)
 		cccpString = fmt.Sprintf("%v%v", base.CouchbaseSecurePrefix, cccpString)
 	}
+	cccpString = ports.TagOptions(cccpString)
 
 	cluster, err := gocb.Connect(cccpString, clusterOpts)
 	if err != nil {
@@ -260,7 +274,15 @@ func (c *DcpClient) initializeBucket() (err error) {
 		return err
 	}
 
-	c.gocbcoreDcpFeed, err = NewGocbcoreDCPFeed(c.Name, []string{bucketConnStr}, c.dcpDriver.bucketName, auth, c.capabilities.HasCollectionSupport(), c.dcpDriver.ref)
+	// OSO snapshots with the seqno advanced events needed to checkpoint them are only sent by servers that support collections
+	useOSO := c.dcpDriver.useOSO && c.capabilities.HasCollectionSupport()
+	ports := base.PortsOf(c.dcpDriver.IsSource())
+	servers := []string{ports.TagAgentOptions(bucketConnStr)}
+	c.gocbcoreDcpFeed, err = NewGocbcoreDCPFeed(c.Name, servers, c.dcpDriver.bucketName, auth, c.capabilities.HasCollectionSupport(), c.dcpDriver.ref, c.dcpDriver.dcpBufferSize, useOSO, c.dcpDriver.noValue, ports.NetworkType())
+	if err != nil && useOSO {
+		c.logger.Warnf("%v unable to set up DCP with OSO snapshots. Retrying with regular snapshots. err=%v\n", c.Name, err)
+		c.gocbcoreDcpFeed, err = NewGocbcoreDCPFeed(c.Name, servers, c.dcpDriver.bucketName, auth, c.capabilities.HasCollectionSupport(), c.dcpDriver.ref, c.dcpDriver.dcpBufferSize, false, c.dcpDriver.noValue, ports.NetworkType())
+	}
 	return
 }
 
@@ -278,8 +300,10 @@ func initializeBucketWithSecurity(dcpDriver *DcpDriver, kvVbMap map[string][]uin
 	}
 
 	useSecurePrefix := dcpDriver.ref.HttpAuthMech() == xdcrBase.HttpAuthMechHttps
+	ports := base.PortsOf(dcpDriver.IsSource())
+	base.SDKLogs.RegisterKvNodes(dcpDriver.Name, kvVbMap, ports)
 
-	if dcpDriver.Name != base.SourceClusterName && len(dcpDriver.ref.ClientKey()) > 0 && len(dcpDriver.ref.ClientCertificate()) > 0 {
+	if !dcpDriver.IsSource() && len(dcpDriver.ref.ClientKey()) > 0 && len(dcpDriver.ref.ClientCertificate()) > 0 {
 		auth = &base.CertificateAuth{
 			// For client cert auth, no pw or username given
 			PasswordAuth:     base.PasswordAuth{},
@@ -295,11 +319,24 @@ func initializeBucketWithSecurity(dcpDriver *DcpDriver, kvVbMap map[string][]uin
 		if !found {
 			return nil, "", fmt.Errorf("Cannot find SSL port for %v in map %v", bucketConnStr, kvSSLPortMap)
 		}
-		bucketConnStr = xdcrBase.GetHostAddr(xdcrBase.GetHostName(bucketConnStr), sslPort)
+		bucketConnStr = ports.Translate(xdcrBase.GetHostAddr(xdcrBase.GetHostName(bucketConnStr), sslPort))
+		// The SDK only verifies the chain against the roots, so the policy is checked on every KV node here
+		for kvAddr, _ := range kvVbMap {
+			kvSSLPort, found := kvSSLPortMap[kvAddr]
+			if !found {
+				return nil, "", fmt.Errorf("Cannot find SSL port for %v in map %v", kvAddr, kvSSLPortMap)
+			}
+			kvSSLAddr := ports.Translate(xdcrBase.GetHostAddr(xdcrBase.GetHostName(kvAddr), kvSSLPort))
+			err := base.TLSVerification.Probe(kvSSLAddr, dcpDriver.ref.Certificates(), time.Duration(base.SetupTimeoutSeconds)*time.Second)
+			if err != nil {
+				return nil, "", err
+			}
+		}
 		if tagPrefix {
 			base.TagCouchbaseSecurePrefix(&bucketConnStr)
 		}
 	} else {
+		bucketConnStr = ports.Translate(bucketConnStr)
 		if tagPrefix {
 			bucketConnStr = fmt.Sprintf("%v%v", base.CouchbasePrefix, bucketConnStr)
 		}
@@ -309,8 +346,13 @@ func initializeBucketWithSecurity(dcpDriver *DcpDriver, kvVbMap map[string][]uin
 }
 
 func (c *DcpClient) initializeDcpHandlers() error {
-	loadDistribution := utils.BalanceLoad(c.dcpDriver.numberOfWorkers, len(c.vbList))
-	for i := 0; i < c.dcpDriver.numberOfWorkers; i++ {
+	// Each handler needs at least one vbucket
+	numberOfWorkers := c.dcpDriver.numberOfWorkers
+	if numberOfWorkers > len(c.vbList) {
+		numberOfWorkers = len(c.vbList)
+	}
+	loadDistribution := utils.BalanceLoad(numberOfWorkers, len(c.vbList))
+	for i := 0; i < numberOfWorkers; i++ {
 		lowIndex := loadDistribution[i][0]
 		highIndex := loadDistribution[i][1]
 		vbList := make([]uint16, highIndex-lowIndex)
@@ -372,6 +414,7 @@ func (c *DcpClient) openDcpStreams() error {
 		vbts := c.dcpDriver.checkpointManager.GetStartVBTS(vbno)
 		if vbts.NoNeedToStartDcpStream {
 			c.dcpDriver.handleVbucketCompletion(vbno, nil, "no mutations to stream")
+			c.vbHandlerMap[vbno].notifyVbucketCompleted(vbno)
 			continue
 		}
 
@@ -10,12 +10,15 @@
 package dcp
 
 import (
+	"bytes"
 	"fmt"
+	"os"
 	"sync"
-	"sync/atomic"
 	"time"
 	"xdcrDiffer/base"
 	fdp "xdcrDiffer/fileDescriptorPool"
+	"xdcrDiffer/results"
+	"xdcrDiffer/stats"
 	"xdcrDiffer/utils"
 
 	gocbcore "github.com/couchbase/gocbcore/v10"
@@ -64,11 +67,46 @@ type DcpDriver struct {
 	migrationMapping    metadata.CollectionNamespaceMapping
 	mobileCompatible    int
 	expDelMode          xdcrBase.FilterExpDelType
+	// The vbuckets to be streamed, in ascending order
+	vbuckets []uint16
 
 	// various counters
-	totalNumReceivedFromDCP                uint64
-	totalSysOrUnsubbedEventReceivedFromDCP uint64
-	xattrKeysForNoCompare                  map[string]bool
+	docsReceived          *stats.Counter
+	sysOrUnsubbedReceived *stats.Counter
+	docsSkipped           *stats.Counter
+	// Bytes of the keys and values received, and their read units as Capella bills them
+	bytesReceived         *stats.Counter
+	readUnits             *stats.Counter
+	xattrKeysForNoCompare map[string]bool
+	// Documents whose keys start with any of these are not captured, i.e. transaction metadata documents
+	keyPrefixesToSkip []string
+	// Capture the Sync Gateway revision of each document, to be compared instead of its CAS
+	syncGatewayMode bool
+	// Shared by the DCP handlers to write their buffers in the background. Nil if buffers are written in place
+	bufferPool *BufferPool
+	// DCP flow control buffer of each connection, in bytes. Sized from the bucket if 0, or base.DcpFlowControlOff
+	dcpBufferSize int
+	// Whether to let the server send backfills as OSO snapshots, where supported
+	useOSO bool
+	// Whether to stream documents without their bodies, for runs that only compare metadata
+	noValue bool
+	// Called with each mutation made after streaming started, if set
+	mutationObserver func(*Mutation)
+	// DCP handlers stop consuming mutations while paused, so that flow control holds back the producer
+	pauseGate *base.PauseGate
+	// KV agents shared with the other phases of the run, used by the checkpoint manager for stats
+	agentPool *base.AgentPool
+	// Called with each vbucket whose capture files are complete and closed, once it has been streamed up to its end
+	// seqno, if set. Only vbuckets that complete before the driver is stopped are handed off this way
+	vbucketCaptured func(vbno uint16)
+	// Sizes and datatypes of the documents captured
+	distribution *results.DistributionRecorder
+	// Capture through KV range scans instead of DCP, sampling this many documents of each vbucket and collection
+	// if non-zero, and scanning them all otherwise
+	rangeScan        bool
+	rangeScanSamples uint64
+	// How long to wait, before streaming, for the vbuckets to persist up to their high seqnos. Not waited for if zero
+	persistenceWait time.Duration
 }
 
 type VBStateWithLock struct {
@@ -92,7 +130,7 @@ const (
 	DriverStateStopped DriverState = iota
 )
 
-func NewDcpDriver(logger *xdcrLog.CommonLogger, name, url, bucketName string, ref *metadata.RemoteClusterReference, fileDir, checkpointFileDir, oldCheckpointFileName, newCheckpointFileName string, numberOfClients, numberOfWorkers, numberOfBins, dcpHandlerChanSize int, bucketOpTimeout time.Duration, maxNumOfGetStatsRetry int, getStatsRetryInterval, getStatsMaxBackoff time.Duration, checkpointInterval int, errChan chan error, waitGroup *sync.WaitGroup, completeBySeqno bool, fdPool fdp.FdPoolIface, filter xdcrParts.Filter, capabilities metadata.Capability, collectionIds []uint32, colMigrationFilters []string, utils xdcrUtils.UtilsIface, bufferCap int, migrationMapping metadata.CollectionNamespaceMapping, mobileCompat int, expDelMode xdcrBase.FilterExpDelType, xattrKeysForNoCompare map[string]bool) *DcpDriver {
+func NewDcpDriver(logger *xdcrLog.CommonLogger, name, url, bucketName string, ref *metadata.RemoteClusterReference, fileDir, checkpointFileDir, oldCheckpointFileName, newCheckpointFileName string, numberOfClients, numberOfWorkers, numberOfBins, dcpHandlerChanSize int, bucketOpTimeout time.Duration, maxNumOfGetStatsRetry int, getStatsRetryInterval, getStatsMaxBackoff time.Duration, checkpointInterval int, errChan chan error, waitGroup *sync.WaitGroup, completeBySeqno bool, fdPool fdp.FdPoolIface, filter xdcrParts.Filter, capabilities metadata.Capability, collectionIds []uint32, colMigrationFilters []string, utils xdcrUtils.UtilsIface, bufferCap int, migrationMapping metadata.CollectionNamespaceMapping, mobileCompat int, expDelMode xdcrBase.FilterExpDelType, xattrKeysForNoCompare map[string]bool, vbuckets []uint16, keyPrefixesToSkip []string, syncGatewayMode bool, bufferPoolSize, bufferHighWatermark, dcpBufferSize int, useOSO, noValue bool, mutationObserver func(*Mutation), pauseGate *base.PauseGate, agentPool *base.AgentPool, vbucketCaptured func(vbno uint16), rangeScan bool, rangeScanSamples uint64, persistenceWait time.Duration, streamStarts *base.StreamStarts) *DcpDriver {
 	dcpDriver := &DcpDriver{
 		Name:                  name,
 		url:                   url,
@@ -124,19 +162,54 @@ func NewDcpDriver(logger *xdcrLog.CommonLogger, name, url, bucketName string, re
 		mobileCompatible:      mobileCompat,
 		expDelMode:            expDelMode,
 		xattrKeysForNoCompare: xattrKeysForNoCompare,
+		keyPrefixesToSkip:     keyPrefixesToSkip,
+		syncGatewayMode:       syncGatewayMode,
+		dcpBufferSize:         dcpBufferSize,
+		useOSO:                useOSO,
+		noValue:               noValue,
+		mutationObserver:      mutationObserver,
+		pauseGate:             pauseGate,
+		agentPool:             agentPool,
+		vbucketCaptured:       vbucketCaptured,
+		rangeScan:             rangeScan,
+		rangeScanSamples:      rangeScanSamples,
+		persistenceWait:       persistenceWait,
+		distribution:          results.NewDistributionRecorder(),
+		docsReceived:          stats.Default.Counter(fmt.Sprintf(stats.DcpDocsReceived, name)),
+		sysOrUnsubbedReceived: stats.Default.Counter(fmt.Sprintf(stats.DcpSysOrUnsubbedReceived, name)),
+		docsSkipped:           stats.Default.Counter(fmt.Sprintf(stats.DcpDocsSkipped, name)),
+		bytesReceived:         stats.Default.Counter(fmt.Sprintf(stats.ClusterBytesRead, name)),
+		readUnits:             stats.Default.Counter(fmt.Sprintf(stats.ClusterReadUnits, name)),
 	}
 
+	if bufferHighWatermark > 0 {
+		dcpDriver.bufferPool = NewBufferPool(bufferCap, bufferPoolSize, bufferHighWatermark,
+			stats.Default.Gauge(fmt.Sprintf(stats.DcpCaptureBuffersInFlight, name)))
+	}
+
+	// An empty vbuckets list means that all vbuckets are streamed
+	requested := make(map[uint16]bool)
+	for _, vbno := range vbuckets {
+		requested[vbno] = true
+	}
 	var vbno uint16
 	for vbno = 0; vbno < base.NumberOfVbuckets; vbno++ {
+		vbState := VBStateNormal
+		if len(requested) == 0 || requested[vbno] {
+			dcpDriver.vbuckets = append(dcpDriver.vbuckets, vbno)
+		} else {
+			// Nothing to stream, so the vbucket counts as completed from the start
+			vbState = VBStateStreamClosed
+		}
 		dcpDriver.vbStateMap[vbno] = &VBStateWithLock{
-			vbState: VBStateNormal,
+			vbState: vbState,
 		}
 	}
 
 	dcpDriver.checkpointManager = NewCheckpointManager(dcpDriver, checkpointFileDir, oldCheckpointFileName,
 		newCheckpointFileName, name, bucketOpTimeout, maxNumOfGetStatsRetry,
 		getStatsRetryInterval, getStatsMaxBackoff, checkpointInterval, dcpDriver.startVbtsDoneChan, logger,
-		completeBySeqno)
+		completeBySeqno, streamStarts)
 
 	base.TagHttpPrefix(&dcpDriver.url)
 
@@ -160,6 +233,7 @@ func (d *DcpDriver) Start() error {
 
 	d.logger.Infof("%v started checkpoint manager.\n", d.Name)
 
+	d.sizeFlowControl()
 	d.initializeDcpClients()
 
 	err = d.startDcpClients()
@@ -217,7 +291,7 @@ func (d *DcpDriver) Stop() error {
 	}
 
 	d.logger.Infof("Dcp driver %v stopping after receiving %v mutations (%v system + unsubscribed events)\n", d.Name,
-		atomic.LoadUint64(&d.totalNumReceivedFromDCP), atomic.LoadUint64(&d.totalSysOrUnsubbedEventReceivedFromDCP))
+		d.docsReceived.Value(), d.sysOrUnsubbedReceived.Value())
 	defer d.logger.Infof("Dcp driver %v stopped\n", d.Name)
 	defer d.waitGroup.Done()
 
@@ -244,6 +318,11 @@ func (d *DcpDriver) Stop() error {
 	return nil
 }
 
+// Records how far each vbucket has been captured, so that a run can be resumed from there
+func (d *DcpDriver) SaveCheckpoint() error {
+	return d.checkpointManager.SaveCheckpoint()
+}
+
 func (d *DcpDriver) FilteredCount() int64 {
 	var vbno uint16
 	var filtered int64
@@ -253,17 +332,94 @@ func (d *DcpDriver) FilteredCount() int64 {
 	return filtered
 }
 
+// Sizes the DCP buffer and the number of DCP clients, i.e. connections per node, that are not specified from the bucket
+func (d *DcpDriver) sizeFlowControl() {
+	if d.dcpBufferSize != 0 && d.numberOfClients > 0 {
+		return
+	}
+
+	var ramQuota, itemCount uint64
+	bucketInfo, _, err := d.bucketValidationInfo()
+	if err == nil {
+		ramQuota, itemCount, err = utils.GetBucketSizeFromBucketInfo(d.bucketName, bucketInfo)
+	}
+	if err != nil {
+		d.logger.Warnf("%v unable to get the size of bucket %v. Sizing DCP flow control for a small bucket. err=%v\n", d.Name, d.bucketName, err)
+	}
+
+	bufferSize, connections := utils.DcpFlowControlForBucket(ramQuota, itemCount)
+	if d.dcpBufferSize == 0 {
+		d.dcpBufferSize = bufferSize
+	}
+	if d.numberOfClients <= 0 {
+		d.numberOfClients = connections
+		d.clients = make([]*DcpClient, d.numberOfClients)
+	}
+	if d.dcpBufferSize == base.DcpFlowControlOff {
+		d.logger.Infof("%v DCP flow control off, connections per node=%v\n", d.Name, d.numberOfClients)
+	} else {
+		d.logger.Infof("%v DCP buffer size=%v bytes, connections per node=%v\n", d.Name, d.dcpBufferSize, d.numberOfClients)
+	}
+}
+
+func (d *DcpDriver) bucketValidationInfo() (bucketInfo map[string]interface{}, conflictResolutionType string, err error) {
+	connStr, err := d.ref.MyConnectionStr()
+	if err != nil {
+		return nil, "", err
+	}
+	bucketInfo, _, _, conflictResolutionType, _, _, err = d.utils.BucketValidationInfo(connStr, d.bucketName, d.ref.UserName(),
+		d.ref.Password(), d.ref.HttpAuthMech(), d.ref.Certificates(), d.ref.SANInCertificate(),
+		d.ref.ClientCertificate(), d.ref.ClientKey(), d.logger)
+	return bucketInfo, conflictResolutionType, err
+}
+
+// Bucket info from the cluster, i.e. for its quota and basic stats
+func (d *DcpDriver) BucketInfo() (map[string]interface{}, error) {
+	bucketInfo, _, err := d.bucketValidationInfo()
+	return bucketInfo, err
+}
+
+// Whether the bucket resolves conflicts by timestamp rather than by revision
+func (d *DcpDriver) IsLWW() bool {
+	_, conflictResolutionType, err := d.bucketValidationInfo()
+	if err != nil {
+		d.logger.Warnf("%v unable to get the conflict resolution type of bucket %v. err=%v\n", d.Name, d.bucketName, err)
+		return false
+	}
+	return conflictResolutionType == xdcrBase.ConflictResolutionType_Lww
+}
+
+// The driver is named after the label of its cluster
+func (d *DcpDriver) IsSource() bool {
+	return d.Name == base.SourceClusterLabel
+}
+
+func (d *DcpDriver) Distribution() *results.DocumentDistribution {
+	return d.distribution.Snapshot()
+}
+
+// Clocks of the KV nodes of the bucket, measured when the driver started
+func (d *DcpDriver) Clocks() []*results.NodeClock {
+	return d.checkpointManager.clocks
+}
+
 func (d *DcpDriver) initializeDcpClients() {
 	d.stateLock.Lock()
 	defer d.stateLock.Unlock()
 
-	loadDistribution := utils.BalanceLoad(d.numberOfClients, base.NumberOfVbuckets)
+	// A client without any vbucket cannot be started
+	if d.numberOfClients > len(d.vbuckets) {
+		d.numberOfClients = len(d.vbuckets)
+		d.clients = d.clients[:d.numberOfClients]
+	}
+
+	loadDistribution := utils.BalanceLoad(d.numberOfClients, len(d.vbuckets))
 	for i := 0; i < d.numberOfClients; i++ {
 		lowIndex := loadDistribution[i][0]
 		highIndex := loadDistribution[i][1]
 		vbList := make([]uint16, highIndex-lowIndex)
 		for j := lowIndex; j < highIndex; j++ {
-			vbList[j-lowIndex] = uint16(j)
+			vbList[j-lowIndex] = d.vbuckets[j]
 		}
 
 		d.childWaitGroup.Add(1)
@@ -312,6 +468,7 @@ func (d *DcpDriver) reportError(err error) {
 		d.logger.Infof("%s dcp driver encountered error=%v\n", d.Name, err)
 	}
 
+	base.Progress.Error(d.Name, err)
 	utils.AddToErrorChan(d.errChan, err)
 }
 
@@ -329,6 +486,7 @@ func (d *DcpDriver) handleVbucketCompletion(vbno uint16, err error, reason strin
 		wrappedErr := fmt.Errorf("%v Vbno %v vbucket completed with err %v - %v", d.Name, vbno, err, reason)
 		d.reportError(wrappedErr)
 	} else {
+		base.Progress.Vbucket(d.Name, vbno)
 		if d.completeBySeqno {
 			vbStateWithLock := d.vbStateMap[vbno]
 			vbStateWithLock.lock.Lock()
@@ -340,6 +498,17 @@ func (d *DcpDriver) handleVbucketCompletion(vbno uint16, err error, reason strin
 	}
 }
 
+// Removes what was captured of a vbucket before, so that it can be captured again from the start
+func (d *DcpDriver) discardCapture(vbno uint16) error {
+	for i := 0; i < d.numberOfBins; i++ {
+		base.CaptureMemory.Remove(utils.GetFileName(d.fileDir, vbno, i))
+		if err := os.Remove(utils.GetFileName(d.fileDir, vbno, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
 func (d *DcpDriver) getVbState(vbno uint16) VBState {
 	vbStateWithLock := d.vbStateMap[vbno]
 	vbStateWithLock.lock.RLock()
@@ -355,9 +524,19 @@ func (d *DcpDriver) setVbState(vbno uint16, vbState VBState) {
 }
 
 func (d *DcpDriver) IncrementDocReceived() {
-	atomic.AddUint64(&d.totalNumReceivedFromDCP, 1)
+	d.docsReceived.Add(1)
 }
 
 func (d *DcpDriver) IncrementSysOrUnsubbedEventReceived() {
-	atomic.AddUint64(&d.totalSysOrUnsubbedEventReceivedFromDCP, 1)
+	d.sysOrUnsubbedReceived.Add(1)
+}
+
+func (d *DcpDriver) shouldSkipKey(key []byte) bool {
+	for _, prefix := range d.keyPrefixesToSkip {
+		if bytes.HasPrefix(key, []byte(prefix)) {
+			d.docsSkipped.Add(1)
+			return true
+		}
+	}
+	return false
 }
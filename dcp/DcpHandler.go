@@ -10,12 +10,15 @@
 package dcp
 
 import (
+	"bytes"
 	"crypto/sha512"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"sort"
-	"strings"
 	"sync"
 	"xdcrDiffer/base"
 	fdp "xdcrDiffer/fileDescriptorPool"
@@ -58,6 +61,9 @@ type DcpHandler struct {
 	mobileCompatible              int
 	expDelMode                    xdcrBase.FilterExpDelType
 	xattrIterator                 *xdcrBase.XattrIterator
+	writer                        *captureWriter
+	// Vbuckets whose capture files have been closed and handed off to vbucketCaptured
+	handedOff map[uint16]bool
 }
 
 func NewDcpHandler(dcpClient *DcpClient, fileDir string, index int, vbList []uint16, numberOfBins, dataChanSize int, fdPool fdp.FdPoolIface, incReceivedCounter, incSysOrUnsubbedEvtReceived func(), colMigrationFilters []string, utils xdcrUtils.UtilsIface, bufferCap int, migrationMapping metadata.CollectionNamespaceMapping) (*DcpHandler, error) {
@@ -73,6 +79,7 @@ func NewDcpHandler(dcpClient *DcpClient, fileDir string, index int, vbList []uin
 		dataChan:                      make(chan *Mutation, dataChanSize),
 		finChan:                       make(chan bool),
 		bucketMap:                     make(map[uint16]map[int]*Bucket),
+		handedOff:                     make(map[uint16]bool),
 		fdPool:                        fdPool,
 		logger:                        dcpClient.logger,
 		filter:                        dcpClient.dcpDriver.filter,
@@ -81,7 +88,7 @@ func NewDcpHandler(dcpClient *DcpClient, fileDir string, index int, vbList []uin
 		colMigrationFilters:           colMigrationFilters,
 		colMigrationFiltersOn:         len(colMigrationFilters) > 0,
 		utils:                         utils,
-		isSource:                      strings.Contains(dcpClient.Name, base.SourceClusterName),
+		isSource:                      dcpClient.dcpDriver.IsSource(),
 		bufferCap:                     bufferCap,
 		migrationMapping:              migrationMapping,
 		mobileCompatible:              dcpClient.dcpDriver.mobileCompatible,
@@ -126,11 +133,22 @@ func (d *DcpHandler) compileMigrCollectionFiltersIfNeeded() error {
 }
 
 func (dh *DcpHandler) initialize() error {
+	if bufferPool := dh.dcpClient.dcpDriver.bufferPool; bufferPool != nil {
+		dh.writer = newCaptureWriter(bufferPool)
+	}
 	for _, vbno := range dh.vbList {
 		innerMap := make(map[int]*Bucket)
 		dh.bucketMap[vbno] = innerMap
+		// Capture files linked from a previous run are shared with it, and get a copy of their own before
+		// anything is appended to them
+		streamed := !dh.dcpClient.dcpDriver.checkpointManager.GetStartVBTS(vbno).NoNeedToStartDcpStream
 		for i := 0; i < dh.numberOfBins; i++ {
-			bucket, err := NewBucket(dh.fileDir, vbno, i, dh.fdPool, dh.logger, dh.bufferCap)
+			if streamed {
+				if err := base.UnshareFile(utils.GetFileName(dh.fileDir, vbno, i)); err != nil {
+					return err
+				}
+			}
+			bucket, err := NewBucket(dh.fileDir, vbno, i, dh.fdPool, dh.logger, dh.bufferCap, dh.writer, dh.dcpClient.dcpDriver.noValue)
 			if err != nil {
 				return err
 			}
@@ -146,6 +164,9 @@ func (dh *DcpHandler) initialize() error {
 
 func (dh *DcpHandler) cleanup() {
 	for _, vbno := range dh.vbList {
+		if dh.handedOff[vbno] {
+			continue
+		}
 		innerMap := dh.bucketMap[vbno]
 		if innerMap == nil {
 			dh.logger.Warnf("Cannot find innerMap for Vbno %v at cleanup\n", vbno)
@@ -161,6 +182,9 @@ func (dh *DcpHandler) cleanup() {
 			bucket.close()
 		}
 	}
+	if dh.writer != nil {
+		dh.writer.stop()
+	}
 }
 
 func (dh *DcpHandler) processData() {
@@ -173,16 +197,55 @@ func (dh *DcpHandler) processData() {
 		case <-dh.finChan:
 			goto done
 		case mut := <-dh.dataChan:
-			dh.processMutation(mut)
+			if !dh.dcpClient.dcpDriver.pauseGate.Wait(dh.finChan) {
+				goto done
+			}
+			if mut.IsStreamReopen() {
+				dh.reopenStream(mut)
+			} else if !mut.IsStreamEnd() {
+				dh.processMutation(mut)
+			}
+			dh.handOffIfCaptured(mut.Vbno)
 		}
 	}
 done:
 }
 
+// Once a vbucket has completed, the mutations up to its end seqno have all gone through the data channel, so its
+// capture files can be closed and compared while the other vbuckets are still being streamed
+func (dh *DcpHandler) handOffIfCaptured(vbno uint16) {
+	vbucketCaptured := dh.dcpClient.dcpDriver.vbucketCaptured
+	if vbucketCaptured == nil || dh.handedOff[vbno] || dh.dcpClient.dcpDriver.getVbState(vbno) == VBStateNormal {
+		return
+	}
+	for _, bucket := range dh.bucketMap[vbno] {
+		bucket.close()
+	}
+	dh.handedOff[vbno] = true
+	vbucketCaptured(vbno)
+}
+
+// Vbuckets that complete outside of the data channel, i.e. when their stream ends or there is nothing to stream,
+// are handed off through it, after the mutations already queued
+func (dh *DcpHandler) notifyVbucketCompleted(vbno uint16) {
+	if dh.dcpClient.dcpDriver.vbucketCaptured == nil {
+		return
+	}
+	dh.writeToDataChan(CreateMutation(vbno, nil, 0, 0, 0, 0, 0, gomemcached.UPR_STREAMEND, nil, 0, base.Uint32MaxVal, nil, nil))
+}
+
 func (dh *DcpHandler) processMutation(mut *Mutation) {
 	var matched bool
 	var replicationFilterResult base.FilterResultType
 
+	// OSO markers go through the data channel so that they are handled in order with the mutations they enclose
+	if mut.IsOSOSnapshot() {
+		dh.dcpClient.dcpDriver.checkpointManager.handleOSOSnapshot(mut.Vbno, mut.Flags)
+		return
+	}
+	dh.dcpClient.dcpDriver.bytesReceived.Add(int64(len(mut.Key) + len(mut.Value)))
+	dh.dcpClient.dcpDriver.readUnits.Add(base.ReadUnits(len(mut.Key) + len(mut.Value)))
+
 	replicationFilterResult = dh.replicationFilter(mut, matched, replicationFilterResult)
 	valid := dh.dcpClient.dcpDriver.checkpointManager.HandleMutationEvent(mut, replicationFilterResult)
 	if !valid {
@@ -200,6 +263,16 @@ func (dh *DcpHandler) processMutation(mut *Mutation) {
 		return
 	}
 
+	// Documents that legitimately differ across clusters, such as transaction metadata, are not compared
+	if dh.dcpClient.dcpDriver.shouldSkipKey(mut.Key) {
+		return
+	}
+
+	if observer := dh.dcpClient.dcpDriver.mutationObserver; observer != nil &&
+		dh.dcpClient.dcpDriver.checkpointManager.isLive(mut.Vbno, mut.Seqno) {
+		observer(mut)
+	}
+
 	var filterIdsMatched []uint8
 	if dh.colMigrationFiltersOn && dh.isSource {
 		dh.checkColMigrationDataCloned(mut)
@@ -224,7 +297,17 @@ func (dh *DcpHandler) processMutation(mut *Mutation) {
 	if dh.colMigrationFiltersOn && len(filterIdsMatched) > 0 {
 		mut.ColFiltersMatched = filterIdsMatched
 	}
-	ret, err := mut.Serialize()
+	// Sizes of documents streamed without their bodies say nothing of the documents
+	if !dh.dcpClient.dcpDriver.noValue {
+		if mut.IsMutation() {
+			dh.dcpClient.dcpDriver.distribution.Record(len(mut.Value), mut.Datatype&base.JSONDataType > 0,
+				mut.Datatype&base.SnappyDataType > 0, mut.Datatype&xdcrBase.XattrDataType > 0)
+		} else {
+			dh.dcpClient.dcpDriver.distribution.RecordTombstone()
+		}
+	}
+	mut.SyncGatewayMode = dh.dcpClient.dcpDriver.syncGatewayMode
+	ret, err := mut.Serialize(bucket.header)
 	if err != nil {
 		dh.logger.Errorf("Error in Serializing the mutation pertaining to the document with the key:%v ,err:%v\n", mut.Key, err)
 	} else {
@@ -261,6 +344,10 @@ func (dh *DcpHandler) SnapshotMarker(snapshot gocbcore.DcpSnapshotMarker) {
 }
 
 func (dh *DcpHandler) Mutation(mutation gocbcore.DcpMutation) {
+	if base.Faults.Inject(base.FaultDcpDisconnect) {
+		dh.End(gocbcore.DcpStreamEnd{VbID: mutation.VbID}, base.ErrInjectedDcpDisconnect)
+		return
+	}
 	dh.writeToDataChan(CreateMutation(mutation.VbID, mutation.Key, mutation.SeqNo, mutation.RevNo, mutation.Cas, mutation.Flags, mutation.Expiry, gomemcached.UPR_MUTATION, mutation.Value, mutation.Datatype, mutation.CollectionID, dh.xattrIterator, dh.dcpClient.dcpDriver.xattrKeysForNoCompare))
 }
 
@@ -273,7 +360,11 @@ func (dh *DcpHandler) Expiration(expiration gocbcore.DcpExpiration) {
 }
 
 func (dh *DcpHandler) End(streamEnd gocbcore.DcpStreamEnd, err error) {
+	if dh.reopenOnEnd(streamEnd.VbID, err) {
+		return
+	}
 	dh.dcpClient.dcpDriver.handleVbucketCompletion(streamEnd.VbID, err, "dcp stream ended")
+	dh.notifyVbucketCompleted(streamEnd.VbID)
 }
 
 // want CreateCollection("github.com/couchbase/gocbcore/v10".DcpCollectionCreation)
@@ -305,7 +396,8 @@ func (dh *DcpHandler) ModifyCollection(modify gocbcore.DcpCollectionModification
 }
 
 func (dh *DcpHandler) OSOSnapshot(oso gocbcore.DcpOSOSnapshot) {
-	// Don't care
+	// The snapshot type, i.e. start or end, is carried as the flags
+	dh.writeToDataChan(CreateMutation(oso.VbID, nil, 0, 0, 0, oso.SnapshotType, 0, gomemcached.DCP_OSO_SNAPSHOT, nil, 0, base.Uint32MaxVal, nil, nil))
 }
 
 func (dh *DcpHandler) SeqNoAdvanced(seqnoAdv gocbcore.DcpSeqNoAdvanced) {
@@ -356,47 +448,126 @@ type Bucket struct {
 
 	fdPoolCb fdp.FileOp
 	closeOp  func() error
+	// The file is registered with the pool once the capture spills to disk, when it starts in memory
+	fdPool fdp.FdPoolIface
 
 	logger *xdcrLog.CommonLogger
 
 	bufferCap int
+
+	// layout of the records, as specified by the capture file header
+	header *base.CaptureFileHeader
+
+	// writes filled buffers in the background if set, else they are written in place
+	writer        *captureWriter
+	pendingWrites sync.WaitGroup
+	writeErrLock  sync.Mutex
+	writeErr      error
 }
 
-func NewBucket(fileDir string, vbno uint16, bucketIndex int, fdPool fdp.FdPoolIface, logger *xdcrLog.CommonLogger, bufferCap int) (*Bucket, error) {
+// noValue is whether the mutations written to the bucket were streamed without their bodies
+func NewBucket(fileDir string, vbno uint16, bucketIndex int, fdPool fdp.FdPoolIface, logger *xdcrLog.CommonLogger, bufferCap int, writer *captureWriter, noValue bool) (*Bucket, error) {
 	fileName := utils.GetFileName(fileDir, vbno, bucketIndex)
 	var cb fdp.FileOp
 	var closeOp func() error
 	var err error
 	var file *os.File
 
-	if fdPool == nil {
+	headerBytes, header, err := prepareCaptureFile(fileName, noValue)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case base.CaptureMemory.InMemory():
+		// The file is only opened should the capture spill to disk
+	case fdPool == nil:
 		file, err = os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY|os.O_CREATE, base.FileModeReadWrite)
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		_, cb, err = fdPool.RegisterFileHandle(fileName)
+	default:
+		cb, closeOp, err = registerWithFdPool(fdPool, fileName)
 		if err != nil {
 			return nil, err
 		}
-		closeOp = func() error {
-			return fdPool.DeRegisterFileHandle(fileName)
-		}
 	}
-	return &Bucket{
+	bucket := &Bucket{
 		data:      make([]byte, bufferCap),
 		index:     0,
 		file:      file,
 		fileName:  fileName,
 		fdPoolCb:  cb,
 		closeOp:   closeOp,
+		fdPool:    fdPool,
 		logger:    logger,
 		bufferCap: bufferCap,
+		header:    header,
+		writer:    writer,
+	}
+	if len(headerBytes) > 0 {
+		// A new capture file always starts with the header, which goes out with the first flush
+		copy(bucket.data, headerBytes)
+		bucket.index = len(headerBytes)
+	}
+	return bucket, nil
+}
+
+func registerWithFdPool(fdPool fdp.FdPoolIface, fileName string) (fdp.FileOp, func() error, error) {
+	_, cb, err := fdPool.RegisterFileHandle(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cb, func() error {
+		return fdPool.DeRegisterFileHandle(fileName)
 	}, nil
 }
 
+// Figures out the layout to use when appending to the given capture file
+// A new or empty file gets the current header, which is returned to be written out first
+// An existing file, i.e. one being resumed from a checkpoint, keeps the layout it was created with
+// Records streamed with and without values cannot be mixed in one file, as their body hashes cannot be compared
+func prepareCaptureFile(fileName string, noValue bool) ([]byte, *base.CaptureFileHeader, error) {
+	fileInfo, err := os.Stat(fileName)
+	if os.IsNotExist(err) || err == nil && fileInfo.Size() == 0 {
+		header := base.NewCaptureFileHeader()
+		if noValue {
+			header.Flags |= base.CaptureFileFlagNoValue
+		}
+		return header.Encode(), header, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	headerBytes := make([]byte, base.CaptureFileHeaderLen)
+	bytesRead, err := io.ReadFull(file, headerBytes)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, nil, err
+	}
+	header, err := base.DecodeCaptureFileHeader(headerBytes[:bytesRead])
+	if err == base.ErrNoCaptureFileHeader {
+		header = base.NewLegacyCaptureFileHeader()
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("Unable to append to capture file %v: %v", fileName, err)
+	}
+	if header.HasNoValue() != noValue {
+		return nil, nil, fmt.Errorf("Unable to append to capture file %v: it was captured with noValue=%v, not %v", fileName, header.HasNoValue(), noValue)
+	}
+	return nil, header, nil
+}
+
 func (b *Bucket) write(item []byte) error {
-	if b.index+len(item) > b.bufferCap {
+	itemLen := len(item)
+	if b.header.HasRecordChecksum() {
+		itemLen += base.CaptureRecordChecksumLen
+	}
+	if b.index+itemLen > b.bufferCap {
 		err := b.flushToFile()
 		if err != nil {
 			return err
@@ -405,39 +576,116 @@ func (b *Bucket) write(item []byte) error {
 
 	copy(b.data[b.index:], item)
 	b.index += len(item)
+	if b.header.HasRecordChecksum() {
+		binary.BigEndian.PutUint32(b.data[b.index:b.index+base.CaptureRecordChecksumLen], base.CaptureChecksum(item))
+		b.index += base.CaptureRecordChecksumLen
+	}
 	return nil
 }
 
 func (b *Bucket) flushToFile() error {
+	if b.writer == nil {
+		err := b.writeToFile(b.data[:b.index])
+		if err != nil {
+			return err
+		}
+		b.index = 0
+		return nil
+	}
+
+	// An earlier write in the background failed
+	if err := b.getWriteErr(); err != nil {
+		return err
+	}
+	if b.index == 0 {
+		return nil
+	}
+	filled := b.data[:b.index]
+	b.data = b.writer.pool.Get()
+	b.index = 0
+	b.writer.submit(b, filled)
+	return nil
+}
+
+func (b *Bucket) writeToFile(data []byte) error {
 	var numOfBytes int
 	var err error
 
+	// An injected partial write only writes the first half of the data, as a full disk would
+	toWrite := data
+	if base.Faults.Inject(base.FaultPartialWrite) {
+		toWrite = data[:len(data)/2]
+	}
 	if b.fdPoolCb != nil {
-		numOfBytes, err = b.fdPoolCb(b.data[:b.index])
+		numOfBytes, err = b.fdPoolCb(toWrite)
 	} else {
-		numOfBytes, err = b.file.Write(b.data[:b.index])
+		numOfBytes, err = b.writeToFileOrMemory(toWrite)
 	}
 	if err != nil {
 		return err
 	}
-	if numOfBytes != b.index {
-		return fmt.Errorf("Incomplete write. expected=%v, actual=%v", b.index, numOfBytes)
+	if numOfBytes != len(data) {
+		return fmt.Errorf("Incomplete write. expected=%v, actual=%v", len(data), numOfBytes)
 	}
-	b.index = 0
 	return nil
 }
 
+// Without a file, the data is held in memory until the capture spills to disk, from when on the file is written,
+// through the file descriptor pool if there is one
+func (b *Bucket) writeToFileOrMemory(data []byte) (int, error) {
+	if b.file == nil {
+		inMemory, err := base.CaptureMemory.Append(b.fileName, data)
+		if err != nil {
+			return 0, err
+		}
+		if inMemory {
+			return len(data), nil
+		}
+		if b.fdPool != nil {
+			// Later writes go to the pool directly
+			if b.fdPoolCb, b.closeOp, err = registerWithFdPool(b.fdPool, b.fileName); err != nil {
+				return 0, err
+			}
+			return b.fdPoolCb(data)
+		}
+		if b.file, err = os.OpenFile(b.fileName, os.O_APPEND|os.O_WRONLY|os.O_CREATE, base.FileModeReadWrite); err != nil {
+			return 0, err
+		}
+	}
+	return b.file.Write(data)
+}
+
+func (b *Bucket) setWriteErr(err error) {
+	b.writeErrLock.Lock()
+	defer b.writeErrLock.Unlock()
+	if b.writeErr == nil {
+		b.writeErr = err
+	}
+}
+
+func (b *Bucket) getWriteErr() error {
+	b.writeErrLock.Lock()
+	defer b.writeErrLock.Unlock()
+	return b.writeErr
+}
+
 func (b *Bucket) close() {
 	err := b.flushToFile()
 	if err != nil {
 		b.logger.Errorf("Error flushing to file %v at bucket close err=%v\n", b.fileName, err)
 	}
+	if b.writer != nil {
+		b.pendingWrites.Wait()
+		if err = b.getWriteErr(); err != nil {
+			b.logger.Errorf("Error writing to file %v err=%v\n", b.fileName, err)
+		}
+	}
 	if b.fdPoolCb != nil {
 		err = b.closeOp()
 		if err != nil {
 			b.logger.Errorf("Error closing file %v.  err=%v\n", b.fileName, err)
 		}
-	} else {
+	} else if b.file != nil {
 		err = b.file.Close()
 		if err != nil {
 			b.logger.Errorf("Error closing file %v.  err=%v\n", b.fileName, err)
@@ -445,6 +693,36 @@ func (b *Bucket) close() {
 	}
 }
 
+// Writes mutations of a vbucket to its capture files the same way DCP handlers do, i.e. for documents that do not
+// come from a DCP stream. Every bin gets a capture file, even if no mutation falls into it
+func WriteCaptureFiles(fileDir string, vbno uint16, numberOfBins int, mutations []*Mutation, logger *xdcrLog.CommonLogger) error {
+	buckets := make([]*Bucket, 0, numberOfBins)
+	defer func() {
+		for _, bucket := range buckets {
+			bucket.close()
+		}
+	}()
+	for i := 0; i < numberOfBins; i++ {
+		bucket, err := NewBucket(fileDir, vbno, i, nil, logger, base.BucketBufferCapacity, nil, false)
+		if err != nil {
+			return err
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	for _, mut := range mutations {
+		bucket := buckets[utils.GetBucketIndexFromKey(mut.Key, numberOfBins)]
+		serialized, err := mut.Serialize(bucket.header)
+		if err != nil {
+			return err
+		}
+		if err = bucket.write(serialized); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type Mutation struct {
 	Vbno                  uint16
 	Key                   []byte
@@ -460,6 +738,8 @@ type Mutation struct {
 	ColFiltersMatched     []uint8 // Given a ordered list of filters, this list contains indexes of the ordered list of filter that matched
 	XattrIterator         *xdcrBase.XattrIterator
 	XattrKeysForNoCompare map[string]bool
+	// Whether to capture the Sync Gateway revision of the document
+	SyncGatewayMode bool
 }
 
 func CreateMutation(vbno uint16, key []byte, seqno, revId, cas uint64, flags, expiry uint32, opCode gomemcached.CommandCode, value []byte, datatype uint8, collectionId uint32, xattrIterator *xdcrBase.XattrIterator, xattrKeysForNoCompare map[string]bool) *Mutation {
@@ -492,6 +772,15 @@ func (m *Mutation) IsMutation() bool {
 	return m.OpCode == gomemcached.UPR_MUTATION
 }
 
+func (m *Mutation) IsOSOSnapshot() bool {
+	return m.OpCode == gomemcached.DCP_OSO_SNAPSHOT
+}
+
+// Marks the completion of a vbucket in the data channel. It is not a mutation of the vbucket
+func (m *Mutation) IsStreamEnd() bool {
+	return m.OpCode == gomemcached.UPR_STREAMEND
+}
+
 func (m *Mutation) IsSystemOrUnsubbedEvent() bool {
 	return m.OpCode == gomemcached.DCP_SYSTEM_EVENT || m.OpCode == gomemcached.DCP_SEQNO_ADV
 }
@@ -532,14 +821,25 @@ func (m *Mutation) ToUprEvent() *xdcrBase.WrappedUprEvent {
 //	Expiry   - 4 bytes
 //	opType   - 2 byte
 //	Datatype - 2 byte
-//	hash     - 64 bytes
+//	importCas - 8 bytes
+//	pRev     - 8 bytes
+//	hlvLen   - 8 bytes
+//	hlv      - length specified by hlvLen
+//	hash     - 64 bytes (of the body alone when the header has CaptureFileFlagXattrHash set, else of the compared xattrs and the body)
+//	xattrHash - 8 bytes (only when the header has CaptureFileFlagXattrHash set)
+//	syncRevLen - 2 bytes (only when the header has CaptureFileFlagSyncRev set)
+//	syncRev  - length specified by syncRevLen
+//	valueSize - 4 bytes (only when the header has CaptureFileFlagValueSize set)
 //	collectionId - 4 bytes
 //	colFiltersLen - 2 byte (number of collection migration filters)
 //	(per col filter) - 2 byte
 
 // Darshan:TODO accomodate SGW xattr change from "import" to "_mou" when MB-60897 is checked-in
-func (mut *Mutation) Serialize() ([]byte, error) {
+func (mut *Mutation) Serialize(header *base.CaptureFileHeader) ([]byte, error) {
 	var bodyHash [64]byte
+	var xattrHash [base.CaptureXattrHashLen]byte
+	var syncRev string
+	withXattrHash := header.HasXattrHash()
 	var xattrSize uint32
 	var xattr []byte
 	var bodyWithoutXattr, trimmedXattrPlusBody, hlv []byte
@@ -565,14 +865,34 @@ func (mut *Mutation) Serialize() ([]byte, error) {
 				return nil, err
 			}
 		}
-		bodyHash = sha512.Sum512(trimmedXattrPlusBody)
+		if mut.SyncGatewayMode {
+			syncRev = getSyncGatewayRev(xattr, xattrSize, mut.XattrIterator)
+		}
+		if withXattrHash {
+			// Documents that only carry data in xattrs, or whose xattrs are all excluded from comparison,
+			// must still be told apart from, or match, documents with the same body
+			bodyHash = sha512.Sum512(bodyWithoutXattr)
+			xattrHash = getXattrHash(trimmedXattrPlusBody, len(bodyWithoutXattr))
+		} else {
+			bodyHash = sha512.Sum512(trimmedXattrPlusBody)
+		}
 	} else {
 		bodyHash = sha512.Sum512(mut.Value)
 	}
 
 	hlvLen := uint64(len(hlv))
 	keyLen := len(mut.Key)
-	ret := make([]byte, base.GetFixedSizeMutationLen(keyLen, hlvLen, mut.ColFiltersMatched))
+	retLen := base.GetFixedSizeMutationLen(keyLen, hlvLen, mut.ColFiltersMatched)
+	if withXattrHash {
+		retLen += base.CaptureXattrHashLen
+	}
+	if header.HasSyncRev() {
+		retLen += 2 + len(syncRev)
+	}
+	if header.HasValueSize() {
+		retLen += 4
+	}
+	ret := make([]byte, retLen)
 
 	pos := 0
 	binary.BigEndian.PutUint16(ret[pos:pos+2], uint16(keyLen))
@@ -603,6 +923,20 @@ func (mut *Mutation) Serialize() ([]byte, error) {
 	pos += int(hlvLen)
 	copy(ret[pos:], bodyHash[:])
 	pos += 64
+	if withXattrHash {
+		copy(ret[pos:], xattrHash[:])
+		pos += base.CaptureXattrHashLen
+	}
+	if header.HasSyncRev() {
+		binary.BigEndian.PutUint16(ret[pos:pos+2], uint16(len(syncRev)))
+		pos += 2
+		copy(ret[pos:pos+len(syncRev)], syncRev)
+		pos += len(syncRev)
+	}
+	if header.HasValueSize() {
+		binary.BigEndian.PutUint32(ret[pos:pos+4], uint32(len(mut.Value)))
+		pos += 4
+	}
 	binary.BigEndian.PutUint32(ret[pos:pos+4], mut.ColId)
 	pos += 4
 	binary.BigEndian.PutUint16(ret[pos:pos+2], uint16(len(mut.ColFiltersMatched)))
@@ -614,6 +948,64 @@ func (mut *Mutation) Serialize() ([]byte, error) {
 	return ret, nil
 }
 
+// Returns the Sync Gateway revision of a document, i.e. "3-abc", which identifies its position in the revision tree
+// An empty revision is returned for documents that Sync Gateway has not imported, or whose _sync xattr cannot be
+// parsed, which are then compared like any other document
+func getSyncGatewayRev(xattr []byte, xattrSize uint32, xattrIterator *xdcrBase.XattrIterator) string {
+	err := xattrIterator.ResetXattrIterator(xattr, xattrSize)
+	if err != nil {
+		return ""
+	}
+	for xattrIterator.HasNext() {
+		key, value, err := xattrIterator.Next()
+		if err != nil {
+			return ""
+		}
+		if string(key) == xdcrBase.XATTR_MOBILE {
+			return parseSyncGatewayRev(bytes.TrimRight(value, "\x00"))
+		}
+	}
+	return ""
+}
+
+func parseSyncGatewayRev(syncXattr []byte) string {
+	var syncData struct {
+		Rev json.RawMessage `json:"rev"`
+	}
+	if err := json.Unmarshal(syncXattr, &syncData); err != nil || len(syncData.Rev) == 0 {
+		return ""
+	}
+	var rev string
+	if err := json.Unmarshal(syncData.Rev, &rev); err != nil {
+		// Newer versions of Sync Gateway keep the revision ID within an object
+		var revObj struct {
+			Rev string `json:"rev"`
+		}
+		if err = json.Unmarshal(syncData.Rev, &revObj); err != nil {
+			return ""
+		}
+		rev = revObj.Rev
+	}
+	if len(rev) > math.MaxUint16 {
+		return ""
+	}
+	return rev
+}
+
+// Hashes the xattr section of a document composed by removeKVSubsetFromXattr, which precedes the body
+// An empty hash is returned when none of the xattrs are left to compare
+func getXattrHash(trimmedXattrPlusBody []byte, bodyLen int) [base.CaptureXattrHashLen]byte {
+	var xattrHash [base.CaptureXattrHashLen]byte
+	// The xattr section is made up of its 4-byte length followed by the KV pairs
+	xattrLen := len(trimmedXattrPlusBody) - bodyLen
+	if xattrLen <= 4 {
+		return xattrHash
+	}
+	fullHash := sha512.Sum512(trimmedXattrPlusBody[0:xattrLen])
+	copy(xattrHash[:], fullHash[:base.CaptureXattrHashLen])
+	return xattrHash
+}
+
 // This is function is used to remove specified KVs from the xattr and create a new one excluding them
 // @param xattr - denotes the original xattr
 // @param size - denotes the max size of the new xattr+docBody
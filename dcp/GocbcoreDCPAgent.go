@@ -18,8 +18,9 @@ type GocbcoreDCPFeed struct {
 	dcpAgent *gocbcore.DCPAgent
 }
 
-func (f *GocbcoreDCPFeed) setupDCPAgent(auth interface{}, collections bool, ref *metadata.RemoteClusterReference) error {
-	agentConfig, shouldBeSecure, err := f.setupDCPAgentConfig(auth, collections, ref)
+// noValue opens the stream without document bodies, while xattrs are still streamed
+func (f *GocbcoreDCPFeed) setupDCPAgent(auth interface{}, collections bool, ref *metadata.RemoteClusterReference, bufferSize int, useOSO, noValue bool) error {
+	agentConfig, shouldBeSecure, err := f.setupDCPAgentConfig(auth, collections, ref, bufferSize, useOSO)
 	if err != nil {
 		return err
 	}
@@ -32,6 +33,7 @@ func (f *GocbcoreDCPFeed) setupDCPAgent(auth interface{}, collections bool, ref
 	}
 
 	dcpFeedParams := NewDCPFeedParams()
+	dcpFeedParams.NoValue = noValue
 
 	flags := memd.DcpOpenFlagProducer
 	if dcpFeedParams.IncludeXAttrs {
@@ -66,7 +68,10 @@ func NewDCPFeedParams() *DCPFeedParams {
 	return &DCPFeedParams{IncludeXAttrs: true}
 }
 
-func (f *GocbcoreDCPFeed) setupDCPAgentConfig(authMech interface{}, collections bool, ref *metadata.RemoteClusterReference) (*gocbcore.DCPAgentConfig, bool, error) {
+// bufferSize is the DCP flow control buffer of each connection, in bytes. 0 for the SDK default, or base.DcpFlowControlOff
+// The SDK acknowledges consumed bytes once half of the buffer is consumed, which cannot be changed
+// useOSO negotiates OSO (Out of Sequence Order) snapshots, which the server may then use for backfills
+func (f *GocbcoreDCPFeed) setupDCPAgentConfig(authMech interface{}, collections bool, ref *metadata.RemoteClusterReference, bufferSize int, useOSO bool) (*gocbcore.DCPAgentConfig, bool, error) {
 	useTLS, x509Provider, auth, err := getAgentConfigs(authMech, ref)
 	if err != nil {
 		return nil, false, err
@@ -74,6 +79,13 @@ func (f *GocbcoreDCPFeed) setupDCPAgentConfig(authMech interface{}, collections
 	if auth == nil {
 		panic("Nil auth")
 	}
+	dcpConfig := gocbcore.DCPConfig{UseOSOBackfill: useOSO}
+	if bufferSize == base.DcpFlowControlOff {
+		// Flow control is not negotiated at all, so there is nothing to acknowledge
+		dcpConfig.DisableBufferAcknowledgement = true
+	} else {
+		dcpConfig.BufferSize = bufferSize
+	}
 	return &gocbcore.DCPAgentConfig{
 		UserAgent:  f.Name,
 		BucketName: f.BucketName,
@@ -87,8 +99,9 @@ func (f *GocbcoreDCPFeed) setupDCPAgentConfig(authMech interface{}, collections
 			ConnectTimeout: f.SetupTimeout,
 		},
 		CompressionConfig: gocbcore.CompressionConfig{Enabled: true},
-		IoConfig:          gocbcore.IoConfig{UseCollections: collections},
+		IoConfig:          gocbcore.IoConfig{UseCollections: collections, NetworkType: f.NetworkType},
 		HTTPConfig:        gocbcore.HTTPConfig{ConnectTimeout: f.SetupTimeout},
+		DCPConfig:         dcpConfig,
 	}, useTLS, nil
 }
 
@@ -97,14 +110,19 @@ func getAgentConfigs(authMech interface{}, ref *metadata.RemoteClusterReference)
 	var useTLS bool
 
 	if ref.HttpAuthMech() == xdcrBase.HttpAuthMechHttps {
-		// https means we need to at a min return a root CA
-		ok := certPool.AppendCertsFromPEM(ref.Certificates())
-		if !ok {
-			return false, nil, nil, fmt.Errorf("Invalid rootCA %s", ref.Certificates())
+		// https means we need to at a min return a root CA, plus whatever the TLS policy trusts
+		var err error
+		certPool, err = base.TLSVerification.CertPool(ref.Certificates())
+		if err != nil {
+			return false, nil, nil, err
 		}
 		useTLS = true
 	}
 	x509Provider := func() *x509.CertPool {
+		if base.TLSVerification.SDKSkipsVerification() {
+			// Verified by base.TLSVerification.Probe instead
+			return nil
+		}
 		return certPool
 	}
 
@@ -163,13 +181,14 @@ func (f *GocbcoreDCPFeed) setupGocbcoreDCPAgent(config *gocbcore.DCPAgentConfig,
 	return
 }
 
-func NewGocbcoreDCPFeed(id string, servers []string, bucketName string, auth interface{}, collections bool, ref *metadata.RemoteClusterReference) (*GocbcoreDCPFeed, error) {
+func NewGocbcoreDCPFeed(id string, servers []string, bucketName string, auth interface{}, collections bool, ref *metadata.RemoteClusterReference, bufferSize int, useOSO, noValue bool, networkType string) (*GocbcoreDCPFeed, error) {
 	gocbcoreDcpFeed := &GocbcoreDCPFeed{
 		GocbcoreAgentCommon: base.GocbcoreAgentCommon{
 			Name:         id,
 			Servers:      servers,
 			BucketName:   bucketName,
 			SetupTimeout: time.Duration(base.SetupTimeoutSeconds) * time.Second,
+			NetworkType:  networkType,
 		},
 		dcpAgent: nil,
 	}
@@ -178,6 +197,6 @@ func NewGocbcoreDCPFeed(id string, servers []string, bucketName string, auth int
 		panic("nil auth")
 	}
 
-	err := gocbcoreDcpFeed.setupDCPAgent(auth, collections, ref)
+	err := gocbcoreDcpFeed.setupDCPAgent(auth, collections, ref, bufferSize, useOSO, noValue)
 	return gocbcoreDcpFeed, err
 }
@@ -0,0 +1,124 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package dcp
+
+import (
+	"sync"
+	"xdcrDiffer/stats"
+)
+
+// Buffers that a bucket swaps in while its filled buffer is written out in the background
+// At most highWatermark buffers can be waiting to be written at once. Beyond that, submitting another one blocks
+// until a write completes, which in turn stops the DCP handler from consuming mutations, so that a slow disk
+// throttles the DCP stream instead of letting memory grow. Up to poolSize written buffers are kept to be reused
+type BufferPool struct {
+	bufferSize    int
+	poolSize      int
+	highWatermark int
+
+	lock        sync.Mutex
+	cond        *sync.Cond
+	inFlight    int
+	free        [][]byte
+	inFlightCnt *stats.Gauge
+}
+
+func NewBufferPool(bufferSize, poolSize, highWatermark int, inFlightGauge *stats.Gauge) *BufferPool {
+	pool := &BufferPool{
+		bufferSize:    bufferSize,
+		poolSize:      poolSize,
+		highWatermark: highWatermark,
+		inFlightCnt:   inFlightGauge,
+	}
+	pool.cond = sync.NewCond(&pool.lock)
+	return pool
+}
+
+func (p *BufferPool) Get() []byte {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.free) > 0 {
+		buffer := p.free[len(p.free)-1]
+		p.free = p.free[:len(p.free)-1]
+		return buffer
+	}
+	return make([]byte, p.bufferSize)
+}
+
+// Blocks until another buffer can be waiting to be written
+func (p *BufferPool) reserve() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for p.inFlight >= p.highWatermark {
+		p.cond.Wait()
+	}
+	p.inFlight++
+	p.inFlightCnt.Set(int64(p.inFlight))
+}
+
+// Returns a buffer that has been written out
+func (p *BufferPool) Put(buffer []byte) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.inFlight--
+	p.inFlightCnt.Set(int64(p.inFlight))
+	if len(p.free) < p.poolSize && len(buffer) == p.bufferSize {
+		p.free = append(p.free, buffer)
+	}
+	p.cond.Signal()
+}
+
+type writeRequest struct {
+	bucket *Bucket
+	data   []byte
+}
+
+// Writes the filled buffers of the buckets of a DCP handler, in the order they are submitted
+// Buffers are handed back to the pool once written
+type captureWriter struct {
+	pool    *BufferPool
+	reqChan chan *writeRequest
+	doneCh  chan bool
+}
+
+func newCaptureWriter(pool *BufferPool) *captureWriter {
+	writer := &captureWriter{
+		pool: pool,
+		// Sending never blocks, as there cannot be more buffers in flight than the high watermark
+		reqChan: make(chan *writeRequest, pool.highWatermark),
+		doneCh:  make(chan bool),
+	}
+	go writer.run()
+	return writer
+}
+
+func (w *captureWriter) run() {
+	defer close(w.doneCh)
+	for req := range w.reqChan {
+		err := req.bucket.writeToFile(req.data)
+		if err != nil {
+			req.bucket.setWriteErr(err)
+		}
+		w.pool.Put(req.data[:cap(req.data)])
+		req.bucket.pendingWrites.Done()
+	}
+}
+
+func (w *captureWriter) submit(bucket *Bucket, data []byte) {
+	w.pool.reserve()
+	bucket.pendingWrites.Add(1)
+	w.reqChan <- &writeRequest{bucket: bucket, data: data}
+}
+
+// Waits for everything submitted to be written. Nothing can be submitted afterwards
+func (w *captureWriter) stop() {
+	close(w.reqChan)
+	<-w.doneCh
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package dcp
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"xdcrDiffer/base"
+	"xdcrDiffer/results"
+
+	"github.com/couchbase/gocbcore/v10"
+)
+
+// Retrieves the given stats from every KV node of the bucket, along with when the request was sent and the
+// response received, by the clock of this machine
+func (cm *CheckpointManager) getStatsOnce(key string) (statsMap map[string]map[string]string, sent, received time.Time, err error) {
+	var waitGroup sync.WaitGroup
+	statsMap = make(map[string]map[string]string)
+
+	callback := func(result *gocbcore.StatsResult, cbErr error) {
+		defer waitGroup.Done()
+		received = time.Now()
+		if cbErr != nil {
+			err = cbErr
+			return
+		}
+		for server, singleServerStats := range result.Servers {
+			if singleServerStats.Error != nil {
+				cm.logger.Warnf("%v stats %v for server %v received err: %v", cm.clusterName, key, server, singleServerStats.Error)
+				continue
+			}
+			statsMap[server] = singleServerStats.Stats
+		}
+	}
+
+	waitGroup.Add(1)
+	sent = time.Now()
+	_, enqErr := cm.agent.Stats(gocbcore.StatsOptions{
+		Key:           key,
+		Deadline:      time.Now().Add(cm.bucketOpTimeout),
+		RetryStrategy: &base.RetryStrategy{},
+	}, callback)
+	if enqErr != nil {
+		return nil, sent, sent, enqErr
+	}
+	waitGroup.Wait()
+	return
+}
+
+// Measures the clock of each KV node against the clock of this machine, and reads the HLC and drift stats of its vbuckets
+func (cm *CheckpointManager) probeClocks() ([]*results.NodeClock, error) {
+	generalStats, sent, received, err := cm.getStatsOnce("")
+	if err != nil {
+		return nil, err
+	}
+	// The node clock was read at some point within the round trip, so the midpoint is the best estimate
+	midpoint := sent.Add(received.Sub(sent) / 2)
+
+	detailStats, _, _, err := cm.getStatsOnce(base.VbucketDetailsStatName)
+	if err != nil {
+		cm.logger.Warnf("%v unable to get %v stats. HLC and drift are not checked. err=%v\n", cm.clusterName, base.VbucketDetailsStatName, err)
+	}
+
+	var clocks []*results.NodeClock
+	for server, stats := range generalStats {
+		nodeSecs, err := strconv.ParseInt(stats[base.TimeStatKey], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v stat %q from %v: %v", base.TimeStatKey, stats[base.TimeStatKey], server, err)
+		}
+		// The stat is in whole seconds, so the node clock was within the second that followed
+		nodeTime := time.Unix(nodeSecs, 0).Add(time.Second / 2)
+		clock := &results.NodeClock{
+			Node:        server,
+			Offset:      nodeTime.Sub(midpoint),
+			Uncertainty: received.Sub(sent)/2 + time.Second/2,
+		}
+
+		var maxCas uint64
+		for statKey, value := range detailStats[server] {
+			if !strings.HasPrefix(statKey, "vb_") {
+				continue
+			}
+			var counter *uint64
+			switch {
+			case strings.HasSuffix(statKey, base.VbucketMaxCasStatSuffix):
+				casValue, err := strconv.ParseUint(value, 10, 64)
+				if err == nil && casValue > maxCas {
+					maxCas = casValue
+				}
+				continue
+			case strings.HasSuffix(statKey, base.VbucketDriftAheadStatSuffix):
+				counter = &clock.DriftAheadExceeded
+			case strings.HasSuffix(statKey, base.VbucketDriftBehindStatSuffix):
+				counter = &clock.DriftBehindExceeded
+			default:
+				continue
+			}
+			count, err := strconv.ParseUint(value, 10, 64)
+			if err == nil {
+				*counter += count
+			}
+		}
+		// CAS is in nanoseconds since the epoch
+		if hlcAhead := time.Unix(0, int64(maxCas)).Sub(nodeTime); maxCas > 0 && hlcAhead > 0 {
+			clock.HLCAhead = hlcAhead
+		}
+		clocks = append(clocks, clock)
+	}
+
+	sort.Slice(clocks, func(i, j int) bool {
+		return clocks[i].Node < clocks[j].Node
+	})
+	return clocks, nil
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package dcp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"xdcrDiffer/base"
+	"xdcrDiffer/utils"
+
+	gocbcore "github.com/couchbase/gocbcore/v10"
+	"github.com/couchbase/gomemcached"
+	xdcrBase "github.com/couchbase/goxdcr/base"
+)
+
+// Captures the vbuckets of the client through KV range scans instead of DCP streams, on clusters of 7.6 and later.
+// Range scans see the live documents only, so deletions and expirations are not captured. Each vbucket completes
+// once every collection of it has been scanned
+func (c *DcpClient) scanVbuckets() {
+	// wait for start vbts done signal from checkpoint manager, which also sets up the KV agent the scans go through
+	select {
+	case <-c.startVbtsDoneChan:
+	case <-c.finChan:
+		return
+	}
+
+	collectionIds := c.collectionIds
+	if len(collectionIds) == 0 {
+		collectionIds = []uint32{0}
+	}
+
+	vbListCopy := utils.DeepCopyUint16Array(c.vbList)
+	utils.ShuffleVbList(vbListCopy)
+	vbnoChan := make(chan uint16, len(vbListCopy))
+	for _, vbno := range vbListCopy {
+		vbnoChan <- vbno
+	}
+	close(vbnoChan)
+
+	var waitGroup sync.WaitGroup
+	for i := 0; i < c.dcpDriver.numberOfWorkers; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			// Vbuckets of the same handler may be scanned at once, so each scanner has an iterator of its own
+			xattrIterator := &xdcrBase.XattrIterator{}
+			for vbno := range vbnoChan {
+				if !c.scanVbucket(vbno, collectionIds, xattrIterator) {
+					return
+				}
+			}
+		}()
+	}
+	waitGroup.Wait()
+}
+
+// Returns false if the client is stopping or the scan failed, in which case no more vbuckets are to be scanned
+func (c *DcpClient) scanVbucket(vbno uint16, collectionIds []uint32, xattrIterator *xdcrBase.XattrIterator) bool {
+	handler := c.vbHandlerMap[vbno]
+	if !c.dcpDriver.checkpointManager.GetStartVBTS(vbno).NoNeedToStartDcpStream {
+		for _, colId := range collectionIds {
+			select {
+			case <-c.finChan:
+				return false
+			default:
+			}
+			if err := c.scanCollection(handler, vbno, colId, xattrIterator); err != nil {
+				c.reportError(fmt.Errorf("%v: range scan of vb %v collection %v failed: %v", c.Name, vbno, colId, err))
+				return false
+			}
+		}
+	}
+	c.dcpDriver.handleVbucketCompletion(vbno, nil, "range scan completed")
+	handler.notifyVbucketCompleted(vbno)
+	return true
+}
+
+type rangeScanCreated struct {
+	result gocbcore.RangeScanCreateResult
+	err    error
+}
+
+type rangeScanContinued struct {
+	result *gocbcore.RangeScanContinueResult
+	err    error
+}
+
+func (c *DcpClient) scanCollection(handler *DcpHandler, vbno uint16, colId uint32, xattrIterator *xdcrBase.XattrIterator) error {
+	agent := c.dcpDriver.checkpointManager.agent
+	timeout := c.dcpDriver.checkpointManager.bucketOpTimeout
+
+	opts := gocbcore.RangeScanCreateOptions{
+		CollectionID: colId,
+		Deadline:     time.Now().Add(timeout),
+	}
+	if samples := c.dcpDriver.rangeScanSamples; samples > 0 {
+		// The same seed on both clusters, so that they sample the same documents as far as they hold the same ones
+		opts.Sampling = &gocbcore.RangeScanCreateRandomSamplingConfig{Samples: samples, Seed: base.RangeScanSampleSeed}
+	} else {
+		opts.Range = &gocbcore.RangeScanCreateRangeScanConfig{Start: []byte(base.RangeScanMinKey), End: []byte(base.RangeScanMaxKey)}
+	}
+
+	createdCh := make(chan rangeScanCreated, 1)
+	_, err := agent.RangeScanCreate(vbno, opts, func(result gocbcore.RangeScanCreateResult, err error) {
+		createdCh <- rangeScanCreated{result: result, err: err}
+	})
+	if err != nil {
+		return rangeScanError(err)
+	}
+	created := <-createdCh
+	if errors.Is(created.err, gocbcore.ErrDocumentNotFound) {
+		// Nothing in the range
+		return nil
+	} else if created.err != nil {
+		return rangeScanError(created.err)
+	}
+
+	for {
+		continuedCh := make(chan rangeScanContinued, 1)
+		_, err = created.result.RangeScanContinue(gocbcore.RangeScanContinueOptions{
+			Deadline: time.Now().Add(timeout),
+			MaxCount: base.RangeScanBatchItems,
+		}, func(items []gocbcore.RangeScanItem) {
+			for _, item := range items {
+				handler.writeToDataChan(CreateMutation(vbno, item.Key, uint64(item.SeqNo), 0, uint64(item.Cas), item.Flags, item.Expiry,
+					gomemcached.UPR_MUTATION, item.Value, item.Datatype, colId, xattrIterator,
+					c.dcpDriver.xattrKeysForNoCompare))
+			}
+		}, func(result *gocbcore.RangeScanContinueResult, err error) {
+			continuedCh <- rangeScanContinued{result: result, err: err}
+		})
+		if err != nil {
+			return err
+		}
+		continued := <-continuedCh
+		if continued.err != nil {
+			return continued.err
+		}
+		if continued.result.Complete || !continued.result.More {
+			return nil
+		}
+	}
+}
+
+func rangeScanError(err error) error {
+	if errors.Is(err, gocbcore.ErrFeatureNotAvailable) {
+		return fmt.Errorf("range scans need Couchbase Server 7.6 or later: %w", err)
+	}
+	return err
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package dcp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+	"xdcrDiffer/base"
+	"xdcrDiffer/stats"
+
+	"github.com/couchbase/gocbcore/v10"
+	"github.com/couchbase/gomemcached"
+)
+
+// A stream whose connection drops is opened again from the last seqno that went through the data channel, rather
+// than failing the run. It ends through the data channel, after the mutations it had already queued, so that the
+// seqno it is opened again from is that of the last mutation captured. The mutations streamed after it are streamed
+// again, and those of an OSO snapshot that was cut short are captured twice, as they are when resuming from a
+// checkpoint
+
+// Flags of a marker of a stream to be opened again, which is still open and has to be closed first
+const streamReopenCloseFirst uint32 = 1
+
+// Marks, in the data channel, a stream that is to be opened again. It is not a mutation of the vbucket
+func (m *Mutation) IsStreamReopen() bool {
+	return m.OpCode == gomemcached.UPR_STREAMREQ
+}
+
+// Whether a stream that ended with err is to be opened again, rather than completing its vbucket
+func (dh *DcpHandler) reopenOnEnd(vbno uint16, err error) bool {
+	client := dh.dcpClient
+	if errors.Is(err, gocbcore.ErrDCPStreamClosed) && client.expectedEnd(vbno) {
+		// The end of a stream closed to be opened again
+		return true
+	}
+	if !base.IsConnectionError(err) || client.dcpDriver.getState() == DriverStateStopped {
+		return false
+	}
+	if !base.DcpReconnects(1) {
+		dh.logger.Errorf("%v stream of vb %v dropped: %v. Not reconnecting, as reconnects are disabled\n", client.Name, vbno, err)
+		return false
+	}
+	dh.logger.Warnf("%v stream of vb %v dropped: %v. Opening it again once the mutations it queued are captured\n",
+		client.Name, vbno, err)
+	reopen := CreateMutation(vbno, nil, 0, 0, 0, 0, 0, gomemcached.UPR_STREAMREQ, nil, 0, base.Uint32MaxVal, nil, nil)
+	// The injected fault leaves the stream open, unlike a dropped connection, so it has to be closed first
+	if errors.Is(err, base.ErrInjectedDcpDisconnect) {
+		reopen.Flags = streamReopenCloseFirst
+	}
+	dh.writeToDataChan(reopen)
+	return true
+}
+
+// Called by processData, once the mutations queued before the stream dropped are captured
+func (dh *DcpHandler) reopenStream(mut *Mutation) {
+	vbno := mut.Vbno
+	if dh.dcpClient.dcpDriver.getVbState(vbno) != VBStateNormal {
+		// Everything up to the end seqno was captured before the stream dropped
+		return
+	}
+	checkpointManager := dh.dcpClient.dcpDriver.checkpointManager
+	// Whatever OSO snapshot the stream was in is not carried on by the new one
+	checkpointManager.resetOSO(vbno)
+	go dh.dcpClient.reopenStream(vbno, checkpointManager.seqnoMap[vbno].getSeqno(), mut.Flags&streamReopenCloseFirst != 0, 1)
+}
+
+func (c *DcpClient) expectedEnd(vbno uint16) bool {
+	c.reopenLock.Lock()
+	defer c.reopenLock.Unlock()
+	if c.closedToReopen[vbno] == 0 {
+		return false
+	}
+	c.closedToReopen[vbno]--
+	return true
+}
+
+func (c *DcpClient) reopenStream(vbno uint16, seqno uint64, closeFirst bool, attempt int) {
+	select {
+	case <-time.After(base.DcpReconnectBackoff(attempt)):
+	case <-c.finChan:
+		return
+	}
+
+	if closeFirst {
+		c.reopenLock.Lock()
+		c.closedToReopen[vbno]++
+		c.reopenLock.Unlock()
+		_, err := c.dcpAgent.CloseStream(vbno, gocbcore.CloseStreamOptions{}, func(err error) {
+			c.openStreamAgain(vbno, seqno, attempt)
+		})
+		if err == nil {
+			return
+		}
+		c.expectedEnd(vbno)
+	}
+	c.openStreamAgain(vbno, seqno, attempt)
+}
+
+func (c *DcpClient) openStreamAgain(vbno uint16, seqno uint64, attempt int) {
+	c.logger.Infof("%v opening stream of vb %v again from seqno %v, attempt %v of %v\n", c.Name, vbno, seqno, attempt, base.MaxDcpReconnects)
+	// The vbuuid of when streaming started, which the seqno is of. Should the vbucket have failed over since, the
+	// stream cannot be opened again without rolling back, and the run fails
+	vbuuid := c.dcpDriver.checkpointManager.vbuuidMap[vbno]
+	if seqno == 0 {
+		vbuuid = 0
+	}
+	_, err := c.dcpAgent.OpenStream(vbno, 0, gocbcore.VbUUID(vbuuid), gocbcore.SeqNo(seqno), gocbcore.SeqNo(math.MaxUint64),
+		gocbcore.SeqNo(seqno), gocbcore.SeqNo(seqno), c.vbHandlerMap[vbno], c.getOpenStreamOptions(),
+		func(_ []gocbcore.FailoverEntry, err error) {
+			c.streamOpenedAgain(vbno, seqno, attempt, err)
+		})
+	if err != nil {
+		c.streamOpenedAgain(vbno, seqno, attempt, err)
+	}
+}
+
+func (c *DcpClient) streamOpenedAgain(vbno uint16, seqno uint64, attempt int, err error) {
+	if err == nil {
+		c.logger.Infof("%v stream of vb %v opened again from seqno %v\n", c.Name, vbno, seqno)
+		stats.Default.Counter(fmt.Sprintf(stats.DcpStreamReconnects, c.dcpDriver.Name)).Add(1)
+		return
+	}
+	if base.IsConnectionError(err) || errors.Is(err, gocbcore.ErrTimeout) {
+		if base.DcpReconnects(attempt + 1) {
+			c.logger.Warnf("%v unable to open stream of vb %v again: %v. Retrying\n", c.Name, vbno, err)
+			go c.reopenStream(vbno, seqno, false, attempt+1)
+			return
+		}
+	}
+	c.reportError(fmt.Errorf("%v unable to open stream of vb %v again from seqno %v after %v attempts: %v", c.Name, vbno, seqno, attempt, err))
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"xdcrDiffer/encryption"
+)
+
+const decryptCommand = "decrypt"
+
+// Environment variable holding the passphrase to derive the encryption key from, if no key is given
+const encryptionPassphraseEnvVar = "XDCRDIFFER_ENCRYPTION_PASSPHRASE"
+
+// The key is read from keyFile, or from the output of keyCommand, i.e. a KMS client decrypting a data key,
+// or else derived from the passphrase in encryptionPassphraseEnvVar
+func loadEncryptionKey(keyFile, keyCommand string) (*encryption.Key, error) {
+	switch {
+	case keyFile != "" && keyCommand != "":
+		return nil, fmt.Errorf("Only one of encryptionKeyFile and encryptionKeyCommand can be given")
+	case keyFile != "":
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return encryption.NewKey(key)
+	case keyCommand != "":
+		cmd := exec.Command("sh", "-c", keyCommand)
+		cmd.Stderr = os.Stderr
+		key, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("encryptionKeyCommand failed: %v", err)
+		}
+		return encryption.NewKey(key)
+	case os.Getenv(encryptionPassphraseEnvVar) != "":
+		return encryption.NewPassphraseKey(os.Getenv(encryptionPassphraseEnvVar))
+	default:
+		return nil, fmt.Errorf("No key is given. Give encryptionKeyFile or encryptionKeyCommand, or set %v", encryptionPassphraseEnvVar)
+	}
+}
+
+// Decrypts the output of a run encrypted with encryptOutput, i.e.
+//
+//	xdcrDiffer decrypt -encryptionKeyFile run.key -output plain fileDiff mutationDiff
+//
+// Without -output, files are decrypted in place
+func runDecryptCommand(args []string) error {
+	flags := flag.NewFlagSet(decryptCommand, flag.ExitOnError)
+	keyFile := flags.String("encryptionKeyFile", "", "file holding the key the output was encrypted with")
+	keyCommand := flags.String("encryptionKeyCommand", "", "command printing the key the output was encrypted with")
+	output := flags.String("output", "", "directory to write the decrypted files to, keeping the encrypted ones. Files are decrypted in place if not given")
+	flags.Parse(args)
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		return fmt.Errorf("No files or directories given to %v", decryptCommand)
+	}
+	key, err := loadEncryptionKey(*keyFile, *keyCommand)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		count, err := key.DecryptTree(path, *output)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Decrypted %v files of %v\n", count, path)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+	"xdcrDiffer/base"
+	"xdcrDiffer/stats"
+)
+
+// When the process started, for the diagnostics to tell how long the run went on for
+var processStartTime = time.Now()
+
+// Ends a failed run, first gathering diagnostics if asked to
+func failRun(format string, args ...interface{}) {
+	fmt.Printf(format, args...)
+	base.Progress.Error("", errors.New(strings.TrimSpace(fmt.Sprintf(format, args...))))
+	if options.diagnosticsOnFailure {
+		gatherDiagnostics(strings.TrimSpace(fmt.Sprintf(format, args...)))
+	}
+	os.Exit(1)
+}
+
+// Gathers diagnostics of a run that panicked, before letting the panic carry on
+func gatherDiagnosticsOnPanic() {
+	if r := recover(); r != nil {
+		gatherDiagnostics(fmt.Sprintf("panic: %v", r))
+		panic(r)
+	}
+}
+
+// Zips what support needs to look into a failed run, i.e. the end of the log, the options with secrets redacted,
+// stats, a dump of the goroutines and the environment, into the run directory
+func gatherDiagnostics(reason string) {
+	fileName := fmt.Sprintf("%v%v.zip", base.DiagnosticsFilePrefix, time.Now().Format("20060102-150405"))
+	if err := writeDiagnostics(fileName, reason); err != nil {
+		fmt.Printf("Unable to gather diagnostics into %v: %v\n", fileName, err)
+		return
+	}
+	fmt.Printf("Gathered diagnostics of the failure into %v\n", fileName)
+}
+
+func writeDiagnostics(fileName, reason string) error {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	archive := zip.NewWriter(file)
+
+	entries := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"environment.txt", func(w io.Writer) error { return writeDiagnosticsEnvironment(w, reason) }},
+		{"options.txt", writeDiagnosticsOptions},
+		{"stats.txt", func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "%v\n", stats.Default.Snapshot())
+			return err
+		}},
+		{"goroutines.txt", func(w io.Writer) error { return pprof.Lookup("goroutine").WriteTo(w, 2) }},
+		{filepath.Base(options.diagnosticsLogFile), writeDiagnosticsLogTail},
+	}
+	for _, entry := range entries {
+		writer, err := archive.CreateHeader(&zip.FileHeader{Name: entry.name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		// What could not be gathered is noted in its place, rather than losing the rest of the bundle over it
+		if err = entry.write(writer); err != nil {
+			fmt.Fprintf(writer, "\nUnable to gather %v: %v\n", entry.name, err)
+		}
+	}
+	if err = archive.Close(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func writeDiagnosticsEnvironment(w io.Writer, reason string) error {
+	hostname, _ := os.Hostname()
+	workingDir, _ := os.Getwd()
+	_, err := fmt.Fprintf(w, "Failure: %v\nStarted: %v\nFailed: %v\nBuild: %v\nCPUs: %v\nHost: %v\nPid: %v\nWorking directory: %v\nArguments: %q\n",
+		reason, processStartTime.Format(time.RFC3339), time.Now().Format(time.RFC3339), base.GetBuildInfo(),
+		runtime.NumCPU(), hostname, os.Getpid(), workingDir, base.RedactArgs(os.Args[1:]))
+	return err
+}
+
+func writeDiagnosticsOptions(w io.Writer) error {
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, "%v=%v\n", f.Name, base.RedactOption(f.Name, f.Value.String()))
+		}
+	})
+	return err
+}
+
+// The end of the log, which is where the failure is, with the credentials of URLs redacted
+func writeDiagnosticsLogTail(w io.Writer) error {
+	logFile, err := os.Open(options.diagnosticsLogFile)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	info, err := logFile.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > base.DiagnosticsLogTailBytes {
+		if _, err = logFile.Seek(info.Size()-base.DiagnosticsLogTailBytes, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	tail, err := ioutil.ReadAll(logFile)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, base.RedactUrls(string(tail)))
+	return err
+}
@@ -16,6 +16,9 @@ import (
 type GocbcoreAgent struct {
 	base.GocbcoreAgentCommon
 	agent *gocbcore.Agent
+	// The agent is shared with the other phases of the run through the pool, if given
+	clusterName string
+	agentPool   *base.AgentPool
 }
 
 func (a *GocbcoreAgent) setupAgent(auth interface{}, batchSize int, capability metadata.Capability, reference *metadata.RemoteClusterReference) error {
@@ -45,12 +48,17 @@ func (a *GocbcoreAgent) setupAgentConfig(authIn interface{}, capability metadata
 
 	if reference.HttpAuthMech() == xdcrBase.HttpAuthMechHttps {
 		useTLS = true
-		ok := certPool.AppendCertsFromPEM(reference.Certificates())
-		if !ok {
-			return nil, fmt.Errorf("Invalid rootCA from gocbcoreagent")
+		var err error
+		certPool, err = base.TLSVerification.CertPool(reference.Certificates())
+		if err != nil {
+			return nil, fmt.Errorf("Invalid rootCA from gocbcoreagent: %v", err)
 		}
 	}
 	x509Provider := func() *x509.CertPool {
+		if base.TLSVerification.SDKSkipsVerification() {
+			// Verified by base.TLSVerification.Probe instead
+			return nil
+		}
 		return certPool
 	}
 
@@ -81,64 +89,55 @@ func (a *GocbcoreAgent) setupAgentConfig(authIn interface{}, capability metadata
 		},
 		KVConfig: gocbcore.KVConfig{
 			ConnectTimeout: a.SetupTimeout,
-			MaxQueueSize:   batchSize * 50, // Give SDK some breathing room
+			MaxQueueSize:   batchSize * base.AgentQueueSizePerBatchKey,
 		},
 		CompressionConfig: gocbcore.CompressionConfig{Enabled: true},
 		HTTPConfig:        gocbcore.HTTPConfig{ConnectTimeout: a.SetupTimeout},
-		IoConfig:          gocbcore.IoConfig{UseCollections: capability.HasCollectionSupport()},
+		IoConfig:          gocbcore.IoConfig{UseCollections: capability.HasCollectionSupport(), NetworkType: a.NetworkType},
 	}, nil
 }
 
 func (a *GocbcoreAgent) setupGocbcoreAgent(config *gocbcore.AgentConfig) (err error) {
-	a.agent, err = gocbcore.CreateAgent(config)
-	if err != nil {
-		return err
-	}
-
-	options := gocbcore.WaitUntilReadyOptions{
-		DesiredState:  gocbcore.ClusterStateOnline,
-		ServiceTypes:  []gocbcore.ServiceType{gocbcore.MemdService},
-		RetryStrategy: &base.RetryStrategy{},
-	}
-
-	signal := make(chan error, 1)
-	_, err = a.agent.WaitUntilReady(time.Now().Add(a.SetupTimeout),
-		options, func(res *gocbcore.WaitUntilReadyResult, er error) {
-			signal <- er
-		})
-
-	if err == nil {
-		err = <-signal
-	}
-
-	if err != nil {
-		errClosing := a.agent.Close()
-		err = fmt.Errorf("Closing GocbcoreAgent.agent because of err=%v, error while closing=%v", err, errClosing)
-	}
+	a.agent, err = a.agentPool.Get(a.clusterName, config, a.SetupTimeout)
 	return
 }
 
-func (a *GocbcoreAgent) Get(key string, callbackFunc func(result *gocbcore.GetResult, err error), colId uint32) error {
+// The KV operations fail with a timeout of their own once past the deadline, without holding up the others
+func (a *GocbcoreAgent) Get(key string, callbackFunc func(result *gocbcore.GetResult, err error), colId uint32, deadline time.Time) error {
+	if err := injectKvFault(); err != nil {
+		go callbackFunc(nil, err)
+		return nil
+	}
 	opts := gocbcore.GetOptions{
 		Key:           []byte(key),
 		RetryStrategy: nil,
 		CollectionID:  colId,
+		Deadline:      deadline,
 	}
 	_, err := a.agent.Get(opts, callbackFunc)
 	return err
 }
 
-func (a *GocbcoreAgent) GetMeta(key string, callbackFunc func(result *gocbcore.GetMetaResult, err error), colId uint32) error {
+func (a *GocbcoreAgent) GetMeta(key string, callbackFunc func(result *gocbcore.GetMetaResult, err error), colId uint32, deadline time.Time) error {
+	if err := injectKvFault(); err != nil {
+		go callbackFunc(nil, err)
+		return nil
+	}
 	opts := gocbcore.GetMetaOptions{
 		Key:           []byte(key),
 		RetryStrategy: nil,
 		CollectionID:  colId,
+		Deadline:      deadline,
 	}
 	_, err := a.agent.GetMeta(opts, callbackFunc)
 	return err
 }
 
-func (a *GocbcoreAgent) GetHlv(key string, callbackFunc func(result *gocbcore.LookupInResult, err error), colId uint32) error {
+func (a *GocbcoreAgent) GetHlv(key string, callbackFunc func(result *gocbcore.LookupInResult, err error), colId uint32, deadline time.Time) error {
+	if err := injectKvFault(); err != nil {
+		go callbackFunc(nil, err)
+		return nil
+	}
 	opts := gocbcore.LookupInOptions{
 		Key:   []byte(key),
 		Flags: memd.SubdocDocFlagAccessDeleted,
@@ -164,20 +163,57 @@ func (a *GocbcoreAgent) GetHlv(key string, callbackFunc func(result *gocbcore.Lo
 		},
 		RetryStrategy: nil,
 		CollectionID:  colId,
+		Deadline:      deadline,
 	}
 	_, err := a.agent.LookupIn(opts, callbackFunc)
 	return err
 }
 
-func NewGocbcoreAgent(id string, servers []string, bucketName string, auth interface{}, batchSize int, capability metadata.Capability, reference *metadata.RemoteClusterReference) (*GocbcoreAgent, error) {
+// Gets only the given paths of the document body
+func (a *GocbcoreAgent) GetPaths(key string, paths []string, callbackFunc func(result *gocbcore.LookupInResult, err error), colId uint32, deadline time.Time) error {
+	if err := injectKvFault(); err != nil {
+		go callbackFunc(nil, err)
+		return nil
+	}
+	opts := gocbcore.LookupInOptions{
+		Key:           []byte(key),
+		RetryStrategy: nil,
+		CollectionID:  colId,
+		Deadline:      deadline,
+	}
+	for _, path := range paths {
+		opts.Ops = append(opts.Ops, gocbcore.SubDocOp{
+			Op:   memd.SubDocOpType(memd.CmdSubDocGet),
+			Path: path,
+		})
+	}
+	_, err := a.agent.LookupIn(opts, callbackFunc)
+	return err
+}
+
+// Injected KV faults are delivered through the callback, as the SDK would once the operation has been dispatched
+func injectKvFault() error {
+	if base.Faults.Inject(base.FaultKvTimeout) {
+		return gocbcore.ErrTimeout
+	}
+	if base.Faults.Inject(base.FaultNotMyVbucket) {
+		return gocbcore.ErrNotMyVBucket
+	}
+	return nil
+}
+
+func NewGocbcoreAgent(id string, servers []string, bucketName string, auth interface{}, batchSize int, capability metadata.Capability, reference *metadata.RemoteClusterReference, clusterName string, agentPool *base.AgentPool) (*GocbcoreAgent, error) {
 	gocbcoreAgent := &GocbcoreAgent{
 		GocbcoreAgentCommon: base.GocbcoreAgentCommon{
 			Name:         id,
 			Servers:      servers,
 			BucketName:   bucketName,
 			SetupTimeout: time.Duration(base.SetupTimeoutSeconds) * time.Second,
+			NetworkType:  base.PortsOf(clusterName == base.SourceClusterLabel).NetworkType(),
 		},
-		agent: nil,
+		agent:       nil,
+		clusterName: clusterName,
+		agentPool:   agentPool,
 	}
 
 	err := gocbcoreAgent.setupAgent(auth, batchSize, capability, reference)
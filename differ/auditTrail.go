@@ -0,0 +1,126 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"time"
+	"xdcrDiffer/base"
+)
+
+// What was seen of one side of a reported difference when it was fetched
+type FetchObservation struct {
+	// When the last response for the document arrived
+	FetchedAt time.Time
+	// CAS of the document as fetched. 0 if it was not found
+	Cas     uint64 `json:",omitempty"`
+	Found   bool
+	Deleted bool `json:",omitempty"`
+}
+
+func observe(result *GetResult) *FetchObservation {
+	if result == nil {
+		return nil
+	}
+	result.lock.RLock()
+	defer result.lock.RUnlock()
+	return &FetchObservation{
+		FetchedAt: result.fetchedAt,
+		Cas:       result.fetchCas,
+		Found:     !isKeyNotFoundError(result.bodyErr) && !isKeyNotFoundError(result.metaErr),
+		Deleted:   isDeleted(result.GetMetaResult),
+	}
+}
+
+func (o *FetchObservation) sameAs(other *FetchObservation) bool {
+	if o == nil || other == nil {
+		return o == other
+	}
+	return o.Found == other.Found && o.Deleted == other.Deleted && o.Cas == other.Cas
+}
+
+// The audit trail of a single reported difference. Source is not set for documents that were only fetched
+// from the target
+type KeyAudit struct {
+	SrcColId uint32
+	TgtColId uint32
+	Source   *FetchObservation `json:",omitempty"`
+	Target   *FetchObservation `json:",omitempty"`
+	// Set when flagged keys are fetched once more to corroborate the difference
+	Refetch *KeyAuditRefetch `json:",omitempty"`
+}
+
+type KeyAuditRefetch struct {
+	Source *FetchObservation `json:",omitempty"`
+	Target *FetchObservation `json:",omitempty"`
+	// Neither document changed between the two fetches, so the difference is not due to a mutation in flight
+	Corroborated bool
+}
+
+func newKeyAudit(srcColId, tgtColId uint32, sourceResult, targetResult *GetResult) *KeyAudit {
+	return &KeyAudit{
+		SrcColId: srcColId,
+		TgtColId: tgtColId,
+		Source:   observe(sourceResult),
+		Target:   observe(targetResult),
+	}
+}
+
+// A document that could not be fetched again is not corroborated
+func (a *KeyAudit) refetched(sourceResult, targetResult *GetResult) {
+	refetch := &KeyAuditRefetch{}
+	if a.Source != nil {
+		refetch.Source = observe(sourceResult)
+	}
+	if a.Target != nil {
+		refetch.Target = observe(targetResult)
+	}
+	refetch.Corroborated = a.Source.sameAs(refetch.Source) && a.Target.sameAs(refetch.Target)
+	a.Refetch = refetch
+}
+
+// Category -> collection ID -> key, laid out the same way as the mutation differ output
+// A nil AuditTrail records nothing, i.e. when the audit trail is not enabled
+type AuditTrail map[string]map[uint32]map[string]*KeyAudit
+
+func (a AuditTrail) add(category string, colId uint32, key string, audit *KeyAudit) {
+	if a == nil {
+		return
+	}
+	if _, exists := a[category]; !exists {
+		a[category] = make(map[uint32]map[string]*KeyAudit)
+	}
+	if _, exists := a[category][colId]; !exists {
+		a[category][colId] = make(map[string]*KeyAudit)
+	}
+	a[category][colId][key] = audit
+}
+
+func (a AuditTrail) merge(other AuditTrail) {
+	for category, auditsPerCol := range other {
+		for colId, audits := range auditsPerCol {
+			for key, audit := range audits {
+				a.add(category, colId, key, audit)
+			}
+		}
+	}
+}
+
+func (a AuditTrail) encoded() AuditTrail {
+	encoded := make(AuditTrail)
+	for category, auditsPerCol := range a {
+		for colId, audits := range auditsPerCol {
+			for key, audit := range audits {
+				encodedKey, _ := base.EncodeKey(key)
+				encoded.add(category, colId, encodedKey, audit)
+			}
+		}
+	}
+	return encoded
+}
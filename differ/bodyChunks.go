@@ -0,0 +1,125 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math/bits"
+)
+
+// Documents above a size threshold that differ are reported by the chunks of their bodies that the other side does
+// not have, rather than by their whole bodies, so that a 20MB document that differs by a field is reported by a few
+// chunks instead of 40MB of base64. Bodies are split where a rolling hash of the bytes before hits a pattern, delta
+// sync style, so that bytes inserted or removed in one place change the chunks around them only, rather than every
+// chunk after them
+
+// In place of the body of a document, the chunks of it that the body it was compared to does not have
+type BodyChunks struct {
+	// Of the whole body
+	Size int
+	// Chunks the body was split into, of which those that are not listed are also in the other body
+	NumChunks int
+	Chunks    []*BodyChunk
+}
+
+type BodyChunk struct {
+	Offset int
+	Length int
+	// Truncated SHA-256 of the chunk
+	Hash string
+	// The chunk itself, if asked for
+	Bytes []byte `json:",omitempty"`
+}
+
+// Bytes of a truncated SHA-256 that identify a chunk
+const bodyChunkHashLen = 8
+
+// Random values of each byte, which the rolling hash adds up. Generated from a fixed seed, so that the same body is
+// split the same way by every run
+var chunkGear = newChunkGear(0x9e3779b97f4a7c15)
+
+func newChunkGear(seed uint64) (gear [256]uint64) {
+	// splitmix64
+	for i := range gear {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+	return
+}
+
+// Ends of the chunks data is split into, averageSize bytes long on average and between a quarter and four times
+// that. averageSize is a power of 2
+func chunkEnds(data []byte, averageSize int) []int {
+	maskBits := uint(bits.Len(uint(averageSize)) - 1)
+	minSize, maxSize := averageSize/4, averageSize*4
+	var ends []int
+	var hash uint64
+	var start int
+	for i, b := range data {
+		// Shifted left with every byte, so that the top bits are of the last 64 bytes only
+		hash = hash<<1 + chunkGear[b]
+		length := i + 1 - start
+		if length < minSize {
+			continue
+		}
+		if hash>>(64-maskBits) == 0 || length >= maxSize {
+			ends = append(ends, i+1)
+			start = i + 1
+		}
+	}
+	if start < len(data) {
+		ends = append(ends, len(data))
+	}
+	return ends
+}
+
+func splitBody(data []byte, averageSize int) []*BodyChunk {
+	var chunks []*BodyChunk
+	var start int
+	for _, end := range chunkEnds(data, averageSize) {
+		sum := sha256.Sum256(data[start:end])
+		chunks = append(chunks, &BodyChunk{Offset: start, Length: end - start, Hash: hex.EncodeToString(sum[:bodyChunkHashLen])})
+		start = end
+	}
+	return chunks
+}
+
+// The chunks of data that are not among others
+func bodyChunksNotIn(data []byte, chunks, others []*BodyChunk, includeBytes bool) *BodyChunks {
+	otherHashes := make(map[string]bool, len(others))
+	for _, chunk := range others {
+		otherHashes[chunk.Hash] = true
+	}
+	bodyChunks := &BodyChunks{Size: len(data), NumChunks: len(chunks), Chunks: []*BodyChunk{}}
+	for _, chunk := range chunks {
+		if otherHashes[chunk.Hash] {
+			continue
+		}
+		if includeBytes {
+			chunk.Bytes = data[chunk.Offset : chunk.Offset+chunk.Length]
+		}
+		bodyChunks.Chunks = append(bodyChunks.Chunks, chunk)
+	}
+	return bodyChunks
+}
+
+// Sets, on each of two results whose bodies differ, the chunks of its body that the other does not have, which are
+// written in place of the body. With migration mappings, a source document compared to several targets keeps the
+// chunks of the last comparison
+func setBodyChunks(sourceResult, targetResult *GetResult, averageSize int, includeBytes bool) {
+	sourceChunks := splitBody(sourceResult.value, averageSize)
+	targetChunks := splitBody(targetResult.value, averageSize)
+	sourceResult.bodyChunks = bodyChunksNotIn(sourceResult.value, sourceChunks, targetChunks, includeBytes)
+	targetResult.bodyChunks = bodyChunksNotIn(targetResult.value, targetChunks, sourceChunks, includeBytes)
+}
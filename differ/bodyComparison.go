@@ -0,0 +1,33 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import "sync"
+
+type bodyComparisonSetting struct {
+	bodiesOnly bool
+	lock       sync.RWMutex
+}
+
+// Whether the file differ compares documents by body only. Copies made within a cluster, i.e. by eventing, are
+// written anew, with metadata and xattrs of their own, so nothing but the body is expected to match
+var bodyComparison *bodyComparisonSetting = &bodyComparisonSetting{}
+
+func SetCompareBodiesOnly(bodiesOnly bool) {
+	bodyComparison.lock.Lock()
+	defer bodyComparison.lock.Unlock()
+	bodyComparison.bodiesOnly = bodiesOnly
+}
+
+func (s *bodyComparisonSetting) get() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.bodiesOnly
+}
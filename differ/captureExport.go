@@ -0,0 +1,75 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"xdcrDiffer/base"
+
+	hlv "github.com/couchbase/goxdcr/hlv"
+)
+
+// Columns of the CSV a capture is exported to, one row per record
+var CaptureExportColumns = []string{"key", "colId", "seqno", "cas", "revId", "flags", "expiry", "opcode", "datatype",
+	"valueHash", "xattrHash"}
+
+// Writes every record of the capture files of a vbucket as a row, in the order they were written, and returns the
+// number of rows. Keys that are not valid UTF-8 are encoded as by base.EncodeKey. The value hash is the SHA-512 of
+// the body and is empty for documents captured without one, as is the xattr hash of those captured without xattrs
+func ExportCaptureVbucket(fileNames []string, writer *csv.Writer) (int, error) {
+	// The bucket UUID only matters to the HLV comparison, which is not done here
+	bucketUUID, err := hlv.UUIDtoDocumentSource("")
+	if err != nil {
+		return 0, err
+	}
+	var rows int
+	for _, fileName := range fileNames {
+		var writeErr error
+		err = readCaptureFile(fileName, bucketUUID, nil, func(entry *oneEntry) {
+			if writeErr != nil {
+				return
+			}
+			docMeta := entry.CrMeta.GetDocumentMetadata()
+			key, _ := base.EncodeKey(entry.Key)
+			var valueHash, xattrHash string
+			if !entry.NoValue {
+				valueHash = hex.EncodeToString(entry.BodyHash[:])
+			}
+			if entry.HasXattrHash {
+				xattrHash = hex.EncodeToString(entry.XattrHash[:])
+			}
+			writeErr = writer.Write([]string{
+				key,
+				strconv.FormatUint(uint64(entry.ColId), 10),
+				strconv.FormatUint(entry.Seqno, 10),
+				strconv.FormatUint(docMeta.Cas, 10),
+				strconv.FormatUint(docMeta.RevSeq, 10),
+				strconv.FormatUint(uint64(docMeta.Flags), 10),
+				strconv.FormatUint(uint64(docMeta.Expiry), 10),
+				docMeta.Opcode.String(),
+				strconv.FormatUint(uint64(docMeta.DataType), 10),
+				valueHash,
+				xattrHash,
+			})
+			rows++
+		})
+		if err == nil {
+			err = writeErr
+		}
+		if err != nil {
+			return rows, fmt.Errorf("%v: %v", fileName, err)
+		}
+	}
+	writer.Flush()
+	return rows, writer.Error()
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"xdcrDiffer/base"
+	fdp "xdcrDiffer/fileDescriptorPool"
+
+	hlv "github.com/couchbase/goxdcr/hlv"
+)
+
+// Allows a FileOp to be used where an io.Reader is expected
+type readOpReader fdp.FileOp
+
+func (r readOpReader) Read(p []byte) (int, error) {
+	return r(p)
+}
+
+// Replays bytes that have already been consumed before reading further from the underlying readOp
+type prefixedReadOp struct {
+	prefix []byte
+	readOp fdp.FileOp
+}
+
+func (p *prefixedReadOp) read(buf []byte) (int, error) {
+	if len(p.prefix) == 0 {
+		return p.readOp(buf)
+	}
+	n := copy(buf, p.prefix)
+	p.prefix = p.prefix[n:]
+	if n == len(buf) {
+		return n, nil
+	}
+	bytesRead, err := io.ReadFull(readOpReader(p.readOp), buf[n:])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// What has been replayed is still valid data
+		err = nil
+	}
+	return n + bytesRead, err
+}
+
+// Keeps a copy of every byte read so that a record can be checksummed once it is parsed
+type recordingReadOp struct {
+	data   []byte
+	readOp fdp.FileOp
+}
+
+func (r *recordingReadOp) read(buf []byte) (int, error) {
+	bytesRead, err := r.readOp(buf)
+	r.data = append(r.data, buf[:bytesRead]...)
+	return bytesRead, err
+}
+
+// Reads the fields of a record in full. A file may only end before the first field of a record, which is returned
+// as io.EOF, as one that ends anywhere after was cut off
+type recordReadOp struct {
+	readOp  fdp.FileOp
+	started bool
+}
+
+func (r *recordReadOp) read(buf []byte) (int, error) {
+	bytesRead, err := io.ReadFull(readOpReader(r.readOp), buf)
+	if err == io.EOF && !r.started {
+		return bytesRead, err
+	}
+	r.started = true
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("%w: record cut off after %v of %v bytes", base.ErrCaptureFileCorrupted, bytesRead, len(buf))
+	}
+	return bytesRead, err
+}
+
+// Reads the capture file header, if there is one, and returns a readOp that is positioned at the first record
+// Legacy capture files do not have a header, and the bytes consumed while looking for one are replayed
+func readCaptureFileHeader(readOp fdp.FileOp) (*base.CaptureFileHeader, fdp.FileOp, error) {
+	headerBytes := make([]byte, base.CaptureFileHeaderLen)
+	bytesRead, err := io.ReadFull(readOpReader(readOp), headerBytes)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, nil, err
+	}
+
+	header, err := base.DecodeCaptureFileHeader(headerBytes[:bytesRead])
+	if err == base.ErrNoCaptureFileHeader {
+		replay := &prefixedReadOp{
+			prefix: headerBytes[:bytesRead],
+			readOp: readOp,
+		}
+		return base.NewLegacyCaptureFileHeader(), replay.read, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return header, readOp, nil
+}
+
+// Reads a record followed by its checksum, and validates one against the other
+func getOneEntryWithChecksum(readOp fdp.FileOp, bucketUUID hlv.DocumentSourceId, header *base.CaptureFileHeader) (*oneEntry, error) {
+	recorder := &recordingReadOp{readOp: readOp}
+	entry, err := getOneEntry(recorder.read, bucketUUID, header)
+	if err != nil {
+		if len(recorder.data) > 0 && !errors.Is(err, base.ErrCaptureFileCorrupted) {
+			// A partially parsed record that makes no sense is as bad as one failing its checksum
+			return nil, fmt.Errorf("%w: %v", base.ErrCaptureFileCorrupted, err)
+		}
+		return nil, err
+	}
+
+	checksumBytes := make([]byte, base.CaptureRecordChecksumLen)
+	bytesRead, err := io.ReadFull(readOpReader(readOp), checksumBytes)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("%w: record checksum of key %v seqno %v cut off after %v bytes", base.ErrCaptureFileCorrupted,
+			entry.Key, entry.Seqno, bytesRead)
+	} else if err != nil {
+		return nil, fmt.Errorf("Unable to read record checksum, bytes read: %v, err: %v", bytesRead, err)
+	}
+	if binary.BigEndian.Uint32(checksumBytes) != base.CaptureChecksum(recorder.data) {
+		return nil, fmt.Errorf("%w: record checksum mismatch for key %v seqno %v", base.ErrCaptureFileCorrupted, entry.Key, entry.Seqno)
+	}
+	return entry, nil
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"xdcrDiffer/base"
+
+	hlv "github.com/couchbase/goxdcr/hlv"
+)
+
+// What the capture files of one vbucket hold, as read back from disk
+type CaptureVbSummary struct {
+	Vbno    uint16
+	Files   int
+	Records int
+	// Collection ID -> number of distinct keys
+	Keys      map[uint32]int
+	LowSeqno  uint64
+	HighSeqno uint64
+	// Whether the records of the files are checksummed, which legacy capture files are not
+	Checksummed bool
+	// File -> why its records could not all be read
+	Corrupted map[string]string `json:",omitempty"`
+}
+
+// Groups the capture files of a directory by vbucket
+func CaptureFilesByVbucket(fileDir string) (map[uint16][]string, error) {
+	fileInfos, err := ioutil.ReadDir(fileDir)
+	if err != nil {
+		return nil, err
+	}
+	var fileNames []string
+	for _, fileInfo := range fileInfos {
+		if fileInfo.Mode().IsRegular() {
+			fileNames = append(fileNames, fileInfo.Name())
+		}
+	}
+	for _, fileName := range base.CaptureMemory.FileNames(fileDir) {
+		fileNames = append(fileNames, filepath.Base(fileName))
+	}
+	files := make(map[uint16][]string)
+	for _, fileName := range fileNames {
+		// i.e. diffTool_<vbno>_<bin>
+		parts := strings.Split(fileName, base.FileNameDelimiter)
+		if len(parts) != 3 || parts[0] != base.FileNamePrefix {
+			continue
+		}
+		vbno, err := strconv.ParseUint(parts[1], 10, 16)
+		if err != nil {
+			continue
+		}
+		if _, err = strconv.Atoi(parts[2]); err != nil {
+			continue
+		}
+		files[uint16(vbno)] = append(files[uint16(vbno)], filepath.Join(fileDir, fileName))
+	}
+	for _, vbFiles := range files {
+		sort.Strings(vbFiles)
+	}
+	return files, nil
+}
+
+// Reads every record of the capture files of a vbucket the way the file differ does, validating their checksums
+// A file that cannot be read through is reported in Corrupted rather than failing the vbucket
+func VerifyCaptureVbucket(vbno uint16, fileNames []string) (*CaptureVbSummary, error) {
+	summary := &CaptureVbSummary{
+		Vbno:        vbno,
+		Keys:        make(map[uint32]int),
+		Checksummed: true,
+		Corrupted:   make(map[string]string),
+	}
+	// The bucket UUID only matters to the HLV comparison, which is not done here
+	bucketUUID, err := hlv.UUIDtoDocumentSource("")
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[uint32]map[string]bool)
+	for _, fileName := range fileNames {
+		if err := summary.readFile(fileName, bucketUUID, keys); err != nil {
+			summary.Corrupted[fileName] = err.Error()
+		}
+		summary.Files++
+	}
+	for colId, colKeys := range keys {
+		summary.Keys[colId] = len(colKeys)
+	}
+	return summary, nil
+}
+
+func (summary *CaptureVbSummary) readFile(fileName string, bucketUUID hlv.DocumentSourceId, keys map[uint32]map[string]bool) error {
+	return readCaptureFile(fileName, bucketUUID, func(header *base.CaptureFileHeader) {
+		if !header.HasRecordChecksum() {
+			summary.Checksummed = false
+		}
+	}, func(entry *oneEntry) {
+		summary.Records++
+		if keys[entry.ColId] == nil {
+			keys[entry.ColId] = make(map[string]bool)
+		}
+		keys[entry.ColId][entry.Key] = true
+		if summary.LowSeqno == 0 || entry.Seqno < summary.LowSeqno {
+			summary.LowSeqno = entry.Seqno
+		}
+		if entry.Seqno > summary.HighSeqno {
+			summary.HighSeqno = entry.Seqno
+		}
+	})
+}
+
+// Passes the header and then every record of a capture file to the given functions, in the order they were written
+func readCaptureFile(fileName string, bucketUUID hlv.DocumentSourceId, headerFunc func(*base.CaptureFileHeader), entryFunc func(*oneEntry)) error {
+	fileReadOp, inMemory := base.CaptureMemory.Open(fileName)
+	if !inMemory {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		fileReadOp = file.Read
+	}
+
+	header, readOp, err := readCaptureFileHeader(fileReadOp)
+	if err != nil {
+		return err
+	}
+	if headerFunc != nil {
+		headerFunc(header)
+	}
+	var records int
+	for {
+		var entry *oneEntry
+		if header.HasRecordChecksum() {
+			entry, err = getOneEntryWithChecksum(readOp, bucketUUID, header)
+		} else {
+			entry, err = getOneEntry(readOp, bucketUUID, header)
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("after %v records: %v", records, err)
+		}
+		records++
+		entryFunc(entry)
+	}
+}
+
+// Keys of the capture files of a directory that match, by collection ID. Deleted documents are included, as a
+// document deleted on one side only is as much of a difference as any other
+func CaptureKeysMatching(fileDir string, match func(key string) bool) (DiffKeysMap, error) {
+	files, err := CaptureFilesByVbucket(fileDir)
+	if err != nil {
+		return nil, err
+	}
+	bucketUUID, err := hlv.UUIDtoDocumentSource("")
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[uint32]map[string]bool)
+	for _, vbFiles := range files {
+		for _, fileName := range vbFiles {
+			err = readCaptureFile(fileName, bucketUUID, nil, func(entry *oneEntry) {
+				if !match(entry.Key) {
+					return
+				}
+				if keys[entry.ColId] == nil {
+					keys[entry.ColId] = make(map[string]bool)
+				}
+				keys[entry.ColId][entry.Key] = true
+			})
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", fileName, err)
+			}
+		}
+	}
+	matching := make(DiffKeysMap)
+	for colId, colKeys := range keys {
+		for key := range colKeys {
+			matching[colId] = append(matching[colId], key)
+		}
+		sort.Strings(matching[colId])
+	}
+	return matching, nil
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import "xdcrDiffer/base"
+
+// Whether an operation of the result failed. A key that is not found is an answer, and a locked document is
+// fetched again by retryLocked rather than counted against its cluster
+func (r *GetResult) failed() bool {
+	if r == nil {
+		return false
+	}
+	if !r.responded() {
+		return true
+	}
+	return r.fetchErr() != nil && !r.locked()
+}
+
+// The operations of the batch and how many of them failed, of the source and of the target
+func (b *batch) outcomes() (srcOps, srcErrs, tgtOps, tgtErrs int) {
+	b.resultsLock.RLock()
+	defer b.resultsLock.RUnlock()
+	for _, fetchItem := range b.fetchList {
+		srcOps++
+		if b.sourceResults[fetchItem.SrcColId][fetchItem.Key].failed() {
+			srcErrs++
+		}
+		for _, tgtColId := range fetchItem.TgtColIds {
+			tgtOps++
+			if b.targetResults[tgtColId][fetchItem.Key].failed() {
+				tgtErrs++
+			}
+		}
+	}
+	return srcOps, srcErrs, tgtOps, tgtErrs
+}
+
+func (d *MutationDiffer) recordOutcomes(b *batch) {
+	if d.sourceBreaker == nil && d.targetBreaker == nil {
+		return
+	}
+	srcOps, srcErrs, tgtOps, tgtErrs := b.outcomes()
+	d.sourceBreaker.Record(srcOps, srcErrs)
+	d.targetBreaker.Record(tgtOps, tgtErrs)
+}
+
+// The cluster whose breaker is open and why, if any. Once the read unit budget is spent, no cluster is read from
+func (d *MutationDiffer) circuitOpen() (string, error) {
+	if err := d.readUnitBudget.Spent(); err != nil {
+		return "", err
+	}
+	if err := d.sourceBreaker.Open(); err != nil {
+		return base.SourceClusterLabel, err
+	}
+	if err := d.targetBreaker.Open(); err != nil {
+		return base.TargetClusterLabel, err
+	}
+	return "", nil
+}
+
+// Takes the retry of the keys of the last batch from the budgets of the clusters that failed it, or of both when
+// the batch failed as a whole. Returns the cluster whose budget is exhausted or whose breaker opened, if any
+func (d *MutationDiffer) allowRetry(lastBatch *batch, keys int) (string, error) {
+	if d.sourceBreaker == nil && d.targetBreaker == nil {
+		return "", nil
+	}
+	_, srcErrs, _, tgtErrs := lastBatch.outcomes()
+	blameBoth := srcErrs == 0 && tgtErrs == 0
+	if srcErrs > 0 || blameBoth {
+		if err := d.sourceBreaker.AllowRetry(keys); err != nil {
+			return base.SourceClusterLabel, err
+		}
+	}
+	if tgtErrs > 0 || blameBoth {
+		if err := d.targetBreaker.AllowRetry(keys); err != nil {
+			return base.TargetClusterLabel, err
+		}
+	}
+	return "", nil
+}
+
+// Of keys that were not sent, or not sent again, as cluster was failing
+func breakerKeyErrors(fetchList MutationDiffFetchList, cluster string, err error, attempts int) []*KeyError {
+	keyErrors := make([]*KeyError, 0, len(fetchList))
+	for _, fetchItem := range fetchList {
+		keyErrors = append(keyErrors, newFetchKeyError(fetchItem, cluster, 0, err, attempts))
+	}
+	return keyErrors
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"xdcrDiffer/base"
+
+	"github.com/couchbase/gocbcore/v10"
+)
+
+// Parses a comma separated list of sub-document paths, i.e. "address.city,orders[0].total"
+func ParseComparePaths(pathsStr string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, path := range strings.Split(pathsStr, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if seen[path] {
+			return nil, fmt.Errorf("path %v is given more than once", path)
+		}
+		seen[path] = true
+		paths = append(paths, path)
+	}
+	if len(paths) > base.MaxSubdocPaths {
+		return nil, fmt.Errorf("%v paths are given, while at most %v can be fetched at once", len(paths), base.MaxSubdocPaths)
+	}
+	return paths, nil
+}
+
+// The values of the paths that exist in the document, as a JSON object of path to value
+// Values are compacted, so that only differences in content are differences
+func pathsToValue(paths []string, result *gocbcore.LookupInResult) ([]byte, error) {
+	values := make(map[string]json.RawMessage)
+	for i, path := range paths {
+		if i >= len(result.Ops) {
+			break
+		}
+		op := result.Ops[i]
+		if op.Err != nil {
+			if errors.Is(op.Err, gocbcore.ErrPathNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("path %v: %v", path, op.Err)
+		}
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, op.Value); err != nil {
+			return nil, fmt.Errorf("path %v: %v", path, err)
+		}
+		values[path] = compacted.Bytes()
+	}
+	return json.Marshal(values)
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"fmt"
+	"sync"
+	"xdcrDiffer/base"
+)
+
+// Whether documents are compared regardless of being held compressed, which buckets that compress documents decide
+// on their own, so that the same document can be compressed on one side and not on the other
+type CompressionPolicy string
+
+const (
+	// Taken from the compression modes of the buckets
+	CompressionPolicyAuto CompressionPolicy = "auto"
+	// The snappy bit of the datatype is left out of the comparison
+	CompressionPolicyNormalize CompressionPolicy = "normalize"
+	// The datatypes are compared as they are
+	CompressionPolicyStrict CompressionPolicy = "strict"
+)
+
+func ParseCompressionPolicy(value string) (CompressionPolicy, error) {
+	switch policy := CompressionPolicy(value); policy {
+	case CompressionPolicyAuto, CompressionPolicyNormalize, CompressionPolicyStrict:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("Invalid compression policy %v. Accepted values are %v, %v and %v", value, CompressionPolicyAuto,
+			CompressionPolicyNormalize, CompressionPolicyStrict)
+	}
+}
+
+// The policy of buckets of the given compression modes. Unless neither compresses documents, whether a document is
+// held compressed says nothing of the replication. A bucket whose mode is unknown is taken to compress them
+func CompressionPolicyOfBuckets(sourceMode, targetMode string) CompressionPolicy {
+	if sourceMode == base.CompressionModeOff && targetMode == base.CompressionModeOff {
+		return CompressionPolicyStrict
+	}
+	return CompressionPolicyNormalize
+}
+
+// The datatype of a document as it is compared
+func (p CompressionPolicy) Datatype(datatype uint8) uint8 {
+	if p == CompressionPolicyNormalize {
+		return datatype &^ base.SnappyDataType
+	}
+	return datatype
+}
+
+type compressionPolicySetting struct {
+	policy CompressionPolicy
+	lock   sync.RWMutex
+}
+
+// Shared by the file differ and the mutation differ, like the TTL policy
+var bucketCompressionPolicy *compressionPolicySetting = &compressionPolicySetting{policy: CompressionPolicyStrict}
+
+// Sets the policy both differs compare datatypes by. It is not to be CompressionPolicyAuto, which has to be resolved first
+func SetCompressionPolicy(policy CompressionPolicy) {
+	bucketCompressionPolicy.lock.Lock()
+	defer bucketCompressionPolicy.lock.Unlock()
+	bucketCompressionPolicy.policy = policy
+}
+
+func (s *compressionPolicySetting) get() CompressionPolicy {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.policy
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"sync"
+	"time"
+
+	xdcrLog "github.com/couchbase/goxdcr/log"
+)
+
+// Limits the number of batches that the mutation differ has in flight, and adjusts the limit
+// according to the per-key latency observed.
+// The lowest latency seen so far is taken as the cost of a fetch on an otherwise idle KV, and how much the latency
+// rises above it is used as an estimate of the share of KV capacity that the differ consumes. The baseline is only
+// ever lowered, as a latency that stays higher may well be due to the differ's own load, which would then be taken
+// as the cost of an idle KV and let the limit climb a step at a time up to the maximum.
+// The limit is raised one at a time while within the target, and cut back by a quarter once above it
+type ConcurrencyTuner struct {
+	cond         *sync.Cond
+	limit        int
+	maxLimit     int
+	inFlight     int
+	targetImpact float64
+	interval     time.Duration
+	logger       *xdcrLog.CommonLogger
+
+	// Lowest per key latency of any interval so far, or 0 until the first interval is over
+	baseline    time.Duration
+	windowKeys  int
+	windowTime  time.Duration
+	windowStart time.Time
+}
+
+// targetImpact is in percent, i.e. 10 for 10% of KV capacity
+func NewConcurrencyTuner(initialLimit, maxLimit int, targetImpact float64, interval time.Duration, logger *xdcrLog.CommonLogger) *ConcurrencyTuner {
+	if maxLimit < 1 {
+		maxLimit = 1
+	}
+	if initialLimit < 1 {
+		initialLimit = 1
+	} else if initialLimit > maxLimit {
+		initialLimit = maxLimit
+	}
+	return &ConcurrencyTuner{
+		cond:         sync.NewCond(&sync.Mutex{}),
+		limit:        initialLimit,
+		maxLimit:     maxLimit,
+		targetImpact: targetImpact / 100,
+		interval:     interval,
+		logger:       logger,
+		windowStart:  time.Now(),
+	}
+}
+
+// Blocks until one more batch is allowed to be in flight
+func (t *ConcurrencyTuner) Acquire() {
+	t.cond.L.Lock()
+	defer t.cond.L.Unlock()
+	for t.inFlight >= t.limit {
+		t.cond.Wait()
+	}
+	t.inFlight++
+}
+
+// Called once a batch of numOfKeys keys has completed after the given latency
+func (t *ConcurrencyTuner) Release(latency time.Duration, numOfKeys int) {
+	t.cond.L.Lock()
+	defer t.cond.L.Unlock()
+	t.inFlight--
+	if numOfKeys > 0 {
+		t.windowKeys += numOfKeys
+		t.windowTime += latency
+	}
+	if t.windowKeys > 0 && time.Since(t.windowStart) >= t.interval {
+		t.adjust()
+	}
+	t.cond.Broadcast()
+}
+
+func (t *ConcurrencyTuner) Limit() int {
+	t.cond.L.Lock()
+	defer t.cond.L.Unlock()
+	return t.limit
+}
+
+func (t *ConcurrencyTuner) adjust() {
+	perKey := t.windowTime / time.Duration(t.windowKeys)
+	if t.baseline == 0 || perKey < t.baseline {
+		t.baseline = perKey
+	}
+	budget := time.Duration(float64(t.baseline) * (1 + t.targetImpact))
+
+	prevLimit := t.limit
+	if perKey > budget {
+		t.limit = t.limit * 3 / 4
+		if t.limit < 1 {
+			t.limit = 1
+		}
+	} else if t.limit < t.maxLimit {
+		t.limit++
+	}
+	if t.limit != prevLimit {
+		t.logger.Infof("Mutation differ concurrency changed from %v to %v. Per key latency %v, baseline %v\n",
+			prevLimit, t.limit, perKey, t.baseline)
+	}
+
+	t.windowKeys = 0
+	t.windowTime = 0
+	t.windowStart = time.Now()
+}
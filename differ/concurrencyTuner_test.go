@@ -0,0 +1,97 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	xdcrLog "github.com/couchbase/goxdcr/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrencyTunerAdjust(t *testing.T) {
+	fmt.Println("============== Test case start: TestConcurrencyTunerAdjust =================")
+	assert := assert.New(t)
+
+	// Re-evaluated after every batch
+	tuner := NewConcurrencyTuner(1, 4, 10, 0, xdcrLog.NewLogger("test", xdcrLog.DefaultLoggerContext))
+	release := func(latency time.Duration) {
+		tuner.Acquire()
+		tuner.Release(latency, 1)
+	}
+
+	// Raised one at a time up to the maximum while the latency stays at the baseline
+	for _, expected := range []int{2, 3, 4, 4} {
+		release(10 * time.Millisecond)
+		assert.Equal(expected, tuner.Limit())
+	}
+	// Cut back by a quarter while above the target, but never below 1
+	for _, expected := range []int{3, 2, 1, 1} {
+		release(20 * time.Millisecond)
+		assert.Equal(expected, tuner.Limit())
+	}
+	// A lower latency is taken as the baseline at once
+	release(5 * time.Millisecond)
+	assert.Equal(2, tuner.Limit())
+	release(20 * time.Millisecond)
+	assert.Equal(1, tuner.Limit())
+	fmt.Println("============== Test case end: TestConcurrencyTunerAdjust =================")
+}
+
+func TestConcurrencyTunerRatchet(t *testing.T) {
+	fmt.Println("============== Test case start: TestConcurrencyTunerRatchet =================")
+	assert := assert.New(t)
+
+	tuner := NewConcurrencyTuner(1, 64, 10, 0, xdcrLog.NewLogger("test", xdcrLog.DefaultLoggerContext))
+	release := func(latency time.Duration) {
+		tuner.Acquire()
+		tuner.Release(latency, 1)
+	}
+
+	// Every batch in flight adds 5% to the latency, as it would if the differ were the only load on KV. The latency
+	// stays within the target of the idle KV with up to 3 batches in flight
+	latencyAt := func(limit int) time.Duration {
+		return 10*time.Millisecond + time.Duration(limit-1)*500*time.Microsecond
+	}
+	for i := 0; i < 1000; i++ {
+		release(latencyAt(tuner.Limit()))
+		// The latency the differ causes itself is never taken as the cost of a fetch on an idle KV, which would have
+		// the limit raised step by step up to the maximum
+		assert.True(tuner.Limit() <= 4, "limit %v after %v intervals", tuner.Limit(), i+1)
+	}
+	fmt.Println("============== Test case end: TestConcurrencyTunerRatchet =================")
+}
+
+func TestConcurrencyTunerAcquire(t *testing.T) {
+	fmt.Println("============== Test case start: TestConcurrencyTunerAcquire =================")
+	assert := assert.New(t)
+
+	tuner := NewConcurrencyTuner(1, 1, 10, time.Hour, xdcrLog.NewLogger("test", xdcrLog.DefaultLoggerContext))
+	tuner.Acquire()
+	acquired := make(chan bool)
+	go func() {
+		tuner.Acquire()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		assert.Fail("acquired beyond the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	tuner.Release(time.Millisecond, 1)
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		assert.Fail("not acquired once released")
+	}
+	fmt.Println("============== Test case end: TestConcurrencyTunerAcquire =================")
+}
@@ -0,0 +1,442 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"xdcrDiffer/base"
+
+	xdcrBase "github.com/couchbase/goxdcr/base"
+	xdcrLog "github.com/couchbase/goxdcr/log"
+)
+
+// Summary of the entries dropped while validating an input diff keys file
+type DiffKeysValidation struct {
+	Duplicates []string
+	Empty      int
+	Oversized  []string
+	// Why inputs that started like JSON were not, in which case they were read as plain text keys instead
+	NotJSON []string
+}
+
+func (v *DiffKeysValidation) dropped() int {
+	return len(v.Duplicates) + v.Empty + len(v.Oversized)
+}
+
+func (v *DiffKeysValidation) report(name string, logger *xdcrLog.CommonLogger) {
+	for _, notJSON := range v.NotJSON {
+		logger.Warnf("%v: read as plain text keys, one per line, as it is not valid JSON (%v). Name key files .json or .txt to choose their format\n",
+			name, notJSON)
+	}
+	if v.dropped() == 0 {
+		return
+	}
+	logger.Warnf("%v: dropped %v duplicate, %v empty and %v oversized (over %v bytes) keys\n",
+		name, len(v.Duplicates), v.Empty, len(v.Oversized), base.MaxKeyLength)
+	if len(v.Duplicates) > 0 {
+		logger.Warnf("%v: duplicate keys include %v\n", name, firstEntries(v.Duplicates))
+	}
+	if len(v.Oversized) > 0 {
+		logger.Warnf("%v: oversized keys include %v\n", name, firstEntries(v.Oversized))
+	}
+}
+
+func firstEntries(entries []string) []string {
+	if len(entries) > base.DiffKeysMaxReportedEntries {
+		return entries[:base.DiffKeysMaxReportedEntries]
+	}
+	return entries
+}
+
+type DiffKeysFormat int
+
+const (
+	// JSON if the data starts with { or [ and is valid JSON, plain text otherwise
+	DiffKeysFormatAuto DiffKeysFormat = iota
+	DiffKeysFormatJSON
+	DiffKeysFormatText
+)
+
+// The format of a key file by its extension: .json for JSON, .txt for plain text, and DiffKeysFormatAuto otherwise
+func DiffKeysFormatOfFile(fileName string) DiffKeysFormat {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".json":
+		return DiffKeysFormatJSON
+	case ".txt":
+		return DiffKeysFormatText
+	default:
+		return DiffKeysFormatAuto
+	}
+}
+
+// Parses the contents of a diff keys file, which can be in one of the following formats:
+// 1. A JSON object of collection ID to array of keys, as written by the file differ
+// 2. A JSON array of keys, as written by versions of the file differ from before collections were supported
+// 3. A JSON array of fetch entries, as written by the mutation differ for the keys it could not fetch
+// 4. Newline-delimited plain text, one key per line
+// Keys in the second and last formats belong to the default collection
+// As a plain text key can start with { or [ too, DiffKeysFormatAuto falls back to plain text if the data is not valid
+// JSON, which is recorded in the validation summary
+// Keys encoded by base.EncodeKey, as in the output of any phase, are decoded
+// Duplicate, empty and oversized keys are dropped and returned as part of the validation summary
+func ParseDiffKeys(data []byte, format DiffKeysFormat) (DiffKeysMap, *DiffKeysValidation, error) {
+	parsed, notJSON, err := parseDiffKeys(data, format)
+	if err != nil {
+		return nil, nil, err
+	}
+	parsed, err = parsed.decoded()
+	if err != nil {
+		return nil, nil, err
+	}
+	validated, validation := ValidateDiffKeys(parsed)
+	if notJSON != "" {
+		validation.NotJSON = []string{notJSON}
+	}
+	return validated, validation, nil
+}
+
+// Returns why the data was not read as JSON, if it started like JSON but was read as plain text
+func parseDiffKeys(data []byte, format DiffKeysFormat) (DiffKeysMap, string, error) {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) == 0:
+		return make(DiffKeysMap), "", nil
+	case format == DiffKeysFormatJSON:
+		parsed, err := parseJSONDiffKeys(trimmed)
+		return parsed, "", err
+	case format == DiffKeysFormatAuto && (trimmed[0] == '{' || trimmed[0] == '['):
+		parsed, err := parseJSONDiffKeys(trimmed)
+		if err == nil {
+			return parsed, "", nil
+		}
+		parsed, textErr := parsePlainTextDiffKeys(data)
+		if textErr != nil {
+			return nil, "", err
+		}
+		return parsed, err.Error(), nil
+	default:
+		parsed, err := parsePlainTextDiffKeys(data)
+		return parsed, "", err
+	}
+}
+
+func parseJSONDiffKeys(trimmed []byte) (DiffKeysMap, error) {
+	parsed := make(DiffKeysMap)
+	if trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, &parsed); err != nil {
+			return nil, err
+		}
+		return parsed, nil
+	}
+	var keys []string
+	if err := json.Unmarshal(trimmed, &keys); err == nil {
+		parsed[0] = keys
+		return parsed, nil
+	}
+	// The keys the mutation differ could not fetch, to be verified again
+	var fetchEntries []struct {
+		Key      string
+		SrcColId uint32
+	}
+	if err := json.Unmarshal(trimmed, &fetchEntries); err != nil {
+		return nil, err
+	}
+	for _, fetchEntry := range fetchEntries {
+		parsed[fetchEntry.SrcColId] = append(parsed[fetchEntry.SrcColId], fetchEntry.Key)
+	}
+	return parsed, nil
+}
+
+func parsePlainTextDiffKeys(data []byte) (DiffKeysMap, error) {
+	var keys []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	// Leave room for oversized keys so that they are reported instead of failing the scan
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), 64*bufio.MaxScanTokenSize)
+	for scanner.Scan() {
+		keys = append(keys, string(bytes.TrimRight(scanner.Bytes(), "\r")))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read plain text keys: %v", err)
+	}
+	// Trailing blank lines are not keys
+	for i := len(keys) - 1; i >= 0 && keys[i] == ""; i-- {
+		keys = keys[:i]
+	}
+	return DiffKeysMap{0: keys}, nil
+}
+
+// Drops duplicate, empty and oversized keys
+func ValidateDiffKeys(parsed DiffKeysMap) (DiffKeysMap, *DiffKeysValidation) {
+	validated := make(DiffKeysMap)
+	validation := &DiffKeysValidation{}
+	for colId, keys := range parsed {
+		seen := make(map[string]bool, len(keys))
+		for _, key := range keys {
+			switch {
+			case len(key) == 0:
+				validation.Empty++
+			case len(key) > base.MaxKeyLength:
+				validation.Oversized = append(validation.Oversized, key)
+			case seen[key]:
+				validation.Duplicates = append(validation.Duplicates, key)
+			default:
+				seen[key] = true
+				validated[colId] = append(validated[colId], key)
+			}
+		}
+	}
+	return validated, validation
+}
+
+// Reads the keys to be verified from an alternative source, which is one of:
+// 1. base.DiffKeysSourceStdin to read from standard input
+// 2. base.DiffKeysSourceN1QLPrefix followed by a statement, whose result rows are keys of the source collection queryColId
+// 3. A glob matching one or more files, the keys of all files being combined
+// Anything other than N1QL results can be in any of the formats accepted by ParseDiffKeys. Files are read in the
+// format of their extension, as given by DiffKeysFormatOfFile, and stdin as DiffKeysFormatAuto
+func ReadDiffKeysSource(source string, stdin io.Reader, query func(statement string) ([]string, error), queryColId uint32) (DiffKeysMap, *DiffKeysValidation, error) {
+	switch {
+	case source == base.DiffKeysSourceStdin:
+		data, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read keys from stdin: %v", err)
+		}
+		return ParseDiffKeys(data, DiffKeysFormatAuto)
+	case strings.HasPrefix(source, base.DiffKeysSourceN1QLPrefix):
+		keys, err := query(strings.TrimPrefix(source, base.DiffKeysSourceN1QLPrefix))
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to query keys: %v", err)
+		}
+		validated, validation := ValidateDiffKeys(DiffKeysMap{queryColId: keys})
+		return validated, validation, nil
+	default:
+		fileNames, err := filepath.Glob(source)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(fileNames) == 0 {
+			return nil, nil, fmt.Errorf("no file matches %v", source)
+		}
+		combined := make(DiffKeysMap)
+		var notJSON []string
+		for _, fileName := range fileNames {
+			data, err := ioutil.ReadFile(fileName)
+			if err != nil {
+				return nil, nil, err
+			}
+			// Keys are validated once combined, so that overlaps across files are also dropped
+			keys, fileNotJSON, err := parseDiffKeys(data, DiffKeysFormatOfFile(fileName))
+			if err != nil {
+				return nil, nil, fmt.Errorf("%v: %v", fileName, err)
+			}
+			if fileNotJSON != "" {
+				notJSON = append(notJSON, fmt.Sprintf("%v: %v", fileName, fileNotJSON))
+			}
+			for colId, colKeys := range keys {
+				combined[colId] = append(combined[colId], colKeys...)
+			}
+		}
+		combined, err = combined.decoded()
+		if err != nil {
+			return nil, nil, err
+		}
+		validated, validation := ValidateDiffKeys(combined)
+		validation.NotJSON = notJSON
+		return validated, validation, nil
+	}
+}
+
+var ErrQueryOfOtherBucket = errors.New("the query reads another bucket than the source bucket")
+
+// The scope and collection of bucketName that the keys a diffKeysSource query returns are of, as read from the
+// keyspace of its FROM clause: the default collection for the bucket alone, or bucket.scope.collection
+// A query that reads more than one keyspace, another bucket, or a scope.collection relative to the query context is
+// rejected, as its keys cannot be told to be of a single collection of the bucket
+func N1QLQueryCollection(statement, bucketName string) (string, string, error) {
+	keyspaces, err := n1qlKeyspaces(statement)
+	if err != nil {
+		return "", "", err
+	}
+	if len(keyspaces) == 0 {
+		return "", "", fmt.Errorf("no keyspace found in the FROM clause of the query")
+	}
+	if len(keyspaces) > 1 {
+		var names []string
+		for _, keyspace := range keyspaces {
+			names = append(names, strings.Join(keyspace, "."))
+		}
+		return "", "", fmt.Errorf("the query reads more than one keyspace: %v", strings.Join(names, ", "))
+	}
+	path := keyspaces[0]
+	switch {
+	case len(path) == 2:
+		return "", "", fmt.Errorf("keyspace %v is relative to the query context, name it as bucket.scope.collection instead",
+			strings.Join(path, "."))
+	case path[0] != bucketName:
+		return "", "", fmt.Errorf("%w: %v instead of %v", ErrQueryOfOtherBucket, path[0], bucketName)
+	case len(path) == 1:
+		return xdcrBase.DefaultScopeCollectionName, xdcrBase.DefaultScopeCollectionName, nil
+	default:
+		return path[1], path[2], nil
+	}
+}
+
+// Returns the paths of the distinct keyspaces that follow FROM and JOIN in a statement
+func n1qlKeyspaces(statement string) ([][]string, error) {
+	var keyspaces [][]string
+	seen := make(map[string]bool)
+	stripped, err := stripN1QLLiterals(statement)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(stripped); {
+		if stripped[i] == '`' {
+			end := strings.IndexByte(stripped[i+1:], '`')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated identifier in the query")
+			}
+			i += end + 2
+			continue
+		}
+		word := n1qlWordAt(stripped, i)
+		if word == "" {
+			i++
+			continue
+		}
+		// Fields can be named like keywords, i.e. d.from
+		isField := i > 0 && stripped[i-1] == '.'
+		i += len(word)
+		if isField || !strings.EqualFold(word, "FROM") && !strings.EqualFold(word, "JOIN") {
+			continue
+		}
+		path, err := n1qlPathAt(stripped, i)
+		if err != nil {
+			return nil, err
+		}
+		// A subquery, whose own keyspace is found as the scan carries on
+		if len(path) == 0 {
+			continue
+		}
+		if keyspace := strings.Join(path, "\x00"); !seen[keyspace] {
+			seen[keyspace] = true
+			keyspaces = append(keyspaces, path)
+		}
+	}
+	return keyspaces, nil
+}
+
+// Blanks out string literals and comments, which could hold anything that reads like a FROM clause
+func stripN1QLLiterals(statement string) (string, error) {
+	var stripped strings.Builder
+	for i := 0; i < len(statement); i++ {
+		c := statement[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := i + 1
+			for ; end < len(statement) && statement[end] != c; end++ {
+				if statement[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(statement) {
+				return "", fmt.Errorf("unterminated %c in the query", c)
+			}
+			if c == '`' {
+				// Identifiers are kept, as they name keyspaces
+				stripped.WriteString(statement[i : end+1])
+			} else {
+				stripped.WriteString("''")
+			}
+			i = end
+		case strings.HasPrefix(statement[i:], "--"):
+			end := strings.IndexByte(statement[i:], '\n')
+			if end < 0 {
+				end = len(statement) - i
+			}
+			stripped.WriteByte(' ')
+			i += end - 1
+		case strings.HasPrefix(statement[i:], "/*"):
+			end := strings.Index(statement[i+2:], "*/")
+			if end < 0 {
+				return "", fmt.Errorf("unterminated comment in the query")
+			}
+			stripped.WriteByte(' ')
+			i += end + 3
+		default:
+			stripped.WriteByte(c)
+		}
+	}
+	return stripped.String(), nil
+}
+
+func isN1QLIdentifierChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// The unquoted word that starts at i, if i is not within one
+func n1qlWordAt(statement string, i int) string {
+	if i > 0 && isN1QLIdentifierChar(statement[i-1]) {
+		return ""
+	}
+	end := i
+	for end < len(statement) && isN1QLIdentifierChar(statement[end]) {
+		end++
+	}
+	return statement[i:end]
+}
+
+// Reads the path of a keyspace, i.e. bucket, `bucket`.scope.collection or default:bucket, that starts after
+// whitespace at i. Returns no path for a subquery or expression
+func n1qlPathAt(statement string, i int) ([]string, error) {
+	for i < len(statement) && strings.IndexByte(" \t\r\n", statement[i]) >= 0 {
+		i++
+	}
+	var path []string
+	for {
+		var element string
+		if i < len(statement) && statement[i] == '`' {
+			end := strings.IndexByte(statement[i+1:], '`')
+			element = statement[i+1 : i+1+end]
+			i += end + 2
+		} else {
+			element = n1qlWordAt(statement, i)
+			i += len(element)
+		}
+		if element == "" {
+			if len(path) > 0 {
+				return nil, fmt.Errorf("incomplete keyspace %v. in the query", strings.Join(path, "."))
+			}
+			return nil, nil
+		}
+		path = append(path, element)
+		if i < len(statement) && statement[i] == ':' && len(path) == 1 {
+			// The namespace, which is always default
+			path = path[:0]
+			i++
+			continue
+		}
+		if i >= len(statement) || statement[i] != '.' {
+			break
+		}
+		i++
+	}
+	if len(path) > 3 {
+		return nil, fmt.Errorf("invalid keyspace %v in the query", strings.Join(path, "."))
+	}
+	return path, nil
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"xdcrDiffer/base"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadDiffKeysSourceQuery(t *testing.T) {
+	fmt.Println("============== Test case start: TestReadDiffKeysSourceQuery =================")
+	assert := assert.New(t)
+
+	var queried string
+	query := func(statement string) ([]string, error) {
+		queried = statement
+		return []string{"receipt_1", "receipt_2", "receipt_1"}, nil
+	}
+	keys, validation, err := ReadDiffKeysSource("n1ql:SELECT RAW META().id FROM orders.sales.receipts", nil, query, 12)
+	assert.Nil(err)
+	assert.Equal("SELECT RAW META().id FROM orders.sales.receipts", queried)
+	assert.Equal(DiffKeysMap{12: {"receipt_1", "receipt_2"}}, keys)
+	assert.Equal(1, validation.dropped())
+
+	_, _, err = ReadDiffKeysSource("n1ql:SELECT RAW META().id FROM orders", nil, func(string) ([]string, error) {
+		return nil, fmt.Errorf("index not found")
+	}, 0)
+	assert.NotNil(err)
+	fmt.Println("============== Test case end: TestReadDiffKeysSourceQuery =================")
+}
+
+func TestParseDiffKeys(t *testing.T) {
+	fmt.Println("============== Test case start: TestParseDiffKeys =================")
+	assert := assert.New(t)
+
+	oversized := strings.Repeat("k", base.MaxKeyLength+1)
+	testCases := []struct {
+		name       string
+		data       string
+		format     DiffKeysFormat
+		expected   DiffKeysMap
+		validation *DiffKeysValidation
+		notJSON    bool
+		isErr      bool
+	}{
+		{name: "empty", data: " \n", expected: DiffKeysMap{}, validation: &DiffKeysValidation{}},
+		{name: "collections", data: `{"0":["a","b"],"8":["c"]}`, expected: DiffKeysMap{0: {"a", "b"}, 8: {"c"}},
+			validation: &DiffKeysValidation{}},
+		{name: "legacy array", data: `["a","b"]`, expected: DiffKeysMap{0: {"a", "b"}}, validation: &DiffKeysValidation{}},
+		{name: "fetch entries", data: `[{"Key":"a","SrcColId":8,"Err":"timeout"},{"Key":"b","SrcColId":0}]`,
+			expected: DiffKeysMap{0: {"b"}, 8: {"a"}}, validation: &DiffKeysValidation{}},
+		{name: "plain text", data: "a\r\nb\n\n", expected: DiffKeysMap{0: {"a", "b"}}, validation: &DiffKeysValidation{}},
+		{name: "encoded", data: `{"0":["base64:/w==","b"]}`, expected: DiffKeysMap{0: {"\xff", "b"}},
+			validation: &DiffKeysValidation{}},
+		// The same key in another collection is not a duplicate
+		{name: "duplicates", data: `{"0":["a","b","a","a"],"8":["a"]}`, expected: DiffKeysMap{0: {"a", "b"}, 8: {"a"}},
+			validation: &DiffKeysValidation{Duplicates: []string{"a", "a"}}},
+		{name: "empty and oversized", data: "a\n\n" + oversized + "\nb", expected: DiffKeysMap{0: {"a", "b"}},
+			validation: &DiffKeysValidation{Empty: 1, Oversized: []string{oversized}}},
+		// Plain text keys that start like JSON
+		{name: "plain text starting with a brace", data: "{draft}\nb\n", expected: DiffKeysMap{0: {"{draft}", "b"}},
+			validation: &DiffKeysValidation{}, notJSON: true},
+		{name: "plain text starting with a bracket", data: "[1]\n[2]\n", expected: DiffKeysMap{0: {"[1]", "[2]"}},
+			validation: &DiffKeysValidation{}, notJSON: true},
+		{name: "JSON read as plain text", data: `["a","b"]`, format: DiffKeysFormatText,
+			expected: DiffKeysMap{0: {`["a","b"]`}}, validation: &DiffKeysValidation{}},
+		{name: "plain text read as JSON", data: "a\nb\n", format: DiffKeysFormatJSON, isErr: true},
+		{name: "truncated object", data: `{"0":["a"`, format: DiffKeysFormatJSON, isErr: true},
+		{name: "collection is not a number", data: `{"default":["a"]}`, format: DiffKeysFormatJSON, isErr: true},
+		{name: "keys are not an array", data: `{"0":"a"}`, format: DiffKeysFormatJSON, isErr: true},
+		{name: "array of neither keys nor fetch entries", data: `[1,2]`, format: DiffKeysFormatJSON, isErr: true},
+		{name: "invalid encoded key", data: `["base64:!!"]`, isErr: true},
+	}
+	for _, testCase := range testCases {
+		keys, validation, err := ParseDiffKeys([]byte(testCase.data), testCase.format)
+		if testCase.isErr {
+			assert.NotNil(err, testCase.name)
+			continue
+		}
+		assert.Nil(err, testCase.name)
+		assert.Equal(testCase.expected, keys, testCase.name)
+		assert.Equal(testCase.notJSON, len(validation.NotJSON) == 1, testCase.name)
+		validation.NotJSON = nil
+		assert.Equal(testCase.validation, validation, testCase.name)
+	}
+	fmt.Println("============== Test case end: TestParseDiffKeys =================")
+}
+
+func TestReadDiffKeysSourceUnknownPrefix(t *testing.T) {
+	fmt.Println("============== Test case start: TestReadDiffKeysSourceUnknownPrefix =================")
+	assert := assert.New(t)
+
+	// Anything but stdin and N1QL is taken as a glob, so that a mistyped prefix fails for the lack of a file
+	// instead of being run as a query
+	query := func(statement string) ([]string, error) {
+		assert.Fail("queried " + statement)
+		return nil, nil
+	}
+	for _, source := range []string{"N1QL:SELECT RAW META().id FROM orders", "sql:SELECT RAW META().id FROM orders"} {
+		_, _, err := ReadDiffKeysSource(source, nil, query, 0)
+		assert.NotNil(err, source)
+	}
+	fmt.Println("============== Test case end: TestReadDiffKeysSourceUnknownPrefix =================")
+}
+
+func TestReadDiffKeysSourceFormats(t *testing.T) {
+	fmt.Println("============== Test case start: TestReadDiffKeysSourceFormats =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "diffKeysFormats")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"keys.txt":  "[draft]\n{a}\n",
+		"keys.json": `{"8":["c"]}`,
+		"keys.lst":  "{b}\n",
+	}
+	for name, data := range files {
+		assert.Nil(ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+	keys, validation, err := ReadDiffKeysSource(filepath.Join(dir, "keys.*"), nil, nil, 0)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"[draft]", "{a}", "{b}"}, keys[0])
+	assert.Equal([]string{"c"}, keys[8])
+	// Only the file without a known extension was tried as JSON first
+	assert.Equal(1, len(validation.NotJSON))
+	assert.True(strings.HasPrefix(validation.NotJSON[0], filepath.Join(dir, "keys.lst")+": "), validation.NotJSON[0])
+
+	// A file named .json has to be valid JSON
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "bad.json"), []byte("{b}\n"), 0644))
+	_, _, err = ReadDiffKeysSource(filepath.Join(dir, "bad.json"), nil, nil, 0)
+	assert.NotNil(err)
+
+	// Stdin is read like a file without an extension
+	keys, validation, err = ReadDiffKeysSource(base.DiffKeysSourceStdin, strings.NewReader("{x}\ny\n"), nil, 0)
+	assert.Nil(err)
+	assert.Equal(DiffKeysMap{0: {"{x}", "y"}}, keys)
+	assert.Equal(1, len(validation.NotJSON))
+	fmt.Println("============== Test case end: TestReadDiffKeysSourceFormats =================")
+}
+
+func TestN1QLQueryCollection(t *testing.T) {
+	fmt.Println("============== Test case start: TestN1QLQueryCollection =================")
+	assert := assert.New(t)
+
+	testCases := []struct {
+		statement  string
+		scope      string
+		collection string
+		isErr      bool
+	}{
+		{statement: "SELECT RAW META().id FROM orders WHERE type = 'receipt'", scope: "_default", collection: "_default"},
+		{statement: "select raw meta(r).id from orders.sales.receipts r where r.region = \"EU\"", scope: "sales", collection: "receipts"},
+		{statement: "SELECT META().id FROM `orders`.`sales`.`receipts`", scope: "sales", collection: "receipts"},
+		{statement: "SELECT META().id FROM default:orders.sales.receipts", scope: "sales", collection: "receipts"},
+		// The same keyspace twice, and FROM within literals, comments and field names
+		{statement: "SELECT META(r).id, r.`from` FROM orders.sales.receipts r WHERE r.note != 'FROM other' /* FROM other */ " +
+			"AND r.x.from = 1 AND META(r).id IN (SELECT RAW META().id FROM orders.sales.receipts)", scope: "sales", collection: "receipts"},
+		{statement: "SELECT META(r).id FROM orders.sales.receipts r JOIN orders.sales.refunds f ON r.id = f.receipt", isErr: true},
+		// Relative to the query context, which is not known
+		{statement: "SELECT META().id FROM sales.receipts", isErr: true},
+		{statement: "SELECT META().id FROM other.sales.receipts", isErr: true},
+		{statement: "SELECT RAW 'orders'", isErr: true},
+		{statement: "SELECT META().id FROM orders WHERE a = 'unterminated", isErr: true},
+	}
+	for _, testCase := range testCases {
+		scope, collection, err := N1QLQueryCollection(testCase.statement, "orders")
+		if testCase.isErr {
+			assert.NotNil(err, testCase.statement)
+			continue
+		}
+		assert.Nil(err, testCase.statement)
+		assert.Equal(testCase.scope, scope, testCase.statement)
+		assert.Equal(testCase.collection, collection, testCase.statement)
+	}
+	_, _, err := N1QLQueryCollection("SELECT META().id FROM other", "orders")
+	assert.True(errors.Is(err, ErrQueryOfOtherBucket))
+	fmt.Println("============== Test case end: TestN1QLQueryCollection =================")
+}
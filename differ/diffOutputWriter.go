@@ -0,0 +1,246 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"xdcrDiffer/base"
+	"xdcrDiffer/results"
+)
+
+// The details of a key of the mutation differ output
+type diffOutputEntry struct {
+	// Encoded by base.EncodeKey, as keys of any other output are
+	key     string
+	details interface{}
+}
+
+// A category of the output, collection ID -> entries
+type diffOutputCategory map[uint32][]diffOutputEntry
+
+func newDiffOutputCategory(results map[uint32]map[string][]*GetResult) diffOutputCategory {
+	category := make(diffOutputCategory, len(results))
+	for colId, resultsPerCol := range results {
+		entries := make([]diffOutputEntry, 0, len(resultsPerCol))
+		for key, result := range resultsPerCol {
+			encodedKey, _ := base.EncodeKey(key)
+			entries = append(entries, diffOutputEntry{key: encodedKey, details: result})
+		}
+		category[colId] = entries
+	}
+	return category
+}
+
+func newMissingDiffOutputCategory(results map[uint32]map[string]*GetResult) diffOutputCategory {
+	category := make(diffOutputCategory, len(results))
+	for colId, resultsPerCol := range results {
+		entries := make([]diffOutputEntry, 0, len(resultsPerCol))
+		for key, result := range resultsPerCol {
+			encodedKey, _ := base.EncodeKey(key)
+			entries = append(entries, diffOutputEntry{key: encodedKey, details: result})
+		}
+		category[colId] = entries
+	}
+	return category
+}
+
+// Consecutive entries of a collection, encoded by one worker
+type diffOutputChunk struct {
+	category string
+	colId    uint32
+	// The output that comes before the entries, i.e. the opening of their category and collection
+	prefix []byte
+	// Whether entries of the collection come before those of the chunk
+	continued bool
+	entries   []diffOutputEntry
+	// Whether the details of each entry are also kept on their own, for the chunked output
+	keepRecords bool
+	encoded     chan *diffOutputEncoded
+}
+
+type diffOutputEncoded struct {
+	bytes []byte
+	// Entries left out of bytes, as they could not be encoded
+	quarantined []*QuarantinedEntry
+	records     []*results.Entry
+}
+
+// Entries that cannot be encoded, or are too large to be, are quarantined rather than failing the whole output
+func (c *diffOutputChunk) encode() {
+	var buffer bytes.Buffer
+	var quarantined []*QuarantinedEntry
+	var records []*results.Entry
+	for _, entry := range c.entries {
+		detailsBytes, err := json.Marshal(entry.details)
+		if err == nil && len(detailsBytes) > base.MutationDiffMaxRecordBytes {
+			err = fmt.Errorf("record of %v bytes is larger than %v bytes", len(detailsBytes), base.MutationDiffMaxRecordBytes)
+		}
+		if err != nil {
+			quarantined = append(quarantined, newQuarantinedEntry(c.category, c.colId, entry, err))
+			continue
+		}
+		// Keys are encoded by base.EncodeKey, which makes them valid JSON strings
+		keyBytes, _ := json.Marshal(entry.key)
+		if buffer.Len() > 0 {
+			buffer.WriteByte(',')
+		}
+		buffer.Write(keyBytes)
+		buffer.WriteByte(':')
+		buffer.Write(detailsBytes)
+		if c.keepRecords {
+			records = append(records, &results.Entry{Category: c.category, ColId: c.colId, Key: entry.key,
+				KeyEncoding: base.KeyEncodingOf(entry.key), Details: detailsBytes})
+		}
+	}
+	c.encoded <- &diffOutputEncoded{bytes: buffer.Bytes(), quarantined: quarantined, records: records}
+}
+
+// Splits the output into chunks, returning what follows the last of them. Categories, collection IDs and keys are in
+// the order json.Marshal puts the keys of a map in, so that the output is the same as if it were marshaled whole
+func planDiffOutput(categories map[string]diffOutputCategory) ([]*diffOutputChunk, []byte) {
+	var chunks []*diffOutputChunk
+	// Output that is yet to be given to a chunk
+	pending := []byte{'{'}
+	appendName := func(name string) {
+		nameBytes, _ := json.Marshal(name)
+		pending = append(pending, nameBytes...)
+		pending = append(pending, ':', '{')
+	}
+
+	names := make([]string, 0, len(categories))
+	for name := range categories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if i > 0 {
+			pending = append(pending, ',')
+		}
+		appendName(name)
+
+		colIdStrs := make([]string, 0, len(categories[name]))
+		for colId := range categories[name] {
+			colIdStrs = append(colIdStrs, strconv.FormatUint(uint64(colId), 10))
+		}
+		sort.Strings(colIdStrs)
+		for j, colIdStr := range colIdStrs {
+			if j > 0 {
+				pending = append(pending, ',')
+			}
+			appendName(colIdStr)
+
+			colId, _ := strconv.ParseUint(colIdStr, 10, 32)
+			entries := categories[name][uint32(colId)]
+			sort.Slice(entries, func(a, b int) bool { return entries[a].key < entries[b].key })
+			for start := 0; start < len(entries); start += base.MutationDiffOutputChunkEntries {
+				end := start + base.MutationDiffOutputChunkEntries
+				if end > len(entries) {
+					end = len(entries)
+				}
+				chunks = append(chunks, &diffOutputChunk{
+					category:  name,
+					colId:     uint32(colId),
+					prefix:    pending,
+					continued: start > 0,
+					entries:   entries[start:end],
+					encoded:   make(chan *diffOutputEncoded, 1),
+				})
+				pending = nil
+			}
+			pending = append(pending, '}')
+		}
+		pending = append(pending, '}')
+	}
+	return chunks, append(pending, '}')
+}
+
+// Writes the output as it is encoded, rather than marshaling it whole first, which held it in memory twice and
+// stalled the end of big runs. Chunks are encoded by numberOfWorkers workers and written in order, with a bounded
+// number of them encoded ahead of the writer. The entries are also written to chunked, if set. Returns the entries
+// that were quarantined rather than written
+func writeDiffOutput(writer io.Writer, categories map[string]diffOutputCategory, numberOfWorkers int, chunked *results.ChunkedDetailsWriter) ([]*QuarantinedEntry, error) {
+	if numberOfWorkers < 1 {
+		numberOfWorkers = 1
+	}
+	chunks, trailer := planDiffOutput(categories)
+	for _, chunk := range chunks {
+		chunk.keepRecords = chunked != nil
+	}
+
+	work := make(chan *diffOutputChunk)
+	inFlight := make(chan bool, 2*numberOfWorkers)
+	stopChan := make(chan bool)
+	defer close(stopChan)
+	go func() {
+		defer close(work)
+		for _, chunk := range chunks {
+			select {
+			case inFlight <- true:
+			case <-stopChan:
+				return
+			}
+			select {
+			case work <- chunk:
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+	for i := 0; i < numberOfWorkers; i++ {
+		go func() {
+			for chunk := range work {
+				chunk.encode()
+			}
+		}()
+	}
+
+	bufferedWriter := bufio.NewWriter(writer)
+	var quarantined []*QuarantinedEntry
+	// Whether entries of the collection being written have been, for those of the next chunk to follow a comma
+	var collectionWritten bool
+	for _, chunk := range chunks {
+		encoded := <-chunk.encoded
+		<-inFlight
+		quarantined = append(quarantined, encoded.quarantined...)
+		if _, err := bufferedWriter.Write(chunk.prefix); err != nil {
+			return quarantined, err
+		}
+		if !chunk.continued {
+			collectionWritten = false
+		}
+		if len(encoded.bytes) == 0 {
+			continue
+		}
+		if collectionWritten {
+			if err := bufferedWriter.WriteByte(','); err != nil {
+				return quarantined, err
+			}
+		}
+		if _, err := bufferedWriter.Write(encoded.bytes); err != nil {
+			return quarantined, err
+		}
+		collectionWritten = true
+		for _, record := range encoded.records {
+			if err := chunked.Add(record); err != nil {
+				return quarantined, err
+			}
+		}
+	}
+	if _, err := bufferedWriter.Write(trailer); err != nil {
+		return quarantined, err
+	}
+	return quarantined, bufferedWriter.Flush()
+}
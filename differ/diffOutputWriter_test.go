@@ -0,0 +1,97 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"xdcrDiffer/base"
+	"xdcrDiffer/results"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type unencodable struct{}
+
+func (unencodable) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("unsupported value")
+}
+
+func TestDiffOutputQuarantine(t *testing.T) {
+	fmt.Println("============== Test case start: TestDiffOutputQuarantine =================")
+	assert := assert.New(t)
+
+	// The first chunk of collection 8 is quarantined whole, so the second one is not to follow a comma
+	var entries []diffOutputEntry
+	for i := 0; i < base.MutationDiffOutputChunkEntries+1; i++ {
+		entry := diffOutputEntry{key: fmt.Sprintf("key%05d", i), details: unencodable{}}
+		if i == base.MutationDiffOutputChunkEntries {
+			entry.details = map[string]int{"Cas": 1}
+		}
+		entries = append(entries, entry)
+	}
+	categories := map[string]diffOutputCategory{
+		"Mismatch": {
+			8: entries,
+			9: {{key: "a", details: []int{1}}, {key: "b", details: unencodable{}}, {key: "c", details: []int{3}}},
+		},
+		"MissingFromSource": {8: {{key: "d", details: unencodable{}}}},
+	}
+
+	var output bytes.Buffer
+	quarantined, err := writeDiffOutput(&output, categories, 4, nil)
+	assert.Nil(err)
+	decoded := make(map[string]map[string]map[string]interface{})
+	assert.Nil(json.Unmarshal(output.Bytes(), &decoded), output.String())
+	assert.Len(decoded["Mismatch"]["8"], 1)
+	assert.Len(decoded["Mismatch"]["9"], 2)
+	assert.Len(decoded["MissingFromSource"]["8"], 0)
+	assert.Len(quarantined, base.MutationDiffOutputChunkEntries+2)
+
+	// Only the entries written are indexed in the chunked output
+	var details, index bytes.Buffer
+	chunked, err := results.NewChunkedDetailsWriter(&details, &index, 2)
+	assert.Nil(err)
+	_, err = writeDiffOutput(&output, categories, 4, chunked)
+	assert.Nil(err)
+	assert.Nil(chunked.Close())
+	assert.Equal(3, bytes.Count(index.Bytes(), []byte("\n")))
+
+	dir, err := ioutil.TempDir("", "quarantine")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, base.MutationDiffQuarantineFileName)
+	document := &GetResult{value: []byte("\xff\x00 not JSON")}
+	assert.Nil(writeQuarantine(fileName, []*QuarantinedEntry{
+		newQuarantinedEntry("Mismatch", 9, diffOutputEntry{key: "b", details: []*GetResult{document, nil}}, errors.New("unsupported value")),
+	}))
+	file, err := os.Open(fileName)
+	assert.Nil(err)
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	assert.True(scanner.Scan())
+	entry := &QuarantinedEntry{}
+	assert.Nil(json.Unmarshal(scanner.Bytes(), entry))
+	assert.Equal("Mismatch", entry.Category)
+	assert.Equal(uint32(9), entry.ColId)
+	assert.Equal("unsupported value", entry.Error)
+	assert.Len(entry.Documents, 2)
+	// The body is kept byte for byte
+	assert.Equal(document.value, entry.Documents[0].Body)
+	assert.False(scanner.Scan())
+	fmt.Println("============== Test case end: TestDiffOutputQuarantine =================")
+}
@@ -14,13 +14,15 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"sort"
-	"strings"
 	"sync"
+	"xdcrDiffer/base"
 	fdp "xdcrDiffer/fileDescriptorPool"
+	"xdcrDiffer/results"
 	"xdcrDiffer/utils"
 
 	"github.com/couchbase/gomemcached"
@@ -46,6 +48,13 @@ type FilesDiffer struct {
 	MissingFromFile1     []*oneEntry
 	MissingFromFile2     []*oneEntry
 	BothExistButMismatch []*entryPair
+	// Keys of BothExistButMismatch by what part of the documents differ, then by source collection ID
+	MismatchCategories map[string]DiffKeysMap
+	// Source collection ID -> keys of BothExistButMismatch whose metadata matches, so that only the hashes of their
+	// bodies tell them apart
+	BodyHashKeys map[uint32][]string
+	// Documents that differ by TTL only, as the replication is configured to alter TTLs
+	ExpectedByConfiguration []*entryPair
 
 	fdPool *fdp.FdPool
 
@@ -56,6 +65,10 @@ type FilesDiffer struct {
 	file1ItemCount int
 	file2ItemCount int
 
+	// Keys of each pair of collections diffed by the side they were found on. Not counted in migration mode, as
+	// the target then holds more than what the source replicates
+	keyOverlaps []*results.KeyOverlap
+
 	// For 1->N,  it is possible for doc is mapped to multiple filter IDs
 	duplicatedHintMap DuplicatedHintMap
 	logger            *xdcrLog.CommonLogger
@@ -94,9 +107,10 @@ func (d DuplicatedHintMap) ToIntMap() map[string][]int {
 }
 
 type FileAttributes struct {
-	name          string
-	bucketUUID    string
-	entries       map[uint32]map[string]*oneEntry
+	name       string
+	bucketUUID string
+	// Entries of each collection, sorted by key, with only the latest entry of each key once loaded. Kept in slices
+	// rather than maps by key, which with tens of millions of keys cost far more memory and CPU to build than sorting
 	sortedEntries map[uint32][]*oneEntry
 	readOp        fdp.FileOp
 	closeOp       func() error
@@ -105,20 +119,26 @@ type FileAttributes struct {
 func NewFileAttribute(fileName string) *FileAttributes {
 	attr := &FileAttributes{
 		name:          fileName,
-		entries:       make(map[uint32]map[string]*oneEntry),
 		sortedEntries: make(map[uint32][]*oneEntry),
 	}
 	return attr
 }
 
 type oneEntry struct {
-	Key               string
-	CrMeta            *crMeta.CRMetadata
-	BucketUUID        hlv.DocumentSourceId
-	Seqno             uint64
-	Xattr             []byte
-	XattrSize         uint32
-	BodyHash          [sha512.Size]byte
+	Key          string
+	CrMeta       *crMeta.CRMetadata
+	BucketUUID   hlv.DocumentSourceId
+	Seqno        uint64
+	Xattr        []byte
+	XattrSize    uint32
+	BodyHash     [sha512.Size]byte
+	XattrHash    [base.CaptureXattrHashLen]byte
+	HasXattrHash bool
+	// Whether the document was captured without its body, in which case its body hash is meaningless
+	NoValue bool
+	SyncRev string
+	// Size of the value of the document as it was streamed, or 0 if the capture file does not carry it
+	ValueSize         uint32
 	ColId             uint32
 	ColMigrFilterLen  uint8
 	ColFiltersMatched []uint8
@@ -136,9 +156,25 @@ func (oneEntry *oneEntry) String() string {
 		oneEntry.Key, oneEntry.Seqno, docMeta.RevSeq, docMeta.Cas, docMeta.Flags, docMeta.Expiry, docMeta.Opcode, docMeta.DataType, hex.EncodeToString(oneEntry.BodyHash[:]), oneEntry.ColId)
 }
 
+// Keys that cannot be written as JSON strings as they are get encoded, and flagged with KeyEncoding
+func (entry *oneEntry) MarshalJSON() ([]byte, error) {
+	type entryAlias oneEntry
+	key, encoded := base.EncodeKey(entry.Key)
+	var keyEncoding string
+	if encoded {
+		keyEncoding = base.KeyEncodingBase64
+	}
+	return json.Marshal(&struct {
+		*entryAlias
+		Key         string
+		KeyEncoding string `json:",omitempty"`
+	}{(*entryAlias)(entry), key, keyEncoding})
+}
+
 type entryPair [2]*oneEntry
 
-type ByKeyName []*oneEntry
+// Orders entries by key, and the entries of a key latest first
+type byKeyLatestFirst []*oneEntry
 
 func shaCompare(a, b [sha512.Size]byte) bool {
 	for i := 0; i < sha512.Size; i++ {
@@ -149,6 +185,31 @@ func shaCompare(a, b [sha512.Size]byte) bool {
 	return true
 }
 
+func (entry *oneEntry) hasXattrs() bool {
+	return entry.CrMeta.GetDocumentMetadata().DataType&xdcrBase.XattrDataType > 0
+}
+
+// Returns whether the bodies, and the xattrs that are compared, of both entries match
+// Bodies of documents captured without them are taken to match, as only their metadata can be compared
+func (entry *oneEntry) compareHashes(other *oneEntry) (bodyMatch bool, xattrsMatch bool) {
+	if entry.HasXattrHash == other.HasXattrHash {
+		return entry.NoValue || other.NoValue || shaCompare(entry.BodyHash, other.BodyHash), entry.XattrHash == other.XattrHash
+	}
+	// The entries come from capture files of different layouts, where the body hash of only one of them
+	// covers the xattrs too. The two are only comparable when neither document has xattrs
+	if entry.hasXattrs() || other.hasXattrs() {
+		return false, false
+	}
+	return entry.NoValue || other.NoValue || shaCompare(entry.BodyHash, other.BodyHash), true
+}
+
+// Returns whether both entries are the same mutation but for their TTL, which XDCR may alter as it replicates
+func (entry *oneEntry) differsByExpiryOnly(other *oneEntry, bodyMatch, xattrsMatch bool) bool {
+	meta, otherMeta := entry.CrMeta.GetDocumentMetadata(), other.CrMeta.GetDocumentMetadata()
+	return bodyMatch && xattrsMatch && meta.Expiry != otherMeta.Expiry && meta.Cas == otherMeta.Cas &&
+		meta.RevSeq == otherMeta.RevSeq && meta.Flags == otherMeta.Flags && meta.Opcode == otherMeta.Opcode
+}
+
 // Note Expiry is not used for conflict resolution
 // Returns a boolean to showcase if the values all match
 // For int return val:
@@ -239,6 +300,8 @@ func NewFilesDiffer(file1, file2 string, collectionMapping map[uint32][]uint32,
 		colFilterStrings:    colFilterStrings,
 		colFilterTgtIds:     colFilterTgtIds,
 		duplicatedHintMap:   map[string][]uint8{},
+		MismatchCategories:  make(map[string]DiffKeysMap),
+		BodyHashKeys:        make(map[uint32][]string),
 		logger:              logger,
 	}
 	if len(collectionMapping) == 0 {
@@ -252,7 +315,8 @@ func NewFilesDiffer(file1, file2 string, collectionMapping map[uint32][]uint32,
 func NewFilesDifferWithFDPool(file1, file2 string, fdPool *fdp.FdPool, collectionMapping map[uint32][]uint32, colFilterStrings []string, colFilterTgtIds []uint32, logger *xdcrLog.CommonLogger) (*FilesDiffer, error) {
 	var err error
 	differ := NewFilesDiffer(file1, file2, collectionMapping, colFilterStrings, colFilterTgtIds, logger)
-	if fdPool != nil {
+	// Capture files held in memory are read from there
+	if fdPool != nil && !base.CaptureMemory.InMemory() {
 		differ.fdPool = fdPool
 		differ.file1.readOp, err = fdPool.RegisterReadOnlyFileHandle(file1)
 		if err != nil {
@@ -281,101 +345,106 @@ func UpdateCrMeta(crMetadata *crMeta.CRMetadata, bucketUUID hlv.DocumentSourceId
 	return err
 }
 
-func getOneEntry(readOp fdp.FileOp, bucketUUID hlv.DocumentSourceId) (*oneEntry, error) {
+func getOneEntry(readOp fdp.FileOp, bucketUUID hlv.DocumentSourceId, header *base.CaptureFileHeader) (*oneEntry, error) {
 	entry := &oneEntry{}
 	docMeta := &xdcrBase.DocumentMetadata{}
 	entry.CrMeta = &crMeta.CRMetadata{}
 	entry.BucketUUID = bucketUUID
+	readOp = (&recordReadOp{readOp: readOp}).read
 	keyLenBytes := make([]byte, 2)
 	bytesRead, err := readOp(keyLenBytes)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read keyLen, bytes read: %v, err: %v", bytesRead, err)
+		return nil, fmt.Errorf("Unable to read keyLen, bytes read: %v, err: %w", bytesRead, err)
 	}
 	entryKeyLen := binary.BigEndian.Uint16(keyLenBytes)
 
 	keyBytes := make([]byte, entryKeyLen)
 	bytesRead, err = readOp(keyBytes)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read key, bytes read: %v, err: %v", bytesRead, err)
+		return nil, fmt.Errorf("Unable to read key, bytes read: %v, err: %w", bytesRead, err)
 	}
 	entry.Key = string(keyBytes)
 
 	seqnoBytes := make([]byte, 8)
 	bytesRead, err = readOp(seqnoBytes)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read seqno, bytes read: %v, err: %v", bytesRead, err)
+		return nil, fmt.Errorf("Unable to read seqno, bytes read: %v, err: %w", bytesRead, err)
 	}
 	entry.Seqno = binary.BigEndian.Uint64(seqnoBytes)
 
 	revIdBytes := make([]byte, 8)
 	bytesRead, err = readOp(revIdBytes)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read revIdBytes, bytes read: %v, err: %v", bytesRead, err)
+		return nil, fmt.Errorf("Unable to read revIdBytes, bytes read: %v, err: %w", bytesRead, err)
 	}
 	docMeta.RevSeq = binary.BigEndian.Uint64(revIdBytes)
 
 	casBytes := make([]byte, 8)
 	bytesRead, err = readOp(casBytes)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read casBytes, bytes read: %v, err: %v", bytesRead, err)
+		return nil, fmt.Errorf("Unable to read casBytes, bytes read: %v, err: %w", bytesRead, err)
 	}
 	docMeta.Cas = binary.BigEndian.Uint64(casBytes)
 
 	flagBytes := make([]byte, 4)
 	bytesRead, err = readOp(flagBytes)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read flagsBytes, bytes read: %v, err: %v", bytesRead, err)
+		return nil, fmt.Errorf("Unable to read flagsBytes, bytes read: %v, err: %w", bytesRead, err)
 	}
 	docMeta.Flags = binary.BigEndian.Uint32(flagBytes)
 
 	expiryBytes := make([]byte, 4)
 	bytesRead, err = readOp(expiryBytes)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read expiryBytes, bytes read: %v, err: %v", bytesRead, err)
+		return nil, fmt.Errorf("Unable to read expiryBytes, bytes read: %v, err: %w", bytesRead, err)
 	}
 	docMeta.Expiry = binary.BigEndian.Uint32(expiryBytes)
 
 	opCodeBytes := make([]byte, 2)
 	bytesRead, err = readOp(opCodeBytes)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read opCodeBytes, bytes read: %v, err: %v", bytesRead, err)
+		return nil, fmt.Errorf("Unable to read opCodeBytes, bytes read: %v, err: %w", bytesRead, err)
 	}
 	docMeta.Opcode = gomemcached.CommandCode(binary.BigEndian.Uint16(opCodeBytes))
 
 	dataTypeBytes := make([]byte, 2)
 	bytesRead, err = readOp(dataTypeBytes)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read dataTypeBytes, bytes read: %v, err: %v", bytesRead, err)
+		return nil, fmt.Errorf("Unable to read dataTypeBytes, bytes read: %v, err: %w", bytesRead, err)
 	}
-	docMeta.DataType = uint8(binary.BigEndian.Uint16(dataTypeBytes))
+	// Held as it is compared, so that a document compressed on one side only is not reported as differing
+	docMeta.DataType = bucketCompressionPolicy.get().Datatype(uint8(binary.BigEndian.Uint16(dataTypeBytes)))
 
 	entry.CrMeta.SetDocumentMetadata(docMeta)
 
 	importCasBytes := make([]byte, 8)
 	bytesRead, err = readOp(importCasBytes)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read importCasBytes, bytes read: %v, err: %v", bytesRead, err)
+		return nil, fmt.Errorf("Unable to read importCasBytes, bytes read: %v, err: %w", bytesRead, err)
 	}
 	entry.CrMeta.SetImportCas(binary.BigEndian.Uint64(importCasBytes))
 
 	pRevIdBytes := make([]byte, 8)
 	bytesRead, err = readOp(pRevIdBytes)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read pRevIdBytes, bytes read: %v, err: %v", bytesRead, err)
+		return nil, fmt.Errorf("Unable to read pRevIdBytes, bytes read: %v, err: %w", bytesRead, err)
 	}
 	pRev := binary.BigEndian.Uint64(pRevIdBytes)
 
 	hlvSizebytes := make([]byte, 8)
 	bytesRead, err = readOp(hlvSizebytes)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read HlvSizeBytes, bytes read: %v, err: %v", bytesRead, err)
+		return nil, fmt.Errorf("Unable to read HlvSizeBytes, bytes read: %v, err: %w", bytesRead, err)
 	}
 	hlvSize := binary.BigEndian.Uint64(hlvSizebytes)
+	if hlvSize > base.MaxCaptureHlvLen {
+		return nil, fmt.Errorf("Invalid hlv size %v", hlvSize)
+	}
 
 	HlvBytes := make([]byte, hlvSize)
 	bytesRead, err = readOp(HlvBytes)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read hlv, bytes read: %v, err: %v", bytesRead, err)
+		return nil, fmt.Errorf("Unable to read hlv, bytes read: %v, err: %w", bytesRead, err)
 	}
 	if len(HlvBytes) != 0 {
 		// UpdateCrMeta sets the appropriate doc version incase the mutation is an import Mutation
@@ -390,21 +459,53 @@ func getOneEntry(readOp fdp.FileOp, bucketUUID hlv.DocumentSourceId) (*oneEntry,
 	hashBytes := make([]byte, sha512.Size)
 	bytesRead, err = readOp(hashBytes)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read hashBytes, bytes read: %v, err: %v", bytesRead, err)
+		return nil, fmt.Errorf("Unable to read hashBytes, bytes read: %v, err: %w", bytesRead, err)
 	}
 	copy(entry.BodyHash[:], hashBytes)
 
+	if header.HasXattrHash() {
+		bytesRead, err = readOp(entry.XattrHash[:])
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read xattrHashBytes, bytes read: %v, err: %w", bytesRead, err)
+		}
+		entry.HasXattrHash = true
+	}
+	entry.NoValue = header.HasNoValue()
+
+	if header.HasSyncRev() {
+		syncRevLenBytes := make([]byte, 2)
+		bytesRead, err = readOp(syncRevLenBytes)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read syncRevLenBytes, bytes read: %v, err: %w", bytesRead, err)
+		}
+		syncRevBytes := make([]byte, binary.BigEndian.Uint16(syncRevLenBytes))
+		bytesRead, err = readOp(syncRevBytes)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read syncRev, bytes read: %v, err: %w", bytesRead, err)
+		}
+		entry.SyncRev = string(syncRevBytes)
+	}
+
+	if header.HasValueSize() {
+		valueSizeBytes := make([]byte, 4)
+		bytesRead, err = readOp(valueSizeBytes)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read valueSizeBytes, bytes read: %v, err: %w", bytesRead, err)
+		}
+		entry.ValueSize = binary.BigEndian.Uint32(valueSizeBytes)
+	}
+
 	collectionIdBytes := make([]byte, 4)
 	bytesRead, err = readOp(collectionIdBytes)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read collectionIdBytes, bytes read: %v, err: %v", bytesRead, err)
+		return nil, fmt.Errorf("Unable to read collectionIdBytes, bytes read: %v, err: %w", bytesRead, err)
 	}
 	entry.ColId = binary.BigEndian.Uint32(collectionIdBytes)
 
 	colFiltersLenByte := make([]byte, 2)
 	bytesRead, err = readOp(colFiltersLenByte)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read filterLenBytes, bytes read: %v, err: %v", bytesRead, err)
+		return nil, fmt.Errorf("Unable to read filterLenBytes, bytes read: %v, err: %w", bytesRead, err)
 	}
 	entry.ColMigrFilterLen = uint8(binary.BigEndian.Uint16(colFiltersLenByte))
 
@@ -413,7 +514,7 @@ func getOneEntry(readOp fdp.FileOp, bucketUUID hlv.DocumentSourceId) (*oneEntry,
 		idByte := make([]byte, 2)
 		bytesRead, err = readOp(idByte)
 		if err != nil {
-			return nil, fmt.Errorf("Unable to read a single colFilterID for index %v, err: %v", i, err)
+			return nil, fmt.Errorf("Unable to read a single colFilterID for index %v, err: %w", i, err)
 		}
 		colFilterIds = append(colFilterIds, uint8(binary.BigEndian.Uint16(idByte)))
 	}
@@ -421,50 +522,71 @@ func getOneEntry(readOp fdp.FileOp, bucketUUID hlv.DocumentSourceId) (*oneEntry,
 	return entry, nil
 }
 
-func (a ByKeyName) Len() int           { return len(a) }
-func (a ByKeyName) Swap(i, j int)      { *a[i], *a[j] = *a[j], *a[i] }
-func (a ByKeyName) Less(i, j int) bool { return a[i].Key < a[j].Key }
+func (a byKeyLatestFirst) Len() int      { return len(a) }
+func (a byKeyLatestFirst) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byKeyLatestFirst) Less(i, j int) bool {
+	if a[i].Key != a[j].Key {
+		return a[i].Key < a[j].Key
+	}
+	return a[i].Seqno > a[j].Seqno
+}
 
-func (attr *FileAttributes) fillAndDedupEntries() error {
+func (attr *FileAttributes) fillEntries() error {
 	var err error
 	var entry *oneEntry
 	bucketUUID, er := hlv.UUIDtoDocumentSource(attr.bucketUUID)
 	if er != nil {
 		return er
 	}
+	header, readOp, er := readCaptureFileHeader(attr.readOp)
+	if er != nil {
+		return er
+	}
 	for {
-		entry, err = getOneEntry(attr.readOp, bucketUUID)
+		if header.HasRecordChecksum() {
+			entry, err = getOneEntryWithChecksum(readOp, bucketUUID, header)
+		} else {
+			entry, err = getOneEntry(readOp, bucketUUID, header)
+		}
 		if err != nil {
 			break
 		}
-		_, exists := attr.entries[entry.ColId]
-		if !exists {
-			attr.entries[entry.ColId] = make(map[string]*oneEntry)
-		}
-		if curEntry, ok := attr.entries[entry.ColId][entry.Key]; !ok {
-			attr.entries[entry.ColId][entry.Key] = entry
-		} else {
-			// Replace the entry in the map if the seqno is newer
-			if entry.Seqno > curEntry.Seqno {
-				attr.entries[entry.ColId][entry.Key] = entry
-			}
-		}
+		// Older entries of the same key are dropped once sorted
+		attr.sortedEntries[entry.ColId] = append(attr.sortedEntries[entry.ColId], entry)
 	}
 
-	if strings.Contains(err.Error(), io.EOF.Error()) {
+	if errors.Is(err, io.EOF) {
 		err = nil
 	}
 
 	return err
 }
 
-func (attr *FileAttributes) sortEntries() {
-	for colId, entriesOfThisCollection := range attr.entries {
-		for _, v := range entriesOfThisCollection {
-			attr.sortedEntries[colId] = append(attr.sortedEntries[colId], v)
+// Sorts the entries of each collection by key, keeping the one of the highest seqno of each key in place
+func (attr *FileAttributes) sortAndDedupEntries() {
+	for colId, entries := range attr.sortedEntries {
+		sort.Sort(byKeyLatestFirst(entries))
+		deduped := entries[:0]
+		for i, entry := range entries {
+			if i > 0 && entry.Key == entries[i-1].Key {
+				continue
+			}
+			deduped = append(deduped, entry)
+		}
+		// The entries dropped are not to be held on to by the rest of the slice
+		for i := len(deduped); i < len(entries); i++ {
+			entries[i] = nil
 		}
-		sort.Sort(ByKeyName(attr.sortedEntries[colId]))
+		attr.sortedEntries[colId] = deduped
+	}
+}
+
+func (attr *FileAttributes) itemCount() int {
+	var count int
+	for _, entries := range attr.sortedEntries {
+		count += len(entries)
 	}
+	return count
 }
 
 func (attr *FileAttributes) LoadFileIntoBuffer() error {
@@ -473,6 +595,8 @@ func (attr *FileAttributes) LoadFileIntoBuffer() error {
 	}
 	if attr.readOp != nil && attr.closeOp != nil {
 		defer attr.closeOp()
+	} else if readOp, ok := base.CaptureMemory.Open(attr.name); ok {
+		attr.readOp = readOp
 	} else {
 		file, err := os.Open(attr.name)
 		defer file.Close()
@@ -481,11 +605,11 @@ func (attr *FileAttributes) LoadFileIntoBuffer() error {
 		}
 		attr.readOp = file.Read
 	}
-	err := attr.fillAndDedupEntries()
+	err := attr.fillEntries()
 	if err != nil {
 		return err
 	}
-	attr.sortEntries()
+	attr.sortAndDedupEntries()
 	return nil
 }
 
@@ -511,7 +635,6 @@ func (differ *FilesDiffer) diffSorted() (map[uint32][]string, map[uint32][]strin
 	colMigrationMode := len(differ.colFilterStrings) > 0
 
 	for srcColId, tgtColIds := range differ.collectionIdMapping {
-		srcDedupMap := make(map[string]bool)
 		for _, tgtColId := range tgtColIds {
 			diffKeys := make([]string, 0)
 			file1Len := len(differ.file1.sortedEntries[srcColId])
@@ -524,6 +647,7 @@ func (differ *FilesDiffer) diffSorted() (map[uint32][]string, map[uint32][]strin
 
 			var i int
 			var j int
+			overlap := &results.KeyOverlap{SourceColId: srcColId, TargetColId: tgtColId}
 
 			for i < file1Len && j < file2Len {
 				item1 := differ.file1.sortedEntries[srcColId][i]
@@ -531,7 +655,42 @@ func (differ *FilesDiffer) diffSorted() (map[uint32][]string, map[uint32][]strin
 				differ.addMigrationHintIfNeeded(colMigrationMode, item1, migrationHintMap)
 
 				keyCompare, match := item1.Diff(*item2)
+				switch {
+				case keyCompare == 0:
+					overlap.Both++
+				case keyCompare < 0:
+					overlap.SourceOnly++
+				default:
+					overlap.TargetOnly++
+				}
+				if keyCompare == 0 && item1.SyncRev != "" && item2.SyncRev != "" {
+					// Documents imported by Sync Gateway are compared by their position in the revision tree,
+					// as their CAS differs between clusters by design
+					match = item1.SyncRev == item2.SyncRev
+				}
+				metaMatch := match
+				bodyMatch, xattrsMatch := item1.compareHashes(item2)
+				if match && !(bodyMatch && xattrsMatch) {
+					// The value hash is computed at stream time, so body divergence can be detected without a fetch
+					match = false
+				}
+				if keyCompare == 0 && bodyComparison.get() {
+					match = bodyMatch && item1.IsMutation() == item2.IsMutation()
+				}
 				validComparison := !colMigrationMode || item1.MapsToTargetCol(item2.ColId, differ.colFilterTgtIds, tgtColId) && item1.IsMutation() && item2.IsMutation()
+				expiryOnly := keyCompare == 0 && item1.differsByExpiryOnly(item2, bodyMatch, xattrsMatch)
+				if expiryOnly {
+					if replicationTTLPolicy.get().Expected(item1.CrMeta.GetDocumentMetadata().Expiry, item2.CrMeta.GetDocumentMetadata().Expiry) {
+						if validComparison {
+							differ.ExpectedByConfiguration = append(differ.ExpectedByConfiguration, &entryPair{item1, item2})
+						}
+						i++
+						j++
+						continue
+					}
+					// Expiry is not part of conflict resolution metadata, so the TTL is compared on its own
+					match = false
+				}
 				if match {
 					// Both items are the same
 					i++
@@ -544,8 +703,12 @@ func (differ *FilesDiffer) diffSorted() (map[uint32][]string, map[uint32][]strin
 							onePair[0] = item1
 							onePair[1] = item2
 							differ.BothExistButMismatch = append(differ.BothExistButMismatch, &onePair)
+							differ.addMismatchCategory(srcColId, item1.Key, bodyMatch, xattrsMatch, expiryOnly)
+							if metaMatch && !bodyMatch {
+								differ.BodyHashKeys[srcColId] = append(differ.BodyHashKeys[srcColId], item1.Key)
+							}
 							diffKeys = append(diffKeys, item1.Key)
-							addToSrcDiffMapIfNotAdded(srcDedupMap, item1.Key, srcDiffMap, srcColId)
+							srcDiffMap[srcColId] = append(srcDiffMap[srcColId], item1.Key)
 							tgtDiffMap[tgtColId] = append(tgtDiffMap[tgtColId], item1.Key)
 						}
 						i++
@@ -555,7 +718,7 @@ func (differ *FilesDiffer) diffSorted() (map[uint32][]string, map[uint32][]strin
 						if validComparison {
 							differ.MissingFromFile2 = append(differ.MissingFromFile2, item1)
 							diffKeys = append(diffKeys, item1.Key)
-							addToSrcDiffMapIfNotAdded(srcDedupMap, item1.Key, srcDiffMap, srcColId)
+							srcDiffMap[srcColId] = append(srcDiffMap[srcColId], item1.Key)
 							tgtDiffMap[tgtColId] = append(tgtDiffMap[tgtColId], item1.Key)
 						}
 						i++
@@ -564,7 +727,7 @@ func (differ *FilesDiffer) diffSorted() (map[uint32][]string, map[uint32][]strin
 						if validComparison {
 							differ.MissingFromFile1 = append(differ.MissingFromFile1, item2)
 							diffKeys = append(diffKeys, item2.Key)
-							addToSrcDiffMapIfNotAdded(srcDedupMap, item2.Key, srcDiffMap, srcColId)
+							srcDiffMap[srcColId] = append(srcDiffMap[srcColId], item2.Key)
 							tgtDiffMap[tgtColId] = append(tgtDiffMap[tgtColId], item2.Key)
 						}
 						j++
@@ -572,6 +735,12 @@ func (differ *FilesDiffer) diffSorted() (map[uint32][]string, map[uint32][]strin
 				}
 			}
 
+			overlap.SourceOnly += int64(file1Len - i)
+			overlap.TargetOnly += int64(file2Len - j)
+			if !colMigrationMode {
+				differ.keyOverlaps = append(differ.keyOverlaps, overlap)
+			}
+
 			for ; i < file1Len; i++ {
 				// This means that all the rest of the entries in file1 are missing from file2
 				item1 := differ.file1.sortedEntries[srcColId][i]
@@ -579,7 +748,7 @@ func (differ *FilesDiffer) diffSorted() (map[uint32][]string, map[uint32][]strin
 				validComparison := !colMigrationMode || item1.MapsToTargetCol(tgtColId, differ.colFilterTgtIds, tgtColId) && item1.IsMutation()
 				if validComparison {
 					differ.MissingFromFile2 = append(differ.MissingFromFile2, item1)
-					addToSrcDiffMapIfNotAdded(srcDedupMap, item1.Key, srcDiffMap, srcColId)
+					srcDiffMap[srcColId] = append(srcDiffMap[srcColId], item1.Key)
 				}
 			}
 
@@ -594,14 +763,24 @@ func (differ *FilesDiffer) diffSorted() (map[uint32][]string, map[uint32][]strin
 				}
 			}
 		}
+		// A source key is only found to differ once per target collection, so only source collections compared
+		// to several can have a key listed more than once
+		if len(tgtColIds) > 1 && len(srcDiffMap[srcColId]) > 0 {
+			srcDiffMap[srcColId] = sortedUniqueKeys(srcDiffMap[srcColId])
+		}
 	}
 	return srcDiffMap, tgtDiffMap, migrationHintMap
 }
 
-func addToSrcDiffMapIfNotAdded(srcDedupMap map[string]bool, key string, srcDiffMap map[uint32][]string, srcColId uint32) {
-	if _, exists := srcDedupMap[key]; !exists {
-		srcDiffMap[srcColId] = append(srcDiffMap[srcColId], key)
+func sortedUniqueKeys(keys []string) []string {
+	sort.Strings(keys)
+	unique := keys[:0]
+	for i, key := range keys {
+		if i == 0 || key != keys[i-1] {
+			unique = append(unique, key)
+		}
 	}
+	return unique
 }
 
 // Diff Returns:
@@ -624,19 +803,65 @@ func (differ *FilesDiffer) Diff() (srcDiffMap, tgtDiffMap map[uint32][]string, m
 	if differ.err2 != nil {
 		differ.logger.Errorf("Error when loading file %v contents: %v\n", differ.file2.name, differ.err2)
 	}
+	if differ.SourceCorrupted() || differ.TargetCorrupted() {
+		// Diffing what could be loaded would only produce wrong results
+		err = fmt.Errorf("%w: source=%v target=%v", base.ErrCaptureFileCorrupted, differ.err1, differ.err2)
+		return
+	}
 
 	srcDiffMap, tgtDiffMap, migrationHintMap = differ.diffSorted()
 	diffBytes, err = differ.diffToJson()
 
-	// Count source items
-	for _, entryMap := range differ.file1.entries {
-		differ.file1ItemCount += len(entryMap)
+	differ.file1ItemCount = differ.file1.itemCount()
+	differ.file2ItemCount = differ.file2.itemCount()
+	return srcDiffMap, tgtDiffMap, migrationHintMap, diffBytes, err
+}
+
+// CAS of the last mutation of each divergent document. Of documents that exist on both sides, the later one
+func (differ *FilesDiffer) DivergenceCas() []uint64 {
+	casValues := make([]uint64, 0, len(differ.BothExistButMismatch)+len(differ.MissingFromFile1)+len(differ.MissingFromFile2))
+	for _, pair := range differ.BothExistButMismatch {
+		srcCas, tgtCas := pair[0].CrMeta.GetDocumentMetadata().Cas, pair[1].CrMeta.GetDocumentMetadata().Cas
+		if tgtCas > srcCas {
+			srcCas = tgtCas
+		}
+		casValues = append(casValues, srcCas)
 	}
-	// Count target Items
-	for _, entryMap := range differ.file2.entries {
-		differ.file2ItemCount += len(entryMap)
+	for _, entries := range [][]*oneEntry{differ.MissingFromFile1, differ.MissingFromFile2} {
+		for _, entry := range entries {
+			casValues = append(casValues, entry.CrMeta.GetDocumentMetadata().Cas)
+		}
 	}
-	return srcDiffMap, tgtDiffMap, migrationHintMap, diffBytes, err
+	return casValues
+}
+
+// Size of the value of each divergent document, for the mutation differ to batch the documents it fetches by. Of
+// documents that exist on both sides, the larger one. Documents whose capture files do not carry sizes are left out
+func (differ *FilesDiffer) DivergenceSizes() DiffKeySizes {
+	sizes := make(DiffKeySizes)
+	add := func(entry *oneEntry) {
+		if entry.ValueSize > sizes[entry.Key] {
+			sizes[entry.Key] = entry.ValueSize
+		}
+	}
+	for _, pair := range differ.BothExistButMismatch {
+		add(pair[0])
+		add(pair[1])
+	}
+	for _, entries := range [][]*oneEntry{differ.MissingFromFile1, differ.MissingFromFile2} {
+		for _, entry := range entries {
+			add(entry)
+		}
+	}
+	return sizes
+}
+
+func (differ *FilesDiffer) SourceCorrupted() bool {
+	return errors.Is(differ.err1, base.ErrCaptureFileCorrupted)
+}
+
+func (differ *FilesDiffer) TargetCorrupted() bool {
+	return errors.Is(differ.err2, base.ErrCaptureFileCorrupted)
 }
 
 func (differ *FilesDiffer) PrettyPrintResult() {
@@ -644,7 +869,7 @@ func (differ *FilesDiffer) PrettyPrintResult() {
 	missing1Cnt := len(differ.MissingFromFile1)
 	missing2Cnt := len(differ.MissingFromFile2)
 
-	if len(differ.file1.entries) == 0 && len(differ.file2.entries) == 0 {
+	if len(differ.file1.sortedEntries) == 0 && len(differ.file2.sortedEntries) == 0 {
 		fmt.Printf("Diff tool has not been run yet\n")
 	} else if mismatchCnt == 0 && missing1Cnt == 0 && missing2Cnt == 0 {
 		fmt.Printf("Both sides match\n")
@@ -680,9 +905,13 @@ func (differ *FilesDiffer) PrettyPrintResult() {
 
 func (differ *FilesDiffer) diffToJson() ([]byte, error) {
 	outputMap := map[string]interface{}{
-		"Mismatch":          differ.BothExistButMismatch,
-		"MissingFromSource": differ.MissingFromFile1,
-		"MissingFromTarget": differ.MissingFromFile2,
+		"Mismatch":           differ.BothExistButMismatch,
+		"MismatchCategories": encodeKeysOfMap(differ.MismatchCategories),
+		"MissingFromSource":  differ.MissingFromFile1,
+		"MissingFromTarget":  differ.MissingFromFile2,
+	}
+	if len(differ.ExpectedByConfiguration) > 0 {
+		outputMap[base.ExpectedByConfigurationCategory] = differ.ExpectedByConfiguration
 	}
 
 	ret, err := json.Marshal(outputMap)
@@ -690,6 +919,31 @@ func (differ *FilesDiffer) diffToJson() ([]byte, error) {
 	return ret, err
 }
 
+func encodeKeysOfMap(keysMap map[string]DiffKeysMap) map[string]DiffKeysMap {
+	encoded := make(map[string]DiffKeysMap, len(keysMap))
+	for k, keys := range keysMap {
+		encoded[k] = keys.encoded()
+	}
+	return encoded
+}
+
+func (differ *FilesDiffer) addMismatchCategory(colId uint32, key string, bodyMatch, xattrsMatch, expiryOnly bool) {
+	var category string
+	if !bodyMatch {
+		category = base.MismatchCategoryBodyDiffers
+	} else if !xattrsMatch {
+		category = base.MismatchCategoryXattrsDiffer
+	} else if expiryOnly {
+		category = base.MismatchCategoryTTLDiffers
+	} else {
+		category = base.MismatchCategoryMetadataDiffers
+	}
+	if differ.MismatchCategories[category] == nil {
+		differ.MismatchCategories[category] = make(DiffKeysMap)
+	}
+	differ.MismatchCategories[category][colId] = append(differ.MismatchCategories[category][colId], key)
+}
+
 func (differ *FilesDiffer) addMigrationHintIfNeeded(migrationMode bool, item1 *oneEntry, hintMap map[string][]uint32) {
 	if !migrationMode {
 		return
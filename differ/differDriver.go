@@ -11,15 +11,19 @@ package differ
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 	"xdcrDiffer/base"
 	fdp "xdcrDiffer/fileDescriptorPool"
+	"xdcrDiffer/results"
+	"xdcrDiffer/stats"
 	"xdcrDiffer/utils"
 
 	xdcrLog "github.com/couchbase/goxdcr/log"
@@ -31,6 +35,50 @@ import (
 type DiffKeysMap map[uint32][]string
 type MigrationHintMap map[string][]uint32
 
+// Keys are written to the diff keys files encoded by base.EncodeKey, and decoded when read back
+func (m DiffKeysMap) encoded() DiffKeysMap {
+	encoded := make(DiffKeysMap, len(m))
+	for colId, keys := range m {
+		encoded[colId] = base.EncodeKeys(keys)
+	}
+	return encoded
+}
+
+func (m DiffKeysMap) decoded() (DiffKeysMap, error) {
+	decoded := make(DiffKeysMap, len(m))
+	for colId, keys := range m {
+		for _, key := range keys {
+			decodedKey, err := base.DecodeKey(key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid encoded key %v: %v", key, err)
+			}
+			decoded[colId] = append(decoded[colId], decodedKey)
+		}
+	}
+	return decoded, nil
+}
+
+func (m MigrationHintMap) encoded() MigrationHintMap {
+	encoded := make(MigrationHintMap, len(m))
+	for key, colIds := range m {
+		encodedKey, _ := base.EncodeKey(key)
+		encoded[encodedKey] = colIds
+	}
+	return encoded
+}
+
+func (m MigrationHintMap) decoded() (MigrationHintMap, error) {
+	decoded := make(MigrationHintMap, len(m))
+	for key, colIds := range m {
+		decodedKey, err := base.DecodeKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encoded key %v: %v", key, err)
+		}
+		decoded[decodedKey] = colIds
+	}
+	return decoded, nil
+}
+
 type pruningWindow struct {
 	duration time.Duration
 	lock     sync.RWMutex
@@ -88,6 +136,25 @@ func (d *DiffKeysMap) GetTotalCount() int {
 	return count
 }
 
+// Returns the subset of keys that belong to the given vbuckets, and the number of keys left out
+func (d *DiffKeysMap) FilterByVbuckets(vbuckets map[uint16]bool) (DiffKeysMap, int) {
+	filtered := make(DiffKeysMap)
+	if d == nil {
+		return filtered, 0
+	}
+	var skipped int
+	for colId, keys := range *d {
+		for _, key := range keys {
+			if vbuckets[utils.GetVbucketFromKey([]byte(key))] {
+				filtered[colId] = append(filtered[colId], key)
+			} else {
+				skipped++
+			}
+		}
+	}
+	return filtered, skipped
+}
+
 // Translate into a list of mutations that needs fetching
 // Returns alongside an index keyed by the document ID
 // For each docID of the index, there can be multiple collection IDs that owns this key
@@ -161,6 +228,20 @@ type MutationDifferFetchEntry struct {
 	Key       string
 }
 
+func (m *MutationDifferFetchEntry) MarshalJSON() ([]byte, error) {
+	type fetchEntryAlias MutationDifferFetchEntry
+	key, encoded := base.EncodeKey(m.Key)
+	var keyEncoding string
+	if encoded {
+		keyEncoding = base.KeyEncodingBase64
+	}
+	return json.Marshal(&struct {
+		*fetchEntryAlias
+		Key         string
+		KeyEncoding string `json:",omitempty"`
+	}{(*fetchEntryAlias)(m), key, keyEncoding})
+}
+
 func (m *MutationDifferFetchEntry) Clone() *MutationDifferFetchEntry {
 	copyTgt := make([]uint32, len(m.TgtColIds))
 	for i, id := range m.TgtColIds {
@@ -223,27 +304,44 @@ type DifferDriver struct {
 	waitGroup         *sync.WaitGroup
 	srcDiffKeys       DiffKeysMap
 	tgtDiffKeys       DiffKeysMap
+	bodyHashKeys      DiffKeysMap
 	stateLock         *sync.RWMutex
 	fileDescPool      *fdp.FdPool
-	vbCompleted       uint32
+	vbCompleted       *stats.Counter
 	finChan           chan bool
 	stopOnce          sync.Once
 	collectionMapping map[uint32][]uint32
 	colFilterStrings  []string
 	colFilterTgtIds   []uint32
-	SourceItemCount   int64
-	TargetItemCount   int64
+	sourceItemCount   *stats.Counter
+	targetItemCount   *stats.Counter
 	SrcVbItemCntMap   map[uint16]int
 	TgtVbItemCntMap   map[uint16]int
 	MapLock           *sync.RWMutex
 	srcMigrationHint  MigrationHintMap
 	DuplicatedHint    DuplicatedHintMap
+	diffKeySizes      DiffKeySizes
 	bucketTopologySvc service_def.BucketTopologySvc
 	specifiedSpec     *metadata.ReplicationSpecification
 	logger            *xdcrLog.CommonLogger
+	// Called to re-generate the capture files of a vbucket that fail validation
+	restreamCb   func(vbno uint16) error
+	corruptedVbs []uint16
+	// The vbuckets to be diffed, in ascending order
+	vbuckets []uint16
+	// If set, vbuckets are diffed as their capture completes on both sides, instead of once the capture has finished
+	capturedVbs <-chan uint16
+	captureDone <-chan bool
+	// Mutation times of the divergent documents, if set
+	divergenceTimeline *results.DivergenceTimeline
+	keyOverlap         *results.KeyOverlapRecorder
+	// Once this many keys are found to differ, no more vbuckets are diffed and onAbort is called. Unlimited if 0
+	abortAfterDiffs int
+	onAbort         func()
+	aborted         uint32
 }
 
-func NewDifferDriver(sourceFileDir, targetFileDir, diffFileDir, diffKeysFileName string, numberOfWorkers, numberOfBins, numberOfFds int, collectionMapping map[uint32][]uint32, colFilterStrings []string, colFilterTgtIds []uint32, sourceBucketUUID, targetBucketUUID string, bucketTopologySvc service_def.BucketTopologySvc, specifiedSpec *metadata.ReplicationSpecification, logger *xdcrLog.CommonLogger) *DifferDriver {
+func NewDifferDriver(sourceFileDir, targetFileDir, diffFileDir, diffKeysFileName string, numberOfWorkers, numberOfBins, numberOfFds int, collectionMapping map[uint32][]uint32, colFilterStrings []string, colFilterTgtIds []uint32, sourceBucketUUID, targetBucketUUID string, bucketTopologySvc service_def.BucketTopologySvc, specifiedSpec *metadata.ReplicationSpecification, logger *xdcrLog.CommonLogger, vbuckets []uint16) *DifferDriver {
 	var fdPool *fdp.FdPool
 	if numberOfFds > 0 {
 		fdPool = fdp.NewFileDescriptorPool(numberOfFds)
@@ -263,6 +361,7 @@ func NewDifferDriver(sourceFileDir, targetFileDir, diffFileDir, diffKeysFileName
 		collectionMapping: collectionMapping,
 		srcDiffKeys:       make(DiffKeysMap),
 		tgtDiffKeys:       make(DiffKeysMap),
+		bodyHashKeys:      make(DiffKeysMap),
 		colFilterStrings:  colFilterStrings,
 		colFilterTgtIds:   colFilterTgtIds,
 		srcMigrationHint:  MigrationHintMap{},
@@ -270,34 +369,126 @@ func NewDifferDriver(sourceFileDir, targetFileDir, diffFileDir, diffKeysFileName
 		TgtVbItemCntMap:   make(map[uint16]int),
 		MapLock:           &sync.RWMutex{},
 		DuplicatedHint:    DuplicatedHintMap{},
+		diffKeySizes:      DiffKeySizes{},
+		keyOverlap:        results.NewKeyOverlapRecorder(),
 		sourceBucketUUID:  sourceBucketUUID,
 		targetBucketUUID:  targetBucketUUID,
 		bucketTopologySvc: bucketTopologySvc,
 		specifiedSpec:     specifiedSpec,
 		logger:            logger,
+		vbuckets:          vbuckets,
+		vbCompleted:       stats.Default.Counter(stats.FileDiffVbsCompleted),
+		sourceItemCount:   stats.Default.Counter(stats.FileDiffSourceItems),
+		targetItemCount:   stats.Default.Counter(stats.FileDiffTargetItems),
 	}
 }
 
+// Number of items, including tombstones, that have been diffed from the source bucket
+func (dr *DifferDriver) SourceItemCount() int64 {
+	return dr.sourceItemCount.Value()
+}
+
+// Number of items, including tombstones, that have been diffed from the target bucket
+func (dr *DifferDriver) TargetItemCount() int64 {
+	return dr.targetItemCount.Value()
+}
+
+// Diffs each vbucket as soon as it is received from capturedVbs, while the capture of the others is still going on.
+// Once captureDone is closed, the vbuckets that have not been received are diffed as they were captured
+func (dr *DifferDriver) SetCaptureHandoff(capturedVbs <-chan uint16, captureDone <-chan bool) {
+	dr.capturedVbs = capturedVbs
+	dr.captureDone = captureDone
+}
+
+// Buckets divergent documents into windows of the given size by the time of their last mutation
+func (dr *DifferDriver) SetHotWindowSize(windowSize time.Duration) {
+	dr.divergenceTimeline = results.NewDivergenceTimeline(windowSize)
+}
+
+// Stops diffing once maxDiffs keys have been found to differ, so that a run that is clearly divergent does not go
+// on for hours. What was diffed up to then is written out as usual. onAbort, if given, is called once, i.e. to stop
+// the capture still feeding the differ
+func (dr *DifferDriver) SetAbortAfterDiffs(maxDiffs int, onAbort func()) {
+	dr.abortAfterDiffs = maxDiffs
+	dr.onAbort = onAbort
+}
+
+// Whether diffing stopped early because the number of keys set by SetAbortAfterDiffs was reached
+func (dr *DifferDriver) Aborted() bool {
+	return atomic.LoadUint32(&dr.aborted) == 1
+}
+
+func (dr *DifferDriver) checkAbortThreshold() {
+	if dr.abortAfterDiffs <= 0 || dr.Aborted() {
+		return
+	}
+	srcDiffCnt, tgtDiffCnt := dr.DiffKeysCount()
+	if srcDiffCnt+tgtDiffCnt < dr.abortAfterDiffs {
+		return
+	}
+	if atomic.CompareAndSwapUint32(&dr.aborted, 0, 1) {
+		dr.logger.Warnf("Found %v keys that differ, reaching the threshold of %v. No more vbuckets will be diffed\n",
+			srcDiffCnt+tgtDiffCnt, dr.abortAfterDiffs)
+		if dr.onAbort != nil {
+			go dr.onAbort()
+		}
+	}
+}
+
+// Nil unless SetHotWindowSize was called
+func (dr *DifferDriver) HotWindows(minSharePercent float64) *results.HotWindowReport {
+	return dr.divergenceTimeline.HotWindows(minSharePercent)
+}
+
+// Collections are named by the names given, which may be nil. Nil in migration mode, or if nothing was diffed
+func (dr *DifferDriver) KeyOverlap(sourceNames, targetNames *results.CollectionNames) *results.KeyOverlapReport {
+	return dr.keyOverlap.Report(sourceNames, targetNames)
+}
+
 func (dr *DifferDriver) Run() error {
-	loadDistribution := utils.BalanceLoad(dr.numberOfWorkers, base.NumberOfVbuckets)
-	err := sourcePruningWindow.set(dr.bucketTopologySvc, dr.specifiedSpec)
-	if err != nil {
-		return err
+	if len(dr.vbuckets) == 0 {
+		for vbno := 0; vbno < base.NumberOfVbuckets; vbno++ {
+			dr.vbuckets = append(dr.vbuckets, uint16(vbno))
+		}
 	}
-	err1 := targetPruningWindow.set(dr.bucketTopologySvc, dr.specifiedSpec)
-	if err1 != nil {
-		return err1
+	if dr.numberOfWorkers > len(dr.vbuckets) {
+		dr.numberOfWorkers = len(dr.vbuckets)
+	}
+	loadDistribution := utils.BalanceLoad(dr.numberOfWorkers, len(dr.vbuckets))
+	// Without a topology service, i.e. in the self test, HLVs are compared without pruning
+	if dr.bucketTopologySvc != nil {
+		err := sourcePruningWindow.set(dr.bucketTopologySvc, dr.specifiedSpec)
+		if err != nil {
+			return err
+		}
+		err1 := targetPruningWindow.set(dr.bucketTopologySvc, dr.specifiedSpec)
+		if err1 != nil {
+			return err1
+		}
 	}
 	go dr.reportStatus()
 
 	var differHandlers []*DifferHandler
 
-	for i := 0; i < dr.numberOfWorkers; i++ {
+	if dr.capturedVbs != nil {
+		// Workers take vbuckets in the order their capture completes, rather than a fixed share of them
+		vbChan := make(chan uint16, len(dr.vbuckets))
+		go dr.dispatchCapturedVbuckets(vbChan)
+		for i := 0; i < dr.numberOfWorkers; i++ {
+			dr.waitGroup.Add(1)
+			differHandler := NewDifferHandler(dr, i, dr.sourceFileDir, dr.targetFileDir, nil, dr.numberOfBins, dr.waitGroup, dr.fileDescPool, dr.collectionMapping, dr.colFilterStrings, dr.colFilterTgtIds)
+			differHandler.vbChan = vbChan
+			differHandlers = append(differHandlers, differHandler)
+			go differHandler.run()
+		}
+	}
+
+	for i := 0; i < dr.numberOfWorkers && dr.capturedVbs == nil; i++ {
 		lowIndex := loadDistribution[i][0]
 		highIndex := loadDistribution[i][1]
 		vbList := make([]uint16, highIndex-lowIndex)
 		for j := lowIndex; j < highIndex; j++ {
-			vbList[j-lowIndex] = uint16(j)
+			vbList[j-lowIndex] = dr.vbuckets[j]
 		}
 
 		dr.waitGroup.Add(1)
@@ -319,6 +510,45 @@ func (dr *DifferDriver) Run() error {
 	return nil
 }
 
+func (dr *DifferDriver) dispatchCapturedVbuckets(vbChan chan uint16) {
+	defer close(vbChan)
+
+	pending := make(map[uint16]bool)
+	for _, vbno := range dr.vbuckets {
+		pending[vbno] = true
+	}
+	dispatch := func(vbno uint16) {
+		if pending[vbno] {
+			delete(pending, vbno)
+			vbChan <- vbno
+		}
+	}
+
+	for len(pending) > 0 {
+		select {
+		case vbno := <-dr.capturedVbs:
+			dispatch(vbno)
+		case <-dr.captureDone:
+			// Vbuckets that completed just before the capture finished come first
+		drain:
+			for {
+				select {
+				case vbno := <-dr.capturedVbs:
+					dispatch(vbno)
+				default:
+					break drain
+				}
+			}
+			dr.logger.Infof("Capture finished with %v vbuckets left to diff\n", len(pending))
+			for _, vbno := range dr.vbuckets {
+				dispatch(vbno)
+			}
+			return
+		}
+	}
+	dr.logger.Infof("All vbuckets were handed off to the file differ as their capture completed\n")
+}
+
 func (dr *DifferDriver) Stop() {
 	dr.stopOnce.Do(func() { dr.cleanup() })
 }
@@ -331,6 +561,35 @@ func (dr *DifferDriver) cleanup() {
 	}
 }
 
+// Sets the callback used to re-stream a vbucket whose capture files are found to be corrupted
+// Without one, such vbuckets are skipped and reported through CorruptedVbs()
+func (dr *DifferDriver) SetRestreamCallback(cb func(vbno uint16) error) {
+	dr.restreamCb = cb
+}
+
+func (dr *DifferDriver) addCorruptedVb(vbno uint16) {
+	dr.stateLock.Lock()
+	defer dr.stateLock.Unlock()
+	dr.corruptedVbs = append(dr.corruptedVbs, vbno)
+}
+
+// Returns the vbuckets that could not be compared because their capture files are corrupted
+func (dr *DifferDriver) CorruptedVbs() []uint16 {
+	dr.stateLock.RLock()
+	defer dr.stateLock.RUnlock()
+	sorted := make([]uint16, len(dr.corruptedVbs))
+	copy(sorted, dr.corruptedVbs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// Returns the number of source and target keys that have been found to differ
+func (dr *DifferDriver) DiffKeysCount() (int, int) {
+	dr.stateLock.RLock()
+	defer dr.stateLock.RUnlock()
+	return dr.srcDiffKeys.GetTotalCount(), dr.tgtDiffKeys.GetTotalCount()
+}
+
 func (dr *DifferDriver) reportStatus() {
 	ticker := time.NewTicker(time.Duration(base.StatsReportInterval) * time.Second)
 	defer ticker.Stop()
@@ -338,9 +597,9 @@ func (dr *DifferDriver) reportStatus() {
 	for {
 		select {
 		case <-ticker.C:
-			vbCompleted := atomic.LoadUint32(&dr.vbCompleted)
+			vbCompleted := dr.vbCompleted.Value()
 			fmt.Printf("%v File differ processed %v vbuckets\n", time.Now(), vbCompleted)
-			if vbCompleted == base.NumberOfVbuckets {
+			if vbCompleted == int64(len(dr.vbuckets)) {
 				return
 			}
 		case <-dr.finChan:
@@ -370,10 +629,40 @@ func (dr *DifferDriver) addTgtDiffKeys(diffKeys map[uint32][]string) {
 	}
 }
 
+func (dr *DifferDriver) addBodyHashKeys(diffKeys DiffKeysMap) {
+	dr.stateLock.Lock()
+	defer dr.stateLock.Unlock()
+	for srcColId, keys := range diffKeys {
+		dr.bodyHashKeys[srcColId] = append(dr.bodyHashKeys[srcColId], keys...)
+	}
+}
+
+func (dr *DifferDriver) addDiffKeySizes(sizes DiffKeySizes) {
+	dr.stateLock.Lock()
+	defer dr.stateLock.Unlock()
+	dr.diffKeySizes.Merge(sizes)
+}
+
 func (dr *DifferDriver) writeDiffKeys() error {
 	dr.stateLock.RLock()
 	defer dr.stateLock.RUnlock()
 
+	if len(dr.diffKeySizes) > 0 {
+		// Only written when the capture files carry sizes, so that the mutation differ knows when it has none
+		if err := dr.diffKeySizes.write(filepath.Join(dr.diffFileDir, base.DiffKeySizesFileName)); err != nil {
+			return err
+		}
+	}
+
+	// Written even when empty, so that the keys of an earlier run in the same directory are not taken for these
+	bodyHashKeysBytes, err := json.Marshal(dr.bodyHashKeys.encoded())
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filepath.Join(dr.diffFileDir, base.DiffKeysBodyHashFileName), bodyHashKeysBytes, base.FileModeReadWrite); err != nil {
+		return err
+	}
+
 	var writeWaitGrp sync.WaitGroup
 	writeWaitGrp.Add(2)
 
@@ -403,7 +692,7 @@ func (dr *DifferDriver) writeSrcDiffKeys(isSrc bool, waitGrp *sync.WaitGroup) er
 		diffKeys = dr.tgtDiffKeys
 	}
 
-	diffKeysBytes, err := json.Marshal(diffKeys)
+	diffKeysBytes, err := json.Marshal(diffKeys.encoded())
 	if err != nil {
 		return err
 	}
@@ -418,7 +707,7 @@ func (dr *DifferDriver) writeSrcDiffKeys(isSrc bool, waitGrp *sync.WaitGroup) er
 
 	if isSrc && len(dr.colFilterStrings) > 0 {
 		migrationHintFile := fmt.Sprintf("%v_%v", diffKeysFileName, base.DiffKeysSrcMigrationHintSuffix)
-		data, err := json.Marshal(dr.srcMigrationHint)
+		data, err := json.Marshal(dr.srcMigrationHint.encoded())
 		if err != nil {
 			return err
 		}
@@ -443,6 +732,8 @@ type DifferHandler struct {
 	collectionMapping map[uint32][]uint32
 	colFilterStrings  []string
 	colFilterTgtIds   []uint32
+	// If set, vbuckets are taken from here as their capture completes, instead of from vbList
+	vbChan <-chan uint16
 
 	duplicatedHintMap DuplicatedHintMap
 }
@@ -474,50 +765,29 @@ func (dh *DifferHandler) run() error {
 		fmt.Printf("%v srcDiff handler failed to initialize. err=%v\n", dh.index, err)
 		return err
 	}
-	var vbno uint16
-	for _, vbno = range dh.vbList {
-		srcVbItemCnt := 0
-		tgtVbItemCnt := 0
-		for bucketIndex := 0; bucketIndex < dh.numberOfBins; bucketIndex++ {
-			sourceFileName := utils.GetFileName(dh.sourceFileDir, vbno, bucketIndex)
-			targetFileName := utils.GetFileName(dh.targetFileDir, vbno, bucketIndex)
-
-			filesDiffer, err := NewFilesDifferWithFDPool(sourceFileName, targetFileName, dh.fileDescPool, dh.collectionMapping, dh.colFilterStrings, dh.colFilterTgtIds, dh.driver.logger)
-			filesDiffer.file1.bucketUUID = dh.driver.sourceBucketUUID
-			filesDiffer.file2.bucketUUID = dh.driver.targetBucketUUID
-			if err != nil {
-				// Most likely FD overrun, program should exit. Print a msg just in case
-				dh.driver.logger.Errorf("Creating file differ for files %v and %v resulted in error: %v\n",
-					sourceFileName, targetFileName, err)
-				return err
-			}
-			srcDiffMap, tgtDiffMap, migrationHints, diffBytes, err := filesDiffer.Diff()
-			if err != nil {
-				fmt.Printf("error getting srcDiff from file differ. err=%v\n", err)
-				continue
-			}
-			if len(srcDiffMap) > 0 || len(tgtDiffMap) > 0 {
-				if len(srcDiffMap) > 0 {
-					dh.driver.addSrcDiffKeys(srcDiffMap, migrationHints)
-				}
-				if len(tgtDiffMap) > 0 {
-					dh.driver.addTgtDiffKeys(tgtDiffMap)
-				}
-				dh.writeDiffBytes(diffBytes)
+	for vbno := range dh.vbucketsToDiff() {
+		if dh.driver.Aborted() {
+			// The remaining vbuckets are left undiffed. Handed off ones need not be drained, as the channel holds them all
+			break
+		}
+		result, err := dh.diffVbucket(vbno)
+		if errors.Is(err, base.ErrCaptureFileCorrupted) && dh.driver.restreamCb != nil {
+			dh.driver.logger.Warnf("Capture files of vb %v are corrupted: %v. Re-streaming the vbucket\n", vbno, err)
+			if restreamErr := dh.driver.restreamCb(vbno); restreamErr != nil {
+				dh.driver.logger.Errorf("Unable to re-stream vb %v. err=%v\n", vbno, restreamErr)
+			} else {
+				result, err = dh.diffVbucket(vbno)
 			}
-			srcVbItemCnt += filesDiffer.file1ItemCount
-			tgtVbItemCnt += filesDiffer.file2ItemCount
-
-			dh.duplicatedHintMap.Merge(filesDiffer.duplicatedHintMap)
 		}
-		atomic.AddInt64(&dh.driver.SourceItemCount, int64(srcVbItemCnt))
-		atomic.AddInt64(&dh.driver.TargetItemCount, int64(tgtVbItemCnt))
-
-		dh.driver.MapLock.Lock()
-		dh.driver.SrcVbItemCntMap[vbno] = srcVbItemCnt
-		dh.driver.TgtVbItemCntMap[vbno] = tgtVbItemCnt
-		dh.driver.MapLock.Unlock()
-		atomic.AddUint32(&dh.driver.vbCompleted, 1)
+		if errors.Is(err, base.ErrCaptureFileCorrupted) {
+			dh.driver.logger.Errorf("Capture files of vb %v are corrupted and it will not be compared. err=%v\n", vbno, err)
+			dh.driver.addCorruptedVb(vbno)
+		} else if err != nil {
+			return err
+		} else {
+			dh.commitVbResult(vbno, result)
+		}
+		dh.driver.vbCompleted.Add(1)
 	}
 
 	dh.cleanup()
@@ -525,8 +795,117 @@ func (dh *DifferHandler) run() error {
 	return nil
 }
 
+func (dh *DifferHandler) vbucketsToDiff() <-chan uint16 {
+	if dh.vbChan != nil {
+		return dh.vbChan
+	}
+	vbChan := make(chan uint16, len(dh.vbList))
+	for _, vbno := range dh.vbList {
+		vbChan <- vbno
+	}
+	close(vbChan)
+	return vbChan
+}
+
+// Results of diffing all the bins of a single vbucket
+type vbDiffResult struct {
+	srcDiffMaps    []map[uint32][]string
+	tgtDiffMaps    []map[uint32][]string
+	migrationHints []map[string][]uint32
+	diffBytes      [][]byte
+	srcItemCnt     int
+	tgtItemCnt     int
+	duplicatedHint DuplicatedHintMap
+	divergenceCas  []uint64
+	diffKeySizes   DiffKeySizes
+	bodyHashKeys   DiffKeysMap
+	keyOverlaps    []*results.KeyOverlap
+}
+
+// Diffs all the bins of a vbucket. Nothing is committed to the driver here so that
+// a vbucket with corrupted capture files can be re-diffed once it has been re-streamed
+func (dh *DifferHandler) diffVbucket(vbno uint16) (*vbDiffResult, error) {
+	result := &vbDiffResult{duplicatedHint: DuplicatedHintMap{}, bodyHashKeys: DiffKeysMap{}, diffKeySizes: DiffKeySizes{}}
+	for bucketIndex := 0; bucketIndex < dh.numberOfBins; bucketIndex++ {
+		sourceFileName := utils.GetFileName(dh.sourceFileDir, vbno, bucketIndex)
+		targetFileName := utils.GetFileName(dh.targetFileDir, vbno, bucketIndex)
+
+		filesDiffer, err := NewFilesDifferWithFDPool(sourceFileName, targetFileName, dh.fileDescPool, dh.collectionMapping, dh.colFilterStrings, dh.colFilterTgtIds, dh.driver.logger)
+		if err != nil {
+			// Most likely FD overrun, program should exit. Print a msg just in case
+			dh.driver.logger.Errorf("Creating file differ for files %v and %v resulted in error: %v\n",
+				sourceFileName, targetFileName, err)
+			return nil, err
+		}
+		filesDiffer.file1.bucketUUID = dh.driver.sourceBucketUUID
+		filesDiffer.file2.bucketUUID = dh.driver.targetBucketUUID
+		srcDiffMap, tgtDiffMap, migrationHints, diffBytes, err := filesDiffer.Diff()
+		if errors.Is(err, base.ErrCaptureFileCorrupted) {
+			return nil, err
+		} else if err != nil {
+			fmt.Printf("error getting srcDiff from file differ. err=%v\n", err)
+			continue
+		}
+		if len(srcDiffMap) > 0 || len(tgtDiffMap) > 0 {
+			result.srcDiffMaps = append(result.srcDiffMaps, srcDiffMap)
+			result.tgtDiffMaps = append(result.tgtDiffMaps, tgtDiffMap)
+			result.migrationHints = append(result.migrationHints, migrationHints)
+			result.diffBytes = append(result.diffBytes, diffBytes)
+			result.diffKeySizes.Merge(filesDiffer.DivergenceSizes())
+			for srcColId, keys := range filesDiffer.BodyHashKeys {
+				result.bodyHashKeys[srcColId] = append(result.bodyHashKeys[srcColId], keys...)
+			}
+		}
+		if dh.driver.divergenceTimeline != nil {
+			result.divergenceCas = append(result.divergenceCas, filesDiffer.DivergenceCas()...)
+		}
+		result.keyOverlaps = append(result.keyOverlaps, filesDiffer.keyOverlaps...)
+		result.srcItemCnt += filesDiffer.file1ItemCount
+		result.tgtItemCnt += filesDiffer.file2ItemCount
+
+		result.duplicatedHint.Merge(filesDiffer.duplicatedHintMap)
+	}
+	return result, nil
+}
+
+func (dh *DifferHandler) commitVbResult(vbno uint16, result *vbDiffResult) {
+	for i := range result.diffBytes {
+		if len(result.srcDiffMaps[i]) > 0 {
+			dh.driver.addSrcDiffKeys(result.srcDiffMaps[i], result.migrationHints[i])
+		}
+		if len(result.tgtDiffMaps[i]) > 0 {
+			dh.driver.addTgtDiffKeys(result.tgtDiffMaps[i])
+		}
+		dh.writeDiffBytes(result.diffBytes[i])
+	}
+	if len(result.diffKeySizes) > 0 {
+		dh.driver.addDiffKeySizes(result.diffKeySizes)
+	}
+	if len(result.bodyHashKeys) > 0 {
+		dh.driver.addBodyHashKeys(result.bodyHashKeys)
+	}
+	for _, cas := range result.divergenceCas {
+		dh.driver.divergenceTimeline.Record(cas)
+	}
+	for _, overlap := range result.keyOverlaps {
+		dh.driver.keyOverlap.Record(overlap)
+	}
+	dh.driver.sourceItemCount.Add(int64(result.srcItemCnt))
+	dh.driver.targetItemCount.Add(int64(result.tgtItemCnt))
+	if len(result.diffBytes) > 0 {
+		dh.driver.checkAbortThreshold()
+	}
+
+	dh.driver.MapLock.Lock()
+	dh.driver.SrcVbItemCntMap[vbno] = result.srcItemCnt
+	dh.driver.TgtVbItemCntMap[vbno] = result.tgtItemCnt
+	dh.driver.MapLock.Unlock()
+
+	dh.duplicatedHintMap.Merge(result.duplicatedHint)
+}
+
 func (dh *DifferHandler) initialize() error {
-	diffDetailsFileName := dh.driver.diffFileDir + base.FileDirDelimiter + base.DiffDetailsFileName + base.FileNameDelimiter + fmt.Sprintf("%v", dh.index)
+	diffDetailsFileName := filepath.Join(dh.driver.diffFileDir, base.DiffDetailsFileName+base.FileNameDelimiter+fmt.Sprintf("%v", dh.index))
 	diffDetailsFile, err := os.OpenFile(diffDetailsFileName, os.O_RDWR|os.O_CREATE, base.FileModeReadWrite)
 	if err != nil {
 		return err
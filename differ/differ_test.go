@@ -13,6 +13,7 @@ import (
 	"crypto/sha512"
 	"fmt"
 	"github.com/couchbase/gomemcached"
+	xdcrLog "github.com/couchbase/goxdcr/log"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"math/rand"
@@ -20,6 +21,7 @@ import (
 	"sync"
 	"testing"
 	"time"
+	"xdcrDiffer/base"
 	"xdcrDiffer/dcp"
 	fdp "xdcrDiffer/fileDescriptorPool"
 )
@@ -29,6 +31,9 @@ const MinUint = 0
 
 var randomOnce sync.Once
 
+// Header of the capture files written by the tests below, which precedes the records generated for them
+var testCaptureHeader = base.NewCaptureFileHeader()
+
 func randomString(l int) string {
 	bytes := make([]byte, l)
 	for i := 0; i < l; i++ {
@@ -94,7 +99,10 @@ func genTestData(regularMutation, colFilters bool) (key string, seqno, revId, ca
 		ColId:             0,
 		ColFiltersMatched: filterIds,
 	}
-	dataSlice := mutationToSerialize.Serialize()
+	dataSlice, err := captureRecords(testCaptureHeader, &mutationToSerialize)
+	if err != nil {
+		panic(err)
+	}
 
 	return key, seqno, revId, cas, flags, expiry, opCode, hash, dataSlice, colId, filterIds
 }
@@ -111,7 +119,7 @@ func genMultipleRecords(numOfRecords int) []byte {
 }
 
 func genSameFiles(numOfRecords int, fileName1, fileName2 string) error {
-	data := genMultipleRecords(numOfRecords)
+	data := append(testCaptureHeader.Encode(), genMultipleRecords(numOfRecords)...)
 
 	err := ioutil.WriteFile(fileName1, data, 0644)
 	if err != nil {
@@ -128,7 +136,7 @@ func genSameFiles(numOfRecords int, fileName1, fileName2 string) error {
 
 func genMismatchedFiles(numOfRecords, mismatchCnt int, fileName1, fileName2 string) ([]string, error) {
 	var mismatchedKeyNames []string
-	data := genMultipleRecords(numOfRecords - mismatchCnt)
+	data := append(testCaptureHeader.Encode(), genMultipleRecords(numOfRecords-mismatchCnt)...)
 
 	err := ioutil.WriteFile(fileName1, data, 0644)
 	if err != nil {
@@ -169,7 +177,10 @@ func genMismatchedFiles(numOfRecords, mismatchCnt int, fileName1, fileName2 stri
 			ColId:             colId,
 			ColFiltersMatched: nil,
 		}
-		mismatchedData := mismatchedDataMut.Serialize()
+		mismatchedData, err := captureRecords(testCaptureHeader, mismatchedDataMut)
+		if err != nil {
+			return mismatchedKeyNames, err
+		}
 
 		_, err = f1.Write(oneData)
 		if err != nil {
@@ -210,17 +221,15 @@ func TestLoader(t *testing.T) {
 
 	key, seqno, _, _, _, _, _, _, data, _, _ := genTestData(true, false)
 
-	err := ioutil.WriteFile(outputFileTemp, data, 0644)
+	err := ioutil.WriteFile(outputFileTemp, append(testCaptureHeader.Encode(), data...), 0644)
 	assert.Nil(err)
 
-	differ := NewFilesDiffer(outputFileTemp, "", nil, nil, nil)
+	differ := newTestFilesDiffer(outputFileTemp, "")
 	err = differ.file1.LoadFileIntoBuffer()
 	assert.Nil(err)
 
-	assert.Equal(1, len(differ.file1.entries[0]))
-	assert.Equal(seqno, differ.file1.entries[0][key].Seqno)
-
 	assert.Equal(1, len(differ.file1.sortedEntries[0]))
+	assert.Equal(key, differ.file1.sortedEntries[0][0].Key)
 	assert.Equal(seqno, differ.file1.sortedEntries[0][0].Seqno)
 }
 
@@ -231,17 +240,18 @@ func TestLoaderWithColFilters(t *testing.T) {
 
 	key, _, _, _, _, _, _, _, data, _, filterIds := genTestData(true, true)
 
-	err := ioutil.WriteFile(outputFileTemp, data, 0644)
+	err := ioutil.WriteFile(outputFileTemp, append(testCaptureHeader.Encode(), data...), 0644)
 	assert.Nil(err)
 
-	differ := NewFilesDiffer(outputFileTemp, "", nil, nil, nil)
+	differ := newTestFilesDiffer(outputFileTemp, "")
 	err = differ.file1.LoadFileIntoBuffer()
 	assert.Nil(err)
 
-	assert.Equal(1, len(differ.file1.entries[0]))
-	assert.Equal(uint8(len(filterIds)), differ.file1.entries[0][key].ColMigrFilterLen)
+	assert.Equal(1, len(differ.file1.sortedEntries[0]))
+	assert.Equal(key, differ.file1.sortedEntries[0][0].Key)
+	assert.Equal(uint8(len(filterIds)), differ.file1.sortedEntries[0][0].ColMigrFilterLen)
 	for i := 0; i < len(filterIds); i++ {
-		assert.Equal(filterIds[i], differ.file1.entries[0][key].ColFiltersMatched[i])
+		assert.Equal(filterIds[i], differ.file1.sortedEntries[0][0].ColFiltersMatched[i])
 	}
 }
 
@@ -259,7 +269,7 @@ func TestLoadSameFile(t *testing.T) {
 	err := genSameFiles(entries, file1, file2)
 	assert.Equal(nil, err)
 
-	differ := NewFilesDiffer(file1, file2, nil, nil, nil)
+	differ := newTestFilesDiffer(file1, file2)
 	assert.NotNil(differ)
 
 	srcDiffMap, tgtDiffMap, _, _, _ := differ.Diff()
@@ -287,7 +297,7 @@ func Disabled_TestLoadMismatchedFilesOnly(t *testing.T) {
 	keys, err := genMismatchedFiles(entries, numMismatch, file1, file2)
 	assert.Nil(err)
 
-	differ := NewFilesDiffer(file1, file2, nil, nil, nil)
+	differ := newTestFilesDiffer(file1, file2)
 	assert.NotNil(differ)
 
 	srcDiffMap, tgtDiffMap, _, _, _ := differ.Diff()
@@ -331,7 +341,7 @@ func Disabled_TestLoadMismatchedFilesAndUneven(t *testing.T) {
 	assert.Nil(err)
 	f.Close()
 
-	differ := NewFilesDiffer(file1, file2, nil, nil, nil)
+	differ := newTestFilesDiffer(file1, file2)
 	assert.NotNil(differ)
 
 	srcDiffMap, tgtDiffMap, _, _, _ := differ.Diff()
@@ -364,9 +374,11 @@ func TestLoadSameFileWPool(t *testing.T) {
 	err := genSameFiles(entries, file1, file2)
 	assert.Equal(nil, err)
 
-	differ, err := NewFilesDifferWithFDPool(file1, file2, fileDescPool, nil, nil, nil)
+	differ, err := NewFilesDifferWithFDPool(file1, file2, fileDescPool, nil, nil, nil, xdcrLog.NewLogger("test", xdcrLog.DefaultLoggerContext))
 	assert.NotNil(differ)
 	assert.Nil(err)
+	differ.file1.bucketUUID = testBucketUUID
+	differ.file2.bucketUUID = testBucketUUID
 
 	srcDiffMap, tgtDiffMap, _, _, _ := differ.Diff()
 
@@ -379,7 +391,7 @@ func TestNoFilePool(t *testing.T) {
 	fmt.Println("============== Test case start: TestNoFilePool =================")
 	assert := assert.New(t)
 
-	differDriver := NewDifferDriver("", "", "", "", 2, 2, 0, nil, nil, nil)
+	differDriver := NewDifferDriver("", "", "", "", 2, 2, 0, nil, nil, nil, "", "", nil, nil, xdcrLog.NewLogger("test", xdcrLog.DefaultLoggerContext), nil)
 	assert.NotNil(differDriver)
 	assert.Nil(differDriver.fileDescPool)
 	fmt.Println("============== Test case end: TestNoFilePool =================")
@@ -0,0 +1,229 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"xdcrDiffer/base"
+	"xdcrDiffer/dcp"
+
+	"github.com/couchbase/gomemcached"
+	xdcrBase "github.com/couchbase/goxdcr/base"
+	xdcrLog "github.com/couchbase/goxdcr/log"
+	"github.com/stretchr/testify/assert"
+)
+
+const testBucketUUID = "0123456789abcdef0123456789abcdef"
+
+func testMutation(key string, seqno, revId, cas uint64, value string) *dcp.Mutation {
+	return dcp.CreateMutation(0, []byte(key), seqno, revId, cas, 0, 0, gomemcached.UPR_MUTATION, []byte(value),
+		base.JSONDataType, 0, &xdcrBase.XattrIterator{}, nil)
+}
+
+// The records of the mutations in the current capture file layout, each followed by its checksum, as a DCP handler
+// writes them
+func captureRecords(header *base.CaptureFileHeader, mutations ...*dcp.Mutation) ([]byte, error) {
+	var data []byte
+	for _, mutation := range mutations {
+		record, err := mutation.Serialize(header)
+		if err != nil {
+			return nil, err
+		}
+		checksum := make([]byte, base.CaptureRecordChecksumLen)
+		binary.BigEndian.PutUint32(checksum, base.CaptureChecksum(record))
+		data = append(append(data, record...), checksum...)
+	}
+	return data, nil
+}
+
+func writeCaptureFile(fileName string, mutations ...*dcp.Mutation) error {
+	header := base.NewCaptureFileHeader()
+	records, err := captureRecords(header, mutations...)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, append(header.Encode(), records...), 0644)
+}
+
+// A mutation of a document imported by Sync Gateway, whose _sync xattr holds the given rev
+func testSyncGatewayMutation(key string, seqno, revId, cas uint64, syncXattr string) (*dcp.Mutation, error) {
+	composer := xdcrBase.NewXattrComposer(make([]byte, 512))
+	if err := composer.WriteKV([]byte(xdcrBase.XATTR_MOBILE), []byte(syncXattr)); err != nil {
+		return nil, err
+	}
+	value, _ := composer.FinishAndAppendDocValue([]byte(`{"a":1}`), nil, nil)
+	// The _sync xattr is left out of the comparison so that only the rev tells the documents apart
+	mutation := dcp.CreateMutation(0, []byte(key), seqno, revId, cas, 0, 0, gomemcached.UPR_MUTATION, value,
+		base.JSONDataType|xdcrBase.XattrDataType, 0, &xdcrBase.XattrIterator{}, map[string]bool{xdcrBase.XATTR_MOBILE: true})
+	mutation.SyncGatewayMode = true
+	return mutation, nil
+}
+
+func newTestFilesDiffer(sourceFileName, targetFileName string) *FilesDiffer {
+	differ := NewFilesDiffer(sourceFileName, targetFileName, nil, nil, nil, xdcrLog.NewLogger("test", xdcrLog.DefaultLoggerContext))
+	differ.file1.bucketUUID = testBucketUUID
+	differ.file2.bucketUUID = testBucketUUID
+	return differ
+}
+
+func TestBodyHashMismatch(t *testing.T) {
+	fmt.Println("============== Test case start: TestBodyHashMismatch =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "bodyHash")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	sourceFileName, targetFileName := filepath.Join(dir, "source"), filepath.Join(dir, "target")
+
+	// The body of doc_1 changed on the target without its metadata changing, i.e. written around XDCR
+	assert.Nil(writeCaptureFile(sourceFileName, testMutation("doc_1", 1, 1, 100, `{"a":1}`),
+		testMutation("doc_2", 2, 1, 200, `{"b":1}`), testMutation("doc_3", 3, 1, 300, `{"c":1}`)))
+	assert.Nil(writeCaptureFile(targetFileName, testMutation("doc_1", 7, 1, 100, `{"a":2}`),
+		testMutation("doc_2", 8, 2, 250, `{"b":2}`), testMutation("doc_3", 9, 1, 300, `{"c":1}`)))
+
+	differ := newTestFilesDiffer(sourceFileName, targetFileName)
+	srcDiffMap, _, _, _, err := differ.Diff()
+	assert.Nil(err)
+	assert.Equal(map[uint32][]string{0: {"doc_1", "doc_2"}}, srcDiffMap)
+	assert.Equal(DiffKeysMap{0: {"doc_1", "doc_2"}}, differ.MismatchCategories[base.MismatchCategoryBodyDiffers])
+	// Only doc_1 needs its body compared by the mutation differ, as the metadata of doc_2 tells it apart already
+	assert.Equal(map[uint32][]string{0: {"doc_1"}}, differ.BodyHashKeys)
+	fmt.Println("============== Test case end: TestBodyHashMismatch =================")
+}
+
+func TestTruncatedCaptureFile(t *testing.T) {
+	fmt.Println("============== Test case start: TestTruncatedCaptureFile =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "truncatedCapture")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "capture")
+
+	header := base.NewCaptureFileHeader()
+	first, err := captureRecords(header, testMutation("doc_1", 1, 1, 100, `{"a":1}`))
+	assert.Nil(err)
+	second, err := captureRecords(header, testMutation("doc_2", 2, 1, 200, `{"b":1}`))
+	assert.Nil(err)
+	data := append(append(header.Encode(), first...), second...)
+
+	load := func(data []byte) (*FileAttributes, error) {
+		assert.Nil(ioutil.WriteFile(fileName, data, 0644))
+		attr := NewFileAttribute(fileName)
+		attr.bucketUUID = testBucketUUID
+		return attr, attr.LoadFileIntoBuffer()
+	}
+	attr, err := load(data)
+	assert.Nil(err)
+	assert.Equal(2, attr.itemCount())
+	// A file that ends between two records cannot be told from one that holds fewer
+	attr, err = load(data[:len(data)-len(second)])
+	assert.Nil(err)
+	assert.Equal(1, attr.itemCount())
+
+	// Cut off within the key of the second record, and within its checksum
+	_, err = load(data[:len(data)-len(second)+5])
+	assert.True(errors.Is(err, base.ErrCaptureFileCorrupted))
+	_, err = load(data[:len(data)-2])
+	assert.True(errors.Is(err, base.ErrCaptureFileCorrupted))
+
+	// Records of files without a header have no checksums, and are cut off the same way
+	legacy := &dcp.Mutation{Key: []byte("doc_3"), Seqno: 3, OpCode: gomemcached.UPR_MUTATION, Value: []byte(`{}`)}
+	record, err := legacy.Serialize(base.NewLegacyCaptureFileHeader())
+	assert.Nil(err)
+	attr, err = load(record)
+	assert.Nil(err)
+	assert.Equal(1, attr.itemCount())
+	_, err = load(record[:len(record)-1])
+	assert.True(errors.Is(err, base.ErrCaptureFileCorrupted))
+	fmt.Println("============== Test case end: TestTruncatedCaptureFile =================")
+}
+
+func TestSyncRev(t *testing.T) {
+	fmt.Println("============== Test case start: TestSyncRev =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "syncRev")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	sourceFileName, targetFileName := filepath.Join(dir, "source"), filepath.Join(dir, "target")
+
+	// Both forms of rev that Sync Gateway writes, and one that cannot be parsed
+	var mutations []*dcp.Mutation
+	for i, syncXattr := range []string{`{"rev":"3-abc"}`, `{"rev":{"rev":"4-def","ver":"1@src"}}`, `{"rev":3}`} {
+		mutation, err := testSyncGatewayMutation(fmt.Sprintf("doc_%v", i+1), uint64(i+1), 1, 100, syncXattr)
+		assert.Nil(err)
+		mutations = append(mutations, mutation)
+	}
+	assert.Nil(writeCaptureFile(sourceFileName, append(mutations, testMutation("doc_4", 4, 1, 100, `{"a":1}`))...))
+	attr := NewFileAttribute(sourceFileName)
+	attr.bucketUUID = testBucketUUID
+	assert.Nil(attr.LoadFileIntoBuffer())
+	var syncRevs []string
+	for _, entry := range attr.sortedEntries[0] {
+		syncRevs = append(syncRevs, entry.SyncRev)
+	}
+	assert.Equal([]string{"3-abc", "4-def", "", ""}, syncRevs)
+
+	// doc_1 was imported on each cluster, so that its CAS differs though it is at the same rev. doc_2 has the same
+	// CAS on both, but is at different revs
+	source1, err := testSyncGatewayMutation("doc_1", 1, 3, 100, `{"rev":"3-abc"}`)
+	assert.Nil(err)
+	source2, err := testSyncGatewayMutation("doc_2", 2, 2, 200, `{"rev":"2-abc"}`)
+	assert.Nil(err)
+	target1, err := testSyncGatewayMutation("doc_1", 5, 4, 500, `{"rev":"3-abc"}`)
+	assert.Nil(err)
+	target2, err := testSyncGatewayMutation("doc_2", 6, 2, 200, `{"rev":"3-def"}`)
+	assert.Nil(err)
+	assert.Nil(writeCaptureFile(sourceFileName, source1, source2))
+	assert.Nil(writeCaptureFile(targetFileName, target1, target2))
+
+	differ := newTestFilesDiffer(sourceFileName, targetFileName)
+	srcDiffMap, _, _, _, err := differ.Diff()
+	assert.Nil(err)
+	assert.Equal(map[uint32][]string{0: {"doc_2"}}, srcDiffMap)
+	assert.Equal(DiffKeysMap{0: {"doc_2"}}, differ.MismatchCategories[base.MismatchCategoryMetadataDiffers])
+	fmt.Println("============== Test case end: TestSyncRev =================")
+}
+
+func TestDedupBySeqno(t *testing.T) {
+	fmt.Println("============== Test case start: TestDedupBySeqno =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "dedupBySeqno")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "capture")
+
+	// The mutations of doc_1 are not in seqno order, as when a stream is resumed and replays older mutations
+	otherCollection := dcp.CreateMutation(0, []byte("doc_1"), 4, 1, 150, 0, 0, gomemcached.UPR_MUTATION, []byte(`{"c":1}`),
+		base.JSONDataType, 8, &xdcrBase.XattrIterator{}, nil)
+	assert.Nil(writeCaptureFile(fileName, testMutation("doc_1", 2, 1, 100, `{"a":1}`), testMutation("doc_1", 5, 2, 300, `{"a":3}`),
+		testMutation("doc_2", 3, 1, 200, `{"b":1}`), testMutation("doc_1", 3, 1, 200, `{"a":2}`), otherCollection))
+
+	attr := NewFileAttribute(fileName)
+	attr.bucketUUID = testBucketUUID
+	assert.Nil(attr.LoadFileIntoBuffer())
+	assert.Len(attr.sortedEntries[0], 2)
+	assert.Equal("doc_1", attr.sortedEntries[0][0].Key)
+	assert.Equal(uint64(5), attr.sortedEntries[0][0].Seqno)
+	assert.Equal(uint64(300), attr.sortedEntries[0][0].CrMeta.GetDocumentMetadata().Cas)
+	assert.Equal("doc_2", attr.sortedEntries[0][1].Key)
+	// The same key in another collection is another document
+	assert.Len(attr.sortedEntries[8], 1)
+	assert.Equal(uint64(4), attr.sortedEntries[8][0].Seqno)
+	fmt.Println("============== Test case end: TestDedupBySeqno =================")
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"xdcrDiffer/stats"
+)
+
+// Marks every element of an array in a path, i.e. orders[].items
+const jsonPathAnyElement = "[]"
+
+// Pairs of bodies whose verdicts are kept. The cache is cleared once full, so that its memory stays bounded
+const jsonVerdictCacheSize = 1 << 16
+
+// Hashes of the bodies of a pair, in the order they were compared in
+type jsonVerdictKey struct {
+	hash1 [sha256.Size]byte
+	hash2 [sha256.Size]byte
+}
+
+// Bits of precision of the numbers compared within a tolerance, so that integers of up to 77 digits are exact where
+// float64 is only exact up to 2^53
+const jsonNumberPrecision = 256
+
+// Compares document bodies as JSON values rather than bytes, for data produced by nondeterministic writers.
+// Objects are equal regardless of the order of their fields. Arrays at the configured paths are compared as
+// multisets, and numbers are equal within the configured tolerances. A nil comparator compares bytes
+type JSONComparator struct {
+	// Paths of the arrays compared regardless of the order of their elements
+	unorderedPaths map[string]bool
+	absTolerance   float64
+	relTolerance   float64
+
+	// Verdicts of the pairs compared so far, as documents of repetitive datasets often share their bodies
+	cacheLock sync.Mutex
+	verdicts  map[jsonVerdictKey]bool
+	cacheSize int
+	cacheHits *stats.Counter
+}
+
+// Paths are dot separated field names from the root of the document, with [] standing for every element of an
+// array, i.e. "tags" or "orders[].items". Numbers are equal if they differ by at most absTolerance, or by at most
+// relTolerance times the larger of the two in magnitude
+func NewJSONComparator(unorderedPaths []string, absTolerance, relTolerance float64) (*JSONComparator, error) {
+	if absTolerance < 0 || relTolerance < 0 || math.IsNaN(absTolerance) || math.IsNaN(relTolerance) {
+		return nil, fmt.Errorf("tolerances cannot be negative")
+	}
+	comparator := &JSONComparator{
+		unorderedPaths: make(map[string]bool),
+		absTolerance:   absTolerance,
+		relTolerance:   relTolerance,
+		verdicts:       make(map[jsonVerdictKey]bool),
+		cacheSize:      jsonVerdictCacheSize,
+		cacheHits:      stats.Default.Counter(stats.MutationDiffJSONCacheHits),
+	}
+	for _, path := range unorderedPaths {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		comparator.unorderedPaths[path] = true
+	}
+	return comparator, nil
+}
+
+// Bodies that are not both valid JSON are compared as bytes
+func (c *JSONComparator) Equal(body1, body2 []byte) bool {
+	if bytes.Equal(body1, body2) {
+		return true
+	}
+	if c == nil {
+		return false
+	}
+	key := jsonVerdictKey{hash1: sha256.Sum256(body1), hash2: sha256.Sum256(body2)}
+	c.cacheLock.Lock()
+	equal, cached := c.verdicts[key]
+	c.cacheLock.Unlock()
+	if cached {
+		c.cacheHits.Add(1)
+		return equal
+	}
+
+	equal = c.bodiesEqual(body1, body2)
+	c.cacheLock.Lock()
+	if len(c.verdicts) >= c.cacheSize {
+		c.verdicts = make(map[jsonVerdictKey]bool)
+	}
+	c.verdicts[key] = equal
+	c.cacheLock.Unlock()
+	return equal
+}
+
+func (c *JSONComparator) bodiesEqual(body1, body2 []byte) bool {
+	value1, err1 := decodeJSONBody(body1)
+	value2, err2 := decodeJSONBody(body2)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return c.valuesEqual("", value1, value2)
+}
+
+func decodeJSONBody(body []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	// Numbers are kept as written, so that integers beyond the precision of a float64 are not rounded
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	return value, err
+}
+
+func (c *JSONComparator) valuesEqual(path string, value1, value2 interface{}) bool {
+	switch v1 := value1.(type) {
+	case map[string]interface{}:
+		v2, ok := value2.(map[string]interface{})
+		if !ok || len(v1) != len(v2) {
+			return false
+		}
+		for field, fieldValue1 := range v1 {
+			fieldValue2, exists := v2[field]
+			if !exists || !c.valuesEqual(joinJSONPath(path, field), fieldValue1, fieldValue2) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		v2, ok := value2.([]interface{})
+		if !ok || len(v1) != len(v2) {
+			return false
+		}
+		elementPath := path + jsonPathAnyElement
+		if c.unorderedPaths[path] {
+			return c.multisetsEqual(elementPath, v1, v2)
+		}
+		for i := range v1 {
+			if !c.valuesEqual(elementPath, v1[i], v2[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		v2, ok := value2.(json.Number)
+		if !ok {
+			return false
+		}
+		return c.numbersEqual(v1, v2)
+	default:
+		// Strings, booleans and null
+		return value1 == value2
+	}
+}
+
+// Each element has to be matched by a distinct element of the other array
+func (c *JSONComparator) multisetsEqual(elementPath string, elements1, elements2 []interface{}) bool {
+	matched := make([]bool, len(elements2))
+	for _, element1 := range elements1 {
+		found := false
+		for j, element2 := range elements2 {
+			if !matched[j] && c.valuesEqual(elementPath, element1, element2) {
+				matched[j] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Numbers of the same value are equal however they are written. Without tolerances, they have to be of exactly the
+// same value
+func (c *JSONComparator) numbersEqual(number1, number2 json.Number) bool {
+	if number1 == number2 {
+		return true
+	}
+	decimal1, ok1 := parseJSONDecimal(number1)
+	decimal2, ok2 := parseJSONDecimal(number2)
+	if !ok1 || !ok2 {
+		return false
+	}
+	if decimal1 == decimal2 {
+		return true
+	}
+	if c.absTolerance == 0 && c.relTolerance == 0 {
+		return false
+	}
+
+	float1, ok1 := new(big.Float).SetPrec(jsonNumberPrecision).SetString(string(number1))
+	float2, ok2 := new(big.Float).SetPrec(jsonNumberPrecision).SetString(string(number2))
+	if !ok1 || !ok2 {
+		return false
+	}
+	diff := new(big.Float).SetPrec(jsonNumberPrecision).Sub(float1, float2)
+	diff.Abs(diff)
+	if diff.Cmp(big.NewFloat(c.absTolerance)) <= 0 {
+		return true
+	}
+	magnitude := new(big.Float).Abs(float1)
+	if magnitude2 := new(big.Float).Abs(float2); magnitude2.Cmp(magnitude) > 0 {
+		magnitude = magnitude2
+	}
+	return diff.Cmp(magnitude.Mul(magnitude, big.NewFloat(c.relTolerance))) <= 0
+}
+
+// The value of a JSON number as its significant digits and the power of ten they are multiplied by, so that numbers
+// written differently, i.e. 1.50 and 15e-1, are of the same decimal
+type jsonDecimal struct {
+	negative bool
+	digits   string
+	exponent int
+}
+
+func parseJSONDecimal(number json.Number) (jsonDecimal, bool) {
+	var decimal jsonDecimal
+	mantissa := string(number)
+	if strings.HasPrefix(mantissa, "-") {
+		decimal.negative = true
+		mantissa = mantissa[1:]
+	}
+	if i := strings.IndexAny(mantissa, "eE"); i >= 0 {
+		exponent, err := strconv.Atoi(mantissa[i+1:])
+		if err != nil {
+			return jsonDecimal{}, false
+		}
+		decimal.exponent = exponent
+		mantissa = mantissa[:i]
+	}
+	if i := strings.IndexByte(mantissa, '.'); i >= 0 {
+		decimal.exponent -= len(mantissa) - i - 1
+		mantissa = mantissa[:i] + mantissa[i+1:]
+	}
+	if mantissa == "" || strings.TrimLeft(mantissa, "0123456789") != "" {
+		return jsonDecimal{}, false
+	}
+	mantissa = strings.TrimLeft(mantissa, "0")
+	if mantissa == "" {
+		// Zero, whatever its sign and exponent
+		return jsonDecimal{}, true
+	}
+	decimal.digits = strings.TrimRight(mantissa, "0")
+	decimal.exponent += len(mantissa) - len(decimal.digits)
+	return decimal, true
+}
+
+func joinJSONPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONComparator(t *testing.T) {
+	fmt.Println("============== Test case start: TestJSONComparator =================")
+	assert := assert.New(t)
+
+	var byteComparator *JSONComparator
+	assert.True(byteComparator.Equal([]byte(`{"a":1}`), []byte(`{"a":1}`)))
+	assert.False(byteComparator.Equal([]byte(`{"a":1,"b":2}`), []byte(`{"b":2,"a":1}`)))
+
+	comparator, err := NewJSONComparator([]string{"tags", "orders[].items"}, 0.001, 0)
+	assert.Nil(err)
+	// Field order never matters
+	assert.True(comparator.Equal([]byte(`{"a":1,"b":2}`), []byte(`{"b":2, "a":1}`)))
+	// Arrays at the configured paths are multisets
+	assert.True(comparator.Equal([]byte(`{"tags":["x","y","x"]}`), []byte(`{"tags":["x","x","y"]}`)))
+	assert.False(comparator.Equal([]byte(`{"tags":["x","y","y"]}`), []byte(`{"tags":["x","x","y"]}`)))
+	assert.True(comparator.Equal([]byte(`{"orders":[{"items":[1,2]},{"items":[3]}]}`), []byte(`{"orders":[{"items":[2,1]},{"items":[3]}]}`)))
+	// While other arrays are ordered
+	assert.False(comparator.Equal([]byte(`{"orders":[{"items":[1]},{"items":[3]}]}`), []byte(`{"orders":[{"items":[3]},{"items":[1]}]}`)))
+	assert.False(comparator.Equal([]byte(`{"other":[1,2]}`), []byte(`{"other":[2,1]}`)))
+	// Numbers within the absolute tolerance
+	assert.True(comparator.Equal([]byte(`{"price":9.9999}`), []byte(`{"price":10}`)))
+	assert.False(comparator.Equal([]byte(`{"price":9.99}`), []byte(`{"price":10}`)))
+	assert.False(comparator.Equal([]byte(`{"price":10}`), []byte(`{"price":"10"}`)))
+	// Bodies that are not JSON are compared as bytes
+	assert.False(comparator.Equal([]byte(`not json`), []byte(`not  json`)))
+
+	comparator, err = NewJSONComparator(nil, 0, 0.01)
+	assert.Nil(err)
+	assert.True(comparator.Equal([]byte(`[1000, 5]`), []byte(`[1009, 5]`)))
+	assert.False(comparator.Equal([]byte(`[1000, 5]`), []byte(`[1011, 5]`)))
+	assert.True(comparator.Equal([]byte(`{"id":12345678901234567890}`), []byte(`{"id":12345678901234567890}`)))
+
+	// Integers beyond 2^53 are compared exactly, with or without a tolerance
+	comparator, err = NewJSONComparator([]string{"tags"}, 0, 0)
+	assert.Nil(err)
+	assert.False(comparator.Equal([]byte(`{"id":9007199254740993}`), []byte(`{"id":9007199254740992}`)))
+	assert.False(comparator.Equal([]byte(`{"id":12345678901234567891}`), []byte(`{"id":12345678901234567890}`)))
+	assert.False(comparator.Equal([]byte(`{"price":0.1}`), []byte(`{"price":0.10000000000000001}`)))
+	// Numbers of the same value that are written differently
+	assert.True(comparator.Equal([]byte(`[1.50, 100, 0, -2.5e-3]`), []byte(`[15e-1, 1E+2, -0.0, -0.0025]`)))
+	assert.False(comparator.Equal([]byte(`[1.5]`), []byte(`[-1.5]`)))
+	comparator, err = NewJSONComparator(nil, 0.5, 0)
+	assert.Nil(err)
+	assert.False(comparator.Equal([]byte(`{"id":9007199254740993}`), []byte(`{"id":9007199254740992}`)))
+	assert.True(comparator.Equal([]byte(`{"id":9007199254740992.4}`), []byte(`{"id":9007199254740992}`)))
+
+	_, err = NewJSONComparator(nil, -1, 0)
+	assert.NotNil(err)
+
+	fmt.Println("============== Test case end: TestJSONComparator =================")
+}
+
+func TestJSONComparatorVerdictCache(t *testing.T) {
+	fmt.Println("============== Test case start: TestJSONComparatorVerdictCache =================")
+	assert := assert.New(t)
+
+	comparator, err := NewJSONComparator([]string{"tags"}, 0, 0)
+	assert.Nil(err)
+	comparator.cacheSize = 2
+	hits := comparator.cacheHits.Value()
+
+	source, target := []byte(`{"tags":["a","b"],"v":1}`), []byte(`{"v":1,"tags":["b","a"]}`)
+	assert.True(comparator.Equal(source, target))
+	assert.True(comparator.Equal(source, target))
+	assert.Equal(hits+1, comparator.cacheHits.Value())
+	// The same bodies the other way round are a pair of their own
+	assert.True(comparator.Equal(target, source))
+	assert.Equal(hits+1, comparator.cacheHits.Value())
+
+	// Differing verdicts are cached as well, and the cache is cleared once full
+	different := []byte(`{"tags":["a"],"v":1}`)
+	assert.False(comparator.Equal(source, different))
+	assert.Len(comparator.verdicts, 1)
+	assert.False(comparator.Equal(source, different))
+	assert.Equal(hits+2, comparator.cacheHits.Value())
+	fmt.Println("============== Test case end: TestJSONComparatorVerdictCache =================")
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"xdcrDiffer/base"
+
+	"github.com/couchbase/gocbcore/v10"
+)
+
+// Kinds of errors a key could not be verified because of
+const (
+	KeyErrorTypeAuth    = "auth"
+	KeyErrorTypeTimeout = "timeout"
+	// The connection the operations of the key were on dropped, every time they were replayed
+	KeyErrorTypeConnection = "connection"
+	// The vbucket or collection of the key was not where the agent expected it, i.e. during a rebalance
+	KeyErrorTypeVbucket = "vbucket"
+	// The key was fetched from both clusters, but the results could not be compared
+	KeyErrorTypeCompare = "compare"
+	// The document was locked. Keys that stay locked once fetched again are reported as base.LockedCategory instead
+	KeyErrorTypeLocked = "locked"
+	// The key was not sent, or not sent again, as the circuit breaker of a cluster was open or its retry budget spent
+	KeyErrorTypeCircuitOpen = "circuitOpen"
+	// The worker panicked while fetching or comparing the key. The stack is in the log
+	KeyErrorTypePanic = "panic"
+	// The key was not sent, as the read units of the Capella clusters had reached maxReadUnitSpend
+	KeyErrorTypeReadUnitBudget = "readUnitBudget"
+	KeyErrorTypeOther          = "other"
+)
+
+// Why a key could not be verified, as written to base.DiffErrorDetailsFileName next to the keys themselves
+type KeyError struct {
+	Key         string
+	KeyEncoding string `json:",omitempty"`
+	SrcColId    uint32
+	TgtColIds   []uint32
+	// The cluster the error is of, and the collection of the key on it. Empty when the error is not of either
+	// cluster, i.e. when the batch failed while the key had its responses
+	Cluster string `json:",omitempty"`
+	ColId   uint32
+	Type    string
+	Error   string
+	// How many times the batch of the key was sent
+	Attempts int
+}
+
+func classifyKeyError(err error) string {
+	switch {
+	case errors.Is(err, gocbcore.ErrAuthenticationFailure):
+		return KeyErrorTypeAuth
+	case errors.Is(err, gocbcore.ErrTimeout), errors.Is(err, errBatchTimedOut):
+		return KeyErrorTypeTimeout
+	case base.IsConnectionError(err):
+		return KeyErrorTypeConnection
+	case errors.Is(err, gocbcore.ErrNotMyVBucket), errors.Is(err, gocbcore.ErrCollectionNotFound),
+		errors.Is(err, gocbcore.ErrScopeNotFound):
+		return KeyErrorTypeVbucket
+	case isLockedError(err):
+		return KeyErrorTypeLocked
+	case errors.Is(err, base.ErrCircuitOpen), errors.Is(err, base.ErrRetryBudgetExhausted):
+		return KeyErrorTypeCircuitOpen
+	case errors.Is(err, errPanicked):
+		return KeyErrorTypePanic
+	case errors.Is(err, base.ErrReadUnitBudgetSpent):
+		return KeyErrorTypeReadUnitBudget
+	default:
+		return KeyErrorTypeOther
+	}
+}
+
+func newKeyError(key string, srcColId uint32, tgtColIds []uint32, keyErrorType string, err error, attempts int) *KeyError {
+	writtenKey, _ := base.EncodeKey(key)
+	return &KeyError{
+		Key:         writtenKey,
+		KeyEncoding: base.KeyEncodingOf(writtenKey),
+		SrcColId:    srcColId,
+		TgtColIds:   tgtColIds,
+		Type:        keyErrorType,
+		Error:       err.Error(),
+		Attempts:    attempts,
+	}
+}
+
+// Of a key of the batch, blamed on the given cluster and collection if any
+func newFetchKeyError(fetchItem *MutationDifferFetchEntry, cluster string, colId uint32, err error, attempts int) *KeyError {
+	keyError := newKeyError(fetchItem.Key, fetchItem.SrcColId, fetchItem.TgtColIds, classifyKeyError(err), err, attempts)
+	keyError.Cluster = cluster
+	keyError.ColId = colId
+	return keyError
+}
+
+// The error of a result, if any. A key that is not found is an answer rather than an error
+func (r *GetResult) fetchErr() error {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, err := range []error{r.dispatchErr, r.bodyErr, r.metaErr, r.hlvErr} {
+		if err != nil && !isKeyNotFoundError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *GetResult) responded() bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return !r.fetchedAt.IsZero()
+}
+
+// Why each key of a batch that failed with batchErr, after the given number of attempts, could not be verified.
+// A key is blamed on each cluster it had an error or no response from, and on the batch otherwise
+func (b *batch) keyErrors(batchErr error, attempts int) []*KeyError {
+	b.resultsLock.RLock()
+	defer b.resultsLock.RUnlock()
+	sideErr := func(result *GetResult) error {
+		if result == nil {
+			return nil
+		}
+		if err := result.fetchErr(); err != nil {
+			return err
+		}
+		if !result.responded() {
+			return fmt.Errorf("%w: no response within %v seconds", errBatchTimedOut, b.dw.differ.timeout)
+		}
+		return nil
+	}
+
+	var keyErrors []*KeyError
+	for _, fetchItem := range b.fetchList {
+		var blamed bool
+		if err := sideErr(b.sourceResults[fetchItem.SrcColId][fetchItem.Key]); err != nil {
+			keyErrors = append(keyErrors, newFetchKeyError(fetchItem, base.SourceClusterLabel, fetchItem.SrcColId, err, attempts))
+			blamed = true
+		}
+		for _, tgtColId := range fetchItem.TgtColIds {
+			if err := sideErr(b.targetResults[tgtColId][fetchItem.Key]); err != nil {
+				keyErrors = append(keyErrors, newFetchKeyError(fetchItem, base.TargetClusterLabel, tgtColId, err, attempts))
+				blamed = true
+			}
+		}
+		if !blamed {
+			keyErrors = append(keyErrors, newFetchKeyError(fetchItem, "", 0, batchErr, attempts))
+		}
+	}
+	return keyErrors
+}
+
+// Counts of the errors by cluster and type, i.e. "12 timeout on target, 3 auth on source"
+func summarizeKeyErrors(keyErrors []*KeyError) string {
+	counts := make(map[string]int)
+	for _, keyError := range keyErrors {
+		cluster := keyError.Cluster
+		if cluster == "" {
+			cluster = "batch"
+		}
+		counts[fmt.Sprintf("%v on %v", keyError.Type, cluster)]++
+	}
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	parts := make([]string, len(kinds))
+	for i, kind := range kinds {
+		parts[i] = fmt.Sprintf("%v %v", counts[kind], kind)
+	}
+	return strings.Join(parts, ", ")
+}
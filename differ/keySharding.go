@@ -0,0 +1,57 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"fmt"
+	"hash/crc32"
+	"xdcrDiffer/utils"
+)
+
+// How the keys to verify are split between the workers of the mutation differ
+type KeySharding string
+
+const (
+	// By the hash of each key, so that keys of a common prefix, i.e. documents that are hot together, are spread
+	// across the workers instead of all left to the one whose range they fall in
+	KeyShardingHash KeySharding = "hash"
+	// In contiguous ranges of the fetch list, which is in the order of the file differ output
+	KeyShardingRange KeySharding = "range"
+)
+
+func ParseKeySharding(value string) (KeySharding, error) {
+	switch sharding := KeySharding(value); sharding {
+	case KeyShardingHash, KeyShardingRange:
+		return sharding, nil
+	default:
+		return "", fmt.Errorf("Invalid key sharding %v. Accepted values are %v and %v", value, KeyShardingHash, KeyShardingRange)
+	}
+}
+
+// Splits the fetch list into one list per worker, some of which may be empty. All entries of a key go to the same
+// worker, whatever their collections
+func (s KeySharding) Shard(fetchList MutationDiffFetchList, numberOfWorkers int) []MutationDiffFetchList {
+	if numberOfWorkers < 1 {
+		panic(fmt.Sprintf("Coding Error: keys cannot be sharded between %v workers", numberOfWorkers))
+	}
+	shards := make([]MutationDiffFetchList, numberOfWorkers)
+	if s == KeyShardingRange {
+		loadDistribution := utils.BalanceLoad(numberOfWorkers, len(fetchList))
+		for i := range shards {
+			shards[i] = fetchList[loadDistribution[i][0]:loadDistribution[i][1]]
+		}
+		return shards
+	}
+	for _, entry := range fetchList {
+		shard := crc32.ChecksumIEEE([]byte(entry.Key)) % uint32(numberOfWorkers)
+		shards[shard] = append(shards[shard], entry)
+	}
+	return shards
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeySharding(t *testing.T) {
+	fmt.Println("============== Test case start: TestKeySharding =================")
+	assert := assert.New(t)
+
+	var fetchList MutationDiffFetchList
+	for i := 0; i < 1000; i++ {
+		fetchList = append(fetchList, &MutationDifferFetchEntry{Key: fmt.Sprintf("order_%v", i), TgtColIds: []uint32{0}})
+	}
+	// The same key in another collection
+	fetchList = append(fetchList, &MutationDifferFetchEntry{Key: "order_7", SrcColId: 8, TgtColIds: []uint32{9}})
+
+	for _, sharding := range []KeySharding{KeyShardingHash, KeyShardingRange} {
+		for _, numberOfWorkers := range []int{1, 3, 16, 2000} {
+			shards := sharding.Shard(fetchList, numberOfWorkers)
+			assert.Len(shards, numberOfWorkers)
+
+			// Every entry goes to exactly one shard
+			shardOfEntry := make(map[*MutationDifferFetchEntry]int)
+			for i, shard := range shards {
+				for _, entry := range shard {
+					_, exists := shardOfEntry[entry]
+					assert.False(exists, "%v entry %v in more than one shard", sharding, entry.Key)
+					shardOfEntry[entry] = i
+				}
+			}
+			assert.Len(shardOfEntry, len(fetchList))
+
+			// And to the same one every time
+			assert.Equal(shards, sharding.Shard(fetchList, numberOfWorkers))
+		}
+	}
+
+	// All entries of a key go to the same worker when sharded by hash
+	shards := KeyShardingHash.Shard(fetchList, 16)
+	for _, shard := range shards {
+		var order7 int
+		for _, entry := range shard {
+			if entry.Key == "order_7" {
+				order7++
+			}
+		}
+		assert.Contains([]int{0, 2}, order7)
+	}
+
+	for _, sharding := range []KeySharding{KeyShardingHash, KeyShardingRange} {
+		assert.Panics(func() { sharding.Shard(fetchList, 0) })
+	}
+	fmt.Println("============== Test case end: TestKeySharding =================")
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"xdcrDiffer/base"
+)
+
+// Size of the value of each key that differs, as captured, for the mutation differ to batch keys by bytes
+type DiffKeySizes map[string]uint32
+
+func (m DiffKeySizes) Merge(other DiffKeySizes) {
+	for key, size := range other {
+		if size > m[key] {
+			m[key] = size
+		}
+	}
+}
+
+func (m DiffKeySizes) encoded() DiffKeySizes {
+	encoded := make(DiffKeySizes, len(m))
+	for key, size := range m {
+		encodedKey, _ := base.EncodeKey(key)
+		encoded[encodedKey] = size
+	}
+	return encoded
+}
+
+func (m DiffKeySizes) decoded() (DiffKeySizes, error) {
+	decoded := make(DiffKeySizes, len(m))
+	for key, size := range m {
+		decodedKey, err := base.DecodeKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encoded key %v: %v", key, err)
+		}
+		decoded[decodedKey] = size
+	}
+	return decoded, nil
+}
+
+func (m DiffKeySizes) write(fileName string) error {
+	data, err := json.Marshal(m.encoded())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, data, base.FileModeReadWrite)
+}
+
+func readDiffKeySizes(fileName string) (DiffKeySizes, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(DiffKeySizes)
+	if err = json.Unmarshal(data, &sizes); err != nil {
+		return nil, fmt.Errorf("%v: %v", fileName, err)
+	}
+	return sizes.decoded()
+}
+
+// Size that keys without a size are taken to have, which is the average of those with one
+func (m DiffKeySizes) average() uint64 {
+	if len(m) == 0 {
+		return 0
+	}
+	var total uint64
+	for _, size := range m {
+		total += uint64(size)
+	}
+	return total / uint64(len(m))
+}
+
+// Where the batch of fetchList starting at start ends, once it holds maxKeys keys or, if maxBytes is set, the next key
+// would take the sizes of its keys past maxBytes. A batch holds at least one key, however large
+func batchEnd(fetchList MutationDiffFetchList, start, maxKeys int, maxBytes uint64, sizes DiffKeySizes, defaultSize uint64) int {
+	end := start + maxKeys
+	if end > len(fetchList) {
+		end = len(fetchList)
+	}
+	if maxBytes == 0 {
+		return end
+	}
+	var bytes uint64
+	for i := start; i < end; i++ {
+		size, exists := sizes[fetchList[i].Key]
+		keyBytes := defaultSize
+		if exists {
+			keyBytes = uint64(size)
+		}
+		if i > start && bytes+keyBytes > maxBytes {
+			return i
+		}
+		bytes += keyBytes
+	}
+	return end
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchEndByBytes(t *testing.T) {
+	fmt.Println("============== Test case start: TestBatchEndByBytes =================")
+	assert := assert.New(t)
+
+	var fetchList MutationDiffFetchList
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		fetchList = append(fetchList, &MutationDifferFetchEntry{Key: key})
+	}
+	sizes := DiffKeySizes{"a": 100, "b": 100, "c": 5000, "d": 100, "f": 100}
+	defaultSize := sizes.average()
+	assert.Equal(uint64(1080), defaultSize)
+
+	// By keys alone
+	assert.Equal(4, batchEnd(fetchList, 0, 4, 0, sizes, defaultSize))
+	assert.Equal(6, batchEnd(fetchList, 4, 4, 0, sizes, defaultSize))
+
+	var ends []int
+	for start := 0; start < len(fetchList); {
+		end := batchEnd(fetchList, start, 4, 1024, sizes, defaultSize)
+		ends = append(ends, end)
+		start = end
+	}
+	// c is larger than the budget on its own, and e is of the average size
+	assert.Equal([]int{2, 3, 4, 5, 6}, ends)
+	fmt.Println("============== Test case end: TestBatchEndByBytes =================")
+}
+
+func TestDiffKeySizesRoundTrip(t *testing.T) {
+	fmt.Println("============== Test case start: TestDiffKeySizesRoundTrip =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "diffKeySizes")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "diffKeySizes")
+
+	sizes := DiffKeySizes{"doc": 10}
+	// The larger size of a key is kept
+	sizes.Merge(DiffKeySizes{"doc": 5, "\xff\xfe": 20})
+	assert.Nil(sizes.write(fileName))
+	read, err := readDiffKeySizes(fileName)
+	assert.Nil(err)
+	assert.Equal(DiffKeySizes{"doc": 10, "\xff\xfe": 20}, read)
+
+	_, err = readDiffKeySizes(filepath.Join(dir, "missing"))
+	assert.True(os.IsNotExist(err))
+	fmt.Println("============== Test case end: TestDiffKeySizesRoundTrip =================")
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"errors"
+	"time"
+	"xdcrDiffer/base"
+
+	"github.com/couchbase/gocbcore/v10"
+)
+
+func isLockedError(err error) bool {
+	return err != nil && errors.Is(err, gocbcore.ErrDocumentLocked)
+}
+
+// Whether an operation of the result was refused as the document is locked. GetMeta does not take locks into
+// account, but GET and the subdoc lookups of the compared paths and the HLV do
+func (r *GetResult) locked() bool {
+	if r == nil {
+		return false
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, err := range []error{r.bodyErr, r.metaErr, r.hlvErr} {
+		if isLockedError(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// The keys of the batch that are locked on either cluster, but for the stragglers, which are fetched again anyway
+func (b *batch) lockedKeys(stragglers MutationDiffFetchList) MutationDiffFetchList {
+	b.resultsLock.RLock()
+	defer b.resultsLock.RUnlock()
+	straggling := make(map[*MutationDifferFetchEntry]bool, len(stragglers))
+	for _, fetchItem := range stragglers {
+		straggling[fetchItem] = true
+	}
+	var locked MutationDiffFetchList
+	for _, fetchItem := range b.fetchList {
+		if straggling[fetchItem] {
+			continue
+		}
+		isLocked := b.sourceResults[fetchItem.SrcColId][fetchItem.Key].locked()
+		for _, tgtColId := range fetchItem.TgtColIds {
+			isLocked = isLocked || b.targetResults[tgtColId][fetchItem.Key].locked()
+		}
+		if isLocked {
+			locked = append(locked, fetchItem)
+		}
+	}
+	return locked
+}
+
+// Keeps the locked keys of the batch aside, along with their results, to be fetched again once their lock expires
+func (dw *DifferWorker) setAsideLocked(b *batch, locked MutationDiffFetchList) {
+	b.resultsLock.RLock()
+	defer b.resultsLock.RUnlock()
+	for _, fetchItem := range locked {
+		results := []*GetResult{b.sourceResults[fetchItem.SrcColId][fetchItem.Key]}
+		for _, tgtColId := range fetchItem.TgtColIds {
+			results = append(results, b.targetResults[tgtColId][fetchItem.Key])
+		}
+		if _, exists := dw.lockedResults[fetchItem.SrcColId]; !exists {
+			dw.lockedResults[fetchItem.SrcColId] = make(map[string][]*GetResult)
+		}
+		dw.lockedResults[fetchItem.SrcColId][fetchItem.Key] = results
+		dw.locked = append(dw.locked, fetchItem)
+	}
+}
+
+// Fetches the locked keys again once their lock has expired. A lock lasts LockedKeyRetryWaitSecs unless the holder asked
+// for longer, up to the maximum the server allows, so keys still locked after LockedKeyRetries are reported as such
+func (dw *DifferWorker) retryLocked() {
+	for i := 0; len(dw.locked) > 0 && i < base.LockedKeyRetries; i++ {
+		dw.logger.Infof("Waiting %v seconds for the locks of %v keys to expire before fetching them again\n",
+			base.LockedKeyRetryWaitSecs, len(dw.locked))
+		time.Sleep(time.Duration(base.LockedKeyRetryWaitSecs) * time.Second)
+		fetchList := dw.locked
+		dw.locked = nil
+		dw.lockedResults = make(map[uint32]map[string][]*GetResult)
+		dw.fetch(fetchList)
+	}
+	if len(dw.locked) > 0 {
+		dw.logger.Warnf("%v keys stayed locked after %v retries, and are reported as %v\n", len(dw.locked),
+			base.LockedKeyRetries, base.LockedCategory)
+		dw.differ.addLocked(dw.lockedResults)
+	}
+}
@@ -11,17 +11,21 @@ package differ
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 	"xdcrDiffer/base"
+	"xdcrDiffer/results"
+	"xdcrDiffer/stats"
 	"xdcrDiffer/utils"
 
 	"github.com/couchbase/gocbcore/v10"
@@ -51,6 +55,11 @@ type MutationDiffer struct {
 	conflictRetries       int
 	retriesWaitSec        int
 
+	// Under the metadata compare type, the bodies of the keys the file differ found to differ by their bodies alone
+	// are compared as well, as their metadata matches. Source collection ID -> key
+	bodyHashKeysFileName string
+	bodyHashKeys         map[uint32]map[string]bool
+
 	sourceBucketAgent *GocbcoreAgent
 	targetBucketAgent *GocbcoreAgent
 
@@ -60,12 +69,34 @@ type MutationDiffer struct {
 	tgtDiff           map[uint32]map[string][]*GetResult
 	deletedFromSource map[uint32]map[string][]*GetResult
 	deletedFromTarget map[uint32]map[string][]*GetResult
+	// Documents that differ by TTL only, as the replication is configured to alter TTLs. They are not fetched again
+	expectedByConfiguration map[uint32]map[string][]*GetResult
+	// Keys of documents the replication logged a conflict of, and those of them that were found to differ
+	knownConflictKeys map[string]bool
+	knownConflicts    map[uint32]map[string][]*GetResult
+	// Documents that stayed locked on either cluster, so that they could not be compared
+	locked map[uint32]map[string][]*GetResult
+	// Documents missing from one cluster that may have purged their tombstone, by source collection ID
+	purgedKeys         map[uint32]map[string][]*GetResult
+	sourcePurgeHorizon time.Time
+	targetPurgeHorizon time.Time
 
 	keysWithError []*MutationDifferFetchEntry
-	stateLock     *sync.RWMutex
-
-	numKeysProcessed  uint32
-	numKeysWithErrors uint32
+	// Why keys could not be verified, be they of keysWithError or fetched but not comparable
+	keyErrors []*KeyError
+	stateLock *sync.RWMutex
+	// Keys whose operations exceeded their deadline, and how often
+	slowKeys *slowKeyTracker
+
+	numKeysProcessed  *stats.Counter
+	numKeysWithErrors *stats.Counter
+	// Keys fetched again as their connection dropped
+	numKeysReplayed *stats.Counter
+	// Keys that stayed locked
+	numKeysLocked *stats.Counter
+	batchLatency  *stats.Histogram
+	// Panics of the workers, each of which left the keys it was on unverified rather than ending the run
+	numPanics *stats.Counter
 
 	maxNumOfSendBatchRetry int
 	sendBatchRetryInterval time.Duration
@@ -91,6 +122,69 @@ type MutationDiffer struct {
 	srcKvVbMap      map[string][]uint16
 	tgtKvVbMap      map[string][]uint16
 	utils           xdcrUtils.UtilsIface
+
+	// If non-empty, only keys belonging to these vbuckets are verified
+	vbuckets map[uint16]bool
+	// If set, limits the number of batches in flight across all workers
+	tuner *ConcurrencyTuner
+	// Nil if the run cannot be paused
+	pauseGate *base.PauseGate
+	// If set, caps the batches in flight to a share of numberOfWorkers as the clusters come under stress
+	throttle *base.Throttle
+	// If set, operations stop being sent to a cluster, and retried on it, once it fails too many of them
+	sourceBreaker *base.CircuitBreaker
+	targetBreaker *base.CircuitBreaker
+	// How the keys are split between the workers
+	keySharding KeySharding
+	// If set, batches also end once the values of their keys add up to about this many bytes, as sized by keySizes.
+	// Keys without a size are taken to be of keySizeDefault bytes
+	batchBytes       uint64
+	keySizesFileName string
+	keySizes         DiffKeySizes
+	keySizeDefault   uint64
+	// If set, the output is also written chunked, with an index by key
+	chunkedDetails bool
+	// KV agents shared with the other phases of the run. Nil if the differ opens its own
+	agentPool *base.AgentPool
+
+	// If set, keys are read from here instead of the diff keys files of file differ
+	diffKeysSource string
+	queryKeysFunc  func(statement string) ([]string, error)
+	// Source collection of the keys a query returns
+	queryColId uint32
+	// If set, the keys of these repairs are verified instead, once repairSettle has passed
+	repairs      *RepairLog
+	repairSettle time.Duration
+
+	// If set, when and at what CAS both sides of every reported difference were fetched is recorded
+	auditEnabled bool
+	auditRefetch bool
+	auditTrail   AuditTrail
+
+	// If set, only these paths of document bodies are fetched and compared
+	comparePaths []string
+	// If set, bodies are compared as JSON values rather than bytes
+	jsonComparator *JSONComparator
+	// If set, documents with bodies of at least this many bytes that differ are reported by the chunks of their
+	// bodies that differ. See BodyChunks
+	bodyChunksThreshold    int
+	bodyChunksIncludeBytes bool
+
+	// If set, decides whether documents found to differ are equivalent after all
+	verdictFunc       VerdictFunc
+	verdicts          VerdictLog
+	numKeysEquivalent *stats.Counter
+
+	// If set, the scripts to reconcile the losing cluster to the winning one are written with the output
+	reconciliation *Reconciliation
+
+	// Bytes of the bodies and subdoc paths fetched from each cluster, and the read units of the operations
+	sourceBytesRead *stats.Counter
+	targetBytesRead *stats.Counter
+	sourceReadUnits *stats.Counter
+	targetReadUnits *stats.Counter
+	// If set, no batch is sent once the read units of the Capella clusters reach its limit
+	readUnitBudget *base.ReadUnitBudget
 }
 
 func (r *GetResult) MarshalJSON() ([]byte, error) {
@@ -98,13 +192,13 @@ func (r *GetResult) MarshalJSON() ([]byte, error) {
 
 	// GetMetaResult nil implies that the compareType is "body only"
 	if r.GetMetaResult == nil {
-		dataToBeEncoded[base.JsonBody] = r.value
+		r.encodeBody(dataToBeEncoded)
 		return json.Marshal(dataToBeEncoded)
 	}
 
 	// compareType can either be "meta only" or "both body and meta"
 	if r.value != nil { // indicates compareType is "both body and meta"
-		dataToBeEncoded[base.JsonBody] = r.value
+		r.encodeBody(dataToBeEncoded)
 	}
 
 	dataToBeEncoded[base.JsonMetadata] = r.GetMetaResult
@@ -119,49 +213,176 @@ func (r *GetResult) MarshalJSON() ([]byte, error) {
 	return json.Marshal(dataToBeEncoded)
 }
 
-func NewMutationDiffer(sourceBucketName string, sourceBucketUUID string, sourceRef *metadata.RemoteClusterReference, targetBucketName string, targetBucketUUID string, targetRef *metadata.RemoteClusterReference, fileDifferDir string, mutationDifferFileDir string, numberOfWorkers int, batchSize int, timeout int, maxNumOfSendBatchRetry int, sendBatchRetryInterval time.Duration, sendBatchMaxBackoff time.Duration, compareType string, logger *xdcrLog.CommonLogger, colIdsMap map[uint32][]uint32, srcCapability metadata.Capability, tgtCapability metadata.Capability, xdcrUtils xdcrUtils.UtilsIface, retries int, retriesWaitSecs int, duplMapping DuplicatedHintMap) *MutationDiffer {
+// The chunks of the body that differ in place of the body, if they were set
+func (r *GetResult) encodeBody(dataToBeEncoded map[string]interface{}) {
+	if r.bodyChunks != nil {
+		dataToBeEncoded[base.JsonBodyChunks] = r.bodyChunks
+		return
+	}
+	dataToBeEncoded[base.JsonBody] = r.value
+}
+
+func NewMutationDiffer(sourceBucketName string, sourceBucketUUID string, sourceRef *metadata.RemoteClusterReference, targetBucketName string, targetBucketUUID string, targetRef *metadata.RemoteClusterReference, fileDifferDir string, mutationDifferFileDir string, numberOfWorkers int, batchSize int, timeout int, maxNumOfSendBatchRetry int, sendBatchRetryInterval time.Duration, sendBatchMaxBackoff time.Duration, compareType string, logger *xdcrLog.CommonLogger, colIdsMap map[uint32][]uint32, srcCapability metadata.Capability, tgtCapability metadata.Capability, xdcrUtils xdcrUtils.UtilsIface, retries int, retriesWaitSecs int, duplMapping DuplicatedHintMap, vbuckets []uint16) *MutationDiffer {
 	// this indicates that mutation differ is expected to read srcDiff fetchList generated by file differ,
-	inputDiffKeysFileName := fileDifferDir + base.FileDirDelimiter + base.DiffKeysFileName
+	inputDiffKeysFileName := filepath.Join(fileDifferDir, base.DiffKeysFileName)
 	if len(colIdsMap) == 0 {
 		// legacy mode
 		colIdsMap = make(map[uint32][]uint32)
 		colIdsMap[0] = []uint32{0}
 	}
+	vbSet := make(map[uint16]bool)
+	for _, vbno := range vbuckets {
+		vbSet[vbno] = true
+	}
 	return &MutationDiffer{
-		sourceBucketName:       sourceBucketName,
-		sourceBucketUUID:       sourceBucketUUID,
-		sourceReference:        sourceRef,
-		targetBucketName:       targetBucketName,
-		targetBucketUUID:       targetBucketUUID,
-		targetReference:        targetRef,
-		inputDiffKeysFileName:  inputDiffKeysFileName,
-		mutationDifferFileDir:  mutationDifferFileDir,
-		numberOfWorkers:        numberOfWorkers,
-		batchSize:              batchSize,
-		timeout:                timeout,
-		missingFromSource:      make(map[uint32]map[string]*GetResult),
-		missingFromTarget:      make(map[uint32]map[string]*GetResult),
-		srcDiff:                make(map[uint32]map[string][]*GetResult),
-		tgtDiff:                make(map[uint32]map[string][]*GetResult),
-		deletedFromSource:      make(map[uint32]map[string][]*GetResult),
-		deletedFromTarget:      make(map[uint32]map[string][]*GetResult),
-		keysWithError:          MutationDiffFetchList{},
-		stateLock:              &sync.RWMutex{},
-		maxNumOfSendBatchRetry: maxNumOfSendBatchRetry,
-		sendBatchRetryInterval: sendBatchRetryInterval,
-		sendBatchMaxBackoff:    sendBatchMaxBackoff,
-		compareType:            compareType,
-		logger:                 logger,
-		colIdsMap:              colIdsMap,
-		reverseTgtColIdsMap:    compileReverseMap(colIdsMap),
-		srcDiffKeysFileName:    utils.DiffKeysFileName(true, fileDifferDir, base.DiffKeysFileName),
-		tgtDiffKeysFileName:    utils.DiffKeysFileName(false, fileDifferDir, base.DiffKeysFileName),
-		srcCapability:          srcCapability,
-		tgtCapability:          tgtCapability,
-		utils:                  xdcrUtils,
-		conflictRetries:        retries,
-		retriesWaitSec:         retriesWaitSecs,
-		duplicateMap:           duplMapping,
+		sourceBucketName:        sourceBucketName,
+		sourceBucketUUID:        sourceBucketUUID,
+		sourceReference:         sourceRef,
+		targetBucketName:        targetBucketName,
+		targetBucketUUID:        targetBucketUUID,
+		targetReference:         targetRef,
+		inputDiffKeysFileName:   inputDiffKeysFileName,
+		mutationDifferFileDir:   mutationDifferFileDir,
+		numberOfWorkers:         numberOfWorkers,
+		batchSize:               batchSize,
+		timeout:                 timeout,
+		missingFromSource:       make(map[uint32]map[string]*GetResult),
+		missingFromTarget:       make(map[uint32]map[string]*GetResult),
+		srcDiff:                 make(map[uint32]map[string][]*GetResult),
+		tgtDiff:                 make(map[uint32]map[string][]*GetResult),
+		deletedFromSource:       make(map[uint32]map[string][]*GetResult),
+		deletedFromTarget:       make(map[uint32]map[string][]*GetResult),
+		expectedByConfiguration: make(map[uint32]map[string][]*GetResult),
+		knownConflicts:          make(map[uint32]map[string][]*GetResult),
+		locked:                  make(map[uint32]map[string][]*GetResult),
+		purgedKeys:              make(map[uint32]map[string][]*GetResult),
+		keysWithError:           MutationDiffFetchList{},
+		keyErrors:               []*KeyError{},
+		slowKeys:                newSlowKeyTracker(),
+		stateLock:               &sync.RWMutex{},
+		maxNumOfSendBatchRetry:  maxNumOfSendBatchRetry,
+		sendBatchRetryInterval:  sendBatchRetryInterval,
+		sendBatchMaxBackoff:     sendBatchMaxBackoff,
+		compareType:             compareType,
+		logger:                  logger,
+		colIdsMap:               colIdsMap,
+		reverseTgtColIdsMap:     compileReverseMap(colIdsMap),
+		srcDiffKeysFileName:     utils.DiffKeysFileName(true, fileDifferDir, base.DiffKeysFileName),
+		tgtDiffKeysFileName:     utils.DiffKeysFileName(false, fileDifferDir, base.DiffKeysFileName),
+		keySizesFileName:        filepath.Join(fileDifferDir, base.DiffKeySizesFileName),
+		bodyHashKeysFileName:    filepath.Join(fileDifferDir, base.DiffKeysBodyHashFileName),
+		srcCapability:           srcCapability,
+		tgtCapability:           tgtCapability,
+		utils:                   xdcrUtils,
+		conflictRetries:         retries,
+		retriesWaitSec:          retriesWaitSecs,
+		duplicateMap:            duplMapping,
+		vbuckets:                vbSet,
+		numKeysProcessed:        stats.Default.Counter(stats.MutationDiffKeysDone),
+		numKeysWithErrors:       stats.Default.Counter(stats.MutationDiffKeysErrored),
+		numKeysReplayed:         stats.Default.Counter(stats.MutationDiffKeysReplayed),
+		numKeysLocked:           stats.Default.Counter(stats.MutationDiffKeysLocked),
+		batchLatency:            stats.Default.Histogram(stats.MutationDiffBatchLatency),
+		numPanics:               stats.Default.Counter(stats.MutationDiffPanics),
+		numKeysEquivalent:       stats.Default.Counter(stats.MutationDiffKeysEquivalent),
+		sourceBytesRead:         stats.Default.Counter(fmt.Sprintf(stats.ClusterBytesRead, base.SourceClusterLabel)),
+		targetBytesRead:         stats.Default.Counter(fmt.Sprintf(stats.ClusterBytesRead, base.TargetClusterLabel)),
+		sourceReadUnits:         stats.Default.Counter(fmt.Sprintf(stats.ClusterReadUnits, base.SourceClusterLabel)),
+		targetReadUnits:         stats.Default.Counter(fmt.Sprintf(stats.ClusterReadUnits, base.TargetClusterLabel)),
+		keySharding:             KeyShardingHash,
+	}
+}
+
+// Lets the number of batches in flight vary between 1 and numberOfWorkers, so that the share of
+// KV capacity used by the differ stays around targetImpact percent
+func (d *MutationDiffer) EnableAutoTune(targetImpact float64) {
+	d.tuner = NewConcurrencyTuner(runtime.NumCPU(), d.numberOfWorkers, targetImpact,
+		time.Duration(base.AutoTuneAdjustInterval)*time.Second, d.logger)
+}
+
+// Verifies the keys read from source instead of the output of file differ. See ReadDiffKeysSource
+func (d *MutationDiffer) SetDiffKeysSource(source string, queryKeysFunc func(statement string) ([]string, error), queryColId uint32) {
+	d.diffKeysSource = source
+	d.queryKeysFunc = queryKeysFunc
+	d.queryColId = queryColId
+}
+
+// Records when and at what CAS both sides of every reported difference were fetched. With refetch, flagged keys are
+// fetched once more at the end of the run, to corroborate that the difference is not due to a mutation in flight
+func (d *MutationDiffer) EnableAuditTrail(refetch bool) {
+	d.auditEnabled = true
+	d.auditRefetch = refetch
+	d.auditTrail = make(AuditTrail)
+}
+
+// Fetches and compares only the given sub-document paths of document bodies, instead of whole bodies
+func (d *MutationDiffer) SetComparePaths(paths []string) {
+	d.comparePaths = paths
+}
+
+// Compares bodies as JSON values, with the tolerances of the comparator, instead of byte for byte
+func (d *MutationDiffer) SetJSONComparator(comparator *JSONComparator) {
+	d.jsonComparator = comparator
+}
+
+// Documents found to differ are handed to verdictFunc, and not reported if it finds them equivalent
+func (d *MutationDiffer) SetVerdictFunc(verdictFunc VerdictFunc) {
+	d.verdictFunc = verdictFunc
+	d.verdicts = make(VerdictLog)
+}
+
+// Reuses the KV agents of the pool, i.e. those the DCP drivers opened to capture the same buckets
+func (d *MutationDiffer) SetAgentPool(agentPool *base.AgentPool) {
+	d.agentPool = agentPool
+}
+
+// Batches are not sent while the gate is paused
+func (d *MutationDiffer) SetPauseGate(pauseGate *base.PauseGate) {
+	d.pauseGate = pauseGate
+}
+
+func (d *MutationDiffer) SetThrottle(throttle *base.Throttle) {
+	d.throttle = throttle
+}
+
+// Either breaker may be nil, in which case the operations on its cluster are retried to exhaustion
+func (d *MutationDiffer) SetCircuitBreakers(source, target *base.CircuitBreaker) {
+	d.sourceBreaker = source
+	d.targetBreaker = target
+}
+
+func (d *MutationDiffer) SetReadUnitBudget(budget *base.ReadUnitBudget) {
+	d.readUnitBudget = budget
+}
+
+func (d *MutationDiffer) SetKeySharding(keySharding KeySharding) {
+	d.keySharding = keySharding
+}
+
+// Batches end at whichever comes first of batchSize keys and values adding up to batchBytes, as sized by the capture
+// files. Batches are sized by keys alone when the capture files carry no sizes
+func (d *MutationDiffer) SetBatchBytes(batchBytes uint64) {
+	d.batchBytes = batchBytes
+}
+
+// The output is also written in chunks compressed on their own, with an index of the chunk of every key, so that the
+// entries of a key can be read without reading the whole output. See results.ChunkedDetailsWriter
+func (d *MutationDiffer) EnableChunkedDetails() {
+	d.chunkedDetails = true
+}
+
+// Documents with bodies of at least threshold bytes that differ are reported by the chunks of their bodies that
+// differ, with the bytes of those chunks if includeBytes is set, rather than by their whole bodies
+func (d *MutationDiffer) SetBodyChunks(threshold int, includeBytes bool) {
+	d.bodyChunksThreshold = threshold
+	d.bodyChunksIncludeBytes = includeBytes
+}
+
+// Documents with the given keys that differ are reported as known conflicts rather than as mismatches
+func (d *MutationDiffer) SetKnownConflicts(keys []string) {
+	d.knownConflictKeys = make(map[string]bool, len(keys))
+	for _, key := range keys {
+		d.knownConflictKeys[key] = true
 	}
 }
 
@@ -171,6 +392,21 @@ func (d *MutationDiffer) Run() error {
 		return err
 	}
 	d.migrationHintMap = migrationHintMap
+	if d.batchBytes > 0 {
+		d.loadKeySizes()
+	}
+	if d.compareType == base.MutationCompareTypeMetadata && d.diffKeysSource == "" {
+		if err = d.loadBodyHashKeys(); err != nil {
+			return err
+		}
+	}
+
+	if len(d.vbuckets) > 0 {
+		var srcSkipped, tgtSkipped int
+		srcDiffKeys, srcSkipped = srcDiffKeys.FilterByVbuckets(d.vbuckets)
+		tgtDiffKeys, tgtSkipped = tgtDiffKeys.FilterByVbuckets(d.vbuckets)
+		d.logger.Infof("Skipping %v source and %v target diff keys that do not belong to the specified vbuckets\n", srcSkipped, tgtSkipped)
+	}
 
 	srcPovFetchList, srcPovFetchIdx := srcDiffKeys.ToFetchEntries(d.colIdsMap, migrationHintMap)
 	tgtPovFetchList, tgtPovFetchIdx := tgtDiffKeys.ToFetchEntries(d.reverseTgtColIdsMap, nil)
@@ -178,6 +414,11 @@ func (d *MutationDiffer) Run() error {
 
 	d.logger.Infof("Mutation srcDiff to work on %v srcPovFetchList with diffs.\n", len(combinedFetchList))
 
+	if d.repairs != nil {
+		d.logger.Infof("Waiting %v for the repairs to settle before verifying them...", d.repairSettle)
+		time.Sleep(d.repairSettle)
+	}
+
 	err = d.initialize()
 	if err != nil {
 		d.logger.Errorf("Error initializing: %v\n", err)
@@ -204,26 +445,100 @@ func (d *MutationDiffer) Run() error {
 		d.fetchAndDiff(combinedFetchList)
 	}
 
+	if d.repairs != nil {
+		if err = d.verifyRepairs(); err != nil {
+			d.logger.Errorf("Error writing repair verification. err=%v\n", err)
+		}
+	}
+
+	if d.auditRefetch && d.containsDiff() {
+		d.refetchFlagged()
+	}
+
 	return d.writeDiff()
 }
 
+// Fetches the keys with differences once more, without diffing them again, and records what was seen in the audit trail
+func (d *MutationDiffer) refetchFlagged() {
+	srcDiffKeys, tgtDiffKeys := d.getDiffKeysFromSourceGocbResult(), d.getDiffKeysFromTargetGocbResult()
+	srcPovFetchList, srcPovFetchIdx := srcDiffKeys.ToFetchEntries(d.colIdsMap, d.migrationHintMap)
+	tgtPovFetchList, tgtPovFetchIdx := tgtDiffKeys.ToFetchEntries(d.reverseTgtColIdsMap, nil)
+	fetchList := dedupFetchLists(srcPovFetchList, srcPovFetchIdx, tgtPovFetchList, tgtPovFetchIdx)
+	d.logger.Infof("Fetching %v flagged keys once more to corroborate the differences...", len(fetchList))
+
+	sourceResults, targetResults := d.fetchOnly(fetchList)
+
+	d.stateLock.Lock()
+	defer d.stateLock.Unlock()
+	var total, corroborated int
+	for _, auditsPerCol := range d.auditTrail {
+		for _, audits := range auditsPerCol {
+			for key, audit := range audits {
+				audit.refetched(sourceResults[audit.SrcColId][key], targetResults[audit.TgtColId][key])
+				total++
+				if audit.Refetch.Corroborated {
+					corroborated++
+				}
+			}
+		}
+	}
+	d.logger.Infof("%v out of %v differences were corroborated by fetching them again\n", corroborated, total)
+}
+
+func (d *MutationDiffer) fetchOnly(fetchList MutationDiffFetchList) (sourceResults, targetResults map[uint32]map[string]*GetResult) {
+	waitGroup := &sync.WaitGroup{}
+	var workers []*DifferWorker
+	for _, shard := range d.keySharding.Shard(fetchList, d.numberOfWorkers) {
+		if len(shard) == 0 {
+			continue
+		}
+		diffWorker := NewDifferWorker(d, d.sourceDcpAgent, d.targetDcpAgent, d.sourceBucketAgent, d.targetBucketAgent,
+			shard, waitGroup, d.colIdsMap, d.reverseTgtColIdsMap, d.migrationHintMap,
+			d.compareType, d.conflictRetries)
+		diffWorker.refetch = true
+		workers = append(workers, diffWorker)
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			diffWorker.getResults()
+		}()
+	}
+	waitGroup.Wait()
+
+	sourceResults = make(map[uint32]map[string]*GetResult)
+	targetResults = make(map[uint32]map[string]*GetResult)
+	for _, worker := range workers {
+		mergeResultMaps(sourceResults, worker.sourceResults)
+		mergeResultMaps(targetResults, worker.targetResults)
+	}
+	return
+}
+
+func mergeResultMaps(dst, src map[uint32]map[string]*GetResult) {
+	for colId, results := range src {
+		if _, exists := dst[colId]; !exists {
+			dst[colId] = make(map[string]*GetResult)
+		}
+		for key, result := range results {
+			dst[colId][key] = result
+		}
+	}
+}
+
 func (d *MutationDiffer) fetchAndDiff(combinedFetchList MutationDiffFetchList) {
 	// First clear the results that the differWorker will be working on
 	d.clearGoCbResults()
 	finCh := make(chan bool)
 
-	go d.reportStatus(len(combinedFetchList), finCh)
-	loadDistribution := utils.BalanceLoad(d.numberOfWorkers, len(combinedFetchList))
+	go d.reportStatus(len(combinedFetchList), d.numKeysProcessed.Value(), finCh)
 	waitGroup := &sync.WaitGroup{}
-	for i := 0; i < d.numberOfWorkers; i++ {
-		lowIndex := loadDistribution[i][0]
-		highIndex := loadDistribution[i][1]
-		if lowIndex == highIndex {
+	for _, shard := range d.keySharding.Shard(combinedFetchList, d.numberOfWorkers) {
+		if len(shard) == 0 {
 			// skip workers with 0 load
 			continue
 		}
 		diffWorker := NewDifferWorker(d, d.sourceDcpAgent, d.targetDcpAgent, d.sourceBucketAgent, d.targetBucketAgent,
-			combinedFetchList[lowIndex:highIndex], waitGroup, d.colIdsMap, d.reverseTgtColIdsMap, d.migrationHintMap,
+			shard, waitGroup, d.colIdsMap, d.reverseTgtColIdsMap, d.migrationHintMap,
 			d.compareType, d.conflictRetries)
 		waitGroup.Add(1)
 		go diffWorker.run()
@@ -322,18 +637,19 @@ func dedupFetchLists(srcPovList MutationDiffFetchList, srcIdx MutationDiffFetchL
 	return combinedFetchList
 }
 
-func (d *MutationDiffer) reportStatus(totalKeys int, finCh chan bool) {
+// Keys processed by earlier rounds, i.e. of retries, are given by startKeysProcessed and not reported
+func (d *MutationDiffer) reportStatus(totalKeys int, startKeysProcessed int64, finCh chan bool) {
 	ticker := time.NewTicker(time.Duration(base.StatsReportInterval) * time.Second)
 	defer ticker.Stop()
 
-	var prevNumKeysProcessed uint32 = math.MaxUint32
+	var prevNumKeysProcessed int64 = math.MaxInt64
 
 	for {
 		select {
 		case <-ticker.C:
-			numKeysProcessed := atomic.LoadUint32(&d.numKeysProcessed)
-			numKeysWithErrors := atomic.LoadUint32(&d.numKeysWithErrors)
-			if prevNumKeysProcessed != math.MaxUint32 {
+			numKeysProcessed := d.numKeysProcessed.Value() - startKeysProcessed
+			numKeysWithErrors := d.numKeysWithErrors.Value()
+			if prevNumKeysProcessed != math.MaxInt64 {
 				d.logger.Infof("%v Mutation differ processed %v fetchList out of %v fetchList. processing rate=%v key/sec\n", time.Now(), numKeysProcessed, totalKeys, (numKeysProcessed-prevNumKeysProcessed)/base.StatsReportInterval)
 			} else {
 				d.logger.Infof("%v Mutation differ processed %v fetchList out of %v fetchList.\n", time.Now(), numKeysProcessed, totalKeys)
@@ -342,7 +658,7 @@ func (d *MutationDiffer) reportStatus(totalKeys int, finCh chan bool) {
 			if numKeysWithErrors > 0 {
 				d.logger.Warnf("%v skipped %v fetchList because of errors\n", time.Now(), numKeysWithErrors)
 			}
-			if numKeysProcessed == uint32(totalKeys) {
+			if numKeysProcessed == int64(totalKeys) {
 				return
 			}
 			prevNumKeysProcessed = numKeysProcessed
@@ -372,20 +688,110 @@ func (d *MutationDiffer) writeDiff() error {
 	if err != nil {
 		d.logger.Errorf("Error writing migration details. err=%v\n", err)
 	}
+
+	err = d.writeSlowestKeys()
+	if err != nil {
+		d.logger.Errorf("Error writing slowest keys. err=%v\n", err)
+	}
+
+	if d.auditEnabled {
+		err = d.writeAuditTrail()
+		if err != nil {
+			d.logger.Errorf("Error writing audit trail. err=%v\n", err)
+		}
+	}
+
+	if d.verdictFunc != nil {
+		err = d.writeVerdicts()
+		if err != nil {
+			d.logger.Errorf("Error writing verdicts. err=%v\n", err)
+		}
+	}
+
+	if d.reconciliation != nil {
+		err = d.writeReconciliation()
+		if err != nil {
+			d.logger.Errorf("Error writing reconciliation. err=%v\n", err)
+		}
+	}
 	return err
 }
 
+func (d *MutationDiffer) writeVerdicts() error {
+	verdictBytes, err := json.Marshal(d.verdicts.encoded())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(d.mutationDifferFileDir, base.MutationDiffVerdictsFileName), verdictBytes, 0644)
+}
+
+// Every key that exceeded the deadline, the most often first
+func (d *MutationDiffer) writeSlowestKeys() error {
+	slowKeysBytes, err := json.Marshal(d.slowKeys.slowest(1))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(d.mutationDifferFileDir, base.MutationDiffSlowestKeysFileName), slowKeysBytes, 0644)
+}
+
+// Keys whose operations repeatedly exceeded the deadline, the most often first
+func (d *MutationDiffer) SlowestKeys() []*SlowKey {
+	return d.slowKeys.slowest(base.SlowKeyMinExceeded)
+}
+
+func (d *MutationDiffer) writeAuditTrail() error {
+	auditBytes, err := json.Marshal(d.auditTrail.encoded())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(d.mutationDifferFileDir, base.MutationDiffAuditFileName), auditBytes, 0644)
+}
+
 func (d *MutationDiffer) writeDiffDetails() error {
-	diffBytes, err := d.getDiffBytes()
+	fullFileName := filepath.Join(d.mutationDifferFileDir, base.MutationDiffFileName)
+	diffFile, err := os.OpenFile(fullFileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, base.FileModeReadWrite)
 	if err != nil {
 		return err
 	}
-	return d.writeDiffBytesToFile(diffBytes)
+	defer diffFile.Close()
+
+	var chunked *results.ChunkedDetailsWriter
+	if d.chunkedDetails {
+		detailsFile, err := os.OpenFile(filepath.Join(d.mutationDifferFileDir, base.MutationDiffChunkedDetailsFileName), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, base.FileModeReadWrite)
+		if err != nil {
+			return err
+		}
+		defer detailsFile.Close()
+		indexFile, err := os.OpenFile(filepath.Join(d.mutationDifferFileDir, base.MutationDiffChunkedIndexFileName), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, base.FileModeReadWrite)
+		if err != nil {
+			return err
+		}
+		defer indexFile.Close()
+		if chunked, err = results.NewChunkedDetailsWriter(detailsFile, indexFile, base.MutationDiffOutputChunkEntries); err != nil {
+			return err
+		}
+	}
+
+	quarantined, err := writeDiffOutput(diffFile, d.diffOutputCategories(), d.numberOfWorkers, chunked)
+	if chunked != nil {
+		if closeErr := chunked.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if len(quarantined) > 0 {
+		quarantineFileName := filepath.Join(d.mutationDifferFileDir, base.MutationDiffQuarantineFileName)
+		d.logger.Warnf("%v records could not be written to %v and are quarantined in %v\n", len(quarantined), fullFileName, quarantineFileName)
+		stats.Default.Counter(stats.MutationDiffQuarantined).Add(int64(len(quarantined)))
+		if quarantineErr := writeQuarantine(quarantineFileName, quarantined); quarantineErr != nil && err == nil {
+			err = quarantineErr
+		}
+	}
+	return err
 }
 
 func (d *MutationDiffer) writeCollectionMapping() error {
 	fileName := base.MutationDiffColIdMapping
-	srcMapFilename := d.mutationDifferFileDir + base.FileDirDelimiter + fileName
+	srcMapFilename := filepath.Join(d.mutationDifferFileDir, fileName)
 
 	srcMappingBytes, srcErr := json.Marshal(d.colIdsMap)
 	if srcErr != nil {
@@ -407,7 +813,7 @@ func (d *MutationDiffer) writeKeysWithError() error {
 		return err
 	}
 
-	keysWithErrorFileName := d.mutationDifferFileDir + base.FileDirDelimiter + base.DiffErrorKeysFileName
+	keysWithErrorFileName := filepath.Join(d.mutationDifferFileDir, base.DiffErrorKeysFileName)
 	keysWithErrorFile, err := os.OpenFile(keysWithErrorFileName, os.O_RDWR|os.O_CREATE, base.FileModeReadWrite)
 	if err != nil {
 		return err
@@ -416,40 +822,76 @@ func (d *MutationDiffer) writeKeysWithError() error {
 	defer keysWithErrorFile.Close()
 
 	_, err = keysWithErrorFile.Write(keysWithErrorBytes)
-	return err
-}
-
-func (d *MutationDiffer) getDiffBytes() ([]byte, error) {
-	outputMap := map[string]interface{}{
-		"Mismatch":          d.srcDiff,
-		"MissingFromSource": d.missingFromSource,
-		"MissingFromTarget": d.missingFromTarget,
-	}
-	if d.compareType == base.MutationCompareTypeMetadata || d.compareType == base.MutationCompareTypeBodyAndMeta {
-		outputMap["DeletedFromSource"] = d.deletedFromSource
-		outputMap["DeletedFromTarget"] = d.deletedFromTarget
+	if err != nil {
+		return err
 	}
-	return json.Marshal(outputMap)
+	return d.writeKeyErrors()
 }
 
-func (d *MutationDiffer) writeDiffBytesToFile(diffBytes []byte) error {
-	fileName := base.MutationDiffFileName
-	fullFileName := d.mutationDifferFileDir + base.FileDirDelimiter + fileName
-
-	diffFile, err := os.OpenFile(fullFileName, os.O_RDWR|os.O_CREATE, base.FileModeReadWrite)
+func (d *MutationDiffer) writeKeyErrors() error {
+	if len(d.keyErrors) > 0 {
+		d.logger.Warnf("Keys with errors: %v\n", summarizeKeyErrors(d.keyErrors))
+	}
+	keyErrorsBytes, err := json.Marshal(d.keyErrors)
 	if err != nil {
 		return err
 	}
+	return ioutil.WriteFile(filepath.Join(d.mutationDifferFileDir, base.DiffErrorDetailsFileName), keyErrorsBytes, base.FileModeReadWrite)
+}
 
-	defer diffFile.Close()
-
-	_, err = diffFile.Write(diffBytes)
-	return err
-
+func (d *MutationDiffer) diffOutputCategories() map[string]diffOutputCategory {
+	categories := map[string]diffOutputCategory{
+		"Mismatch":          newDiffOutputCategory(d.srcDiff),
+		"MissingFromSource": newMissingDiffOutputCategory(d.missingFromSource),
+		"MissingFromTarget": newMissingDiffOutputCategory(d.missingFromTarget),
+	}
+	if d.compareType == base.MutationCompareTypeMetadata || d.compareType == base.MutationCompareTypeBodyAndMeta {
+		categories["DeletedFromSource"] = newDiffOutputCategory(d.deletedFromSource)
+		categories["DeletedFromTarget"] = newDiffOutputCategory(d.deletedFromTarget)
+	}
+	if len(d.expectedByConfiguration) > 0 {
+		categories[base.ExpectedByConfigurationCategory] = newDiffOutputCategory(d.expectedByConfiguration)
+	}
+	if len(d.knownConflicts) > 0 {
+		categories[base.KnownConflictCategory] = newDiffOutputCategory(d.knownConflicts)
+	}
+	if len(d.locked) > 0 {
+		categories[base.LockedCategory] = newDiffOutputCategory(d.locked)
+	}
+	if len(d.purgedKeys) > 0 {
+		categories[base.PurgedCategory] = newDiffOutputCategory(d.purgedKeys)
+	}
+	return categories
 }
 
 func (d *MutationDiffer) loadDiffKeys() (DiffKeysMap, DiffKeysMap, MigrationHintMap, error) {
+	if d.repairs != nil {
+		return d.repairs.diffKeys(), make(DiffKeysMap), make(MigrationHintMap), nil
+	}
+	if d.diffKeysSource != "" {
+		// The keys are fetched from both clusters, so they only need to be given from the source's point of view
+		srcDiffKeys, validation, err := ReadDiffKeysSource(d.diffKeysSource, os.Stdin, d.queryKeysFunc, d.queryColId)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		validation.report(d.diffKeysSource, d.logger)
+		return srcDiffKeys, make(DiffKeysMap), make(MigrationHintMap), nil
+	}
+
 	srcDiffKeysBytes, err := ioutil.ReadFile(d.srcDiffKeysFileName)
+	if os.IsNotExist(err) {
+		if legacyDiffKeysBytes, legacyErr := ioutil.ReadFile(d.inputDiffKeysFileName); legacyErr == nil {
+			// Older versions of the file differ wrote a single file of the keys of the source. The keys are fetched
+			// from both clusters, so they suffice
+			d.logger.Infof("Reading keys to verify from %v, as written by an older version\n", d.inputDiffKeysFileName)
+			srcDiffKeys, validation, err := ParseDiffKeys(legacyDiffKeysBytes, DiffKeysFormatOfFile(d.inputDiffKeysFileName))
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("srcUnmarshal %v", err)
+			}
+			validation.report(d.inputDiffKeysFileName, d.logger)
+			return srcDiffKeys, make(DiffKeysMap), make(MigrationHintMap), nil
+		}
+	}
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -467,29 +909,87 @@ func (d *MutationDiffer) loadDiffKeys() (DiffKeysMap, DiffKeysMap, MigrationHint
 		migrationHintFound = true
 	}
 
-	srcDiffKeys := make(DiffKeysMap)
-	tgtDiffKeys := make(DiffKeysMap)
 	migrationHintMap := make(MigrationHintMap)
 
-	err = json.Unmarshal(srcDiffKeysBytes, &srcDiffKeys)
+	srcDiffKeys, srcValidation, err := ParseDiffKeys(srcDiffKeysBytes, DiffKeysFormatOfFile(d.srcDiffKeysFileName))
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("srcUnmarshal %v", err)
 	}
-	err = json.Unmarshal(tgtDiffKeyBytes, &tgtDiffKeys)
+	srcValidation.report(d.srcDiffKeysFileName, d.logger)
+	tgtDiffKeys, tgtValidation, err := ParseDiffKeys(tgtDiffKeyBytes, DiffKeysFormatOfFile(d.tgtDiffKeysFileName))
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("tgtUnmarshal %v", err)
 	}
+	tgtValidation.report(d.tgtDiffKeysFileName, d.logger)
 
 	if migrationHintFound {
 		err = json.Unmarshal(migrationHintBytes, &migrationHintMap)
 		if err != nil {
 			return nil, nil, nil, fmt.Errorf("hintUnmarshal %v", err)
 		}
+		migrationHintMap, err = migrationHintMap.decoded()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("hintDecode %v", err)
+		}
 	}
 
 	return srcDiffKeys, tgtDiffKeys, migrationHintMap, nil
 }
 
+func (d *MutationDiffer) loadKeySizes() {
+	if d.diffKeysSource != "" {
+		d.logger.Warnf("Keys read from %v have no sizes. Batches are sized by keys alone\n", d.diffKeysSource)
+		return
+	}
+	keySizes, err := readDiffKeySizes(d.keySizesFileName)
+	if os.IsNotExist(err) {
+		d.logger.Warnf("The capture files carry no sizes of the keys to verify. Batches are sized by keys alone\n")
+		return
+	} else if err != nil {
+		d.logger.Warnf("Unable to read the sizes of the keys to verify. Batches are sized by keys alone. err=%v\n", err)
+		return
+	}
+	d.keySizes = keySizes
+	d.keySizeDefault = keySizes.average()
+	d.logger.Infof("Batching by up to %v bytes, with %v keys sized and the others taken to be of %v bytes\n",
+		d.batchBytes, len(keySizes), d.keySizeDefault)
+}
+
+// Written by file differs of this version onwards. Without it, keys are compared by their metadata alone
+func (d *MutationDiffer) loadBodyHashKeys() error {
+	data, err := ioutil.ReadFile(d.bodyHashKeysFileName)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	bodyHashKeys, _, err := ParseDiffKeys(data, DiffKeysFormatJSON)
+	if err != nil {
+		return fmt.Errorf("Invalid %v: %v", d.bodyHashKeysFileName, err)
+	}
+	d.bodyHashKeys = make(map[uint32]map[string]bool)
+	var count int
+	for srcColId, keys := range bodyHashKeys {
+		d.bodyHashKeys[srcColId] = make(map[string]bool)
+		for _, key := range keys {
+			d.bodyHashKeys[srcColId][key] = true
+		}
+		count += len(d.bodyHashKeys[srcColId])
+	}
+	if count > 0 {
+		d.logger.Infof("Comparing the bodies of %v keys as well, which the file differ found to differ by their bodies alone\n", count)
+	}
+	return nil
+}
+
+// The compare type of a key, which includes the body for keys of bodyHashKeys
+func (d *MutationDiffer) compareTypeOf(srcColId uint32, key string) string {
+	if d.bodyHashKeys[srcColId][key] {
+		return base.MutationCompareTypeBodyAndMeta
+	}
+	return d.compareType
+}
+
 func (d *MutationDiffer) addDocDiff(missingFromSource, missingFromTarget map[uint32]map[string]*GetResult, srcDiff, tgtDiff, deletedFromSource, deletedFromTarget map[uint32]map[string][]*GetResult) {
 	d.stateLock.Lock()
 	defer d.stateLock.Unlock()
@@ -548,11 +1048,78 @@ func (d *MutationDiffer) addDocDiff(missingFromSource, missingFromTarget map[uin
 	}
 }
 
-func (d *MutationDiffer) addKeysWithError(keysWithError MutationDiffFetchList) {
+func (d *MutationDiffer) addAuditTrail(audit AuditTrail) {
+	if audit == nil {
+		return
+	}
+	d.stateLock.Lock()
+	defer d.stateLock.Unlock()
+	d.auditTrail.merge(audit)
+}
+
+func (d *MutationDiffer) addExpectedByConfiguration(expected map[uint32]map[string][]*GetResult) {
+	d.stateLock.Lock()
+	defer d.stateLock.Unlock()
+	for colId, expectedPerCol := range expected {
+		if _, exists := d.expectedByConfiguration[colId]; !exists {
+			d.expectedByConfiguration[colId] = make(map[string][]*GetResult)
+		}
+		for key, results := range expectedPerCol {
+			d.expectedByConfiguration[colId][key] = results
+		}
+	}
+}
+
+func (d *MutationDiffer) addKnownConflicts(knownConflicts map[uint32]map[string][]*GetResult) {
+	d.stateLock.Lock()
+	defer d.stateLock.Unlock()
+	for colId, knownConflictsPerCol := range knownConflicts {
+		if _, exists := d.knownConflicts[colId]; !exists {
+			d.knownConflicts[colId] = make(map[string][]*GetResult)
+		}
+		for key, results := range knownConflictsPerCol {
+			d.knownConflicts[colId][key] = results
+		}
+	}
+}
+
+func (d *MutationDiffer) addLocked(locked map[uint32]map[string][]*GetResult) {
+	d.stateLock.Lock()
+	defer d.stateLock.Unlock()
+	for colId, lockedPerCol := range locked {
+		if _, exists := d.locked[colId]; !exists {
+			d.locked[colId] = make(map[string][]*GetResult)
+		}
+		for key, results := range lockedPerCol {
+			d.locked[colId][key] = results
+			d.numKeysLocked.Add(1)
+		}
+	}
+}
+
+func (d *MutationDiffer) addVerdicts(verdicts VerdictLog) {
+	if verdicts == nil {
+		return
+	}
+	d.stateLock.Lock()
+	defer d.stateLock.Unlock()
+	d.verdicts.merge(verdicts)
+}
+
+func (d *MutationDiffer) addKeysWithError(keysWithError MutationDiffFetchList, keyErrors []*KeyError) {
 	d.stateLock.Lock()
 	defer d.stateLock.Unlock()
 	d.keysWithError = append(d.keysWithError, keysWithError...)
-	atomic.AddUint32(&d.numKeysWithErrors, uint32(len(keysWithError)))
+	d.keyErrors = append(d.keyErrors, keyErrors...)
+	d.numKeysWithErrors.Add(int64(len(keysWithError)))
+}
+
+// For keys that were fetched, but could not be compared
+func (d *MutationDiffer) addKeyError(keyError *KeyError) {
+	d.stateLock.Lock()
+	defer d.stateLock.Unlock()
+	d.keyErrors = append(d.keyErrors, keyError)
+	d.numKeysWithErrors.Add(1)
 }
 
 type DifferWorker struct {
@@ -572,6 +1139,11 @@ type DifferWorker struct {
 	migrationHintMap  MigrationHintMap
 	compareType       string
 	retries           int
+	// Set when flagged keys are only fetched again for the audit trail, and are neither diffed nor counted
+	refetch bool
+	// Keys found locked, to be fetched again once their lock expires, and their results
+	locked        MutationDiffFetchList
+	lockedResults map[uint32]map[string][]*GetResult
 }
 
 func NewDifferWorker(differ *MutationDiffer, sourceDCPAgent, targetDCPAgent *gocbcore.DCPAgent, sourceBucketAgent,
@@ -585,6 +1157,7 @@ func NewDifferWorker(differ *MutationDiffer, sourceDCPAgent, targetDCPAgent *goc
 		waitGroup:         waitGroup,
 		sourceResults:     make(map[uint32]map[string]*GetResult),
 		targetResults:     make(map[uint32]map[string]*GetResult),
+		lockedResults:     make(map[uint32]map[string][]*GetResult),
 		logger:            differ.logger,
 		sourceDcpAgent:    sourceDCPAgent,
 		targetDcpAgent:    targetDCPAgent,
@@ -621,60 +1194,164 @@ func compileReverseMap(srcToTgts map[uint32][]uint32) map[uint32][]uint32 {
 
 func (dw *DifferWorker) run() {
 	defer dw.waitGroup.Done()
+	defer dw.recoverWorker()
 	dw.getResults()
 	dw.diff()
 }
 
 func (dw *DifferWorker) getResults() {
-	index := 0
-	for {
-		if index >= len(dw.fetchList) {
-			break
-		}
-
-		if index+dw.differ.batchSize < len(dw.fetchList) {
-			dw.sendBatchWithRetry(index, index+dw.differ.batchSize)
-			index += dw.differ.batchSize
-			continue
-		}
-
-		dw.sendBatchWithRetry(index, len(dw.fetchList))
-		break
+	var batchBytes uint64
+	if dw.differ.keySizes != nil {
+		batchBytes = dw.differ.batchBytes
+	}
+	for index := 0; index < len(dw.fetchList); {
+		end := batchEnd(dw.fetchList, index, dw.differ.batchSize, batchBytes, dw.differ.keySizes, dw.differ.keySizeDefault)
+		dw.sendBatchWithRetry(index, end)
+		index = end
+	}
+	if !dw.refetch {
+		dw.retryLocked()
 	}
-
 }
 
 func (dw *DifferWorker) sendBatchWithRetry(startIndex, endIndex int) {
+	dw.fetch(dw.fetchList[startIndex:endIndex])
+	if !dw.refetch {
+		// fetchList with error are also counted toward keysProcessed
+		dw.differ.numKeysProcessed.Add(int64(endIndex - startIndex))
+	}
+}
+
+// Keys whose operations exceed their deadline are sent again on their own, while the results of the others stand.
+// Locked keys are set aside for retryLocked
+func (dw *DifferWorker) fetch(fetchList MutationDiffFetchList) {
+	batchKeys := len(fetchList)
+	// The last batch sent, whose results tell why the keys could not be fetched if every attempt fails
+	var lastBatch *batch
+	var attempts int
+	defer func(batchList MutationDiffFetchList) {
+		if r := recover(); r != nil {
+			err := dw.differ.recovered(r, fmt.Sprintf("fetching a batch of %v keys", batchKeys))
+			dw.panicked(batchList, err, attempts)
+			if !dw.refetch {
+				base.Progress.Batch(batchKeys, attempts, err)
+			}
+		}
+	}(fetchList)
+	// Why the keys are not sent again, once a cluster is failing
+	var breakerErr error
+	var breakerCluster string
 	sendBatchFunc := func() error {
-		batch := NewBatch(dw, startIndex, endIndex)
-		err := batch.send()
+		dw.differ.pauseGate.Wait(nil)
+		if breakerCluster, breakerErr = dw.differ.circuitOpen(); breakerErr != nil {
+			return nil
+		}
+		if lastBatch != nil {
+			if breakerCluster, breakerErr = dw.differ.allowRetry(lastBatch, len(fetchList)); breakerErr != nil {
+				return nil
+			}
+		}
+		batch := NewBatch(dw, fetchList)
+		lastBatch = batch
+		attempts++
+		err := dw.sendThrottled(batch)
+		dw.differ.recordOutcomes(batch)
 		if err != nil {
 			return err
 		}
-		dw.mergeResults(batch)
-		return nil
+		stragglers := batch.stragglers()
+		var locked MutationDiffFetchList
+		if !dw.refetch {
+			locked = batch.lockedKeys(stragglers)
+			dw.setAsideLocked(batch, locked)
+		}
+		dw.mergeResults(batch, append(locked, stragglers...))
+		if len(stragglers) == 0 {
+			return nil
+		}
+		dw.differ.slowKeys.exceeded(batch, stragglers)
+		if disconnected := batch.disconnected(stragglers); disconnected > 0 {
+			dw.logger.Warnf("Replaying the operations of %v keys whose connection dropped\n", disconnected)
+			dw.differ.numKeysReplayed.Add(int64(disconnected))
+		}
+		fetchList = stragglers
+		return fmt.Errorf("%v keys exceeded the deadline of %v seconds, could not be dispatched or lost their connection", len(stragglers), dw.differ.timeout)
 	}
 
 	opErr := utils.ExponentialBackoffExecutor("sendBatchWithRetry", dw.differ.sendBatchRetryInterval, dw.differ.maxNumOfSendBatchRetry,
 		base.SendBatchBackoffFactor, dw.differ.sendBatchMaxBackoff, sendBatchFunc)
+	if opErr == nil && breakerErr != nil {
+		opErr = breakerErr
+	}
+	if dw.refetch {
+		if opErr != nil {
+			dw.logger.Warnf("Unable to fetch %v flagged keys again because of err=%v.\n", len(fetchList), opErr)
+		}
+		return
+	}
 	if opErr != nil {
-		dw.logger.Warnf("Skipped check on %v fetchList because of err=%v.\n", endIndex-startIndex, opErr)
-		dw.differ.addKeysWithError(dw.fetchList[startIndex:endIndex])
+		// The breaker logs once as it opens, rather than for every batch that is not sent after
+		if breakerErr == nil || attempts > 0 {
+			dw.logger.Warnf("Skipped check on %v fetchList because of err=%v.\n", len(fetchList), opErr)
+		}
+		var keyErrors []*KeyError
+		if breakerErr != nil {
+			keyErrors = breakerKeyErrors(fetchList, breakerCluster, breakerErr, attempts)
+		} else if lastBatch != nil {
+			keyErrors = lastBatch.keyErrors(opErr, attempts)
+		}
+		dw.differ.addKeysWithError(fetchList, keyErrors)
 	}
-	// fetchList with error are also counted toward keysProcessed
-	atomic.AddUint32(&dw.differ.numKeysProcessed, uint32(endIndex-startIndex))
+	base.Progress.Batch(batchKeys, attempts, opErr)
+}
+
+// Sends the batch once the throttle lets it, which is released however the send ends
+func (dw *DifferWorker) sendThrottled(b *batch) error {
+	dw.differ.throttle.Acquire(dw.differ.numberOfWorkers)
+	defer dw.differ.throttle.Release()
+	if dw.differ.tuner != nil {
+		dw.differ.tuner.Acquire()
+	}
+	startTime := time.Now()
+	defer func() {
+		latency := time.Since(startTime)
+		dw.differ.batchLatency.Observe(latency.Milliseconds())
+		if dw.differ.tuner != nil {
+			dw.differ.tuner.Release(latency, len(b.fetchList))
+		}
+	}()
+	return b.send()
 }
 
-// merge results obtained by batch into dw
+// merge results obtained by batch into dw, but for those of the stragglers, which are to be fetched again
 // no need to lock results in dw since it is never accessed concurrently
 // need to lock results in batch since it could still be updated when mergeResults is called
-func (dw *DifferWorker) mergeResults(b *batch) {
+func (dw *DifferWorker) mergeResults(b *batch, stragglers MutationDiffFetchList) {
+	b.resultsLock.RLock()
+	defer b.resultsLock.RUnlock()
+	srcStraggling := make(map[uint32]map[string]bool)
+	tgtStraggling := make(map[uint32]map[string]bool)
+	for _, fetchItem := range stragglers {
+		if srcStraggling[fetchItem.SrcColId] == nil {
+			srcStraggling[fetchItem.SrcColId] = make(map[string]bool)
+		}
+		srcStraggling[fetchItem.SrcColId][fetchItem.Key] = true
+		for _, tgtColId := range fetchItem.TgtColIds {
+			if tgtStraggling[tgtColId] == nil {
+				tgtStraggling[tgtColId] = make(map[string]bool)
+			}
+			tgtStraggling[tgtColId][fetchItem.Key] = true
+		}
+	}
+
 	for colId, results := range b.sourceResults {
 		if _, exists := dw.sourceResults[colId]; !exists {
 			dw.sourceResults[colId] = make(map[string]*GetResult)
 		}
 		for key, result := range results {
-			dw.sourceResults[colId][key] = result
+			if !srcStraggling[colId][key] {
+				dw.sourceResults[colId][key] = result
+			}
 		}
 	}
 	for colId, results := range b.targetResults {
@@ -682,11 +1359,23 @@ func (dw *DifferWorker) mergeResults(b *batch) {
 			dw.targetResults[colId] = make(map[string]*GetResult)
 		}
 		for key, result := range results {
-			dw.targetResults[colId][key] = result
+			if !tgtStraggling[colId][key] {
+				dw.targetResults[colId][key] = result
+			}
 		}
 	}
 }
 
+// Documents above the threshold are reported by the chunks of their bodies that differ
+func (dw *DifferWorker) chunkBodies(sourceResult, targetResult *GetResult) {
+	threshold := dw.differ.bodyChunksThreshold
+	if threshold <= 0 || sourceResult.value == nil || targetResult.value == nil ||
+		(len(sourceResult.value) < threshold && len(targetResult.value) < threshold) {
+		return
+	}
+	setBodyChunks(sourceResult, targetResult, base.BodyChunkAverageSize, dw.differ.bodyChunksIncludeBytes)
+}
+
 func (dw *DifferWorker) diff() {
 	missingFromSource := make(map[uint32]map[string]*GetResult)
 	missingFromTarget := make(map[uint32]map[string]*GetResult)
@@ -694,6 +1383,18 @@ func (dw *DifferWorker) diff() {
 	tgtDiff := make(map[uint32]map[string][]*GetResult)
 	deletedFromSource := make(map[uint32]map[string][]*GetResult)
 	deletedFromTarget := make(map[uint32]map[string][]*GetResult)
+	expectedByConfiguration := make(map[uint32]map[string][]*GetResult)
+	knownConflicts := make(map[uint32]map[string][]*GetResult)
+	purged := make(map[uint32]map[string][]*GetResult)
+	ttlPolicy := replicationTTLPolicy.get()
+	var audit AuditTrail
+	if dw.differ.auditEnabled {
+		audit = make(AuditTrail)
+	}
+	var verdicts VerdictLog
+	if dw.differ.verdictFunc != nil {
+		verdicts = make(VerdictLog)
+	}
 
 	migrationMode := len(dw.migrationHintMap) > 0
 
@@ -730,76 +1431,136 @@ func (dw *DifferWorker) diff() {
 			}
 
 			for _, tgtColId := range tgtColIds {
-				var srcerr error
-				var tgterr error
-				targetResult := dw.targetResults[tgtColId][key]
-				if targetResult.key == "" {
-					continue
-				}
-				if bodyOnly {
-					srcerr = sourceResult.bodyErr
-					tgterr = targetResult.bodyErr
-				} else {
-					srcerr = sourceResult.metaErr
-					tgterr = targetResult.metaErr
-				}
-				if isKeyNotFoundError(srcerr) && !isKeyNotFoundError(tgterr) {
-					if _, exists := missingFromSource[srcColId]; !exists {
-						missingFromSource[srcColId] = make(map[string]*GetResult)
+				dw.isolate(key, srcColId, tgtColId, func() {
+					var srcerr error
+					var tgterr error
+					targetResult := dw.targetResults[tgtColId][key]
+					if targetResult == nil || targetResult.key == "" {
+						return
 					}
-					missingFromSource[srcColId][key] = targetResult
-					continue
-				}
-				if !isKeyNotFoundError(srcerr) && isKeyNotFoundError(tgterr) {
-					if _, exists := missingFromTarget[tgtColId]; !exists {
-						missingFromTarget[tgtColId] = make(map[string]*GetResult)
+					if bodyOnly {
+						srcerr = sourceResult.bodyErr
+						tgterr = targetResult.bodyErr
+					} else {
+						srcerr = sourceResult.metaErr
+						tgterr = targetResult.metaErr
 					}
-					missingFromTarget[tgtColId][key] = sourceResult
-					continue
-				}
-				if bodyOnly {
-					if !areGetResultsBodyTheSame(sourceResult, targetResult) {
-						if _, exists := srcDiff[srcColId]; !exists {
-							srcDiff[srcColId] = make(map[string][]*GetResult)
+					if (isKeyNotFoundError(srcerr) && !isKeyNotFoundError(tgterr) && dw.differ.purged(true, targetResult)) ||
+						(!isKeyNotFoundError(srcerr) && isKeyNotFoundError(tgterr) && dw.differ.purged(false, sourceResult)) {
+						if _, exists := purged[srcColId]; !exists {
+							purged[srcColId] = make(map[string][]*GetResult)
 						}
-						srcDiff[srcColId][key] = append(srcDiff[srcColId][key], []*GetResult{sourceResult, targetResult}...)
-						if _, exists := tgtDiff[tgtColId]; !exists {
-							tgtDiff[tgtColId] = make(map[string][]*GetResult)
+						purged[srcColId][key] = append(purged[srcColId][key], []*GetResult{sourceResult, targetResult}...)
+						audit.add(base.PurgedCategory, srcColId, key, newKeyAudit(srcColId, tgtColId, sourceResult, targetResult))
+						return
+					}
+					if isKeyNotFoundError(srcerr) && !isKeyNotFoundError(tgterr) {
+						if _, exists := missingFromSource[srcColId]; !exists {
+							missingFromSource[srcColId] = make(map[string]*GetResult)
 						}
-						tgtDiff[tgtColId][key] = append(tgtDiff[tgtColId][key], []*GetResult{targetResult, sourceResult}...)
+						missingFromSource[srcColId][key] = targetResult
+						audit.add("MissingFromSource", srcColId, key, newKeyAudit(srcColId, tgtColId, sourceResult, targetResult))
+						dw.differ.reconciliation.add(key, srcColId, tgtColId, sourceResult, targetResult, false, true)
+						return
 					}
-				} else {
-					metaSame, err := areGetResultsTheSame(sourceResult, targetResult, srcUUID, tgtUUID, includeBody)
-					if err != nil {
-						atomic.AddUint32(&dw.differ.numKeysWithErrors, 1)
-						dw.logger.Errorf(err.Error())
-						continue
+					if !isKeyNotFoundError(srcerr) && isKeyNotFoundError(tgterr) {
+						if _, exists := missingFromTarget[tgtColId]; !exists {
+							missingFromTarget[tgtColId] = make(map[string]*GetResult)
+						}
+						missingFromTarget[tgtColId][key] = sourceResult
+						audit.add("MissingFromTarget", tgtColId, key, newKeyAudit(srcColId, tgtColId, sourceResult, targetResult))
+						dw.differ.reconciliation.add(key, srcColId, tgtColId, sourceResult, targetResult, true, false)
+						return
 					}
-					if !metaSame {
-						if isDeleted(sourceResult.GetMetaResult) {
-							if _, exists := deletedFromSource[srcColId]; !exists {
-								deletedFromSource[srcColId] = make(map[string][]*GetResult)
+					if bodyOnly {
+						if !areGetResultsBodyTheSame(sourceResult, targetResult, dw.differ.jsonComparator) {
+							if dw.judge(srcColId, tgtColId, key, sourceResult, targetResult, verdicts) {
+								return
 							}
-							deletedFromSource[srcColId][key] = append(deletedFromSource[srcColId][key], []*GetResult{sourceResult, targetResult}...)
-							continue
-						}
-						if isDeleted(targetResult.GetMetaResult) {
-							if _, exists := deletedFromTarget[srcColId]; !exists {
-								deletedFromTarget[srcColId] = make(map[string][]*GetResult)
+							dw.chunkBodies(sourceResult, targetResult)
+							if dw.differ.knownConflictKeys[key] {
+								if _, exists := knownConflicts[srcColId]; !exists {
+									knownConflicts[srcColId] = make(map[string][]*GetResult)
+								}
+								knownConflicts[srcColId][key] = append(knownConflicts[srcColId][key], []*GetResult{sourceResult, targetResult}...)
+								audit.add(base.KnownConflictCategory, srcColId, key, newKeyAudit(srcColId, tgtColId, sourceResult, targetResult))
+								return
 							}
-							deletedFromTarget[srcColId][key] = append(deletedFromSource[srcColId][key], []*GetResult{sourceResult, targetResult}...)
-							continue
+							if _, exists := srcDiff[srcColId]; !exists {
+								srcDiff[srcColId] = make(map[string][]*GetResult)
+							}
+							srcDiff[srcColId][key] = append(srcDiff[srcColId][key], []*GetResult{sourceResult, targetResult}...)
+							if _, exists := tgtDiff[tgtColId]; !exists {
+								tgtDiff[tgtColId] = make(map[string][]*GetResult)
+							}
+							tgtDiff[tgtColId][key] = append(tgtDiff[tgtColId][key], []*GetResult{targetResult, sourceResult}...)
+							audit.add("Mismatch", srcColId, key, newKeyAudit(srcColId, tgtColId, sourceResult, targetResult))
+							dw.differ.reconciliation.add(key, srcColId, tgtColId, sourceResult, targetResult, true, true)
 						}
-						if _, exists := srcDiff[srcColId]; !exists {
-							srcDiff[srcColId] = make(map[string][]*GetResult)
+					} else {
+						includeBody := includeBody || dw.differ.compareTypeOf(srcColId, key) == base.MutationCompareTypeBodyAndMeta
+						metaSame, err := areGetResultsTheSame(sourceResult, targetResult, srcUUID, tgtUUID, includeBody, dw.differ.jsonComparator)
+						if err != nil {
+							dw.differ.addKeyError(newKeyError(key, srcColId, []uint32{tgtColId}, KeyErrorTypeCompare, err, 1))
+							dw.logger.Errorf("%v", err)
+							return
 						}
-						srcDiff[srcColId][key] = append(srcDiff[srcColId][key], []*GetResult{sourceResult, targetResult}...)
-						if _, exists := tgtDiff[tgtColId]; !exists {
-							tgtDiff[tgtColId] = make(map[string][]*GetResult)
+						if areGetResultsDifferentByExpiryOnly(sourceResult, targetResult, includeBody, dw.differ.jsonComparator) {
+							if ttlPolicy.Expected(sourceResult.Expiry, targetResult.Expiry) {
+								if _, exists := expectedByConfiguration[srcColId]; !exists {
+									expectedByConfiguration[srcColId] = make(map[string][]*GetResult)
+								}
+								expectedByConfiguration[srcColId][key] = append(expectedByConfiguration[srcColId][key], []*GetResult{sourceResult, targetResult}...)
+								audit.add(base.ExpectedByConfigurationCategory, srcColId, key, newKeyAudit(srcColId, tgtColId, sourceResult, targetResult))
+								return
+							}
+							// Expiry is not part of conflict resolution metadata, so the TTL is compared on its own
+							metaSame = false
+						}
+						if !metaSame {
+							if isDeleted(sourceResult.GetMetaResult) {
+								if _, exists := deletedFromSource[srcColId]; !exists {
+									deletedFromSource[srcColId] = make(map[string][]*GetResult)
+								}
+								deletedFromSource[srcColId][key] = append(deletedFromSource[srcColId][key], []*GetResult{sourceResult, targetResult}...)
+								audit.add("DeletedFromSource", srcColId, key, newKeyAudit(srcColId, tgtColId, sourceResult, targetResult))
+								dw.differ.reconciliation.add(key, srcColId, tgtColId, sourceResult, targetResult, false, true)
+								return
+							}
+							if isDeleted(targetResult.GetMetaResult) {
+								if _, exists := deletedFromTarget[srcColId]; !exists {
+									deletedFromTarget[srcColId] = make(map[string][]*GetResult)
+								}
+								deletedFromTarget[srcColId][key] = append(deletedFromSource[srcColId][key], []*GetResult{sourceResult, targetResult}...)
+								audit.add("DeletedFromTarget", srcColId, key, newKeyAudit(srcColId, tgtColId, sourceResult, targetResult))
+								dw.differ.reconciliation.add(key, srcColId, tgtColId, sourceResult, targetResult, true, false)
+								return
+							}
+							if dw.judge(srcColId, tgtColId, key, sourceResult, targetResult, verdicts) {
+								return
+							}
+							dw.chunkBodies(sourceResult, targetResult)
+							if dw.differ.knownConflictKeys[key] {
+								if _, exists := knownConflicts[srcColId]; !exists {
+									knownConflicts[srcColId] = make(map[string][]*GetResult)
+								}
+								knownConflicts[srcColId][key] = append(knownConflicts[srcColId][key], []*GetResult{sourceResult, targetResult}...)
+								audit.add(base.KnownConflictCategory, srcColId, key, newKeyAudit(srcColId, tgtColId, sourceResult, targetResult))
+								return
+							}
+							if _, exists := srcDiff[srcColId]; !exists {
+								srcDiff[srcColId] = make(map[string][]*GetResult)
+							}
+							srcDiff[srcColId][key] = append(srcDiff[srcColId][key], []*GetResult{sourceResult, targetResult}...)
+							if _, exists := tgtDiff[tgtColId]; !exists {
+								tgtDiff[tgtColId] = make(map[string][]*GetResult)
+							}
+							tgtDiff[tgtColId][key] = append(tgtDiff[tgtColId][key], []*GetResult{targetResult, sourceResult}...)
+							audit.add("Mismatch", srcColId, key, newKeyAudit(srcColId, tgtColId, sourceResult, targetResult))
+							dw.differ.reconciliation.add(key, srcColId, tgtColId, sourceResult, targetResult, true, true)
 						}
-						tgtDiff[tgtColId][key] = append(tgtDiff[tgtColId][key], []*GetResult{targetResult, sourceResult}...)
 					}
-				}
+				})
 			}
 		}
 	}
@@ -826,15 +1587,25 @@ func (dw *DifferWorker) diff() {
 					missingFromTarget[tgtColId] = make(map[string]*GetResult)
 				}
 				missingFromTarget[tgtColId][key] = targetResult
+				audit.add("MissingFromTarget", tgtColId, key, &KeyAudit{TgtColId: tgtColId, Target: observe(targetResult)})
 			}
 		}
 	}
 	dw.differ.addDocDiff(missingFromSource, missingFromTarget, srcDiff, tgtDiff, deletedFromSource, deletedFromTarget)
+	dw.differ.addExpectedByConfiguration(expectedByConfiguration)
+	dw.differ.addKnownConflicts(knownConflicts)
+	dw.differ.addPurged(purged)
+	dw.differ.addAuditTrail(audit)
+	dw.differ.addVerdicts(verdicts)
 }
 
+var errBatchTimedOut = errors.New("mutation differ batch timed out")
+
 type batch struct {
-	dw                *DifferWorker
-	fetchList         MutationDiffFetchList
+	dw        *DifferWorker
+	fetchList MutationDiffFetchList
+	// By which every operation of the batch is to have completed
+	deadline          time.Time
 	waitGroup         sync.WaitGroup
 	sourceResultCount uint32
 	targetResultCount uint32
@@ -843,10 +1614,10 @@ type batch struct {
 	resultsLock       sync.RWMutex
 }
 
-func NewBatch(dw *DifferWorker, startIndex, endIndex int) *batch {
+func NewBatch(dw *DifferWorker, fetchList MutationDiffFetchList) *batch {
 	b := &batch{
 		dw:            dw,
-		fetchList:     dw.fetchList[startIndex:endIndex],
+		fetchList:     fetchList,
 		sourceResults: make(map[uint32]map[string]*GetResult),
 		targetResults: make(map[uint32]map[string]*GetResult),
 	}
@@ -872,31 +1643,59 @@ func NewBatch(dw *DifferWorker, startIndex, endIndex int) *batch {
 // When data is in flight, the results may be different. If results are different
 // then try a few times to see if the same CAS are ever the same. If they are, then it means
 // this is not a diff
+// Each operation has a deadline of its own, past which the SDK fails it with a timeout. The batch only gives up
+// on the responses when they are overdue by base.MutationDiffDeadlineGraceSecs, as the SDK should have answered
 func (b *batch) send() error {
+	b.deadline = time.Now().Add(time.Duration(b.dw.differ.timeout) * time.Second)
 	for _, fetchItem := range b.fetchList {
-		b.get(fetchItem.Key, true, b.dw.differ.compareType, fetchItem.SrcColId)
+		compareType := b.dw.differ.compareTypeOf(fetchItem.SrcColId, fetchItem.Key)
+		b.get(fetchItem.Key, true, compareType, fetchItem.SrcColId)
 		for _, tgtId := range fetchItem.TgtColIds {
-			b.get(fetchItem.Key, false, b.dw.differ.compareType, tgtId)
+			b.get(fetchItem.Key, false, compareType, tgtId)
 		}
 	}
 
 	doneChan := make(chan bool, 1)
 	go utils.WaitForWaitGroup(&b.waitGroup, doneChan)
 
-	timer := time.NewTimer(time.Duration(b.dw.differ.timeout) * time.Second)
+	timer := time.NewTimer(time.Until(b.deadline) + time.Duration(base.MutationDiffDeadlineGraceSecs)*time.Second)
 	defer timer.Stop()
 	for {
 		select {
 		case <-doneChan:
 			return nil
 		case <-timer.C:
-			return fmt.Errorf("mutation differ batch timed out")
+			return errBatchTimedOut
 		}
 	}
 }
 
 func (b *batch) get(key string, isSource bool, compareType string, colId uint32) {
+	bytesRead, readUnits := b.dw.differ.targetBytesRead, b.dw.differ.targetReadUnits
+	if isSource {
+		bytesRead, readUnits = b.dw.differ.sourceBytesRead, b.dw.differ.sourceReadUnits
+	}
+	// A key that is not found is read as well, and billed as a unit
+	addReadUnits := func(size int, err error) {
+		if err == nil || isKeyNotFoundError(err) {
+			readUnits.Add(base.ReadUnits(size))
+		}
+	}
+	lookupInSize := func(result *gocbcore.LookupInResult) int {
+		var size int
+		if result != nil {
+			for _, op := range result.Ops {
+				size += len(op.Value)
+			}
+		}
+		return size
+	}
+	addLookupInBytes := func(result *gocbcore.LookupInResult) {
+		bytesRead.Add(int64(lookupInSize(result)))
+	}
+
 	getCallbackFunc := func(result *gocbcore.GetResult, err error) {
+		defer b.recoverCallback(key, isSource, colId)
 		b.resultsLock.RLock()
 		var resultsMap map[string]*GetResult
 		if isSource {
@@ -909,15 +1708,24 @@ func (b *batch) get(key string, isSource bool, compareType string, colId uint32)
 
 		getResult.lock.Lock()
 		defer getResult.lock.Unlock()
+		getResult.fetchedAt = time.Now()
+		var size int
+		if result != nil {
+			size = len(result.Value)
+		}
+		addReadUnits(size, err)
 		if err != nil {
 			getResult.bodyErr = err
 		} else {
 			getResult.value = result.Value
+			getResult.fetchCas = uint64(result.Cas)
+			bytesRead.Add(int64(len(result.Value)))
 		}
 		b.waitGroup.Done()
 	}
 
 	getMetaCallbackFunc := func(result *gocbcore.GetMetaResult, err error) {
+		defer b.recoverCallback(key, isSource, colId)
 		b.resultsLock.RLock()
 		var resultsMap map[string]*GetResult
 		if isSource {
@@ -933,10 +1741,45 @@ func (b *batch) get(key string, isSource bool, compareType string, colId uint32)
 
 		getResult.GetMetaResult = result
 		getResult.metaErr = err
+		getResult.fetchedAt = time.Now()
+		addReadUnits(0, err)
+		if result != nil {
+			getResult.fetchCas = uint64(result.Cas)
+		}
+		b.waitGroup.Done()
+	}
+
+	getPathsCallbackFunc := func(result *gocbcore.LookupInResult, err error) {
+		defer b.recoverCallback(key, isSource, colId)
+		b.resultsLock.RLock()
+		var resultsMap map[string]*GetResult
+		if isSource {
+			resultsMap = b.sourceResults[colId]
+		} else {
+			resultsMap = b.targetResults[colId]
+		}
+		getResult := resultsMap[key]
+		b.resultsLock.RUnlock()
+
+		getResult.lock.Lock()
+		defer getResult.lock.Unlock()
+		getResult.fetchedAt = time.Now()
+		addReadUnits(lookupInSize(result), err)
+		if err != nil {
+			getResult.bodyErr = err
+		} else {
+			addLookupInBytes(result)
+			getResult.value, getResult.bodyErr = pathsToValue(b.dw.differ.comparePaths, result)
+			if getResult.bodyErr != nil {
+				b.dw.logger.Warnf("Unable to read the compared paths of doc %v. err:%v\n", key, getResult.bodyErr)
+			}
+			getResult.fetchCas = uint64(result.Cas)
+		}
 		b.waitGroup.Done()
 	}
 
 	getHlvCallbackFunc := func(result *gocbcore.LookupInResult, err error) {
+		defer b.recoverCallback(key, isSource, colId)
 		b.resultsLock.RLock()
 		var resultsMap map[string]*GetResult
 		var bucketUUID string
@@ -950,9 +1793,14 @@ func (b *batch) get(key string, isSource bool, compareType string, colId uint32)
 		getResult := resultsMap[key]
 		b.resultsLock.RUnlock()
 
+		addReadUnits(lookupInSize(result), err)
 		if err != nil {
 			b.dw.logger.Debugf("Subdoc-get error occured for doc %v. err:%v\n", key, err)
+			getResult.lock.Lock()
+			defer getResult.lock.Unlock()
+			getResult.hlvErr = err
 		} else {
+			addLookupInBytes(result)
 			getResult.lock.Lock()
 			defer getResult.lock.Unlock()
 			getResult.hlvBytes, getResult.importCas, getResult.pRev, getResult.parsingErr = getHlvImportCas(bucketUUID, result)
@@ -969,44 +1817,85 @@ func (b *batch) get(key string, isSource bool, compareType string, colId uint32)
 	} else {
 		gocbAgent = b.dw.targetBucketAgent
 	}
+	// Only the compared paths of the body are fetched, if any
+	getBody := func() error {
+		if len(b.dw.differ.comparePaths) > 0 {
+			return gocbAgent.GetPaths(key, b.dw.differ.comparePaths, getPathsCallbackFunc, colId, b.deadline)
+		}
+		return gocbAgent.Get(key, getCallbackFunc, colId, b.deadline)
+	}
 	if compareType == base.MutationCompareTypeBodyOnly {
 		b.waitGroup.Add(1)
-		err = gocbAgent.Get(key, getCallbackFunc, colId)
+		err = getBody()
 		if err != nil {
 			b.dw.logger.Errorf("GetError for bucket %v on key %v. err: %v\n", gocbAgent.GocbcoreAgentCommon.BucketName, key, err)
+			b.dispatchFailed(key, isSource, colId, err)
 		}
 	} else if compareType == base.MutationCompareTypeMetadata {
 		b.waitGroup.Add(2)
-		err = gocbAgent.GetMeta(key, getMetaCallbackFunc, colId)
+		err = gocbAgent.GetMeta(key, getMetaCallbackFunc, colId, b.deadline)
 		if err != nil {
 			b.dw.logger.Errorf("GetMetaError for bucket %v on key %v. err: %v\n", gocbAgent.GocbcoreAgentCommon.BucketName, key, err)
+			b.dispatchFailed(key, isSource, colId, err)
 		}
-		err1 = gocbAgent.GetHlv(key, getHlvCallbackFunc, colId)
+		err1 = gocbAgent.GetHlv(key, getHlvCallbackFunc, colId, b.deadline)
 		if err1 != nil {
 			b.dw.logger.Errorf("GetHlvError for bucket %v on key %v. err: %v\n", gocbAgent.GocbcoreAgentCommon.BucketName, key, err1)
+			b.dispatchFailed(key, isSource, colId, err1)
 		}
 	} else if compareType == base.MutationCompareTypeBodyAndMeta {
 		b.waitGroup.Add(3)
-		err = gocbAgent.Get(key, getCallbackFunc, colId)
+		err = getBody()
 		if err != nil {
 			b.dw.logger.Errorf("GetError for bucket %v on key %v. err: %v\n", gocbAgent.GocbcoreAgentCommon.BucketName, key, err)
+			b.dispatchFailed(key, isSource, colId, err)
 		}
-		err1 = gocbAgent.GetMeta(key, getMetaCallbackFunc, colId)
+		err1 = gocbAgent.GetMeta(key, getMetaCallbackFunc, colId, b.deadline)
 		if err1 != nil {
 			b.dw.logger.Errorf("GetMetaError for bucket %v on key %v. err: %v\n", gocbAgent.GocbcoreAgentCommon.BucketName, key, err1)
+			b.dispatchFailed(key, isSource, colId, err1)
 		}
-		err2 = gocbAgent.GetHlv(key, getHlvCallbackFunc, colId)
+		err2 = gocbAgent.GetHlv(key, getHlvCallbackFunc, colId, b.deadline)
 		if err2 != nil {
 			b.dw.logger.Errorf("GetHlvError for bucket %v on key %v. err: %v\n", gocbAgent.GocbcoreAgentCommon.BucketName, key, err2)
+			b.dispatchFailed(key, isSource, colId, err2)
 		}
 	}
 }
 
+// The callback of an operation that could not be dispatched is never called, so the operation is done with here
+func (b *batch) dispatchFailed(key string, isSource bool, colId uint32, err error) {
+	defer b.waitGroup.Done()
+	b.resultsLock.RLock()
+	var getResult *GetResult
+	if isSource {
+		getResult = b.sourceResults[colId][key]
+	} else {
+		getResult = b.targetResults[colId][key]
+	}
+	b.resultsLock.RUnlock()
+
+	getResult.lock.Lock()
+	defer getResult.lock.Unlock()
+	getResult.dispatchErr = err
+}
+
 func isKeyNotFoundError(err error) bool {
 	return err != nil && strings.Contains(err.Error(), gocbcore.ErrDocumentNotFound.Error())
 }
 
-func areGetResultsBodyTheSame(result1, result2 *GetResult) bool {
+// Returns whether both results are of the same mutation but for their TTL, which XDCR may alter as it replicates
+func areGetResultsDifferentByExpiryOnly(result1, result2 *GetResult, includeBody bool, comparator *JSONComparator) bool {
+	if result1.GetMetaResult == nil || result2.GetMetaResult == nil || isDeleted(result1.GetMetaResult) || isDeleted(result2.GetMetaResult) {
+		return false
+	}
+	if includeBody && !areGetResultsBodyTheSame(result1, result2, comparator) {
+		return false
+	}
+	return result1.Expiry != result2.Expiry && result1.Cas == result2.Cas && result1.SeqNo == result2.SeqNo && result1.Flags == result2.Flags
+}
+
+func areGetResultsBodyTheSame(result1, result2 *GetResult, comparator *JSONComparator) bool {
 
 	if result1.value == nil {
 		return result2.value == nil
@@ -1015,18 +1904,18 @@ func areGetResultsBodyTheSame(result1, result2 *GetResult) bool {
 		return false
 	}
 
-	return reflect.DeepEqual(result1.value, result2.value)
+	return comparator.Equal(result1.value, result2.value)
 }
 
 // This function is used to docMeta for metadata comparison
 func NewDocMeta(result *GetResult) *xdcrBase.DocumentMetadata {
 	// This function is called if and only if both the documents on source and target are not deleted. So it is safe to hardcode the opcode to UPR_MUTATION.
 	// (crMeta.Diff() method compares the metadata only if the opcodes are equal to UPR_MUTATION).Hence setting it to UPR_MUTATION makes sure that metadata is compared.
-	return &xdcrBase.DocumentMetadata{Cas: uint64(result.Cas), RevSeq: uint64(result.SeqNo), Flags: result.Flags, Expiry: result.Expiry, DataType: result.Datatype, Opcode: gomemcached.UPR_MUTATION}
+	return &xdcrBase.DocumentMetadata{Cas: uint64(result.Cas), RevSeq: uint64(result.SeqNo), Flags: result.Flags, Expiry: result.Expiry, DataType: bucketCompressionPolicy.get().Datatype(result.Datatype), Opcode: gomemcached.UPR_MUTATION}
 
 }
 
-func areGetResultsTheSame(result1, result2 *GetResult, sourceUUID, targetUUID hlv.DocumentSourceId, includeBody bool) (bool, error) {
+func areGetResultsTheSame(result1, result2 *GetResult, sourceUUID, targetUUID hlv.DocumentSourceId, includeBody bool, comparator *JSONComparator) (bool, error) {
 	if result1.GetMetaResult == nil && result2.GetMetaResult == nil {
 		return true, nil
 	} else if result1.GetMetaResult == nil {
@@ -1091,7 +1980,7 @@ func areGetResultsTheSame(result1, result2 *GetResult, sourceUUID, targetUUID hl
 			}
 		}
 		if includeBody {
-			bodySame := areGetResultsBodyTheSame(result1, result2)
+			bodySame := areGetResultsBodyTheSame(result1, result2, comparator)
 			return (metaSame && bodySame), nil
 		}
 		return metaSame, nil
@@ -1150,7 +2039,15 @@ type GetResult struct {
 	*gocbcore.GetMetaResult
 	hlvBytes []byte
 	*hlv.HLV
-	lock sync.RWMutex
+	// Why the fetch could not be dispatched, in which case there is no response
+	dispatchErr error
+	hlvErr      error
+	// When the last response arrived, and the CAS it returned, for the audit trail
+	fetchedAt time.Time
+	fetchCas  uint64
+	// If set, the chunks of the body that the body it was compared to does not have, written in place of the body
+	bodyChunks *BodyChunks
+	lock       sync.RWMutex
 }
 
 func (d *MutationDiffer) initialize() error {
@@ -1245,13 +2142,30 @@ func (d *MutationDiffer) openBucket(bucketName string, reference *metadata.Remot
 		if !found {
 			return fmt.Errorf("Cannot find SSL port for %v in map %v", connStr, sslPortMap)
 		}
-		connStr = xdcrBase.GetHostAddr(xdcrBase.GetHostName(connStr), sslPort)
+		connStr = base.PortsOf(source).Translate(xdcrBase.GetHostAddr(xdcrBase.GetHostName(connStr), sslPort))
+		// The SDK only verifies the chain against the roots, so the policy is checked on every KV node here
+		for kvAddr, _ := range kvVbMap {
+			kvSSLPort, found := sslPortMap[kvAddr]
+			if !found {
+				return fmt.Errorf("Cannot find SSL port for %v in map %v", kvAddr, sslPortMap)
+			}
+			kvSSLAddr := base.PortsOf(source).Translate(xdcrBase.GetHostAddr(xdcrBase.GetHostName(kvAddr), kvSSLPort))
+			err = base.TLSVerification.Probe(kvSSLAddr, reference.Certificates(), time.Duration(base.SetupTimeoutSeconds)*time.Second)
+			if err != nil {
+				return err
+			}
+		}
 		base.TagCouchbaseSecurePrefix(&connStr)
 	} else {
-		connStr = fmt.Sprintf("%v%v", base.CouchbasePrefix, connStr)
+		connStr = fmt.Sprintf("%v%v", base.CouchbasePrefix, base.PortsOf(source).Translate(connStr))
 	}
 
-	agent, err := NewGocbcoreAgent(name, []string{connStr}, bucketName, auth, d.batchSize, capability, reference)
+	clusterName := base.SourceClusterLabel
+	if !source {
+		clusterName = base.TargetClusterLabel
+	}
+	base.SDKLogs.RegisterKvNodes(clusterName, kvVbMap, base.PortsOf(source))
+	agent, err := NewGocbcoreAgent(name, []string{base.PortsOf(source).TagAgentOptions(connStr)}, bucketName, auth, d.batchSize, capability, reference, clusterName, d.agentPool)
 
 	if source {
 		d.sourceBucketAgent = agent
@@ -1407,13 +2321,26 @@ func (d *MutationDiffer) clearGoCbResults() {
 	d.tgtDiff = make(map[uint32]map[string][]*GetResult)
 	d.deletedFromSource = make(map[uint32]map[string][]*GetResult)
 	d.deletedFromTarget = make(map[uint32]map[string][]*GetResult)
+	if d.auditEnabled {
+		d.auditTrail = make(AuditTrail)
+	}
+	d.reconciliation.clear()
+	// Documents found equivalent are not fetched again, so only the annotations of differences are cleared
+	if d.verdictFunc != nil {
+		delete(d.verdicts, VerdictCategoryMismatch)
+	}
 }
 
 func (d *MutationDiffer) writeMigrationDetails() error {
 	fileName := base.MutationDiffMigrationDetails
-	srcMapFilename := d.mutationDifferFileDir + base.FileDirDelimiter + fileName
+	srcMapFilename := filepath.Join(d.mutationDifferFileDir, fileName)
 
-	bytes, err := json.Marshal(d.duplicateMap.ToIntMap())
+	duplicates := make(map[string][]int)
+	for key, filterIds := range d.duplicateMap.ToIntMap() {
+		encodedKey, _ := base.EncodeKey(key)
+		duplicates[encodedKey] = filterIds
+	}
+	bytes, err := json.Marshal(duplicates)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,98 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// The keys a worker was fetching or comparing when it panicked are reported with errors wrapping this one, as
+// KeyErrorTypePanic
+var errPanicked = errors.New("mutation differ worker panicked")
+
+// Logs the panic along with the stack it was recovered on, for the log to tell where it came from
+func (d *MutationDiffer) recovered(r interface{}, doing string) error {
+	d.numPanics.Add(1)
+	d.logger.Errorf("Recovered from a panic while %v: %v\n%s", doing, r, debug.Stack())
+	return fmt.Errorf("%w while %v: %v", errPanicked, doing, r)
+}
+
+// Compares a key on its own, so that a panic leaves the key unverified rather than ending the run
+func (dw *DifferWorker) isolate(key string, srcColId, tgtColId uint32, compare func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := dw.differ.recovered(r, fmt.Sprintf("comparing key %v", key))
+			fetchItem := &MutationDifferFetchEntry{SrcColId: srcColId, TgtColIds: []uint32{tgtColId}, Key: key}
+			dw.differ.addKeysWithError(MutationDiffFetchList{fetchItem},
+				[]*KeyError{newKeyError(key, srcColId, fetchItem.TgtColIds, KeyErrorTypePanic, err, 1)})
+		}
+	}()
+	compare()
+}
+
+// Fails the operation whose callback panicked with the panic, rather than leaving the batch waiting on it. The keys of
+// such operations are sent again like those that could not be dispatched
+func (b *batch) recoverCallback(key string, isSource bool, colId uint32) {
+	if r := recover(); r != nil {
+		b.dispatchFailed(key, isSource, colId, b.dw.differ.recovered(r, fmt.Sprintf("handling a response of key %v", key)))
+	}
+}
+
+// For a worker that panicked outside of a batch or a key, the keys that were fetched but not diffed go unverified.
+// Their differences are only added to the differ once all of them are diffed, so none of them were
+func (dw *DifferWorker) recoverWorker() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	err := dw.differ.recovered(r, fmt.Sprintf("running a worker of %v keys", len(dw.fetchList)))
+	var fetched MutationDiffFetchList
+	for _, fetchItem := range dw.fetchList {
+		if dw.sourceResults[fetchItem.SrcColId][fetchItem.Key] != nil {
+			fetched = append(fetched, fetchItem)
+		}
+	}
+	dw.panicked(fetched, err, 1)
+}
+
+// Reports the keys as not verified because of the panic, and drops whatever results they had, so that they are not
+// diffed on top of it
+func (dw *DifferWorker) panicked(fetchList MutationDiffFetchList, err error, attempts int) {
+	if len(fetchList) == 0 {
+		return
+	}
+	forgotten := make(map[*MutationDifferFetchEntry]bool)
+	for _, fetchItem := range fetchList {
+		forgotten[fetchItem] = true
+		delete(dw.sourceResults[fetchItem.SrcColId], fetchItem.Key)
+		for _, tgtColId := range fetchItem.TgtColIds {
+			delete(dw.targetResults[tgtColId], fetchItem.Key)
+		}
+		delete(dw.lockedResults[fetchItem.SrcColId], fetchItem.Key)
+	}
+	var locked MutationDiffFetchList
+	for _, fetchItem := range dw.locked {
+		if !forgotten[fetchItem] {
+			locked = append(locked, fetchItem)
+		}
+	}
+	dw.locked = locked
+	if dw.refetch {
+		return
+	}
+
+	keyErrors := make([]*KeyError, 0, len(fetchList))
+	for _, fetchItem := range fetchList {
+		keyErrors = append(keyErrors, newFetchKeyError(fetchItem, "", 0, err, attempts))
+	}
+	dw.differ.addKeysWithError(fetchList, keyErrors)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"xdcrDiffer/stats"
+
+	xdcrLog "github.com/couchbase/goxdcr/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPanicIsolation(t *testing.T) {
+	fmt.Println("============== Test case start: TestPanicIsolation =================")
+	assert := assert.New(t)
+
+	differ := &MutationDiffer{
+		logger:            xdcrLog.NewLogger("TestPanicIsolation", xdcrLog.DefaultLoggerContext),
+		stateLock:         &sync.RWMutex{},
+		numKeysWithErrors: &stats.Counter{},
+		numPanics:         &stats.Counter{},
+	}
+	fetched := &MutationDifferFetchEntry{SrcColId: 8, TgtColIds: []uint32{9}, Key: "doc_1"}
+	failed := &MutationDifferFetchEntry{SrcColId: 8, TgtColIds: []uint32{9}, Key: "doc_2"}
+	dw := &DifferWorker{
+		differ:        differ,
+		fetchList:     MutationDiffFetchList{fetched, failed},
+		sourceResults: map[uint32]map[string]*GetResult{8: {"doc_1": &GetResult{key: "doc_1"}}},
+		targetResults: map[uint32]map[string]*GetResult{9: {"doc_1": &GetResult{key: "doc_1"}}},
+		lockedResults: make(map[uint32]map[string][]*GetResult),
+	}
+
+	// A key whose comparison panics is reported on its own
+	dw.isolate("doc_3", 8, 9, func() { panic("comparison bug") })
+	dw.isolate("doc_4", 8, 9, func() {})
+	assert.Len(differ.keysWithError, 1)
+	assert.Len(differ.keyErrors, 1)
+	assert.Equal("doc_3", differ.keyErrors[0].Key)
+	assert.Equal(KeyErrorTypePanic, differ.keyErrors[0].Type)
+	assert.Contains(differ.keyErrors[0].Error, "comparison bug")
+
+	// A worker that panics otherwise reports the keys it fetched but had yet to diff, and nothing is left to diff
+	func() {
+		defer dw.recoverWorker()
+		panic("worker bug")
+	}()
+	assert.Len(differ.keysWithError, 2)
+	assert.Equal(fetched, differ.keysWithError[1])
+	assert.Equal(KeyErrorTypePanic, differ.keyErrors[1].Type)
+	assert.Len(dw.sourceResults[8], 0)
+	assert.Len(dw.targetResults[9], 0)
+	assert.Equal(int64(2), differ.numPanics.Value())
+	assert.Equal(int64(2), differ.numKeysWithErrors.Value())
+	fmt.Println("============== Test case end: TestPanicIsolation =================")
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import "time"
+
+// Documents deleted before the horizon of a cluster may have had their tombstones purged from it, so that a tombstone
+// of one on the other cluster tells nothing of whether the deletion was replicated. A zero horizon is not known
+func (d *MutationDiffer) SetPurgeHorizons(sourceHorizon, targetHorizon time.Time) {
+	d.sourcePurgeHorizon = sourceHorizon
+	d.targetPurgeHorizon = targetHorizon
+}
+
+// Whether the document missing from a cluster is a tombstone on the other that the cluster may have purged. GetMeta
+// returns the time of the deletion of a tombstone as its expiry
+func (d *MutationDiffer) purged(fromSource bool, other *GetResult) bool {
+	horizon := d.targetPurgeHorizon
+	if fromSource {
+		horizon = d.sourcePurgeHorizon
+	}
+	if horizon.IsZero() || other == nil || !isDeleted(other.GetMetaResult) || other.Expiry == 0 {
+		return false
+	}
+	return time.Unix(int64(other.Expiry), 0).Before(horizon)
+}
+
+func (d *MutationDiffer) addPurged(purged map[uint32]map[string][]*GetResult) {
+	d.stateLock.Lock()
+	defer d.stateLock.Unlock()
+	for colId, purgedPerCol := range purged {
+		if _, exists := d.purgedKeys[colId]; !exists {
+			d.purgedKeys[colId] = make(map[string][]*GetResult)
+		}
+		for key, results := range purgedPerCol {
+			d.purgedKeys[colId][key] = results
+		}
+	}
+}
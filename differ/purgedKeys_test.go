@@ -0,0 +1,44 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocbcore/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPurged(t *testing.T) {
+	fmt.Println("============== Test case start: TestPurged =================")
+	assert := assert.New(t)
+
+	now := time.Now()
+	tombstone := func(deletedAt time.Time) *GetResult {
+		return &GetResult{GetMetaResult: &gocbcore.GetMetaResult{Deleted: 1, Expiry: uint32(deletedAt.Unix())}}
+	}
+	differ := &MutationDiffer{}
+	// Unknown purge intervals leave every missing document missing
+	assert.False(differ.purged(true, tombstone(now.Add(-30*24*time.Hour))))
+
+	differ.SetPurgeHorizons(now.Add(-3*24*time.Hour), now.Add(-24*time.Hour))
+	// Deleted two days ago, which the target has purged by now but the source has not
+	assert.True(differ.purged(false, tombstone(now.Add(-2*24*time.Hour))))
+	assert.False(differ.purged(true, tombstone(now.Add(-2*24*time.Hour))))
+	assert.True(differ.purged(true, tombstone(now.Add(-4*24*time.Hour))))
+	// A live document, or one whose metadata was not fetched, is no tombstone
+	live := &GetResult{GetMetaResult: &gocbcore.GetMetaResult{Expiry: uint32(now.Add(-4 * 24 * time.Hour).Unix())}}
+	assert.False(differ.purged(true, live))
+	assert.False(differ.purged(true, &GetResult{}))
+	assert.False(differ.purged(true, tombstone(time.Unix(0, 0))))
+	fmt.Println("============== Test case end: TestPurged =================")
+}
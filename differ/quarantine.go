@@ -0,0 +1,79 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"xdcrDiffer/base"
+)
+
+// A record of the mutation differ output that could not be written with the others. What was fetched of it is kept
+// as raw bytes, which JSON writes as base64, so that nothing about the record has to be encodable for it to be kept
+type QuarantinedEntry struct {
+	Category string
+	ColId    uint32
+	// Encoded by base.EncodeKey
+	Key       string
+	Error     string
+	Documents []*QuarantinedDocument
+}
+
+// One side of a quarantined record
+type QuarantinedDocument struct {
+	Body []byte `json:",omitempty"`
+	// The metadata as formatted by fmt, as it may be what could not be encoded
+	Metadata []byte `json:",omitempty"`
+}
+
+func newQuarantinedEntry(category string, colId uint32, entry diffOutputEntry, err error) *QuarantinedEntry {
+	quarantined := &QuarantinedEntry{Category: category, ColId: colId, Key: entry.key, Error: err.Error()}
+	switch details := entry.details.(type) {
+	case *GetResult:
+		quarantined.Documents = append(quarantined.Documents, newQuarantinedDocument(details))
+	case []*GetResult:
+		for _, result := range details {
+			quarantined.Documents = append(quarantined.Documents, newQuarantinedDocument(result))
+		}
+	default:
+		quarantined.Documents = append(quarantined.Documents, &QuarantinedDocument{Metadata: []byte(fmt.Sprintf("%+v", details))})
+	}
+	return quarantined
+}
+
+func newQuarantinedDocument(result *GetResult) *QuarantinedDocument {
+	if result == nil {
+		return &QuarantinedDocument{}
+	}
+	document := &QuarantinedDocument{Body: result.value}
+	if result.GetMetaResult != nil {
+		document.Metadata = []byte(fmt.Sprintf("%+v", *result.GetMetaResult))
+	}
+	return document
+}
+
+// Writes the entries as JSON lines, one entry per line
+func writeQuarantine(fileName string, entries []*QuarantinedEntry) error {
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, base.FileModeReadWrite)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range entries {
+		if err = encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
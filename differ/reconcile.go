@@ -0,0 +1,325 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+	"xdcrDiffer/base"
+)
+
+// What it takes to reconcile the losing cluster to the winning one, as found by the mutation differ. Documents that
+// only the losing cluster has are to be deleted from it, and those it lacks, or has another revision of, are to be
+// written to it from the winning cluster. A tombstone counts as the document not being there
+type Reconciliation struct {
+	winner string
+	// Bucket and scope.collection names of each collection ID of either cluster
+	sourceBucket string
+	targetBucket string
+	sourceNames  map[uint32]string
+	targetNames  map[uint32]string
+	// Of the losing cluster and its bucket as found by the run, so that the script refuses to run against another
+	loserClusterUUID string
+	loserBucketUUID  string
+	// Bodies are not imported when only some paths of them were fetched
+	partialBodies bool
+
+	mtx     sync.Mutex
+	orphans []*reconcileEntry
+	stale   []*reconcileEntry
+}
+
+type reconcileEntry struct {
+	key      string
+	srcColId uint32
+	tgtColId uint32
+	// Of the losing cluster for orphans, whose CAS guards their deletion, and of the winning one otherwise
+	result *GetResult
+	// Of the document on the losing cluster, or 0 if it has none, so that a repair can be verified
+	loserCas uint64
+}
+
+// Counts of what was written, as logged
+type ReconciliationSummary struct {
+	Deletes int
+	Stale   int
+	Imports int
+	// Documents that are left to re-replication alone: their bodies were not fetched, are not JSON objects or
+	// have keys that cannot be written as JSON
+	NotImported int
+	// Keys that cannot be deleted by N1QL, as they are not valid UTF-8 or their collection is unknown
+	NotDeleted int
+}
+
+func (s *ReconciliationSummary) String() string {
+	return fmt.Sprintf("%v documents to delete and %v to write, %v of them in the import dataset. Not scripted: %v deletes and %v imports",
+		s.Deletes, s.Stale, s.Imports, s.NotDeleted, s.NotImported)
+}
+
+func NewReconciliation(winner, sourceBucket, targetBucket, loserClusterUUID, loserBucketUUID string, sourceNames, targetNames map[uint32]string) *Reconciliation {
+	return &Reconciliation{
+		winner:           winner,
+		sourceBucket:     sourceBucket,
+		targetBucket:     targetBucket,
+		loserClusterUUID: loserClusterUUID,
+		loserBucketUUID:  loserBucketUUID,
+		sourceNames:      sourceNames,
+		targetNames:      targetNames,
+	}
+}
+
+// Reconciles the documents the mutation differ found to differ, of the compare paths or of whole bodies
+func (d *MutationDiffer) SetReconciliation(reconciliation *Reconciliation) {
+	reconciliation.partialBodies = len(d.comparePaths) > 0
+	d.reconciliation = reconciliation
+}
+
+// Takes in a key that differs, given whether there is a live document of it on either cluster
+func (r *Reconciliation) add(key string, srcColId, tgtColId uint32, sourceResult, targetResult *GetResult, onSource, onTarget bool) {
+	if r == nil {
+		return
+	}
+	winnerResult, loserResult, onWinner, onLoser := sourceResult, targetResult, onSource, onTarget
+	if r.winner == base.ReconcileWinnerTarget {
+		winnerResult, loserResult, onWinner, onLoser = targetResult, sourceResult, onTarget, onSource
+	}
+	entry := &reconcileEntry{key: key, srcColId: srcColId, tgtColId: tgtColId}
+	if onLoser {
+		entry.loserCas = loserResult.fetchCas
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	switch {
+	case onWinner:
+		entry.result = winnerResult
+		r.stale = append(r.stale, entry)
+	case onLoser:
+		entry.result = loserResult
+		r.orphans = append(r.orphans, entry)
+	}
+}
+
+// Once the keys that differed are fetched again, what was found of them before no longer holds
+func (r *Reconciliation) clear() {
+	if r == nil {
+		return
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.orphans = nil
+	r.stale = nil
+}
+
+// The bucket, collection names and collection ID of an entry on the losing cluster
+func (r *Reconciliation) loserOf(entry *reconcileEntry) (string, map[uint32]string, uint32) {
+	if r.winner == base.ReconcileWinnerTarget {
+		return r.sourceBucket, r.sourceNames, entry.srcColId
+	}
+	return r.targetBucket, r.targetNames, entry.tgtColId
+}
+
+func sortReconcileEntries(entries []*reconcileEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].srcColId != entries[j].srcColId {
+			return entries[i].srcColId < entries[j].srcColId
+		}
+		if entries[i].tgtColId != entries[j].tgtColId {
+			return entries[i].tgtColId < entries[j].tgtColId
+		}
+		return entries[i].key < entries[j].key
+	})
+}
+
+// Writes the scripts and datasets under dir, which is created if need be. Files with nothing to do are not written
+func (r *Reconciliation) write(dir string) (*ReconciliationSummary, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	sortReconcileEntries(r.orphans)
+	sortReconcileEntries(r.stale)
+	summary := &ReconciliationSummary{Stale: len(r.stale)}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+
+	repairs := &RepairLog{Winner: r.winner, Repairs: make(map[uint32]map[string]*Repair)}
+	var deletes bytes.Buffer
+	for _, entry := range r.orphans {
+		bucket, names, colId := r.loserOf(entry)
+		statement, ok := deleteStatement(bucket, names[colId], entry)
+		if !ok {
+			summary.NotDeleted++
+			continue
+		}
+		deletes.WriteString(statement)
+		summary.Deletes++
+		repairs.add(entry, base.RepairActionDelete)
+	}
+
+	replicateKeys := make(DiffKeysMap)
+	var imports bytes.Buffer
+	for _, entry := range r.stale {
+		replicateKeys[entry.srcColId] = append(replicateKeys[entry.srcColId], entry.key)
+		repairs.add(entry, base.RepairActionWrite)
+		_, names, colId := r.loserOf(entry)
+		line, ok := r.importLine(names[colId], entry)
+		if !ok {
+			summary.NotImported++
+			continue
+		}
+		imports.Write(line)
+		imports.WriteByte('\n')
+		summary.Imports++
+	}
+
+	if deletes.Len() > 0 {
+		if err := ioutil.WriteFile(filepath.Join(dir, base.ReconcileDeleteFileName), deletes.Bytes(), base.FileModeReadWrite); err != nil {
+			return nil, err
+		}
+	}
+	if len(replicateKeys) > 0 {
+		replicateKeysBytes, err := json.Marshal(replicateKeys.encoded())
+		if err != nil {
+			return nil, err
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, base.ReconcileReplicateKeysFileName), replicateKeysBytes, base.FileModeReadWrite); err != nil {
+			return nil, err
+		}
+	}
+	if imports.Len() > 0 {
+		if err := ioutil.WriteFile(filepath.Join(dir, base.ReconcileImportFileName), imports.Bytes(), base.FileModeReadWrite); err != nil {
+			return nil, err
+		}
+	}
+	if len(repairs.Repairs) > 0 {
+		repairsBytes, err := json.Marshal(repairs.encoded())
+		if err != nil {
+			return nil, err
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, base.ReconcileRepairsFileName), repairsBytes, base.FileModeReadWrite); err != nil {
+			return nil, err
+		}
+	}
+	if summary.Deletes > 0 || summary.Imports > 0 {
+		script := r.script(summary)
+		if err := ioutil.WriteFile(filepath.Join(dir, base.ReconcileScriptFileName), []byte(script), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return summary, nil
+}
+
+// Deletes the document only if it is still at the CAS it was fetched at, so that one written since is left alone
+func deleteStatement(bucket, collectionName string, entry *reconcileEntry) (string, bool) {
+	keyspace, ok := n1qlKeyspace(bucket, collectionName)
+	if !ok || !utf8.ValidString(entry.key) {
+		return "", false
+	}
+	quotedKey, err := json.Marshal(entry.key)
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("DELETE FROM %v USE KEYS %s WHERE META().cas = %v;\n", keyspace, quotedKey, entry.result.fetchCas), true
+}
+
+// i.e. `travel-sample`.`inventory`.`airline`
+func n1qlKeyspace(bucket, collectionName string) (string, bool) {
+	parts := strings.Split(collectionName, base.ScopeCollectionDelimiter)
+	if len(parts) != 2 {
+		return "", false
+	}
+	return fmt.Sprintf("`%v`.`%v`.`%v`", bucket, parts[0], parts[1]), true
+}
+
+// The document as a line of the import dataset, with the fields cbimport takes its key and collection from
+func (r *Reconciliation) importLine(collectionName string, entry *reconcileEntry) ([]byte, bool) {
+	parts := strings.Split(collectionName, base.ScopeCollectionDelimiter)
+	if r.partialBodies || entry.result.value == nil || len(parts) != 2 || !utf8.ValidString(entry.key) {
+		return nil, false
+	}
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(entry.result.value, &document); err != nil || document == nil {
+		return nil, false
+	}
+	for field, value := range map[string]string{
+		base.ReconcileImportKeyField:        entry.key,
+		base.ReconcileImportScopeField:      parts[0],
+		base.ReconcileImportCollectionField: parts[1],
+	} {
+		if _, exists := document[field]; exists {
+			return nil, false
+		}
+		valueBytes, err := json.Marshal(value)
+		if err != nil {
+			return nil, false
+		}
+		document[field] = valueBytes
+	}
+	line, err := json.Marshal(document)
+	if err != nil {
+		return nil, false
+	}
+	return line, true
+}
+
+// Runs the deletes and the import against the losing cluster, given as arguments so that no credentials are written.
+// Nothing is run unless the cluster and its bucket have the UUIDs of those the differences were found on, so that a
+// cluster given by mistake, or one whose bucket was recreated since, is left alone
+func (r *Reconciliation) script(summary *ReconciliationSummary) string {
+	loser, loserBucket := base.TargetClusterLabel, r.targetBucket
+	if r.winner == base.ReconcileWinnerTarget {
+		loser, loserBucket = base.SourceClusterLabel, r.sourceBucket
+	}
+	var script strings.Builder
+	script.WriteString("#!/bin/sh\n")
+	fmt.Fprintf(&script, "# Reconciles %v to %v: %v\n", loser, r.winner, summary)
+	script.WriteString("# Review before running. Usage: ./" + base.ReconcileScriptFileName + " <cluster URL> <username> <password>, the URL being of the REST endpoint, i.e. http://host:8091\n")
+	script.WriteString("set -e\ncd \"$(dirname \"$0\")\"\n")
+	fmt.Fprintf(&script, "curl -sSf -u \"$2:$3\" \"$1%v\" | grep -qF %v || { echo \"$1 is not the %v cluster of the run, of UUID %v\" >&2; exit 1; }\n",
+		base.PoolsPath, shellQuote(uuidField(r.loserClusterUUID)), loser, r.loserClusterUUID)
+	fmt.Fprintf(&script, "curl -sSf -u \"$2:$3\" \"$1%v\" | grep -qF %v || { echo \"Bucket %v on $1 is not the one of the run, of UUID %v\" >&2; exit 1; }\n",
+		base.PoolsDefaultBucketPath+url.PathEscape(loserBucket), shellQuote(uuidField(r.loserBucketUUID)), loserBucket, r.loserBucketUUID)
+	if summary.Deletes > 0 {
+		fmt.Fprintf(&script, "cbq -e \"$1\" -u \"$2\" -p \"$3\" -f %v\n", base.ReconcileDeleteFileName)
+	}
+	if summary.Imports > 0 {
+		fmt.Fprintf(&script, "cbimport json -c \"$1\" -u \"$2\" -p \"$3\" -b %v -f lines -d file://%v -g %%%v%% --scope-collection-exp %%%v%%.%%%v%% --ignore-fields %v,%v,%v\n",
+			shellQuote(loserBucket), base.ReconcileImportFileName, base.ReconcileImportKeyField, base.ReconcileImportScopeField,
+			base.ReconcileImportCollectionField, base.ReconcileImportKeyField, base.ReconcileImportScopeField, base.ReconcileImportCollectionField)
+	}
+	script.WriteString("echo \"Done. Verify the repairs with -verifyRepairs $(pwd) and the options of the run\"\n")
+	return script.String()
+}
+
+// As a cluster serves it in the JSON of the cluster or bucket
+func uuidField(uuid string) string {
+	return fmt.Sprintf(`"uuid":"%v"`, uuid)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func (d *MutationDiffer) writeReconciliation() error {
+	summary, err := d.reconciliation.write(filepath.Join(d.mutationDifferFileDir, base.ReconcileDir))
+	if err != nil {
+		return err
+	}
+	d.logger.Infof("Reconciliation to %v: %v\n", d.reconciliation.winner, summary)
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"xdcrDiffer/base"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconciliation(t *testing.T) {
+	fmt.Println("============== Test case start: TestReconciliation =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "reconcile")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	sourceNames := map[uint32]string{8: "inventory.airline"}
+	targetNames := map[uint32]string{9: "inventory.airline"}
+	reconciliation := NewReconciliation(base.ReconcileWinnerSource, "src", "tgt", "c1a55e7b", "b0cce7a1", sourceNames, targetNames)
+	onSource := &GetResult{key: "airline_1", value: []byte(`{"name":"Ryanair"}`), fetchCas: 100}
+	reconciliation.add("airline_1", 8, 9, onSource, &GetResult{}, true, false)
+	onTarget := &GetResult{key: "airline_2", fetchCas: 1700000000000000001}
+	reconciliation.add("airline_2", 8, 9, &GetResult{}, onTarget, false, true)
+	// Bodies that are not JSON objects are left to re-replication
+	binary := &GetResult{key: "airline_3", value: []byte("\x00\x01")}
+	reconciliation.add("airline_3", 8, 9, binary, &GetResult{fetchCas: 5}, true, true)
+	// Deletes need the collection on the losing cluster
+	reconciliation.add("airline_4", 8, 10, &GetResult{}, &GetResult{fetchCas: 6}, false, true)
+
+	summary, err := reconciliation.write(dir)
+	assert.Nil(err)
+	assert.Equal(&ReconciliationSummary{Deletes: 1, Stale: 2, Imports: 1, NotImported: 1, NotDeleted: 1}, summary)
+
+	deletes, err := ioutil.ReadFile(filepath.Join(dir, base.ReconcileDeleteFileName))
+	assert.Nil(err)
+	assert.Equal("DELETE FROM `tgt`.`inventory`.`airline` USE KEYS \"airline_2\" WHERE META().cas = 1700000000000000001;\n", string(deletes))
+
+	imports, err := ioutil.ReadFile(filepath.Join(dir, base.ReconcileImportFileName))
+	assert.Nil(err)
+	var document map[string]string
+	assert.Nil(json.Unmarshal(imports, &document))
+	assert.Equal(map[string]string{"name": "Ryanair", base.ReconcileImportKeyField: "airline_1",
+		base.ReconcileImportScopeField: "inventory", base.ReconcileImportCollectionField: "airline"}, document)
+
+	replicateKeys, err := ioutil.ReadFile(filepath.Join(dir, base.ReconcileReplicateKeysFileName))
+	assert.Nil(err)
+	parsed, _, err := ParseDiffKeys(replicateKeys, DiffKeysFormatJSON)
+	assert.Nil(err)
+	assert.Equal(DiffKeysMap{8: {"airline_1", "airline_3"}}, parsed)
+
+	// Every key that is scripted is listed for its repair to be verified, with the CAS it had on the losing cluster
+	repairLog, err := ReadRepairLog(dir)
+	assert.Nil(err)
+	assert.Equal(&RepairLog{Winner: base.ReconcileWinnerSource, Repairs: map[uint32]map[string]*Repair{8: {
+		"airline_1": {TgtColId: 9, Action: base.RepairActionWrite},
+		"airline_2": {TgtColId: 9, Action: base.RepairActionDelete, LoserCas: 1700000000000000001},
+		"airline_3": {TgtColId: 9, Action: base.RepairActionWrite, LoserCas: 5},
+	}}}, repairLog)
+
+	script, err := ioutil.ReadFile(filepath.Join(dir, base.ReconcileScriptFileName))
+	assert.Nil(err)
+	assert.True(strings.Contains(string(script), "cbq -e \"$1\" -u \"$2\" -p \"$3\" -f deleteOrphans.n1ql\n"))
+	assert.True(strings.Contains(string(script), "-b 'tgt' -f lines -d file://import.jsonl -g %xdcrDifferKey%"))
+	// Nothing is run against a cluster or bucket other than those of the run
+	guard := strings.Index(string(script), "curl -sSf -u \"$2:$3\" \"$1/pools\" | grep -qF '\"uuid\":\"c1a55e7b\"' || {")
+	bucketGuard := strings.Index(string(script), "curl -sSf -u \"$2:$3\" \"$1/pools/default/buckets/tgt\" | grep -qF '\"uuid\":\"b0cce7a1\"' || {")
+	assert.True(guard > 0)
+	assert.True(bucketGuard > guard)
+	assert.True(strings.Index(string(script), "cbq") > bucketGuard)
+	assert.True(strings.Contains(string(script), "-verifyRepairs $(pwd)"))
+
+	// With the target winning, the same differences are reconciled the other way
+	reconciliation = NewReconciliation(base.ReconcileWinnerTarget, "src", "tgt", "5e1f0001", "5e1f0002", sourceNames, targetNames)
+	reconciliation.add("airline_1", 8, 9, onSource, &GetResult{}, true, false)
+	reconciliation.add("airline_2", 8, 9, &GetResult{}, onTarget, false, true)
+	reconciliation.clear()
+	reconciliation.add("airline_1", 8, 9, onSource, &GetResult{}, true, false)
+	summary, err = reconciliation.write(filepath.Join(dir, "target"))
+	assert.Nil(err)
+	assert.Equal(&ReconciliationSummary{Deletes: 1}, summary)
+	deletes, err = ioutil.ReadFile(filepath.Join(dir, "target", base.ReconcileDeleteFileName))
+	assert.Nil(err)
+	assert.Equal("DELETE FROM `src`.`inventory`.`airline` USE KEYS \"airline_1\" WHERE META().cas = 100;\n", string(deletes))
+	script, err = ioutil.ReadFile(filepath.Join(dir, "target", base.ReconcileScriptFileName))
+	assert.Nil(err)
+	assert.True(strings.Contains(string(script), "\"$1/pools\" | grep -qF '\"uuid\":\"5e1f0001\"'"))
+	assert.True(strings.Contains(string(script), "\"$1/pools/default/buckets/src\" | grep -qF '\"uuid\":\"5e1f0002\"'"))
+	fmt.Println("============== Test case end: TestReconciliation =================")
+}
@@ -0,0 +1,235 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+	"xdcrDiffer/base"
+)
+
+// Categories of the repairs, once verified
+const (
+	// The document no longer differs
+	RepairConverged = "Converged"
+	// The document still differs, and was written on the losing cluster since it was found to differ, i.e. the repair
+	// was overwritten again by a replication or an application
+	RepairOverwritten = "Overwritten"
+	// The document still differs, and is on the losing cluster as it was found, i.e. the repair never took effect
+	RepairFailed = "Failed"
+)
+
+// What a repair of the reconciliation is to do to a key on the losing cluster
+type Repair struct {
+	TgtColId uint32
+	Action   string
+	// Of the document on the losing cluster when it was found to differ, or 0 if it had none
+	LoserCas uint64 `json:",omitempty"`
+}
+
+// The repairs of a reconciliation, by source collection ID and key
+type RepairLog struct {
+	Winner  string
+	Repairs map[uint32]map[string]*Repair
+}
+
+func (l *RepairLog) add(entry *reconcileEntry, action string) {
+	if _, exists := l.Repairs[entry.srcColId]; !exists {
+		l.Repairs[entry.srcColId] = make(map[string]*Repair)
+	}
+	l.Repairs[entry.srcColId][entry.key] = &Repair{TgtColId: entry.tgtColId, Action: action, LoserCas: entry.loserCas}
+}
+
+func (l *RepairLog) encoded() *RepairLog {
+	encoded := &RepairLog{Winner: l.Winner, Repairs: make(map[uint32]map[string]*Repair)}
+	for colId, repairs := range l.Repairs {
+		encoded.Repairs[colId] = make(map[string]*Repair, len(repairs))
+		for key, repair := range repairs {
+			encodedKey, _ := base.EncodeKey(key)
+			encoded.Repairs[colId][encodedKey] = repair
+		}
+	}
+	return encoded
+}
+
+// The keys of the repairs, from the source's point of view
+func (l *RepairLog) diffKeys() DiffKeysMap {
+	diffKeys := make(DiffKeysMap)
+	for colId, repairs := range l.Repairs {
+		for key := range repairs {
+			diffKeys[colId] = append(diffKeys[colId], key)
+		}
+	}
+	return diffKeys
+}
+
+// Reads the repairs that a reconciliation written under dir is to make
+func ReadRepairLog(dir string) (*RepairLog, error) {
+	repairsBytes, err := ioutil.ReadFile(filepath.Join(dir, base.ReconcileRepairsFileName))
+	if err != nil {
+		return nil, err
+	}
+	var encoded RepairLog
+	if err = json.Unmarshal(repairsBytes, &encoded); err != nil {
+		return nil, fmt.Errorf("%v is not a repair log: %v", base.ReconcileRepairsFileName, err)
+	}
+	if encoded.Winner != base.ReconcileWinnerSource && encoded.Winner != base.ReconcileWinnerTarget {
+		return nil, fmt.Errorf("%v has no winning cluster", base.ReconcileRepairsFileName)
+	}
+	repairLog := &RepairLog{Winner: encoded.Winner, Repairs: make(map[uint32]map[string]*Repair)}
+	for colId, repairs := range encoded.Repairs {
+		repairLog.Repairs[colId] = make(map[string]*Repair, len(repairs))
+		for encodedKey, repair := range repairs {
+			key, err := base.DecodeKey(encodedKey)
+			if err != nil {
+				return nil, fmt.Errorf("invalid encoded key %v: %v", encodedKey, err)
+			}
+			repairLog.Repairs[colId][key] = repair
+		}
+	}
+	return repairLog, nil
+}
+
+// Verifies the repairs of repairLog, once settle has passed, instead of the keys of file differ
+func (d *MutationDiffer) SetRepairVerification(repairLog *RepairLog, settle time.Duration) {
+	d.repairs = repairLog
+	d.repairSettle = settle
+}
+
+// Keys of each category of RepairConverged, RepairOverwritten and RepairFailed, by source collection ID
+type RepairVerification map[string]map[uint32][]string
+
+func (v RepairVerification) add(category string, colId uint32, key string) {
+	if _, exists := v[category]; !exists {
+		v[category] = make(map[uint32][]string)
+	}
+	v[category][colId] = append(v[category][colId], key)
+}
+
+func (v RepairVerification) count(category string) int {
+	var count int
+	for _, keys := range v[category] {
+		count += len(keys)
+	}
+	return count
+}
+
+func (v RepairVerification) encoded() RepairVerification {
+	encoded := make(RepairVerification, len(v))
+	for category, keysPerCol := range v {
+		encoded[category] = make(map[uint32][]string, len(keysPerCol))
+		for colId, keys := range keysPerCol {
+			sort.Strings(keys)
+			encoded[category][colId] = base.EncodeKeys(keys)
+		}
+	}
+	return encoded
+}
+
+// Once the repaired keys are diffed, writes which repairs converged and which did not
+func (d *MutationDiffer) verifyRepairs() error {
+	verification, numUnverified := d.classifyRepairs(d.fetchOnly)
+	d.logger.Infof("Repairs to %v: %v converged, %v overwritten again and %v failed. %v could not be verified\n", d.repairs.Winner,
+		verification.count(RepairConverged), verification.count(RepairOverwritten), verification.count(RepairFailed), numUnverified)
+	verificationBytes, err := json.Marshal(verification.encoded())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(d.mutationDifferFileDir, base.RepairVerificationFileName), verificationBytes, base.FileModeReadWrite)
+}
+
+// Tells the repairs that converged from those that did not, fetching the keys that still differ once more to tell
+// whether the losing cluster was written since they were found to differ. Keys that could not be verified, as they
+// stayed locked or could not be fetched, are counted and left out
+func (d *MutationDiffer) classifyRepairs(fetch func(MutationDiffFetchList) (map[uint32]map[string]*GetResult, map[uint32]map[string]*GetResult)) (RepairVerification, int) {
+	srcDiffKeys, tgtDiffKeys := keySet(d.getDiffKeysFromSourceGocbResult()), keySet(d.getDiffKeysFromTargetGocbResult())
+	unverified := make(DiffKeysMap)
+	d.stateLock.RLock()
+	for colId, lockedPerCol := range d.locked {
+		for key := range lockedPerCol {
+			unverified[colId] = append(unverified[colId], key)
+		}
+	}
+	for _, entry := range d.keysWithError {
+		unverified[entry.SrcColId] = append(unverified[entry.SrcColId], entry.Key)
+	}
+	d.stateLock.RUnlock()
+	unverifiedKeys := keySet(unverified)
+
+	verification := make(RepairVerification)
+	var fetchList MutationDiffFetchList
+	var numUnverified int
+	for colId, repairs := range d.repairs.Repairs {
+		for key, repair := range repairs {
+			switch {
+			case unverifiedKeys[colId][key]:
+				numUnverified++
+			case srcDiffKeys[colId][key] || tgtDiffKeys[repair.TgtColId][key]:
+				fetchList = append(fetchList, &MutationDifferFetchEntry{SrcColId: colId, TgtColIds: []uint32{repair.TgtColId}, Key: key})
+			default:
+				verification.add(RepairConverged, colId, key)
+			}
+		}
+	}
+
+	if len(fetchList) > 0 {
+		d.logger.Infof("Fetching %v repaired keys that still differ once more to tell whether they were written since...", len(fetchList))
+		sourceResults, targetResults := fetch(fetchList)
+		for _, entry := range fetchList {
+			repair := d.repairs.Repairs[entry.SrcColId][entry.Key]
+			loserResult := targetResults[repair.TgtColId][entry.Key]
+			if d.repairs.Winner == base.ReconcileWinnerTarget {
+				loserResult = sourceResults[entry.SrcColId][entry.Key]
+			}
+			loserCas, ok := repairedCas(loserResult)
+			switch {
+			case !ok:
+				numUnverified++
+			case loserCas != repair.LoserCas:
+				verification.add(RepairOverwritten, entry.SrcColId, entry.Key)
+			default:
+				verification.add(RepairFailed, entry.SrcColId, entry.Key)
+			}
+		}
+	}
+
+	return verification, numUnverified
+}
+
+func keySet(keys DiffKeysMap) map[uint32]map[string]bool {
+	set := make(map[uint32]map[string]bool, len(keys))
+	for colId, keysPerCol := range keys {
+		set[colId] = make(map[string]bool, len(keysPerCol))
+		for _, key := range keysPerCol {
+			set[colId][key] = true
+		}
+	}
+	return set
+}
+
+// The CAS of the document fetched, or 0 if there is none. Not ok if it could not be fetched
+func repairedCas(result *GetResult) (uint64, bool) {
+	if result == nil {
+		return 0, false
+	}
+	result.lock.RLock()
+	defer result.lock.RUnlock()
+	if isKeyNotFoundError(result.bodyErr) {
+		return 0, true
+	}
+	if result.bodyErr != nil || result.dispatchErr != nil {
+		return 0, false
+	}
+	return result.fetchCas, true
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"xdcrDiffer/base"
+
+	gocbcore "github.com/couchbase/gocbcore/v10"
+	xdcrLog "github.com/couchbase/goxdcr/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepairVerification(t *testing.T) {
+	fmt.Println("============== Test case start: TestRepairVerification =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "repairs")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	repairLog := &RepairLog{Winner: base.ReconcileWinnerSource, Repairs: map[uint32]map[string]*Repair{8: {
+		"converged":   {TgtColId: 9, Action: base.RepairActionWrite, LoserCas: 10},
+		"overwritten": {TgtColId: 9, Action: base.RepairActionWrite, LoserCas: 20},
+		"failed":      {TgtColId: 9, Action: base.RepairActionDelete, LoserCas: 30},
+		"notWritten":  {TgtColId: 9, Action: base.RepairActionWrite},
+		"locked":      {TgtColId: 9, Action: base.RepairActionWrite},
+		"unfetched":   {TgtColId: 9, Action: base.RepairActionWrite},
+		"\xff":        {TgtColId: 9, Action: base.RepairActionWrite},
+	}}}
+	// Written and read back as reconcileWinner and verifyRepairs do, with keys that are not valid UTF-8 encoded
+	repairsBytes, err := json.Marshal(repairLog.encoded())
+	assert.Nil(err)
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, base.ReconcileRepairsFileName), repairsBytes, base.FileModeReadWrite))
+	readLog, err := ReadRepairLog(dir)
+	assert.Nil(err)
+	assert.Equal(repairLog, readLog)
+	assert.Equal(DiffKeysMap{8: {"converged", "failed", "locked", "notWritten", "overwritten", "unfetched", "\xff"}}, sortedDiffKeys(readLog.diffKeys()))
+
+	differ := &MutationDiffer{
+		logger:                xdcrLog.NewLogger("TestRepairVerification", xdcrLog.DefaultLoggerContext),
+		stateLock:             &sync.RWMutex{},
+		mutationDifferFileDir: dir,
+		srcDiff: map[uint32]map[string][]*GetResult{8: {
+			"overwritten": nil,
+			"unfetched":   nil,
+			"\xff":        nil,
+		}},
+		missingFromSource: map[uint32]map[string]*GetResult{8: {"notWritten": nil}},
+		missingFromTarget: map[uint32]map[string]*GetResult{9: {"failed": nil}},
+		locked:            map[uint32]map[string][]*GetResult{8: {"locked": nil}},
+	}
+	differ.SetRepairVerification(readLog, 0)
+
+	var fetched []string
+	fetch := func(fetchList MutationDiffFetchList) (map[uint32]map[string]*GetResult, map[uint32]map[string]*GetResult) {
+		for _, entry := range fetchList {
+			fetched = append(fetched, entry.Key)
+		}
+		return map[uint32]map[string]*GetResult{}, map[uint32]map[string]*GetResult{9: {
+			// Written on the losing cluster since it was found to differ
+			"overwritten": {key: "overwritten", fetchCas: 21},
+			// Still there as it was found, at the CAS its delete was guarded by
+			"failed": {key: "failed", fetchCas: 30},
+			// Still missing, as it was found
+			"notWritten": {key: "notWritten", bodyErr: gocbcore.ErrDocumentNotFound},
+			"unfetched":  {key: "unfetched", bodyErr: errors.New("timeout")},
+			"\xff":       {key: "\xff", fetchCas: 1},
+		}}
+	}
+	verification, numUnverified := differ.classifyRepairs(fetch)
+	// Only the keys that still differ are fetched again
+	assert.ElementsMatch([]string{"overwritten", "failed", "notWritten", "unfetched", "\xff"}, fetched)
+	assert.Equal(2, numUnverified)
+	assert.Equal(RepairVerification{
+		RepairConverged:   {8: {"converged"}},
+		RepairOverwritten: {8: {"overwritten", "\xff"}},
+		RepairFailed:      {8: {"failed", "notWritten"}},
+	}, sortedVerification(verification))
+	// Written sorted by key, encoded
+	assert.Equal([]string{"overwritten", "base64:/w=="}, verification.encoded()[RepairOverwritten][8])
+
+	// With the target winning, the source is the one the repairs were made to
+	differ.repairs = &RepairLog{Winner: base.ReconcileWinnerTarget, Repairs: map[uint32]map[string]*Repair{8: {
+		"overwritten": {TgtColId: 9, Action: base.RepairActionWrite, LoserCas: 20},
+	}}}
+	verification, _ = differ.classifyRepairs(func(MutationDiffFetchList) (map[uint32]map[string]*GetResult, map[uint32]map[string]*GetResult) {
+		return map[uint32]map[string]*GetResult{8: {"overwritten": {key: "overwritten", fetchCas: 20}}},
+			map[uint32]map[string]*GetResult{9: {"overwritten": {key: "overwritten", fetchCas: 21}}}
+	})
+	assert.Equal(RepairVerification{RepairFailed: {8: {"overwritten"}}}, verification)
+
+	_, err = ReadRepairLog(filepath.Join(dir, "none"))
+	assert.NotNil(err)
+	fmt.Println("============== Test case end: TestRepairVerification =================")
+}
+
+func sortedDiffKeys(keys DiffKeysMap) DiffKeysMap {
+	for _, keysPerCol := range keys {
+		sort.Strings(keysPerCol)
+	}
+	return keys
+}
+
+func sortedVerification(verification RepairVerification) RepairVerification {
+	for _, keysPerCol := range verification {
+		for _, keys := range keysPerCol {
+			sort.Strings(keys)
+		}
+	}
+	return verification
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"sort"
+	"sync"
+	"xdcrDiffer/base"
+)
+
+// A key whose operations on one cluster exceeded their deadline
+type SlowKey struct {
+	Key         string
+	KeyEncoding string `json:",omitempty"`
+	Cluster     string
+	ColId       uint32
+	// Times the operations of the key exceeded the deadline
+	Exceeded int
+}
+
+type slowKeyId struct {
+	key     string
+	cluster string
+	colId   uint32
+}
+
+type slowKeyTracker struct {
+	mtx  sync.Mutex
+	keys map[slowKeyId]*SlowKey
+}
+
+func newSlowKeyTracker() *slowKeyTracker {
+	return &slowKeyTracker{keys: make(map[slowKeyId]*SlowKey)}
+}
+
+func (t *slowKeyTracker) add(key, cluster string, colId uint32) {
+	id := slowKeyId{key: key, cluster: cluster, colId: colId}
+	slowKey, exists := t.keys[id]
+	if !exists {
+		writtenKey, _ := base.EncodeKey(key)
+		slowKey = &SlowKey{Key: writtenKey, KeyEncoding: base.KeyEncodingOf(writtenKey), Cluster: cluster, ColId: colId}
+		t.keys[id] = slowKey
+	}
+	slowKey.Exceeded++
+}
+
+// Counts the stragglers of the batch whose operations exceeded the deadline, rather than failing otherwise
+func (t *slowKeyTracker) exceeded(b *batch, stragglers MutationDiffFetchList) {
+	b.resultsLock.RLock()
+	defer b.resultsLock.RUnlock()
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for _, fetchItem := range stragglers {
+		if b.sourceResults[fetchItem.SrcColId][fetchItem.Key].exceededDeadline() {
+			t.add(fetchItem.Key, base.SourceClusterLabel, fetchItem.SrcColId)
+		}
+		for _, tgtColId := range fetchItem.TgtColIds {
+			if b.targetResults[tgtColId][fetchItem.Key].exceededDeadline() {
+				t.add(fetchItem.Key, base.TargetClusterLabel, tgtColId)
+			}
+		}
+	}
+}
+
+// The stragglers of the batch whose connection dropped, which are replayed rather than being slow
+func (b *batch) disconnected(stragglers MutationDiffFetchList) int {
+	b.resultsLock.RLock()
+	defer b.resultsLock.RUnlock()
+	var count int
+	for _, fetchItem := range stragglers {
+		dropped := b.sourceResults[fetchItem.SrcColId][fetchItem.Key].droppedConnection()
+		for _, tgtColId := range fetchItem.TgtColIds {
+			dropped = dropped || b.targetResults[tgtColId][fetchItem.Key].droppedConnection()
+		}
+		if dropped {
+			count++
+		}
+	}
+	return count
+}
+
+// Keys that exceeded the deadline at least minExceeded times, the most often first
+func (t *slowKeyTracker) slowest(minExceeded int) []*SlowKey {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	slowKeys := []*SlowKey{}
+	for _, slowKey := range t.keys {
+		if slowKey.Exceeded >= minExceeded {
+			slowKeys = append(slowKeys, slowKey)
+		}
+	}
+	sort.Slice(slowKeys, func(i, j int) bool {
+		if slowKeys[i].Exceeded != slowKeys[j].Exceeded {
+			return slowKeys[i].Exceeded > slowKeys[j].Exceeded
+		}
+		if slowKeys[i].Key != slowKeys[j].Key {
+			return slowKeys[i].Key < slowKeys[j].Key
+		}
+		return slowKeys[i].Cluster < slowKeys[j].Cluster
+	})
+	return slowKeys
+}
+
+func (r *GetResult) exceededDeadline() bool {
+	if r == nil {
+		return false
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, err := range []error{r.bodyErr, r.metaErr, r.hlvErr} {
+		if err != nil && classifyKeyError(err) == KeyErrorTypeTimeout {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether an operation of the result failed as its connection dropped, in which case it is replayed once the SDK has
+// connected again
+func (r *GetResult) droppedConnection() bool {
+	if r == nil {
+		return false
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, err := range []error{r.dispatchErr, r.bodyErr, r.metaErr, r.hlvErr} {
+		if base.IsConnectionError(err) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *GetResult) undispatched() bool {
+	if r == nil {
+		return false
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.dispatchErr != nil
+}
+
+// The keys of the batch that are to be fetched again, as their operations exceeded the deadline, could not be
+// dispatched at all or lost their connection
+func (b *batch) stragglers() MutationDiffFetchList {
+	b.resultsLock.RLock()
+	defer b.resultsLock.RUnlock()
+	straggling := func(result *GetResult) bool {
+		return result.exceededDeadline() || result.undispatched() || result.droppedConnection()
+	}
+	var stragglers MutationDiffFetchList
+	for _, fetchItem := range b.fetchList {
+		isStraggler := straggling(b.sourceResults[fetchItem.SrcColId][fetchItem.Key])
+		for _, tgtColId := range fetchItem.TgtColIds {
+			isStraggler = isStraggler || straggling(b.targetResults[tgtColId][fetchItem.Key])
+		}
+		if isStraggler {
+			stragglers = append(stragglers, fetchItem)
+		}
+	}
+	return stragglers
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"fmt"
+	"sync"
+
+	xdcrBase "github.com/couchbase/goxdcr/base"
+)
+
+// How the replication treats the TTL of the documents it replicates, which decides whether
+// documents are expected to differ by TTL only
+type TTLPolicy string
+
+const (
+	// Taken from the expiry settings of the replication
+	TTLPolicyAuto TTLPolicy = "auto"
+	// Documents keep their TTL on the target
+	TTLPolicyPreserve TTLPolicy = "preserve"
+	// The TTL of documents is removed as they are replicated, so that they never expire on the target
+	TTLPolicyStrip TTLPolicy = "strip"
+)
+
+func ParseTTLPolicy(value string) (TTLPolicy, error) {
+	switch policy := TTLPolicy(value); policy {
+	case TTLPolicyAuto, TTLPolicyPreserve, TTLPolicyStrip:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("Invalid TTL policy %v. Accepted values are %v, %v and %v", value, TTLPolicyAuto, TTLPolicyPreserve, TTLPolicyStrip)
+	}
+}
+
+// The policy of a replication of the given expiry settings
+func TTLPolicyOfReplication(expDelMode xdcrBase.FilterExpDelType) TTLPolicy {
+	if expDelMode.IsStripExpirationSet() {
+		return TTLPolicyStrip
+	}
+	return TTLPolicyPreserve
+}
+
+// Whether a source and target document that differ by TTL only do so because of the configuration of the replication
+func (p TTLPolicy) Expected(srcExpiry, tgtExpiry uint32) bool {
+	return p == TTLPolicyStrip && srcExpiry != 0 && tgtExpiry == 0
+}
+
+type ttlPolicySetting struct {
+	policy TTLPolicy
+	lock   sync.RWMutex
+}
+
+// Shared by the file differ and the mutation differ, like the pruning windows
+var replicationTTLPolicy *ttlPolicySetting = &ttlPolicySetting{policy: TTLPolicyPreserve}
+
+// Sets the policy both differs judge TTL differences by. It is not to be TTLPolicyAuto, which has to be resolved first
+func SetTTLPolicy(policy TTLPolicy) {
+	replicationTTLPolicy.lock.Lock()
+	defer replicationTTLPolicy.lock.Unlock()
+	replicationTTLPolicy.policy = policy
+}
+
+func (s *ttlPolicySetting) get() TTLPolicy {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.policy
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"errors"
+	"fmt"
+	"plugin"
+	"xdcrDiffer/base"
+)
+
+// Name of the function a verdict plugin exports, of the signature of VerdictFunc, i.e.
+//
+//	package main
+//
+//	import "xdcrDiffer/differ"
+//
+//	func Verdict(source, target *differ.VerdictDocument) (*differ.Verdict, error) { ... }
+//
+// built with go build -buildmode=plugin against the same sources as the tool
+const VerdictPluginSymbol = "Verdict"
+
+var ErrVerdictPluginsUnsupported = errors.New("Go plugins are not supported by this binary, which is built without cgo or for a platform other than Linux, FreeBSD or macOS. Build the tool with make, with cgo enabled, to use a verdict plugin")
+
+// Categories of the verdicts recorded in the output
+const (
+	VerdictCategoryEquivalent = "Equivalent"
+	VerdictCategoryMismatch   = "Mismatch"
+)
+
+// One side of a document the mutation differ found to differ, as handed to a verdict plugin
+type VerdictDocument struct {
+	Key          string
+	CollectionId uint32
+	// Nil unless the compare type includes bodies. Only the compared paths, if comparePaths is given
+	Body     []byte
+	Cas      uint64
+	RevId    uint64
+	Flags    uint32
+	Expiry   uint32
+	Datatype uint8
+	Deleted  bool
+}
+
+type Verdict struct {
+	// The documents are equivalent by the rules of the plugin, so they are not reported as a difference
+	Equivalent bool
+	// Recorded with the verdict, i.e. the rule that decided it
+	Annotation string `json:",omitempty"`
+}
+
+// Decides whether two documents that the built in comparison found to differ are equivalent after all,
+// i.e. by domain specific rules such as floating point tolerance. Called concurrently by the differ workers
+type VerdictFunc func(source, target *VerdictDocument) (*Verdict, error)
+
+// Loads the VerdictPluginSymbol of the Go plugin at path
+func LoadVerdictPlugin(path string) (VerdictFunc, error) {
+	if !VerdictPluginsSupported {
+		return nil, ErrVerdictPluginsUnsupported
+	}
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	symbol, err := p.Lookup(VerdictPluginSymbol)
+	if err != nil {
+		return nil, err
+	}
+	switch verdictFunc := symbol.(type) {
+	case func(source, target *VerdictDocument) (*Verdict, error):
+		return verdictFunc, nil
+	case *VerdictFunc:
+		return *verdictFunc, nil
+	default:
+		return nil, fmt.Errorf("%v of plugin %v is of type %T instead of %T", VerdictPluginSymbol, path, symbol, VerdictFunc(nil))
+	}
+}
+
+func newVerdictDocument(key string, colId uint32, result *GetResult) *VerdictDocument {
+	result.lock.RLock()
+	defer result.lock.RUnlock()
+	doc := &VerdictDocument{
+		Key:          key,
+		CollectionId: colId,
+		Body:         result.value,
+		Cas:          result.fetchCas,
+	}
+	if meta := result.GetMetaResult; meta != nil {
+		doc.Cas = uint64(meta.Cas)
+		doc.RevId = uint64(meta.SeqNo)
+		doc.Flags = meta.Flags
+		doc.Expiry = meta.Expiry
+		doc.Datatype = meta.Datatype
+		doc.Deleted = meta.Deleted != 0
+	}
+	return doc
+}
+
+// Verdicts of the plugin, by category, source collection ID and key
+type VerdictLog map[string]map[uint32]map[string]*Verdict
+
+func (l VerdictLog) add(category string, colId uint32, key string, verdict *Verdict) {
+	if l == nil {
+		return
+	}
+	if _, exists := l[category]; !exists {
+		l[category] = make(map[uint32]map[string]*Verdict)
+	}
+	if _, exists := l[category][colId]; !exists {
+		l[category][colId] = make(map[string]*Verdict)
+	}
+	l[category][colId][key] = verdict
+}
+
+func (l VerdictLog) merge(other VerdictLog) {
+	for category, verdictsPerCol := range other {
+		for colId, verdicts := range verdictsPerCol {
+			for key, verdict := range verdicts {
+				l.add(category, colId, key, verdict)
+			}
+		}
+	}
+}
+
+func (l VerdictLog) encoded() VerdictLog {
+	encoded := make(VerdictLog)
+	for category, verdictsPerCol := range l {
+		for colId, verdicts := range verdictsPerCol {
+			for key, verdict := range verdicts {
+				encodedKey, _ := base.EncodeKey(key)
+				encoded.add(category, colId, encodedKey, verdict)
+			}
+		}
+	}
+	return encoded
+}
+
+// Asks the plugin, if any, about documents found to differ, and records its verdict. Returns true if they are
+// equivalent. Documents the plugin fails on are reported as a difference
+func (dw *DifferWorker) judge(srcColId, tgtColId uint32, key string, sourceResult, targetResult *GetResult, verdicts VerdictLog) bool {
+	verdictFunc := dw.differ.verdictFunc
+	if verdictFunc == nil {
+		return false
+	}
+	verdict, err := verdictFunc(newVerdictDocument(key, srcColId, sourceResult), newVerdictDocument(key, tgtColId, targetResult))
+	if err != nil {
+		dw.logger.Warnf("Verdict plugin failed on doc %v. It is reported as a difference. err: %v\n", key, err)
+		return false
+	}
+	if verdict == nil {
+		return false
+	}
+	if verdict.Equivalent {
+		verdicts.add(VerdictCategoryEquivalent, srcColId, key, verdict)
+		dw.differ.numKeysEquivalent.Add(1)
+		return true
+	}
+	if verdict.Annotation != "" {
+		verdicts.add(VerdictCategoryMismatch, srcColId, key, verdict)
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"xdcrDiffer/base"
+	"xdcrDiffer/stats"
+
+	gocbcore "github.com/couchbase/gocbcore/v10"
+	xdcrLog "github.com/couchbase/goxdcr/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerdictPlugin(t *testing.T) {
+	fmt.Println("============== Test case start: TestVerdictPlugin =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "verdicts")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	var judged []string
+	var judgedLock sync.Mutex
+	differ := &MutationDiffer{
+		logger:                xdcrLog.NewLogger("TestVerdictPlugin", xdcrLog.DefaultLoggerContext),
+		stateLock:             &sync.RWMutex{},
+		mutationDifferFileDir: dir,
+		numKeysEquivalent:     &stats.Counter{},
+	}
+	differ.SetVerdictFunc(func(source, target *VerdictDocument) (*Verdict, error) {
+		judgedLock.Lock()
+		judged = append(judged, fmt.Sprintf("%v:%v/%v", source.Key, source.CollectionId, target.CollectionId))
+		judgedLock.Unlock()
+		switch source.Key {
+		case "equivalent":
+			if string(source.Body) == "1.001" && string(target.Body) == "1.002" && source.Cas == 5 && target.RevId == 7 {
+				return &Verdict{Equivalent: true, Annotation: "within tolerance"}, nil
+			}
+		case "annotated":
+			return &Verdict{Annotation: "out of tolerance"}, nil
+		case "failed":
+			return nil, errors.New("plugin bug")
+		}
+		return nil, nil
+	})
+	dw := &DifferWorker{differ: differ, logger: differ.logger}
+
+	source := &GetResult{value: []byte("1.001"), fetchCas: 5}
+	target := &GetResult{value: []byte("1.002"), GetMetaResult: &gocbcore.GetMetaResult{Cas: 6, SeqNo: 7}}
+	verdicts := make(VerdictLog)
+	// Documents the plugin finds equivalent are not reported, and are recorded as such
+	assert.True(dw.judge(8, 9, "equivalent", source, target, verdicts))
+	// Differences are reported, with the annotation of the plugin if it gives one
+	assert.False(dw.judge(8, 9, "annotated", source, target, verdicts))
+	assert.False(dw.judge(8, 9, "unannotated", source, target, verdicts))
+	// A plugin failure leaves the documents reported as a difference
+	assert.False(dw.judge(8, 9, "failed", source, target, verdicts))
+	assert.Equal([]string{"equivalent:8/9", "annotated:8/9", "unannotated:8/9", "failed:8/9"}, judged)
+	assert.Equal(int64(1), differ.numKeysEquivalent.Value())
+
+	differ.addVerdicts(verdicts)
+	assert.Nil(differ.writeVerdicts())
+	verdictBytes, err := ioutil.ReadFile(filepath.Join(dir, base.MutationDiffVerdictsFileName))
+	assert.Nil(err)
+	var written VerdictLog
+	assert.Nil(json.Unmarshal(verdictBytes, &written))
+	assert.Equal(VerdictLog{
+		VerdictCategoryEquivalent: {8: {"equivalent": {Equivalent: true, Annotation: "within tolerance"}}},
+		VerdictCategoryMismatch:   {8: {"annotated": {Annotation: "out of tolerance"}}},
+	}, written)
+
+	// Without a plugin, nothing is judged
+	dw.differ = &MutationDiffer{}
+	assert.False(dw.judge(8, 9, "equivalent", source, target, verdicts))
+	assert.Len(judged, 4)
+	fmt.Println("============== Test case end: TestVerdictPlugin =================")
+}
+
+func TestLoadVerdictPlugin(t *testing.T) {
+	fmt.Println("============== Test case start: TestLoadVerdictPlugin =================")
+	assert := assert.New(t)
+
+	_, err := LoadVerdictPlugin(filepath.Join(os.TempDir(), "noSuchVerdictPlugin.so"))
+	assert.NotNil(err)
+	// Binaries that cannot load plugins say so, rather than failing on the file
+	assert.Equal(!VerdictPluginsSupported, errors.Is(err, ErrVerdictPluginsUnsupported))
+	fmt.Println("============== Test case end: TestLoadVerdictPlugin =================")
+}
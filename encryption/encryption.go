@@ -0,0 +1,384 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package encryption encrypts the output of a run at rest with AES-256-GCM, and decrypts it for viewing.
+//
+// An encrypted file is the magic, the salt the key was derived with, if derived from a passphrase, and a nonce
+// prefix, followed by the content in chunks. Each chunk is sealed on its own, with its index and whether it is the
+// last one in the nonce and the additional data, so that chunks cannot be reordered, and a file cannot be truncated
+// at a chunk boundary, without failing authentication
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Suffix of encrypted files, which replace the files they were encrypted from
+const FileSuffix = ".enc"
+
+const (
+	KeyLength    = 32
+	saltLength   = 16
+	prefixLength = 8
+	chunkSize    = 64 * 1024
+	// Iterations of PBKDF2-HMAC-SHA512 to derive a key from a passphrase
+	kdfIterations = 210000
+)
+
+var magic = []byte("xdcrDEnc\x01")
+
+var ErrNotEncrypted = errors.New("not an encrypted file")
+
+// The key to encrypt and decrypt with. Keys derived from a passphrase are derived once per salt
+type Key struct {
+	// Set for raw keys
+	raw []byte
+	// Set for keys derived from a passphrase. Files are encrypted with the key derived with salt
+	passphrase []byte
+	salt       []byte
+	mtx        sync.Mutex
+	// salt -> derived key
+	derived map[string][]byte
+}
+
+// Takes a raw key of KeyLength bytes, given as is or encoded in hex or base64, i.e. as written by a KMS
+func NewKey(key []byte) (*Key, error) {
+	trimmed := bytes.TrimSpace(key)
+	if decoded, err := hex.DecodeString(string(trimmed)); err == nil && len(decoded) == KeyLength {
+		return &Key{raw: decoded}, nil
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(string(trimmed)); err == nil && len(decoded) == KeyLength {
+		return &Key{raw: decoded}, nil
+	}
+	if len(key) == KeyLength {
+		return &Key{raw: key}, nil
+	}
+	return nil, fmt.Errorf("a key has to be %v bytes, as is or encoded in hex or base64", KeyLength)
+}
+
+func NewPassphraseKey(passphrase string) (*Key, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("the passphrase is empty")
+	}
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return &Key{passphrase: []byte(passphrase), salt: salt, derived: make(map[string][]byte)}, nil
+}
+
+// The AES key for files encrypted with salt. Salt is nil for raw keys
+func (k *Key) aesKey(salt []byte) ([]byte, error) {
+	if k.raw != nil {
+		if len(salt) != 0 {
+			return nil, fmt.Errorf("the file was encrypted with a passphrase, not a key")
+		}
+		return k.raw, nil
+	}
+	if len(salt) == 0 {
+		return nil, fmt.Errorf("the file was encrypted with a key, not a passphrase")
+	}
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+	if derived, exists := k.derived[string(salt)]; exists {
+		return derived, nil
+	}
+	derived := pbkdf2(sha512.New, k.passphrase, salt, kdfIterations, KeyLength)
+	k.derived[string(salt)] = derived
+	return derived, nil
+}
+
+// PBKDF2 as of RFC 8018, with HMAC of newHash as the pseudorandom function. Keys are derived with HMAC-SHA512
+func pbkdf2(newHash func() hash.Hash, password, salt []byte, iterations, keyLength int) []byte {
+	prf := hmac.New(newHash, password)
+	var derived []byte
+	for block := uint32(1); len(derived) < keyLength; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		var blockIndex [4]byte
+		binary.BigEndian.PutUint32(blockIndex[:], block)
+		prf.Write(blockIndex[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		derived = append(derived, t...)
+	}
+	return derived[:keyLength]
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, prefix...)
+	var indexBytes [4]byte
+	binary.BigEndian.PutUint32(indexBytes[:], index)
+	nonce = append(nonce, indexBytes[:]...)
+	if last {
+		// The top bit of the index marks the last chunk
+		nonce[prefixLength] |= 0x80
+	}
+	return nonce
+}
+
+// Writes the encryption of the content of in to out
+func (k *Key) Encrypt(out io.Writer, in io.Reader) error {
+	aesKey, err := k.aesKey(k.salt)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(aesKey)
+	if err != nil {
+		return err
+	}
+	prefix := make([]byte, prefixLength)
+	if _, err = rand.Read(prefix); err != nil {
+		return err
+	}
+
+	header := append([]byte(nil), magic...)
+	header = append(header, byte(len(k.salt)))
+	header = append(header, k.salt...)
+	header = append(header, prefix...)
+	if _, err = out.Write(header); err != nil {
+		return err
+	}
+
+	// A chunk is only sealed once the next one has been read, so that the last one is known to be the last
+	current := make([]byte, chunkSize)
+	next := make([]byte, chunkSize)
+	n, err := io.ReadFull(in, current)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	var sealed []byte
+	for index := uint32(0); ; index++ {
+		if index&0x80000000 != 0 {
+			return fmt.Errorf("the content is too large to encrypt")
+		}
+		var m int
+		last := n < chunkSize
+		if !last {
+			m, err = io.ReadFull(in, next)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			last = m == 0
+		}
+		nonce := chunkNonce(prefix, index, last)
+		sealed = aead.Seal(sealed[:0], nonce, current[:n], nonce)
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(sealed)))
+		if _, err = out.Write(length[:]); err != nil {
+			return err
+		}
+		if _, err = out.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		current, next = next, current
+		n = m
+	}
+}
+
+// Writes the decryption of the content of in to out. Nothing is written for a chunk that fails authentication
+func (k *Key) Decrypt(out io.Writer, in io.Reader) error {
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(in, header); err != nil || !bytes.Equal(header[:len(magic)], magic) {
+		return ErrNotEncrypted
+	}
+	salt := make([]byte, int(header[len(magic)]))
+	if _, err := io.ReadFull(in, salt); err != nil {
+		return ErrNotEncrypted
+	}
+	prefix := make([]byte, prefixLength)
+	if _, err := io.ReadFull(in, prefix); err != nil {
+		return ErrNotEncrypted
+	}
+	aesKey, err := k.aesKey(salt)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(aesKey)
+	if err != nil {
+		return err
+	}
+
+	var sealed, opened []byte
+	for index := uint32(0); ; index++ {
+		var length [4]byte
+		if _, err = io.ReadFull(in, length[:]); err != nil {
+			return fmt.Errorf("the file is truncated")
+		}
+		chunkLength := binary.BigEndian.Uint32(length[:])
+		if chunkLength > chunkSize+uint32(aead.Overhead()) {
+			return fmt.Errorf("chunk %v is corrupted", index)
+		}
+		if cap(sealed) < int(chunkLength) {
+			sealed = make([]byte, chunkLength)
+		}
+		sealed = sealed[:chunkLength]
+		if _, err = io.ReadFull(in, sealed); err != nil {
+			return fmt.Errorf("the file is truncated")
+		}
+		// The last chunk is the one that opens with the last chunk nonce
+		last := false
+		nonce := chunkNonce(prefix, index, false)
+		opened, err = aead.Open(opened[:0], nonce, sealed, nonce)
+		if err != nil {
+			nonce = chunkNonce(prefix, index, true)
+			if opened, err = aead.Open(opened[:0], nonce, sealed, nonce); err != nil {
+				return fmt.Errorf("chunk %v fails authentication. Either the key is wrong or the file was tampered with", index)
+			}
+			last = true
+		}
+		if _, err = out.Write(opened); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// Replaces the file with its encryption, named with FileSuffix
+func (k *Key) EncryptFile(path string) error {
+	return transformFile(path, path+FileSuffix, k.Encrypt)
+}
+
+// Writes the decryption of the encrypted file to outPath. The encrypted file is kept
+func (k *Key) DecryptFile(path, outPath string) error {
+	return transformFile(path, outPath, k.Decrypt)
+}
+
+// The output is written to a temporary file and renamed into place, so that an interrupted run leaves either
+// the input or the output, but never a partial output
+func transformFile(inPath, outPath string, transform func(out io.Writer, in io.Reader) error) error {
+	in, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	tmpPath := outPath + ".tmp"
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	err = transform(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, outPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("%v: %v", inPath, err)
+	}
+	return nil
+}
+
+// Encrypts every file under the path, or the path itself if it is a file, that is not encrypted yet.
+// Returns the number of files encrypted. Paths that do not exist are skipped
+func (k *Key) EncryptTree(root string) (int, error) {
+	var count int
+	err := walkFiles(root, func(path string) error {
+		if strings.HasSuffix(path, FileSuffix) {
+			return nil
+		}
+		if err := k.EncryptFile(path); err != nil {
+			return err
+		}
+		count++
+		return os.Remove(path)
+	})
+	return count, err
+}
+
+// Decrypts every encrypted file under the path, or the path itself if it is a file. Decrypted files are written
+// next to the encrypted ones, or under outDir with the same layout relative to root if given. Encrypted files are
+// removed once decrypted in place. Returns the number of files decrypted
+func (k *Key) DecryptTree(root, outDir string) (int, error) {
+	var count int
+	err := walkFiles(root, func(path string) error {
+		if !strings.HasSuffix(path, FileSuffix) {
+			return nil
+		}
+		outPath := strings.TrimSuffix(path, FileSuffix)
+		if outDir != "" {
+			relPath, err := filepath.Rel(filepath.Dir(root), outPath)
+			if err != nil {
+				return err
+			}
+			outPath = filepath.Join(outDir, relPath)
+			if err = os.MkdirAll(filepath.Dir(outPath), 0777); err != nil {
+				return err
+			}
+		}
+		if err := k.DecryptFile(path, outPath); err != nil {
+			return err
+		}
+		count++
+		if outDir == "" {
+			return os.Remove(path)
+		}
+		return nil
+	})
+	return count, err
+}
+
+func walkFiles(root string, visit func(path string) error) error {
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		return visit(path)
+	})
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package encryption
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	fmt.Println("============== Test case start: TestEncryptDecrypt =================")
+	assert := assert.New(t)
+
+	key, err := NewKey([]byte(hex.EncodeToString(bytes.Repeat([]byte{7}, KeyLength)) + "\n"))
+	assert.Nil(err)
+	_, err = NewKey([]byte("too short"))
+	assert.NotNil(err)
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, 3*chunkSize + 5} {
+		plain := bytes.Repeat([]byte("xdcr"), size/4+1)[:size]
+		var encrypted bytes.Buffer
+		assert.Nil(key.Encrypt(&encrypted, bytes.NewReader(plain)))
+		assert.False(size > 16 && bytes.Contains(encrypted.Bytes(), plain))
+
+		var decrypted bytes.Buffer
+		assert.Nil(key.Decrypt(&decrypted, bytes.NewReader(encrypted.Bytes())))
+		assert.True(bytes.Equal(plain, decrypted.Bytes()))
+
+		// Truncated at a chunk boundary or tampered with
+		if size > chunkSize {
+			truncated := encrypted.Bytes()[:len(magic)+1+prefixLength+4+chunkSize+16]
+			assert.NotNil(key.Decrypt(ioutil.Discard, bytes.NewReader(truncated)))
+		}
+		tampered := append([]byte(nil), encrypted.Bytes()...)
+		tampered[len(tampered)-1] ^= 1
+		assert.NotNil(key.Decrypt(ioutil.Discard, bytes.NewReader(tampered)))
+	}
+
+	otherKey, _ := NewKey(bytes.Repeat([]byte{8}, KeyLength))
+	var encrypted bytes.Buffer
+	assert.Nil(key.Encrypt(&encrypted, bytes.NewReader([]byte("secret"))))
+	assert.NotNil(otherKey.Decrypt(ioutil.Discard, bytes.NewReader(encrypted.Bytes())))
+	assert.Equal(ErrNotEncrypted, key.Decrypt(ioutil.Discard, bytes.NewReader([]byte("plain text"))))
+
+	fmt.Println("============== Test case end: TestEncryptDecrypt =================")
+}
+
+func TestPassphraseTree(t *testing.T) {
+	fmt.Println("============== Test case start: TestPassphraseTree =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "encryption")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "fileDiff")
+	assert.Nil(os.MkdirAll(filepath.Join(root, "sub"), 0777))
+	assert.Nil(ioutil.WriteFile(filepath.Join(root, "diffKeys"), []byte(`{"0":["key"]}`), 0644))
+	assert.Nil(ioutil.WriteFile(filepath.Join(root, "sub", "diffDetails_0"), []byte("details"), 0644))
+
+	key, err := NewPassphraseKey("correct horse")
+	assert.Nil(err)
+	count, err := key.EncryptTree(root)
+	assert.Nil(err)
+	assert.Equal(2, count)
+	_, err = os.Stat(filepath.Join(root, "diffKeys"))
+	assert.True(os.IsNotExist(err))
+	// Already encrypted files are left alone
+	count, err = key.EncryptTree(root)
+	assert.Nil(err)
+	assert.Equal(0, count)
+
+	// Another key from the same passphrase derives the same key from the salt in the files
+	sameKey, err := NewPassphraseKey("correct horse")
+	assert.Nil(err)
+	outDir := filepath.Join(dir, "decrypted")
+	count, err = sameKey.DecryptTree(root, outDir)
+	assert.Nil(err)
+	assert.Equal(2, count)
+	content, err := ioutil.ReadFile(filepath.Join(outDir, "fileDiff", "sub", "diffDetails_0"))
+	assert.Nil(err)
+	assert.Equal("details", string(content))
+
+	wrongKey, err := NewPassphraseKey("wrong")
+	assert.Nil(err)
+	_, err = wrongKey.DecryptTree(root, "")
+	assert.NotNil(err)
+
+	count, err = key.DecryptTree(root, "")
+	assert.Nil(err)
+	assert.Equal(2, count)
+	content, err = ioutil.ReadFile(filepath.Join(root, "diffKeys"))
+	assert.Nil(err)
+	assert.Equal(`{"0":["key"]}`, string(content))
+
+	fmt.Println("============== Test case end: TestPassphraseTree =================")
+}
+
+func TestPBKDF2(t *testing.T) {
+	fmt.Println("============== Test case start: TestPBKDF2 =================")
+	assert := assert.New(t)
+
+	// HMAC-SHA1 vectors of RFC 6070, but for the one of 16777216 iterations
+	sha1Vectors := []struct {
+		password   string
+		salt       string
+		iterations int
+		derived    string
+	}{
+		{"password", "salt", 1, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{"password", "salt", 2, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{"password", "salt", 4096, "4b007901b765489abead49d926f721d065a429c1"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038"},
+		{"pass\x00word", "sa\x00lt", 4096, "56fa6aa75548099dcc37d7f03425e0c3"},
+	}
+	for _, vector := range sha1Vectors {
+		derived := pbkdf2(sha1.New, []byte(vector.password), []byte(vector.salt), vector.iterations, len(vector.derived)/2)
+		assert.Equal(vector.derived, hex.EncodeToString(derived))
+	}
+
+	// The same inputs with HMAC-SHA512, as keys are derived with, over more than one block
+	derived := pbkdf2(sha512.New, []byte("password"), []byte("salt"), 1, 64)
+	assert.Equal("867f70cf1ade02cff3752599a3a53dc4af34c7a669815ae5d513554e1c8cf252c02d470a285a0501bad999bfe943c08f050235d7d68b1da55e63f73b60a57fce",
+		hex.EncodeToString(derived))
+	derived = pbkdf2(sha512.New, []byte("passwordPASSWORDpassword"), []byte("saltSALTsaltSALTsaltSALTsaltSALTsalt"), 4096, 80)
+	assert.Equal("8c0511f4c6e597c6ac6315d8f0362e225f3c501495ba23b868c005174dc4ee71115b59f9e60cd9532fa33e0f75aefe30225c583a186cd82bd4daea9724a3d3b804f75bdd41494fa324cab24bcc680fb3",
+		hex.EncodeToString(derived))
+	fmt.Println("============== Test case end: TestPBKDF2 =================")
+}
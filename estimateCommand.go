@@ -0,0 +1,156 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+	"xdcrDiffer/base"
+	"xdcrDiffer/dcp"
+	"xdcrDiffer/estimator"
+	"xdcrDiffer/stats"
+	"xdcrDiffer/utils"
+)
+
+const estimateCommand = "estimate"
+
+// Assumed round trip of a mutation differ batch, as the probe does not fetch documents
+const estimateMutationDifferBatchTime = 20 * time.Millisecond
+
+// The estimate subcommand predicts how long a run with the same options would take, and what it would cost, i.e.
+//
+//	xdcrDiffer estimate -sourceUrl ... -estimateProbeSecs 30 -linkBandwidthMBps 100
+//
+// It takes the options of a full run, and captures both clusters for a short while into a temporary directory
+// Capture directories are redirected before they are set up, so that the probe leaves nothing behind
+func prepareEstimate() (string, error) {
+	probeDir, err := ioutil.TempDir("", "xdcrDifferEstimate")
+	if err != nil {
+		return "", err
+	}
+	options.sourceFileDir = filepath.Join(probeDir, base.SourceFileDir)
+	options.targetFileDir = filepath.Join(probeDir, base.TargetFileDir)
+	options.checkpointFileDir = filepath.Join(probeDir, base.CheckpointFileDir)
+	options.oldSourceCheckpointFileName = ""
+	options.oldTargetCheckpointFileName = ""
+	options.newCheckpointFileName = ""
+	options.completeBySeqno = false
+	options.completeByDuration = options.estimateProbeSecs
+	options.monitor = false
+	return probeDir, nil
+}
+
+func (difftool *xdcrDiffTool) runEstimate(probeDir string) error {
+	defer os.RemoveAll(probeDir)
+
+	// Capture of both clusters overlaps for the duration of the probe, and each carries on for the delay in between
+	probeDuration := time.Duration(options.estimateProbeSecs+options.delayBetweenSourceAndTarget) * time.Second
+	if err := difftool.generateDataFiles(); err != nil {
+		return err
+	}
+
+	source, err := probeCluster(difftool.sourceDcpDriver, base.SourceClusterLabel, options.sourceFileDir, probeDuration)
+	if err != nil {
+		return err
+	}
+	target, err := probeCluster(difftool.targetDcpDriver, base.TargetClusterLabel, options.targetFileDir, probeDuration)
+	if err != nil {
+		return err
+	}
+	// The differ runs next to the source cluster, as enforceTLS requires
+	target.OverLink = true
+	for _, cluster := range difftool.readUnitBudget.Clusters() {
+		source.Capella = source.Capella || cluster == source.Name
+		target.Capella = target.Capella || cluster == target.Name
+	}
+
+	settings := &estimator.Settings{
+		VbucketFraction:             1,
+		LinkBandwidth:               options.linkBandwidthMBps * 1024 * 1024,
+		DiffFraction:                options.estimateDiffPercent / 100,
+		MutationDifferWorkers:       int(options.numberOfWorkersForMutationDiffer),
+		MutationDifferBatchSize:     int(options.mutationDifferBatchSize),
+		MutationDifferOpsPerKey:     mutationDifferOpsPerKey(options.compareType),
+		MutationDifferBodyOpsPerKey: mutationDifferBodyOpsPerKey(options.compareType),
+		MutationDifferBatchTime:     estimateMutationDifferBatchTime,
+		ReadUnitPrice:               options.readUnitPrice,
+		MaxReadUnitSpend:            options.maxReadUnitSpend,
+	}
+	if len(difftool.vbuckets) > 0 {
+		settings.VbucketFraction = float64(len(difftool.vbuckets)) / base.NumberOfVbuckets
+	}
+	if !options.runMutationDiffer {
+		settings.DiffFraction = 0
+	}
+
+	estimate, err := estimator.NewEstimate(source, target, settings)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(estimate)
+}
+
+func probeCluster(driver *dcp.DcpDriver, name, fileDir string, probeDuration time.Duration) (*estimator.ClusterProbe, error) {
+	probe := &estimator.ClusterProbe{
+		Name:          name,
+		ProbeItems:    uint64(stats.Default.Counter(fmt.Sprintf(stats.DcpDocsReceived, name)).Value()),
+		ProbeDuration: probeDuration,
+	}
+
+	bucketInfo, err := driver.BucketInfo()
+	if err != nil {
+		return nil, fmt.Errorf("%v: unable to get bucket info: %v", name, err)
+	}
+	_, probe.ItemCount, err = utils.GetBucketSizeFromBucketInfo(name, bucketInfo)
+	if err != nil {
+		return nil, err
+	}
+	probe.DataUsed, probe.OpsPerSec, probe.Nodes, err = utils.GetBucketUsageFromBucketInfo(name, bucketInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.Walk(fileDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			probe.ProbeBytes += uint64(info.Size())
+		}
+		return nil
+	})
+	return probe, err
+}
+
+// The mutation differ gets the document, its metadata and its HLV, depending on what it compares
+func mutationDifferOpsPerKey(compareType string) int {
+	switch compareType {
+	case base.MutationCompareTypeBodyOnly:
+		return 1
+	case base.MutationCompareTypeBodyAndMeta:
+		return 3
+	default:
+		return 2
+	}
+}
+
+// Of which only the get of the document reads its body
+func mutationDifferBodyOpsPerKey(compareType string) int {
+	if compareType == base.MutationCompareTypeMetadata {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package estimator
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"xdcrDiffer/base"
+)
+
+// Below this many documents captured by a probe, the rate it measured is not representative
+const MinProbeItems = 1000
+
+// What is known of a cluster before a run, and what a short capture of it measured
+type ClusterProbe struct {
+	Name string
+	// Of the whole bucket, from its basic stats
+	ItemCount uint64
+	DataUsed  uint64
+	Nodes     int
+	OpsPerSec float64
+	// Documents captured, and bytes of capture files written, during the probe
+	ProbeItems    uint64
+	ProbeBytes    uint64
+	ProbeDuration time.Duration
+	// Whether the bucket is streamed over the link between the clusters, rather than from the local cluster
+	OverLink bool
+	// Whether the cluster is a Capella one, whose reads are billed by read units
+	Capella bool
+}
+
+// How the run would be configured
+type Settings struct {
+	// Share of the vbuckets that would be compared. 1 for the whole bucket
+	VbucketFraction float64
+	// Bytes per second. Unknown if 0
+	LinkBandwidth float64
+	// Expected share of documents with differences, each of which the mutation differ fetches from both clusters
+	DiffFraction            float64
+	MutationDifferWorkers   int
+	MutationDifferBatchSize int
+	// KV operations per key and cluster, which depend on what the mutation differ compares, and how many of them
+	// read the body
+	MutationDifferOpsPerKey     int
+	MutationDifferBodyOpsPerKey int
+	MutationDifferBatchTime     time.Duration
+	// Of a million read units. Unknown if 0
+	ReadUnitPrice float64
+	// Most to spend on read units, in the currency of ReadUnitPrice. No limit if 0
+	MaxReadUnitSpend float64
+}
+
+type ClusterEstimate struct {
+	Name            string
+	Items           uint64
+	CaptureDuration time.Duration
+	// Whether capture is bound by the link bandwidth rather than by the rate measured by the probe
+	LinkBound bool
+	// Capture files written
+	DiskUsage uint64
+	// DCP load on each node while capturing, next to the load of the bucket before the probe
+	DcpItemsPerSecPerNode   float64
+	BucketOpsPerSecPerNode  float64
+	MutationDifferOps       uint64
+	MutationDifferOpsPerSec float64
+	// Of a Capella cluster, the read units capture and the mutation differ would use. Documents are taken to be of
+	// the average size the probe captured, so that the units of buckets whose sizes vary widely are underestimated
+	CaptureReadUnits        uint64 `json:",omitempty"`
+	MutationDifferReadUnits uint64 `json:",omitempty"`
+}
+
+type Estimate struct {
+	Source *ClusterEstimate
+	Target *ClusterEstimate
+	// Both clusters are captured at the same time
+	CaptureDuration        time.Duration
+	MutationDifferDuration time.Duration
+	// Excludes the file differ, whose duration depends on the CPUs and disk of this machine
+	Duration  time.Duration
+	DiskUsage uint64
+	// Of the Capella clusters, and what they would cost given the price of read units
+	ReadUnits    uint64   `json:",omitempty"`
+	ReadUnitCost float64  `json:",omitempty"`
+	Warnings     []string `json:",omitempty"`
+}
+
+// Extrapolates what the probe measured to the whole run
+func NewEstimate(source, target *ClusterProbe, settings *Settings) (*Estimate, error) {
+	estimate := &Estimate{}
+	var err error
+	if estimate.Source, err = estimateCluster(source, settings, estimate); err != nil {
+		return nil, err
+	}
+	if estimate.Target, err = estimateCluster(target, settings, estimate); err != nil {
+		return nil, err
+	}
+
+	estimate.CaptureDuration = estimate.Source.CaptureDuration
+	if estimate.Target.CaptureDuration > estimate.CaptureDuration {
+		estimate.CaptureDuration = estimate.Target.CaptureDuration
+	}
+	estimate.DiskUsage = estimate.Source.DiskUsage + estimate.Target.DiskUsage
+
+	// Keys with differences on either side are fetched from both
+	keys := estimate.Source.Items
+	if estimate.Target.Items > keys {
+		keys = estimate.Target.Items
+	}
+	keys = uint64(math.Ceil(float64(keys) * settings.DiffFraction))
+	if keys > 0 && settings.MutationDifferBatchSize > 0 && settings.MutationDifferWorkers > 0 {
+		batches := math.Ceil(float64(keys) / float64(settings.MutationDifferBatchSize))
+		rounds := math.Ceil(batches / float64(settings.MutationDifferWorkers))
+		estimate.MutationDifferDuration = time.Duration(rounds) * settings.MutationDifferBatchTime
+		for i, cluster := range []*ClusterEstimate{estimate.Source, estimate.Target} {
+			cluster.MutationDifferOps = keys * uint64(settings.MutationDifferOpsPerKey)
+			if estimate.MutationDifferDuration > 0 {
+				cluster.MutationDifferOpsPerSec = float64(cluster.MutationDifferOps) / estimate.MutationDifferDuration.Seconds()
+			}
+			if probe := []*ClusterProbe{source, target}[i]; probe.Capella {
+				// Operations that do not read the body read a unit's worth of metadata
+				bodyOps := uint64(settings.MutationDifferBodyOpsPerKey)
+				cluster.MutationDifferReadUnits = keys * (uint64(settings.MutationDifferOpsPerKey) - bodyOps +
+					bodyOps*itemReadUnits(probe))
+			}
+		}
+	}
+	estimate.Duration = estimate.CaptureDuration + estimate.MutationDifferDuration
+	estimateReadUnits(estimate, settings)
+	return estimate, nil
+}
+
+func estimateReadUnits(estimate *Estimate, settings *Settings) {
+	for _, cluster := range []*ClusterEstimate{estimate.Source, estimate.Target} {
+		estimate.ReadUnits += cluster.CaptureReadUnits + cluster.MutationDifferReadUnits
+	}
+	if estimate.ReadUnits == 0 || settings.ReadUnitPrice <= 0 {
+		return
+	}
+	estimate.ReadUnitCost = float64(estimate.ReadUnits) / 1000000 * settings.ReadUnitPrice
+	if settings.MaxReadUnitSpend > 0 && estimate.ReadUnitCost > settings.MaxReadUnitSpend {
+		estimate.Warnings = append(estimate.Warnings, fmt.Sprintf("The read units would cost %.2f, more than maxReadUnitSpend %.2f. The run would be aborted once it has spent that",
+			estimate.ReadUnitCost, settings.MaxReadUnitSpend))
+	}
+}
+
+func estimateCluster(probe *ClusterProbe, settings *Settings, estimate *Estimate) (*ClusterEstimate, error) {
+	if probe.ProbeItems == 0 || probe.ProbeDuration <= 0 {
+		return nil, fmt.Errorf("%v: the probe did not capture any documents", probe.Name)
+	}
+	if probe.ProbeItems < MinProbeItems && probe.ProbeItems < probe.ItemCount {
+		estimate.Warnings = append(estimate.Warnings, fmt.Sprintf("%v: the probe captured only %v documents. Use a longer probe for a better estimate",
+			probe.Name, probe.ProbeItems))
+	}
+
+	cluster := &ClusterEstimate{
+		Name:  probe.Name,
+		Items: uint64(float64(probe.ItemCount) * settings.VbucketFraction),
+	}
+	rate := float64(probe.ProbeItems) / probe.ProbeDuration.Seconds()
+	cluster.CaptureDuration = time.Duration(float64(cluster.Items) / rate * float64(time.Second))
+	if probe.OverLink && settings.LinkBandwidth > 0 {
+		linkDuration := time.Duration(float64(probe.DataUsed) * settings.VbucketFraction / settings.LinkBandwidth * float64(time.Second))
+		if linkDuration > cluster.CaptureDuration {
+			cluster.CaptureDuration = linkDuration
+			cluster.LinkBound = true
+		}
+	}
+	cluster.DiskUsage = uint64(float64(probe.ProbeBytes) / float64(probe.ProbeItems) * float64(cluster.Items))
+	if probe.Capella {
+		cluster.CaptureReadUnits = cluster.Items * itemReadUnits(probe)
+	}
+
+	nodes := probe.Nodes
+	if nodes < 1 {
+		nodes = 1
+	}
+	if cluster.CaptureDuration > 0 {
+		cluster.DcpItemsPerSecPerNode = float64(cluster.Items) / cluster.CaptureDuration.Seconds() / float64(nodes)
+	}
+	cluster.BucketOpsPerSecPerNode = probe.OpsPerSec / float64(nodes)
+	return cluster, nil
+}
+
+// Of a document of the average size the probe captured
+func itemReadUnits(probe *ClusterProbe) uint64 {
+	return uint64(base.ReadUnits(int(probe.ProbeBytes / probe.ProbeItems)))
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package estimator
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEstimate(t *testing.T) {
+	fmt.Println("============== Test case start: TestNewEstimate =================")
+	assert := assert.New(t)
+
+	source := &ClusterProbe{Name: "source", ItemCount: 1000000, DataUsed: 1 << 30, Nodes: 2,
+		ProbeItems: 100000, ProbeBytes: 10000000, ProbeDuration: 10 * time.Second}
+	target := &ClusterProbe{Name: "target", ItemCount: 1000000, DataUsed: 1 << 30, Nodes: 2,
+		ProbeItems: 50000, ProbeBytes: 5000000, ProbeDuration: 10 * time.Second, OverLink: true}
+	settings := &Settings{
+		VbucketFraction:         0.5,
+		DiffFraction:            0.01,
+		MutationDifferWorkers:   10,
+		MutationDifferBatchSize: 100,
+		MutationDifferOpsPerKey: 2,
+		MutationDifferBatchTime: 20 * time.Millisecond,
+	}
+
+	estimate, err := NewEstimate(source, target, settings)
+	assert.Nil(err)
+	assert.Equal(50*time.Second, estimate.Source.CaptureDuration)
+	assert.Equal(100*time.Second, estimate.Target.CaptureDuration)
+	assert.Equal(100*time.Second, estimate.CaptureDuration)
+	assert.Equal(uint64(100000000), estimate.DiskUsage)
+	assert.Equal(float64(2500), estimate.Target.DcpItemsPerSecPerNode)
+	// 5000 keys are 50 batches, 5 rounds of 10 workers
+	assert.Equal(100*time.Millisecond, estimate.MutationDifferDuration)
+	assert.Equal(uint64(10000), estimate.Source.MutationDifferOps)
+	assert.Empty(estimate.Warnings)
+
+	// 512MiB over 1MiB/s is slower than the probe
+	settings.LinkBandwidth = 1 << 20
+	estimate, err = NewEstimate(source, target, settings)
+	assert.Nil(err)
+	assert.True(estimate.Target.LinkBound)
+	assert.False(estimate.Source.LinkBound)
+	assert.Equal(512*time.Second, estimate.CaptureDuration)
+
+	target.ProbeItems = 0
+	_, err = NewEstimate(source, target, settings)
+	assert.NotNil(err)
+	fmt.Println("============== Test case end: TestNewEstimate =================")
+}
+
+func TestReadUnitEstimate(t *testing.T) {
+	fmt.Println("============== Test case start: TestReadUnitEstimate =================")
+	assert := assert.New(t)
+
+	source := &ClusterProbe{Name: "source", ItemCount: 1000000, DataUsed: 1 << 30, Nodes: 2,
+		ProbeItems: 100000, ProbeBytes: 10000000, ProbeDuration: 10 * time.Second}
+	// Documents of 10000 bytes are 3 read units each
+	target := &ClusterProbe{Name: "target", ItemCount: 1000000, DataUsed: 1 << 30, Nodes: 2,
+		ProbeItems: 50000, ProbeBytes: 500000000, ProbeDuration: 10 * time.Second, Capella: true}
+	settings := &Settings{
+		VbucketFraction:             0.5,
+		DiffFraction:                0.01,
+		MutationDifferWorkers:       10,
+		MutationDifferBatchSize:     100,
+		MutationDifferOpsPerKey:     3,
+		MutationDifferBodyOpsPerKey: 1,
+		MutationDifferBatchTime:     20 * time.Millisecond,
+	}
+
+	estimate, err := NewEstimate(source, target, settings)
+	assert.Nil(err)
+	assert.Equal(uint64(0), estimate.Source.CaptureReadUnits)
+	assert.Equal(uint64(1500000), estimate.Target.CaptureReadUnits)
+	// 5000 keys, each of which takes 2 units of metadata and 3 of body
+	assert.Equal(uint64(25000), estimate.Target.MutationDifferReadUnits)
+	assert.Equal(uint64(1525000), estimate.ReadUnits)
+	assert.Equal(float64(0), estimate.ReadUnitCost)
+
+	settings.ReadUnitPrice = 0.5
+	settings.MaxReadUnitSpend = 0.5
+	estimate, err = NewEstimate(source, target, settings)
+	assert.Nil(err)
+	assert.Equal(0.7625, estimate.ReadUnitCost)
+	assert.Len(estimate.Warnings, 1)
+	fmt.Println("============== Test case end: TestReadUnitEstimate =================")
+}
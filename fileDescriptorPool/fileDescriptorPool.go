@@ -122,6 +122,8 @@ func (fdp *FdPool) DeRegisterFileHandle(fileName string) error {
 	if fd, ok = fdp.fdMap[fileName]; !ok {
 		return fmt.Errorf("FileName %v has not been registered", fileName)
 	}
+	// Allow the same file to be registered again, i.e. after it has been re-generated
+	delete(fdp.fdMap, fileName)
 	return fd.Close()
 }
 
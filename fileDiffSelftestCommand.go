@@ -0,0 +1,220 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"xdcrDiffer/base"
+	"xdcrDiffer/dcp"
+	"xdcrDiffer/differ"
+	"xdcrDiffer/results"
+	"xdcrDiffer/utils"
+
+	"github.com/couchbase/gomemcached"
+	xdcrBase "github.com/couchbase/goxdcr/base"
+	xdcrLog "github.com/couchbase/goxdcr/log"
+)
+
+const fileDiffSelftestCommand = "filediff-selftest"
+
+// Documents of the default collection, which is all the self test uses
+const selftestColId uint32 = 0
+
+// A document as kept by a mock bucket
+type mockDoc struct {
+	vbno  uint16
+	seqno uint64
+	revId uint64
+	cas   uint64
+	value []byte
+}
+
+// An in-memory bucket
+type mockBucket struct {
+	docs map[string]*mockDoc
+	// Last seqno of each vbucket
+	seqnos map[uint16]uint64
+}
+
+func newMockBucket() *mockBucket {
+	return &mockBucket{docs: make(map[string]*mockDoc), seqnos: make(map[uint16]uint64)}
+}
+
+func (b *mockBucket) set(key string, revId, cas uint64, value []byte) {
+	vbno := utils.GetVbucketFromKey([]byte(key))
+	b.seqnos[vbno]++
+	b.docs[key] = &mockDoc{vbno: vbno, seqno: b.seqnos[vbno], revId: revId, cas: cas, value: value}
+}
+
+func (b *mockBucket) clone() *mockBucket {
+	clone := newMockBucket()
+	for key, doc := range b.docs {
+		docCopy := *doc
+		clone.docs[key] = &docCopy
+	}
+	for vbno, seqno := range b.seqnos {
+		clone.seqnos[vbno] = seqno
+	}
+	return clone
+}
+
+// Writes the documents of the bucket to capture files, as if they had been streamed over DCP
+func (b *mockBucket) capture(fileDir string, vbuckets []uint16, numberOfBins int, logger *xdcrLog.CommonLogger) error {
+	mutations := make(map[uint16][]*dcp.Mutation)
+	for key, doc := range b.docs {
+		mutations[doc.vbno] = append(mutations[doc.vbno], dcp.CreateMutation(doc.vbno, []byte(key), doc.seqno, doc.revId,
+			doc.cas, 0, 0, gomemcached.UPR_MUTATION, doc.value, base.JSONDataType, selftestColId, &xdcrBase.XattrIterator{}, nil))
+	}
+	for _, vbno := range vbuckets {
+		if err := dcp.WriteCaptureFiles(fileDir, vbno, numberOfBins, mutations[vbno], logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validates the installation and the comparison logic of the file differ, i.e.
+//
+//	xdcrDiffer filediff-selftest -documents 10000
+//
+// Two mock buckets are filled with the same documents, divergences of every kind are injected into the target, and
+// the documents are written to capture files and compared by the file differ as in a real run. The differences found
+// have to be exactly the ones injected. No cluster is involved, so DCP streaming and the mutation differ are not
+// exercised, see seedDocuments for a check of the whole tool
+func runFileDiffSelftestCommand(args []string) error {
+	flags := flag.NewFlagSet(fileDiffSelftestCommand, flag.ExitOnError)
+	numberOfDocs := flags.Int("documents", 10000, "number of documents in the mock source bucket")
+	divergences := flags.Int("divergences", 10, "number of divergences of each kind to inject")
+	numberOfBins := flags.Int("numberOfBins", 5, "number of capture files per vbucket")
+	keep := flags.Bool("keep", false, "whether to keep the capture files and the file differ output for inspection")
+	flags.Parse(args)
+
+	if *divergences <= 0 || *numberOfDocs < 3**divergences {
+		return fmt.Errorf("%v documents are not enough to inject %v divergences of each kind", *numberOfDocs, *divergences)
+	}
+
+	dir, err := ioutil.TempDir("", fileDiffSelftestCommand)
+	if err != nil {
+		return err
+	}
+	if *keep {
+		fmt.Printf("Self test files are kept in %v\n", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+	logger := xdcrLog.NewLogger("xdcrDiffTool", xdcrLog.DefaultLoggerContext)
+
+	source := newMockBucket()
+	for i := 0; i < *numberOfDocs; i++ {
+		source.set(fmt.Sprintf("doc_%v", i), 1, uint64(1600000000000000000+i), []byte(fmt.Sprintf(`{"id":%v}`, i)))
+	}
+	target := source.clone()
+
+	// Category or subcategory -> keys injected
+	expected := make(map[string][]string)
+	for i := 0; i < *divergences; i++ {
+		missingFromTarget := fmt.Sprintf("doc_%v", i)
+		delete(target.docs, missingFromTarget)
+		expected["MissingFromTarget"] = append(expected["MissingFromTarget"], missingFromTarget)
+
+		missingFromSource := fmt.Sprintf("targetOnly_%v", i)
+		target.set(missingFromSource, 1, uint64(1700000000000000000+i), []byte(`{}`))
+		expected["MissingFromSource"] = append(expected["MissingFromSource"], missingFromSource)
+
+		// Same metadata, as if the body had been changed on the target without going through KV
+		bodyDiffers := fmt.Sprintf("doc_%v", *divergences+i)
+		target.docs[bodyDiffers].value = []byte(`{"changed":true}`)
+		expected[base.MismatchCategoryBodyDiffers] = append(expected[base.MismatchCategoryBodyDiffers], bodyDiffers)
+
+		// A newer revision of the same body, as if a mutation was not replicated
+		metadataDiffers := fmt.Sprintf("doc_%v", 2**divergences+i)
+		target.docs[metadataDiffers].revId++
+		target.docs[metadataDiffers].cas++
+		expected[base.MismatchCategoryMetadataDiffers] = append(expected[base.MismatchCategoryMetadataDiffers], metadataDiffers)
+	}
+
+	vbucketSet := make(map[uint16]bool)
+	for _, bucket := range []*mockBucket{source, target} {
+		for _, doc := range bucket.docs {
+			vbucketSet[doc.vbno] = true
+		}
+	}
+	var vbuckets []uint16
+	for vbno := range vbucketSet {
+		vbuckets = append(vbuckets, vbno)
+	}
+	sort.Slice(vbuckets, func(i, j int) bool {
+		return vbuckets[i] < vbuckets[j]
+	})
+
+	sourceFileDir := filepath.Join(dir, base.SourceFileDir)
+	targetFileDir := filepath.Join(dir, base.TargetFileDir)
+	fileDifferDir := filepath.Join(dir, base.FileDifferDir)
+	for _, fileDir := range []string{sourceFileDir, targetFileDir, fileDifferDir} {
+		if err = os.MkdirAll(fileDir, 0777); err != nil {
+			return err
+		}
+	}
+	if err = source.capture(sourceFileDir, vbuckets, *numberOfBins, logger); err != nil {
+		return fmt.Errorf("Unable to capture the mock source bucket: %v", err)
+	}
+	if err = target.capture(targetFileDir, vbuckets, *numberOfBins, logger); err != nil {
+		return fmt.Errorf("Unable to capture the mock target bucket: %v", err)
+	}
+
+	collectionMapping := map[uint32][]uint32{selftestColId: {selftestColId}}
+	driver := differ.NewDifferDriver(sourceFileDir, targetFileDir, fileDifferDir, base.DiffKeysFileName, 4, *numberOfBins, 0,
+		collectionMapping, nil, nil, "", "", nil, nil, logger, vbuckets)
+	if err = driver.Run(); err != nil {
+		return fmt.Errorf("File differ failed: %v", err)
+	}
+	if corruptedVbs := driver.CorruptedVbs(); len(corruptedVbs) > 0 {
+		return fmt.Errorf("Capture files of vbuckets %v were found to be corrupted", corruptedVbs)
+	}
+
+	var failures []string
+	if sourceItems, targetItems := driver.SourceItemCount(), driver.TargetItemCount(); sourceItems != int64(len(source.docs)) || targetItems != int64(len(target.docs)) {
+		failures = append(failures, fmt.Sprintf("file differ read %v source and %v target documents, but the mock buckets hold %v and %v",
+			sourceItems, targetItems, len(source.docs), len(target.docs)))
+	}
+	for _, category := range []string{"MissingFromTarget", "MissingFromSource", base.MismatchCategoryBodyDiffers, base.MismatchCategoryMetadataDiffers} {
+		query := &results.Query{Categories: []string{category}}
+		page, err := results.Run(results.PhaseFileDiff, filepath.Join(fileDifferDir, base.DiffDetailsFileName+base.FileNameDelimiter+"*"), query)
+		if err != nil {
+			return err
+		}
+		var found []string
+		for _, entry := range page.Entries {
+			found = append(found, entry.Key)
+		}
+		sort.Strings(found)
+		sort.Strings(expected[category])
+		if fmt.Sprint(found) != fmt.Sprint(expected[category]) {
+			failures = append(failures, fmt.Sprintf("%v: injected %v but found %v", category, expected[category], found))
+		} else {
+			fmt.Printf("%v: found all %v injected divergences\n", category, len(found))
+		}
+	}
+
+	if len(failures) > 0 {
+		for _, failure := range failures {
+			fmt.Printf("FAILED %v\n", failure)
+		}
+		return fmt.Errorf("File differ self test failed")
+	}
+	fmt.Printf("File differ self test passed: %v source and %v target documents in %v vbuckets compared as expected\n",
+		len(source.docs), len(target.docs), len(vbuckets))
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"xdcrDiffer/base"
+)
+
+// Hands vbuckets over to the file differ as soon as both clusters have finished capturing them,
+// so that comparing the capture files overlaps with streaming the remaining vbuckets
+type vbHandoff struct {
+	mtx sync.Mutex
+	// vbno -> names of the clusters that have finished capturing it
+	captured map[uint16]map[string]bool
+	// Vbuckets captured by both clusters. Never closed, as DCP handlers may still be winding down
+	ready chan uint16
+	// Closed once capture is over, after which any vbuckets not yet handed over are compared as well
+	done chan bool
+}
+
+func newVbHandoff() *vbHandoff {
+	return &vbHandoff{
+		captured: make(map[uint16]map[string]bool),
+		ready:    make(chan uint16, base.NumberOfVbuckets),
+		done:     make(chan bool),
+	}
+}
+
+// Called by the DCP driver of the given cluster as it finishes capturing each vbucket
+func (h *vbHandoff) observer(clusterName string) func(vbno uint16) {
+	if h == nil {
+		return nil
+	}
+	return func(vbno uint16) {
+		h.mtx.Lock()
+		defer h.mtx.Unlock()
+		if h.captured[vbno] == nil {
+			h.captured[vbno] = make(map[string]bool)
+		}
+		if h.captured[vbno][clusterName] {
+			return
+		}
+		h.captured[vbno][clusterName] = true
+		if len(h.captured[vbno]) == 2 {
+			h.ready <- vbno
+		}
+	}
+}
+
+func (h *vbHandoff) captureDone() {
+	close(h.done)
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+	"xdcrDiffer/base"
+
+	xdcrBase "github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/metadata"
+)
+
+// Who pauses and resumes the run on the health of the clusters, as logged
+const healthThrottleRequester = "health throttle"
+
+// The parts of /pools/default that the health of the nodes is taken from
+type poolHealth struct {
+	Nodes []struct {
+		SystemStats struct {
+			CpuUtilizationRate float64 `json:"cpu_utilization_rate"`
+			MemTotal           float64 `json:"mem_total"`
+			MemFree            float64 `json:"mem_free"`
+		} `json:"systemStats"`
+	} `json:"nodes"`
+}
+
+// The parts of the stats of a bucket that its health is taken from. Each stat holds the samples of the last minute
+type bucketHealth struct {
+	Op struct {
+		Samples struct {
+			DiskWriteQueue     []float64 `json:"disk_write_queue"`
+			ResidentItemsRatio []float64 `json:"vb_active_resident_items_ratio"`
+		} `json:"samples"`
+	} `json:"op"`
+}
+
+// Feeds the health of both clusters to the health throttle until stopCh is closed. A cluster whose stats cannot be
+// had is taken to be healthy for that poll, so that the run is not held back by a stats endpoint
+func (difftool *xdcrDiffTool) pollClusterHealth(interval time.Duration, stopCh chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+		var samples []*base.HealthSample
+		for _, cluster := range []struct {
+			label  string
+			ref    *metadata.RemoteClusterReference
+			bucket string
+		}{
+			{base.SourceClusterLabel, difftool.selfRef, difftool.specifiedSpec.SourceBucketName},
+			{base.TargetClusterLabel, difftool.specifiedRef, difftool.specifiedSpec.TargetBucketName},
+		} {
+			sample, err := difftool.getClusterHealth(cluster.label, cluster.ref, cluster.bucket)
+			if err != nil {
+				difftool.logger.Warnf("Unable to get the health stats of %v. err=%v\n", cluster.label, err)
+				continue
+			}
+			samples = append(samples, sample)
+		}
+		if action := difftool.healthThrottler.Observe(samples); action != "" {
+			fmt.Printf("Health throttle: %v\n", action)
+		}
+	}
+}
+
+func (difftool *xdcrDiffTool) getClusterHealth(label string, ref *metadata.RemoteClusterReference, bucketName string) (*base.HealthSample, error) {
+	sample := &base.HealthSample{Cluster: label}
+
+	pool := &poolHealth{}
+	if err := difftool.getClusterRestApi(ref, xdcrBase.DefaultPoolPath, pool); err != nil {
+		return nil, err
+	}
+	for _, node := range pool.Nodes {
+		stats := node.SystemStats
+		if stats.CpuUtilizationRate > sample.CpuPercent {
+			sample.CpuPercent = stats.CpuUtilizationRate
+		}
+		if stats.MemTotal > 0 {
+			if memoryPercent := 100 * (stats.MemTotal - stats.MemFree) / stats.MemTotal; memoryPercent > sample.MemoryPercent {
+				sample.MemoryPercent = memoryPercent
+			}
+		}
+	}
+
+	bucket := &bucketHealth{}
+	if err := difftool.getClusterRestApi(ref, xdcrBase.DefaultPoolBucketsPath+bucketName+base.BucketStatsPath, bucket); err != nil {
+		return nil, err
+	}
+	if samples := bucket.Op.Samples.DiskWriteQueue; len(samples) > 0 {
+		sample.DiskQueue = samples[len(samples)-1]
+	}
+	if samples := bucket.Op.Samples.ResidentItemsRatio; len(samples) > 0 {
+		sample.ResidentRatio = samples[len(samples)-1]
+	}
+	return sample, nil
+}
+
+func (difftool *xdcrDiffTool) getClusterRestApi(ref *metadata.RemoteClusterReference, path string, out interface{}) error {
+	err, statusCode := difftool.utils.QueryRestApiWithAuth(ref.HostName(), path, false, ref.UserName(), ref.Password(),
+		ref.HttpAuthMech(), ref.Certificates(), ref.SANInCertificate(), ref.ClientCertificate(), ref.ClientKey(),
+		xdcrBase.MethodGet, "", nil, 0, out, nil, false, difftool.logger)
+	if err != nil {
+		return err
+	}
+	if statusCode != http.StatusOK {
+		return fmt.Errorf("%v returned status %v", path, statusCode)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"xdcrDiffer/base"
+	"xdcrDiffer/utils"
+
+	xdcrBase "github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/metadata"
+)
+
+// Holds the capture in memory if both buckets together hold less data than inMemoryCaptureMB. A capture only has
+// the keys, metadata and hashes of the documents, so it is usually well within that, and spills to disk if it is not
+func (difftool *xdcrDiffTool) setUpInMemoryCapture() {
+	limit := options.inMemoryCaptureMB * 1024 * 1024
+	var dataUsed uint64
+	for _, cluster := range []struct {
+		label  string
+		ref    *metadata.RemoteClusterReference
+		bucket string
+	}{
+		{base.SourceClusterLabel, difftool.selfRef, difftool.specifiedSpec.SourceBucketName},
+		{base.TargetClusterLabel, difftool.specifiedRef, difftool.specifiedSpec.TargetBucketName},
+	} {
+		bucketInfo := make(map[string]interface{})
+		err := difftool.getClusterRestApi(cluster.ref, xdcrBase.DefaultPoolBucketsPath+cluster.bucket, &bucketInfo)
+		var used uint64
+		if err == nil {
+			used, _, _, err = utils.GetBucketUsageFromBucketInfo(cluster.bucket, bucketInfo)
+		}
+		if err != nil {
+			fmt.Printf("Capturing to disk, as the size of %v bucket %v is unknown. err=%v\n", cluster.label, cluster.bucket, err)
+			return
+		}
+		dataUsed += used
+	}
+	if dataUsed >= limit {
+		fmt.Printf("Capturing to disk, as the buckets hold %v MB of data, no less than inMemoryCaptureMB\n", dataUsed/1024/1024)
+		return
+	}
+	base.CaptureMemory = base.NewMemoryCapture(int64(limit))
+	fmt.Printf("Capturing in memory, as the buckets hold %v MB of data\n", dataUsed/1024/1024)
+}
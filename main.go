@@ -11,23 +11,36 @@ package main
 
 import (
 	"bufio"
+	"crypto"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"xdcrDiffer/base"
 	"xdcrDiffer/dcp"
 	"xdcrDiffer/differ"
+	"xdcrDiffer/encryption"
 	fdp "xdcrDiffer/fileDescriptorPool"
 	"xdcrDiffer/filterPool"
+	"xdcrDiffer/monitor"
+	"xdcrDiffer/results"
+	"xdcrDiffer/stats"
 	"xdcrDiffer/utils"
 
 	"github.com/couchbase/gocb/v2"
@@ -122,6 +135,15 @@ var options struct {
 	enforceTLS bool
 	// Number of items kept in memory per binary buffer bucket
 	bucketBufferCapacity int
+	// Number of written bucket buffers kept for reuse
+	captureBufferPoolSize int
+	// Number of filled bucket buffers that can be waiting to be written before DCP handlers block. 0 to write in place
+	captureBufferHighWatermark int
+	// DCP flow control buffer of each connection, in bytes. 0 to size from the bucket RAM quota, -1 to turn flow control off
+	sourceDcpBufferSize int
+	targetDcpBufferSize int
+	// Whether to let servers send backfills as OSO snapshots
+	useOSO bool
 	// Compare metadata, or body, or both
 	compareType string
 	// Number of times for mutationsDiffer to retry to resolve doc differences
@@ -136,11 +158,194 @@ var options struct {
 	setupTimeout int
 	//string denoting the xattrs that shouldn't be compared
 	fileContaingXattrKeysForNoComapre string
+	// Compare only the metadata and body hash captured via DCP, and skip the document fetches of mutation differ
+	fastMode bool
+	// vbuckets and vbucket ranges to capture and verify, i.e. "0-127,512". All vbuckets if empty
+	vbuckets string
+	// Size worker counts from the machine and vbuckets, and adjust mutation differ concurrency at runtime
+	autoTune bool
+	// Share of KV capacity, in percent, that the mutation differ should stay around when autoTune is on
+	autoTuneTargetImpact float64
+	// Where the mutation differ reads the keys to verify from, instead of the output of file differ
+	// "-" for stdin, "n1ql:<statement>" for a query on the source cluster, or a file glob
+	diffKeysSource string
+	// Skip transaction records and ignore transaction xattrs when comparing
+	filterTxnMetadata bool
+	// Skip Sync Gateway documents and compare documents by their Sync Gateway revision instead of CAS
+	syncGatewayMode bool
+	// With syncGatewayMode, whether the _sync xattr is left out of comparison
+	syncGatewayIgnoreSyncXattr bool
+	// Include the system scope and Eventing metadata, which are excluded by default
+	includeSystemCollections bool
+	// Keep streaming both clusters after the initial backfill and compare mutations as they arrive
+	monitor bool
+	// Seconds that a mutation may take to show up on the other cluster before a divergence is reported
+	monitorSettleSecs int
+	// File that divergence events found while monitoring are appended to, one JSON document per line
+	monitorEventsFile string
+	// Clock skew between source and target nodes, in seconds, beyond which a warning is reported
+	clockSkewThresholdSecs int
+	// scope.collection on the source to write a canary document to, to measure replication latency. Not measured if empty
+	canaryCollection string
+	// scope.collection on the target that the canary collection replicates to. The same as canaryCollection if empty
+	canaryTargetCollection string
+	// Seconds to wait for the canary document to show up on the target
+	canaryTimeoutSecs int
+	// Whether to record when and at what CAS both sides of every difference found by the mutation differ were fetched
+	auditTrail bool
+	// Whether to fetch the keys with differences once more at the end of the mutation differ, to corroborate them
+	auditRefetch bool
+	// Seconds that the estimate subcommand captures both clusters for
+	estimateProbeSecs uint64
+	// Bandwidth of the link between the clusters in MiB per second, for the estimate subcommand. Unknown if 0
+	linkBandwidthMBps float64
+	// Percentage of documents expected to have differences, for the estimate subcommand
+	estimateDiffPercent float64
+	// Named bundle of options, which sets those that are not explicitly specified
+	profile string
+	// Comma separated sub-document paths that the mutation differ fetches and compares, instead of whole bodies
+	comparePaths string
+	// JSON file of known and accepted divergences, reported separately instead of as differences
+	suppressionFile string
+	// Address to serve the pause and resume endpoints on, i.e. localhost:8765. Not served if empty
+	controlListen string
+	// Compares the capture files of each vbucket as soon as both clusters have finished capturing it
+	streamFileDiff bool
+	// Size of the windows of mutation time that divergences are bucketed into
+	hotWindowSecs uint64
+	// What the clusters are called in logs, stats, file names and reports
+	sourceLabel string
+	targetLabel string
+	// Faults to inject and their probabilities, i.e. kvTimeout=0.01,dcpDisconnect=0.001
+	injectFaults string
+	// Go plugin deciding whether documents the mutation differ found to differ are equivalent after all
+	verdictPlugin string
+	// Comma separated paths of arrays the mutation differ compares regardless of the order of their elements
+	unorderedArrayPaths string
+	// Differences between numbers in document bodies that the mutation differ tolerates, absolute and relative
+	numberAbsTolerance float64
+	numberRelTolerance float64
+	// Encrypts the output files at the end of the run
+	encryptOutput        bool
+	encryptionKeyFile    string
+	encryptionKeyCommand string
+	// Credentials of the phases that read and write documents through KV and query, i.e. the mutation differ and the
+	// canary, if they differ from those used for DCP capture
+	sourceVerifyUsername string
+	sourceVerifyPassword string
+	targetVerifyUsername string
+	targetVerifyPassword string
+	// How the certificates of the clusters are verified over TLS, on top of the certificates of the references
+	tlsUseSystemRoots           bool
+	tlsCAFile                   string
+	tlsSkipHostnameVerification bool
+	tlsPinnedSANs               string
+	tlsPinnedFingerprints       string
+	// Manifest of the SHA-256 digests of the output, written at the end of the run, and the key signing it
+	artifactManifest       string
+	artifactSigningKeyFile string
+	// File to write whether the run passes to, and the maximum numbers of differences it passes with
+	runVerdict    string
+	failThreshold string
+	// Stop the run once this many keys are found to differ. Unlimited if 0
+	abortAfterDiffs int
+	// Stream documents without their bodies, for runs that only compare metadata
+	captureNoValue bool
+	// Directory a previous run was started in, whose capture is reused so that only what changed since is streamed
+	baselineRunDir string
+	// Whether the replication strips TTLs, which decides if documents differing by TTL only are reported as expected
+	ttlPolicy string
+	// File of keys and key prefixes that are verified before the rest of the run and again at its end
+	criticalKeys    string
+	criticalKeysDir string
+	// Cluster health stats beyond which the run is throttled, i.e. cpu=85,memory=90. Not polled if empty
+	healthThresholds       string
+	healthPollIntervalSecs uint64
+	// Compares buckets, or collections of the same bucket, of the source cluster, without a replication between them
+	sameCluster bool
+	// Comma separated sourceScope.sourceCollection:targetScope.targetCollection pairs, compared instead of the
+	// collections the replication maps
+	collectionMapping string
+	// Exits without comparing when the replication is paused or reporting errors
+	skipInactiveReplication bool
+	// Buckets holding less data than this together are captured in memory rather than to disk. Never if 0
+	inMemoryCaptureMB uint64
+	// How the keys to verify are split between the mutation differ workers, by hash or in contiguous ranges
+	mutationDifferKeySharding string
+	// How documents are captured, through DCP or KV range scans
+	captureBackend string
+	// Documents sampled of each vbucket and collection by range scans. Every document is captured if 0
+	rangeScanSamples uint64
+	// Ports of each cluster that are not the defaults, and addresses its nodes are reached at instead of the ones
+	// they report
+	sourcePorts string
+	targetPorts string
+	// Gather a diagnostics bundle into the run directory when the run fails
+	diagnosticsOnFailure bool
+	// Log the diagnostics include the end of
+	diagnosticsLogFile string
+	// How often the KV agents are pinged to keep their connections alive. 0 to not ping them
+	keepAliveSecs uint64
+	// Times in a row a DCP stream whose connection dropped is opened again before the run fails
+	maxDcpReconnects uint64
+	// Documents with bodies of at least this many KB that differ are reported by the chunks of their bodies that differ
+	bodyChunksThresholdKB uint64
+	// Include the bytes of the chunks that differ
+	bodyChunksIncludeBytes bool
+	// JSON file mapping key prefixes and collections to tenants, whose output is split out and counted separately
+	tenantFile string
+	// bucket.scope.collection of the source cluster the replication logs conflicts to
+	conflictLogCollection string
+	// Field of the conflict records holding the key of the document in conflict
+	conflictLogKeyField string
+	// Whether the snappy bit of the datatype is left out of the comparison, as buckets compress documents on their own
+	compressionPolicy string
+	// Seconds to wait, before capturing, for the vbuckets to persist up to their high seqnos. Not waited for if zero
+	persistenceWaitTimeout uint64
+	// Documents of a synthetic dataset to seed the clusters with before capture, checking that exactly the divergences
+	// injected into it are found. Not seeded if zero
+	seedDocuments int
+	// Divergences of each kind to inject into the seeded dataset
+	seedDivergences int
+	// scope.collection of both buckets to seed
+	seedCollection string
+	// JSON file of where the DCP streams of each vbucket of both clusters start. See base.StreamStartPlan
+	streamStartFile string
+	// Where to write progress events as JSON lines: stdout, fd:<n> or a file. Not written if empty
+	progressEvents string
+	// KB of document values each batch of the mutation differ holds at most, as sized by the capture. Not bounded if 0
+	mutationDifferBatchKB uint64
+	// Whether the mutation differ output is also written in compressed chunks with an index by key
+	mutationDiffChunkedDetails bool
+	// Prints the version of the tool and exits
+	printVersion bool
+	// Level up to which the log messages of the SDK are routed into the log of the tool. Not routed if empty
+	sdkLogLevel string
+	// Percentage of the latest KV operations on a cluster that may fail before the mutation differ stops sending
+	// to it. 0 never stops
+	circuitBreakerPercent float64
+	// Percentage of the KV operations on a cluster that may be retries. 0 retries without limit
+	retryBudgetPercent float64
+	// Whether the run is paused or aborted once the circuit breaker of a cluster opens
+	circuitBreakerAction string
+	// Cluster the other is reconciled to by the scripts written with the mutation differ output. None if empty
+	reconcileWinner string
+	// reconcile directory of an earlier run, whose repairs are verified instead of the keys of file differ
+	verifyRepairs string
+	// Seconds to wait for the repairs to settle before they are verified
+	repairSettleSecs int
+	// scope.collection of the source keys a diffKeysSource query returns. The default collection if empty
+	diffKeysCollection string
+	// Which clusters are Capella, whose reads are billed by read units
+	capellaClusters string
+	// Price of a million read units, and the most a run may spend on those of the Capella clusters. No limit if 0
+	readUnitPrice    float64
+	maxReadUnitSpend float64
 }
 
 func argParse() {
 	flag.StringVar(&options.sourceUrl, "sourceUrl", "",
-		"url for source cluster")
+		"url for source cluster, or a connection string of the SDK with options, i.e. couchbase://cb1,cb2?network=external&kv_timeout=5s")
 	flag.StringVar(&options.sourceUsername, "sourceUsername", "",
 		"username for source cluster")
 	flag.StringVar(&options.sourcePassword, "sourcePassword", "",
@@ -152,7 +357,7 @@ func argParse() {
 	flag.StringVar(&options.sourceFileDir, "sourceFileDir", base.SourceFileDir,
 		"directory to store mutations in source cluster")
 	flag.StringVar(&options.targetUrl, "targetUrl", "",
-		"url for target cluster")
+		"url for target cluster, or a connection string of the SDK with options")
 	flag.StringVar(&options.targetUsername, "targetUsername", "",
 		"username for target cluster")
 	flag.StringVar(&options.targetPassword, "targetPassword", "",
@@ -161,12 +366,12 @@ func argParse() {
 		"bucket name for target cluster")
 	flag.StringVar(&options.targetFileDir, "targetFileDir", base.TargetFileDir,
 		"directory to store mutations in target cluster")
-	flag.Uint64Var(&options.numberOfSourceDcpClients, "numberOfSourceDcpClients", 1,
-		"number of source dcp clients")
+	flag.Uint64Var(&options.numberOfSourceDcpClients, "numberOfSourceDcpClients", 0,
+		"number of source dcp clients, i.e. DCP connections per source node. 0 to size from the source bucket item count")
 	flag.Uint64Var(&options.numberOfWorkersPerSourceDcpClient, "numberOfWorkersPerSourceDcpClient", 64,
 		"number of workers for each source dcp client")
-	flag.Uint64Var(&options.numberOfTargetDcpClients, "numberOfTargetDcpClients", 1,
-		"number of target dcp clients")
+	flag.Uint64Var(&options.numberOfTargetDcpClients, "numberOfTargetDcpClients", 0,
+		"number of target dcp clients, i.e. DCP connections per target node. 0 to size from the target bucket item count")
 	flag.Uint64Var(&options.numberOfWorkersPerTargetDcpClient, "numberOfWorkersPerTargetDcpClient", 64,
 		"number of workers for each target dcp client")
 	flag.Uint64Var(&options.numberOfWorkersForFileDiffer, "numberOfWorkersForFileDiffer", 30,
@@ -196,7 +401,7 @@ func argParse() {
 	flag.Uint64Var(&options.mutationDifferBatchSize, "mutationDifferBatchSize", 100,
 		"size of batch used by mutation differ")
 	flag.Uint64Var(&options.mutationDifferTimeout, "mutationDifferTimeout", 30,
-		"timeout, in seconds, used by mutation differ")
+		"deadline, in seconds, of each KV operation of the mutation differ. Only the operations that exceed it are sent again")
 	flag.Uint64Var(&options.sourceDcpHandlerChanSize, "sourceDcpHandlerChanSize", base.DcpHandlerChanSize,
 		"size of source dcp handler channel")
 	flag.Uint64Var(&options.targetDcpHandlerChanSize, "targetDcpHandlerChanSize", base.DcpHandlerChanSize,
@@ -229,6 +434,16 @@ func argParse() {
 		" stops executing if pre-requisites are not in place to ensure TLS communications")
 	flag.IntVar(&options.bucketBufferCapacity, "bucketBufferCapacity", base.BucketBufferCapacity,
 		"  number of items kept in memory per binary buffer bucket")
+	flag.IntVar(&options.captureBufferPoolSize, "captureBufferPoolSize", base.CaptureBufferPoolSize,
+		"Number of written bucket buffers per DCP driver kept for reuse")
+	flag.IntVar(&options.captureBufferHighWatermark, "captureBufferHighWatermark", base.CaptureBufferHighWatermark,
+		"Number of filled bucket buffers per DCP driver that can be waiting to be written to disk before DCP streaming is throttled. 0 to write buffers in place")
+	flag.IntVar(&options.sourceDcpBufferSize, "sourceDcpBufferSize", 0,
+		"DCP flow control buffer size in bytes of each source DCP connection. 0 to size from the source bucket RAM quota, -1 to turn flow control off")
+	flag.IntVar(&options.targetDcpBufferSize, "targetDcpBufferSize", 0,
+		"DCP flow control buffer size in bytes of each target DCP connection. 0 to size from the target bucket RAM quota, -1 to turn flow control off")
+	flag.BoolVar(&options.useOSO, "useOSO", true,
+		"Let servers that support it send backfills as Out of Sequence Order snapshots, which are faster to read from disk")
 	flag.StringVar(&options.compareType, "compareType", base.MutationCompareTypeMetadata,
 		" whether to compare meta, body, or both. Default meta")
 	flag.IntVar(&options.mutationDifferRetries, "mutationRetries", 0,
@@ -243,9 +458,306 @@ func argParse() {
 		"Common setup timeout duration in seconds")
 	flag.StringVar(&options.fileContaingXattrKeysForNoComapre, "fileContaingXattrKeysForNoComapre", "",
 		"Path to the file containing the Xattr keys for NoCompare ")
+	flag.BoolVar(&options.fastMode, "fastMode", false,
+		"Compare only the metadata and body hash captured during DCP streaming, without fetching any documents afterwards")
+	flag.StringVar(&options.vbuckets, "vbuckets", "",
+		"Comma separated vbuckets and vbucket ranges to capture and verify, i.e. 0-127,512. Default is all vbuckets")
+	flag.BoolVar(&options.autoTune, "autoTune", false,
+		"Size the worker counts that are not explicitly specified from the CPU and vbucket count, and adjust mutation differ concurrency based on measured latency")
+	flag.Float64Var(&options.autoTuneTargetImpact, "autoTuneTargetImpact", base.AutoTuneTargetImpact,
+		"With autoTune, the share of KV capacity in percent that the mutation differ should stay around")
+	flag.StringVar(&options.diffKeysSource, "diffKeysSource", "",
+		"Keys for the mutation differ to verify instead of the file differ output: - for stdin, n1ql:<statement> to query the source cluster, or a glob of key files")
+	flag.BoolVar(&options.filterTxnMetadata, "filterTxnMetadata", true,
+		"Skip transaction records and ignore the transaction xattrs holding staged mutations. Set to false for a raw comparison")
+	flag.BoolVar(&options.syncGatewayMode, "syncGatewayMode", false,
+		"Skip Sync Gateway's _sync: documents, and compare documents imported by Sync Gateway by their revision instead of CAS")
+	flag.BoolVar(&options.syncGatewayIgnoreSyncXattr, "syncGatewayIgnoreSyncXattr", true,
+		"With syncGatewayMode, leave the _sync xattr out of comparison. Set to false to compare it")
+	flag.BoolVar(&options.includeSystemCollections, "includeSystemCollections", false,
+		"Capture and compare the collections of the _system scope and Eventing metadata documents, which are excluded by default")
+	flag.BoolVar(&options.monitor, "monitor", false,
+		"Keep the DCP streams open after the initial backfill and compare mutations from both clusters as they arrive, until completeByDuration or interrupted")
+	flag.IntVar(&options.monitorSettleSecs, "monitorSettleSecs", 10,
+		"With monitor, seconds that a mutation may take to be replicated before it is reported as a divergence")
+	flag.StringVar(&options.monitorEventsFile, "monitorEventsFile", base.MonitorEventsFileName,
+		"With monitor, file that divergence events are appended to as JSON lines")
+	flag.IntVar(&options.clockSkewThresholdSecs, "clockSkewThresholdSecs", 5,
+		"Clock skew in seconds between source and target nodes beyond which a warning is reported")
+	flag.StringVar(&options.canaryCollection, "canaryCollection", "",
+		"scope.collection on the source to write a canary document to, to measure replication latency before capture starts")
+	flag.StringVar(&options.canaryTargetCollection, "canaryTargetCollection", "",
+		"scope.collection on the target that canaryCollection replicates to. Default is the same as canaryCollection")
+	flag.IntVar(&options.canaryTimeoutSecs, "canaryTimeoutSecs", 60,
+		"Seconds to wait for the canary document to be replicated to the target")
+	flag.BoolVar(&options.auditTrail, "auditTrail", false,
+		"Record when and at what CAS both sides of every difference found by the mutation differ were fetched")
+	flag.BoolVar(&options.auditRefetch, "auditRefetch", false,
+		"With auditTrail, fetch the keys with differences once more at the end of the mutation differ to corroborate them")
+	flag.Uint64Var(&options.estimateProbeSecs, "estimateProbeSecs", 30,
+		"With the estimate subcommand, seconds to capture both clusters for")
+	flag.Float64Var(&options.linkBandwidthMBps, "linkBandwidthMBps", 0,
+		"With the estimate subcommand, bandwidth of the link between the clusters in MiB per second")
+	flag.Float64Var(&options.estimateDiffPercent, "estimateDiffPercent", 1,
+		"With the estimate subcommand, percentage of documents expected to have differences")
+	flag.StringVar(&options.profile, "profile", "",
+		fmt.Sprintf("Named bundle of options, one of %v. Options that are explicitly specified take precedence", profileNames()))
+	flag.StringVar(&options.comparePaths, "comparePaths", "",
+		"Comma separated sub-document paths, i.e. \"address.city,orders[0]\", that the mutation differ fetches and compares instead of whole document bodies")
+	flag.StringVar(&options.suppressionFile, "suppressionFile", "",
+		"JSON file of known and accepted divergences, each with a reason and optionally an expiry date, that are reported separately instead of as differences")
+	flag.StringVar(&options.controlListen, "controlListen", "",
+		"Address to serve endpoints to pause and resume the run on, i.e. localhost:8765. The run can also be paused with SIGUSR1 and resumed with SIGUSR2, other than on Windows")
+	flag.BoolVar(&options.streamFileDiff, "streamFileDiff", false,
+		"Whether to start comparing the capture files of each vbucket as soon as it has been captured from both clusters, instead of once capture is complete. Requires completeBySeqno")
+	flag.Uint64Var(&options.hotWindowSecs, "hotWindowSecs", 300,
+		"Size in seconds of the windows of mutation time, taken from the CAS of divergent documents, in which divergence concentration is reported. 0 to disable")
+	flag.StringVar(&options.sourceLabel, "sourceLabel", "",
+		"Label of the source cluster, i.e. dc-east, used in logs, stats, file names and reports instead of \"source\"")
+	flag.StringVar(&options.targetLabel, "targetLabel", "",
+		"Label of the target cluster, i.e. dc-west, used in logs, stats, file names and reports instead of \"target\"")
+	flag.StringVar(&options.injectFaults, "injectFaults", os.Getenv(base.FaultInjectionEnvVar),
+		fmt.Sprintf("Comma separated faults to inject, each with the probability to inject it at, i.e. kvTimeout=0.01,dcpDisconnect=0.001, to validate retries and resumption. Faults are %v. Defaults to the %v environment variable",
+			strings.Join(base.FaultNames, ", "), base.FaultInjectionEnvVar))
+	flag.StringVar(&options.verdictPlugin, "verdictPlugin", "",
+		"Go plugin exporting a Verdict function that decides whether documents the mutation differ found to differ are equivalent by domain specific rules, in which case they are not reported. Requires a binary built with cgo, i.e. by make")
+	flag.StringVar(&options.unorderedArrayPaths, "unorderedArrayPaths", "",
+		"Comma separated paths of arrays in document bodies, i.e. \"tags,orders[].items\", that the mutation differ compares regardless of the order of their elements")
+	flag.Float64Var(&options.numberAbsTolerance, "numberAbsTolerance", 0,
+		"Largest difference between numbers in document bodies that the mutation differ considers equal")
+	flag.Float64Var(&options.numberRelTolerance, "numberRelTolerance", 0,
+		"Largest difference between numbers in document bodies, as a fraction of the larger of the two, that the mutation differ considers equal")
+	flag.BoolVar(&options.encryptOutput, "encryptOutput", false,
+		fmt.Sprintf("Whether to encrypt the capture files and the output of the differs with AES-256-GCM at the end of the run. The key is taken from encryptionKeyFile or encryptionKeyCommand, or derived from the passphrase in the %v environment variable", encryptionPassphraseEnvVar))
+	flag.StringVar(&options.encryptionKeyFile, "encryptionKeyFile", "",
+		"File holding the 32 byte key to encrypt the output with, as is or encoded in hex or base64")
+	flag.StringVar(&options.encryptionKeyCommand, "encryptionKeyCommand", "",
+		"Command printing the 32 byte key to encrypt the output with, i.e. a KMS client decrypting a data key")
+	flag.StringVar(&options.sourceVerifyUsername, "sourceVerifyUsername", "",
+		"User the mutation differ, key queries and the canary authenticate to the source cluster as, i.e. a data reader and writer, if not the one DCP capture uses")
+	flag.StringVar(&options.sourceVerifyPassword, "sourceVerifyPassword", "",
+		"Password of sourceVerifyUsername")
+	flag.StringVar(&options.targetVerifyUsername, "targetVerifyUsername", "",
+		"User the mutation differ and the canary authenticate to the target cluster as, i.e. a data reader, if not the one of the remote cluster reference or targetUsername")
+	flag.StringVar(&options.targetVerifyPassword, "targetVerifyPassword", "",
+		"Password of targetVerifyUsername")
+	flag.BoolVar(&options.tlsUseSystemRoots, "tlsUseSystemRoots", false,
+		"Trust the root CAs of the system, on top of the certificates of the cluster references")
+	flag.StringVar(&options.tlsCAFile, "tlsCAFile", "",
+		"PEM file of CAs to trust, on top of the certificates of the cluster references")
+	flag.BoolVar(&options.tlsSkipHostnameVerification, "tlsSkipHostnameVerification", false,
+		"Do not verify that certificates name the hosts they are presented by. Chains are still verified by a handshake with every node, but the SDK connections are not verified, so it cannot be combined with pins")
+	flag.StringVar(&options.tlsPinnedSANs, "tlsPinnedSANs", "",
+		"Comma separated DNS, IP or URI SANs, i.e. SPIFFE IDs, one of which the certificate of every node has to carry")
+	flag.StringVar(&options.tlsPinnedFingerprints, "tlsPinnedFingerprints", "",
+		"Comma separated SHA-256 fingerprints in hex, one of which the certificate of every node has to have")
+	flag.StringVar(&options.artifactManifest, "artifactManifest", "",
+		"File to write the SHA-256 digests of all the output of the run to, once it is done")
+	flag.StringVar(&options.artifactSigningKeyFile, "artifactSigningKeyFile", "",
+		"PEM private key, i.e. Ed25519, ECDSA or RSA, to sign the artifactManifest with")
+	flag.StringVar(&options.runVerdict, "runVerdict", "",
+		"File to write a JSON verdict of whether the run passes to, along with the number of differences of each category")
+	flag.StringVar(&options.failThreshold, "failThreshold", "0",
+		"Maximum number of differences the runVerdict passes with, or comma separated category=max pairs, i.e. MissingFromTarget=0,total=100")
+	flag.IntVar(&options.abortAfterDiffs, "abortAfterDiffs", 0,
+		"Stop the run once the file differ finds this many keys that differ, keeping what was found until then. 0 to never stop early")
+	flag.BoolVar(&options.captureNoValue, "captureNoValue", false,
+		"Stream documents without their bodies, which only compares metadata and xattrs but is much lighter on the clusters. Requires compareType meta")
+	flag.StringVar(&options.baselineRunDir, "baselineRunDir", "",
+		"Directory a previous run was started in, whose capture is linked in so that only vbuckets that changed since its checkpoints are streamed")
+	flag.StringVar(&options.ttlPolicy, "ttlPolicy", string(differ.TTLPolicyAuto),
+		"Whether the replication strips TTLs, which makes documents that differ by TTL only be reported as ExpectedByConfiguration rather than Mismatch."+
+			" One of auto, to take it from the replication settings, strip or preserve")
+	flag.StringVar(&options.criticalKeys, "criticalKeys", "",
+		"File of keys, one per line, that are verified before capture and again at the end of the run, with a report of their own. Lines ending with * are key prefixes, matched against the capture")
+	flag.StringVar(&options.criticalKeysDir, "criticalKeysDir", base.CriticalKeysDir,
+		"Output directory for the verification of criticalKeys")
+	flag.StringVar(&options.healthThresholds, "healthThresholds", "",
+		"Comma separated cluster health stats, i.e. cpu=85,memory=90,diskQueue=1000000,residentRatio=10, beyond which the mutation differ runs at reduced concurrency and the run is eventually paused until the clusters recover."+
+			" cpu and memory are the highest percentages of any node, diskQueue the items of the bucket waiting to be persisted and residentRatio the lowest percentage of active items resident in memory")
+	flag.Uint64Var(&options.healthPollIntervalSecs, "healthPollIntervalSecs", 15,
+		"Seconds between polls of the health stats of both clusters, with healthThresholds")
+	flag.BoolVar(&options.sameCluster, "sameCluster", false,
+		"Compare two buckets of the source cluster, or two sets of collections of the same bucket, i.e. copies made by eventing, instead of the source and target of a replication. Takes no remote cluster. targetBucketName defaults to sourceBucketName")
+	flag.StringVar(&options.collectionMapping, "collectionMapping", "",
+		"Comma separated sourceScope.sourceCollection:targetScope.targetCollection pairs to compare, i.e. inventory.airline:inventory.airlineCopy, instead of the collections the replication maps")
+	flag.BoolVar(&options.skipInactiveReplication, "skipInactiveReplication", false,
+		"Exit without comparing, with exit status 3, when the replication is paused or reporting errors, as the target is then expected to lag behind")
+	flag.Uint64Var(&options.inMemoryCaptureMB, "inMemoryCaptureMB", 0,
+		"Capture in memory instead of to disk when both buckets together hold less than this many MB of data. The capture spills to disk should it grow beyond that. Requires the file differ to run in the same run")
+	flag.StringVar(&options.mutationDifferKeySharding, "mutationDifferKeySharding", string(differ.KeyShardingHash),
+		fmt.Sprintf("How the keys to verify are split between the mutation differ workers: %v spreads them by the hash of each key, %v in contiguous ranges of the file differ output, where keys of a common prefix fall to the same worker",
+			differ.KeyShardingHash, differ.KeyShardingRange))
+	flag.StringVar(&options.captureBackend, "captureBackend", base.CaptureBackendDcp,
+		fmt.Sprintf("How documents are captured: %v streams them, %v enumerates them with KV range scans, on 7.6 and later, for when DCP is not permitted or streaming is undesirable. Range scans capture live documents only, not deletions",
+			base.CaptureBackendDcp, base.CaptureBackendRangeScan))
+	flag.Uint64Var(&options.rangeScanSamples, "rangeScanSamples", 0,
+		"With the rangeScan capture backend, sample this many documents of each vbucket and collection instead of capturing them all. The sampled keys are verified by the mutation differ instead of the file differ")
+	flag.StringVar(&options.sourcePorts, "sourcePorts", "",
+		"Comma separated ports of the source cluster that are not the defaults, i.e. mgmt=18091,kv=21210,kvTls=21207, the network of alternate addresses to connect over, i.e. network=external, and host:port=host:port pairs of the address a node reports and the address to reach it at, i.e. behind a port-mapped proxy")
+	flag.StringVar(&options.targetPorts, "targetPorts", "",
+		"Same as sourcePorts, for the target cluster")
+	flag.BoolVar(&options.diagnosticsOnFailure, "diagnosticsOnFailure", false,
+		"When the run fails, zip the end of the log, the options with passwords redacted, stats, a goroutine dump and environment info into a diagnostics_<time>.zip in the run directory, for support escalations")
+	flag.StringVar(&options.diagnosticsLogFile, "diagnosticsLogFile", base.DiagnosticsLogFileName,
+		"Log of the run that diagnosticsOnFailure includes the end of")
+	flag.Uint64Var(&options.keepAliveSecs, "keepAliveSecs", base.DefaultKeepAliveSecs,
+		"Ping the KV connections every this many seconds, so that firewalls do not drop them while idle during long runs. 0 to not ping them")
+	flag.Uint64Var(&options.maxDcpReconnects, "maxDcpReconnects", base.DefaultMaxDcpReconnects,
+		"Times in a row a DCP stream whose connection dropped is opened again, from the last seqno captured, before the run fails. 0 fails the run as soon as a connection drops")
+	flag.Uint64Var(&options.bodyChunksThresholdKB, "bodyChunksThresholdKB", 0,
+		"Report documents with bodies of at least this many KB that differ by the chunks of their bodies that the other side does not have, with their offsets and hashes, instead of by their whole bodies. 0 reports whole bodies")
+	flag.BoolVar(&options.bodyChunksIncludeBytes, "bodyChunksIncludeBytes", false,
+		"With bodyChunksThresholdKB, also include the bytes of the chunks that differ")
+	flag.StringVar(&options.tenantFile, "tenantFile", "",
+		"JSON file of the tenants of a multi-tenant bucket, each with the key prefixes and/or collections of their documents, whose divergences are counted and written to an output directory of their own")
+	flag.StringVar(&options.conflictLogCollection, "conflictLogCollection", "",
+		"bucket.scope.collection of the source cluster that the replication logs conflicts to. Documents the mutation differ finds to differ that have a conflict record are reported as "+base.KnownConflictCategory+" instead of Mismatch")
+	flag.StringVar(&options.conflictLogKeyField, "conflictLogKeyField", base.DefaultConflictLogKeyField,
+		"Field, or path, of the conflict records that holds the key of the document in conflict")
+	flag.StringVar(&options.compressionPolicy, "compressionPolicy", string(differ.CompressionPolicyAuto),
+		"Whether documents held compressed on one side only are compared as if neither was, rather than reported as differing by datatype."+
+			" One of auto, to normalize unless compression is off on both buckets, normalize or strict")
+	flag.Uint64Var(&options.persistenceWaitTimeout, "persistenceWaitTimeout", 0,
+		"Seconds to wait, before capturing each cluster, for every vbucket to persist up to its high seqno, so that the capture reflects a persisted state instead of mutations still in flight. 0 does not wait")
+	flag.IntVar(&options.seedDocuments, "seedDocuments", 0,
+		"Number of documents of a deterministic synthetic dataset to write to seedCollection of the source before capture. Once replicated, divergences are injected into the target, and the run checks that exactly those are found. 0 does not seed")
+	flag.IntVar(&options.seedDivergences, "seedDivergences", 10,
+		"With seedDocuments, number of documents missing from the target, missing from the source and differing to inject")
+	flag.StringVar(&options.seedCollection, "seedCollection", "_default._default",
+		"With seedDocuments, scope.collection of both buckets to seed")
+	flag.StringVar(&options.streamStartFile, "streamStartFile", "",
+		"JSON file of where the DCP stream of each vbucket of either cluster starts: from zero, from the checkpoint the run resumes from, or from a given seqno and failover UUID")
+	flag.StringVar(&options.progressEvents, "progressEvents", "",
+		"Where to write progress events as JSON lines, for wrappers to follow the run: stdout, fd:<n> for a file descriptor inherited from the parent process, or a file to append to")
+	flag.Uint64Var(&options.mutationDifferBatchKB, "mutationDifferBatchKB", 0,
+		"KB of document values a batch of the mutation differ holds at most, as sized by the capture, so that batches of large documents hold fewer keys. A batch ends at whichever comes first of mutationDifferBatchSize keys and this. 0 batches by keys alone")
+	flag.BoolVar(&options.mutationDiffChunkedDetails, "mutationDiffChunkedDetails", false,
+		"Also write the mutation differ output in zstd compressed chunks, with an index of the chunk of every key, so that the results subcommand can read the entries of a key without reading the whole output")
+	flag.BoolVar(&options.printVersion, "version", false,
+		"Print the version and commit the tool was built from, and the platform it was built for, and exit")
+	flag.StringVar(&options.sdkLogLevel, "sdkLogLevel", "",
+		"Route the log messages of the SDK up to this level, one of error, warn, info, debug or trace, into the log of the tool, tagged by the cluster of the nodes they name. Not routed if empty")
+	flag.Float64Var(&options.circuitBreakerPercent, "circuitBreakerPercent", 0,
+		"Percentage of the latest KV operations of the mutation differ on a cluster that may fail, beyond which no more batches are sent to it, nor retried, and the run is paused or aborted as circuitBreakerAction says. 0 keeps on retrying")
+	flag.Float64Var(&options.retryBudgetPercent, "retryBudgetPercent", 0,
+		"Percentage of the KV operations of the mutation differ on a cluster that may be retries. Batches that fail beyond the budget are not retried, and their keys are listed as keys with error. 0 retries without limit")
+	flag.StringVar(&options.circuitBreakerAction, "circuitBreakerAction", base.CircuitBreakerPause,
+		"What to do once the circuit breaker of a cluster opens: pause, to resume once the cluster has recovered, or abort, listing the keys left as keys with error")
+	flag.StringVar(&options.reconcileWinner, "reconcileWinner", "",
+		"source or target, to write scripts that reconcile the other cluster to it with the mutation differ output: N1QL deletes of the documents only the other has, the keys to re-replicate and a cbimport dataset of the documents it lacks or has another revision of")
+	flag.StringVar(&options.verifyRepairs, "verifyRepairs", "",
+		"reconcile directory of an earlier run, once its reconcile.sh has run, to verify its repairs with the mutation differ: the repaired keys are fetched from both clusters and reported as converged, overwritten again or failed. Requires the mutation differ alone, with compareType body")
+	flag.IntVar(&options.repairSettleSecs, "repairSettleSecs", base.RepairSettleSecs,
+		"Seconds to wait before verifyRepairs fetches the repaired keys, for replication to settle")
+	flag.StringVar(&options.diffKeysCollection, "diffKeysCollection", "",
+		"scope.collection of the source bucket the keys returned by a diffKeysSource n1ql: query are of, for queries whose keyspace does not tell. Otherwise it has to be the collection the query reads")
+	flag.StringVar(&options.capellaClusters, "capellaClusters", base.CapellaClustersAuto,
+		"Clusters whose reads are billed by read units: source, target, both, none, or auto to take those whose host is under cloud.couchbase.com")
+	flag.Float64Var(&options.readUnitPrice, "readUnitPrice", 0,
+		"Price of a million read units of Capella, for the read units estimated and used to be reported as a cost")
+	flag.Float64Var(&options.maxReadUnitSpend, "maxReadUnitSpend", 0,
+		"Most a run may spend on the read units of Capella clusters, in the currency of readUnitPrice. Once reached, the run stops reading from the clusters and is aborted. 0 for no limit")
 	flag.Parse()
 }
 
+// Options that apply to document bodies need the mutation differ to fetch them. The compare type is switched
+// to bodies, unless it was given explicitly
+func requireBodyComparison(optionNames string) {
+	if options.fastMode {
+		fmt.Fprintf(os.Stderr, "%v cannot be used with fastMode, which does not fetch documents\n", optionNames)
+		os.Exit(1)
+	}
+	if options.compareType == base.MutationCompareTypeMetadata {
+		if flagIsSet("compareType") {
+			fmt.Fprintf(os.Stderr, "%v requires compareType %v or %v\n", optionNames, base.MutationCompareTypeBodyOnly, base.MutationCompareTypeBodyAndMeta)
+			os.Exit(1)
+		}
+		fmt.Printf("Mutation differ will compare document bodies, as required by %v\n", optionNames)
+		options.compareType = base.MutationCompareTypeBodyOnly
+	}
+}
+
+func flagIsSet(name string) bool {
+	var set bool
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+var clusterLabelRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Labels end up in file names and stat names, so only a few characters are allowed
+// Capture files are kept in a directory named after the label, unless their directory is given
+func setClusterLabels(sourceLabel, targetLabel string) error {
+	if sourceLabel == "" {
+		sourceLabel = base.SourceClusterName
+	}
+	if targetLabel == "" {
+		targetLabel = base.TargetClusterName
+	}
+	for _, label := range []string{sourceLabel, targetLabel} {
+		if !clusterLabelRegex.MatchString(label) {
+			return fmt.Errorf("Invalid cluster label %q. Labels consist of letters, digits, '_', '.' and '-'", label)
+		}
+		for _, dir := range []string{base.CheckpointFileDir, base.FileDifferDir, base.MutationDifferDir} {
+			if label == dir {
+				return fmt.Errorf("Cluster label %v is reserved for the %v directory", label, dir)
+			}
+		}
+	}
+	if sourceLabel == targetLabel {
+		return fmt.Errorf("Source and target clusters cannot have the same label %v", sourceLabel)
+	}
+
+	base.SourceClusterLabel = sourceLabel
+	base.TargetClusterLabel = targetLabel
+	if !flagIsSet("sourceFileDir") {
+		options.sourceFileDir = sourceLabel
+	}
+	if !flagIsSet("targetFileDir") {
+		options.targetFileDir = targetLabel
+	}
+	return nil
+}
+
+// Sizes the worker counts that have not been explicitly specified
+// DCP handlers and mutation differ workers mostly wait on the network, while file differ workers are bound by CPU
+func autoTuneOptions(numOfVbuckets int, logger *xdcrLog.CommonLogger) {
+	explicitlySet := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicitlySet[f.Name] = true
+	})
+
+	cpus := runtime.NumCPU()
+	tune := func(name string, value *uint64, perCpu, min, max int) {
+		if explicitlySet[name] {
+			return
+		}
+		tuned := cpus * perCpu
+		if tuned < min {
+			tuned = min
+		}
+		if tuned > max {
+			tuned = max
+		}
+		if tuned > numOfVbuckets {
+			tuned = numOfVbuckets
+		}
+		logger.Infof("autoTune set %v to %v\n", name, tuned)
+		*value = uint64(tuned)
+	}
+
+	tune("numberOfWorkersPerSourceDcpClient", &options.numberOfWorkersPerSourceDcpClient, 8, 4, 64)
+	tune("numberOfWorkersPerTargetDcpClient", &options.numberOfWorkersPerTargetDcpClient, 8, 4, 64)
+	tune("numberOfWorkersForFileDiffer", &options.numberOfWorkersForFileDiffer, 2, 2, 64)
+	// Upper bound only. The number of batches actually in flight is adjusted at runtime
+	tune("numberOfWorkersForMutationDiffer", &options.numberOfWorkersForMutationDiffer, 8, 8, 128)
+}
+
 func validateCompareType(method string) {
 	for _, str := range base.MutationDiffCompareType {
 		if method == str {
@@ -258,6 +770,7 @@ func validateCompareType(method string) {
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage : %s [OPTIONS] \n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "        %s %s [OPTIONS] to query the output of a completed run\n", os.Args[0], resultsCommand)
 	flag.PrintDefaults()
 }
 
@@ -299,6 +812,59 @@ type xdcrDiffTool struct {
 
 	srcBucketManifest *metadata.CollectionsManifest
 	tgtBucketManifest *metadata.CollectionsManifest
+	// Structural differences between the manifests according to the replication mapping
+	manifestDivergences []*results.ManifestDivergence
+	// Clocks of the nodes of both clusters, measured when data generation started
+	clockSkew *results.ClockSkewReport
+	// Replication latency, measured before data generation started
+	canaryLatency *results.CanaryLatency
+	// Written to the clusters before capture, for the run to be checked against. Nil unless seeded
+	seedDataset *results.SeedDataset
+	// What the run missed or found in excess of the seeded divergences
+	seedFailures []string
+	// Where the DCP streams of both clusters start, per vbucket
+	streamStartPlan *base.StreamStartPlan
+	// Phases of the run currently running
+	runningPhases map[string]bool
+	phasesLock    sync.Mutex
+	// State of the replication when it was found, nil if unknown
+	replicationState *results.ReplicationState
+	// Sizes and datatypes of the documents captured from both buckets
+	distribution *results.DistributionReport
+	// Windows of mutation time in which the divergences found by the file differ concentrate
+	hotWindows *results.HotWindowReport
+	// Keys of each collection found on either bucket or both, as diffed by the file differ
+	keyOverlap *results.KeyOverlapReport
+	// Why the run stopped before everything was compared, if it did
+	abortReason string
+	// Metadata purge intervals of both buckets, zero if unknown, and when this run started capturing
+	sourcePurgeInterval time.Duration
+	targetPurgeInterval time.Duration
+	captureStarted      time.Time
+	// Keys whose KV operations repeatedly exceeded their deadline in the mutation differ
+	slowestKeys []*differ.SlowKey
+	// Sub-document paths that the mutation differ compares, parsed from options.comparePaths
+	comparePaths []string
+	// Loaded from options.verdictPlugin
+	verdictFunc differ.VerdictFunc
+	// Loaded from options.verifyRepairs
+	repairLog *differ.RepairLog
+	// Set if bodies are compared as JSON values, from options.unorderedArrayPaths and the number tolerances
+	jsonComparator *differ.JSONComparator
+	// Loaded from options.criticalKeys, and the outcome of each pass over them
+	criticalKeys       *base.CriticalKeys
+	criticalKeysReport *results.CriticalKeysReport
+	// Loaded from options.suppressionFile
+	suppressions []*results.Suppression
+	// Entries taken out of the output of each phase by the suppressions
+	suppressionSummaries map[string]*results.SuppressionSummary
+	// Loaded from options.tenantFile
+	tenants []*results.Tenant
+	// Divergences of each tenant in the output of each phase
+	tenantSummaries map[string][]*results.TenantSummary
+	// Of each phase once it completes, in order of completion
+	resourceUsage     []*results.PhaseResourceUsage
+	resourceUsageLock sync.Mutex
 
 	// If non-empty, just stream these collection IDs from each side's DCP
 	srcCollectionIds []uint32
@@ -319,14 +885,43 @@ type xdcrDiffTool struct {
 	migrationMapping  metadata.CollectionNamespaceMapping
 	duplicatedMapping differ.DuplicatedHintMap
 
+	// Serializes re-streaming of vbuckets with corrupted capture files
+	restreamLock sync.Mutex
+	// The vbuckets to capture and verify. All vbuckets if empty
+	vbuckets []uint16
+
 	sourceDcpDriver *dcp.DcpDriver
 	targetDcpDriver *dcp.DcpDriver
 
+	// Compares live mutations from both clusters when in monitor mode
+	monitor           *monitor.Monitor
+	monitorEventsFile *os.File
+	// Closed when an interrupt stops the DCP drivers
+	interruptCh chan bool
+
 	curState difftoolState
+	// Holds back DCP capture and the mutation differ while paused
+	pauseGate *base.PauseGate
+	// Set from options.healthThresholds, to hold back the mutation differ while the clusters are under stress
+	throttle        *base.Throttle
+	healthThrottler *base.HealthThrottler
+	// Set from options.circuitBreakerPercent and options.retryBudgetPercent, to stop sending to a failing cluster
+	sourceBreaker *base.CircuitBreaker
+	targetBreaker *base.CircuitBreaker
+	// Counts the read units of the Capella clusters, against options.maxReadUnitSpend if set. Nil without any
+	readUnitBudget *base.ReadUnitBudget
+	// KV agents to each cluster, shared by the DCP drivers and the mutation differ
+	agentPool *base.AgentPool
+	// Hands captured vbuckets over to the file differ while the rest are still being captured
+	handoff *vbHandoff
 
 	legacyMode bool
 	//Xattr Keys to be excluded for comparison
 	xattrKeysForNoCompare map[string]bool
+	// Documents with these key prefixes are not captured
+	keyPrefixesToSkip []string
+	// Parsed from options.collectionMapping. Nil if the collections are mapped as the replication maps them
+	collectionMapping base.CollectionMapping
 }
 
 func NewDiffTool(legacyMode bool) (*xdcrDiffTool, error) {
@@ -337,6 +932,11 @@ func NewDiffTool(legacyMode bool) (*xdcrDiffTool, error) {
 		srcToTgtColIdsMap:       make(map[uint32][]uint32),
 		colFilterToTgtColIdsMap: map[string][]uint32{},
 		xattrKeysForNoCompare:   map[string]bool{},
+		interruptCh:             make(chan bool),
+		pauseGate:               base.NewPauseGate(),
+		agentPool:               base.NewAgentPool(int(options.mutationDifferBatchSize) * base.AgentQueueSizePerBatchKey),
+		streamStartPlan:         &base.StreamStartPlan{},
+		runningPhases:           make(map[string]bool),
 	}
 	if options.fileContaingXattrKeysForNoComapre != "" {
 		readFile, er := os.Open(options.fileContaingXattrKeysForNoComapre)
@@ -353,18 +953,54 @@ func NewDiffTool(legacyMode bool) (*xdcrDiffTool, error) {
 	// HLV and ImportCas needs to be stripped from the Xattrs
 	difftool.xattrKeysForNoCompare[xdcrBase.XATTR_HLV] = true
 	difftool.xattrKeysForNoCompare[xdcrBase.XATTR_MOU] = true
-	difftool.xattrKeysForNoCompare[xdcrBase.XATTR_MOBILE] = true
+	if !options.syncGatewayMode || options.syncGatewayIgnoreSyncXattr {
+		difftool.xattrKeysForNoCompare[xdcrBase.XATTR_MOBILE] = true
+	}
+	difftool.keyPrefixesToSkip = append(difftool.keyPrefixesToSkip, base.CanaryKeyPrefix)
+	if options.syncGatewayMode {
+		difftool.keyPrefixesToSkip = append(difftool.keyPrefixesToSkip, base.SyncGatewayKeyPrefix)
+	}
+	if !options.includeSystemCollections {
+		difftool.keyPrefixesToSkip = append(difftool.keyPrefixesToSkip, base.EventingMetadataKeyPrefix)
+	}
+	if options.filterTxnMetadata {
+		for _, xattrKey := range base.TxnXattrKeys {
+			difftool.xattrKeysForNoCompare[xattrKey] = true
+		}
+		difftool.keyPrefixesToSkip = append(difftool.keyPrefixesToSkip, base.TxnMetadataKeyPrefixes...)
+	}
+	difftool.vbuckets, err = utils.ParseVbucketList(options.vbuckets)
+	if err != nil {
+		fmt.Printf("Invalid vbuckets %v. err=%v\n", options.vbuckets, err)
+		return nil, err
+	}
 	logCtx := xdcrLog.DefaultLoggerContext
 	difftool.logger = xdcrLog.NewLogger("xdcrDiffTool", xdcrLog.DefaultLoggerContext)
 	if options.debugMode {
 		logCtx.SetLogLevel(xdcrLog.LogLevelDebug)
 		gocb.SetLogger(gocb.VerboseStdioLogger())
 	}
+	if options.sdkLogLevel != "" {
+		// Validated by main. Takes the place of the verbose SDK logging of debugMode
+		level, _ := base.ParseSDKLogLevel(options.sdkLogLevel)
+		routeSDKLogs(level)
+	}
 
 	difftool.selfRef, _ = metadata.NewRemoteClusterReference("", base.SelfReferenceName, options.sourceUrl, options.sourceUsername, options.sourcePassword,
 		"", false, "", nil, nil, nil, nil)
+	if options.collectionMapping != "" {
+		difftool.collectionMapping, err = base.ParseCollectionMapping(options.collectionMapping)
+		if err != nil {
+			fmt.Printf("Invalid collectionMapping %v. err=%v\n", options.collectionMapping, err)
+			return nil, err
+		}
+	}
 
-	if !legacyMode {
+	if options.sameCluster {
+		if err = difftool.setupSameCluster(); err != nil {
+			return nil, err
+		}
+	} else if !legacyMode {
 		difftool.metadataSvc, err = metadata_svc.NewMetaKVMetadataSvc(nil, difftool.utils, true /*readOnly*/)
 		if err != nil {
 			return nil, err
@@ -444,6 +1080,7 @@ func NewDiffTool(legacyMode bool) (*xdcrDiffTool, error) {
 
 	// Capture any Ctrl-C for continuing to next steps or cleanup
 	go difftool.monitorInterruptSignal()
+	go difftool.monitorPauseSignals()
 
 	return difftool, err
 }
@@ -601,12 +1238,540 @@ func maybeSetEnv(key, value string) {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == resultsCommand {
+		if err := runResultsCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == mergeCommand {
+		if err := runMergeCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == decryptCommand {
+		if err := runDecryptCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == verifyCommand {
+		if err := runVerifyCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == captureCommand {
+		if err := runCaptureCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == catalogCommand {
+		if err := runCatalogCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == colocateCommand {
+		if err := runColocateCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == watchCommand {
+		if err := runWatchCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == scheduleCommand {
+		if err := runScheduleCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == meshCommand {
+		if err := runMeshCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == fileDiffSelftestCommand {
+		if err := runFileDiffSelftestCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == spotCheckCommand {
+		runArgs, err := spotCheckArgs(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		os.Args = append(os.Args[:1:1], runArgs...)
+	}
+
+	var estimateOnly bool
+	if len(os.Args) > 1 && os.Args[1] == estimateCommand {
+		// Takes the same options as the run being estimated
+		os.Args = append(os.Args[:1:1], os.Args[2:]...)
+		estimateOnly = true
+	}
+
 	argParse()
 
+	if options.printVersion {
+		fmt.Println(base.GetBuildInfo())
+		return
+	}
+
+	if options.profile != "" {
+		if err := applyProfile(options.profile); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	base.SetupTimeoutSeconds = options.setupTimeout
 
+	if err := setClusterLabels(options.sourceLabel, options.targetLabel); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	faults, err := base.ParseFaults(options.injectFaults)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid injectFaults: %v\n", err)
+		os.Exit(1)
+	}
+	if faults != nil {
+		fmt.Printf("WARNING: injecting faults %v. Differences reported by this run are not to be trusted\n", faults)
+		base.Faults = faults
+	}
+
 	validateCompareType(options.compareType)
 
+	if options.fastMode && options.runMutationDiffer {
+		fmt.Printf("Fast mode is enabled. Mutation differ will not be run\n")
+		options.runMutationDiffer = false
+	}
+
+	if options.streamFileDiff && (options.monitor || !options.completeBySeqno || !options.runDataGeneration || !options.runFileDiffer) {
+		fmt.Printf("streamFileDiff requires completeBySeqno, with both data generation and the file differ enabled, and is not supported in monitor mode\n")
+		os.Exit(1)
+	}
+
+	if options.monitor && options.completeBySeqno {
+		// The streams have to stay open past the seqnos at start time
+		fmt.Printf("Monitor mode is enabled. Streaming will not complete by seqno\n")
+		options.completeBySeqno = false
+	}
+
+	if options.syncGatewayMode && !flagIsSet("compareType") {
+		// Metadata of documents written through Sync Gateway differs between clusters by design
+		fmt.Printf("Sync Gateway mode is enabled. Mutation differ will compare document bodies\n")
+		options.compareType = base.MutationCompareTypeBodyOnly
+	}
+
+	if options.sameCluster {
+		if options.remoteClusterName != "" || options.targetUrl != "" || options.targetUsername != "" || options.targetPorts != "" {
+			fmt.Fprintf(os.Stderr, "sameCluster compares buckets of the source cluster, and takes no remoteClusterName, targetUrl, targetUsername or targetPorts\n")
+			os.Exit(1)
+		}
+		if options.targetBucketName == "" {
+			options.targetBucketName = options.sourceBucketName
+		}
+		if !flagIsSet("compareType") {
+			// Copies are written anew, with metadata of their own
+			fmt.Printf("Same cluster mode is enabled. Mutation differ will compare document bodies\n")
+			options.compareType = base.MutationCompareTypeBodyOnly
+		}
+		differ.SetCompareBodiesOnly(true)
+	}
+
+	if options.sourceDcpBufferSize < base.DcpFlowControlOff || options.targetDcpBufferSize < base.DcpFlowControlOff {
+		fmt.Fprintf(os.Stderr, "sourceDcpBufferSize and targetDcpBufferSize cannot be negative, other than %v to turn flow control off\n", base.DcpFlowControlOff)
+		os.Exit(1)
+	}
+
+	var probeDir string
+	if estimateOnly {
+		var err error
+		if probeDir, err = prepareEstimate(); err != nil {
+			fmt.Printf("Unable to prepare the estimate: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var comparePaths []string
+	if options.comparePaths != "" {
+		var err error
+		if comparePaths, err = differ.ParseComparePaths(options.comparePaths); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid comparePaths: %v\n", err)
+			os.Exit(1)
+		}
+		requireBodyComparison("comparePaths")
+	}
+
+	var jsonComparator *differ.JSONComparator
+	if options.unorderedArrayPaths != "" || options.numberAbsTolerance != 0 || options.numberRelTolerance != 0 {
+		var err error
+		if jsonComparator, err = differ.NewJSONComparator(strings.Split(options.unorderedArrayPaths, ","),
+			options.numberAbsTolerance, options.numberRelTolerance); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid JSON comparison options: %v\n", err)
+			os.Exit(1)
+		}
+		requireBodyComparison("unorderedArrayPaths and number tolerances")
+	}
+
+	if options.bodyChunksThresholdKB > 0 {
+		requireBodyComparison("bodyChunksThresholdKB")
+	} else if options.bodyChunksIncludeBytes {
+		fmt.Fprintf(os.Stderr, "bodyChunksIncludeBytes requires bodyChunksThresholdKB\n")
+		os.Exit(1)
+	}
+
+	if options.conflictLogCollection != "" {
+		if !options.runMutationDiffer {
+			fmt.Fprintf(os.Stderr, "conflictLogCollection requires the mutation differ, which is not run\n")
+			os.Exit(1)
+		}
+		if _, err := base.ConflictLogStatement(options.conflictLogCollection, options.conflictLogKeyField); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if options.captureNoValue && options.compareType != base.MutationCompareTypeMetadata {
+		fmt.Fprintf(os.Stderr, "captureNoValue does not capture document bodies, and requires compareType %v\n", base.MutationCompareTypeMetadata)
+		os.Exit(1)
+	}
+
+	ttlPolicy, err := differ.ParseTTLPolicy(options.ttlPolicy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	compressionPolicy, err := differ.ParseCompressionPolicy(options.compressionPolicy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if _, err = differ.ParseKeySharding(options.mutationDifferKeySharding); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if options.numberOfWorkersForMutationDiffer == 0 {
+		fmt.Fprintf(os.Stderr, "numberOfWorkersForMutationDiffer has to be at least 1\n")
+		os.Exit(1)
+	}
+
+	if options.sourcePorts != "" {
+		if base.SourcePorts, err = base.ParseClusterPorts(options.sourcePorts); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid sourcePorts: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if options.targetPorts != "" {
+		if base.TargetPorts, err = base.ParseClusterPorts(options.targetPorts); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid targetPorts: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	// Connection strings of the SDK are taken apart into the REST URL of their first node and the ports and options
+	// every connection to the cluster is made with
+	if base.IsConnStr(options.sourceUrl) {
+		if options.sourceUrl, base.SourcePorts, err = base.ParseConnStr(options.sourceUrl, base.SourcePorts); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid sourceUrl: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		options.sourceUrl = base.SourcePorts.Url(options.sourceUrl)
+	}
+	if base.IsConnStr(options.targetUrl) {
+		if options.targetUrl, base.TargetPorts, err = base.ParseConnStr(options.targetUrl, base.TargetPorts); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid targetUrl: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		options.targetUrl = base.TargetPorts.Url(options.targetUrl)
+	}
+	if options.sameCluster {
+		// The target is the source cluster itself
+		base.TargetPorts = base.SourcePorts
+	}
+	base.MaxDcpReconnects = int(options.maxDcpReconnects)
+
+	var healthThresholds *base.HealthThresholds
+	if options.healthThresholds != "" {
+		if options.healthPollIntervalSecs == 0 {
+			fmt.Fprintf(os.Stderr, "healthPollIntervalSecs has to be greater than 0\n")
+			os.Exit(1)
+		}
+		if healthThresholds, err = base.ParseHealthThresholds(options.healthThresholds); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid healthThresholds: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var criticalKeys *base.CriticalKeys
+	if options.criticalKeys != "" {
+		criticalKeysBytes, err := ioutil.ReadFile(options.criticalKeys)
+		if err == nil {
+			criticalKeys, err = base.ParseCriticalKeys(criticalKeysBytes)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid criticalKeys %v: %v\n", options.criticalKeys, err)
+			os.Exit(1)
+		}
+	}
+
+	var verdictFunc differ.VerdictFunc
+	if options.verdictPlugin != "" {
+		if !differ.VerdictPluginsSupported {
+			fmt.Fprintf(os.Stderr, "verdictPlugin cannot be used: %v\n", differ.ErrVerdictPluginsUnsupported)
+			os.Exit(1)
+		}
+		if !options.runMutationDiffer {
+			fmt.Fprintf(os.Stderr, "verdictPlugin requires the mutation differ, which is not run\n")
+			os.Exit(1)
+		}
+		var err error
+		if verdictFunc, err = differ.LoadVerdictPlugin(options.verdictPlugin); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to load verdictPlugin %v: %v\n", options.verdictPlugin, err)
+			os.Exit(1)
+		}
+	}
+
+	if (options.sourceVerifyUsername == "") != (options.sourceVerifyPassword == "") ||
+		(options.targetVerifyUsername == "") != (options.targetVerifyPassword == "") {
+		fmt.Fprintf(os.Stderr, "A verify username has to be given along with its password\n")
+		os.Exit(1)
+	}
+
+	if options.tlsUseSystemRoots || options.tlsCAFile != "" || options.tlsSkipHostnameVerification ||
+		options.tlsPinnedSANs != "" || options.tlsPinnedFingerprints != "" {
+		var err error
+		if base.TLSVerification, err = base.NewTLSPolicy(options.tlsUseSystemRoots, options.tlsCAFile,
+			options.tlsSkipHostnameVerification, options.tlsPinnedSANs, options.tlsPinnedFingerprints); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid TLS verification options: %v\n", err)
+			os.Exit(1)
+		}
+		if options.tlsSkipHostnameVerification {
+			fmt.Printf("WARNING: hostname verification is skipped. Certificate chains are checked by a handshake with every node, and the SDK connections are not verified\n")
+		}
+	}
+
+	var encryptionKey *encryption.Key
+	if options.encryptOutput {
+		var err error
+		// Loaded up front, so that a run is not wasted on a key that cannot be had
+		if encryptionKey, err = loadEncryptionKey(options.encryptionKeyFile, options.encryptionKeyCommand); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to load the encryption key: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var artifactSigner crypto.Signer
+	if options.artifactSigningKeyFile != "" {
+		if options.artifactManifest == "" {
+			fmt.Fprintf(os.Stderr, "artifactSigningKeyFile requires artifactManifest\n")
+			os.Exit(1)
+		}
+		keyBytes, err := ioutil.ReadFile(options.artifactSigningKeyFile)
+		if err == nil {
+			artifactSigner, err = results.ParseSigningKey(keyBytes)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to load artifactSigningKeyFile: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if options.baselineRunDir != "" {
+		if !options.runDataGeneration || options.oldSourceCheckpointFileName == "" || options.oldTargetCheckpointFileName == "" {
+			fmt.Fprintf(os.Stderr, "baselineRunDir requires runDataGeneration, and oldSourceCheckpointFileName and oldTargetCheckpointFileName naming the checkpoints the baseline run saved\n")
+			os.Exit(1)
+		}
+		for _, dir := range []string{options.sourceFileDir, options.targetFileDir, options.checkpointFileDir} {
+			if filepath.IsAbs(dir) {
+				fmt.Fprintf(os.Stderr, "baselineRunDir requires sourceFileDir, targetFileDir and checkpointFileDir relative to the run directory, not %v\n", dir)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if options.abortAfterDiffs < 0 || (options.abortAfterDiffs > 0 && !options.runFileDiffer) {
+		fmt.Printf("abortAfterDiffs has to be a positive number of differences, and requires the file differ\n")
+		os.Exit(1)
+	}
+
+	if options.captureBackend != base.CaptureBackendDcp && options.captureBackend != base.CaptureBackendRangeScan {
+		fmt.Fprintf(os.Stderr, "Invalid captureBackend %v. Accepted values are %v and %v\n", options.captureBackend,
+			base.CaptureBackendDcp, base.CaptureBackendRangeScan)
+		os.Exit(1)
+	}
+	if options.captureBackend == base.CaptureBackendRangeScan && (!options.completeBySeqno || options.monitor ||
+		options.newCheckpointFileName != "" || options.oldSourceCheckpointFileName != "" || options.oldTargetCheckpointFileName != "") {
+		fmt.Fprintf(os.Stderr, "The rangeScan capture backend requires completeBySeqno, and neither monitor nor checkpoints, as a scan has no seqno to resume from\n")
+		os.Exit(1)
+	}
+	if options.rangeScanSamples > 0 && (options.captureBackend != base.CaptureBackendRangeScan || options.streamFileDiff ||
+		!options.runMutationDiffer || options.diffKeysSource != "") {
+		fmt.Fprintf(os.Stderr, "rangeScanSamples requires the rangeScan capture backend and the mutation differ, which verifies the sampled keys, and neither streamFileDiff nor diffKeysSource\n")
+		os.Exit(1)
+	}
+
+	if options.inMemoryCaptureMB > 0 && (!options.runDataGeneration || !options.runFileDiffer || options.newCheckpointFileName != "" ||
+		options.oldSourceCheckpointFileName != "" || options.oldTargetCheckpointFileName != "") {
+		fmt.Fprintf(os.Stderr, "inMemoryCaptureMB requires runDataGeneration and runFileDiffer, and no checkpoints to be saved or resumed from, as a capture held in memory is gone once the run ends\n")
+		os.Exit(1)
+	}
+
+	var seedDataset *results.SeedDataset
+	if options.seedDocuments > 0 {
+		if options.sameCluster || !options.runDataGeneration || !options.runFileDiffer || !options.runMutationDiffer ||
+			options.vbuckets != "" || options.rangeScanSamples > 0 || options.suppressionFile != "" || options.abortAfterDiffs > 0 {
+			fmt.Fprintf(os.Stderr, "seedDocuments requires a replication to seed through and every phase of the run over all vbuckets, and neither rangeScanSamples, suppressionFile nor abortAfterDiffs\n")
+			os.Exit(1)
+		}
+		var err error
+		if seedDataset, err = results.NewSeedDataset(options.seedDocuments, options.seedDivergences); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid seedDivergences: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	streamStartPlan := &base.StreamStartPlan{}
+	if options.streamStartFile != "" {
+		if !options.runDataGeneration || options.captureBackend != base.CaptureBackendDcp {
+			fmt.Fprintf(os.Stderr, "streamStartFile requires runDataGeneration with the dcp capture backend\n")
+			os.Exit(1)
+		}
+		var err error
+		if streamStartPlan, err = base.ReadStreamStartPlan(options.streamStartFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid streamStartFile: %v\n", err)
+			os.Exit(1)
+		}
+		if (streamStartPlan.Source.NeedsCheckpoint() && options.oldSourceCheckpointFileName == "") ||
+			(streamStartPlan.Target.NeedsCheckpoint() && options.oldTargetCheckpointFileName == "") {
+			fmt.Fprintf(os.Stderr, "streamStartFile starts vbuckets from the checkpoint, which requires oldSourceCheckpointFileName or oldTargetCheckpointFileName for their cluster\n")
+			os.Exit(1)
+		}
+	}
+
+	if options.progressEvents != "" {
+		var err error
+		if base.Progress, err = base.OpenProgressEvents(options.progressEvents); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to open progressEvents: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if options.sdkLogLevel != "" {
+		if _, err := base.ParseSDKLogLevel(options.sdkLogLevel); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid sdkLogLevel: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if options.circuitBreakerPercent < 0 || options.circuitBreakerPercent >= 100 ||
+		options.retryBudgetPercent < 0 || options.retryBudgetPercent > 100 {
+		fmt.Fprintf(os.Stderr, "circuitBreakerPercent has to be at least 0 and below 100, and retryBudgetPercent between 0 and 100\n")
+		os.Exit(1)
+	}
+	if options.circuitBreakerAction != base.CircuitBreakerPause && options.circuitBreakerAction != base.CircuitBreakerAbort {
+		fmt.Fprintf(os.Stderr, "circuitBreakerAction has to be %v or %v\n", base.CircuitBreakerPause, base.CircuitBreakerAbort)
+		os.Exit(1)
+	}
+	if options.circuitBreakerPercent > 0 && options.circuitBreakerAction == base.CircuitBreakerPause &&
+		!pauseSignalsSupported && options.controlListen == "" {
+		fmt.Fprintf(os.Stderr, "circuitBreakerAction pause requires controlListen to resume the run by on this platform\n")
+		os.Exit(1)
+	}
+
+	if options.reconcileWinner != "" {
+		if options.reconcileWinner != base.ReconcileWinnerSource && options.reconcileWinner != base.ReconcileWinnerTarget {
+			fmt.Fprintf(os.Stderr, "reconcileWinner has to be %v or %v\n", base.ReconcileWinnerSource, base.ReconcileWinnerTarget)
+			os.Exit(1)
+		}
+		if !options.runMutationDiffer {
+			fmt.Fprintf(os.Stderr, "reconcileWinner requires the mutation differ\n")
+			os.Exit(1)
+		}
+	}
+
+	var repairLog *differ.RepairLog
+	if options.verifyRepairs != "" {
+		if !options.runMutationDiffer || options.runDataGeneration || options.runFileDiffer || options.diffKeysSource != "" ||
+			options.compareType != base.MutationCompareTypeBodyOnly {
+			fmt.Fprintf(os.Stderr, "verifyRepairs requires the mutation differ alone, without runDataGeneration, runFileDiffer or diffKeysSource, and compareType %v, as documents written by a repair have metadata of their own\n",
+				base.MutationCompareTypeBodyOnly)
+			os.Exit(1)
+		}
+		if options.repairSettleSecs < 0 {
+			fmt.Fprintf(os.Stderr, "repairSettleSecs cannot be negative\n")
+			os.Exit(1)
+		}
+		var err error
+		if repairLog, err = differ.ReadRepairLog(options.verifyRepairs); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read the repairs of %v: %v\n", options.verifyRepairs, err)
+			os.Exit(1)
+		}
+	}
+
+	if options.diffKeysCollection != "" {
+		if !strings.HasPrefix(options.diffKeysSource, base.DiffKeysSourceN1QLPrefix) {
+			fmt.Fprintf(os.Stderr, "diffKeysCollection applies to a diffKeysSource that is a %v query\n", base.DiffKeysSourceN1QLPrefix)
+			os.Exit(1)
+		}
+		if parts := strings.Split(options.diffKeysCollection, xdcrBase.ScopeCollectionDelimiter); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			fmt.Fprintf(os.Stderr, "Invalid diffKeysCollection %v. It has to be scope.collection\n", options.diffKeysCollection)
+			os.Exit(1)
+		}
+	}
+	if !base.ValidCapellaClusters(options.capellaClusters) {
+		fmt.Fprintf(os.Stderr, "Invalid capellaClusters %v. Accepted values are auto, source, target, both and none\n", options.capellaClusters)
+		os.Exit(1)
+	}
+	if options.readUnitPrice < 0 || options.maxReadUnitSpend < 0 {
+		fmt.Fprintf(os.Stderr, "readUnitPrice and maxReadUnitSpend cannot be negative\n")
+		os.Exit(1)
+	}
+	if options.maxReadUnitSpend > 0 && options.readUnitPrice == 0 {
+		fmt.Fprintf(os.Stderr, "maxReadUnitSpend requires readUnitPrice, for the spend to be counted in read units\n")
+		os.Exit(1)
+	}
+
+	var failThresholds map[string]int
+	if options.runVerdict != "" {
+		var err error
+		if failThresholds, err = results.ParseFailThresholds(options.failThreshold); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid failThreshold: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	fmt.Printf("differ is run with options: %+v\n", options)
 	legacyMode := len(options.targetUsername) > 0
 
@@ -614,11 +1779,56 @@ func main() {
 		fmt.Printf("Unable to set up directory structure: %v\n", err)
 		os.Exit(1)
 	}
+	if options.baselineRunDir != "" {
+		if err := stageBaselineCapture(); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to reuse the capture of %v: %v\n", options.baselineRunDir, err)
+			os.Exit(1)
+		}
+	}
+	// Only once the baseline capture is staged, as it takes the directories to be relative to the run directory
+	if err := longPathDirs(); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to resolve the output directories: %v\n", err)
+		os.Exit(1)
+	}
 
+	if options.diagnosticsOnFailure {
+		defer gatherDiagnosticsOnPanic()
+	}
 	difftool, err := NewDiffTool(legacyMode)
 	if err != nil {
-		fmt.Printf("Error creating difftool: %v\n", err)
-		os.Exit(1)
+		failRun("Error creating difftool: %v\n", err)
+	}
+	difftool.logger.Infof("Running %v\n", base.GetBuildInfo())
+	difftool.comparePaths = comparePaths
+	difftool.verdictFunc = verdictFunc
+	difftool.repairLog = repairLog
+	difftool.jsonComparator = jsonComparator
+	difftool.seedDataset = seedDataset
+	difftool.streamStartPlan = streamStartPlan
+	if criticalKeys != nil {
+		difftool.criticalKeys = criticalKeys
+		difftool.criticalKeysReport = &results.CriticalKeysReport{}
+	}
+	if options.suppressionFile != "" {
+		// Loaded up front, so that a malformed file is found before the run rather than after it
+		if difftool.suppressions, err = results.LoadSuppressions(options.suppressionFile); err != nil {
+			fmt.Printf("Unable to load suppressionFile %v: %v\n", options.suppressionFile, err)
+			os.Exit(1)
+		}
+	}
+	if options.tenantFile != "" {
+		if difftool.tenants, err = results.LoadTenants(options.tenantFile); err != nil {
+			fmt.Printf("Unable to load tenantFile %v: %v\n", options.tenantFile, err)
+			os.Exit(1)
+		}
+	}
+
+	if options.autoTune {
+		numOfVbuckets := len(difftool.vbuckets)
+		if numOfVbuckets == 0 {
+			numOfVbuckets = base.NumberOfVbuckets
+		}
+		autoTuneOptions(numOfVbuckets, difftool.logger)
 	}
 
 	if options.enforceTLS {
@@ -637,35 +1847,410 @@ func main() {
 		}
 		// OK to ignore metakv err in manual mode
 		if err := difftool.populateTemporarySpecAndRef(); err != nil {
-			fmt.Printf("%v\n", err)
-			os.Exit(1)
+			failRun("%v\n", err)
 		}
 	}
-	if options.runDataGeneration {
-		err := difftool.generateDataFiles()
-		if err != nil {
-			fmt.Printf("Error generating data files. err=%v\n", err)
+	if ttlPolicy == differ.TTLPolicyAuto {
+		ttlPolicy = differ.TTLPolicyOfReplication(difftool.specifiedSpec.Settings.GetExpDelMode())
+	}
+	if ttlPolicy == differ.TTLPolicyStrip {
+		fmt.Printf("The replication strips TTLs. Documents that differ by TTL only are reported as %v\n", base.ExpectedByConfigurationCategory)
+	}
+	differ.SetTTLPolicy(ttlPolicy)
+	if compressionPolicy == differ.CompressionPolicyAuto {
+		compressionPolicy = difftool.compressionPolicyOfBuckets()
+	}
+	differ.SetCompressionPolicy(compressionPolicy)
+	if state := difftool.replicationState; !state.Running() {
+		if options.skipInactiveReplication {
+			fmt.Printf("Skipping the run, as the %v\n", state)
+			os.Exit(replicationInactiveExitCode)
+		}
+		fmt.Printf("The %v. Differences, keys missing from the target in particular, may be due to it\n", state)
+	}
+	difftool.setUpReadUnitBudget()
+	if estimateOnly {
+		if err := difftool.runEstimate(probeDir); err != nil {
+			fmt.Printf("Unable to estimate the run: %v\n", err)
 			os.Exit(1)
 		}
-	} else {
-		fmt.Printf("Skipping  generating data files since it has been disabled\n")
+		return
+	}
+	if options.runMutationDiffer {
+		difftool.lookUpPurgeIntervals()
+	}
+	if options.controlListen != "" {
+		go difftool.serveControl(options.controlListen)
+	}
+	stopHealthPollCh := make(chan bool)
+	if healthThresholds != nil {
+		difftool.throttle = base.NewThrottle()
+		difftool.healthThrottler = base.NewHealthThrottler(healthThresholds, difftool.throttle,
+			func() bool { return difftool.pause(healthThrottleRequester) },
+			func() bool { return difftool.resume(healthThrottleRequester) })
+		go difftool.pollClusterHealth(time.Duration(options.healthPollIntervalSecs)*time.Second, stopHealthPollCh)
+	}
+	difftool.setUpCircuitBreakers()
+	stopKeepAliveCh := make(chan bool)
+	if options.keepAliveSecs > 0 {
+		go difftool.keepConnectionsAlive(time.Duration(options.keepAliveSecs)*time.Second, stopKeepAliveCh)
+	}
+	if difftool.seedDataset != nil {
+		if err := difftool.seedClusters(); err != nil {
+			failRun("Unable to seed the clusters. err=%v\n", err)
+		}
+	}
+	if options.canaryCollection != "" {
+		// Measured for context. The run carries on regardless
+		if err := difftool.measureCanaryLatency(); err != nil {
+			fmt.Printf("Unable to measure replication latency. err=%v\n", err)
+		}
+	}
+	// Ahead of capture, so that the critical keys have an answer however the rest of the run goes. Prefixes
+	// have to wait for the capture to be matched against
+	if difftool.criticalKeys != nil && len(difftool.criticalKeys.Keys) > 0 {
+		if err := difftool.verifyCriticalKeys(results.CriticalKeysPassFirst, false); err != nil {
+			fmt.Printf("Unable to verify critical keys. err=%v\n", err)
+		}
 	}
 
-	if options.runFileDiffer {
-		err := difftool.diffDataFiles()
+	if options.streamFileDiff {
+		difftool.handoff = newVbHandoff()
+		fileDiffErrCh := make(chan error, 1)
+		go func() {
+			fileDiffErrCh <- difftool.runPhase(results.PhaseFileDiff, difftool.diffDataFiles)
+		}()
+		err := difftool.runPhase(results.PhaseCapture, difftool.generateDataFiles)
+		difftool.handoff.captureDone()
 		if err != nil {
-			fmt.Printf("Error running file difftool. err=%v\n", err)
-			os.Exit(1)
+			failRun("Error generating data files. err=%v\n", err)
+		}
+		if err = <-fileDiffErrCh; err != nil {
+			failRun("Error running file difftool. err=%v\n", err)
 		}
+		difftool.checkReadUnitBudget()
 	} else {
-		fmt.Printf("Skipping file difftool since it has been disabled\n")
+		if options.runDataGeneration {
+			err := difftool.runPhase(results.PhaseCapture, difftool.generateDataFiles)
+			if err != nil {
+				failRun("Error generating data files. err=%v\n", err)
+			}
+		} else {
+			fmt.Printf("Skipping  generating data files since it has been disabled\n")
+		}
+		difftool.checkReadUnitBudget()
+
+		if options.runFileDiffer && difftool.abortReason != "" {
+			// What was captured until then is not all of either bucket, which the file differ would take for differences
+			fmt.Printf("Skipping file difftool since the run was %v\n", difftool.abortReason)
+		} else if options.runFileDiffer && options.rangeScanSamples > 0 {
+			err := difftool.runPhase(results.PhaseFileDiff, difftool.diffSampledKeys)
+			if err != nil {
+				failRun("Error reading sampled keys. err=%v\n", err)
+			}
+		} else if options.runFileDiffer {
+			err := difftool.runPhase(results.PhaseFileDiff, difftool.diffDataFiles)
+			if err != nil {
+				failRun("Error running file difftool. err=%v\n", err)
+			}
+		} else {
+			fmt.Printf("Skipping file difftool since it has been disabled\n")
+		}
 	}
 
-	if options.runMutationDiffer {
-		difftool.runMutationDiffer()
+	if options.runMutationDiffer && difftool.abortReason != "" {
+		fmt.Printf("Skipping mutation diff since the run was %v\n", difftool.abortReason)
+	} else if options.runMutationDiffer {
+		difftool.runPhase(results.PhaseMutationDiff, func() error {
+			difftool.runMutationDiffer()
+			return nil
+		})
 	} else {
 		fmt.Printf("Skipping mutation diff since it has been disabled\n")
 	}
+	if difftool.seedDataset != nil {
+		var err error
+		if difftool.seedFailures, err = checkSeededDivergences(difftool.seedDataset); err != nil {
+			failRun("Unable to check the seeded divergences. err=%v\n", err)
+		}
+	}
+	// Also when the run was aborted, as the critical keys are few enough to be verified regardless
+	if difftool.criticalKeys != nil {
+		if err := difftool.verifyCriticalKeys(results.CriticalKeysPassFinal, true); err != nil {
+			fmt.Printf("Unable to verify critical keys. err=%v\n", err)
+		}
+	}
+
+	close(stopHealthPollCh)
+	close(stopKeepAliveCh)
+	difftool.agentPool.Close()
+
+	if options.suppressionFile != "" {
+		difftool.applySuppressions()
+	}
+	// After the suppressions, so that tenants are not sent the divergences that were accepted
+	if options.tenantFile != "" {
+		difftool.splitOutputByTenant()
+	}
+
+	if len(difftool.manifestDivergences) > 0 {
+		fmt.Printf("Manifest divergences:\n")
+		for _, divergence := range difftool.manifestDivergences {
+			fmt.Printf("  %v\n", divergence)
+		}
+	}
+	if state := difftool.replicationState; !state.Running() {
+		fmt.Printf("Replication: %v\n", state)
+	}
+	if latency := difftool.canaryLatency; latency != nil {
+		if latency.TimedOut {
+			fmt.Printf("Replication latency: canary %v was not replicated within %v seconds\n", latency.Key, options.canaryTimeoutSecs)
+		} else {
+			fmt.Printf("Replication latency: %v\n", latency.Latency)
+		}
+	}
+	if difftool.clockSkew != nil {
+		fmt.Printf("Clock skew between clusters: %v (+/- %v)\n", difftool.clockSkew.MaxSkew, difftool.clockSkew.MaxSkewUncertainty)
+		for _, warning := range difftool.clockSkew.Warnings {
+			fmt.Printf("  %v\n", warning)
+		}
+	}
+	if distribution := difftool.distribution; distribution != nil {
+		fmt.Printf("Document distribution (%v / %v):\n", base.SourceClusterLabel, base.TargetClusterLabel)
+		fmt.Printf("  %-14v %12v / %-12v\n", "documents", distribution.Source.Documents, distribution.Target.Documents)
+		fmt.Printf("  %-14v %12v / %-12v\n", "tombstones", distribution.Source.Tombstones, distribution.Target.Tombstones)
+		for i, label := range results.DocumentSizeLabels() {
+			fmt.Printf("  %-14v %12v / %-12v\n", label, distribution.Source.Sizes[i], distribution.Target.Sizes[i])
+		}
+		fmt.Printf("  %-14v %12v / %-12v\n", "JSON", distribution.Source.JSON, distribution.Target.JSON)
+		fmt.Printf("  %-14v %12v / %-12v\n", "binary", distribution.Source.Binary, distribution.Target.Binary)
+		fmt.Printf("  %-14v %12v / %-12v\n", "compressed", distribution.Source.Compressed, distribution.Target.Compressed)
+		fmt.Printf("  %-14v %12v / %-12v\n", "with xattrs", distribution.Source.WithXattrs, distribution.Target.WithXattrs)
+		for _, skew := range distribution.Skews {
+			fmt.Printf("  Skew: %v\n", skew)
+		}
+	}
+	if keyOverlap := difftool.keyOverlap; keyOverlap != nil {
+		fmt.Printf("Key overlap: %v\n", keyOverlap.Total)
+		for _, collection := range keyOverlap.Collections {
+			fmt.Printf("  %v -> %v: %v\n", collectionLabel(collection.SourceCollection, collection.SourceColId),
+				collectionLabel(collection.TargetCollection, collection.TargetColId), collection)
+		}
+	}
+	if hotWindows := difftool.hotWindows; hotWindows != nil && len(hotWindows.Windows) > 0 {
+		fmt.Printf("Divergence hot windows, by time of last mutation:\n")
+		for _, window := range hotWindows.Windows {
+			fmt.Printf("  %v\n", window)
+		}
+	}
+	for _, phase := range []string{results.PhaseFileDiff, results.PhaseMutationDiff} {
+		summary := difftool.suppressionSummaries[phase]
+		if summary == nil {
+			continue
+		}
+		fmt.Printf("Suppressed %v known divergences from the %v output\n", summary.Suppressed, phase)
+		for _, suppression := range summary.Expired {
+			fmt.Printf("  Suppression of %v expired on %v and is reported again\n", suppression, suppression.Expires)
+		}
+	}
+	difftool.printDivergenceBreakdown()
+	for _, phase := range []string{results.PhaseFileDiff, results.PhaseMutationDiff} {
+		summaries := difftool.tenantSummaries[phase]
+		if summaries == nil {
+			continue
+		}
+		fmt.Printf("Divergences by tenant in the %v output, split into %v:\n", phase, results.TenantsDirName)
+		for _, summary := range summaries {
+			fmt.Printf("  %v\n", summary)
+		}
+	}
+	if report := difftool.criticalKeysReport; report != nil {
+		fmt.Printf("Critical keys:\n")
+		for _, pass := range report.Passes {
+			fmt.Printf("  %v\n", pass)
+		}
+		if latest := report.Latest(); latest != nil {
+			for category, keys := range latest.Differences {
+				fmt.Printf("  %v: %v\n", category, keys)
+			}
+			if len(latest.Unverified) > 0 {
+				fmt.Printf("  Could not be fetched: %v\n", latest.Unverified)
+			}
+		}
+	}
+	if len(difftool.slowestKeys) > 0 {
+		fmt.Printf("Slowest keys, by times their operations exceeded the deadline of %v seconds:\n", options.mutationDifferTimeout)
+		for i, slowKey := range difftool.slowestKeys {
+			if i == base.SlowestKeysReported {
+				fmt.Printf("  ... %v more in %v\n", len(difftool.slowestKeys)-i, base.MutationDiffSlowestKeysFileName)
+				break
+			}
+			fmt.Printf("  %v on %v (collection %v): %v times\n", slowKey.Key, slowKey.Cluster, slowKey.ColId, slowKey.Exceeded)
+		}
+	}
+	if base.CaptureMemory != nil {
+		if size, spilled := base.CaptureMemory.Status(); spilled {
+			fmt.Printf("Capture spilled to disk at %v MB\n", size/1024/1024)
+		} else {
+			fmt.Printf("Capture held in memory: %v MB\n", size/1024/1024)
+		}
+	}
+	if difftool.healthThrottler != nil {
+		reductions, pauses := difftool.healthThrottler.Actions()
+		fmt.Printf("Health throttle: concurrency reduced %v times, run paused %v times\n", reductions, pauses)
+	}
+	if base.Faults != nil {
+		fmt.Printf("Injected faults: %v\n", base.Faults.Injected())
+	}
+	difftool.printCircuitBreakers()
+	difftool.printReadUnits()
+	if difftool.abortReason == results.AbortedThresholdExceeded {
+		fmt.Printf("Run %v, as at least %v keys were found to differ. The output holds what was found until then\n",
+			difftool.abortReason, options.abortAfterDiffs)
+	} else if difftool.abortReason != "" {
+		fmt.Printf("Run %v. The output holds what was found until then\n", difftool.abortReason)
+	}
+	if dataset := difftool.seedDataset; dataset != nil {
+		if len(difftool.seedFailures) == 0 {
+			fmt.Printf("Seeded dataset: found exactly the %v injected divergences of each kind among %v documents\n",
+				dataset.Divergences, dataset.Documents)
+		}
+		for _, failure := range difftool.seedFailures {
+			fmt.Printf("Seeded dataset FAILED %v\n", failure)
+		}
+	}
+	if usage := difftool.phaseResourceUsage(); len(usage) > 0 {
+		fmt.Printf("Resource usage by phase:\n")
+		for _, phase := range usage {
+			fmt.Printf("  %v\n", phase)
+		}
+	}
+	fmt.Printf("Stats summary:\n%v\n", stats.Default.Snapshot())
+
+	// Counted before the output is encrypted. The verdict itself is left in the clear for gates to read
+	var verdict *results.RunVerdict
+	if options.runVerdict != "" {
+		verdict = writeRunVerdict(failThresholds, difftool.abortReason)
+	}
+	if encryptionKey != nil {
+		encryptOutput(encryptionKey)
+	}
+	// Last, so that the digests are of the output as it is left, encrypted or not
+	if options.artifactManifest != "" {
+		writeArtifactManifest(artifactSigner)
+	}
+	if len(difftool.seedFailures) > 0 {
+		os.Exit(seedCheckFailExitCode)
+	}
+	if verdict != nil && verdict.Verdict == results.RunVerdictFail {
+		os.Exit(runVerdictFailExitCode)
+	}
+}
+
+// Tells a failed verdict apart from the run itself failing
+const runVerdictFailExitCode = 2
+
+// Tells a run skipped with skipInactiveReplication apart from a failed one
+const replicationInactiveExitCode = 3
+
+// Tells a seeded run that did not find exactly the divergences injected apart from a failed one
+const seedCheckFailExitCode = 4
+
+func writeRunVerdict(thresholds map[string]int, abortReason string) *results.RunVerdict {
+	verdict, err := results.NewRunVerdict(runVerdictPatterns(options.fileDifferDir, options.mutationDifferDir), thresholds)
+	if err == nil && abortReason != "" {
+		verdict.SetAborted(abortReason)
+	}
+	if err == nil {
+		err = verdict.Write(options.runVerdict)
+	}
+	if err != nil {
+		fmt.Printf("Unable to write run verdict %v: %v\n", options.runVerdict, err)
+		os.Exit(1)
+	}
+	if verdict.Reason != "" {
+		fmt.Printf("Run verdict: %v, as %v. Written to %v\n", verdict.Verdict, verdict.Reason, options.runVerdict)
+	} else {
+		fmt.Printf("Run verdict: %v with %v differences. Written to %v\n", verdict.Verdict, verdict.Differences, options.runVerdict)
+	}
+	return verdict
+}
+
+// Width, in characters, of the bar of the most common kind of divergence in the summary
+const divergenceBarWidth = 40
+
+// Counted after the suppressions, as the verdict is, so that the breakdown adds up to what the run reports
+func (difftool *xdcrDiffTool) printDivergenceBreakdown() {
+	breakdown, err := results.NewDivergenceBreakdown(runVerdictPatterns(options.fileDifferDir, options.mutationDifferDir))
+	if err != nil {
+		fmt.Printf("Unable to break down the divergences: %v\n", err)
+		return
+	}
+	if breakdown.Phase == "" {
+		return
+	}
+	if summary := difftool.suppressionSummaries[breakdown.Phase]; summary != nil {
+		breakdown.Suppressed = summary.Suppressed
+	}
+	if breakdown.Phase == results.PhaseMutationDiff {
+		breakdown.Unverifiable += int(stats.Default.Counter(stats.MutationDiffKeysErrored).Value())
+	}
+	fmt.Printf("Divergences by kind in the %v output:\n", breakdown.Phase)
+	for _, line := range breakdown.Table(divergenceBarWidth) {
+		fmt.Printf("  %v\n", line)
+	}
+}
+
+// The output files of each phase a verdict counts the differences of
+func runVerdictPatterns(fileDifferDir, mutationDifferDir string) map[string]string {
+	return map[string]string{
+		results.PhaseFileDiff:     filepath.Join(fileDifferDir, base.DiffDetailsFileName+base.FileNameDelimiter+"*"),
+		results.PhaseMutationDiff: filepath.Join(mutationDifferDir, base.MutationDiffFileName),
+	}
+}
+
+// Checkpoints are left out, as they only hold seqnos and are rewritten by the runs resuming from them
+func runOutputPaths() []string {
+	paths := []string{options.sourceFileDir, options.targetFileDir, options.fileDifferDir, options.mutationDifferDir}
+	if options.monitor {
+		paths = append(paths, options.monitorEventsFile)
+	}
+	if options.criticalKeys != "" {
+		paths = append(paths, options.criticalKeysDir)
+	}
+	return paths
+}
+
+func writeArtifactManifest(signer crypto.Signer) {
+	paths := runOutputPaths()
+	if options.runVerdict != "" {
+		paths = append(paths, options.runVerdict)
+	}
+	manifest, err := results.NewArtifactManifest(options.artifactManifest, paths)
+	if err == nil {
+		err = manifest.Write(options.artifactManifest, signer)
+	}
+	if err != nil {
+		fmt.Printf("Unable to write artifact manifest %v: %v\n", options.artifactManifest, err)
+		os.Exit(1)
+	}
+	if signer != nil {
+		fmt.Printf("Wrote the digests of %v artifacts to %v, signed in %v\n", len(manifest.Artifacts),
+			options.artifactManifest, options.artifactManifest+results.ArtifactSignatureSuffix)
+	} else {
+		fmt.Printf("Wrote the digests of %v artifacts to %v\n", len(manifest.Artifacts), options.artifactManifest)
+	}
+}
+
+func encryptOutput(key *encryption.Key) {
+	for _, path := range runOutputPaths() {
+		count, err := key.EncryptTree(path)
+		if err != nil {
+			fmt.Printf("Unable to encrypt %v: %v\n", path, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Encrypted %v files of %v\n", count, path)
+	}
 }
 
 func isURLLoopBack(url string) bool {
@@ -691,6 +2276,63 @@ func setupDirectories() error {
 	return nil
 }
 
+// Resolves the directories the run writes to so that paths under them can exceed MAX_PATH on Windows
+func longPathDirs() error {
+	for _, dir := range []*string{&options.sourceFileDir, &options.targetFileDir, &options.checkpointFileDir,
+		&options.fileDifferDir, &options.mutationDifferDir} {
+		longPathDir, err := base.LongPathDir(*dir)
+		if err != nil {
+			return err
+		}
+		*dir = longPathDir
+	}
+	return nil
+}
+
+// Links the capture files of the baseline run into the capture directories, and copies the checkpoints it saved
+// next to them. Streams then resume from those checkpoints, so that vbuckets whose high seqno did not advance
+// are not streamed at all, and the others only from where the baseline run stopped. The file differ keeps the
+// record of the highest seqno of each document, which makes the stitched capture read as a single one
+func stageBaselineCapture() error {
+	clusters := []struct {
+		fileDir, label, checkpointFileName string
+	}{
+		{options.sourceFileDir, base.SourceClusterLabel, options.oldSourceCheckpointFileName},
+		{options.targetFileDir, base.TargetClusterLabel, options.oldTargetCheckpointFileName},
+	}
+	for _, cluster := range clusters {
+		checkpointName := cluster.label + base.FileNameDelimiter + cluster.checkpointFileName
+		from := filepath.Join(options.baselineRunDir, options.checkpointFileDir, checkpointName)
+		to := filepath.Join(options.checkpointFileDir, checkpointName)
+		if _, err := os.Stat(to); err == nil {
+			return fmt.Errorf("checkpoint %v already exists", to)
+		}
+		if err := base.CloneFile(from, to); err != nil {
+			return err
+		}
+
+		linked, err := base.LinkCaptureFiles(filepath.Join(options.baselineRunDir, cluster.fileDir), cluster.fileDir)
+		if err != nil {
+			return err
+		}
+		// Documents are spread over the capture files of a vbucket by key, which only lines up with the same number of bins
+		var numberOfBins int
+		for _, fileName := range linked {
+			if parts := strings.Split(fileName, base.FileNameDelimiter); len(parts) == 3 {
+				if bin, err := strconv.Atoi(parts[2]); err == nil && bin >= numberOfBins {
+					numberOfBins = bin + 1
+				}
+			}
+		}
+		if len(linked) > 0 && numberOfBins != int(options.numberOfBins) {
+			return fmt.Errorf("the baseline run was captured with %v capture files per vbucket rather than numberOfBins %v",
+				numberOfBins, options.numberOfBins)
+		}
+		fmt.Printf("Reusing %v capture files of %v from %v\n", len(linked), cluster.label, options.baselineRunDir)
+	}
+	return nil
+}
+
 func (difftool *xdcrDiffTool) createFilter() error {
 	var ok bool
 	var expr string
@@ -719,11 +2361,26 @@ func (difftool *xdcrDiffTool) generateDataFiles() error {
 	difftool.logger.Infof("GenerateDataFiles routine started\n")
 	defer difftool.logger.Infof("GenerateDataFiles routine completed\n")
 
-	if options.completeByDuration == 0 && !options.completeBySeqno {
+	if options.completeByDuration == 0 && !options.completeBySeqno && !options.monitor {
 		difftool.logger.Infof("completeByDuration is required when completeBySeqno is false\n")
 		os.Exit(1)
 	}
 
+	if options.monitor {
+		if err := difftool.startMonitor(); err != nil {
+			return err
+		}
+		defer difftool.stopMonitor()
+	}
+	difftool.captureStarted = time.Now()
+	if difftool.readUnitBudget.Limit() > 0 {
+		stopBudgetWatchCh := make(chan bool)
+		defer close(stopBudgetWatchCh)
+		go difftool.watchReadUnitBudget(stopBudgetWatchCh)
+	}
+	defer difftool.meterPhase(results.PhaseCapture, []string{base.SourceClusterLabel, base.TargetClusterLabel},
+		options.sourceFileDir, options.targetFileDir)()
+
 	errChan := make(chan error, 1)
 	waitGroup := &sync.WaitGroup{}
 
@@ -734,31 +2391,24 @@ func (difftool *xdcrDiffTool) generateDataFiles() error {
 
 	if err := difftool.createFilter(); err != nil {
 		difftool.logger.Errorf("Error creating filter: %v", err.Error())
-		os.Exit(1)
+		failRun("Error creating filter: %v\n", err)
+	}
+	if options.inMemoryCaptureMB > 0 {
+		difftool.setUpInMemoryCapture()
 	}
 
-	difftool.sourceDcpDriver = startDcpDriver(difftool.logger, base.SourceClusterName, options.sourceUrl, difftool.specifiedSpec.SourceBucketName,
-		difftool.selfRef, options.sourceFileDir, options.checkpointFileDir,
-		options.oldSourceCheckpointFileName, options.newCheckpointFileName, options.numberOfSourceDcpClients,
-		options.numberOfWorkersPerSourceDcpClient, options.numberOfBins, options.sourceDcpHandlerChanSize,
-		options.bucketOpTimeout, options.maxNumOfGetStatsRetry, options.getStatsRetryInterval,
-		options.getStatsMaxBackoff, options.checkpointInterval, errChan, waitGroup, options.completeBySeqno, fileDescPool, difftool.filter,
-		difftool.srcCapabilities, difftool.srcCollectionIds, difftool.colFilterOrderedKeys, difftool.utils, options.bucketBufferCapacity,
-		difftool.migrationMapping, difftool.specifiedSpec.Settings.GetMobileCompatible(), difftool.specifiedSpec.Settings.GetExpDelMode(), difftool.xattrKeysForNoCompare)
+	difftool.sourceDcpDriver = difftool.startSourceDcpDriver(errChan, waitGroup, fileDescPool, options.oldSourceCheckpointFileName,
+		options.newCheckpointFileName, options.completeBySeqno, difftool.vbuckets, difftool.mutationObserver(monitor.Source),
+		difftool.handoff.observer(base.SourceClusterName), difftool.streamStartPlan.Source)
 
 	delayDurationBetweenSourceAndTarget := time.Duration(options.delayBetweenSourceAndTarget) * time.Second
 	difftool.logger.Infof("Waiting for %v before starting target dcp clients\n", delayDurationBetweenSourceAndTarget)
 	time.Sleep(delayDurationBetweenSourceAndTarget)
 
 	difftool.logger.Infof("Starting target dcp clients\n")
-	difftool.targetDcpDriver = startDcpDriver(difftool.logger, base.TargetClusterName, difftool.specifiedRef.HostName_,
-		difftool.specifiedSpec.TargetBucketName, difftool.specifiedRef,
-		options.targetFileDir, options.checkpointFileDir, options.oldTargetCheckpointFileName, options.newCheckpointFileName,
-		options.numberOfTargetDcpClients, options.numberOfWorkersPerTargetDcpClient, options.numberOfBins, options.targetDcpHandlerChanSize,
-		options.bucketOpTimeout, options.maxNumOfGetStatsRetry, options.getStatsRetryInterval, options.getStatsMaxBackoff,
-		options.checkpointInterval, errChan, waitGroup, options.completeBySeqno, fileDescPool, difftool.filter,
-		difftool.tgtCapabilities, difftool.tgtCollectionIds, difftool.colFilterOrderedKeys, difftool.utils, options.bucketBufferCapacity,
-		difftool.migrationMapping, difftool.specifiedSpec.Settings.GetMobileCompatible(), difftool.specifiedSpec.Settings.GetExpDelMode(), difftool.xattrKeysForNoCompare)
+	difftool.targetDcpDriver = difftool.startTargetDcpDriver(errChan, waitGroup, fileDescPool, options.oldTargetCheckpointFileName,
+		options.newCheckpointFileName, options.completeBySeqno, difftool.vbuckets, difftool.mutationObserver(monitor.Target),
+		difftool.handoff.observer(base.TargetClusterName), difftool.streamStartPlan.Target)
 
 	difftool.curState.mtx.Lock()
 	difftool.curState.state = StateDcpStarted
@@ -771,7 +2421,68 @@ func (difftool *xdcrDiffTool) generateDataFiles() error {
 		err = difftool.waitForDuration(difftool.sourceDcpDriver, difftool.targetDcpDriver, errChan, options.completeByDuration, delayDurationBetweenSourceAndTarget)
 	}
 
-	return err
+	difftool.checkClockSkew()
+	if !options.captureNoValue {
+		// Documents streamed without their bodies have no sizes to compare
+		difftool.compareDistributions()
+	}
+	return err
+}
+
+// Normalizes datatypes unless compression is off on both buckets. Should the mode of a bucket not be known, it is
+// taken to compress documents, as normalizing only hides differences of the snappy bit
+func (difftool *xdcrDiffTool) compressionPolicyOfBuckets() differ.CompressionPolicy {
+	modes := make([]string, 2)
+	for i, cluster := range []struct {
+		label  string
+		ref    *metadata.RemoteClusterReference
+		bucket string
+	}{
+		{base.SourceClusterLabel, difftool.selfRef, difftool.specifiedSpec.SourceBucketName},
+		{base.TargetClusterLabel, difftool.specifiedRef, difftool.specifiedSpec.TargetBucketName},
+	} {
+		bucketInfo := make(map[string]interface{})
+		err := difftool.getClusterRestApi(cluster.ref, xdcrBase.DefaultPoolBucketsPath+cluster.bucket, &bucketInfo)
+		if err == nil {
+			modes[i], err = utils.GetBucketCompressionModeFromBucketInfo(cluster.bucket, bucketInfo)
+		}
+		if err != nil {
+			difftool.logger.Warnf("Unable to get the compression mode of %v bucket %v. err=%v\n", cluster.label, cluster.bucket, err)
+			modes[i] = "unknown"
+		}
+	}
+	policy := differ.CompressionPolicyOfBuckets(modes[0], modes[1])
+	if policy == differ.CompressionPolicyNormalize {
+		fmt.Printf("Bucket compression modes are %v on %v and %v on %v. Documents compressed on one side only are not reported as differing by datatype\n",
+			modes[0], base.SourceClusterLabel, modes[1], base.TargetClusterLabel)
+	}
+	return policy
+}
+
+// Clocks are measured by each DCP driver as it starts
+func (difftool *xdcrDiffTool) checkClockSkew() {
+	srcClocks, tgtClocks := difftool.sourceDcpDriver.Clocks(), difftool.targetDcpDriver.Clocks()
+	if len(srcClocks) == 0 || len(tgtClocks) == 0 {
+		difftool.logger.Warnf("Node clocks were not measured on both clusters. Clock skew is not checked\n")
+		return
+	}
+
+	lww := difftool.sourceDcpDriver.IsLWW() || difftool.targetDcpDriver.IsLWW()
+	difftool.clockSkew = results.NewClockSkewReport(srcClocks, tgtClocks, lww,
+		time.Duration(options.clockSkewThresholdSecs)*time.Second)
+	difftool.logger.Infof("Largest clock skew between source and target nodes is %v (+/- %v)\n",
+		difftool.clockSkew.MaxSkew, difftool.clockSkew.MaxSkewUncertainty)
+	for _, warning := range difftool.clockSkew.Warnings {
+		difftool.logger.Warnf("Clock skew: %v\n", warning)
+	}
+}
+
+func (difftool *xdcrDiffTool) compareDistributions() {
+	difftool.distribution = results.NewDistributionReport(difftool.sourceDcpDriver.Distribution(),
+		difftool.targetDcpDriver.Distribution(), base.DistributionSkewPercent)
+	for _, skew := range difftool.distribution.Skews {
+		difftool.logger.Warnf("Document distribution: %v\n", skew)
+	}
 }
 
 func (difftool *xdcrDiffTool) diffDataFiles() error {
@@ -786,14 +2497,47 @@ func (difftool *xdcrDiffTool) diffDataFiles() error {
 	if err != nil {
 		return fmt.Errorf("Error mkdir fileDifferDir: %v\n", err)
 	}
+	difftool.writeRunMetadata(options.fileDifferDir)
+	stopMeter := difftool.meterPhase(results.PhaseFileDiff, nil, options.fileDifferDir)
 
 	difftoolDriver := differ.NewDifferDriver(options.sourceFileDir, options.targetFileDir, options.fileDifferDir,
 		base.DiffKeysFileName, int(options.numberOfWorkersForFileDiffer), int(options.numberOfBins),
-		int(options.numberOfFileDesc), difftool.srcToTgtColIdsMap, difftool.colFilterOrderedKeys, difftool.colFilterOrderedTargetColId, difftool.specifiedSpec.SourceBucketUUID, difftool.specifiedSpec.TargetBucketUUID, difftool.bucketTopologySvc, difftool.specifiedSpec, difftool.logger)
+		int(options.numberOfFileDesc), difftool.srcToTgtColIdsMap, difftool.colFilterOrderedKeys, difftool.colFilterOrderedTargetColId, difftool.specifiedSpec.SourceBucketUUID, difftool.specifiedSpec.TargetBucketUUID, difftool.bucketTopologySvc, difftool.specifiedSpec, difftool.logger, difftool.vbuckets)
+	difftoolDriver.SetRestreamCallback(difftool.restreamVbucket)
+	if difftool.handoff != nil {
+		difftoolDriver.SetCaptureHandoff(difftool.handoff.ready, difftool.handoff.done)
+	}
+	if options.hotWindowSecs > 0 {
+		difftoolDriver.SetHotWindowSize(time.Duration(options.hotWindowSecs) * time.Second)
+	}
+	if options.abortAfterDiffs > 0 {
+		// When streaming, the capture still going on is stopped as well
+		difftoolDriver.SetAbortAfterDiffs(options.abortAfterDiffs, difftool.abortCapture)
+	}
 	err = difftoolDriver.Run()
 	if err != nil {
 		difftool.logger.Errorf("Error from diffDataFiles = %v\n", err)
 	}
+	if difftoolDriver.Aborted() {
+		difftool.abortReason = results.AbortedThresholdExceeded
+	}
+	srcManifest, tgtManifest := difftool.capturedManifests()
+	difftool.keyOverlap = difftoolDriver.KeyOverlap(collectionNames(srcManifest), collectionNames(tgtManifest))
+	if difftool.keyOverlap != nil {
+		difftool.logger.Infof("Key overlap: %v\n", difftool.keyOverlap.Total)
+	}
+	difftool.hotWindows = difftoolDriver.HotWindows(base.HotWindowMinSharePercent)
+	if difftool.hotWindows != nil {
+		for _, window := range difftool.hotWindows.Windows {
+			difftool.logger.Infof("Divergence hot window: %v\n", window)
+		}
+	}
+	stopMeter()
+	// Hot windows, clock skew when streaming, and the resources used are only known once the file differ has run
+	difftool.writeRunMetadata(options.fileDifferDir)
+	if corruptedVbs := difftoolDriver.CorruptedVbs(); len(corruptedVbs) > 0 {
+		difftool.logger.Errorf("The following vbuckets were not compared because their capture files are corrupted: %v\n", corruptedVbs)
+	}
 	difftoolDriver.MapLock.RLock()
 	if difftool.colFilterOrderedKeys == nil {
 		difftool.logger.Infof("Source vb to item count map: %v", difftoolDriver.SrcVbItemCntMap)
@@ -801,12 +2545,12 @@ func (difftool *xdcrDiffTool) diffDataFiles() error {
 	difftool.logger.Infof("Target vb to item count map: %v", difftoolDriver.TgtVbItemCntMap)
 	difftoolDriver.MapLock.RUnlock()
 	if difftool.colFilterOrderedKeys == nil {
-		difftool.logger.Infof("Source bucket item count including tombstones is %v (excluding %v filtered mutations)", difftoolDriver.SourceItemCount, difftool.sourceDcpDriver.FilteredCount())
+		difftool.logger.Infof("Source bucket item count including tombstones is %v (excluding %v filtered mutations)", difftoolDriver.SourceItemCount(), difftool.sourceDcpDriver.FilteredCount())
 	} else {
 		difftool.logger.Infof("Replication is in migration mode from the source bucket")
 	}
-	difftool.logger.Infof("Target bucket item count including tombstones is %v (excluding %v filtered mutations)", difftoolDriver.TargetItemCount, difftool.targetDcpDriver.FilteredCount())
-	if difftool.colFilterOrderedKeys == nil && difftoolDriver.SourceItemCount != difftoolDriver.TargetItemCount {
+	difftool.logger.Infof("Target bucket item count including tombstones is %v (excluding %v filtered mutations)", difftoolDriver.TargetItemCount(), difftool.targetDcpDriver.FilteredCount())
+	if difftool.colFilterOrderedKeys == nil && difftoolDriver.SourceItemCount() != difftoolDriver.TargetItemCount() {
 		difftool.logger.Infof("Here are the vbuckets with different item counts:")
 		for vb, c1 := range difftoolDriver.SrcVbItemCntMap {
 			c2 := difftoolDriver.TgtVbItemCntMap[vb]
@@ -816,9 +2560,146 @@ func (difftool *xdcrDiffTool) diffDataFiles() error {
 		}
 	}
 	difftool.duplicatedMapping = difftoolDriver.DuplicatedHint
+	if options.fastMode {
+		srcDiffCnt, tgtDiffCnt := difftoolDriver.DiffKeysCount()
+		difftool.logger.Infof("Fast mode found %v source keys and %v target keys that differ. Details are under %v", srcDiffCnt, tgtDiffCnt, options.fileDifferDir)
+	}
 	return err
 }
 
+// The reference of the cluster as seen by the phases that read and write documents, i.e. the mutation differ, as
+// opposed to DCP capture. Under least privilege the two can need different roles, and so different users
+func (difftool *xdcrDiffTool) verificationRef(source bool) *metadata.RemoteClusterReference {
+	ref, username, password := difftool.selfRef, options.sourceVerifyUsername, options.sourceVerifyPassword
+	if !source {
+		ref, username, password = difftool.specifiedRef, options.targetVerifyUsername, options.targetVerifyPassword
+	}
+	if username == "" {
+		return ref
+	}
+	verificationRef := ref.Clone()
+	verificationRef.UserName_ = username
+	verificationRef.Password_ = password
+	// A client certificate would take precedence over the password
+	verificationRef.ClientCertificate_ = nil
+	verificationRef.ClientKey_ = nil
+	return verificationRef
+}
+
+// The UUIDs of the losing cluster of reconcileWinner and of its bucket, as the cluster serves them
+func (difftool *xdcrDiffTool) reconcileLoserUUIDs() (string, string, error) {
+	ref, bucketName := difftool.specifiedRef, difftool.specifiedSpec.TargetBucketName
+	if options.reconcileWinner == base.ReconcileWinnerTarget {
+		ref, bucketName = difftool.selfRef, difftool.specifiedSpec.SourceBucketName
+	}
+	var uuids []string
+	for _, path := range []string{base.PoolsPath, base.PoolsDefaultBucketPath + bucketName} {
+		info := make(map[string]interface{})
+		err, statusCode := difftool.utils.QueryRestApiWithAuth(ref.HostName(), path, false, ref.UserName(), ref.Password(),
+			ref.HttpAuthMech(), ref.Certificates(), ref.SANInCertificate(), ref.ClientCertificate(), ref.ClientKey(),
+			xdcrBase.MethodGet, "", nil, 0, &info, nil, false, difftool.logger)
+		if err != nil {
+			return "", "", err
+		}
+		if statusCode != http.StatusOK {
+			return "", "", fmt.Errorf("%v returned status %v", path, statusCode)
+		}
+		uuid, ok := info["uuid"].(string)
+		if !ok || uuid == "" {
+			return "", "", fmt.Errorf("%v returned no UUID", path)
+		}
+		uuids = append(uuids, uuid)
+	}
+	return uuids[0], uuids[1], nil
+}
+
+func hasVerificationIdentity() bool {
+	return options.sourceVerifyUsername != "" || options.targetVerifyUsername != ""
+}
+
+// Runs a N1QL statement on the source cluster. Each row of the result is either a key,
+// i.e. SELECT RAW META().id, or an object with an "id" field
+func (difftool *xdcrDiffTool) queryKeys(statement string) ([]string, error) {
+	sourceRef := difftool.verificationRef(true)
+	cccpString := base.SourcePorts.TagOptions(utils.PopulateCCCPConnectString(options.sourceUrl, base.SourcePorts.KvPort(false)))
+	cluster, err := gocb.Connect(cccpString, gocb.ClusterOptions{
+		Authenticator: gocb.PasswordAuthenticator{
+			Username: sourceRef.UserName(),
+			Password: sourceRef.Password(),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cluster.Close(nil)
+
+	err = cluster.WaitUntilReady(time.Duration(base.SetupTimeoutSeconds)*time.Second, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	difftool.logger.Infof("Querying keys: %v\n", statement)
+	result, err := cluster.Query(statement, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	var keys []string
+	for result.Next() {
+		var row interface{}
+		if err = result.Row(&row); err != nil {
+			return nil, err
+		}
+		switch typedRow := row.(type) {
+		case string:
+			keys = append(keys, typedRow)
+		case map[string]interface{}:
+			key, ok := typedRow["id"].(string)
+			if !ok {
+				return nil, fmt.Errorf("query result row %v does not have an id", typedRow)
+			}
+			keys = append(keys, key)
+		default:
+			return nil, fmt.Errorf("query result row %v is neither a key nor an object", row)
+		}
+	}
+	return keys, result.Err()
+}
+
+// The source collection ID of the keys a diffKeysSource query returns, as read from the keyspace of the query
+// diffKeysCollection names the collection of queries whose keyspace cannot be told, and has to agree with the
+// keyspace otherwise
+func (difftool *xdcrDiffTool) diffKeysCollectionId() (uint32, error) {
+	if !strings.HasPrefix(options.diffKeysSource, base.DiffKeysSourceN1QLPrefix) {
+		return 0, nil
+	}
+	scope, collection, err := differ.N1QLQueryCollection(strings.TrimPrefix(options.diffKeysSource, base.DiffKeysSourceN1QLPrefix),
+		options.sourceBucketName)
+	if options.diffKeysCollection != "" {
+		// Validated when the options were parsed
+		parts := strings.Split(options.diffKeysCollection, xdcrBase.ScopeCollectionDelimiter)
+		if errors.Is(err, differ.ErrQueryOfOtherBucket) {
+			return 0, err
+		}
+		if err == nil && (parts[0] != scope || parts[1] != collection) {
+			return 0, fmt.Errorf("diffKeysCollection %v is not the collection the query reads, %v%v%v", options.diffKeysCollection,
+				scope, xdcrBase.ScopeCollectionDelimiter, collection)
+		}
+		scope, collection, err = parts[0], parts[1], nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%v. Name the collection with diffKeysCollection", err)
+	}
+	if scope == xdcrBase.DefaultScopeCollectionName && collection == xdcrBase.DefaultScopeCollectionName {
+		return 0, nil
+	}
+	if difftool.srcBucketManifest == nil {
+		return 0, fmt.Errorf("the collections of the source bucket are not known")
+	}
+	return difftool.srcBucketManifest.GetCollectionId(scope, collection)
+}
+
 func (difftool *xdcrDiffTool) runMutationDiffer() {
 	difftool.logger.Infof("runMutationDiffer started with compareBody=%v\n", options.compareType)
 	defer difftool.logger.Infof("runMutationDiffer completed\n")
@@ -832,29 +2713,263 @@ func (difftool *xdcrDiffTool) runMutationDiffer() {
 		err = fmt.Errorf("Error mkdir mutationDifferDir: %v\n", err)
 		return
 	}
+	difftool.writeRunMetadata(options.mutationDifferDir)
+	difftool.checkCaptureWindow()
+
+	mutationDiffer := difftool.newMutationDiffer(options.mutationDifferDir)
+	if options.diffKeysSource != "" {
+		queryColId, err := difftool.diffKeysCollectionId()
+		if err != nil {
+			difftool.logger.Errorf("Unable to tell the collection of the keys of %v: %v\n", options.diffKeysSource, err)
+			return
+		}
+		mutationDiffer.SetDiffKeysSource(options.diffKeysSource, difftool.queryKeys, queryColId)
+	}
+	if difftool.repairLog != nil {
+		mutationDiffer.SetRepairVerification(difftool.repairLog, time.Duration(options.repairSettleSecs)*time.Second)
+	}
+	if options.conflictLogCollection != "" {
+		difftool.loadKnownConflicts(mutationDiffer)
+	}
+	stopMeter := difftool.meterPhase(results.PhaseMutationDiff, []string{base.SourceClusterLabel, base.TargetClusterLabel},
+		options.mutationDifferDir)
+	err = mutationDiffer.Run()
+	if err != nil {
+		difftool.logger.Errorf("Error from runMutationDiffer = %v\n", err)
+	}
+	difftool.slowestKeys = mutationDiffer.SlowestKeys()
+	if difftool.openCircuit() != "" && difftool.abortReason == "" {
+		difftool.abortReason = results.AbortedCircuitOpen
+	}
+	difftool.checkReadUnitBudget()
+	stopMeter()
+	difftool.writeRunMetadata(options.mutationDifferDir)
+}
+
+// Meters the resources a phase uses, writing to the given directories, until the returned function is called
+func (difftool *xdcrDiffTool) meterPhase(phase string, clusters []string, dirs ...string) func() {
+	meter := results.StartResourceMeter(phase, clusters, dirs, base.ResourceSampleInterval*time.Millisecond)
+	return func() {
+		usage := meter.Stop()
+		difftool.logger.Infof("Resource usage of %v\n", usage)
+		difftool.resourceUsageLock.Lock()
+		defer difftool.resourceUsageLock.Unlock()
+		difftool.resourceUsage = append(difftool.resourceUsage, usage)
+	}
+}
+
+func (difftool *xdcrDiffTool) phaseResourceUsage() []*results.PhaseResourceUsage {
+	difftool.resourceUsageLock.Lock()
+	defer difftool.resourceUsageLock.Unlock()
+	return append([]*results.PhaseResourceUsage(nil), difftool.resourceUsage...)
+}
+
+// Read as the mutation differ starts, so that conflicts logged while the clusters were captured are included. Should
+// the conflict log not be readable, documents in conflict are reported as mismatches, as they are without it
+func (difftool *xdcrDiffTool) loadKnownConflicts(mutationDiffer *differ.MutationDiffer) {
+	// Validated when the options were parsed
+	statement, _ := base.ConflictLogStatement(options.conflictLogCollection, options.conflictLogKeyField)
+	keys, err := difftool.queryKeys(statement)
+	if err != nil {
+		difftool.logger.Errorf("Unable to read the conflict log %v. Documents in conflict are reported as mismatches. err=%v\n",
+			options.conflictLogCollection, err)
+		return
+	}
+	difftool.logger.Infof("%v documents have conflicts logged in %v\n", len(keys), options.conflictLogCollection)
+	mutationDiffer.SetKnownConflicts(keys)
+}
 
+// A mutation differ configured by the options of the run, writing its output to the given directory
+func (difftool *xdcrDiffTool) newMutationDiffer(outputDir string) *differ.MutationDiffer {
 	mutationDiffer := differ.NewMutationDiffer(difftool.specifiedSpec.SourceBucketName, difftool.specifiedSpec.SourceBucketUUID,
-		difftool.selfRef, difftool.specifiedSpec.TargetBucketName, difftool.specifiedSpec.TargetBucketUUID, difftool.specifiedRef,
-		options.fileDifferDir, options.mutationDifferDir, int(options.numberOfWorkersForMutationDiffer),
+		difftool.verificationRef(true), difftool.specifiedSpec.TargetBucketName, difftool.specifiedSpec.TargetBucketUUID, difftool.verificationRef(false),
+		options.fileDifferDir, outputDir, int(options.numberOfWorkersForMutationDiffer),
 		int(options.mutationDifferBatchSize), int(options.mutationDifferTimeout), int(options.maxNumOfSendBatchRetry),
 		time.Duration(options.sendBatchRetryInterval)*time.Millisecond,
 		time.Duration(options.sendBatchMaxBackoff)*time.Second, options.compareType, difftool.logger, difftool.srcToTgtColIdsMap,
 		difftool.srcCapabilities, difftool.tgtCapabilities, difftool.utils, options.mutationDifferRetries,
-		options.mutationDifferRetriesWaitSecs, difftool.duplicatedMapping)
-	err = mutationDiffer.Run()
-	if err != nil {
-		difftool.logger.Errorf("Error from runMutationDiffer = %v\n", err)
+		options.mutationDifferRetriesWaitSecs, difftool.duplicatedMapping, difftool.vbuckets)
+	if options.autoTune {
+		mutationDiffer.EnableAutoTune(options.autoTuneTargetImpact)
+	}
+	if options.auditTrail || options.auditRefetch {
+		mutationDiffer.EnableAuditTrail(options.auditRefetch)
+	}
+	if len(difftool.comparePaths) > 0 {
+		mutationDiffer.SetComparePaths(difftool.comparePaths)
+	}
+	if difftool.jsonComparator != nil {
+		mutationDiffer.SetJSONComparator(difftool.jsonComparator)
+	}
+	if difftool.verdictFunc != nil {
+		mutationDiffer.SetVerdictFunc(difftool.verdictFunc)
+	}
+	if options.mutationDiffChunkedDetails {
+		mutationDiffer.EnableChunkedDetails()
+	}
+	if options.mutationDifferBatchKB > 0 {
+		mutationDiffer.SetBatchBytes(options.mutationDifferBatchKB * 1024)
+	}
+	if options.bodyChunksThresholdKB > 0 {
+		mutationDiffer.SetBodyChunks(int(options.bodyChunksThresholdKB)*1024, options.bodyChunksIncludeBytes)
+	}
+	if options.reconcileWinner != "" {
+		srcManifest, tgtManifest := difftool.capturedManifests()
+		loserClusterUUID, loserBucketUUID, err := difftool.reconcileLoserUUIDs()
+		if err != nil {
+			difftool.logger.Errorf("Unable to get the UUIDs of the cluster and bucket to reconcile, which %v checks before it runs. Reconciliation is not written. err=%v\n",
+				base.ReconcileScriptFileName, err)
+		} else {
+			mutationDiffer.SetReconciliation(differ.NewReconciliation(options.reconcileWinner,
+				difftool.specifiedSpec.SourceBucketName, difftool.specifiedSpec.TargetBucketName, loserClusterUUID, loserBucketUUID,
+				collectionNames(srcManifest).Names, collectionNames(tgtManifest).Names))
+		}
+	}
+	mutationDiffer.SetPauseGate(difftool.pauseGate)
+	mutationDiffer.SetThrottle(difftool.throttle)
+	mutationDiffer.SetCircuitBreakers(difftool.sourceBreaker, difftool.targetBreaker)
+	mutationDiffer.SetReadUnitBudget(difftool.readUnitBudget)
+	mutationDiffer.SetPurgeHorizons(purgeHorizon(difftool.sourcePurgeInterval), purgeHorizon(difftool.targetPurgeInterval))
+	// Validated when the options were parsed
+	mutationDiffer.SetKeySharding(differ.KeySharding(options.mutationDifferKeySharding))
+	// Pooled agents are authenticated as the user of DCP capture
+	if !hasVerificationIdentity() {
+		mutationDiffer.SetAgentPool(difftool.agentPool)
+	}
+	return mutationDiffer
+}
+
+// Takes the known divergences out of the output of each phase that was run, and writes them next to it instead
+func (difftool *xdcrDiffTool) applySuppressions() {
+	dirs := map[string]string{
+		results.PhaseFileDiff:     options.fileDifferDir,
+		results.PhaseMutationDiff: options.mutationDifferDir,
+	}
+	patterns := map[string]string{
+		results.PhaseFileDiff:     base.DiffDetailsFileName + base.FileNameDelimiter + "*",
+		results.PhaseMutationDiff: base.MutationDiffFileName,
+	}
+	difftool.suppressionSummaries = make(map[string]*results.SuppressionSummary)
+	for phase, dir := range dirs {
+		fileNames, err := filepath.Glob(filepath.Join(dir, patterns[phase]))
+		if err != nil || len(fileNames) == 0 {
+			// The phase was not run
+			continue
+		}
+		metadata, err := results.ReadRunMetadata(dir)
+		if err != nil {
+			difftool.logger.Warnf("Unable to read run metadata of %v. Suppressions by collection do not apply. err=%v\n", dir, err)
+		}
+		summary, err := results.Suppress(phase, fileNames, metadata, difftool.suppressions, time.Now(),
+			filepath.Join(dir, results.SuppressedFileName))
+		if err != nil {
+			difftool.logger.Errorf("Unable to apply suppressions to the %v output. err=%v\n", phase, err)
+			continue
+		}
+		difftool.suppressionSummaries[phase] = summary
+	}
+}
+
+// Splits the output of each phase that was run into a directory of each tenant next to it, and counts the
+// divergences of each
+func (difftool *xdcrDiffTool) splitOutputByTenant() {
+	dirs := map[string]string{
+		results.PhaseFileDiff:     options.fileDifferDir,
+		results.PhaseMutationDiff: options.mutationDifferDir,
+	}
+	difftool.tenantSummaries = make(map[string][]*results.TenantSummary)
+	for phase, pattern := range runVerdictPatterns(options.fileDifferDir, options.mutationDifferDir) {
+		fileNames, err := filepath.Glob(pattern)
+		if err != nil || len(fileNames) == 0 {
+			// The phase was not run
+			continue
+		}
+		dir := dirs[phase]
+		metadata, err := results.ReadRunMetadata(dir)
+		if err != nil {
+			difftool.logger.Warnf("Unable to read run metadata of %v. Tenants by collection are not matched. err=%v\n", dir, err)
+		}
+		summaries, err := results.SplitByTenant(phase, fileNames, metadata, difftool.tenants,
+			filepath.Join(dir, results.TenantsDirName), filepath.Join(dir, results.TenantSummaryFileName))
+		if err != nil {
+			difftool.logger.Errorf("Unable to split the %v output by tenant. err=%v\n", phase, err)
+			continue
+		}
+		difftool.tenantSummaries[phase] = summaries
+	}
+}
+
+// Starts streaming the given vbuckets, or all of them if vbuckets is empty, from the source bucket
+func (difftool *xdcrDiffTool) startSourceDcpDriver(errChan chan error, waitGroup *sync.WaitGroup, fileDescPool fdp.FdPoolIface, oldCheckpointFileName, newCheckpointFileName string, completeBySeqno bool, vbuckets []uint16, mutationObserver func(*dcp.Mutation), vbucketCaptured func(vbno uint16), streamStarts *base.StreamStarts) *dcp.DcpDriver {
+	return startDcpDriver(difftool.logger, base.SourceClusterLabel, options.sourceUrl, difftool.specifiedSpec.SourceBucketName,
+		difftool.selfRef, options.sourceFileDir, options.checkpointFileDir,
+		oldCheckpointFileName, newCheckpointFileName, options.numberOfSourceDcpClients,
+		options.numberOfWorkersPerSourceDcpClient, options.numberOfBins, options.sourceDcpHandlerChanSize,
+		options.bucketOpTimeout, options.maxNumOfGetStatsRetry, options.getStatsRetryInterval,
+		options.getStatsMaxBackoff, options.checkpointInterval, errChan, waitGroup, completeBySeqno, fileDescPool, difftool.filter,
+		difftool.srcCapabilities, difftool.srcCollectionIds, difftool.colFilterOrderedKeys, difftool.utils, options.bucketBufferCapacity,
+		difftool.migrationMapping, difftool.specifiedSpec.Settings.GetMobileCompatible(), difftool.specifiedSpec.Settings.GetExpDelMode(), difftool.xattrKeysForNoCompare,
+		vbuckets, difftool.keyPrefixesToSkip, options.syncGatewayMode, options.captureBufferPoolSize, options.captureBufferHighWatermark,
+		options.sourceDcpBufferSize, options.useOSO, options.captureNoValue, mutationObserver, difftool.pauseGate, difftool.agentPool, vbucketCaptured,
+		options.captureBackend == base.CaptureBackendRangeScan, options.rangeScanSamples,
+		time.Duration(options.persistenceWaitTimeout)*time.Second, streamStarts)
+}
+
+// Starts streaming the given vbuckets, or all of them if vbuckets is empty, from the target bucket
+func (difftool *xdcrDiffTool) startTargetDcpDriver(errChan chan error, waitGroup *sync.WaitGroup, fileDescPool fdp.FdPoolIface, oldCheckpointFileName, newCheckpointFileName string, completeBySeqno bool, vbuckets []uint16, mutationObserver func(*dcp.Mutation), vbucketCaptured func(vbno uint16), streamStarts *base.StreamStarts) *dcp.DcpDriver {
+	return startDcpDriver(difftool.logger, base.TargetClusterLabel, difftool.specifiedRef.HostName_,
+		difftool.specifiedSpec.TargetBucketName, difftool.specifiedRef,
+		options.targetFileDir, options.checkpointFileDir, oldCheckpointFileName, newCheckpointFileName,
+		options.numberOfTargetDcpClients, options.numberOfWorkersPerTargetDcpClient, options.numberOfBins, options.targetDcpHandlerChanSize,
+		options.bucketOpTimeout, options.maxNumOfGetStatsRetry, options.getStatsRetryInterval, options.getStatsMaxBackoff,
+		options.checkpointInterval, errChan, waitGroup, completeBySeqno, fileDescPool, difftool.filter,
+		difftool.tgtCapabilities, difftool.tgtCollectionIds, difftool.colFilterOrderedKeys, difftool.utils, options.bucketBufferCapacity,
+		difftool.migrationMapping, difftool.specifiedSpec.Settings.GetMobileCompatible(), difftool.specifiedSpec.Settings.GetExpDelMode(), difftool.xattrKeysForNoCompare,
+		vbuckets, difftool.keyPrefixesToSkip, options.syncGatewayMode, options.captureBufferPoolSize, options.captureBufferHighWatermark,
+		options.targetDcpBufferSize, options.useOSO, options.captureNoValue, mutationObserver, difftool.pauseGate, difftool.agentPool, vbucketCaptured,
+		options.captureBackend == base.CaptureBackendRangeScan, options.rangeScanSamples,
+		time.Duration(options.persistenceWaitTimeout)*time.Second, streamStarts)
+}
+
+// Re-generates the capture files of a single vbucket from both clusters, i.e. when the existing ones are found
+// to be corrupted. Both sides are re-streamed so that they are captured at roughly the same point in time
+func (difftool *xdcrDiffTool) restreamVbucket(vbno uint16) error {
+	difftool.restreamLock.Lock()
+	defer difftool.restreamLock.Unlock()
+
+	if difftool.filter == nil {
+		if err := difftool.createFilter(); err != nil {
+			return err
+		}
+	}
+
+	for _, fileDir := range []string{options.sourceFileDir, options.targetFileDir} {
+		for i := 0; i < int(options.numberOfBins); i++ {
+			base.CaptureMemory.Remove(utils.GetFileName(fileDir, vbno, i))
+			err := os.Remove(utils.GetFileName(fileDir, vbno, i))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
 	}
+
+	difftool.logger.Infof("Re-streaming vb %v from source and target clusters\n", vbno)
+	errChan := make(chan error, 1)
+	waitGroup := &sync.WaitGroup{}
+	vbuckets := []uint16{vbno}
+	sourceDcpDriver := difftool.startSourceDcpDriver(errChan, waitGroup, nil, "", "", true, vbuckets, nil, nil, nil)
+	targetDcpDriver := difftool.startTargetDcpDriver(errChan, waitGroup, nil, "", "", true, vbuckets, nil, nil, nil)
+	return difftool.waitForCompletion(sourceDcpDriver, targetDcpDriver, errChan, waitGroup)
 }
 
-func startDcpDriver(logger *xdcrLog.CommonLogger, name, url, bucketName string, ref *metadata.RemoteClusterReference, fileDir, checkpointFileDir, oldCheckpointFileName, newCheckpointFileName string, numberOfDcpClients, numberOfWorkersPerDcpClient, numberOfBins, dcpHandlerChanSize, bucketOpTimeout, maxNumOfGetStatsRetry, getStatsRetryInterval, getStatsMaxBackoff, checkpointInterval uint64, errChan chan error, waitGroup *sync.WaitGroup, completeBySeqno bool, fdPool fdp.FdPoolIface, filter xdcrParts.Filter, capabilities metadata.Capability, collectionIDs []uint32, colMigrationFilters []string, utils xdcrUtils.UtilsIface, bucketBufferCap int, migrationMapping metadata.CollectionNamespaceMapping, mobileCompat int, expDelMode xdcrBase.FilterExpDelType, xattrKeysForNoCompare map[string]bool) *dcp.DcpDriver {
+func startDcpDriver(logger *xdcrLog.CommonLogger, name, url, bucketName string, ref *metadata.RemoteClusterReference, fileDir, checkpointFileDir, oldCheckpointFileName, newCheckpointFileName string, numberOfDcpClients, numberOfWorkersPerDcpClient, numberOfBins, dcpHandlerChanSize, bucketOpTimeout, maxNumOfGetStatsRetry, getStatsRetryInterval, getStatsMaxBackoff, checkpointInterval uint64, errChan chan error, waitGroup *sync.WaitGroup, completeBySeqno bool, fdPool fdp.FdPoolIface, filter xdcrParts.Filter, capabilities metadata.Capability, collectionIDs []uint32, colMigrationFilters []string, utils xdcrUtils.UtilsIface, bucketBufferCap int, migrationMapping metadata.CollectionNamespaceMapping, mobileCompat int, expDelMode xdcrBase.FilterExpDelType, xattrKeysForNoCompare map[string]bool, vbuckets []uint16, keyPrefixesToSkip []string, syncGatewayMode bool, bufferPoolSize, bufferHighWatermark, dcpBufferSize int, useOSO, noValue bool, mutationObserver func(*dcp.Mutation), pauseGate *base.PauseGate, agentPool *base.AgentPool, vbucketCaptured func(vbno uint16), rangeScan bool, rangeScanSamples uint64, persistenceWaitTimeout time.Duration, streamStarts *base.StreamStarts) *dcp.DcpDriver {
 	waitGroup.Add(1)
 	dcpDriver := dcp.NewDcpDriver(logger, name, url, bucketName, ref, fileDir, checkpointFileDir, oldCheckpointFileName,
 		newCheckpointFileName, int(numberOfDcpClients), int(numberOfWorkersPerDcpClient), int(numberOfBins),
 		int(dcpHandlerChanSize), time.Duration(bucketOpTimeout)*time.Second, int(maxNumOfGetStatsRetry),
 		time.Duration(getStatsRetryInterval)*time.Second, time.Duration(getStatsMaxBackoff)*time.Second,
 		int(checkpointInterval), errChan, waitGroup, completeBySeqno, fdPool, filter, capabilities, collectionIDs, colMigrationFilters,
-		utils, bucketBufferCap, migrationMapping, mobileCompat, expDelMode, xattrKeysForNoCompare)
+		utils, bucketBufferCap, migrationMapping, mobileCompat, expDelMode, xattrKeysForNoCompare, vbuckets, keyPrefixesToSkip, syncGatewayMode, bufferPoolSize, bufferHighWatermark, dcpBufferSize, useOSO, noValue, mutationObserver, pauseGate, agentPool, vbucketCaptured, rangeScan, rangeScanSamples, persistenceWaitTimeout, streamStarts)
 	// dcp driver startup may take some time. Do it asynchronously
 	go startDcpDriverAysnc(dcpDriver, errChan, logger)
 	return dcpDriver
@@ -892,14 +3007,36 @@ func (difftool *xdcrDiffTool) waitForCompletion(sourceDcpDriver, targetDcpDriver
 	return nil
 }
 
+// A duration of 0 waits until interrupted
 func (difftool *xdcrDiffTool) waitForDuration(sourceDcpDriver, targetDcpDriver *dcp.DcpDriver, errChan chan error, duration uint64, delayDurationBetweenSourceAndTarget time.Duration) (err error) {
-	timer := time.NewTimer(time.Duration(duration) * time.Second)
-
-	select {
-	case err = <-errChan:
-		difftool.logger.Errorf("Stop diff generation due to error from dcp client %v\n", err)
-	case <-timer.C:
-		difftool.logger.Infof("Stop diff generation after specified processing duration\n")
+	var timer *time.Timer
+	var timerCh <-chan time.Time
+	if duration > 0 {
+		timer = time.NewTimer(time.Duration(duration) * time.Second)
+		defer timer.Stop()
+		timerCh = timer.C
+	}
+	// Time spent paused does not count towards the duration
+	_, credited := difftool.pauseGate.Status()
+
+waitLoop:
+	for {
+		select {
+		case err = <-errChan:
+			difftool.logger.Errorf("Stop diff generation due to error from dcp client %v\n", err)
+			break waitLoop
+		case <-timerCh:
+			if _, pausedFor := difftool.pauseGate.Status(); pausedFor > credited {
+				timer.Reset(pausedFor - credited)
+				credited = pausedFor
+				continue
+			}
+			difftool.logger.Infof("Stop diff generation after specified processing duration\n")
+			break waitLoop
+		case <-difftool.interruptCh:
+			difftool.logger.Infof("Stop diff generation after interrupt\n")
+			break waitLoop
+		}
 	}
 
 	err1 := sourceDcpDriver.Stop()
@@ -917,6 +3054,64 @@ func (difftool *xdcrDiffTool) waitForDuration(sourceDcpDriver, targetDcpDriver *
 	return err
 }
 
+// Compares mutations made after streaming started, and reports those that do not converge across clusters in time
+func (difftool *xdcrDiffTool) startMonitor() error {
+	if len(difftool.colFilterOrderedKeys) > 0 {
+		return fmt.Errorf("monitor mode is not supported for replications in migration mode")
+	}
+
+	eventsFile, err := os.OpenFile(options.monitorEventsFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Error opening monitorEventsFile: %v", err)
+	}
+	difftool.monitorEventsFile = eventsFile
+	encoder := json.NewEncoder(eventsFile)
+
+	difftool.monitor = monitor.NewMonitor(time.Duration(options.monitorSettleSecs)*time.Second, func(event *monitor.Event) {
+		difftool.logger.Warnf("Divergence %v for key %v in target collection %v\n", event.Type, event.Key, event.ColId)
+		if err := encoder.Encode(event); err != nil {
+			difftool.logger.Errorf("Error writing monitor event for key %v. err=%v\n", event.Key, err)
+		}
+	})
+	difftool.monitor.Start()
+	difftool.logger.Infof("Monitoring mutations with a settle window of %v seconds. Divergences are written to %v\n",
+		options.monitorSettleSecs, options.monitorEventsFile)
+	return nil
+}
+
+func (difftool *xdcrDiffTool) stopMonitor() {
+	difftool.monitor.Stop()
+	err := difftool.monitorEventsFile.Close()
+	if err != nil {
+		difftool.logger.Errorf("Error closing monitorEventsFile. err=%v\n", err)
+	}
+}
+
+// Returns nil when not monitoring
+// Source collection IDs are translated to the target collection they replicate to, so that both sides of a document meet
+func (difftool *xdcrDiffTool) mutationObserver(side monitor.Side) func(*dcp.Mutation) {
+	if difftool.monitor == nil {
+		return nil
+	}
+	return func(mut *dcp.Mutation) {
+		colId := mut.ColId
+		if side == monitor.Source {
+			if tgtColIds := difftool.srcToTgtColIdsMap[colId]; len(tgtColIds) > 0 {
+				colId = tgtColIds[0]
+			}
+		}
+		key, _ := base.EncodeKey(string(mut.Key))
+		difftool.monitor.Observe(side, &monitor.Version{
+			ColId:   colId,
+			Key:     key,
+			Seqno:   mut.Seqno,
+			Cas:     mut.Cas,
+			RevId:   mut.RevId,
+			Deleted: mut.IsDeletion() || mut.IsExpiration(),
+		})
+	}
+}
+
 func (difftool *xdcrDiffTool) retrieveReplicationSpecInfo() error {
 	// CBAUTH has already been setup
 	var err error
@@ -956,6 +3151,7 @@ func (difftool *xdcrDiffTool) retrieveReplicationSpecInfo() error {
 	}
 
 	difftool.logger.Infof("Found Remote Cluster: %v and Replication Spec: %v\n", difftool.specifiedRef.String(), difftool.specifiedSpec.String())
+	difftool.checkReplicationState()
 	return nil
 }
 
@@ -980,25 +3176,45 @@ func (difftool *xdcrDiffTool) populateTemporarySpecAndRef() error {
 	return err
 }
 
+// SIGTERM is handled as an interrupt, as it is what service managers stop the run with. On Windows, closing the
+// console, logging off and shutting down are delivered as SIGTERM too
 func (difftool *xdcrDiffTool) monitorInterruptSignal() {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	for sig := range c {
-		if sig.String() == "interrupt" {
-			difftool.curState.mtx.Lock()
-			switch difftool.curState.state {
-			case StateInitial:
-				os.Exit(0)
-			case StateDcpStarted:
-				difftool.logger.Warnf("Received interrupt. Closing DCP drivers")
-				difftool.sourceDcpDriver.Stop()
-				difftool.targetDcpDriver.Stop()
-				difftool.curState.state = StateFinal
-			case StateFinal:
-				os.Exit(0)
-			}
-			difftool.curState.mtx.Unlock()
+		difftool.curState.mtx.Lock()
+		switch difftool.curState.state {
+		case StateInitial:
+			os.Exit(0)
+		case StateDcpStarted:
+			difftool.logger.Warnf("Received %v. Closing DCP drivers", sig)
+			difftool.closeDcpDriversLocked()
+		case StateFinal:
+			os.Exit(0)
 		}
+		difftool.curState.mtx.Unlock()
+	}
+}
+
+// Must be called with curState.mtx held, while DCP is started
+func (difftool *xdcrDiffTool) closeDcpDriversLocked() {
+	difftool.sourceDcpDriver.Stop()
+	difftool.targetDcpDriver.Stop()
+	close(difftool.interruptCh)
+	difftool.curState.state = StateFinal
+}
+
+// Stops the capture if it is still going on, as an interrupt would, once the run is aborted
+func (difftool *xdcrDiffTool) abortCapture() {
+	difftool.stopCapture(results.AbortedThresholdExceeded)
+}
+
+func (difftool *xdcrDiffTool) stopCapture(reason string) {
+	difftool.curState.mtx.Lock()
+	defer difftool.curState.mtx.Unlock()
+	if difftool.curState.state == StateDcpStarted {
+		difftool.logger.Warnf("Run is %v. Closing DCP drivers", reason)
+		difftool.closeDcpDriversLocked()
 	}
 }
 
@@ -1055,6 +3271,21 @@ func (difftool *xdcrDiffTool) populateSelfRef() error {
 	return nil
 }
 
+// REST requests go through goxdcr, which only verifies the chain against the reference certificates, so the
+// TLS policy is checked on the REST endpoints of both clusters before anything is read from them
+func (difftool *xdcrDiffTool) probeTLSEndpoints() error {
+	timeout := time.Duration(base.SetupTimeoutSeconds) * time.Second
+	for _, ref := range []*metadata.RemoteClusterReference{difftool.selfRef, difftool.specifiedRef} {
+		if ref.HttpAuthMech() != xdcrBase.HttpAuthMechHttps || ref.HttpsHostName() == "" {
+			continue
+		}
+		if err := base.TLSVerification.Probe(ref.HttpsHostName(), ref.Certificates(), timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (difftool *xdcrDiffTool) retrieveClustersCapabilities(legacyMode bool, xdcrCompTopologyMockCb func()) error {
 	var err error
 	difftool.specifiedRef, err = difftool.remoteClusterSvc.RemoteClusterByRefName(options.remoteClusterName, true /*refresh*/)
@@ -1072,6 +3303,9 @@ func (difftool *xdcrDiffTool) retrieveClustersCapabilities(legacyMode bool, xdcr
 	if err = difftool.populateSelfRef(); err != nil {
 		return err
 	}
+	if err = difftool.probeTLSEndpoints(); err != nil {
+		return err
+	}
 
 	if !legacyMode {
 		ref, err := difftool.remoteClusterSvc.RemoteClusterByRefName(difftool.specifiedRef.Name(), false)
@@ -1085,7 +3319,18 @@ func (difftool *xdcrDiffTool) retrieveClustersCapabilities(legacyMode bool, xdcr
 		}
 	}
 
-	// Self capabilities
+	if err = difftool.loadSelfCapabilities(); err != nil {
+		return err
+	}
+
+	if xdcrCompTopologyMockCb != nil {
+		xdcrCompTopologyMockCb()
+	}
+	return nil
+}
+
+// Capabilities of the source cluster, once the self reference is populated
+func (difftool *xdcrDiffTool) loadSelfCapabilities() error {
 	if atomic.LoadUint32(&difftool.selfRefPopulated) == 0 {
 		return fmt.Errorf("SelfRef has not been populated\n")
 	}
@@ -1109,10 +3354,6 @@ func (difftool *xdcrDiffTool) retrieveClustersCapabilities(legacyMode bool, xdcr
 		nodeList, _ := xdcrBase.GetNodeListFromInfoMap(defaultPoolInfo, difftool.logger)
 		difftool.srcClusterCompat, _ = xdcrBase.GetClusterCompatibilityFromNodeList(nodeList)
 	}
-
-	if xdcrCompTopologyMockCb != nil {
-		xdcrCompTopologyMockCb()
-	}
 	return nil
 }
 
@@ -1165,7 +3406,12 @@ func (difftool *xdcrDiffTool) PopulateManifestsAndMappings() error {
 	} else {
 		difftool.logger.Infof("Replication spec is using implicit mapping")
 	}
-	err = difftool.compileCollectionMapping()
+	if difftool.collectionMapping != nil {
+		difftool.logger.Infof("Comparing the collections of collectionMapping instead of those the replication maps")
+		err = difftool.compileGivenCollectionMapping()
+	} else {
+		err = difftool.compileCollectionMapping()
+	}
 	if err != nil {
 		return err
 	}
@@ -1173,9 +3419,24 @@ func (difftool *xdcrDiffTool) PopulateManifestsAndMappings() error {
 	// Once hardcoded compilation map has been generated, just stream these Collection IDs from DCP to minimize other noise
 	difftool.generateSrcAndTgtColIds()
 
+	// DCP streams are filtered by these IDs on the server. Without any, they would carry every collection of the bucket
+	if len(difftool.srcCollectionIds) == 0 || len(difftool.tgtCollectionIds) == 0 {
+		return fmt.Errorf("the replication does not map any collection that exists on both clusters, so there is nothing to stream")
+	}
+	difftool.logger.Infof("Streaming %v of %v source collections and %v of %v target collections\n",
+		len(difftool.srcCollectionIds), numCollections(difftool.srcBucketManifest),
+		len(difftool.tgtCollectionIds), numCollections(difftool.tgtBucketManifest))
 	return nil
 }
 
+func numCollections(manifest *metadata.CollectionsManifest) int {
+	var count int
+	for _, scope := range manifest.Scopes() {
+		count += len(scope.Collections)
+	}
+	return count
+}
+
 func (difftool *xdcrDiffTool) outputManifestsToFiles(err error) error {
 	srcManJson, err := json.Marshal(difftool.srcBucketManifest)
 	if err != nil {
@@ -1203,6 +3464,87 @@ func (difftool *xdcrDiffTool) outputManifestsToFiles(err error) error {
 	return nil
 }
 
+// Records the bucket names and the collection names as of the captured manifests alongside the output of a phase
+func (difftool *xdcrDiffTool) writeRunMetadata(dir string) {
+	srcManifest, tgtManifest := difftool.capturedManifests()
+
+	runMetadata := &results.RunMetadata{
+		Build:               base.GetBuildInfo(),
+		SourceLabel:         options.sourceLabel,
+		TargetLabel:         options.targetLabel,
+		SourceBucketName:    difftool.specifiedSpec.SourceBucketName,
+		TargetBucketName:    difftool.specifiedSpec.TargetBucketName,
+		SourceCollections:   collectionNames(srcManifest),
+		TargetCollections:   collectionNames(tgtManifest),
+		ManifestDivergences: difftool.manifestDivergences,
+		ClockSkew:           difftool.clockSkew,
+		CanaryLatency:       difftool.canaryLatency,
+		Distribution:        difftool.distribution,
+		KeyOverlap:          difftool.keyOverlap,
+		HotWindows:          difftool.hotWindows,
+		InjectedFaults:      base.Faults.Injected(),
+		Aborted:             difftool.abortReason,
+		ResourceUsage:       difftool.phaseResourceUsage(),
+	}
+	if !difftool.replicationState.Running() {
+		runMetadata.Replication = difftool.replicationState
+	}
+	if err := results.WriteRunMetadata(dir, runMetadata); err != nil {
+		difftool.logger.Warnf("Unable to write run metadata to %v: %v\n", dir, err)
+	}
+}
+
+// The manifests of this run, or as captured by an earlier one when only comparing
+func (difftool *xdcrDiffTool) capturedManifests() (*metadata.CollectionsManifest, *metadata.CollectionsManifest) {
+	srcManifest := difftool.srcBucketManifest
+	if srcManifest == nil {
+		srcManifest = difftool.loadCapturedManifest(options.sourceFileDir)
+	}
+	tgtManifest := difftool.tgtBucketManifest
+	if tgtManifest == nil {
+		tgtManifest = difftool.loadCapturedManifest(options.targetFileDir)
+	}
+	return srcManifest, tgtManifest
+}
+
+// Returns nil if no manifest was captured, i.e. either cluster does not support collections
+func (difftool *xdcrDiffTool) loadCapturedManifest(fileDir string) *metadata.CollectionsManifest {
+	manifestBytes, err := ioutil.ReadFile(utils.GetManifestFileName(fileDir))
+	if err != nil {
+		return nil
+	}
+	manifest := &metadata.CollectionsManifest{}
+	if err = json.Unmarshal(manifestBytes, manifest); err != nil {
+		difftool.logger.Warnf("Unable to parse captured manifest in %v: %v\n", fileDir, err)
+		return nil
+	}
+	return manifest
+}
+
+// Without a manifest, only the default collection can be captured
+func collectionNames(manifest *metadata.CollectionsManifest) *results.CollectionNames {
+	names := &results.CollectionNames{Names: make(map[uint32]string)}
+	if manifest == nil {
+		names.Names[0] = fmt.Sprintf("%v%v%v", xdcrBase.DefaultScopeCollectionName, xdcrBase.ScopeCollectionDelimiter, xdcrBase.DefaultScopeCollectionName)
+		return names
+	}
+	names.ManifestUid = manifest.Uid()
+	for scopeName, scope := range manifest.Scopes() {
+		for collectionName, collection := range scope.Collections {
+			names.Names[collection.Uid] = fmt.Sprintf("%v%v%v", scopeName, xdcrBase.ScopeCollectionDelimiter, collectionName)
+		}
+	}
+	return names
+}
+
+// Collections no longer in the manifest are labelled by their ID
+func collectionLabel(name string, colId uint32) string {
+	if name == "" {
+		return fmt.Sprintf("collection %v", colId)
+	}
+	return name
+}
+
 func (difftool *xdcrDiffTool) compileCollectionMapping() error {
 	pair := metadata.CollectionsManifestPair{
 		Source: difftool.srcBucketManifest,
@@ -1213,6 +3555,7 @@ func (difftool *xdcrDiffTool) compileCollectionMapping() error {
 		difftool.logger.Errorf("NewCollectionNamespaceMappingFromRules err: %v", err)
 		return err
 	}
+	difftool.checkManifestDivergence(namespaceMapping)
 
 	modes := difftool.specifiedSpec.Settings.GetCollectionModes()
 	rules := difftool.specifiedSpec.Settings.GetCollectionsRoutingRules()
@@ -1224,6 +3567,113 @@ func (difftool *xdcrDiffTool) compileCollectionMapping() error {
 	return nil
 }
 
+// Compares the existence and settings of each replicated source collection with its target collections,
+// so that structural differences are reported on their own rather than as a flood of missing documents
+func (difftool *xdcrDiffTool) checkManifestDivergence(namespaceMapping metadata.CollectionNamespaceMapping) {
+	srcManifest, err := difftool.getRawManifest(difftool.selfRef, difftool.specifiedSpec.SourceBucketName)
+	if err != nil {
+		difftool.logger.Warnf("Unable to get source manifest to check for divergence: %v\n", err)
+		return
+	}
+	tgtManifest, err := difftool.getRawManifest(difftool.specifiedRef, difftool.specifiedSpec.TargetBucketName)
+	if err != nil {
+		difftool.logger.Warnf("Unable to get target manifest to check for divergence: %v\n", err)
+		return
+	}
+	srcSettings := srcManifest.Settings()
+
+	isSystem := func(name string) bool {
+		return !options.includeSystemCollections && strings.HasPrefix(name, base.SystemScopeName+xdcrBase.ScopeCollectionDelimiter)
+	}
+	mapping := make(map[string][]string)
+	modes := difftool.specifiedSpec.Settings.GetCollectionModes()
+	if difftool.collectionMapping != nil {
+		for source, target := range difftool.collectionMapping {
+			if srcName, tgtName := source.String(), target.String(); !isSystem(srcName) && !isSystem(tgtName) {
+				mapping[srcName] = []string{tgtName}
+			}
+		}
+	} else if !modes.IsMigrationOn() && !modes.IsExplicitMapping() {
+		// Implicit mapping replicates every collection to the one of the same name, whether it exists or not
+		for srcName := range srcSettings {
+			if !isSystem(srcName) {
+				mapping[srcName] = []string{srcName}
+			}
+		}
+	} else {
+		for srcNs, tgtNamespaces := range namespaceMapping {
+			srcName := srcNs.String()
+			if !modes.IsMigrationOn() {
+				srcName = srcNs.GetCollectionNamespace().ToIndexString()
+			}
+			for _, tgtNs := range tgtNamespaces {
+				if tgtName := tgtNs.ToIndexString(); !isSystem(srcName) && !isSystem(tgtName) {
+					mapping[srcName] = append(mapping[srcName], tgtName)
+				}
+			}
+		}
+	}
+
+	difftool.manifestDivergences = results.CompareManifests(mapping, srcSettings, tgtManifest.Settings())
+	for _, divergence := range difftool.manifestDivergences {
+		difftool.logger.Warnf("Manifest divergence: %v\n", divergence)
+	}
+}
+
+// Maps the collections of collectionMapping, or else every source collection to the target collection of the same
+// name, as implicit mapping does
+func (difftool *xdcrDiffTool) compileGivenCollectionMapping() error {
+	if difftool.specifiedSpec.Settings.GetCollectionModes().IsMigrationOn() {
+		return fmt.Errorf("collectionMapping cannot be used with a replication in migration mode")
+	}
+	difftool.checkManifestDivergence(nil)
+
+	mapping := difftool.collectionMapping
+	if mapping == nil {
+		mapping = make(base.CollectionMapping)
+		for scopeName, scope := range difftool.srcBucketManifest.Scopes() {
+			for collectionName := range scope.Collections {
+				name := base.CollectionName{ScopeName: scopeName, CollectionName: collectionName}
+				mapping[name] = name
+			}
+		}
+	}
+	for source, target := range mapping {
+		if !options.includeSystemCollections && (source.ScopeName == base.SystemScopeName || target.ScopeName == base.SystemScopeName) {
+			difftool.logger.Infof("Skipping system collection %v\n", source)
+			continue
+		}
+		srcColId, srcErr := difftool.srcBucketManifest.GetCollectionId(source.ScopeName, source.CollectionName)
+		tgtColId, tgtErr := difftool.tgtBucketManifest.GetCollectionId(target.ScopeName, target.CollectionName)
+		if srcErr != nil || tgtErr != nil {
+			if difftool.collectionMapping != nil {
+				return fmt.Errorf("collectionMapping maps %v to %v, which do not both exist. source err=%v target err=%v", source, target, srcErr, tgtErr)
+			}
+			difftool.logger.Infof("Skipping %v, which does not exist on the target\n", source)
+			continue
+		}
+		difftool.srcToTgtColIdsMap[srcColId] = []uint32{tgtColId}
+	}
+
+	difftool.logger.Infof("Collection mapping: %v idsMap: %v", mapping, difftool.srcToTgtColIdsMap)
+	return nil
+}
+
+func (difftool *xdcrDiffTool) getRawManifest(ref *metadata.RemoteClusterReference, bucketName string) (*results.RawManifest, error) {
+	manifest := &results.RawManifest{}
+	path := xdcrBase.DefaultPoolBucketsPath + bucketName + base.BucketScopesPath
+	err, statusCode := difftool.utils.QueryRestApiWithAuth(ref.HostName(), path, false, ref.UserName(), ref.Password(),
+		ref.HttpAuthMech(), ref.Certificates(), ref.SANInCertificate(), ref.ClientCertificate(), ref.ClientKey(),
+		xdcrBase.MethodGet, "", nil, 0, manifest, nil, false, difftool.logger)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v returned status %v", path, statusCode)
+	}
+	return manifest, nil
+}
+
 func (difftool *xdcrDiffTool) compileHardcodedColToColMapping(namespaceMapping metadata.CollectionNamespaceMapping) {
 	for srcNs, tgtNamespaces := range namespaceMapping {
 		for _, tgtNs := range tgtNamespaces {
@@ -1231,6 +3681,10 @@ func (difftool *xdcrDiffTool) compileHardcodedColToColMapping(namespaceMapping m
 			collectionName := srcNs.GetCollectionNamespace().CollectionName
 			tgtScopeName := tgtNs.ScopeName
 			tgtCollectionName := tgtNs.CollectionName
+			if !options.includeSystemCollections && (scopeName == base.SystemScopeName || tgtScopeName == base.SystemScopeName) {
+				difftool.logger.Infof("Skipping system collection %v - %v\n", scopeName, collectionName)
+				continue
+			}
 			srcColId, srcErr := difftool.srcBucketManifest.GetCollectionId(scopeName, collectionName)
 			tgtColId, tgtErr := difftool.tgtBucketManifest.GetCollectionId(tgtScopeName, tgtCollectionName)
 
@@ -0,0 +1,65 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"xdcrDiffer/base"
+	"xdcrDiffer/results"
+)
+
+const mergeCommand = "merge"
+
+// Combines the output of several runs into one, i.e.
+//
+//	xdcrDiffer merge -output merged run0-511 run512-1023
+//
+// Each run directory is the directory a run was started in, holding its fileDiff and mutationDiff directories
+func runMergeCommand(args []string) error {
+	flags := flag.NewFlagSet(mergeCommand, flag.ExitOnError)
+	output := flags.String("output", "merged",
+		"directory to write the merged fileDiff and mutationDiff output to")
+	fileDifferDir := flags.String("fileDifferDir", base.FileDifferDir,
+		"name of the file differ output directory within each run directory")
+	mutationDifferDir := flags.String("mutationDifferDir", base.MutationDifferDir,
+		"name of the mutation differ output directory within each run directory")
+	flags.Parse(args)
+
+	runDirs := flags.Args()
+	if len(runDirs) == 0 {
+		return fmt.Errorf("No run directories given to %v", mergeCommand)
+	}
+
+	layouts := []*results.PhaseLayout{
+		{
+			Phase:          results.PhaseFileDiff,
+			Dir:            *fileDifferDir,
+			FilePattern:    base.DiffDetailsFileName + base.FileNameDelimiter + "*",
+			MergedFileName: base.DiffDetailsFileName + base.FileNameDelimiter + "0",
+		},
+		{
+			Phase:          results.PhaseMutationDiff,
+			Dir:            *mutationDifferDir,
+			FilePattern:    base.MutationDiffFileName,
+			MergedFileName: base.MutationDiffFileName,
+		},
+	}
+	summary, err := results.Merge(*output, runDirs, layouts)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(summary)
+}
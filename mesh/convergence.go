@@ -0,0 +1,218 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package mesh
+
+import (
+	"fmt"
+	"sort"
+	"xdcrDiffer/base"
+	"xdcrDiffer/results"
+)
+
+// Categories of the mutation differ output that tell the two clusters of a pair disagree about a document, and which
+// of them has no live document, if either
+var divergentCategories = map[string]struct{ sourceAbsent, targetAbsent bool }{
+	"Mismatch":          {},
+	"MissingFromSource": {sourceAbsent: true},
+	"MissingFromTarget": {targetAbsent: true},
+	"DeletedFromSource": {sourceAbsent: true},
+	"DeletedFromTarget": {targetAbsent: true},
+}
+
+// Categories that tell nothing of whether the clusters of a pair agree about a document
+var inconclusiveCategories = map[string]bool{
+	base.LockedCategory:                  true,
+	base.ExpectedByConfigurationCategory: true,
+	base.KnownConflictCategory:           true,
+	base.PurgedCategory:                  true,
+}
+
+// What the run of a pair found
+type PairOutcome struct {
+	Pair *Pair
+	// A failed run tells nothing of any document
+	Failed bool
+	// Its mutation differ output, with the collection names resolved
+	Entries []*results.Entry
+}
+
+// Clusters whose replicas of a document agree with one another
+type ReplicaSet struct {
+	Clusters []string
+	// The clusters of the set have no live document, as a pair of one of them found
+	Absent bool `json:",omitempty"`
+}
+
+// The replicas of a document that some pair found to differ, grouped by the pairs that did not
+type DocumentConvergence struct {
+	Collection string
+	Key        string
+	// One set once the replicas agree across the mesh, several for a split brain
+	Sets []*ReplicaSet
+	// Pairs that found the document to differ although the others place both clusters in the same set, i.e. as it
+	// changed while the runs went on
+	Inconsistent []string `json:",omitempty"`
+}
+
+func (d *DocumentConvergence) SplitBrain() bool {
+	return len(d.Sets) > 1
+}
+
+type ConvergenceReport struct {
+	Clusters    []string
+	Pairs       int
+	FailedPairs []string `json:",omitempty"`
+	// Clusters of no pair whose run succeeded, which nothing is known of. They are left out of the sets
+	Unverified []string `json:",omitempty"`
+	// Documents found to differ by at least one pair. Every other document agrees across the verified clusters
+	Diverged     int
+	SplitBrain   int
+	Inconsistent int
+	Documents    []*DocumentConvergence
+}
+
+func (r *ConvergenceReport) String() string {
+	return fmt.Sprintf("%v clusters, %v pairs (%v failed). %v documents diverged: %v split-brain, %v inconsistent between pairs. Unverified clusters: %v",
+		len(r.Clusters), r.Pairs, len(r.FailedPairs), r.Diverged, r.SplitBrain, r.Inconsistent, r.Unverified)
+}
+
+type documentId struct {
+	collection string
+	key        string
+}
+
+type pairFinding struct {
+	pair      *Pair
+	divergent bool
+}
+
+// Works out, for every document some pair found to differ, which clusters agree about it. Two clusters agree unless
+// their pair found the document to differ, and agreement carries across the mesh, so that clusters with no pair of
+// their own are grouped through the clusters in between
+func Converge(clusters []string, outcomes []*PairOutcome) *ConvergenceReport {
+	report := &ConvergenceReport{Clusters: clusters, Pairs: len(outcomes), Documents: []*DocumentConvergence{}}
+
+	verified := make(map[string]bool)
+	findings := make(map[documentId][]*pairFinding)
+	absent := make(map[documentId]map[string]bool)
+	var order []documentId
+	for _, outcome := range outcomes {
+		if outcome.Failed {
+			report.FailedPairs = append(report.FailedPairs, outcome.Pair.String())
+			continue
+		}
+		verified[outcome.Pair.Source] = true
+		verified[outcome.Pair.Target] = true
+		for _, entry := range outcome.Entries {
+			divergence, divergent := divergentCategories[entry.Category]
+			if !divergent && !inconclusiveCategories[entry.Category] {
+				continue
+			}
+			id := documentId{collection: entry.Collection, key: entry.Key}
+			if id.collection == "" {
+				id.collection = fmt.Sprintf("%v#%v", outcome.Pair.Source, entry.ColId)
+			}
+			if _, exists := findings[id]; !exists {
+				order = append(order, id)
+				absent[id] = make(map[string]bool)
+			}
+			findings[id] = append(findings[id], &pairFinding{pair: outcome.Pair, divergent: divergent})
+			if divergence.sourceAbsent {
+				absent[id][outcome.Pair.Source] = true
+			}
+			if divergence.targetAbsent {
+				absent[id][outcome.Pair.Target] = true
+			}
+		}
+	}
+	for _, cluster := range clusters {
+		if !verified[cluster] {
+			report.Unverified = append(report.Unverified, cluster)
+		}
+	}
+
+	for _, id := range order {
+		document := converge(clusters, verified, outcomes, findings[id], absent[id])
+		if document == nil {
+			continue
+		}
+		document.Collection, document.Key = id.collection, id.key
+		report.Diverged++
+		if document.SplitBrain() {
+			report.SplitBrain++
+		}
+		if len(document.Inconsistent) > 0 {
+			report.Inconsistent++
+		}
+		report.Documents = append(report.Documents, document)
+	}
+	sort.SliceStable(report.Documents, func(i, j int) bool {
+		if report.Documents[i].Collection != report.Documents[j].Collection {
+			return report.Documents[i].Collection < report.Documents[j].Collection
+		}
+		return report.Documents[i].Key < report.Documents[j].Key
+	})
+	return report
+}
+
+// Nil for a document that no pair found to differ, but only to be inconclusive about
+func converge(clusters []string, verified map[string]bool, outcomes []*PairOutcome, findings []*pairFinding, absent map[string]bool) *DocumentConvergence {
+	found := make(map[*Pair]*pairFinding)
+	var divergent bool
+	for _, finding := range findings {
+		found[finding.pair] = finding
+		divergent = divergent || finding.divergent
+	}
+	if !divergent {
+		return nil
+	}
+
+	parent := make(map[string]string)
+	var find func(cluster string) string
+	find = func(cluster string) string {
+		if parent[cluster] == cluster {
+			return cluster
+		}
+		parent[cluster] = find(parent[cluster])
+		return parent[cluster]
+	}
+	for _, cluster := range clusters {
+		parent[cluster] = cluster
+	}
+	for _, outcome := range outcomes {
+		if outcome.Failed || found[outcome.Pair] != nil {
+			continue
+		}
+		parent[find(outcome.Pair.Source)] = find(outcome.Pair.Target)
+	}
+
+	document := &DocumentConvergence{}
+	sets := make(map[string]*ReplicaSet)
+	for _, cluster := range clusters {
+		if !verified[cluster] {
+			continue
+		}
+		root := find(cluster)
+		set, exists := sets[root]
+		if !exists {
+			set = &ReplicaSet{}
+			sets[root] = set
+			document.Sets = append(document.Sets, set)
+		}
+		set.Clusters = append(set.Clusters, cluster)
+		set.Absent = set.Absent || absent[cluster]
+	}
+	for _, finding := range findings {
+		if finding.divergent && find(finding.pair.Source) == find(finding.pair.Target) {
+			document.Inconsistent = append(document.Inconsistent, finding.pair.String())
+		}
+	}
+	return document
+}
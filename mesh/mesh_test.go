@@ -0,0 +1,103 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package mesh
+
+import (
+	"fmt"
+	"testing"
+	"xdcrDiffer/results"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testTopology = `{
+	"Args": ["-compareType", "body"],
+	"Clusters": {
+		"A": {"Url": "a:8091", "Username": "u", "Password": "p", "Bucket": "orders"},
+		"B": {"Url": "b:8091", "Username": "u", "Password": "p", "Bucket": "orders", "Ports": "kv=21210"},
+		"C": {"Url": "c:8091", "Username": "u", "Password": "p", "Bucket": "orders"}
+	},
+	"Links": [
+		{"Source": "A", "Target": "B"},
+		{"Source": "B", "Target": "A", "RemoteClusterName": "toA"},
+		{"Source": "B", "Target": "C", "RemoteClusterName": "toC"},
+		{"Source": "C", "Target": "B", "RemoteClusterName": "toB"}
+	]
+}`
+
+func TestTopology(t *testing.T) {
+	fmt.Println("============== Test case start: TestTopology =================")
+	assert := assert.New(t)
+
+	topology, err := ParseTopology([]byte(testTopology))
+	assert.Nil(err)
+	assert.Equal([]string{"A", "B", "C"}, topology.ClusterNames())
+	// Each bidirectional replication is verified once, by a link with a remote cluster reference if there is one
+	pairs := topology.Pairs()
+	assert.Equal([]*Pair{{Source: "B", Target: "A", RemoteClusterName: "toA"}, {Source: "B", Target: "C", RemoteClusterName: "toC"}}, pairs)
+	assert.Equal([]string{"-compareType", "body", "-sourceUrl", "b:8091", "-sourceUsername", "u", "-sourcePassword", "p",
+		"-sourceLabel", "B", "-targetLabel", "A", "-remoteClusterName", "toA", "-sourcePorts", "kv=21210"}, topology.PairArgs(pairs[0]))
+
+	topology.Links = topology.Links[:1]
+	assert.Equal([]string{"-compareType", "body", "-sourceUrl", "a:8091", "-sourceUsername", "u", "-sourcePassword", "p",
+		"-sourceLabel", "A", "-targetLabel", "B", "-targetUrl", "b:8091", "-targetUsername", "u", "-targetPassword", "p",
+		"-targetPorts", "kv=21210"}, topology.PairArgs(topology.Pairs()[0]))
+
+	for _, invalid := range []string{
+		`{"Clusters": {"A": {"Url": "a", "Bucket": "b"}}, "Links": []}`,
+		`{"Clusters": {"A": {"Url": "a", "Bucket": "b"}, "B/C": {"Url": "b", "Bucket": "b"}}, "Links": [{"Source": "A", "Target": "B/C"}]}`,
+		`{"Clusters": {"A": {"Url": "a", "Bucket": "b"}, "B": {"Url": "b"}}, "Links": [{"Source": "A", "Target": "B"}]}`,
+		`{"Clusters": {"A": {"Url": "a", "Bucket": "b"}, "B": {"Url": "b", "Bucket": "b"}}, "Links": [{"Source": "A", "Target": "C"}]}`,
+		`{"Clusters": {"A": {"Url": "a", "Bucket": "b"}, "B": {"Url": "b", "Bucket": "b"}}, "Links": [{"Source": "A", "Target": "A"}]}`,
+	} {
+		_, err = ParseTopology([]byte(invalid))
+		assert.NotNil(err, invalid)
+	}
+	fmt.Println("============== Test case end: TestTopology =================")
+}
+
+func TestConverge(t *testing.T) {
+	fmt.Println("============== Test case start: TestConverge =================")
+	assert := assert.New(t)
+
+	ab, bc, ca := &Pair{Source: "A", Target: "B"}, &Pair{Source: "B", Target: "C"}, &Pair{Source: "C", Target: "A"}
+	entry := func(category, key string) *results.Entry {
+		return &results.Entry{Category: category, Collection: "_default._default", Key: key}
+	}
+	outcomes := []*PairOutcome{
+		// split: B lacks it, A and C agree. locked: only inconclusive. raced: every pair but one agrees
+		{Pair: ab, Entries: []*results.Entry{entry("MissingFromTarget", "split"), entry("Locked", "locked"), entry("Mismatch", "raced")}},
+		{Pair: bc, Entries: []*results.Entry{entry("MissingFromSource", "split")}},
+		{Pair: ca, Entries: []*results.Entry{entry("Mismatch", "threeWays")}},
+	}
+	outcomes[0].Entries = append(outcomes[0].Entries, entry("Mismatch", "threeWays"))
+	outcomes[1].Entries = append(outcomes[1].Entries, entry("Mismatch", "threeWays"))
+
+	report := Converge([]string{"A", "B", "C"}, outcomes)
+	assert.Equal(3, report.Diverged)
+	assert.Equal(2, report.SplitBrain)
+	assert.Equal(1, report.Inconsistent)
+	assert.Len(report.Documents, 3)
+	assert.Equal("raced", report.Documents[0].Key)
+	assert.Equal([]*ReplicaSet{{Clusters: []string{"A", "B", "C"}}}, report.Documents[0].Sets)
+	assert.Equal([]string{"A/B"}, report.Documents[0].Inconsistent)
+	assert.Equal("split", report.Documents[1].Key)
+	assert.Equal([]*ReplicaSet{{Clusters: []string{"A", "C"}}, {Clusters: []string{"B"}, Absent: true}}, report.Documents[1].Sets)
+	assert.Equal("threeWays", report.Documents[2].Key)
+	assert.Len(report.Documents[2].Sets, 3)
+
+	// Nothing is known of a cluster whose only pair failed
+	outcomes = []*PairOutcome{{Pair: ab, Entries: []*results.Entry{entry("Mismatch", "doc")}}, {Pair: bc, Failed: true}}
+	report = Converge([]string{"A", "B", "C"}, outcomes)
+	assert.Equal([]string{"B/C"}, report.FailedPairs)
+	assert.Equal([]string{"C"}, report.Unverified)
+	assert.Equal([]*ReplicaSet{{Clusters: []string{"A"}}, {Clusters: []string{"B"}}}, report.Documents[0].Sets)
+	fmt.Println("============== Test case end: TestConverge =================")
+}
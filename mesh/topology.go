@@ -0,0 +1,151 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package mesh
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// Cluster names are also the labels of the clusters in the runs of their pairs
+var clusterNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// A cluster of the mesh, and its bucket that is replicated to the others
+type Cluster struct {
+	Url      string
+	Username string
+	Password string
+	Bucket   string
+	// Given as sourcePorts or targetPorts, as the cluster is the source or the target of a pair
+	Ports string `json:",omitempty"`
+}
+
+// A replication from one cluster of the mesh to another. A bidirectional replication is given as two links
+type Link struct {
+	Source string
+	Target string
+	// Of the remote cluster reference of Target on Source, that the run looks the replication up by. Without one, the
+	// run is given the URL and credentials of Target, as legacyMode takes them
+	RemoteClusterName string `json:",omitempty"`
+}
+
+// The clusters of an N-way replicated bucket, the replications between them, and the options common to the runs
+type Topology struct {
+	Args     []string
+	Clusters map[string]*Cluster
+	Links    []*Link
+}
+
+// Two clusters verified against each other by a single run, once whichever way they replicate
+type Pair struct {
+	Source            string
+	Target            string
+	RemoteClusterName string `json:",omitempty"`
+}
+
+// The directory the run of the pair is started in, relative to that of the mesh
+func (p *Pair) Dir() string {
+	return filepath.Join(p.Source, p.Target)
+}
+
+func (p *Pair) String() string {
+	return p.Source + "/" + p.Target
+}
+
+func ParseTopology(data []byte) (*Topology, error) {
+	topology := &Topology{}
+	if err := json.Unmarshal(data, topology); err != nil {
+		return nil, err
+	}
+	if len(topology.Clusters) < 2 {
+		return nil, fmt.Errorf("The topology needs at least two clusters")
+	}
+	for name, cluster := range topology.Clusters {
+		if !clusterNameRegex.MatchString(name) || name == "." || name == ".." {
+			return nil, fmt.Errorf("Invalid cluster name %q. Names consist of letters, digits, '_', '.' and '-'", name)
+		}
+		if cluster == nil || cluster.Url == "" || cluster.Bucket == "" {
+			return nil, fmt.Errorf("Cluster %v needs both a Url and a Bucket", name)
+		}
+	}
+	if len(topology.Links) == 0 {
+		return nil, fmt.Errorf("The topology has no links")
+	}
+	for _, link := range topology.Links {
+		for _, name := range []string{link.Source, link.Target} {
+			if _, exists := topology.Clusters[name]; !exists {
+				return nil, fmt.Errorf("Link %v -> %v is of unknown cluster %q", link.Source, link.Target, name)
+			}
+		}
+		if link.Source == link.Target {
+			return nil, fmt.Errorf("Link %v -> %v is of a cluster to itself", link.Source, link.Target)
+		}
+	}
+	return topology, nil
+}
+
+// The pairs to verify, one per two clusters with a link between them either way, as a run compares both ways at
+// once. Of the two links of a bidirectional replication, the first one with a remote cluster reference is run
+func (t *Topology) Pairs() []*Pair {
+	type unordered [2]string
+	pairs := make(map[unordered]*Pair)
+	var order []unordered
+	for _, link := range t.Links {
+		key := unordered{link.Source, link.Target}
+		if link.Target < link.Source {
+			key = unordered{link.Target, link.Source}
+		}
+		pair, exists := pairs[key]
+		if !exists {
+			order = append(order, key)
+		}
+		if !exists || (pair.RemoteClusterName == "" && link.RemoteClusterName != "") {
+			pairs[key] = &Pair{Source: link.Source, Target: link.Target, RemoteClusterName: link.RemoteClusterName}
+		}
+	}
+	result := make([]*Pair, 0, len(order))
+	for _, key := range order {
+		result = append(result, pairs[key])
+	}
+	return result
+}
+
+// The names of the clusters, sorted
+func (t *Topology) ClusterNames() []string {
+	names := make([]string, 0, len(t.Clusters))
+	for name := range t.Clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Options of the run of a pair on top of those common to all runs, but for the bucket names
+func (t *Topology) PairArgs(pair *Pair) []string {
+	source, target := t.Clusters[pair.Source], t.Clusters[pair.Target]
+	args := append([]string{}, t.Args...)
+	args = append(args, "-sourceUrl", source.Url, "-sourceUsername", source.Username, "-sourcePassword", source.Password,
+		"-sourceLabel", pair.Source, "-targetLabel", pair.Target)
+	if pair.RemoteClusterName != "" {
+		args = append(args, "-remoteClusterName", pair.RemoteClusterName)
+	} else {
+		args = append(args, "-targetUrl", target.Url, "-targetUsername", target.Username, "-targetPassword", target.Password)
+	}
+	if source.Ports != "" {
+		args = append(args, "-sourcePorts", source.Ports)
+	}
+	if target.Ports != "" {
+		args = append(args, "-targetPorts", target.Ports)
+	}
+	return args
+}
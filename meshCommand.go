@@ -0,0 +1,127 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+	"xdcrDiffer/base"
+	"xdcrDiffer/mesh"
+	"xdcrDiffer/results"
+	"xdcrDiffer/scheduler"
+)
+
+const meshCommand = "mesh"
+
+// Under the run directory of the mesh, the replicas of every document some pair found to differ
+const meshConvergenceFileName = "meshConvergence.json"
+
+// Verifies a bucket replicated across a bidirectional or mesh topology, such as A <-> B <-> C, i.e.
+//
+//	xdcrDiffer mesh -topology topology.json -maxConcurrent 2
+//
+// Every two clusters with a replication between them, either way, are verified by a run of their own, each with the
+// clusters as its labels, in a directory named after them under runDir. Once all have finished, their mutation differ
+// output is combined into the convergence of every document across the mesh
+func runMeshCommand(args []string) error {
+	flags := flag.NewFlagSet(meshCommand, flag.ExitOnError)
+	topologyFile := flags.String("topology", "", "JSON file of the clusters, the replications between them and the options common to their runs")
+	maxConcurrent := flags.Int("maxConcurrent", 1, "number of pairs to run at once")
+	runDir := flags.String("runDir", "mesh", "directory the run of each pair is started in a directory of its own under")
+	flags.Parse(args)
+
+	if *topologyFile == "" {
+		return fmt.Errorf("%v requires a topology", meshCommand)
+	}
+	if *maxConcurrent < 1 {
+		return fmt.Errorf("maxConcurrent has to be at least 1")
+	}
+	topologyBytes, err := ioutil.ReadFile(*topologyFile)
+	if err != nil {
+		return err
+	}
+	topology, err := mesh.ParseTopology(topologyBytes)
+	if err != nil {
+		return fmt.Errorf("Invalid topology %v: %v", *topologyFile, err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(*runDir, 0777); err != nil {
+		return err
+	}
+
+	pairs := topology.Pairs()
+	scheduledPairs := make([]*scheduler.Pair, 0, len(pairs))
+	for _, pair := range pairs {
+		fmt.Printf("Verifying %v against %v\n", pair.Source, pair.Target)
+		scheduledPairs = append(scheduledPairs, &scheduler.Pair{
+			Name:         pair.Dir(),
+			SourceBucket: topology.Clusters[pair.Source].Bucket,
+			TargetBucket: topology.Clusters[pair.Target].Bucket,
+			Args:         topology.PairArgs(pair),
+		})
+	}
+	pairScheduler := scheduler.NewScheduler(scheduledPairs, &scheduler.Budget{MaxConcurrent: *maxConcurrent}, 0)
+	onChange := func() {
+		timeline := pairScheduler.Timeline(time.Now())
+		fmt.Printf("%v: %v done, %v failed, %v running, %v pending\n", time.Now().Format(time.RFC3339),
+			timeline.Done, timeline.Failed, timeline.Running, timeline.Pending)
+	}
+	runErr := pairScheduler.Run(func(pair *scheduler.Pair) error {
+		err := runSchedulePair(executable, *runDir, nil, pair)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == runVerdictFailExitCode {
+			// The run completed, and its differences are what the mesh is after
+			return nil
+		}
+		return err
+	}, onChange)
+
+	failed := make(map[string]bool)
+	for _, status := range pairScheduler.Timeline(time.Now()).Pairs {
+		failed[status.Name] = status.State == scheduler.PairFailed
+	}
+	outcomes := make([]*mesh.PairOutcome, 0, len(pairs))
+	for _, pair := range pairs {
+		outcome := &mesh.PairOutcome{Pair: pair, Failed: failed[pair.Dir()]}
+		if !outcome.Failed {
+			pattern := filepath.Join(*runDir, pair.Dir(), base.MutationDifferDir, base.MutationDiffFileName)
+			page, err := results.Run(results.PhaseMutationDiff, pattern, &results.Query{})
+			if err != nil {
+				fmt.Printf("Unable to read the output of %v, which is taken as failed. err=%v\n", pair, err)
+				outcome.Failed = true
+			} else {
+				outcome.Entries = page.Entries
+			}
+		}
+		outcomes = append(outcomes, outcome)
+	}
+
+	report := mesh.Converge(topology.ClusterNames(), outcomes)
+	reportBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	reportFileName := filepath.Join(*runDir, meshConvergenceFileName)
+	if err = ioutil.WriteFile(reportFileName, reportBytes, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("%v\nThe replicas of every document that diverged are in %v\n", report, reportFileName)
+	return runErr
+}
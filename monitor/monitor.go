@@ -0,0 +1,199 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package monitor
+
+import (
+	"sync"
+	"time"
+	"xdcrDiffer/base"
+	"xdcrDiffer/stats"
+)
+
+// Types of divergence events, named after the categories of the differ output
+const (
+	EventMismatch          = "Mismatch"
+	EventMissingFromSource = "MissingFromSource"
+	EventMissingFromTarget = "MissingFromTarget"
+)
+
+// Stats kept by the monitor
+const (
+	StatConverged = "monitor.converged"
+	StatDiverged  = "monitor.diverged"
+	StatPending   = "monitor.pending"
+)
+
+type Side int
+
+const (
+	Source Side = iota
+	Target Side = iota
+)
+
+// A version of a document seen on either cluster. ColId is in terms of the target, so that
+// both sides of a replicated collection are tracked together. Key is encoded by base.EncodeKey, as in any other output
+type Version struct {
+	ColId   uint32
+	Key     string
+	Seqno   uint64
+	Cas     uint64
+	RevId   uint64
+	Deleted bool
+}
+
+func (v *Version) sameAs(other *Version) bool {
+	return v.Cas == other.Cas && v.RevId == other.RevId && v.Deleted == other.Deleted
+}
+
+// Emitted when a document has not converged within the settle window
+type Event struct {
+	Time  time.Time
+	Type  string
+	ColId uint32
+	Key   string
+	// Set if Key is encoded
+	KeyEncoding string `json:",omitempty"`
+	// The latest version seen on each side, if any
+	Source *Version `json:",omitempty"`
+	Target *Version `json:",omitempty"`
+}
+
+type pendingKey struct {
+	colId uint32
+	key   string
+}
+
+type pendingDoc struct {
+	source *Version
+	target *Version
+	// When either side last changed. The settle window restarts from here
+	lastChange time.Time
+}
+
+// Compares the mutations streamed from both clusters as they arrive
+// A mutation on one side is expected to be matched by the same version on the other side within the settle window,
+// which allows for replication latency. Documents that have not converged by then are emitted as events
+type Monitor struct {
+	settleWindow time.Duration
+	emit         func(*Event)
+
+	lock    sync.Mutex
+	pending map[pendingKey]*pendingDoc
+
+	converged *stats.Counter
+	diverged  *stats.Counter
+	numPend   *stats.Gauge
+
+	finCh     chan bool
+	waitGroup sync.WaitGroup
+}
+
+func NewMonitor(settleWindow time.Duration, emit func(*Event)) *Monitor {
+	return &Monitor{
+		settleWindow: settleWindow,
+		emit:         emit,
+		pending:      make(map[pendingKey]*pendingDoc),
+		converged:    stats.Default.Counter(StatConverged),
+		diverged:     stats.Default.Counter(StatDiverged),
+		numPend:      stats.Default.Gauge(StatPending),
+		finCh:        make(chan bool),
+	}
+}
+
+func (m *Monitor) Start() {
+	m.waitGroup.Add(1)
+	go m.run()
+}
+
+// Documents still pending are not emitted, as their settle window has not passed
+func (m *Monitor) Stop() {
+	close(m.finCh)
+	m.waitGroup.Wait()
+}
+
+func (m *Monitor) run() {
+	defer m.waitGroup.Done()
+
+	// Checking a few times per window keeps the time to report a divergence close to the window itself
+	interval := m.settleWindow / 4
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.finCh:
+			return
+		case now := <-ticker.C:
+			m.sweep(now)
+		}
+	}
+}
+
+func (m *Monitor) Observe(side Side, version *Version) {
+	m.observeAt(side, version, time.Now())
+}
+
+func (m *Monitor) observeAt(side Side, version *Version, now time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key := pendingKey{version.ColId, version.Key}
+	doc, exists := m.pending[key]
+	if !exists {
+		doc = &pendingDoc{}
+		m.pending[key] = doc
+	}
+	if side == Source {
+		doc.source = version
+	} else {
+		doc.target = version
+	}
+	doc.lastChange = now
+
+	if doc.source != nil && doc.target != nil && doc.source.sameAs(doc.target) {
+		delete(m.pending, key)
+		m.converged.Add(1)
+	}
+	m.numPend.Set(int64(len(m.pending)))
+}
+
+func (m *Monitor) sweep(now time.Time) {
+	var events []*Event
+
+	m.lock.Lock()
+	for key, doc := range m.pending {
+		if now.Sub(doc.lastChange) < m.settleWindow {
+			continue
+		}
+		event := &Event{Time: now, ColId: key.colId, Key: key.key, KeyEncoding: base.KeyEncodingOf(key.key),
+			Source: doc.source, Target: doc.target}
+		switch {
+		case doc.target == nil:
+			event.Type = EventMissingFromTarget
+		case doc.source == nil:
+			event.Type = EventMissingFromSource
+		default:
+			event.Type = EventMismatch
+		}
+		events = append(events, event)
+		delete(m.pending, key)
+	}
+	m.numPend.Set(int64(len(m.pending)))
+	m.lock.Unlock()
+
+	// Emitted outside of the lock, so that a slow consumer does not hold up the DCP handlers
+	for _, event := range events {
+		m.diverged.Add(1)
+		m.emit(event)
+	}
+}
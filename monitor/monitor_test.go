@@ -0,0 +1,52 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package monitor
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonitorSettleWindow(t *testing.T) {
+	fmt.Println("============== Test case start: TestMonitorSettleWindow =================")
+	assert := assert.New(t)
+
+	var events []*Event
+	m := NewMonitor(10*time.Second, func(event *Event) {
+		events = append(events, event)
+	})
+	start := time.Now()
+
+	// Replicated within the window
+	m.observeAt(Source, &Version{ColId: 8, Key: "a", Cas: 1, RevId: 1}, start)
+	m.observeAt(Target, &Version{ColId: 8, Key: "a", Cas: 1, RevId: 1}, start.Add(2*time.Second))
+	// Never replicated
+	m.observeAt(Source, &Version{ColId: 8, Key: "b", Cas: 2, RevId: 1}, start)
+	// Target behind the source
+	m.observeAt(Source, &Version{ColId: 8, Key: "c", Cas: 4, RevId: 2}, start)
+	m.observeAt(Target, &Version{ColId: 8, Key: "c", Cas: 3, RevId: 1}, start.Add(time.Second))
+
+	m.sweep(start.Add(5 * time.Second))
+	assert.Len(events, 0)
+
+	m.sweep(start.Add(11 * time.Second))
+	assert.Len(events, 2)
+	types := map[string]string{}
+	for _, event := range events {
+		types[event.Key] = event.Type
+	}
+	assert.Equal(EventMissingFromTarget, types["b"])
+	assert.Equal(EventMismatch, types["c"])
+	assert.Len(m.pending, 0)
+	fmt.Println("============== Test case end: TestMonitorSettleWindow =================")
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"time"
+)
+
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+type StageState struct {
+	Name   string
+	Status string
+	// Unset until the stage is first run. A stage that was resumed has the times of its latest run
+	Started  *time.Time `json:",omitempty"`
+	Finished *time.Time `json:",omitempty"`
+	// Why the stage failed, if it did
+	Error string `json:",omitempty"`
+	// Exit status of the run of a failed stage, if it got as far as exiting
+	ExitCode int `json:",omitempty"`
+}
+
+// Which stages of a verification are done, so that a verification that failed half way can be resumed from the
+// stage that failed rather than started over
+type State struct {
+	// Options the stages are run with. A state is only resumed with the same options, as the output of the stages
+	// that are done would otherwise be of something else
+	Args   []string
+	Stages []*StageState
+}
+
+func NewState(args []string, stageNames []string) *State {
+	state := &State{Args: args}
+	for _, name := range stageNames {
+		state.Stages = append(state.Stages, &StageState{Name: name, Status: StatusPending})
+	}
+	return state
+}
+
+// Returns nil without an error if there is no state, i.e. the verification was never run
+func ReadState(fileName string) (*State, error) {
+	stateBytes, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &State{}
+	if err = json.Unmarshal(stateBytes, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *State) Write(fileName string) error {
+	stateBytes, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, stateBytes, 0644)
+}
+
+// Errors if the state is of other options or other stages than those given
+func (s *State) CanResume(args []string, stageNames []string) error {
+	if !reflect.DeepEqual(s.Args, args) {
+		return fmt.Errorf("it was run with options %v rather than %v", s.Args, args)
+	}
+	var names []string
+	for _, stage := range s.Stages {
+		names = append(names, stage.Name)
+	}
+	if !reflect.DeepEqual(names, stageNames) {
+		return fmt.Errorf("it has stages %v rather than %v", names, stageNames)
+	}
+	return nil
+}
+
+// Runs the stages that are not done, in order, stopping at the first that fails. onChange is called whenever a stage
+// starts or finishes, i.e. to write the state out, so that it holds whatever was done should the process die
+func (s *State) Run(runStage func(stage *StageState) error, onChange func()) error {
+	for _, stage := range s.Stages {
+		if stage.Status == StatusDone {
+			continue
+		}
+		started := time.Now()
+		stage.Status, stage.Started, stage.Finished, stage.Error, stage.ExitCode = StatusRunning, &started, nil, "", 0
+		onChange()
+
+		err := runStage(stage)
+		finished := time.Now()
+		stage.Finished = &finished
+		if err != nil {
+			stage.Status = StatusFailed
+			stage.Error = err.Error()
+			if exitErr, ok := err.(interface{ ExitCode() int }); ok {
+				stage.ExitCode = exitErr.ExitCode()
+			}
+			onChange()
+			return fmt.Errorf("stage %v failed: %v", stage.Name, err)
+		}
+		stage.Status = StatusDone
+		onChange()
+	}
+	return nil
+}
+
+// The stage that failed, if any
+func (s *State) Failed() *StageState {
+	for _, stage := range s.Stages {
+		if stage.Status == StatusFailed {
+			return stage
+		}
+	}
+	return nil
+}
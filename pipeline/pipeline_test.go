@@ -0,0 +1,86 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package pipeline
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type exitError int
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func (e exitError) ExitCode() int {
+	return int(e)
+}
+
+func TestStateRunAndResume(t *testing.T) {
+	fmt.Println("============== Test case start: TestStateRunAndResume =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "pipeline")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "state.json")
+
+	state, err := ReadState(fileName)
+	assert.Nil(err)
+	assert.Nil(state)
+
+	args := []string{"-sourceUrl", "localhost:8091"}
+	stages := []string{"capture", "fileDiff", "mutationDiff"}
+	state = NewState(args, stages)
+	var ran []string
+	writes := 0
+	onChange := func() {
+		writes++
+		assert.Nil(state.Write(fileName))
+	}
+	err = state.Run(func(stage *StageState) error {
+		ran = append(ran, stage.Name)
+		if stage.Name == "fileDiff" {
+			return exitError(3)
+		}
+		return nil
+	}, onChange)
+	assert.NotNil(err)
+	assert.Equal([]string{"capture", "fileDiff"}, ran)
+	assert.Equal(4, writes)
+	assert.Equal("fileDiff", state.Failed().Name)
+	assert.Equal(3, state.Failed().ExitCode)
+	assert.Equal(StatusPending, state.Stages[2].Status)
+
+	// Resumed from the stage that failed
+	state, err = ReadState(fileName)
+	assert.Nil(err)
+	assert.Nil(state.CanResume(args, stages))
+	assert.NotNil(state.CanResume([]string{"-sourceUrl", "otherhost:8091"}, stages))
+	assert.NotNil(state.CanResume(args, stages[:2]))
+	ran = nil
+	assert.Nil(state.Run(func(stage *StageState) error {
+		ran = append(ran, stage.Name)
+		return nil
+	}, onChange))
+	assert.Equal([]string{"fileDiff", "mutationDiff"}, ran)
+	assert.Nil(state.Failed())
+	for _, stage := range state.Stages {
+		assert.Equal(StatusDone, stage.Status)
+		assert.Equal("", stage.Error)
+	}
+	fmt.Println("============== Test case end: TestStateRunAndResume =================")
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+)
+
+type profileSetting struct {
+	flagName string
+	value    string
+}
+
+// Named bundles of options, so that a comparison can be set up without knowing each of them
+// A profile only sets the options that are not explicitly specified
+var profiles = map[string][]profileSetting{
+	// Everything that can be compared is, and differences are reported as first found
+	"strict": {
+		{"compareType", "both"},
+		{"filterTxnMetadata", "false"},
+		{"includeSystemCollections", "true"},
+		{"mutationRetries", "0"},
+		{"clockSkewThresholdSecs", "1"},
+	},
+	// What XDCR replicates, leaving out what legitimately differs, with in-flight differences verified again
+	"xdcr-default": {
+		{"compareType", "meta"},
+		{"filterTxnMetadata", "true"},
+		{"includeSystemCollections", "false"},
+		{"mutationRetries", "3"},
+		{"mutationRetriesWaitSecs", "30"},
+	},
+	// Buckets with last write wins conflict resolution, which depend on the clocks of both clusters
+	"lww": {
+		{"compareType", "meta"},
+		{"filterTxnMetadata", "true"},
+		{"mutationRetries", "3"},
+		{"mutationRetriesWaitSecs", "30"},
+		{"clockSkewThresholdSecs", "1"},
+		{"auditTrail", "true"},
+	},
+	// Buckets used by Sync Gateway
+	"mobile": {
+		{"syncGatewayMode", "true"},
+		{"syncGatewayIgnoreSyncXattr", "true"},
+		{"compareType", "body"},
+		{"filterTxnMetadata", "true"},
+		{"mutationRetries", "3"},
+		{"mutationRetriesWaitSecs", "30"},
+	},
+	// Replications migrating the default collection into collections, which have a large backlog in flight
+	"migration": {
+		{"compareType", "meta"},
+		{"filterTxnMetadata", "true"},
+		{"includeSystemCollections", "false"},
+		{"mutationRetries", "5"},
+		{"mutationRetriesWaitSecs", "60"},
+	},
+}
+
+func profileNames() []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Sets the options of the profile that have not been explicitly specified
+func applyProfile(name string) error {
+	settings, exists := profiles[name]
+	if !exists {
+		return fmt.Errorf("Unknown profile %q. Accepted values are %v", name, profileNames())
+	}
+	for _, setting := range settings {
+		if flagIsSet(setting.flagName) {
+			continue
+		}
+		if err := flag.Set(setting.flagName, setting.value); err != nil {
+			return fmt.Errorf("profile %v: %v", name, err)
+		}
+		fmt.Printf("profile %v set %v to %v\n", name, setting.flagName, setting.value)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+	"xdcrDiffer/base"
+	"xdcrDiffer/utils"
+
+	xdcrBase "github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/metadata"
+)
+
+// Looks up the metadata purge interval of both buckets, past which their tombstones may be purged. An interval that
+// cannot be had stays unknown, and documents missing as their tombstone was purged are then reported as missing
+func (difftool *xdcrDiffTool) lookUpPurgeIntervals() {
+	for _, cluster := range []struct {
+		label    string
+		ref      *metadata.RemoteClusterReference
+		bucket   string
+		interval *time.Duration
+	}{
+		{base.SourceClusterLabel, difftool.selfRef, difftool.specifiedSpec.SourceBucketName, &difftool.sourcePurgeInterval},
+		{base.TargetClusterLabel, difftool.specifiedRef, difftool.specifiedSpec.TargetBucketName, &difftool.targetPurgeInterval},
+	} {
+		interval, err := difftool.purgeInterval(cluster.ref, cluster.bucket)
+		if err != nil {
+			difftool.logger.Warnf("Unable to get the metadata purge interval of %v bucket %v. Documents whose tombstones were purged are reported as missing. err=%v\n",
+				cluster.label, cluster.bucket, err)
+			continue
+		}
+		*cluster.interval = interval
+		difftool.logger.Infof("Metadata purge interval of %v bucket %v is %v\n", cluster.label, cluster.bucket, interval)
+	}
+}
+
+// Of the bucket if it has one of its own, or else of the auto-compaction settings of the cluster
+func (difftool *xdcrDiffTool) purgeInterval(ref *metadata.RemoteClusterReference, bucket string) (time.Duration, error) {
+	bucketInfo := make(map[string]interface{})
+	if err := difftool.getClusterRestApi(ref, xdcrBase.DefaultPoolBucketsPath+bucket, &bucketInfo); err != nil {
+		return 0, err
+	}
+	if interval, ok := utils.GetPurgeIntervalFromSettings(bucketInfo); ok {
+		return interval, nil
+	}
+	settings := make(map[string]interface{})
+	if err := difftool.getClusterRestApi(ref, base.AutoCompactionSettingsPath, &settings); err != nil {
+		return 0, err
+	}
+	if interval, ok := utils.GetPurgeIntervalFromSettings(settings); ok {
+		return interval, nil
+	}
+	return 0, fmt.Errorf("neither the bucket nor the cluster has a %v", base.BucketPurgeIntervalKey)
+}
+
+// Tombstones of documents deleted before then may have been purged. Zero if the interval is unknown
+func purgeHorizon(interval time.Duration) time.Time {
+	if interval == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-interval)
+}
+
+// Warns when the mutations compared were captured over a longer window than a bucket keeps tombstones for, as the
+// tombstones of deletions captured early on may be gone by the time the mutation differ fetches them. A capture that
+// resumed from checkpoints began no later than they were saved
+func (difftool *xdcrDiffTool) checkCaptureWindow() {
+	started := difftool.captureStarted
+	for _, fileName := range []string{options.oldSourceCheckpointFileName, options.oldTargetCheckpointFileName} {
+		if fileName == "" {
+			continue
+		}
+		if info, err := os.Stat(fileName); err == nil && (started.IsZero() || info.ModTime().Before(started)) {
+			started = info.ModTime()
+		}
+	}
+	if started.IsZero() {
+		return
+	}
+	window := time.Since(started).Round(time.Second)
+	for _, cluster := range []struct {
+		label    string
+		bucket   string
+		interval time.Duration
+	}{
+		{base.SourceClusterLabel, options.sourceBucketName, difftool.sourcePurgeInterval},
+		{base.TargetClusterLabel, options.targetBucketName, difftool.targetPurgeInterval},
+	} {
+		if cluster.interval == 0 || window <= cluster.interval {
+			continue
+		}
+		msg := fmt.Sprintf("The capture window of %v exceeds the metadata purge interval of %v bucket %v, %v. Tombstones of deletions captured early on may have been purged from it, and documents missing as they were are reported as %v",
+			window, cluster.label, cluster.bucket, cluster.interval, base.PurgedCategory)
+		fmt.Printf("WARNING: %v\n", msg)
+		difftool.logger.Warnf("%v\n", msg)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"xdcrDiffer/base"
+	"xdcrDiffer/differ"
+	"xdcrDiffer/results"
+)
+
+// Under options.fileDifferDir, the keys sampled from both clusters in the diff keys format
+const sampledKeysFileName = "sampledKeys.json"
+
+// Samples of the two clusters do not hold the same keys, even where the clusters do, so rather than comparing the
+// captures, every key sampled from either cluster is handed to the mutation differ, which fetches it from both
+func (difftool *xdcrDiffTool) diffSampledKeys() error {
+	if err := os.RemoveAll(options.fileDifferDir); err != nil {
+		difftool.logger.Errorf("Error removing fileDifferDir: %v\n", err)
+	}
+	if err := os.MkdirAll(options.fileDifferDir, 0777); err != nil {
+		return fmt.Errorf("Error mkdir fileDifferDir: %v\n", err)
+	}
+	defer difftool.meterPhase(results.PhaseFileDiff, nil, options.fileDifferDir)()
+
+	matchAll := func(string) bool { return true }
+	sourceKeys, err := differ.CaptureKeysMatching(options.sourceFileDir, matchAll)
+	if err != nil {
+		return fmt.Errorf("Unable to read the keys sampled from %v: %v", base.SourceClusterLabel, err)
+	}
+	targetKeys, err := differ.CaptureKeysMatching(options.targetFileDir, matchAll)
+	if err != nil {
+		return fmt.Errorf("Unable to read the keys sampled from %v: %v", base.TargetClusterLabel, err)
+	}
+
+	srcToTgtColIds := difftool.srcToTgtColIdsMap
+	if len(srcToTgtColIds) == 0 {
+		// Legacy mode, where everything is in the default collection
+		srcToTgtColIds = map[uint32][]uint32{0: {0}}
+	}
+	// Keys that only the target sampled are verified under the source collection that maps to theirs
+	keySets := make(map[uint32]map[string]bool)
+	add := func(colId uint32, keys []string) {
+		if keySets[colId] == nil {
+			keySets[colId] = make(map[string]bool)
+		}
+		for _, key := range keys {
+			keySets[colId][key] = true
+		}
+	}
+	for srcColId, tgtColIds := range srcToTgtColIds {
+		add(srcColId, sourceKeys[srcColId])
+		for _, tgtColId := range tgtColIds {
+			add(srcColId, targetKeys[tgtColId])
+		}
+	}
+
+	encodedKeys := make(map[uint32][]string, len(keySets))
+	var count int
+	for colId, keySet := range keySets {
+		var keys []string
+		for key := range keySet {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		encodedKeys[colId] = base.EncodeKeys(keys)
+		count += len(keys)
+	}
+	keysBytes, err := json.Marshal(encodedKeys)
+	if err != nil {
+		return err
+	}
+	keysFileName := filepath.Join(options.fileDifferDir, sampledKeysFileName)
+	if err = ioutil.WriteFile(keysFileName, keysBytes, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Verifying %v keys sampled by range scans with the mutation differ\n", count)
+	options.diffKeysSource = keysFileName
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"xdcrDiffer/base"
+	"xdcrDiffer/results"
+)
+
+// The labels of the clusters whose reads are billed by read units, as given or as told by their URLs
+func capellaClusters(sourceUrl, targetUrl string) []string {
+	var clusters []string
+	switch options.capellaClusters {
+	case base.CapellaClustersSource:
+		clusters = []string{base.SourceClusterLabel}
+	case base.CapellaClustersTarget:
+		clusters = []string{base.TargetClusterLabel}
+	case base.CapellaClustersBoth:
+		clusters = []string{base.SourceClusterLabel, base.TargetClusterLabel}
+	case base.CapellaClustersAuto:
+		if base.IsCapellaUrl(sourceUrl) {
+			clusters = append(clusters, base.SourceClusterLabel)
+		}
+		if base.IsCapellaUrl(targetUrl) {
+			clusters = append(clusters, base.TargetClusterLabel)
+		}
+	}
+	return clusters
+}
+
+// Counts the read units of the Capella clusters, if any, against maxReadUnitSpend
+func (difftool *xdcrDiffTool) setUpReadUnitBudget() {
+	clusters := capellaClusters(options.sourceUrl, difftool.specifiedRef.HostName())
+	if len(clusters) == 0 {
+		return
+	}
+	var limit int64
+	if options.maxReadUnitSpend > 0 {
+		limit = int64(options.maxReadUnitSpend / options.readUnitPrice * 1000000)
+	}
+	difftool.readUnitBudget = base.NewReadUnitBudget(clusters, limit, func(used int64) {
+		difftool.logger.Errorf("%v read units of %v used, which reaches maxReadUnitSpend %v. Aborting the run\n",
+			used, strings.Join(clusters, " and "), options.maxReadUnitSpend)
+		difftool.stopCapture(results.AbortedReadUnitBudget)
+	})
+	fmt.Printf("Reads of %v are billed by read units of Capella", strings.Join(clusters, " and "))
+	if limit > 0 {
+		fmt.Printf(", of which the run may use %v", limit)
+	}
+	fmt.Printf("\n")
+}
+
+// Stops the capture once the read units are spent. The mutation differ checks them before each batch on its own
+func (difftool *xdcrDiffTool) watchReadUnitBudget(stopCh chan bool) {
+	ticker := time.NewTicker(base.ReadUnitBudgetCheckInterval * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+		if difftool.readUnitBudget.Spent() != nil {
+			return
+		}
+	}
+}
+
+func (difftool *xdcrDiffTool) checkReadUnitBudget() {
+	if difftool.readUnitBudget.Spent() != nil && difftool.abortReason == "" {
+		difftool.abortReason = results.AbortedReadUnitBudget
+	}
+}
+
+func (difftool *xdcrDiffTool) printReadUnits() {
+	for _, cluster := range difftool.readUnitBudget.Clusters() {
+		units := base.ClusterReadUnits(cluster)
+		if options.readUnitPrice > 0 {
+			fmt.Printf("Read units used of %v: %v, costing %.2f\n", cluster, units, float64(units)/1000000*options.readUnitPrice)
+		} else {
+			fmt.Printf("Read units used of %v: %v\n", cluster, units)
+		}
+	}
+}
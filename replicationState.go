@@ -0,0 +1,41 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"time"
+	"xdcrDiffer/base"
+	"xdcrDiffer/results"
+)
+
+type clusterLogs struct {
+	List []*results.ClusterLogEntry `json:"list"`
+}
+
+// Looks up whether the replication that was found is paused or reporting errors, through the tasks of the source
+// cluster. The state stays unknown, and the run is taken to verify a running replication, if the tasks cannot be had
+func (difftool *xdcrDiffTool) checkReplicationState() {
+	var tasks []*results.ReplicationTask
+	if err := difftool.getClusterRestApi(difftool.selfRef, base.TasksPath, &tasks); err != nil {
+		difftool.logger.Warnf("Unable to look up the state of replication %v. err=%v\n", difftool.specifiedSpec.Id, err)
+		return
+	}
+	// Only needed for when a paused replication was paused
+	logs := &clusterLogs{}
+	if err := difftool.getClusterRestApi(difftool.selfRef, base.LogsPath, logs); err != nil {
+		difftool.logger.Warnf("Unable to look up when replication %v was paused. err=%v\n", difftool.specifiedSpec.Id, err)
+	}
+
+	difftool.replicationState = results.NewReplicationState(difftool.specifiedSpec.Id, difftool.specifiedSpec.SourceBucketName,
+		difftool.specifiedSpec.TargetBucketName, tasks, logs.List, time.Now())
+	if !difftool.replicationState.Running() {
+		difftool.logger.Warnf("Replication %v: %v\n", difftool.specifiedSpec.Id, difftool.replicationState)
+	}
+}
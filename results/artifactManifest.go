@@ -0,0 +1,250 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The signature of a manifest is written next to it, base64 encoded
+const ArtifactSignatureSuffix = ".sig"
+
+type Artifact struct {
+	// Relative to the directory of the manifest, unless the artifact is on another volume
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// Digests of the files a run produced, so that they can be shown not to have been altered since
+type ArtifactManifest struct {
+	Created   time.Time
+	Artifacts []*Artifact
+}
+
+// Digests every file under roots. Roots that do not exist, i.e. of phases that were not run, are skipped
+func NewArtifactManifest(manifestPath string, roots []string) (*ArtifactManifest, error) {
+	manifestDir, err := filepath.Abs(filepath.Dir(manifestPath))
+	if err != nil {
+		return nil, err
+	}
+	// The manifest does not digest itself, if written within one of the roots
+	excluded := map[string]bool{
+		relativeArtifactPath(manifestDir, manifestPath):                         true,
+		relativeArtifactPath(manifestDir, manifestPath+ArtifactSignatureSuffix): true,
+	}
+
+	manifest := &ArtifactManifest{Created: time.Now().UTC()}
+	for _, root := range roots {
+		if _, err := os.Stat(root); os.IsNotExist(err) {
+			continue
+		}
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			relPath := relativeArtifactPath(manifestDir, path)
+			if info.IsDir() || excluded[relPath] {
+				return nil
+			}
+			digest, err := digestFile(path)
+			if err != nil {
+				return err
+			}
+			manifest.Artifacts = append(manifest.Artifacts, &Artifact{
+				Path:   relPath,
+				Size:   info.Size(),
+				SHA256: digest,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(manifest.Artifacts, func(i, j int) bool {
+		return manifest.Artifacts[i].Path < manifest.Artifacts[j].Path
+	})
+	return manifest, nil
+}
+
+func relativeArtifactPath(manifestDir, path string) string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	relPath, err := filepath.Rel(manifestDir, absPath)
+	if err != nil {
+		return filepath.ToSlash(absPath)
+	}
+	return filepath.ToSlash(relPath)
+}
+
+func digestFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Writes the manifest, and its signature if a signer is given
+func (m *ArtifactManifest) Write(manifestPath string, signer crypto.Signer) error {
+	manifestBytes, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(manifestPath, manifestBytes, 0644); err != nil {
+		return err
+	}
+	if signer == nil {
+		return nil
+	}
+	signature, err := signManifest(signer, manifestBytes)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(manifestPath+ArtifactSignatureSuffix, []byte(base64.StdEncoding.EncodeToString(signature)+"\n"), 0644)
+}
+
+// Ed25519 keys sign the manifest itself, RSA and ECDSA keys its SHA-256 digest
+func signManifest(signer crypto.Signer, manifestBytes []byte) ([]byte, error) {
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		return signer.Sign(rand.Reader, manifestBytes, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(manifestBytes)
+	return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// Reads a manifest, verifying its signature against publicKey if one is given
+func ReadArtifactManifest(manifestPath string, publicKey crypto.PublicKey) (*ArtifactManifest, error) {
+	manifestBytes, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	if publicKey != nil {
+		encodedSignature, err := ioutil.ReadFile(manifestPath + ArtifactSignatureSuffix)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the signature of %v: %v", manifestPath, err)
+		}
+		signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encodedSignature)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature of %v: %v", manifestPath, err)
+		}
+		if !verifyManifestSignature(publicKey, manifestBytes, signature) {
+			return nil, fmt.Errorf("signature of %v does not match its content", manifestPath)
+		}
+	}
+	manifest := &ArtifactManifest{}
+	if err = json.Unmarshal(manifestBytes, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func verifyManifestSignature(publicKey crypto.PublicKey, manifestBytes, signature []byte) bool {
+	digest := sha256.Sum256(manifestBytes)
+	switch key := publicKey.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, manifestBytes, signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	default:
+		return false
+	}
+}
+
+// Re-digests the artifacts, returning those that were altered or removed since the manifest was written
+func (m *ArtifactManifest) Verify(manifestPath string) ([]string, error) {
+	manifestDir := filepath.Dir(manifestPath)
+	var altered []string
+	for _, artifact := range m.Artifacts {
+		path := filepath.FromSlash(artifact.Path)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(manifestDir, path)
+		}
+		digest, err := digestFile(path)
+		if os.IsNotExist(err) {
+			altered = append(altered, fmt.Sprintf("%v: removed", artifact.Path))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if digest != artifact.SHA256 {
+			altered = append(altered, fmt.Sprintf("%v: altered", artifact.Path))
+		}
+	}
+	return altered, nil
+}
+
+// Parses a PEM private key, i.e. PKCS#8, or PKCS#1 RSA or SEC 1 EC keys as written by openssl
+func ParseSigningKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM private key found")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%T keys cannot sign", key)
+	}
+	return signer, nil
+}
+
+// Parses a PEM public key, or the certificate holding it
+func ParseVerificationKey(pemBytes []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM public key found")
+	}
+	if block.Type == "CERTIFICATE" {
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return certificate.PublicKey, nil
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
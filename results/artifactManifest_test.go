@@ -0,0 +1,89 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArtifactManifest(t *testing.T) {
+	fmt.Println("============== Test case start: TestArtifactManifest =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "artifactManifest")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	fileDiffDir := filepath.Join(dir, "fileDiff")
+	assert.Nil(os.MkdirAll(fileDiffDir, 0777))
+	assert.Nil(ioutil.WriteFile(filepath.Join(fileDiffDir, "diffKeys"), []byte(`{"0":["key"]}`), 0644))
+	assert.Nil(ioutil.WriteFile(filepath.Join(fileDiffDir, RunMetadataFileName), []byte(`{}`), 0644))
+	manifestPath := filepath.Join(dir, "artifactManifest.json")
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.Nil(err)
+	signer, err := ParseSigningKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+	assert.Nil(err)
+	pkix, err := x509.MarshalPKIXPublicKey(privateKey.Public())
+	assert.Nil(err)
+	publicKey, err := ParseVerificationKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix}))
+	assert.Nil(err)
+
+	manifest, err := NewArtifactManifest(manifestPath, []string{fileDiffDir, filepath.Join(dir, "mutationDiff")})
+	assert.Nil(err)
+	assert.Equal(2, len(manifest.Artifacts))
+	assert.Equal("fileDiff/diffKeys", manifest.Artifacts[0].Path)
+	assert.Nil(manifest.Write(manifestPath, signer))
+
+	readManifest, err := ReadArtifactManifest(manifestPath, publicKey)
+	assert.Nil(err)
+	altered, err := readManifest.Verify(manifestPath)
+	assert.Nil(err)
+	assert.Equal(0, len(altered))
+
+	// Altered and removed artifacts are reported
+	assert.Nil(ioutil.WriteFile(filepath.Join(fileDiffDir, "diffKeys"), []byte(`{}`), 0644))
+	assert.Nil(os.Remove(filepath.Join(fileDiffDir, RunMetadataFileName)))
+	altered, err = readManifest.Verify(manifestPath)
+	assert.Nil(err)
+	assert.Equal([]string{"fileDiff/diffKeys: altered", "fileDiff/runMetadata: removed"}, altered)
+
+	// As is an altered manifest, or a key other than the one it was signed with
+	manifestBytes, err := ioutil.ReadFile(manifestPath)
+	assert.Nil(err)
+	assert.Nil(ioutil.WriteFile(manifestPath, append(manifestBytes, ' '), 0644))
+	_, err = ReadArtifactManifest(manifestPath, publicKey)
+	assert.NotNil(err)
+	assert.Nil(ioutil.WriteFile(manifestPath, manifestBytes, 0644))
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(err)
+	_, err = ReadArtifactManifest(manifestPath, otherKey.Public())
+	assert.NotNil(err)
+
+	// ECDSA keys sign the digest of the manifest
+	assert.Nil(manifest.Write(manifestPath, otherKey))
+	_, err = ReadArtifactManifest(manifestPath, otherKey.Public())
+	assert.Nil(err)
+
+	fmt.Println("============== Test case end: TestArtifactManifest =================")
+}
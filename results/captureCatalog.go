@@ -0,0 +1,152 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Under the catalog directory, next to the snapshot directory of each tag
+const CaptureCatalogFileName = "catalog.json"
+
+// Tags name directories, so they are kept to characters that are safe in a path
+var catalogTagRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+type SeqnoRange struct {
+	Low  uint64
+	High uint64
+}
+
+// A capture directory as it was when it was tagged, i.e. "pre-upgrade"
+type CatalogedCapture struct {
+	Tag string
+	// Of the capture, i.e. the label of the cluster and the bucket captured
+	Cluster string
+	Bucket  string `json:",omitempty"`
+	// Where the capture was tagged from. Its capture files are kept under the catalog, as later runs replace them
+	CapturedFrom string
+	// When the last capture file was written to
+	CapturedAt time.Time
+	TaggedAt   time.Time
+	Note       string `json:",omitempty"`
+	// Capture files of each vbucket, which two snapshots must agree on to be diffed
+	Bins    int
+	Records int
+	// Collection ID -> number of distinct keys
+	Keys map[uint32]int
+	// vbucket -> lowest and highest seqnos captured
+	Seqnos map[uint16]SeqnoRange
+}
+
+// Seqnos captured across all vbuckets
+func (c *CatalogedCapture) SeqnoCount() uint64 {
+	var count uint64
+	for _, seqnos := range c.Seqnos {
+		if seqnos.High >= seqnos.Low && seqnos.High > 0 {
+			count += seqnos.High - seqnos.Low + 1
+		}
+	}
+	return count
+}
+
+// Tagged capture datasets, for any two of them to be diffed later
+type CaptureCatalog struct {
+	Captures []*CatalogedCapture
+}
+
+func ValidateCatalogTag(tag string) error {
+	if !catalogTagRegex.MatchString(tag) || tag == "." || tag == ".." {
+		return fmt.Errorf("invalid tag %q: tags are made of letters, digits, '_', '.' and '-'", tag)
+	}
+	return nil
+}
+
+// Where the capture files of a tag are kept
+func CatalogSnapshotDir(catalogDir, tag string) string {
+	return filepath.Join(catalogDir, tag)
+}
+
+// Reads the catalog of a directory. A directory with no catalog yet has an empty one
+func ReadCaptureCatalog(catalogDir string) (*CaptureCatalog, error) {
+	catalog := &CaptureCatalog{}
+	catalogBytes, err := ioutil.ReadFile(filepath.Join(catalogDir, CaptureCatalogFileName))
+	if os.IsNotExist(err) {
+		return catalog, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(catalogBytes, catalog); err != nil {
+		return nil, fmt.Errorf("unable to parse the catalog of %v: %v", catalogDir, err)
+	}
+	return catalog, nil
+}
+
+// Writes the catalog, replacing the previous one only once it has been written in full
+func (c *CaptureCatalog) Write(catalogDir string) error {
+	catalogBytes, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(catalogDir, 0777); err != nil {
+		return err
+	}
+	catalogFileName := filepath.Join(catalogDir, CaptureCatalogFileName)
+	if err = ioutil.WriteFile(catalogFileName+".tmp", catalogBytes, 0644); err != nil {
+		return err
+	}
+	return os.Rename(catalogFileName+".tmp", catalogFileName)
+}
+
+func (c *CaptureCatalog) Find(tag string) *CatalogedCapture {
+	for _, capture := range c.Captures {
+		if capture.Tag == tag {
+			return capture
+		}
+	}
+	return nil
+}
+
+// Adds a capture, keeping the catalog ordered by when its captures were taken. A tag already in use is only
+// replaced if asked to
+func (c *CaptureCatalog) Add(capture *CatalogedCapture, replace bool) error {
+	if err := ValidateCatalogTag(capture.Tag); err != nil {
+		return err
+	}
+	if existing := c.Find(capture.Tag); existing != nil {
+		if !replace {
+			return fmt.Errorf("tag %v is already in use, by a capture of %v taken at %v", capture.Tag, existing.Cluster,
+				existing.CapturedAt.Format(time.RFC3339))
+		}
+		c.Remove(capture.Tag)
+	}
+	c.Captures = append(c.Captures, capture)
+	sort.SliceStable(c.Captures, func(i, j int) bool {
+		return c.Captures[i].CapturedAt.Before(c.Captures[j].CapturedAt)
+	})
+	return nil
+}
+
+// Removes the capture of a tag, returning whether there was one
+func (c *CaptureCatalog) Remove(tag string) bool {
+	for i, capture := range c.Captures {
+		if capture.Tag == tag {
+			c.Captures = append(c.Captures[:i], c.Captures[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
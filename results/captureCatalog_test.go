@@ -0,0 +1,70 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaptureCatalog(t *testing.T) {
+	fmt.Println("============== Test case start: TestCaptureCatalog =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "captureCatalog")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	catalogDir := filepath.Join(dir, "catalog")
+
+	// Nothing is cataloged until something is tagged
+	catalog, err := ReadCaptureCatalog(catalogDir)
+	assert.Nil(err)
+	assert.Len(catalog.Captures, 0)
+
+	captured := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	postFailover := &CatalogedCapture{Tag: "post-failover", Cluster: "source", CapturedAt: captured.Add(time.Hour), Bins: 5,
+		Seqnos: map[uint16]SeqnoRange{0: {Low: 1, High: 10}, 1: {Low: 0, High: 0}}}
+	preUpgrade := &CatalogedCapture{Tag: "pre-upgrade", Cluster: "source", CapturedAt: captured, Bins: 5,
+		Keys: map[uint32]int{0: 7, 8: 3}}
+	assert.Nil(catalog.Add(postFailover, false))
+	assert.Nil(catalog.Add(preUpgrade, false))
+	assert.Equal("pre-upgrade", catalog.Captures[0].Tag)
+	assert.Equal(uint64(10), postFailover.SeqnoCount())
+
+	// A tag in use is only replaced if asked to
+	retagged := &CatalogedCapture{Tag: "pre-upgrade", Cluster: "target", CapturedAt: captured.Add(2 * time.Hour)}
+	assert.NotNil(catalog.Add(retagged, false))
+	assert.Equal("source", catalog.Find("pre-upgrade").Cluster)
+	assert.Nil(catalog.Add(retagged, true))
+	assert.Len(catalog.Captures, 2)
+	assert.Equal("pre-upgrade", catalog.Captures[1].Tag)
+	assert.Equal("target", catalog.Find("pre-upgrade").Cluster)
+
+	for _, tag := range []string{"", "..", "a/b", "pre upgrade"} {
+		assert.NotNil(catalog.Add(&CatalogedCapture{Tag: tag}, false), tag)
+	}
+
+	assert.Nil(catalog.Write(catalogDir))
+	readBack, err := ReadCaptureCatalog(catalogDir)
+	assert.Nil(err)
+	assert.Len(readBack.Captures, 2)
+	assert.Equal(SeqnoRange{Low: 1, High: 10}, readBack.Find("post-failover").Seqnos[0])
+	assert.Nil(readBack.Find("missing"))
+	assert.True(readBack.Remove("post-failover"))
+	assert.False(readBack.Remove("post-failover"))
+	assert.Equal(filepath.Join(catalogDir, "pre-upgrade"), CatalogSnapshotDir(catalogDir, "pre-upgrade"))
+	fmt.Println("============== Test case end: TestCaptureCatalog =================")
+}
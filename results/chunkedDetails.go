@@ -0,0 +1,246 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"xdcrDiffer/base"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The mutation differ output can also be written as chunks of entries, each compressed as a zstd frame of its own and
+// holding its entries as JSON lines, along with an index of the chunk of every key. The index is written as JSON lines
+// sorted by key, so that the chunks of a key are found by a binary search of the index, and the entries of the key are
+// read by decompressing only those chunks, rather than by reading the whole output
+type ChunkIndexEntry struct {
+	// As written by the differ, i.e. encoded by base.EncodeKey
+	Key string
+	// Of the chunk in the details file
+	Offset int64
+	Length int64
+}
+
+type ChunkedDetailsWriter struct {
+	details      io.Writer
+	index        *bufio.Writer
+	compressor   *zstd.Encoder
+	chunkEntries int
+	pending      []*Entry
+	// Of the next chunk in the details file
+	offset int64
+	// Written once all chunks are, as they have to be sorted by key
+	indexEntries []*ChunkIndexEntry
+}
+
+// Entries are written to details and the index to index, chunkEntries entries to a chunk
+func NewChunkedDetailsWriter(details, index io.Writer, chunkEntries int) (*ChunkedDetailsWriter, error) {
+	compressor, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ChunkedDetailsWriter{
+		details:      details,
+		index:        bufio.NewWriter(index),
+		compressor:   compressor,
+		chunkEntries: chunkEntries,
+	}, nil
+}
+
+func (w *ChunkedDetailsWriter) Add(entry *Entry) error {
+	w.pending = append(w.pending, entry)
+	if len(w.pending) < w.chunkEntries {
+		return nil
+	}
+	return w.flush()
+}
+
+func (w *ChunkedDetailsWriter) flush() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	for _, entry := range w.pending {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	chunk := w.compressor.EncodeAll(buffer.Bytes(), nil)
+	if _, err := w.details.Write(chunk); err != nil {
+		return err
+	}
+	for _, entry := range w.pending {
+		w.indexEntries = append(w.indexEntries, &ChunkIndexEntry{Key: entry.Key, Offset: w.offset, Length: int64(len(chunk))})
+	}
+	w.offset += int64(len(chunk))
+	w.pending = w.pending[:0]
+	return nil
+}
+
+// Writes the last chunk and the index
+func (w *ChunkedDetailsWriter) Close() error {
+	defer w.compressor.Close()
+	if err := w.flush(); err != nil {
+		return err
+	}
+	// The entries of a key are in the order their chunks were written
+	sort.SliceStable(w.indexEntries, func(i, j int) bool {
+		return w.indexEntries[i].Key < w.indexEntries[j].Key
+	})
+	indexEncoder := json.NewEncoder(w.index)
+	indexEncoder.SetEscapeHTML(false)
+	for _, indexEntry := range w.indexEntries {
+		if err := indexEncoder.Encode(indexEntry); err != nil {
+			return err
+		}
+	}
+	w.indexEntries = nil
+	return w.index.Flush()
+}
+
+// Whether the chunked output was written to dir
+func HasChunkedDetails(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, base.MutationDiffChunkedIndexFileName))
+	return err == nil
+}
+
+// Returns the entries of the key, as written by the differ, of the chunked output in dir. Only the index and the
+// chunks that hold the key are read
+func LookupChunkedDetails(dir, key string) ([]*Entry, error) {
+	indexFileName := filepath.Join(dir, base.MutationDiffChunkedIndexFileName)
+	chunks, err := chunksOfKey(indexFileName, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, nil
+	}
+
+	detailsFileName := filepath.Join(dir, base.MutationDiffChunkedDetailsFileName)
+	details, err := os.Open(detailsFileName)
+	if err != nil {
+		return nil, err
+	}
+	defer details.Close()
+	decompressor, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer decompressor.Close()
+
+	var entries []*Entry
+	for _, chunk := range chunks {
+		compressed := make([]byte, chunk.Length)
+		if _, err = details.ReadAt(compressed, chunk.Offset); err != nil {
+			return nil, fmt.Errorf("%v: chunk at %v: %v", detailsFileName, chunk.Offset, err)
+		}
+		decompressed, err := decompressor.DecodeAll(compressed, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%v: chunk at %v: %v", detailsFileName, chunk.Offset, err)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(decompressed))
+		for decoder.More() {
+			entry := &Entry{}
+			if err = decoder.Decode(entry); err != nil {
+				return nil, fmt.Errorf("%v: chunk at %v: %v", detailsFileName, chunk.Offset, err)
+			}
+			if entry.Key == key {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// The chunks that hold the key, in the order they were written
+func chunksOfKey(indexFileName, key string) ([]*ChunkIndexEntry, error) {
+	index, err := os.Open(indexFileName)
+	if err != nil {
+		return nil, err
+	}
+	defer index.Close()
+	fileInfo, err := index.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// The lowest position from which the first line to start is of the key or of a later one. Positions past the
+	// last line are taken to be of a later key
+	var searchErr error
+	low := sort.Search(int(fileInfo.Size()), func(pos int) bool {
+		indexEntry, _, err := readIndexLine(index, int64(pos))
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return indexEntry == nil || indexEntry.Key >= key
+	})
+	if searchErr != nil {
+		return nil, fmt.Errorf("%v: %v", indexFileName, searchErr)
+	}
+
+	var chunks []*ChunkIndexEntry
+	seen := make(map[int64]bool)
+	for pos := int64(low); ; {
+		indexEntry, next, err := readIndexLine(index, pos)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", indexFileName, err)
+		}
+		if indexEntry == nil || indexEntry.Key != key {
+			return chunks, nil
+		}
+		if !seen[indexEntry.Offset] {
+			seen[indexEntry.Offset] = true
+			chunks = append(chunks, indexEntry)
+		}
+		pos = next
+	}
+}
+
+// Decodes the first line of the index that starts at pos or after it. Returns the position after the line, or a nil
+// entry if no line starts there
+func readIndexLine(index io.ReaderAt, pos int64) (*ChunkIndexEntry, int64, error) {
+	start := pos
+	if pos > 0 {
+		// Unless pos is the start of a line, the rest of the line it is in is skipped
+		start = pos - 1
+	}
+	reader := bufio.NewReader(io.NewSectionReader(index, start, math.MaxInt64-start))
+	if pos > 0 {
+		skipped, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil, 0, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
+		start += int64(len(skipped))
+	}
+	line, err := reader.ReadBytes('\n')
+	if err == io.EOF && len(line) == 0 {
+		return nil, 0, nil
+	} else if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	indexEntry := &ChunkIndexEntry{}
+	if err = json.Unmarshal(line, indexEntry); err != nil {
+		return nil, 0, err
+	}
+	return indexEntry, start + int64(len(line)), nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"xdcrDiffer/base"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkedDetails(t *testing.T) {
+	fmt.Println("============== Test case start: TestChunkedDetails =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "chunkedDetails")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	assert.False(HasChunkedDetails(dir))
+
+	details, err := os.Create(filepath.Join(dir, base.MutationDiffChunkedDetailsFileName))
+	assert.Nil(err)
+	index, err := os.Create(filepath.Join(dir, base.MutationDiffChunkedIndexFileName))
+	assert.Nil(err)
+	writer, err := NewChunkedDetailsWriter(details, index, 3)
+	assert.Nil(err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(writer.Add(&Entry{Category: "MissingFromTarget", ColId: 8, Key: fmt.Sprintf("user_%v", i),
+			Details: json.RawMessage(fmt.Sprintf(`{"Cas":%v}`, i))}))
+	}
+	// A key can be in several categories, and so in several chunks
+	assert.Nil(writer.Add(&Entry{Category: "Mismatch", ColId: 8, Key: "user_1", Details: json.RawMessage(`[{"Cas":1},{"Cas":11}]`)}))
+	assert.Nil(writer.Close())
+	assert.Nil(details.Close())
+	assert.Nil(index.Close())
+	assert.True(HasChunkedDetails(dir))
+
+	entries, err := LookupChunkedDetails(dir, "user_1")
+	assert.Nil(err)
+	assert.Len(entries, 2)
+	assert.Equal("MissingFromTarget", entries[0].Category)
+	assert.Equal(`{"Cas":1}`, string(entries[0].Details))
+	assert.Equal("Mismatch", entries[1].Category)
+
+	entries, err = LookupChunkedDetails(dir, "user_9")
+	assert.Nil(err)
+	assert.Len(entries, 1)
+	assert.Equal(uint32(8), entries[0].ColId)
+
+	entries, err = LookupChunkedDetails(dir, "user_99")
+	assert.Nil(err)
+	assert.Len(entries, 0)
+	entries, err = LookupChunkedDetails(dir, "a")
+	assert.Nil(err)
+	assert.Len(entries, 0)
+	entries, err = LookupChunkedDetails(dir, "z")
+	assert.Nil(err)
+	assert.Len(entries, 0)
+
+	// Keys written in no order are found all the same, as the index is sorted
+	details, err = os.Create(filepath.Join(dir, base.MutationDiffChunkedDetailsFileName))
+	assert.Nil(err)
+	index, err = os.Create(filepath.Join(dir, base.MutationDiffChunkedIndexFileName))
+	assert.Nil(err)
+	writer, err = NewChunkedDetailsWriter(details, index, 7)
+	assert.Nil(err)
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("order_%v", i*337%500)
+		assert.Nil(writer.Add(&Entry{Category: "Mismatch", Key: key, Details: json.RawMessage(fmt.Sprintf(`"%v"`, key))}))
+	}
+	assert.Nil(writer.Close())
+	assert.Nil(details.Close())
+	assert.Nil(index.Close())
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("order_%v", i)
+		entries, err = LookupChunkedDetails(dir, key)
+		assert.Nil(err)
+		if assert.Len(entries, 1, key) {
+			assert.Equal(fmt.Sprintf(`"%v"`, key), string(entries[0].Details))
+		}
+	}
+	entries, err = LookupChunkedDetails(dir, "order_")
+	assert.Nil(err)
+	assert.Len(entries, 0)
+	fmt.Println("============== Test case end: TestChunkedDetails =================")
+}
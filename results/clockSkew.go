@@ -0,0 +1,87 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"time"
+)
+
+// The clock of a KV node, as measured from the machine running the differ at the start of a run
+type NodeClock struct {
+	Node string
+	// Clock of the node minus the clock of this machine
+	Offset time.Duration
+	// Offset is accurate to within this, given the resolution of the clock stat and the round trip to the node
+	Uncertainty time.Duration
+	// How far the hybrid logical clock of the node, i.e. the highest CAS of its vbuckets, is ahead of its clock
+	HLCAhead time.Duration `json:",omitempty"`
+	// Number of mutations received by the node through XDCR with a CAS beyond the drift thresholds of the bucket
+	DriftAheadExceeded  uint64 `json:",omitempty"`
+	DriftBehindExceeded uint64 `json:",omitempty"`
+}
+
+type ClockSkewReport struct {
+	// Whether either bucket resolves conflicts by timestamp, which is where skew loses data
+	LWW    bool
+	Source []*NodeClock
+	Target []*NodeClock
+	// Largest difference between the clocks of a source node and a target node, and how accurate it is
+	MaxSkew            time.Duration
+	MaxSkewUncertainty time.Duration
+	Warnings           []string `json:",omitempty"`
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// Skew is only warned about when it exceeds threshold even at the low end of its uncertainty
+func NewClockSkewReport(source, target []*NodeClock, lww bool, threshold time.Duration) *ClockSkewReport {
+	report := &ClockSkewReport{LWW: lww, Source: source, Target: target}
+
+	var skewedSource, skewedTarget *NodeClock
+	for _, sourceClock := range source {
+		for _, targetClock := range target {
+			skew := abs(sourceClock.Offset - targetClock.Offset)
+			if skewedSource == nil || skew > report.MaxSkew {
+				report.MaxSkew = skew
+				report.MaxSkewUncertainty = sourceClock.Uncertainty + targetClock.Uncertainty
+				skewedSource, skewedTarget = sourceClock, targetClock
+			}
+		}
+	}
+
+	if skewedSource != nil && report.MaxSkew-report.MaxSkewUncertainty > threshold {
+		warning := fmt.Sprintf("clocks of source node %v and target node %v are %v (+/- %v) apart",
+			skewedSource.Node, skewedTarget.Node, report.MaxSkew, report.MaxSkewUncertainty)
+		if lww {
+			warning += ", so last write wins conflict resolution can keep the older of two conflicting mutations"
+		}
+		report.Warnings = append(report.Warnings, warning)
+	}
+
+	for _, clocks := range [][]*NodeClock{source, target} {
+		for _, clock := range clocks {
+			if clock.HLCAhead > threshold {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("hybrid logical clock of node %v is %v ahead of its clock",
+					clock.Node, clock.HLCAhead))
+			}
+			if clock.DriftAheadExceeded > 0 || clock.DriftBehindExceeded > 0 {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("node %v received %v mutations through XDCR with a CAS ahead and %v behind its drift thresholds",
+					clock.Node, clock.DriftAheadExceeded, clock.DriftBehindExceeded))
+			}
+		}
+	}
+	return report
+}
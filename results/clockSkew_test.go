@@ -0,0 +1,41 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClockSkewReport(t *testing.T) {
+	fmt.Println("============== Test case start: TestClockSkewReport =================")
+	assert := assert.New(t)
+
+	source := []*NodeClock{
+		{Node: "s1:11210", Offset: 200 * time.Millisecond, Uncertainty: 500 * time.Millisecond},
+		{Node: "s2:11210", Offset: -time.Second, Uncertainty: 500 * time.Millisecond},
+	}
+	target := []*NodeClock{
+		{Node: "t1:11210", Offset: 8 * time.Second, Uncertainty: 500 * time.Millisecond, DriftAheadExceeded: 3},
+	}
+
+	report := NewClockSkewReport(source, target, true, 5*time.Second)
+	assert.Equal(9*time.Second, report.MaxSkew)
+	assert.Equal(time.Second, report.MaxSkewUncertainty)
+	assert.Len(report.Warnings, 2)
+
+	// Within the threshold once the uncertainty is accounted for
+	report = NewClockSkewReport(source[:1], []*NodeClock{{Node: "t1:11210", Offset: 6 * time.Second, Uncertainty: time.Second}}, false, 5*time.Second)
+	assert.Len(report.Warnings, 0)
+	fmt.Println("============== Test case end: TestClockSkewReport =================")
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+	"xdcrDiffer/base"
+)
+
+// The critical keys are verified before the rest of the run, and once more at its end
+const (
+	CriticalKeysPassFirst = "first"
+	CriticalKeysPassFinal = "final"
+)
+
+// Outcome of each pass over the critical keys. It is rewritten after every pass, so that what was verified stands
+// even if the run is cut short
+type CriticalKeysReport struct {
+	Updated time.Time
+	Passes  []*CriticalKeysPass
+}
+
+type CriticalKeysPass struct {
+	Name     string
+	Verified int
+	// Category -> keys found to differ. Keys that are listed neither here nor in Unverified match
+	Differences map[string][]string
+	// Keys that could not be fetched, which therefore have no answer
+	Unverified []string `json:",omitempty"`
+}
+
+// Reads the output the mutation differ wrote to outputDir for a pass over the given number of critical keys
+func NewCriticalKeysPass(name string, verified int, outputDir string) (*CriticalKeysPass, error) {
+	pass := &CriticalKeysPass{Name: name, Verified: verified, Differences: make(map[string][]string)}
+	_, err := scanPhase(PhaseMutationDiff, filepath.Join(outputDir, base.MutationDiffFileName), func(entry *Entry) {
+		// Expected differences are reported, but the keys do match as far as the replication is concerned
+		if entry.Category != base.ExpectedByConfigurationCategory {
+			pass.Differences[entry.Category] = append(pass.Differences[entry.Category], entry.Key)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, keys := range pass.Differences {
+		sort.Strings(keys)
+	}
+
+	errorKeysBytes, err := ioutil.ReadFile(filepath.Join(outputDir, base.DiffErrorKeysFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(errorKeysBytes) > 0 {
+		if pass.Unverified, err = ParseKeysWithError(errorKeysBytes); err != nil {
+			return nil, fmt.Errorf("Unable to read %v: %v", base.DiffErrorKeysFileName, err)
+		}
+		sort.Strings(pass.Unverified)
+	}
+	return pass, nil
+}
+
+// Number of keys found to differ
+func (p *CriticalKeysPass) Differing() int {
+	var differing int
+	for _, keys := range p.Differences {
+		differing += len(keys)
+	}
+	return differing
+}
+
+func (p *CriticalKeysPass) String() string {
+	return fmt.Sprintf("%v pass verified %v keys: %v differ, %v could not be fetched", p.Name, p.Verified, p.Differing(), len(p.Unverified))
+}
+
+// Replaces the pass of the same name, if any
+func (r *CriticalKeysReport) AddPass(pass *CriticalKeysPass) {
+	r.Updated = time.Now().UTC()
+	for i, existing := range r.Passes {
+		if existing.Name == pass.Name {
+			r.Passes[i] = pass
+			return
+		}
+	}
+	r.Passes = append(r.Passes, pass)
+}
+
+// The pass that has the final say, i.e. the last one to have completed. Nil if none has
+func (r *CriticalKeysReport) Latest() *CriticalKeysPass {
+	if len(r.Passes) == 0 {
+		return nil
+	}
+	return r.Passes[len(r.Passes)-1]
+}
+
+func (r *CriticalKeysReport) Write(fileName string) error {
+	reportBytes, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, reportBytes, 0644)
+}
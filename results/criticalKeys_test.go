@@ -0,0 +1,70 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCriticalKeysReport(t *testing.T) {
+	fmt.Println("============== Test case start: TestCriticalKeysReport =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "criticalKeys")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	firstDir := filepath.Join(dir, CriticalKeysPassFirst)
+	assert.Nil(os.MkdirAll(firstDir, 0777))
+	assert.Nil(ioutil.WriteFile(filepath.Join(firstDir, "mutationDiffDetails"), []byte(mutationDiffOutput), 0644))
+	assert.Nil(ioutil.WriteFile(filepath.Join(firstDir, "diffKeysWithError"), []byte(`[{"SrcColId":0,"TgtColIds":[0],"Key":"order_2"}]`), 0644))
+	first, err := NewCriticalKeysPass(CriticalKeysPassFirst, 10, firstDir)
+	assert.Nil(err)
+	assert.Equal(map[string][]string{"Mismatch": {"user_1"}, "MissingFromTarget": {"order_1", "user_2", "user_3"}}, first.Differences)
+	assert.Equal([]string{"order_2"}, first.Unverified)
+	assert.Equal("first pass verified 10 keys: 4 differ, 1 could not be fetched", first.String())
+
+	report := &CriticalKeysReport{}
+	assert.Nil(report.Latest())
+	report.AddPass(first)
+
+	// Documents the replication is configured to make differ do not differ as far as the critical keys go
+	finalDir := filepath.Join(dir, CriticalKeysPassFinal)
+	assert.Nil(os.MkdirAll(finalDir, 0777))
+	assert.Nil(ioutil.WriteFile(filepath.Join(finalDir, "mutationDiffDetails"),
+		[]byte(`{"Mismatch":{},"ExpectedByConfiguration":{"0":{"user_1":[{"Cas":1},{"Cas":1}]}}}`), 0644))
+	final, err := NewCriticalKeysPass(CriticalKeysPassFinal, 10, finalDir)
+	assert.Nil(err)
+	assert.Equal(0, final.Differing())
+	assert.Len(final.Unverified, 0)
+	report.AddPass(final)
+	report.AddPass(final)
+	assert.Len(report.Passes, 2)
+	assert.Equal(final, report.Latest())
+
+	reportFile := filepath.Join(dir, "report.json")
+	assert.Nil(report.Write(reportFile))
+	reportBytes, err := ioutil.ReadFile(reportFile)
+	assert.Nil(err)
+	var written CriticalKeysReport
+	assert.Nil(json.Unmarshal(reportBytes, &written))
+	assert.Equal(report.Passes, written.Passes)
+
+	_, err = NewCriticalKeysPass(CriticalKeysPassFinal, 10, filepath.Join(dir, "missing"))
+	assert.NotNil(err)
+	fmt.Println("============== Test case end: TestCriticalKeysReport =================")
+}
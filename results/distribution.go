@@ -0,0 +1,153 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Upper bounds, in bytes, of the document size ranges. The last range has no upper bound
+var DocumentSizeBounds = []int{256, 1024, 4 * 1024, 16 * 1024, 64 * 1024, 256 * 1024, 1024 * 1024}
+
+// Labels of the document size ranges, in the same order as the counts of DocumentDistribution.Sizes
+func DocumentSizeLabels() []string {
+	labels := make([]string, 0, len(DocumentSizeBounds)+1)
+	lower := 0
+	for _, bound := range DocumentSizeBounds {
+		labels = append(labels, fmt.Sprintf("%v-%v", formatSize(lower), formatSize(bound)))
+		lower = bound
+	}
+	return append(labels, fmt.Sprintf(">=%v", formatSize(lower)))
+}
+
+func formatSize(size int) string {
+	switch {
+	case size >= 1024*1024 && size%(1024*1024) == 0:
+		return fmt.Sprintf("%vMiB", size/(1024*1024))
+	case size >= 1024 && size%1024 == 0:
+		return fmt.Sprintf("%vKiB", size/1024)
+	default:
+		return fmt.Sprintf("%vB", size)
+	}
+}
+
+// Shape of the documents captured from one bucket. Sizes are of the value as received, including xattrs
+type DocumentDistribution struct {
+	Documents  int64
+	Tombstones int64
+	// Number of documents within each size range of DocumentSizeBounds
+	Sizes      []int64
+	MaxSize    int64
+	JSON       int64
+	Binary     int64
+	Compressed int64
+	WithXattrs int64
+}
+
+// Counts documents as they are captured. Safe for concurrent use by the DCP handlers
+type DistributionRecorder struct {
+	documents  int64
+	tombstones int64
+	sizes      []int64
+	maxSize    int64
+	json       int64
+	binary     int64
+	compressed int64
+	withXattrs int64
+}
+
+func NewDistributionRecorder() *DistributionRecorder {
+	return &DistributionRecorder{sizes: make([]int64, len(DocumentSizeBounds)+1)}
+}
+
+func (r *DistributionRecorder) Record(size int, isJSON, isCompressed, hasXattrs bool) {
+	atomic.AddInt64(&r.documents, 1)
+	i := 0
+	for i < len(DocumentSizeBounds) && size >= DocumentSizeBounds[i] {
+		i++
+	}
+	atomic.AddInt64(&r.sizes[i], 1)
+	for {
+		maxSize := atomic.LoadInt64(&r.maxSize)
+		if int64(size) <= maxSize || atomic.CompareAndSwapInt64(&r.maxSize, maxSize, int64(size)) {
+			break
+		}
+	}
+	if isJSON {
+		atomic.AddInt64(&r.json, 1)
+	} else {
+		atomic.AddInt64(&r.binary, 1)
+	}
+	if isCompressed {
+		atomic.AddInt64(&r.compressed, 1)
+	}
+	if hasXattrs {
+		atomic.AddInt64(&r.withXattrs, 1)
+	}
+}
+
+func (r *DistributionRecorder) RecordTombstone() {
+	atomic.AddInt64(&r.tombstones, 1)
+}
+
+func (r *DistributionRecorder) Snapshot() *DocumentDistribution {
+	distribution := &DocumentDistribution{
+		Documents:  atomic.LoadInt64(&r.documents),
+		Tombstones: atomic.LoadInt64(&r.tombstones),
+		Sizes:      make([]int64, len(r.sizes)),
+		MaxSize:    atomic.LoadInt64(&r.maxSize),
+		JSON:       atomic.LoadInt64(&r.json),
+		Binary:     atomic.LoadInt64(&r.binary),
+		Compressed: atomic.LoadInt64(&r.compressed),
+		WithXattrs: atomic.LoadInt64(&r.withXattrs),
+	}
+	for i := range r.sizes {
+		distribution.Sizes[i] = atomic.LoadInt64(&r.sizes[i])
+	}
+	return distribution
+}
+
+type DistributionReport struct {
+	Source *DocumentDistribution
+	Target *DocumentDistribution
+	// Share of documents of a kind that differs by more than the threshold between the two buckets
+	Skews []string `json:",omitempty"`
+}
+
+func share(count, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(count) * 100 / float64(total)
+}
+
+// Shares are compared in percentage points, so that kinds of documents that are rare on both sides are not reported
+func NewDistributionReport(source, target *DocumentDistribution, thresholdPercent float64) *DistributionReport {
+	report := &DistributionReport{Source: source, Target: target}
+	compare := func(kind string, sourceCount, targetCount int64) {
+		sourceShare, targetShare := share(sourceCount, source.Documents), share(targetCount, target.Documents)
+		if sourceShare-targetShare > thresholdPercent || targetShare-sourceShare > thresholdPercent {
+			report.Skews = append(report.Skews, fmt.Sprintf("%v are %.1f%% of source documents and %.1f%% of target documents",
+				kind, sourceShare, targetShare))
+		}
+	}
+
+	for i, label := range DocumentSizeLabels() {
+		if i < len(source.Sizes) && i < len(target.Sizes) {
+			compare(fmt.Sprintf("documents of %v", label), source.Sizes[i], target.Sizes[i])
+		}
+	}
+	compare("JSON documents", source.JSON, target.JSON)
+	compare("binary documents", source.Binary, target.Binary)
+	compare("compressed documents", source.Compressed, target.Compressed)
+	compare("documents with xattrs", source.WithXattrs, target.WithXattrs)
+	return report
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDistributionReport(t *testing.T) {
+	fmt.Println("============== Test case start: TestDistributionReport =================")
+	assert := assert.New(t)
+
+	assert.Equal([]string{"0B-256B", "256B-1KiB", "1KiB-4KiB", "4KiB-16KiB", "16KiB-64KiB", "64KiB-256KiB", "256KiB-1MiB", ">=1MiB"}, DocumentSizeLabels())
+
+	source := NewDistributionRecorder()
+	target := NewDistributionRecorder()
+	for i := 0; i < 10; i++ {
+		source.Record(100, true, false, false)
+		target.Record(100, true, false, i < 5)
+	}
+	source.Record(2*1024*1024, false, false, false)
+	target.Record(1024, true, false, false)
+	target.RecordTombstone()
+
+	sourceDist := source.Snapshot()
+	assert.Equal(int64(11), sourceDist.Documents)
+	assert.Equal(int64(10), sourceDist.Sizes[0])
+	assert.Equal(int64(1), sourceDist.Sizes[len(DocumentSizeBounds)])
+	assert.Equal(int64(2*1024*1024), sourceDist.MaxSize)
+	assert.Equal(int64(1), sourceDist.Binary)
+	targetDist := target.Snapshot()
+	assert.Equal(int64(1), targetDist.Tombstones)
+	assert.Equal(int64(1), targetDist.Sizes[2])
+
+	// Half of the target documents have xattrs while none of the source do. The other shares are within 10 points
+	report := NewDistributionReport(sourceDist, targetDist, 10)
+	assert.Len(report.Skews, 1)
+	assert.Contains(report.Skews[0], "xattrs")
+
+	report = NewDistributionReport(sourceDist, targetDist, 5)
+	assert.Len(report.Skews, 5)
+	fmt.Println("============== Test case end: TestDistributionReport =================")
+}
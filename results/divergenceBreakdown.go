@@ -0,0 +1,138 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"xdcrDiffer/base"
+)
+
+// What kinds of divergence a run found, for the summary to show more than a total. The counts are of the deciding
+// phase, as of RunVerdict.DecidingPhase
+type DivergenceBreakdown struct {
+	Phase        string
+	BodyMismatch int
+	// Bodies are equal, but the metadata or xattrs are not
+	MetadataOnly      int
+	TTLOnly           int
+	MissingFromTarget int
+	MissingFromSource int
+	// Documents that could be neither confirmed nor ruled out to differ, i.e. locked, possibly purged or in error
+	Unverifiable int
+	// Taken out of the output by suppressions
+	Suppressed int
+	// Expected by the configuration of the replication, or logged by it as conflicts
+	Explained int
+	// Mismatches of the mutation differ whose kind the file differ did not record
+	Unclassified int
+}
+
+type breakdownKey struct {
+	colId uint32
+	key   string
+}
+
+// Breaks down the output matched by the patterns of each phase. The mutation differ reports every mismatch alike,
+// so its mismatches are told apart by what the file differ found of the same documents
+func NewDivergenceBreakdown(patterns map[string]string) (*DivergenceBreakdown, error) {
+	breakdown := &DivergenceBreakdown{}
+	subcategories := make(map[breakdownKey]string)
+	for _, phase := range []string{PhaseFileDiff, PhaseMutationDiff} {
+		pattern, ok := patterns[phase]
+		if !ok {
+			continue
+		}
+		if fileNames, _ := filepath.Glob(pattern); len(fileNames) == 0 {
+			continue
+		}
+		counts := &DivergenceBreakdown{Phase: phase}
+		_, err := scanPhase(phase, pattern, func(entry *Entry) {
+			subcategory := entry.Subcategory
+			if phase == PhaseFileDiff && entry.Category == "Mismatch" {
+				subcategories[breakdownKey{entry.ColId, entry.Key}] = subcategory
+			} else if phase == PhaseMutationDiff {
+				subcategory = subcategories[breakdownKey{entry.ColId, entry.Key}]
+			}
+			counts.add(entry.Category, subcategory)
+		})
+		if err != nil {
+			return nil, err
+		}
+		breakdown = counts
+	}
+	return breakdown, nil
+}
+
+func (b *DivergenceBreakdown) add(category, subcategory string) {
+	switch category {
+	case "Mismatch":
+		switch subcategory {
+		case base.MismatchCategoryBodyDiffers:
+			b.BodyMismatch++
+		case base.MismatchCategoryXattrsDiffer, base.MismatchCategoryMetadataDiffers:
+			b.MetadataOnly++
+		case base.MismatchCategoryTTLDiffers:
+			b.TTLOnly++
+		default:
+			b.Unclassified++
+		}
+	case "MissingFromTarget", "DeletedFromTarget":
+		b.MissingFromTarget++
+	case "MissingFromSource", "DeletedFromSource":
+		b.MissingFromSource++
+	case base.LockedCategory, base.PurgedCategory:
+		b.Unverifiable++
+	case base.ExpectedByConfigurationCategory, base.KnownConflictCategory:
+		b.Explained++
+	}
+}
+
+// One line per kind of divergence, with its count and a bar scaled to the most common kind. Kinds that were not
+// found are left out but for those the operator would look for first
+func (b *DivergenceBreakdown) Table(width int) []string {
+	rows := []struct {
+		label  string
+		count  int
+		always bool
+	}{
+		{"body mismatch", b.BodyMismatch, true},
+		{"metadata only", b.MetadataOnly, true},
+		{"TTL only", b.TTLOnly, true},
+		{"missing target", b.MissingFromTarget, true},
+		{"missing source", b.MissingFromSource, true},
+		{"unverifiable", b.Unverifiable, true},
+		{"suppressed", b.Suppressed, true},
+		{"explained", b.Explained, false},
+		{"unclassified", b.Unclassified, false},
+	}
+	var max int
+	for _, row := range rows {
+		if row.count > max {
+			max = row.count
+		}
+	}
+	var lines []string
+	for _, row := range rows {
+		if row.count == 0 && !row.always {
+			continue
+		}
+		bar := 0
+		if max > 0 {
+			bar = row.count * width / max
+			if bar == 0 && row.count > 0 {
+				bar = 1
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%-15v %10v %v", row.label, row.count, strings.Repeat("#", bar)))
+	}
+	return lines
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDivergenceBreakdown(t *testing.T) {
+	fmt.Println("============== Test case start: TestDivergenceBreakdown =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "divergenceBreakdown")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	fileDiffPattern := filepath.Join(dir, "fileDiff", "diffDetails_*")
+	mutationDiffPattern := filepath.Join(dir, "mutationDiff", "mutationDiffDetails")
+	patterns := map[string]string{PhaseFileDiff: fileDiffPattern, PhaseMutationDiff: mutationDiffPattern}
+
+	breakdown, err := NewDivergenceBreakdown(patterns)
+	assert.Nil(err)
+	assert.Equal(&DivergenceBreakdown{}, breakdown)
+
+	assert.Nil(os.MkdirAll(filepath.Dir(fileDiffPattern), 0777))
+	assert.Nil(ioutil.WriteFile(filepath.Join(filepath.Dir(fileDiffPattern), "diffDetails_0"),
+		[]byte(`{"Mismatch":[[{"Key":"user_1","ColId":8},{"Key":"user_1","ColId":8}],[{"Key":"a","ColId":0},{"Key":"a","ColId":0}],`+
+			`[{"Key":"b","ColId":0},{"Key":"b","ColId":0}],[{"Key":"c","ColId":0},{"Key":"c","ColId":0}],`+
+			`[{"Key":"user_1","ColId":0},{"Key":"user_1","ColId":0}]],`+
+			`"MismatchCategories":{"TTLDiffers":{"8":["user_1"]},"BodyDiffers":{"0":["a","user_1"]},"BodyEqualXattrsDiffer":{"0":["b"]},`+
+			`"MetadataDiffers":{"0":["c"]}},`+
+			`"MissingFromSource":[{"Key":"d","ColId":0}],"MissingFromTarget":null,`+
+			`"ExpectedByConfiguration":[[{"Key":"e","ColId":0},{"Key":"e","ColId":0}]]}`), 0644))
+	breakdown, err = NewDivergenceBreakdown(patterns)
+	assert.Nil(err)
+	// user_1 of each collection keeps its own kind
+	assert.Equal(&DivergenceBreakdown{Phase: PhaseFileDiff, BodyMismatch: 2, MetadataOnly: 2, TTLOnly: 1, MissingFromSource: 1,
+		Explained: 1}, breakdown)
+
+	// Once the mutation differ is run, its mismatches take the kind the file differ found of the same document
+	assert.Nil(os.MkdirAll(filepath.Dir(mutationDiffPattern), 0777))
+	assert.Nil(ioutil.WriteFile(mutationDiffPattern, []byte(mutationDiffOutput), 0644))
+	breakdown, err = NewDivergenceBreakdown(patterns)
+	assert.Nil(err)
+	assert.Equal(&DivergenceBreakdown{Phase: PhaseMutationDiff, TTLOnly: 1, MissingFromTarget: 3}, breakdown)
+
+	breakdown.Suppressed = 6
+	assert.Equal([]string{
+		"body mismatch            0 ",
+		"metadata only            0 ",
+		"TTL only                 1 #",
+		"missing target           3 ###",
+		"missing source           0 ",
+		"unverifiable             0 ",
+		"suppressed               6 ######",
+	}, breakdown.Table(6))
+	fmt.Println("============== Test case end: TestDivergenceBreakdown =================")
+}
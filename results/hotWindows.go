@@ -0,0 +1,105 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A span of mutation time in which divergent documents were last changed
+type HotWindow struct {
+	Start       time.Time
+	End         time.Time
+	Divergences int
+	// Of all divergences with a known mutation time
+	SharePercent float64
+}
+
+func (w *HotWindow) String() string {
+	return fmt.Sprintf("%v to %v: %v divergences (%.1f%%)", w.Start.Format(time.RFC3339), w.End.Format(time.RFC3339),
+		w.Divergences, w.SharePercent)
+}
+
+type HotWindowReport struct {
+	WindowSize  time.Duration
+	Divergences int
+	// Windows holding at least the minimum share of divergences, with adjacent ones combined, the largest first
+	Windows []*HotWindow `json:",omitempty"`
+}
+
+// Buckets divergent documents by the time of their last mutation, taken from their CAS, which is a hybrid
+// logical clock in nanoseconds since the epoch. Safe for concurrent use
+type DivergenceTimeline struct {
+	windowSize time.Duration
+	mtx        sync.Mutex
+	// Start of a window, in multiples of windowSize since the epoch -> number of divergences
+	windows     map[int64]int
+	divergences int
+}
+
+func NewDivergenceTimeline(windowSize time.Duration) *DivergenceTimeline {
+	return &DivergenceTimeline{
+		windowSize: windowSize,
+		windows:    make(map[int64]int),
+	}
+}
+
+// A CAS of 0 carries no time, i.e. for documents whose metadata could not be read, and is ignored
+func (t *DivergenceTimeline) Record(cas uint64) {
+	if t == nil || cas == 0 {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.windows[int64(cas)/int64(t.windowSize)]++
+	t.divergences++
+}
+
+func (t *DivergenceTimeline) HotWindows(minSharePercent float64) *HotWindowReport {
+	if t == nil {
+		return nil
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	report := &HotWindowReport{WindowSize: t.windowSize, Divergences: t.divergences}
+	var hot []int64
+	for window, count := range t.windows {
+		if share(int64(count), int64(t.divergences)) >= minSharePercent {
+			hot = append(hot, window)
+		}
+	}
+	sort.Slice(hot, func(i, j int) bool {
+		return hot[i] < hot[j]
+	})
+
+	// An outage that spans several windows is reported as one
+	var current *HotWindow
+	var last int64
+	for _, window := range hot {
+		if current == nil || window != last+1 {
+			current = &HotWindow{Start: time.Unix(0, window*int64(t.windowSize))}
+			report.Windows = append(report.Windows, current)
+		}
+		current.End = time.Unix(0, (window+1)*int64(t.windowSize))
+		current.Divergences += t.windows[window]
+		last = window
+	}
+	for _, window := range report.Windows {
+		window.SharePercent = share(int64(window.Divergences), int64(t.divergences))
+	}
+	sort.SliceStable(report.Windows, func(i, j int) bool {
+		return report.Windows[i].Divergences > report.Windows[j].Divergences
+	})
+	return report
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotWindows(t *testing.T) {
+	fmt.Println("============== Test case start: TestHotWindows =================")
+	assert := assert.New(t)
+
+	outage := time.Date(2021, 5, 11, 17, 0, 0, 0, time.UTC)
+	timeline := NewDivergenceTimeline(5 * time.Minute)
+	// An outage of 8 minutes, and divergences scattered over the day before
+	for i := 0; i < 16; i++ {
+		timeline.Record(uint64(outage.Add(time.Duration(i) * 30 * time.Second).UnixNano()))
+	}
+	for i := 0; i < 4; i++ {
+		timeline.Record(uint64(outage.Add(-time.Duration(i+1) * 5 * time.Hour).UnixNano()))
+	}
+	timeline.Record(0)
+
+	report := timeline.HotWindows(10)
+	assert.Equal(20, report.Divergences)
+	assert.Len(report.Windows, 1)
+	assert.Equal(outage, report.Windows[0].Start.UTC())
+	assert.Equal(outage.Add(10*time.Minute), report.Windows[0].End.UTC())
+	assert.Equal(16, report.Windows[0].Divergences)
+	assert.Equal(float64(80), report.Windows[0].SharePercent)
+
+	// Every window holds at least 5% of the divergences
+	report = timeline.HotWindows(5)
+	assert.Len(report.Windows, 5)
+	assert.Equal(16, report.Windows[0].Divergences)
+
+	var nilTimeline *DivergenceTimeline
+	nilTimeline.Record(1)
+	assert.Nil(nilTimeline.HotWindows(10))
+	fmt.Println("============== Test case end: TestHotWindows =================")
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Keys of a source collection and the target collection it replicates to, by whether they were captured from
+// either bucket or both. Tombstones are keys as much as documents are, as that is how the file differ compares them
+type KeyOverlap struct {
+	SourceColId uint32
+	TargetColId uint32
+	// As of the manifests captured, set once the report is made
+	SourceCollection string `json:",omitempty"`
+	TargetCollection string `json:",omitempty"`
+	SourceOnly       int64
+	TargetOnly       int64
+	Both             int64
+	// Share of the keys found on either side that were found on both
+	OverlapPercent float64
+}
+
+func (o *KeyOverlap) String() string {
+	return fmt.Sprintf("%v source only, %v target only, %v on both (%.1f%% overlap)", o.SourceOnly, o.TargetOnly,
+		o.Both, o.OverlapPercent)
+}
+
+func (o *KeyOverlap) add(other *KeyOverlap) {
+	o.SourceOnly += other.SourceOnly
+	o.TargetOnly += other.TargetOnly
+	o.Both += other.Both
+	o.OverlapPercent = share(o.Both, o.SourceOnly+o.TargetOnly+o.Both)
+}
+
+type KeyOverlapReport struct {
+	// Of all the collections
+	Total *KeyOverlap
+	// By source then target collection ID
+	Collections []*KeyOverlap
+}
+
+// Sums the key counts of the files diffed. Safe for concurrent use
+type KeyOverlapRecorder struct {
+	mtx      sync.Mutex
+	overlaps map[[2]uint32]*KeyOverlap
+}
+
+func NewKeyOverlapRecorder() *KeyOverlapRecorder {
+	return &KeyOverlapRecorder{overlaps: make(map[[2]uint32]*KeyOverlap)}
+}
+
+func (r *KeyOverlapRecorder) Record(overlap *KeyOverlap) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	pair := [2]uint32{overlap.SourceColId, overlap.TargetColId}
+	if r.overlaps[pair] == nil {
+		r.overlaps[pair] = &KeyOverlap{SourceColId: overlap.SourceColId, TargetColId: overlap.TargetColId}
+	}
+	r.overlaps[pair].add(overlap)
+}
+
+// Collections are named by the names given, which may be nil. Nil if nothing was recorded
+func (r *KeyOverlapRecorder) Report(sourceNames, targetNames *CollectionNames) *KeyOverlapReport {
+	if r == nil {
+		return nil
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if len(r.overlaps) == 0 {
+		return nil
+	}
+
+	report := &KeyOverlapReport{Total: &KeyOverlap{}}
+	for _, overlap := range r.overlaps {
+		collection := *overlap
+		collection.SourceCollection = sourceNames.Name(overlap.SourceColId)
+		collection.TargetCollection = targetNames.Name(overlap.TargetColId)
+		report.Collections = append(report.Collections, &collection)
+		report.Total.add(overlap)
+	}
+	sort.Slice(report.Collections, func(i, j int) bool {
+		if report.Collections[i].SourceColId != report.Collections[j].SourceColId {
+			return report.Collections[i].SourceColId < report.Collections[j].SourceColId
+		}
+		return report.Collections[i].TargetColId < report.Collections[j].TargetColId
+	})
+	return report
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyOverlapReport(t *testing.T) {
+	fmt.Println("============== Test case start: TestKeyOverlapReport =================")
+	assert := assert.New(t)
+
+	recorder := NewKeyOverlapRecorder()
+	assert.Nil(recorder.Report(nil, nil))
+
+	// Counts of the same collections in different files are summed
+	recorder.Record(&KeyOverlap{SourceColId: 8, TargetColId: 9, SourceOnly: 1, Both: 40})
+	recorder.Record(&KeyOverlap{SourceColId: 8, TargetColId: 9, TargetOnly: 3, Both: 35})
+	recorder.Record(&KeyOverlap{SourceColId: 0, TargetColId: 0, SourceOnly: 20})
+
+	sourceNames := &CollectionNames{Names: map[uint32]string{0: "_default._default", 8: "inventory.hotels"}}
+	report := recorder.Report(sourceNames, nil)
+	assert.Len(report.Collections, 2)
+	assert.Equal("_default._default", report.Collections[0].SourceCollection)
+	assert.Equal(float64(0), report.Collections[0].OverlapPercent)
+	hotels := report.Collections[1]
+	assert.Equal("inventory.hotels", hotels.SourceCollection)
+	assert.Equal("", hotels.TargetCollection)
+	assert.Equal(int64(75), hotels.Both)
+	assert.Equal(float64(75)*100/79, hotels.OverlapPercent)
+
+	assert.Equal(int64(21), report.Total.SourceOnly)
+	assert.Equal(int64(3), report.Total.TargetOnly)
+	assert.Equal(float64(75)*100/99, report.Total.OverlapPercent)
+	assert.Equal("21 source only, 3 target only, 75 on both (75.8% overlap)", report.Total.String())
+	fmt.Println("============== Test case end: TestKeyOverlapReport =================")
+}
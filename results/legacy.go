@@ -0,0 +1,144 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"xdcrDiffer/base"
+)
+
+// Output written by versions of the differ from before collections were supported differs from the current output:
+//  1. The mutation differ output is category -> key -> details, without the collection ID level
+//  2. The keys that could not be fetched are a JSON array of keys, rather than of fetch entries
+//
+// Such output is read as it is, its keys belonging to the default collection, so that historical runs can be
+// queried, merged and re-verified alongside current ones
+
+// Peeks at the first entry of the mutation differ output to tell whether it is in the legacy layout. The reader
+// returned reads the output from the start again
+func detectLegacyMutationDiff(reader io.Reader) (bool, io.Reader) {
+	var peeked bytes.Buffer
+	legacy := isLegacyMutationDiff(json.NewDecoder(io.TeeReader(reader, &peeked)))
+	return legacy, io.MultiReader(&peeked, reader)
+}
+
+// Anything that cannot be told apart is taken to be in the current layout, so that errors are reported by its scan
+func isLegacyMutationDiff(decoder *json.Decoder) bool {
+	if expectDelim(decoder, '{') != nil {
+		return false
+	}
+	for decoder.More() {
+		if _, err := stringToken(decoder); err != nil {
+			return false
+		}
+		if expectDelim(decoder, '{') != nil {
+			return false
+		}
+		if !decoder.More() {
+			// Nothing in the category to tell by
+			if expectDelim(decoder, '}') != nil {
+				return false
+			}
+			continue
+		}
+
+		name, err := stringToken(decoder)
+		if err != nil {
+			return false
+		}
+		if _, err = strconv.ParseUint(name, 10, 32); err != nil {
+			// A key rather than a collection ID
+			return true
+		}
+		token, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+		if token != json.Delim('{') {
+			// The details of a legacy mismatch, which are an array
+			return true
+		}
+		if !decoder.More() {
+			return false
+		}
+		if _, err = stringToken(decoder); err != nil {
+			return false
+		}
+		// Under a collection ID, each key has its details, which are an object or an array, whereas the fields of
+		// the details of a legacy key hold plain values
+		token, err = decoder.Token()
+		if err != nil {
+			return false
+		}
+		_, isDelim := token.(json.Delim)
+		return !isDelim
+	}
+	return false
+}
+
+// Queries the legacy mutation differ output, a JSON object of category -> key -> details
+func scanLegacyMutationDiff(reader io.Reader, collect func(*Entry)) error {
+	decoder := json.NewDecoder(reader)
+
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+	for decoder.More() {
+		category, err := stringToken(decoder)
+		if err != nil {
+			return err
+		}
+		if err = expectDelim(decoder, '{'); err != nil {
+			return err
+		}
+		for decoder.More() {
+			key, err := stringToken(decoder)
+			if err != nil {
+				return err
+			}
+			var details json.RawMessage
+			if err = decoder.Decode(&details); err != nil {
+				return err
+			}
+			collect(&Entry{Category: category, Key: key, KeyEncoding: base.KeyEncodingOf(key), Details: details})
+		}
+		if err = expectDelim(decoder, '}'); err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, '}')
+}
+
+// Reads the keys of a base.DiffErrorKeysFileName file, be it an array of fetch entries or, as written by older
+// versions, an array of keys
+func ParseKeysWithError(data []byte) ([]string, error) {
+	var rawEntries []json.RawMessage
+	if err := json.Unmarshal(data, &rawEntries); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(rawEntries))
+	for _, rawEntry := range rawEntries {
+		var key string
+		if err := json.Unmarshal(rawEntry, &key); err == nil {
+			keys = append(keys, key)
+			continue
+		}
+		var entry struct{ Key string }
+		if err := json.Unmarshal(rawEntry, &entry); err != nil {
+			return nil, fmt.Errorf("Invalid entry %s: %v", rawEntry, err)
+		}
+		keys = append(keys, entry.Key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryLegacyMutationDiff(t *testing.T) {
+	fmt.Println("============== Test case start: TestQueryLegacyMutationDiff =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "results")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "mutationDiffDetails")
+	// Numeric keys are told apart from collection IDs by their details
+	output := `{"Mismatch":{},"MissingFromSource":{"123":{"Value":null,"Cas":1}},` +
+		`"MissingFromTarget":{"user_1":{"Value":"e30=","Cas":2}}}`
+	assert.Nil(ioutil.WriteFile(fileName, []byte(output), 0644))
+
+	query, err := NewQuery("", "", "", 0, 0)
+	assert.Nil(err)
+	page, err := Run(PhaseMutationDiff, fileName, query)
+	assert.Nil(err)
+	assert.Equal(2, page.Total)
+	assert.Equal("MissingFromSource", page.Entries[0].Category)
+	assert.Equal("123", page.Entries[0].Key)
+	assert.Equal(uint32(0), page.Entries[0].ColId)
+	assert.Equal(`{"Value":"e30=","Cas":2}`, string(page.Entries[1].Details))
+
+	output = `{"Mismatch":{"user_2":[{"Cas":3},{"Cas":4}]}}`
+	assert.Nil(ioutil.WriteFile(fileName, []byte(output), 0644))
+	page, err = Run(PhaseMutationDiff, fileName, query)
+	assert.Nil(err)
+	assert.Equal(1, page.Total)
+	assert.Equal("user_2", page.Entries[0].Key)
+
+	// The current layout is still read as such, and a truncated file still fails
+	assert.Nil(ioutil.WriteFile(fileName, []byte(mutationDiffOutput), 0644))
+	page, err = Run(PhaseMutationDiff, fileName, query)
+	assert.Nil(err)
+	assert.Equal(4, page.Total)
+	assert.Nil(ioutil.WriteFile(fileName, []byte(mutationDiffOutput[:40]), 0644))
+	_, err = Run(PhaseMutationDiff, fileName, query)
+	assert.NotNil(err)
+	fmt.Println("============== Test case end: TestQueryLegacyMutationDiff =================")
+}
+
+func TestParseKeysWithError(t *testing.T) {
+	fmt.Println("============== Test case start: TestParseKeysWithError =================")
+	assert := assert.New(t)
+
+	keys, err := ParseKeysWithError([]byte(`[{"SrcColId":8,"TgtColIds":[9],"Key":"a"},{"SrcColId":0,"TgtColIds":[0],"Key":"b"}]`))
+	assert.Nil(err)
+	assert.Equal([]string{"a", "b"}, keys)
+
+	keys, err = ParseKeysWithError([]byte(`["c","d"]`))
+	assert.Nil(err)
+	assert.Equal([]string{"c", "d"}, keys)
+
+	_, err = ParseKeysWithError([]byte(`[1]`))
+	assert.NotNil(err)
+	fmt.Println("============== Test case end: TestParseKeysWithError =================")
+}
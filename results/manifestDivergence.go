@@ -0,0 +1,109 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Reasons why a collection of the source does not line up with the target collection it replicates to
+const (
+	DivergenceMissingOnTarget = "MissingOnTarget"
+	DivergenceMaxTTLDiffers   = "MaxTTLDiffers"
+	DivergenceHistoryDiffers  = "HistoryDiffers"
+)
+
+// The manifest of a bucket as returned by the scopes REST endpoint
+type RawManifest struct {
+	Uid    string `json:"uid"`
+	Scopes []struct {
+		Name        string `json:"name"`
+		Collections []struct {
+			Name    string `json:"name"`
+			MaxTTL  uint32 `json:"maxTTL"`
+			History bool   `json:"history"`
+		} `json:"collections"`
+	} `json:"scopes"`
+}
+
+// Settings of a collection that affect whether its documents compare equal across clusters
+type CollectionSettings struct {
+	MaxTTL  uint32
+	History bool
+}
+
+// scope.collection -> settings
+type ManifestSettings map[string]*CollectionSettings
+
+func (m *RawManifest) Settings() ManifestSettings {
+	settings := make(ManifestSettings)
+	for _, scope := range m.Scopes {
+		for _, collection := range scope.Collections {
+			settings[scope.Name+"."+collection.Name] = &CollectionSettings{MaxTTL: collection.MaxTTL, History: collection.History}
+		}
+	}
+	return settings
+}
+
+type ManifestDivergence struct {
+	Source string
+	Target string
+	Reason string
+	// Unset if the collection does not exist on that side
+	SourceSettings *CollectionSettings `json:",omitempty"`
+	TargetSettings *CollectionSettings `json:",omitempty"`
+}
+
+func (d *ManifestDivergence) String() string {
+	switch d.Reason {
+	case DivergenceMissingOnTarget:
+		return fmt.Sprintf("%v replicates to %v, which does not exist on the target", d.Source, d.Target)
+	case DivergenceMaxTTLDiffers:
+		return fmt.Sprintf("%v has maxTTL %v but %v has maxTTL %v", d.Source, d.SourceSettings.MaxTTL, d.Target, d.TargetSettings.MaxTTL)
+	default:
+		return fmt.Sprintf("%v has history %v but %v has history %v", d.Source, d.SourceSettings.History, d.Target, d.TargetSettings.History)
+	}
+}
+
+// Compares each source collection with the target collections it replicates to, as given by mapping
+// Source collections that are not in the mapping are not replicated, and so are not compared
+func CompareManifests(mapping map[string][]string, source, target ManifestSettings) []*ManifestDivergence {
+	var sourceNames []string
+	for sourceName := range mapping {
+		sourceNames = append(sourceNames, sourceName)
+	}
+	sort.Strings(sourceNames)
+
+	divergences := []*ManifestDivergence{}
+	for _, sourceName := range sourceNames {
+		sourceSettings := source[sourceName]
+		for _, targetName := range mapping[sourceName] {
+			targetSettings, exists := target[targetName]
+			if !exists {
+				divergences = append(divergences, &ManifestDivergence{Source: sourceName, Target: targetName,
+					Reason: DivergenceMissingOnTarget, SourceSettings: sourceSettings})
+				continue
+			}
+			if sourceSettings == nil {
+				continue
+			}
+			if sourceSettings.MaxTTL != targetSettings.MaxTTL {
+				divergences = append(divergences, &ManifestDivergence{Source: sourceName, Target: targetName,
+					Reason: DivergenceMaxTTLDiffers, SourceSettings: sourceSettings, TargetSettings: targetSettings})
+			}
+			if sourceSettings.History != targetSettings.History {
+				divergences = append(divergences, &ManifestDivergence{Source: sourceName, Target: targetName,
+					Reason: DivergenceHistoryDiffers, SourceSettings: sourceSettings, TargetSettings: targetSettings})
+			}
+		}
+	}
+	return divergences
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareManifests(t *testing.T) {
+	fmt.Println("============== Test case start: TestCompareManifests =================")
+	assert := assert.New(t)
+
+	var source, target RawManifest
+	assert.Nil(json.Unmarshal([]byte(`{"uid":"3","scopes":[{"name":"S1","collections":[`+
+		`{"name":"col1","maxTTL":0},{"name":"col2","maxTTL":3600,"history":true},{"name":"col3"}]}]}`), &source))
+	assert.Nil(json.Unmarshal([]byte(`{"uid":"5","scopes":[{"name":"S1","collections":[`+
+		`{"name":"col1","maxTTL":0},{"name":"col2","maxTTL":60}]}]}`), &target))
+
+	mapping := map[string][]string{
+		"S1.col1": {"S1.col1"},
+		"S1.col2": {"S1.col2"},
+		"S1.col3": {"S1.col3"},
+	}
+	divergences := CompareManifests(mapping, source.Settings(), target.Settings())
+	assert.Len(divergences, 3)
+	assert.Equal(DivergenceMaxTTLDiffers, divergences[0].Reason)
+	assert.Equal(DivergenceHistoryDiffers, divergences[1].Reason)
+	assert.Equal(DivergenceMissingOnTarget, divergences[2].Reason)
+	assert.Equal("S1.col3", divergences[2].Target)
+	fmt.Println("============== Test case end: TestCompareManifests =================")
+}
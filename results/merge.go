@@ -0,0 +1,281 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"xdcrDiffer/base"
+)
+
+// Where the output of a phase is found, relative to the directory a run was started in
+type PhaseLayout struct {
+	Phase string
+	Dir   string
+	// Glob of the output files within Dir
+	FilePattern string
+	// Name of the merged output file within Dir
+	MergedFileName string
+}
+
+type MergeSummary struct {
+	Runs int
+	// Phase -> category -> number of entries once merged
+	Counts map[string]map[string]int
+	// Entries found by more than one run, of which only the one of the last run is kept
+	Duplicates int
+	// Collection IDs that runs resolved to different names, i.e. as collections were recreated between runs
+	CollectionConflicts []string `json:",omitempty"`
+}
+
+type entryKey struct {
+	category string
+	colId    uint32
+	key      string
+}
+
+// Combines the output of runs over different vbuckets, or of repeated runs, into the output of a single run
+// Runs are given in order, so that where they disagree about a document, the later run is taken to be more recent
+func Merge(outputDir string, runDirs []string, layouts []*PhaseLayout) (*MergeSummary, error) {
+	// The merged output replaces whatever was in the output directory before
+	for _, runDir := range runDirs {
+		if filepath.Clean(runDir) == filepath.Clean(outputDir) {
+			return nil, fmt.Errorf("Output directory %v cannot be one of the runs being merged", outputDir)
+		}
+	}
+	summary := &MergeSummary{Runs: len(runDirs), Counts: make(map[string]map[string]int)}
+
+	metadata, err := mergeRunMetadata(runDirs, layouts, summary)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, layout := range layouts {
+		entries := make(map[entryKey]*Entry)
+		var order []entryKey
+		collect := func(entry *Entry) {
+			key := entryKey{entry.Category, entry.ColId, entry.Key}
+			if _, exists := entries[key]; exists {
+				summary.Duplicates++
+			} else {
+				order = append(order, key)
+			}
+			entries[key] = entry
+		}
+
+		var found bool
+		for _, runDir := range runDirs {
+			fileNames, err := filepath.Glob(filepath.Join(runDir, layout.Dir, layout.FilePattern))
+			if err != nil {
+				return nil, err
+			}
+			sort.Strings(fileNames)
+			for _, fileName := range fileNames {
+				found = true
+				if err = scanFile(fileName, scanFunc(layout.Phase), collect); err != nil {
+					return nil, fmt.Errorf("Unable to read %v: %v", fileName, err)
+				}
+			}
+		}
+		if !found {
+			// i.e. the mutation differ is not run in fast mode
+			continue
+		}
+
+		counts := make(map[string]int)
+		merged := make([]*Entry, 0, len(order))
+		for _, key := range order {
+			merged = append(merged, entries[key])
+			counts[key.category]++
+		}
+		summary.Counts[layout.Phase] = counts
+
+		dir := filepath.Join(outputDir, layout.Dir)
+		if err = os.RemoveAll(dir); err != nil {
+			return nil, err
+		}
+		if err = os.MkdirAll(dir, 0777); err != nil {
+			return nil, err
+		}
+		if err = writeMerged(layout.Phase, filepath.Join(dir, layout.MergedFileName), merged); err != nil {
+			return nil, err
+		}
+		if metadata != nil {
+			if err = WriteRunMetadata(dir, metadata); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return summary, nil
+}
+
+func scanFunc(phase string) func(io.Reader, func(*Entry)) error {
+	if phase == PhaseMutationDiff {
+		return scanMutationDiff
+	}
+	return scanFileDiff
+}
+
+// Writes the entries in the same format as the phase itself does, so that the merged output can be queried
+func writeMerged(phase, fileName string, entries []*Entry) error {
+	var output interface{}
+	if phase == PhaseMutationDiff {
+		// category -> collection ID -> key -> details
+		details := make(map[string]map[string]map[string]json.RawMessage)
+		for _, entry := range entries {
+			colIdStr := strconv.FormatUint(uint64(entry.ColId), 10)
+			if details[entry.Category] == nil {
+				details[entry.Category] = make(map[string]map[string]json.RawMessage)
+			}
+			if details[entry.Category][colIdStr] == nil {
+				details[entry.Category][colIdStr] = make(map[string]json.RawMessage)
+			}
+			details[entry.Category][colIdStr][entry.Key] = entry.Details
+		}
+		output = details
+	} else {
+		fileDiff := &fileDiffOutput{MismatchCategories: make(map[string]*mismatchCategoryKeys)}
+		for _, entry := range entries {
+			switch entry.Category {
+			case "MissingFromSource":
+				fileDiff.MissingFromSource = append(fileDiff.MissingFromSource, entry.Details)
+			case "MissingFromTarget":
+				fileDiff.MissingFromTarget = append(fileDiff.MissingFromTarget, entry.Details)
+			case base.ExpectedByConfigurationCategory:
+				fileDiff.ExpectedByConfiguration = append(fileDiff.ExpectedByConfiguration, entry.Details)
+			default:
+				fileDiff.Mismatch = append(fileDiff.Mismatch, entry.Details)
+				if entry.Subcategory != "" {
+					if fileDiff.MismatchCategories[entry.Subcategory] == nil {
+						fileDiff.MismatchCategories[entry.Subcategory] = &mismatchCategoryKeys{}
+					}
+					fileDiff.MismatchCategories[entry.Subcategory].add(entry.ColId, entry.Key)
+				}
+			}
+		}
+		output = fileDiff
+	}
+
+	outputBytes, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, outputBytes, 0644)
+}
+
+// Bucket names have to match. Collection names are combined, and later runs take precedence
+func mergeRunMetadata(runDirs []string, layouts []*PhaseLayout, summary *MergeSummary) (*RunMetadata, error) {
+	var merged *RunMetadata
+	divergences := make(map[string]bool)
+	conflicts := make(map[string]bool)
+
+	for _, runDir := range runDirs {
+		var metadata *RunMetadata
+		for _, layout := range layouts {
+			var err error
+			metadata, err = ReadRunMetadata(filepath.Join(runDir, layout.Dir))
+			if err != nil {
+				return nil, fmt.Errorf("Unable to read run metadata of %v: %v", runDir, err)
+			}
+			if metadata != nil {
+				break
+			}
+		}
+		if metadata == nil {
+			continue
+		}
+
+		if merged == nil {
+			merged = &RunMetadata{
+				SourceLabel:      metadata.SourceLabel,
+				TargetLabel:      metadata.TargetLabel,
+				SourceBucketName: metadata.SourceBucketName,
+				TargetBucketName: metadata.TargetBucketName,
+			}
+		} else if merged.SourceBucketName != metadata.SourceBucketName || merged.TargetBucketName != metadata.TargetBucketName {
+			return nil, fmt.Errorf("%v compared %v with %v, while earlier runs compared %v with %v", runDir,
+				metadata.SourceBucketName, metadata.TargetBucketName, merged.SourceBucketName, merged.TargetBucketName)
+		}
+
+		merged.SourceCollections = mergeCollectionNames(merged.SourceCollections, metadata.SourceCollections, "source", conflicts)
+		merged.TargetCollections = mergeCollectionNames(merged.TargetCollections, metadata.TargetCollections, "target", conflicts)
+		for _, divergence := range metadata.ManifestDivergences {
+			if !divergences[divergence.String()] {
+				divergences[divergence.String()] = true
+				merged.ManifestDivergences = append(merged.ManifestDivergences, divergence)
+			}
+		}
+		// Measurements are of a point in time, so those of the latest run are kept
+		if metadata.ClockSkew != nil {
+			merged.ClockSkew = metadata.ClockSkew
+		}
+		if metadata.CanaryLatency != nil {
+			merged.CanaryLatency = metadata.CanaryLatency
+		}
+		if metadata.Distribution != nil {
+			merged.Distribution = metadata.Distribution
+		}
+		if metadata.Build != nil {
+			merged.Build = metadata.Build
+		}
+		if metadata.KeyOverlap != nil {
+			merged.KeyOverlap = metadata.KeyOverlap
+		}
+		if metadata.HotWindows != nil {
+			merged.HotWindows = metadata.HotWindows
+		}
+		if metadata.Replication != nil {
+			merged.Replication = metadata.Replication
+		}
+		for fault, count := range metadata.InjectedFaults {
+			if merged.InjectedFaults == nil {
+				merged.InjectedFaults = make(map[string]int64)
+			}
+			merged.InjectedFaults[fault] += count
+		}
+		// The merged output took the resources of all the runs
+		merged.ResourceUsage = append(merged.ResourceUsage, metadata.ResourceUsage...)
+		// The merged output is as incomplete as any of the runs it is merged from
+		if metadata.Aborted != "" {
+			merged.Aborted = metadata.Aborted
+		}
+	}
+
+	for conflict := range conflicts {
+		summary.CollectionConflicts = append(summary.CollectionConflicts, conflict)
+	}
+	sort.Strings(summary.CollectionConflicts)
+	return merged, nil
+}
+
+func mergeCollectionNames(merged, names *CollectionNames, side string, conflicts map[string]bool) *CollectionNames {
+	if names == nil {
+		return merged
+	}
+	if merged == nil {
+		merged = &CollectionNames{Names: make(map[uint32]string)}
+	}
+	if names.ManifestUid > merged.ManifestUid {
+		merged.ManifestUid = names.ManifestUid
+	}
+	for colId, name := range names.Names {
+		if existing, exists := merged.Names[colId]; exists && existing != name {
+			conflicts[fmt.Sprintf("%v collection ID %v was %v and later %v", side, colId, existing, name)] = true
+		}
+		merged.Names[colId] = name
+	}
+	return merged
+}
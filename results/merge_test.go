@@ -0,0 +1,67 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+	fmt.Println("============== Test case start: TestMerge =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "merge")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	layouts := []*PhaseLayout{{Phase: PhaseMutationDiff, Dir: "mutationDiff", FilePattern: "mutationDiffDetails", MergedFileName: "mutationDiffDetails"}}
+	runOutputs := []string{
+		mutationDiffOutput,
+		// The second run saw user_3 again, and found another key of its own
+		`{"Mismatch":{},"MissingFromTarget":{"8":{"user_3":{"Cas":6},"user_4":{"Cas":7}}}}`,
+	}
+	var runDirs []string
+	for i, output := range runOutputs {
+		runDir := filepath.Join(dir, fmt.Sprintf("run%v", i))
+		assert.Nil(os.MkdirAll(filepath.Join(runDir, "mutationDiff"), 0777))
+		assert.Nil(ioutil.WriteFile(filepath.Join(runDir, "mutationDiff", "mutationDiffDetails"), []byte(output), 0644))
+		assert.Nil(WriteRunMetadata(filepath.Join(runDir, "mutationDiff"), &RunMetadata{SourceBucketName: "B1", TargetBucketName: "B2",
+			SourceCollections: &CollectionNames{ManifestUid: uint64(i), Names: map[uint32]string{8: fmt.Sprintf("S1.col%v", i)}}}))
+		runDirs = append(runDirs, runDir)
+	}
+
+	outputDir := filepath.Join(dir, "merged")
+	summary, err := Merge(outputDir, runDirs, layouts)
+	assert.Nil(err)
+	assert.Equal(1, summary.Duplicates)
+	assert.Equal(1, summary.Counts[PhaseMutationDiff]["Mismatch"])
+	assert.Equal(4, summary.Counts[PhaseMutationDiff]["MissingFromTarget"])
+	assert.Len(summary.CollectionConflicts, 1)
+
+	query, err := NewQuery("MissingFromTarget", "8", "user_3", 0, 0)
+	assert.Nil(err)
+	page, err := Run(PhaseMutationDiff, filepath.Join(outputDir, "mutationDiff", "mutationDiffDetails"), query)
+	assert.Nil(err)
+	assert.Equal(1, page.Total)
+	assert.Equal(`{"Cas":6}`, string(page.Entries[0].Details))
+	assert.Equal("S1.col1", page.Entries[0].Collection)
+
+	// Runs of different buckets cannot be merged
+	assert.Nil(WriteRunMetadata(filepath.Join(runDirs[1], "mutationDiff"), &RunMetadata{SourceBucketName: "B3", TargetBucketName: "B2"}))
+	_, err = Merge(outputDir, runDirs, layouts)
+	assert.NotNil(err)
+	fmt.Println("============== Test case end: TestMerge =================")
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The state of the replication being verified, as reported by the source cluster when the run started. A paused
+// or broken replication leaves the target behind, so the differences found are expected rather than lost data
+type ReplicationState struct {
+	ReplicationId string
+	// As given by XDCR, i.e. running, paused or notRunning
+	Status string
+	Paused bool `json:",omitempty"`
+	// When the replication was paused, as logged by the cluster. Nil if the log no longer has it
+	PausedSince *time.Time `json:",omitempty"`
+	// Errors the replication reported, latest first, as given by XDCR
+	Errors []string `json:",omitempty"`
+	// When the state was looked up
+	Observed time.Time
+}
+
+// Whether the replication was neither paused nor reporting errors, in which case the differences are its own
+func (s *ReplicationState) Running() bool {
+	return s == nil || (!s.Paused && len(s.Errors) == 0 && s.Status != ReplicationStatusNotRunning)
+}
+
+// Statuses XDCR gives replications that are not running
+const (
+	ReplicationStatusPaused     = "paused"
+	ReplicationStatusNotRunning = "notRunning"
+)
+
+// i.e. "replication paused since 2021-01-02T15:04:05Z". Empty if the replication was running
+func (s *ReplicationState) String() string {
+	if s.Running() {
+		return ""
+	}
+	var states []string
+	if s.Paused {
+		if s.PausedSince != nil {
+			states = append(states, fmt.Sprintf("replication paused since %v", s.PausedSince.Format(time.RFC3339)))
+		} else {
+			states = append(states, fmt.Sprintf("replication paused since before %v", s.Observed.Format(time.RFC3339)))
+		}
+	} else if s.Status == ReplicationStatusNotRunning {
+		states = append(states, fmt.Sprintf("replication not running as of %v", s.Observed.Format(time.RFC3339)))
+	}
+	if len(s.Errors) > 0 {
+		states = append(states, fmt.Sprintf("replication reporting %v errors, the latest being: %v", len(s.Errors), s.Errors[0]))
+	}
+	return strings.Join(states, ", ")
+}
+
+// An entry of /pools/default/tasks. Those of type xdcr are replications
+type ReplicationTask struct {
+	Type           string `json:"type"`
+	Id             string `json:"id"`
+	Status         string `json:"status"`
+	PauseRequested bool   `json:"pauseRequested"`
+	// Strings, or objects with the time and message of each error, depending on the version of the cluster
+	Errors []interface{} `json:"errors"`
+}
+
+const ReplicationTaskType = "xdcr"
+
+// An entry of /logs, which has the time replications were paused
+type ClusterLogEntry struct {
+	// Milliseconds since the epoch
+	Tstamp int64  `json:"tstamp"`
+	Text   string `json:"text"`
+}
+
+// The state of the replication of the given ID among the tasks of the source cluster. Nil if there is no task of
+// the replication, i.e. it was deleted since it was looked up
+func NewReplicationState(replicationId, sourceBucketName, targetBucketName string, tasks []*ReplicationTask,
+	logs []*ClusterLogEntry, observed time.Time) *ReplicationState {
+	var task *ReplicationTask
+	for _, candidate := range tasks {
+		if candidate.Type == ReplicationTaskType && candidate.Id == replicationId {
+			task = candidate
+			break
+		}
+	}
+	if task == nil {
+		return nil
+	}
+
+	state := &ReplicationState{
+		ReplicationId: replicationId,
+		Status:        task.Status,
+		Paused:        task.PauseRequested || task.Status == ReplicationStatusPaused,
+		Observed:      observed,
+	}
+	for _, taskError := range task.Errors {
+		state.Errors = append(state.Errors, replicationErrorString(taskError))
+	}
+	if state.Paused {
+		// i.e. Replication from bucket "a" to bucket "b" on cluster "c" paused.
+		source, target := fmt.Sprintf("%q", sourceBucketName), fmt.Sprintf("%q", targetBucketName)
+		for _, entry := range logs {
+			if !strings.Contains(entry.Text, "paused") || !strings.Contains(entry.Text, source) || !strings.Contains(entry.Text, target) {
+				continue
+			}
+			pausedAt := time.Unix(0, entry.Tstamp*int64(time.Millisecond))
+			if state.PausedSince == nil || pausedAt.After(*state.PausedSince) {
+				state.PausedSince = &pausedAt
+			}
+		}
+	}
+	return state
+}
+
+func replicationErrorString(taskError interface{}) string {
+	switch e := taskError.(type) {
+	case string:
+		return e
+	case map[string]interface{}:
+		if msg, ok := e["errorMsg"]; ok {
+			if errTime, ok := e["time"]; ok {
+				return fmt.Sprintf("%v %v", errTime, msg)
+			}
+			return fmt.Sprintf("%v", msg)
+		}
+	}
+	return fmt.Sprintf("%v", taskError)
+}
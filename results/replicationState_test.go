@@ -0,0 +1,65 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicationState(t *testing.T) {
+	fmt.Println("============== Test case start: TestReplicationState =================")
+	assert := assert.New(t)
+
+	observed := time.Date(2021, 1, 2, 15, 0, 0, 0, time.UTC)
+	pausedAt := time.Date(2021, 1, 2, 12, 0, 0, 0, time.UTC)
+	tasks := []*ReplicationTask{
+		{Type: "rebalance", Status: "notRunning"},
+		{Type: ReplicationTaskType, Id: "uuid/a/b", Status: "running"},
+		{Type: ReplicationTaskType, Id: "uuid/c/d", Status: ReplicationStatusNotRunning, PauseRequested: true},
+		{Type: ReplicationTaskType, Id: "uuid/e/f", Status: "running", Errors: []interface{}{
+			"2021-01-02 14:00:00 Target bucket missing",
+			map[string]interface{}{"time": "2021-01-02T13:00:00Z", "errorMsg": "connection refused"},
+		}},
+	}
+	logs := []*ClusterLogEntry{
+		{Tstamp: pausedAt.Add(-time.Hour).UnixNano() / int64(time.Millisecond), Text: `Replication from bucket "c" to bucket "d" on cluster "remote" paused.`},
+		{Tstamp: pausedAt.UnixNano() / int64(time.Millisecond), Text: `Replication from bucket "c" to bucket "d" on cluster "remote" paused.`},
+		{Tstamp: observed.UnixNano() / int64(time.Millisecond), Text: `Replication from bucket "a" to bucket "b" on cluster "remote" paused.`},
+	}
+
+	state := NewReplicationState("uuid/a/b", "a", "b", tasks, logs, observed)
+	assert.True(state.Running())
+	assert.Equal("", state.String())
+
+	state = NewReplicationState("uuid/c/d", "c", "d", tasks, logs, observed)
+	assert.False(state.Running())
+	assert.True(pausedAt.Equal(*state.PausedSince))
+	assert.Equal("replication paused since 2021-01-02T12:00:00Z", state.String())
+
+	// The log no longer has when it was paused
+	state = NewReplicationState("uuid/c/d", "c", "d", tasks, nil, observed)
+	assert.Equal("replication paused since before 2021-01-02T15:00:00Z", state.String())
+
+	state = NewReplicationState("uuid/e/f", "e", "f", tasks, logs, observed)
+	assert.False(state.Running())
+	assert.Nil(state.PausedSince)
+	assert.Equal([]string{"2021-01-02 14:00:00 Target bucket missing", "2021-01-02T13:00:00Z connection refused"}, state.Errors)
+	assert.Equal("replication reporting 2 errors, the latest being: 2021-01-02 14:00:00 Target bucket missing", state.String())
+
+	assert.Nil(NewReplicationState("uuid/x/y", "x", "y", tasks, logs, observed))
+	var unknown *ReplicationState
+	assert.True(unknown.Running())
+	assert.Equal("", unknown.String())
+	fmt.Println("============== Test case end: TestReplicationState =================")
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"xdcrDiffer/base"
+	"xdcrDiffer/stats"
+)
+
+// Phase of a run that captures both clusters. It has no output of its own to query
+const PhaseCapture = "capture"
+
+// Resources a phase of a run used, for the capacity of later runs to be planned by
+type PhaseResourceUsage struct {
+	Phase    string
+	WallTime time.Duration
+	// Of the whole process while the phase ran, so it includes any phase that ran alongside. Zero if it could not
+	// be measured
+	CPUTime time.Duration
+	// Cluster -> bytes of keys, values and subdoc paths read from it
+	BytesRead map[string]int64
+	// How much the directories the phase writes to grew
+	BytesWritten int64
+	// Most memory the process held while the phase ran, as sampled
+	PeakMemory uint64
+}
+
+func (u *PhaseResourceUsage) String() string {
+	clusters := make([]string, 0, len(u.BytesRead))
+	for cluster := range u.BytesRead {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+	read := make([]string, len(clusters))
+	for i, cluster := range clusters {
+		read[i] = fmt.Sprintf("%v from %v", formatBytes(u.BytesRead[cluster]), cluster)
+	}
+	readString := "nothing"
+	if len(read) > 0 {
+		readString = strings.Join(read, ", ")
+	}
+	return fmt.Sprintf("%v: wall %v, cpu %v, read %v, wrote %v, peak memory %v", u.Phase, u.WallTime.Round(time.Millisecond),
+		u.CPUTime.Round(time.Millisecond), readString, formatBytes(u.BytesWritten), formatBytes(int64(u.PeakMemory)))
+}
+
+func formatBytes(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%v B", size)
+	}
+}
+
+// Measures the resources a phase uses, from when it is started until it is stopped
+type ResourceMeter struct {
+	usage     *PhaseResourceUsage
+	start     time.Time
+	startCPU  time.Duration
+	cpuErr    error
+	startRead map[string]int64
+	dirs      []string
+	startSize int64
+
+	peakLock sync.Mutex
+	finCh    chan bool
+	wg       sync.WaitGroup
+}
+
+// Starts metering a phase that reads from the given clusters, by the stats.ClusterBytesRead counters, and writes to
+// the given directories. Memory is sampled every interval
+func StartResourceMeter(phase string, clusters, dirs []string, interval time.Duration) *ResourceMeter {
+	m := &ResourceMeter{
+		usage:     &PhaseResourceUsage{Phase: phase, BytesRead: make(map[string]int64)},
+		start:     time.Now(),
+		startRead: make(map[string]int64),
+		dirs:      dirs,
+		finCh:     make(chan bool),
+	}
+	m.startCPU, m.cpuErr = base.ProcessCPUTime()
+	for _, cluster := range clusters {
+		m.startRead[cluster] = stats.Default.Counter(fmt.Sprintf(stats.ClusterBytesRead, cluster)).Value()
+	}
+	m.startSize = dirsSize(dirs)
+	m.sampleMemory()
+
+	m.wg.Add(1)
+	go m.run(interval)
+	return m
+}
+
+func (m *ResourceMeter) run(interval time.Duration) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.finCh:
+			return
+		case <-ticker.C:
+			m.sampleMemory()
+		}
+	}
+}
+
+// Memory obtained from the OS and not yet returned to it, which is close to what the process holds
+func (m *ResourceMeter) sampleMemory() {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	memory := memStats.Sys - memStats.HeapReleased
+	m.peakLock.Lock()
+	defer m.peakLock.Unlock()
+	if memory > m.usage.PeakMemory {
+		m.usage.PeakMemory = memory
+	}
+}
+
+// Stops metering and returns what the phase used. Not to be called more than once
+func (m *ResourceMeter) Stop() *PhaseResourceUsage {
+	close(m.finCh)
+	m.wg.Wait()
+	m.sampleMemory()
+
+	m.usage.WallTime = time.Since(m.start)
+	if cpu, err := base.ProcessCPUTime(); err == nil && m.cpuErr == nil {
+		m.usage.CPUTime = cpu - m.startCPU
+	}
+	for cluster, startRead := range m.startRead {
+		m.usage.BytesRead[cluster] = stats.Default.Counter(fmt.Sprintf(stats.ClusterBytesRead, cluster)).Value() - startRead
+	}
+	// Directories can also shrink, i.e. as capture files are replaced
+	if size := dirsSize(m.dirs); size > m.startSize {
+		m.usage.BytesWritten = size - m.startSize
+	}
+	return m.usage
+}
+
+// Total size of the files under the given directories. Those that do not exist count as empty
+func dirsSize(dirs []string) int64 {
+	var size int64
+	for _, dir := range dirs {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				size += info.Size()
+			}
+			return nil
+		})
+	}
+	return size
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"xdcrDiffer/stats"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceMeter(t *testing.T) {
+	fmt.Println("============== Test case start: TestResourceMeter =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "resourceUsage")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "existing"), make([]byte, 100), 0644))
+	bytesRead := stats.Default.Counter(fmt.Sprintf(stats.ClusterBytesRead, "meterSource"))
+	bytesRead.Add(10)
+
+	// Written to a directory that does not exist yet, and read from one cluster only
+	outputDir := filepath.Join(dir, "output")
+	meter := StartResourceMeter(PhaseCapture, []string{"meterSource", "meterTarget"}, []string{dir},
+		time.Millisecond)
+	assert.Nil(os.MkdirAll(outputDir, 0755))
+	assert.Nil(ioutil.WriteFile(filepath.Join(outputDir, "capture"), make([]byte, 2048), 0644))
+	bytesRead.Add(1024)
+	time.Sleep(5 * time.Millisecond)
+	usage := meter.Stop()
+
+	assert.Equal(PhaseCapture, usage.Phase)
+	assert.True(usage.WallTime >= 5*time.Millisecond)
+	assert.Equal(int64(1024), usage.BytesRead["meterSource"])
+	assert.Equal(int64(0), usage.BytesRead["meterTarget"])
+	assert.Equal(int64(2048), usage.BytesWritten)
+	assert.True(usage.PeakMemory > 0)
+	assert.True(strings.Contains(usage.String(), "read 1.0 KB from meterSource, 0 B from meterTarget, wrote 2.0 KB"))
+
+	// A directory that shrinks has nothing written to it
+	meter = StartResourceMeter(PhaseFileDiff, nil, []string{dir}, time.Second)
+	assert.Nil(os.RemoveAll(outputDir))
+	usage = meter.Stop()
+	assert.Equal(int64(0), usage.BytesWritten)
+	assert.True(strings.Contains(usage.String(), "read nothing, wrote 0 B"))
+	fmt.Println("============== Test case end: TestResourceMeter =================")
+}
@@ -0,0 +1,393 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"xdcrDiffer/base"
+)
+
+// Phases whose output can be queried
+const (
+	PhaseFileDiff     = "fileDiff"
+	PhaseMutationDiff = "mutationDiff"
+)
+
+// A single difference found by a completed run
+type Entry struct {
+	// i.e. Mismatch, MissingFromSource or MissingFromTarget
+	Category string
+	// For file differ mismatches, what part of the documents differ
+	Subcategory string `json:",omitempty"`
+	ColId       uint32
+	// scope.collection of ColId when the run started, if known
+	Collection string `json:",omitempty"`
+	// As written by the differ, i.e. encoded by base.EncodeKey when KeyEncoding is set
+	Key         string
+	KeyEncoding string `json:",omitempty"`
+	// The entry as written by the differ
+	Details json.RawMessage
+}
+
+// Selects the entries of a run to be returned. Empty fields select everything
+type Query struct {
+	// Matches either the category or the subcategory of an entry
+	Categories []string
+	ColIds     []uint32
+	KeyPrefix  string
+	// If set, only the entries of exactly this key, as written by the differ
+	Key    string
+	Offset int
+	// Maximum number of entries to return. All remaining entries if 0
+	Limit int
+}
+
+type Page struct {
+	// Number of entries that match the query, regardless of offset and limit
+	Total   int
+	Offset  int
+	Entries []*Entry
+	// Set when the replication was not running as the run started, i.e. "replication paused since T", in which case
+	// entries missing from the target in particular are to be attributed to it
+	Replication string `json:",omitempty"`
+}
+
+// Builds a query from comma separated categories and collection IDs
+func NewQuery(categories, colIds, keyPrefix string, offset, limit int) (*Query, error) {
+	query := &Query{
+		KeyPrefix: keyPrefix,
+		Offset:    offset,
+		Limit:     limit,
+	}
+	if offset < 0 || limit < 0 {
+		return nil, fmt.Errorf("offset %v and limit %v cannot be negative", offset, limit)
+	}
+	for _, category := range strings.Split(categories, ",") {
+		if category = strings.TrimSpace(category); category != "" {
+			query.Categories = append(query.Categories, category)
+		}
+	}
+	for _, colIdStr := range strings.Split(colIds, ",") {
+		if colIdStr = strings.TrimSpace(colIdStr); colIdStr == "" {
+			continue
+		}
+		colId, err := strconv.ParseUint(colIdStr, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid collection ID %v: %v", colIdStr, err)
+		}
+		query.ColIds = append(query.ColIds, uint32(colId))
+	}
+	return query, nil
+}
+
+func (q *Query) matches(entry *Entry) bool {
+	if len(q.Categories) > 0 {
+		var found bool
+		for _, category := range q.Categories {
+			if category == entry.Category || category == entry.Subcategory {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(q.ColIds) > 0 {
+		var found bool
+		for _, colId := range q.ColIds {
+			if colId == entry.ColId {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.Key != "" && entry.Key != q.Key {
+		return false
+	}
+	return strings.HasPrefix(entry.Key, q.KeyPrefix)
+}
+
+// Only the entries within the requested page are kept, so that the memory used does not depend on the size of the run
+func (q *Query) newCollector(metadata *RunMetadata) (*Page, func(entry *Entry)) {
+	page := &Page{Offset: q.Offset, Entries: []*Entry{}}
+	if metadata != nil {
+		page.Replication = metadata.Replication.String()
+	}
+	return page, func(entry *Entry) {
+		if !q.matches(entry) {
+			return
+		}
+		entry.Collection = metadata.collectionName(entry.Category, entry.ColId)
+		if page.Total >= q.Offset && (q.Limit <= 0 || len(page.Entries) < q.Limit) {
+			page.Entries = append(page.Entries, entry)
+		}
+		page.Total++
+	}
+}
+
+// Queries the output files of a phase matching the given glob, in the order of their names
+func Run(phase, pattern string, query *Query) (*Page, error) {
+	metadata, err := ReadRunMetadata(filepath.Dir(pattern))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read run metadata: %v", err)
+	}
+	page, collect := query.newCollector(metadata)
+	if phase == PhaseMutationDiff && query.Key != "" && HasChunkedDetails(filepath.Dir(pattern)) {
+		entries, err := LookupChunkedDetails(filepath.Dir(pattern), query.Key)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			collect(entry)
+		}
+		return page, nil
+	}
+	if _, err = scanPhase(phase, pattern, collect); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// Passes every entry of the output files of a phase to collect, returning the names of the files
+func scanPhase(phase, pattern string, collect func(*Entry)) ([]string, error) {
+	var scan func(io.Reader, func(*Entry)) error
+	switch phase {
+	case PhaseMutationDiff:
+		scan = scanMutationDiff
+	case PhaseFileDiff:
+		scan = scanFileDiff
+	default:
+		return nil, fmt.Errorf("Invalid phase %v. Accepted values are %v and %v", phase, PhaseFileDiff, PhaseMutationDiff)
+	}
+
+	fileNames, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(fileNames) == 0 {
+		return nil, fmt.Errorf("No %v output found at %v", phase, pattern)
+	}
+	sort.Strings(fileNames)
+
+	for _, fileName := range fileNames {
+		if err = scanFile(fileName, scan, collect); err != nil {
+			return nil, fmt.Errorf("Unable to read %v: %v", fileName, err)
+		}
+	}
+	return fileNames, nil
+}
+
+func scanFile(fileName string, scan func(io.Reader, func(*Entry)) error, collect func(*Entry)) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return scan(bufio.NewReader(file), collect)
+}
+
+// Queries the mutation differ output, a JSON object of category -> collection ID -> key -> details
+// The output is decoded one entry at a time, as it can be too large to be loaded at once
+func scanMutationDiff(reader io.Reader, collect func(*Entry)) error {
+	legacy, reader := detectLegacyMutationDiff(reader)
+	if legacy {
+		return scanLegacyMutationDiff(reader, collect)
+	}
+	decoder := json.NewDecoder(reader)
+
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+	for decoder.More() {
+		category, err := stringToken(decoder)
+		if err != nil {
+			return err
+		}
+		if err = expectDelim(decoder, '{'); err != nil {
+			return err
+		}
+		for decoder.More() {
+			colIdStr, err := stringToken(decoder)
+			if err != nil {
+				return err
+			}
+			colId, err := strconv.ParseUint(colIdStr, 10, 32)
+			if err != nil {
+				return fmt.Errorf("Invalid collection ID %v under %v: %v", colIdStr, category, err)
+			}
+			if err = expectDelim(decoder, '{'); err != nil {
+				return err
+			}
+			for decoder.More() {
+				key, err := stringToken(decoder)
+				if err != nil {
+					return err
+				}
+				var details json.RawMessage
+				if err = decoder.Decode(&details); err != nil {
+					return err
+				}
+				collect(&Entry{Category: category, ColId: uint32(colId), Key: key, KeyEncoding: base.KeyEncodingOf(key), Details: details})
+			}
+			if err = expectDelim(decoder, '}'); err != nil {
+				return err
+			}
+		}
+		if err = expectDelim(decoder, '}'); err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, '}')
+}
+
+// The fields of a file differ entry that are needed to query it
+type fileDiffEntry struct {
+	Key   string
+	ColId uint32
+}
+
+// The file differ writes one JSON object per pair of compared files, one after the other
+type fileDiffOutput struct {
+	Mismatch           []json.RawMessage
+	MismatchCategories map[string]*mismatchCategoryKeys
+	MissingFromSource  []json.RawMessage
+	MissingFromTarget  []json.RawMessage
+	// Pairs of documents that differ only as the replication is configured to make them differ
+	ExpectedByConfiguration []json.RawMessage `json:",omitempty"`
+}
+
+// The keys of the mismatches of one category, by source collection ID. Versions from before the keys were told apart
+// by collection list them alone
+type mismatchCategoryKeys struct {
+	byColId  map[uint32][]string
+	keysOnly []string
+}
+
+func (k *mismatchCategoryKeys) add(colId uint32, key string) {
+	if k.byColId == nil {
+		k.byColId = make(map[uint32][]string)
+	}
+	k.byColId[colId] = append(k.byColId[colId], key)
+}
+
+func (k *mismatchCategoryKeys) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k.byColId)
+}
+
+func (k *mismatchCategoryKeys) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &k.keysOnly); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, &k.byColId)
+}
+
+// Queries the file differ output. Each object is small, being the differences of a single bin of a vbucket
+func scanFileDiff(reader io.Reader, collect func(*Entry)) error {
+	decoder := json.NewDecoder(reader)
+
+	for decoder.More() {
+		var output fileDiffOutput
+		if err := decoder.Decode(&output); err != nil {
+			return err
+		}
+
+		subcategories := make(map[fileDiffEntry]string)
+		subcategoriesOfKeys := make(map[string]string)
+		for subcategory, keys := range output.MismatchCategories {
+			if keys == nil {
+				continue
+			}
+			for colId, colKeys := range keys.byColId {
+				for _, key := range colKeys {
+					subcategories[fileDiffEntry{Key: key, ColId: colId}] = subcategory
+				}
+			}
+			for _, key := range keys.keysOnly {
+				subcategoriesOfKeys[key] = subcategory
+			}
+		}
+
+		pairs := []struct {
+			category string
+			entries  []json.RawMessage
+		}{
+			{"Mismatch", output.Mismatch},
+			{base.ExpectedByConfigurationCategory, output.ExpectedByConfiguration},
+		}
+		for _, p := range pairs {
+			for _, pairBytes := range p.entries {
+				var pair []fileDiffEntry
+				if err := json.Unmarshal(pairBytes, &pair); err != nil {
+					return err
+				}
+				if len(pair) == 0 {
+					continue
+				}
+				subcategory, ok := subcategories[pair[0]]
+				if !ok {
+					subcategory = subcategoriesOfKeys[pair[0].Key]
+				}
+				collect(&Entry{Category: p.category, Subcategory: subcategory, ColId: pair[0].ColId, Key: pair[0].Key, KeyEncoding: base.KeyEncodingOf(pair[0].Key), Details: pairBytes})
+			}
+		}
+		missing := []struct {
+			category string
+			entries  []json.RawMessage
+		}{
+			{"MissingFromSource", output.MissingFromSource},
+			{"MissingFromTarget", output.MissingFromTarget},
+		}
+		for _, m := range missing {
+			for _, entryBytes := range m.entries {
+				var entry fileDiffEntry
+				if err := json.Unmarshal(entryBytes, &entry); err != nil {
+					return err
+				}
+				collect(&Entry{Category: m.category, ColId: entry.ColId, Key: entry.Key, KeyEncoding: base.KeyEncodingOf(entry.Key), Details: entryBytes})
+			}
+		}
+	}
+	return nil
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("Expected %v but found %v", delim, token)
+	}
+	return nil
+}
+
+func stringToken(decoder *json.Decoder) (string, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return "", err
+	}
+	str, ok := token.(string)
+	if !ok {
+		return "", fmt.Errorf("Expected a string but found %v", token)
+	}
+	return str, nil
+}
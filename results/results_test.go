@@ -0,0 +1,99 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const mutationDiffOutput = `{"Mismatch":{"8":{"user_1":[{"Cas":1},{"Cas":2}]}},"MissingFromSource":{},` +
+	`"MissingFromTarget":{"0":{"order_1":{"Cas":3},"user_2":{"Cas":4}},"8":{"user_3":{"Cas":5}}}}`
+
+func TestQueryMutationDiff(t *testing.T) {
+	fmt.Println("============== Test case start: TestQueryMutationDiff =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "results")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "mutationDiffDetails")
+	assert.Nil(ioutil.WriteFile(fileName, []byte(mutationDiffOutput), 0644))
+
+	query, err := NewQuery("", "", "", 0, 0)
+	assert.Nil(err)
+	page, err := Run(PhaseMutationDiff, fileName, query)
+	assert.Nil(err)
+	assert.Equal(4, page.Total)
+	assert.Len(page.Entries, 4)
+
+	query, err = NewQuery("MissingFromTarget", "", "user", 1, 1)
+	assert.Nil(err)
+	page, err = Run(PhaseMutationDiff, fileName, query)
+	assert.Nil(err)
+	assert.Equal(2, page.Total)
+	assert.Len(page.Entries, 1)
+	assert.Equal("user_3", page.Entries[0].Key)
+	assert.Equal(uint32(8), page.Entries[0].ColId)
+	assert.Equal(`{"Cas":5}`, string(page.Entries[0].Details))
+
+	assert.Equal("", page.Entries[0].Collection)
+
+	metadata := &RunMetadata{
+		SourceCollections: &CollectionNames{Names: map[uint32]string{0: "_default._default", 8: "inventory.users"}},
+		TargetCollections: &CollectionNames{Names: map[uint32]string{8: "inventory.orders"}},
+	}
+	assert.Nil(WriteRunMetadata(dir, metadata))
+	query, err = NewQuery("Mismatch", "", "", 0, 0)
+	assert.Nil(err)
+	page, err = Run(PhaseMutationDiff, fileName, query)
+	assert.Nil(err)
+	assert.Equal(1, page.Total)
+	assert.Equal("inventory.users", page.Entries[0].Collection)
+
+	_, err = NewQuery("", "notAnId", "", 0, 0)
+	assert.NotNil(err)
+	fmt.Println("============== Test case end: TestQueryMutationDiff =================")
+}
+
+func TestQueryFileDiff(t *testing.T) {
+	fmt.Println("============== Test case start: TestQueryFileDiff =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "results")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	// The categories of the first object are listed as older versions did, without their collection
+	output := `{"Mismatch":[[{"Key":"a","ColId":8},{"Key":"a","ColId":9}]],"MismatchCategories":{"BodyEqualXattrsDiffer":["a"]},` +
+		`"MissingFromSource":[{"Key":"b","ColId":0}],"MissingFromTarget":null}` +
+		`{"Mismatch":null,"MismatchCategories":{},"MissingFromSource":null,"MissingFromTarget":[{"Key":"c","ColId":0}]}`
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "diffDetails_0"), []byte(output), 0644))
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "diffDetails_1"), []byte(`{"MissingFromTarget":[{"Key":"d","ColId":0}]}`), 0644))
+
+	query, err := NewQuery("", "", "", 0, 0)
+	assert.Nil(err)
+	page, err := Run(PhaseFileDiff, filepath.Join(dir, "diffDetails_*"), query)
+	assert.Nil(err)
+	assert.Equal(4, page.Total)
+
+	query, err = NewQuery("BodyEqualXattrsDiffer", "8", "", 0, 0)
+	assert.Nil(err)
+	page, err = Run(PhaseFileDiff, filepath.Join(dir, "diffDetails_*"), query)
+	assert.Nil(err)
+	assert.Equal(1, page.Total)
+	assert.Equal("Mismatch", page.Entries[0].Category)
+	assert.Equal("a", page.Entries[0].Key)
+	fmt.Println("============== Test case end: TestQueryFileDiff =================")
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+	"xdcrDiffer/base"
+)
+
+// Written to the output directory of each phase
+const RunMetadataFileName = "runMetadata"
+
+// Scope and collection names of the collection IDs of a bucket, as of the manifest captured at the start of a run
+// Collections may since have been dropped, or recreated under a different ID
+type CollectionNames struct {
+	ManifestUid uint64
+	// Collection ID -> scope.collection
+	Names map[uint32]string
+}
+
+func (c *CollectionNames) Name(colId uint32) string {
+	if c == nil {
+		return ""
+	}
+	return c.Names[colId]
+}
+
+// Describes a run, so that its output can be interpreted on its own
+type RunMetadata struct {
+	// Of the tool that wrote the output
+	Build *base.BuildInfo `json:",omitempty"`
+	// Labels the clusters were given, if any
+	SourceLabel       string `json:",omitempty"`
+	TargetLabel       string `json:",omitempty"`
+	SourceBucketName  string
+	TargetBucketName  string
+	SourceCollections *CollectionNames
+	TargetCollections *CollectionNames
+	// Structural differences between the manifests, found before any document was compared
+	ManifestDivergences []*ManifestDivergence `json:",omitempty"`
+	// Clocks of the nodes of both clusters, measured when capture started
+	ClockSkew *ClockSkewReport `json:",omitempty"`
+	// Replication latency measured when the run started
+	CanaryLatency *CanaryLatency `json:",omitempty"`
+	// Sizes and datatypes of the documents captured from each bucket
+	Distribution *DistributionReport `json:",omitempty"`
+	// Keys found on either bucket or both, by collection
+	KeyOverlap *KeyOverlapReport `json:",omitempty"`
+	// Times of last mutation around which the divergences found by the file differ concentrate
+	HotWindows *HotWindowReport `json:",omitempty"`
+	// Number of times each fault was injected, for runs that exercise resilience rather than compare clusters
+	InjectedFaults map[string]int64 `json:",omitempty"`
+	// Why the run stopped before everything was compared, if it did
+	Aborted string `json:",omitempty"`
+	// Set when the replication was paused or reporting errors as the run started
+	Replication *ReplicationState `json:",omitempty"`
+	// Resources used by each phase that had completed when this was written
+	ResourceUsage []*PhaseResourceUsage `json:",omitempty"`
+}
+
+// The run stopped once the number of differences given by abortAfterDiffs was found
+const AbortedThresholdExceeded = "aborted early: threshold exceeded"
+
+// The circuit breaker of a cluster opened with circuitBreakerAction abort, so the keys left were not verified
+const AbortedCircuitOpen = "aborted early: circuit breaker open"
+
+// The read units of the Capella clusters reached maxReadUnitSpend, so the run stopped reading from them
+const AbortedReadUnitBudget = "aborted early: read unit budget spent"
+
+// End to end replication latency, measured by writing a canary document to the source and polling the target for it
+type CanaryLatency struct {
+	SourceCollection string
+	TargetCollection string
+	Key              string
+	// From the write to the source returning to the document being read from the target. Unset if it timed out
+	Latency  time.Duration `json:",omitempty"`
+	TimedOut bool          `json:",omitempty"`
+}
+
+func WriteRunMetadata(dir string, metadata *RunMetadata) error {
+	metadataBytes, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, RunMetadataFileName), metadataBytes, 0644)
+}
+
+// Returns nil without an error if the run did not write any metadata, i.e. it was run by an older version
+func ReadRunMetadata(dir string) (*RunMetadata, error) {
+	metadataBytes, err := ioutil.ReadFile(filepath.Join(dir, RunMetadataFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	metadata := &RunMetadata{}
+	if err = json.Unmarshal(metadataBytes, metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// Entries missing from the source were found in the target, so their collection IDs are the target's
+// The other entries carry the source collection IDs
+func (m *RunMetadata) collectionName(category string, colId uint32) string {
+	if m == nil {
+		return ""
+	}
+	if category == "MissingFromSource" {
+		return m.TargetCollections.Name(colId)
+	}
+	return m.SourceCollections.Name(colId)
+}
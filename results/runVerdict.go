@@ -0,0 +1,165 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"xdcrDiffer/base"
+)
+
+const (
+	RunVerdictPass = "pass"
+	RunVerdictFail = "fail"
+)
+
+// Threshold on the number of differences of all categories together
+const TotalThresholdName = "total"
+
+// Whether a run passes as a whole, for gates that validate replication without reading the output itself
+type RunVerdict struct {
+	Verdict string
+	// Why the run failed, if it did
+	Reason string `json:",omitempty"`
+	// Why the run stopped before everything was compared, if it did. The counts are of what was found until then
+	Aborted string `json:",omitempty"`
+	Created time.Time
+	// The phase whose differences are counted, i.e. the mutation differ if it was run, as it re-checks what the
+	// file differ found against the live clusters
+	DecidingPhase string `json:",omitempty"`
+	Differences   int
+	Thresholds    []*VerdictThreshold
+	// Phase -> category -> number of differences, after suppressions
+	Counts map[string]map[string]int
+	// Phase -> files holding the details of the differences
+	DetailFiles map[string][]string
+}
+
+type VerdictThreshold struct {
+	// A category, i.e. MissingFromTarget, or TotalThresholdName
+	Name string
+	// The run fails if Actual exceeds it
+	MaxAllowed int
+	Actual     int
+	Exceeded   bool `json:",omitempty"`
+}
+
+// Parses a maximum number of differences, i.e. "10", or comma separated category=max pairs, i.e.
+// "MissingFromTarget=0,Mismatch=100,total=100"
+func ParseFailThresholds(spec string) (map[string]int, error) {
+	thresholds := make(map[string]int)
+	for _, threshold := range strings.Split(spec, ",") {
+		if threshold = strings.TrimSpace(threshold); threshold == "" {
+			continue
+		}
+		name, maxStr := TotalThresholdName, threshold
+		if parts := strings.SplitN(threshold, "=", 2); len(parts) == 2 {
+			name, maxStr = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		}
+		max, err := strconv.Atoi(maxStr)
+		if err != nil || max < 0 {
+			return nil, fmt.Errorf("Invalid threshold %v. The maximum has to be a number no less than 0", threshold)
+		}
+		thresholds[name] = max
+	}
+	if len(thresholds) == 0 {
+		return nil, fmt.Errorf("No thresholds given in %v", spec)
+	}
+	return thresholds, nil
+}
+
+// Counts the differences in the output of each phase, given as phase -> glob of its output files. Phases whose
+// output is not found were not run, and are left out
+func NewRunVerdict(patterns map[string]string, thresholds map[string]int) (*RunVerdict, error) {
+	verdict := &RunVerdict{
+		Verdict:     RunVerdictPass,
+		Created:     time.Now().UTC(),
+		Counts:      make(map[string]map[string]int),
+		DetailFiles: make(map[string][]string),
+	}
+	for _, phase := range []string{PhaseFileDiff, PhaseMutationDiff} {
+		pattern, ok := patterns[phase]
+		if !ok {
+			continue
+		}
+		if fileNames, _ := filepath.Glob(pattern); len(fileNames) == 0 {
+			continue
+		}
+		counts := make(map[string]int)
+		fileNames, err := scanPhase(phase, pattern, func(entry *Entry) {
+			counts[entry.Category]++
+		})
+		if err != nil {
+			return nil, err
+		}
+		verdict.Counts[phase] = counts
+		verdict.DetailFiles[phase] = fileNames
+		verdict.DecidingPhase = phase
+	}
+
+	if verdict.DecidingPhase == "" {
+		// Nothing was compared, which is not to be mistaken for no differences
+		verdict.Verdict = RunVerdictFail
+		verdict.Reason = "no differ output was found"
+		return verdict, nil
+	}
+
+	decidingCounts := verdict.Counts[verdict.DecidingPhase]
+	for category, count := range decidingCounts {
+		// Documents the replication is configured to make differ, knows to be in conflict, or whose tombstones may
+		// have been purged, are reported, but are not unexplained differences
+		if category != base.ExpectedByConfigurationCategory && category != base.KnownConflictCategory && category != base.PurgedCategory {
+			verdict.Differences += count
+		}
+	}
+	names := make([]string, 0, len(thresholds))
+	for name := range thresholds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		threshold := &VerdictThreshold{Name: name, MaxAllowed: thresholds[name], Actual: decidingCounts[name]}
+		if name == TotalThresholdName {
+			threshold.Actual = verdict.Differences
+		}
+		if threshold.Actual > threshold.MaxAllowed {
+			threshold.Exceeded = true
+			verdict.Verdict = RunVerdictFail
+			if verdict.Reason == "" {
+				verdict.Reason = fmt.Sprintf("%v differences of %v exceed the %v allowed", threshold.Actual, name, threshold.MaxAllowed)
+			}
+		}
+		verdict.Thresholds = append(verdict.Thresholds, threshold)
+	}
+	return verdict, nil
+}
+
+// An aborted run fails, whatever its counts, as they are of what was compared before it stopped
+func (v *RunVerdict) SetAborted(reason string) {
+	v.Aborted = reason
+	v.Verdict = RunVerdictFail
+	if v.Reason == "" {
+		v.Reason = "the run was " + reason
+	}
+}
+
+func (v *RunVerdict) Write(fileName string) error {
+	verdictBytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, verdictBytes, 0644)
+}
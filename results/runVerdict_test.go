@@ -0,0 +1,86 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"xdcrDiffer/base"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunVerdict(t *testing.T) {
+	fmt.Println("============== Test case start: TestRunVerdict =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "runVerdict")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	fileDiffPattern := filepath.Join(dir, "fileDiff", "diffDetails_*")
+	mutationDiffPattern := filepath.Join(dir, "mutationDiff", "mutationDiffDetails")
+	patterns := map[string]string{PhaseFileDiff: fileDiffPattern, PhaseMutationDiff: mutationDiffPattern}
+
+	thresholds, err := ParseFailThresholds("0")
+	assert.Nil(err)
+	assert.Equal(map[string]int{TotalThresholdName: 0}, thresholds)
+	verdict, err := NewRunVerdict(patterns, thresholds)
+	assert.Nil(err)
+	assert.Equal(RunVerdictFail, verdict.Verdict)
+	assert.Equal("", verdict.DecidingPhase)
+
+	assert.Nil(os.MkdirAll(filepath.Dir(fileDiffPattern), 0777))
+	assert.Nil(ioutil.WriteFile(filepath.Join(filepath.Dir(fileDiffPattern), "diffDetails_0"),
+		[]byte(`{"Mismatch":null,"MismatchCategories":{},"MissingFromSource":[{"Key":"b","ColId":0}],"MissingFromTarget":null,`+
+			`"ExpectedByConfiguration":[[{"Key":"c","ColId":0},{"Key":"c","ColId":0}]]}`), 0644))
+	verdict, err = NewRunVerdict(patterns, map[string]int{TotalThresholdName: 1})
+	assert.Nil(err)
+	assert.Equal(RunVerdictPass, verdict.Verdict)
+	assert.Equal(PhaseFileDiff, verdict.DecidingPhase)
+	// Documents the replication is configured to make differ are counted, but not as differences
+	assert.Equal(1, verdict.Differences)
+	assert.Equal(1, verdict.Counts[PhaseFileDiff][base.ExpectedByConfigurationCategory])
+
+	// The mutation differ decides once it is run
+	assert.Nil(os.MkdirAll(filepath.Dir(mutationDiffPattern), 0777))
+	assert.Nil(ioutil.WriteFile(mutationDiffPattern, []byte(mutationDiffOutput), 0644))
+	thresholds, err = ParseFailThresholds("MissingFromTarget=2, total=10")
+	assert.Nil(err)
+	verdict, err = NewRunVerdict(patterns, thresholds)
+	assert.Nil(err)
+	assert.Equal(RunVerdictFail, verdict.Verdict)
+	assert.Equal(PhaseMutationDiff, verdict.DecidingPhase)
+	assert.Equal(4, verdict.Differences)
+	assert.Equal(map[string]int{"Mismatch": 1, "MissingFromTarget": 3}, verdict.Counts[PhaseMutationDiff])
+	assert.Equal(1, verdict.Counts[PhaseFileDiff]["MissingFromSource"])
+	assert.Equal([]string{mutationDiffPattern}, verdict.DetailFiles[PhaseMutationDiff])
+	assert.Len(verdict.Thresholds, 2)
+	assert.True(verdict.Thresholds[0].Exceeded)
+	assert.False(verdict.Thresholds[1].Exceeded)
+	assert.Equal("3 differences of MissingFromTarget exceed the 2 allowed", verdict.Reason)
+
+	// An aborted run fails however few differences it found
+	verdict, err = NewRunVerdict(patterns, map[string]int{TotalThresholdName: 100})
+	assert.Nil(err)
+	assert.Equal(RunVerdictPass, verdict.Verdict)
+	verdict.SetAborted(AbortedThresholdExceeded)
+	assert.Equal(RunVerdictFail, verdict.Verdict)
+	assert.Equal(AbortedThresholdExceeded, verdict.Aborted)
+
+	_, err = ParseFailThresholds("Mismatch=-1")
+	assert.NotNil(err)
+	_, err = ParseFailThresholds(" , ")
+	assert.NotNil(err)
+
+	fmt.Println("============== Test case end: TestRunVerdict =================")
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+)
+
+// Keys of the seeded documents start with this, so that the rest of the bucket is left out of the check
+const SeedKeyPrefix = "xdcrDifferSeed_"
+
+// A synthetic dataset that is the same every time for the same size, written to the source and replicated, after
+// which divergences of each kind the mutation differ reports are injected into the target
+type SeedDataset struct {
+	Documents   int
+	Divergences int
+	// Mutation differ category -> keys injected into it
+	Injected map[string][]string
+}
+
+type SeedDocument struct {
+	Id    int    `json:"id"`
+	Name  string `json:"name"`
+	Value uint32 `json:"value"`
+	// Set only on the target, for the body to differ
+	Changed bool `json:"changed,omitempty"`
+}
+
+func NewSeedDataset(documents, divergences int) (*SeedDataset, error) {
+	if divergences <= 0 || documents < 2*divergences {
+		return nil, fmt.Errorf("%v documents are not enough to inject %v divergences of each kind", documents, divergences)
+	}
+	s := &SeedDataset{Documents: documents, Divergences: divergences, Injected: make(map[string][]string)}
+	for i := 0; i < divergences; i++ {
+		s.Injected["MissingFromTarget"] = append(s.Injected["MissingFromTarget"], s.Key(i))
+		s.Injected["MissingFromSource"] = append(s.Injected["MissingFromSource"], s.TargetOnlyKey(i))
+		s.Injected["Mismatch"] = append(s.Injected["Mismatch"], s.Key(divergences+i))
+	}
+	for _, keys := range s.Injected {
+		sort.Strings(keys)
+	}
+	return s, nil
+}
+
+// Key of the ith document written to the source
+func (s *SeedDataset) Key(i int) string {
+	return fmt.Sprintf("%vdoc_%v", SeedKeyPrefix, i)
+}
+
+// Key of the ith document written to the target only
+func (s *SeedDataset) TargetOnlyKey(i int) string {
+	return fmt.Sprintf("%vtargetOnly_%v", SeedKeyPrefix, i)
+}
+
+func (s *SeedDataset) Document(i int) *SeedDocument {
+	name := fmt.Sprintf("seed document %v", i)
+	return &SeedDocument{Id: i, Name: name, Value: crc32.ChecksumIEEE([]byte(name))}
+}
+
+// Documents deleted from the target, once replicated
+func (s *SeedDataset) DeletedFromTarget() []string {
+	return s.Injected["MissingFromTarget"]
+}
+
+// Documents whose body is changed on the target, once replicated, and what it is changed to
+func (s *SeedDataset) ChangedOnTarget() map[string]*SeedDocument {
+	changed := make(map[string]*SeedDocument)
+	for i := s.Divergences; i < 2*s.Divergences; i++ {
+		doc := s.Document(i)
+		doc.Changed = true
+		changed[s.Key(i)] = doc
+	}
+	return changed
+}
+
+// Compares the seeded keys a run found in each category with those injected. Returns what was missed or found in
+// excess, if anything
+func (s *SeedDataset) Check(found map[string][]string) []string {
+	var failures []string
+	categories := make(map[string]bool)
+	for category := range s.Injected {
+		categories[category] = true
+	}
+	for category := range found {
+		categories[category] = true
+	}
+	sortedCategories := make([]string, 0, len(categories))
+	for category := range categories {
+		sortedCategories = append(sortedCategories, category)
+	}
+	sort.Strings(sortedCategories)
+
+	for _, category := range sortedCategories {
+		foundKeys := append([]string{}, found[category]...)
+		sort.Strings(foundKeys)
+		if fmt.Sprint(foundKeys) != fmt.Sprint(s.Injected[category]) {
+			failures = append(failures, fmt.Sprintf("%v: injected %v but found %v", category, s.Injected[category], foundKeys))
+		}
+	}
+	return failures
+}
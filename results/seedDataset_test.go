@@ -0,0 +1,59 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeedDataset(t *testing.T) {
+	fmt.Println("============== Test case start: TestSeedDataset =================")
+	assert := assert.New(t)
+
+	_, err := NewSeedDataset(3, 2)
+	assert.NotNil(err)
+	dataset, err := NewSeedDataset(10, 2)
+	assert.Nil(err)
+
+	// The same every time
+	again, _ := NewSeedDataset(10, 2)
+	assert.Equal(dataset, again)
+	assert.Equal(dataset.Document(7), again.Document(7))
+	assert.NotEqual(dataset.Document(7).Value, dataset.Document(8).Value)
+
+	assert.Equal([]string{"xdcrDifferSeed_doc_0", "xdcrDifferSeed_doc_1"}, dataset.DeletedFromTarget())
+	changed := dataset.ChangedOnTarget()
+	assert.Len(changed, 2)
+	assert.True(changed["xdcrDifferSeed_doc_3"].Changed)
+	assert.Equal(3, changed["xdcrDifferSeed_doc_3"].Id)
+	assert.Equal([]string{"xdcrDifferSeed_targetOnly_0", "xdcrDifferSeed_targetOnly_1"}, dataset.Injected["MissingFromSource"])
+
+	found := map[string][]string{
+		"MissingFromTarget": {"xdcrDifferSeed_doc_1", "xdcrDifferSeed_doc_0"},
+		"MissingFromSource": {"xdcrDifferSeed_targetOnly_0", "xdcrDifferSeed_targetOnly_1"},
+		"Mismatch":          {"xdcrDifferSeed_doc_3", "xdcrDifferSeed_doc_2"},
+	}
+	assert.Len(dataset.Check(found), 0)
+
+	// Missed, and found in excess or in a category nothing was injected into
+	found["Mismatch"] = []string{"xdcrDifferSeed_doc_2"}
+	found["MissingFromSource"] = append(found["MissingFromSource"], "xdcrDifferSeed_doc_9")
+	found["ExpectedByConfiguration"] = []string{"xdcrDifferSeed_doc_5"}
+	failures := dataset.Check(found)
+	assert.Len(failures, 3)
+	assert.True(strings.HasPrefix(failures[0], "ExpectedByConfiguration: injected [] but found [xdcrDifferSeed_doc_5]"))
+	assert.True(strings.HasPrefix(failures[1], "Mismatch:"))
+	assert.True(strings.HasPrefix(failures[2], "MissingFromSource:"))
+	fmt.Println("============== Test case end: TestSeedDataset =================")
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const ResultsPathPrefix = "/results/"
+
+// Number of entries returned when no limit is given
+const DefaultPageLimit = 100
+
+// Serves queries over the output of each phase, given by phase -> glob of its output files, i.e.
+//
+//	GET /results/mutationDiff?category=Mismatch&colId=8,9&keyPrefix=user&offset=100&limit=100
+//	GET /results/mutationDiff?key=user_1
+//
+// returns a Page as JSON. Every request reads the file again, so that the latest output is always returned
+func NewHandler(patterns map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		phase := strings.TrimPrefix(r.URL.Path, ResultsPathPrefix)
+		pattern, ok := patterns[phase]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		params := r.URL.Query()
+		offset, err := intParam(params.Get("offset"), 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, err := intParam(params.Get("limit"), DefaultPageLimit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query, err := NewQuery(params.Get("category"), params.Get("colId"), params.Get("keyPrefix"), offset, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query.Key = params.Get("key")
+
+		page, err := Run(phase, pattern, query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	})
+}
+
+func intParam(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+	"xdcrDiffer/base"
+)
+
+// Written next to the output of a phase, holding the entries that were taken out of it
+const SuppressedFileName = "suppressed"
+
+// Layout of Suppression.Expires
+const SuppressionDateLayout = "2006-01-02"
+
+// A known and accepted divergence, which is reported separately instead of as a difference
+type Suppression struct {
+	// Either the key of the document, or a prefix of the keys of the documents, that diverge
+	Key       string `json:",omitempty"`
+	KeyPrefix string `json:",omitempty"`
+	// scope.collection of the documents. Any collection if empty
+	Collection string `json:",omitempty"`
+	Reason     string
+	// Date after which the divergence is reported again. Never if empty
+	Expires string `json:",omitempty"`
+
+	expires time.Time
+}
+
+func (s *Suppression) String() string {
+	target := s.Key
+	if target == "" {
+		target = s.KeyPrefix + "*"
+	}
+	if s.Collection != "" {
+		target = fmt.Sprintf("%v in %v", target, s.Collection)
+	}
+	return fmt.Sprintf("%v (%v)", target, s.Reason)
+}
+
+// Keys of entries are encoded by base.EncodeKey, so the suppression is too
+func (s *Suppression) matches(entry *Entry) bool {
+	if s.Collection != "" && s.Collection != entry.Collection {
+		return false
+	}
+	if s.Key != "" {
+		key, _ := base.EncodeKey(s.Key)
+		return key == entry.Key
+	}
+	return strings.HasPrefix(entry.Key, s.KeyPrefix)
+}
+
+func (s *Suppression) expired(now time.Time) bool {
+	return !s.expires.IsZero() && now.After(s.expires)
+}
+
+// A JSON array of suppressions
+func LoadSuppressions(fileName string) ([]*Suppression, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var suppressions []*Suppression
+	if err = json.Unmarshal(data, &suppressions); err != nil {
+		return nil, err
+	}
+	for i, suppression := range suppressions {
+		if (suppression.Key == "") == (suppression.KeyPrefix == "") {
+			return nil, fmt.Errorf("suppression %v: exactly one of Key and KeyPrefix has to be given", i)
+		}
+		if suppression.Reason == "" {
+			return nil, fmt.Errorf("suppression %v: a reason has to be given", i)
+		}
+		if suppression.Expires != "" {
+			expires, err := time.Parse(SuppressionDateLayout, suppression.Expires)
+			if err != nil {
+				return nil, fmt.Errorf("suppression %v: invalid expiry date %v: %v", i, suppression.Expires, err)
+			}
+			// The divergence is accepted until the end of the day it expires on
+			suppression.expires = expires.AddDate(0, 0, 1)
+		}
+	}
+	return suppressions, nil
+}
+
+type SuppressedEntry struct {
+	*Entry
+	Reason  string
+	Expires string `json:",omitempty"`
+}
+
+type SuppressionSummary struct {
+	Suppressed int
+	// Suppressions past their expiry, whose documents are reported as differences again
+	Expired []*Suppression `json:",omitempty"`
+}
+
+// Takes the entries matching an active suppression out of the given output files of a phase, and writes them
+// to suppressedFileName instead
+func Suppress(phase string, fileNames []string, metadata *RunMetadata, suppressions []*Suppression, now time.Time,
+	suppressedFileName string) (*SuppressionSummary, error) {
+	summary := &SuppressionSummary{}
+	var active []*Suppression
+	for _, suppression := range suppressions {
+		if suppression.expired(now) {
+			summary.Expired = append(summary.Expired, suppression)
+		} else {
+			active = append(active, suppression)
+		}
+	}
+
+	suppressed := []*SuppressedEntry{}
+	sort.Strings(fileNames)
+	for _, fileName := range fileNames {
+		var kept []*Entry
+		var found bool
+		collect := func(entry *Entry) {
+			entry.Collection = metadata.collectionName(entry.Category, entry.ColId)
+			for _, suppression := range active {
+				if suppression.matches(entry) {
+					suppressed = append(suppressed, &SuppressedEntry{Entry: entry, Reason: suppression.Reason, Expires: suppression.Expires})
+					found = true
+					return
+				}
+			}
+			kept = append(kept, entry)
+		}
+		if err := scanFile(fileName, scanFunc(phase), collect); err != nil {
+			return nil, fmt.Errorf("Unable to read %v: %v", fileName, err)
+		}
+		if !found {
+			continue
+		}
+		if err := writeMerged(phase, fileName, kept); err != nil {
+			return nil, err
+		}
+	}
+	summary.Suppressed = len(suppressed)
+
+	suppressedBytes, err := json.Marshal(suppressed)
+	if err != nil {
+		return nil, err
+	}
+	return summary, ioutil.WriteFile(suppressedFileName, suppressedBytes, 0644)
+}
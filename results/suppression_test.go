@@ -0,0 +1,79 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuppress(t *testing.T) {
+	fmt.Println("============== Test case start: TestSuppress =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "suppress")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	suppressionsFile := filepath.Join(dir, "suppressions")
+	assert.Nil(ioutil.WriteFile(suppressionsFile, []byte(`[
+		{"Key": "user_1", "Reason": "rewritten by an application on the target"},
+		{"KeyPrefix": "user_", "Collection": "S1.col1", "Reason": "known", "Expires": "2026-01-31"},
+		{"KeyPrefix": "order_", "Collection": "S1.col2", "Reason": "other collection"}
+	]`), 0644))
+	suppressions, err := LoadSuppressions(suppressionsFile)
+	assert.Nil(err)
+	assert.Len(suppressions, 3)
+
+	outputFile := filepath.Join(dir, "mutationDiffDetails")
+	assert.Nil(ioutil.WriteFile(outputFile, []byte(mutationDiffOutput), 0644))
+	metadata := &RunMetadata{SourceCollections: &CollectionNames{Names: map[uint32]string{8: "S1.col1"}}}
+
+	// The prefix suppression is in effect until the end of the day it expires on
+	now := time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC)
+	suppressedFile := filepath.Join(dir, SuppressedFileName)
+	summary, err := Suppress(PhaseMutationDiff, []string{outputFile}, metadata, suppressions, now, suppressedFile)
+	assert.Nil(err)
+	assert.Equal(2, summary.Suppressed)
+	assert.Len(summary.Expired, 0)
+
+	// user_2 is in a collection other than the one of the prefix suppression
+	query, err := NewQuery("MissingFromTarget", "", "", 0, 0)
+	assert.Nil(err)
+	page, err := Run(PhaseMutationDiff, outputFile, query)
+	assert.Nil(err)
+	assert.Equal(2, page.Total)
+
+	var suppressed []*SuppressedEntry
+	data, err := ioutil.ReadFile(suppressedFile)
+	assert.Nil(err)
+	assert.Nil(json.Unmarshal(data, &suppressed))
+	assert.Len(suppressed, 2)
+	assert.Equal("rewritten by an application on the target", suppressed[0].Reason)
+
+	// Once expired, the divergence is reported again
+	assert.Nil(ioutil.WriteFile(outputFile, []byte(mutationDiffOutput), 0644))
+	summary, err = Suppress(PhaseMutationDiff, []string{outputFile}, metadata, suppressions, now.Add(2*time.Hour), suppressedFile)
+	assert.Nil(err)
+	assert.Equal(1, summary.Suppressed)
+	assert.Len(summary.Expired, 1)
+
+	assert.Nil(ioutil.WriteFile(suppressionsFile, []byte(`[{"Key": "user_1"}]`), 0644))
+	_, err = LoadSuppressions(suppressionsFile)
+	assert.NotNil(err)
+	fmt.Println("============== Test case end: TestSuppress =================")
+}
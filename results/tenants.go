@@ -0,0 +1,193 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"xdcrDiffer/base"
+)
+
+// Written next to the output of a phase, holding the divergence counts of each tenant
+const TenantSummaryFileName = "tenantSummary"
+
+// Directory next to the output of a phase, holding a directory per tenant with the part of the output that is theirs
+const TenantsDirName = "tenants"
+
+// Tenant of the entries that no tenant matches
+const UnassignedTenant = "unassigned"
+
+// A customer of a multi-tenant bucket, whose documents are told apart by their keys, their collections, or both
+type Tenant struct {
+	// Names the directory of their output, so it cannot be a path
+	Name string
+	// Prefixes of the keys of their documents. Any key if empty
+	KeyPrefixes []string `json:",omitempty"`
+	// scope.collection, or scope for every collection of it. Any collection if empty
+	Collections []string `json:",omitempty"`
+}
+
+// Keys of entries are encoded by base.EncodeKey, so they are decoded to be matched against the prefixes
+func (t *Tenant) matches(entry *Entry) bool {
+	if len(t.Collections) > 0 {
+		var found bool
+		for _, collection := range t.Collections {
+			if entry.Collection == collection || strings.HasPrefix(entry.Collection, collection+base.ScopeCollectionDelimiter) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(t.KeyPrefixes) == 0 {
+		return true
+	}
+	key, err := base.DecodeKey(entry.Key)
+	if err != nil {
+		key = entry.Key
+	}
+	for _, prefix := range t.KeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// A JSON array of tenants. An entry belongs to the first tenant that matches it
+func LoadTenants(fileName string) ([]*Tenant, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []*Tenant
+	if err = json.Unmarshal(data, &tenants); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i, tenant := range tenants {
+		if tenant.Name == "" || tenant.Name == "." || tenant.Name == ".." || strings.ContainsAny(tenant.Name, `/\`) {
+			return nil, fmt.Errorf("tenant %v: invalid name %q", i, tenant.Name)
+		}
+		if tenant.Name == UnassignedTenant || names[tenant.Name] {
+			return nil, fmt.Errorf("tenant %v: name %v is already taken", i, tenant.Name)
+		}
+		names[tenant.Name] = true
+		if len(tenant.KeyPrefixes) == 0 && len(tenant.Collections) == 0 {
+			return nil, fmt.Errorf("tenant %v: at least one key prefix or collection has to be given", tenant.Name)
+		}
+	}
+	return tenants, nil
+}
+
+// Divergences of a tenant found by a phase
+type TenantSummary struct {
+	Tenant string
+	Total  int
+	// Category -> number of entries
+	Categories map[string]int
+}
+
+func (s *TenantSummary) String() string {
+	if s.Total == 0 {
+		return fmt.Sprintf("%v: none", s.Tenant)
+	}
+	categories := make([]string, 0, len(s.Categories))
+	for category := range s.Categories {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	counts := make([]string, len(categories))
+	for i, category := range categories {
+		counts[i] = fmt.Sprintf("%v %v", category, s.Categories[category])
+	}
+	return fmt.Sprintf("%v: %v (%v)", s.Tenant, s.Total, strings.Join(counts, ", "))
+}
+
+// Splits the given output files of a phase by tenant, writing the part of each file that is a tenant's to a file of
+// the same name and format in tenantsDir/<tenant>, so that it can be shared with them alone. Every tenant gets their
+// files, and their summary, even when nothing of theirs diverges. Entries of no tenant go to UnassignedTenant, which
+// is only written if there are any. The summaries of all tenants are written to summaryFileName
+func SplitByTenant(phase string, fileNames []string, metadata *RunMetadata, tenants []*Tenant, tenantsDir,
+	summaryFileName string) ([]*TenantSummary, error) {
+	summaries := make([]*TenantSummary, len(tenants)+1)
+	for i, tenant := range tenants {
+		summaries[i] = &TenantSummary{Tenant: tenant.Name, Categories: make(map[string]int)}
+	}
+	unassigned := len(tenants)
+	summaries[unassigned] = &TenantSummary{Tenant: UnassignedTenant, Categories: make(map[string]int)}
+	// Left over from a previous run in the same directory, possibly of tenants since taken out of the mapping
+	if err := os.RemoveAll(tenantsDir); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(fileNames)
+	for _, fileName := range fileNames {
+		entries := make([][]*Entry, len(summaries))
+		collect := func(entry *Entry) {
+			entry.Collection = metadata.collectionName(entry.Category, entry.ColId)
+			i := unassigned
+			for j, tenant := range tenants {
+				if tenant.matches(entry) {
+					i = j
+					break
+				}
+			}
+			entries[i] = append(entries[i], entry)
+			summaries[i].Total++
+			summaries[i].Categories[entry.Category]++
+		}
+		if err := scanFile(fileName, scanFunc(phase), collect); err != nil {
+			return nil, fmt.Errorf("Unable to read %v: %v", fileName, err)
+		}
+		for i, summary := range summaries {
+			if i == unassigned && len(entries[i]) == 0 {
+				continue
+			}
+			dir := filepath.Join(tenantsDir, summary.Tenant)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, err
+			}
+			if err := writeMerged(phase, filepath.Join(dir, filepath.Base(fileName)), entries[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if summaries[unassigned].Total == 0 {
+		summaries = summaries[:unassigned]
+	}
+
+	// Each tenant's own summary goes with their output, so that their directory can be shared as it is
+	for _, summary := range summaries {
+		dir := filepath.Join(tenantsDir, summary.Tenant)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		if err := writeTenantSummaries(filepath.Join(dir, TenantSummaryFileName), summary); err != nil {
+			return nil, err
+		}
+	}
+	return summaries, writeTenantSummaries(summaryFileName, summaries)
+}
+
+func writeTenantSummaries(fileName string, summaries interface{}) error {
+	summaryBytes, err := json.MarshalIndent(summaries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, summaryBytes, 0644)
+}
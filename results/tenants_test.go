@@ -0,0 +1,84 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitByTenant(t *testing.T) {
+	fmt.Println("============== Test case start: TestSplitByTenant =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "tenants")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	tenantsFile := filepath.Join(dir, "tenantMapping")
+	assert.Nil(ioutil.WriteFile(tenantsFile, []byte(`[
+		{"Name": "acme", "Collections": ["inventory"], "KeyPrefixes": ["user_1", "user_3"]},
+		{"Name": "globex", "KeyPrefixes": ["order_"]}
+	]`), 0644))
+	tenants, err := LoadTenants(tenantsFile)
+	assert.Nil(err)
+	assert.Len(tenants, 2)
+
+	outputFile := filepath.Join(dir, "mutationDiffDetails")
+	assert.Nil(ioutil.WriteFile(outputFile, []byte(mutationDiffOutput), 0644))
+	metadata := &RunMetadata{SourceCollections: &CollectionNames{Names: map[uint32]string{0: "_default._default", 8: "inventory.users"}}}
+
+	tenantsDir := filepath.Join(dir, TenantsDirName)
+	summaryFile := filepath.Join(dir, TenantSummaryFileName)
+	summaries, err := SplitByTenant(PhaseMutationDiff, []string{outputFile}, metadata, tenants, tenantsDir, summaryFile)
+	assert.Nil(err)
+	// user_2 is in neither tenant
+	assert.Len(summaries, 3)
+	assert.Equal("acme: 2 (Mismatch 1, MissingFromTarget 1)", summaries[0].String())
+	assert.Equal("globex: 1 (MissingFromTarget 1)", summaries[1].String())
+	assert.Equal(UnassignedTenant, summaries[2].Tenant)
+	assert.Equal(1, summaries[2].Total)
+
+	// The output of each tenant is queried as the output of a run is
+	query, err := NewQuery("", "", "", 0, 0)
+	assert.Nil(err)
+	page, err := Run(PhaseMutationDiff, filepath.Join(tenantsDir, "acme", "mutationDiffDetails"), query)
+	assert.Nil(err)
+	assert.Equal(2, page.Total)
+	page, err = Run(PhaseMutationDiff, filepath.Join(tenantsDir, "globex", "mutationDiffDetails"), query)
+	assert.Nil(err)
+	assert.Equal(1, page.Total)
+	assert.Equal("order_1", page.Entries[0].Key)
+
+	var summary *TenantSummary
+	data, err := ioutil.ReadFile(filepath.Join(tenantsDir, "globex", TenantSummaryFileName))
+	assert.Nil(err)
+	assert.Nil(json.Unmarshal(data, &summary))
+	assert.Equal(1, summary.Total)
+	var allSummaries []*TenantSummary
+	data, err = ioutil.ReadFile(summaryFile)
+	assert.Nil(err)
+	assert.Nil(json.Unmarshal(data, &allSummaries))
+	assert.Len(allSummaries, 3)
+
+	for _, invalid := range []string{`[{"Name": "../acme", "KeyPrefixes": ["a"]}]`, `[{"Name": "acme"}]`,
+		`[{"Name": "acme", "KeyPrefixes": ["a"]}, {"Name": "acme", "KeyPrefixes": ["b"]}]`} {
+		assert.Nil(ioutil.WriteFile(tenantsFile, []byte(invalid), 0644))
+		_, err = LoadTenants(tenantsFile)
+		assert.NotNil(err)
+	}
+	fmt.Println("============== Test case end: TestSplitByTenant =================")
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"xdcrDiffer/base"
+	"xdcrDiffer/results"
+)
+
+const resultsCommand = "results"
+
+// Queries the output of a completed run, i.e.
+//
+//	xdcrDiffer results -category MissingFromTarget -keyPrefix user -offset 100 -limit 100
+func runResultsCommand(args []string) error {
+	flags := flag.NewFlagSet(resultsCommand, flag.ExitOnError)
+	phase := flags.String("phase", results.PhaseMutationDiff,
+		fmt.Sprintf("Output to query: %v or %v", results.PhaseMutationDiff, results.PhaseFileDiff))
+	fileDifferDir := flags.String("fileDifferDir", base.FileDifferDir,
+		"directory of the file differ output")
+	mutationDifferDir := flags.String("mutationDifferDir", base.MutationDifferDir,
+		"directory of the mutation differ output")
+	category := flags.String("category", "",
+		"Comma separated categories to return, i.e. Mismatch,MissingFromTarget or BodyEqualXattrsDiffer. Default is all")
+	collectionIds := flags.String("collectionIds", "",
+		"Comma separated collection IDs to return. Default is all")
+	keyPrefix := flags.String("keyPrefix", "",
+		"Return only keys starting with this prefix")
+	key := flags.String("key", "",
+		"Return only the entries of this key, as written by the differ. Read from the chunked output alone if the mutation differ wrote it")
+	offset := flags.Int("offset", 0,
+		"Number of matching entries to skip")
+	limit := flags.Int("limit", results.DefaultPageLimit,
+		"Maximum number of entries to return. 0 for all")
+	keysOnly := flags.Bool("keysOnly", false,
+		"Print only the keys, one per line, i.e. to be fed back to -diffKeysSource")
+	listen := flags.String("listen", "",
+		"Instead of running a single query, serve queries over HTTP on this address, i.e. 127.0.0.1:8095")
+	flags.Parse(args)
+
+	patterns := map[string]string{
+		results.PhaseFileDiff:     filepath.Join(*fileDifferDir, base.DiffDetailsFileName+base.FileNameDelimiter+"*"),
+		results.PhaseMutationDiff: filepath.Join(*mutationDifferDir, base.MutationDiffFileName),
+	}
+
+	if *listen != "" {
+		fmt.Printf("Serving results on http://%v%v{%v,%v}\n", *listen, results.ResultsPathPrefix, results.PhaseMutationDiff, results.PhaseFileDiff)
+		http.Handle(results.ResultsPathPrefix, results.NewHandler(patterns))
+		return http.ListenAndServe(*listen, nil)
+	}
+
+	pattern, ok := patterns[*phase]
+	if !ok {
+		return fmt.Errorf("Invalid phase %v. Accepted values are %v and %v", *phase, results.PhaseMutationDiff, results.PhaseFileDiff)
+	}
+	query, err := results.NewQuery(*category, *collectionIds, *keyPrefix, *offset, *limit)
+	if err != nil {
+		return err
+	}
+	query.Key = *key
+	page, err := results.Run(*phase, pattern, query)
+	if err != nil {
+		return err
+	}
+
+	if *keysOnly {
+		// Kept off stdout, which is for keys only
+		if page.Replication != "" {
+			fmt.Fprintf(os.Stderr, "The %v\n", page.Replication)
+		}
+		for _, entry := range page.Entries {
+			fmt.Println(entry.Key)
+		}
+		return nil
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(page)
+}
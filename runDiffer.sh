@@ -25,7 +25,7 @@ function printHelp() {
 	findExec
 
 	cat <<EOF
-Usage: $0 -u <username> -p <password> -h <hostname:port> -s <sourceBucket> -t <targetBucket> -r <remoteClusterName> [-v <targetUrl>] [-n <remoteClusterUsername> -q <remoteClusterPassword>] [-c clean] [-m meta | body | both ] [-e <mutationRetries>] [-w <setupTimeoutInSeconds>] [-d] [-x <FileContaingXattrKeysToExclude>]
+Usage: $0 -u <username> -p <password> -h <hostname:port> -s <sourceBucket> -t <targetBucket> -r <remoteClusterName> [-v <targetUrl>] [-n <remoteClusterUsername> -q <remoteClusterPassword>] [-c clean] [-m meta | body | both ] [-e <mutationRetries>] [-w <setupTimeoutInSeconds>] [-d] [-x <FileContaingXattrKeysToExclude>] [-f]
 
 This script will set up the necessary environment variable to allow the XDCR diff tool to connect to the metakv service in the
 specified source cluster (NOTE: over http://) and retrieve the specified replication spec and run the difftool on it.
@@ -37,6 +37,7 @@ use "-m" to specify what to compare during mutationDiff.
  body will get document body and only compare the document body. This is slower and does not include tombstones
  both will get document body and compare both document body and metadata. This is slower and includes tombstones
 use "-d" to enable SDK (gocb) verbose logging along with the xdcrDiffer DEBUG logging. Should be only used for debugging purposes (can be quite spammy)
+use "-f" to run in fast mode, which compares only the metadata and body hash captured via DCP and skips the mutation differ
 EOF
 }
 
@@ -58,7 +59,7 @@ function killBgTail {
 	fi
 }
 
-while getopts ":h:p:u:r:s:t:n:q:v:cm:ew:d:x:" opt; do
+while getopts ":h:p:u:r:s:t:n:q:v:cm:ew:d:x:f" opt; do
 	case ${opt} in
 	u)
 		username=$OPTARG
@@ -105,6 +106,9 @@ while getopts ":h:p:u:r:s:t:n:q:v:cm:ew:d:x:" opt; do
 	x)
 		fileContaingXattrKeysForNoComapre=$OPTARG
 		;;
+	f)
+		fastMode=1
+		;;
 	\?)
 		echo "Invalid option: $OPTARG" 1>&2
 		;;
@@ -214,6 +218,9 @@ if [[ ! -z "$fileContaingXattrKeysForNoComapre" ]]; then
 	execString="${execString} -fileContaingXattrKeysForNoComapre"
 	execString="${execString} $fileContaingXattrKeysForNoComapre"
 fi
+if [[ ! -z "$fastMode" ]]; then
+	execString="${execString} -fastMode"
+fi
 
 # Execute the differ in background and watch the pid to be finished
 $execString >$differLogFileName 2>&1 &
@@ -0,0 +1,98 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"xdcrDiffer/base"
+
+	xdcrBase "github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/metadata"
+)
+
+// With options.sameCluster, the target is the source cluster itself. There is no remote cluster reference or
+// replication to look up, so the spec only names the buckets, and the manifests are read from the cluster directly.
+// Each side is still captured by a DCP driver of its own, under its own label, so that the DCP connections, capture
+// files and checkpoints of the two sides stay apart even when they stream the same bucket
+func (difftool *xdcrDiffTool) setupSameCluster() error {
+	if options.sourceBucketName == options.targetBucketName {
+		if difftool.collectionMapping == nil {
+			return fmt.Errorf("Comparing bucket %v with itself requires collectionMapping", options.sourceBucketName)
+		}
+		for source, target := range difftool.collectionMapping {
+			if source == target {
+				return fmt.Errorf("Collection %v of bucket %v cannot be compared with itself", source, options.sourceBucketName)
+			}
+		}
+	}
+
+	var err error
+	difftool.specifiedSpec, err = metadata.NewReplicationSpecification(options.sourceBucketName, "", /*sourceBucketUUID*/
+		"" /*targetClusterUUID*/, options.targetBucketName, "" /*targetBucketUUID*/)
+	if err != nil {
+		return fmt.Errorf("setupSameCluster() - %v", err)
+	}
+	difftool.specifiedRef = difftool.selfRef
+	if err = difftool.populateSelfRef(); err != nil {
+		return fmt.Errorf("setupSameCluster() - %v", err)
+	}
+	if err = difftool.loadSelfCapabilities(); err != nil {
+		return err
+	}
+	difftool.tgtCapabilities = difftool.srcCapabilities
+	if !difftool.srcCapabilities.HasCollectionSupport() {
+		if difftool.collectionMapping != nil {
+			return fmt.Errorf("collectionMapping requires a cluster that supports collections")
+		}
+		return nil
+	}
+
+	if difftool.srcBucketManifest, err = difftool.getBucketManifest(difftool.selfRef, options.sourceBucketName); err != nil {
+		return fmt.Errorf("Unable to get the manifest of bucket %v: %v", options.sourceBucketName, err)
+	}
+	if difftool.tgtBucketManifest, err = difftool.getBucketManifest(difftool.selfRef, options.targetBucketName); err != nil {
+		return fmt.Errorf("Unable to get the manifest of bucket %v: %v", options.targetBucketName, err)
+	}
+	if err = difftool.outputManifestsToFiles(nil); err != nil {
+		return err
+	}
+	if err = difftool.compileGivenCollectionMapping(); err != nil {
+		return err
+	}
+	difftool.generateSrcAndTgtColIds()
+	if len(difftool.srcCollectionIds) == 0 || len(difftool.tgtCollectionIds) == 0 {
+		return fmt.Errorf("no collection of bucket %v maps to one of bucket %v, so there is nothing to stream",
+			options.sourceBucketName, options.targetBucketName)
+	}
+	difftool.logger.Infof("Comparing %v collections of bucket %v with collections of bucket %v on the same cluster\n",
+		len(difftool.srcCollectionIds), options.sourceBucketName, options.targetBucketName)
+	return nil
+}
+
+// The manifest of a bucket as the cluster serves it, for when there is no replication to get it through
+func (difftool *xdcrDiffTool) getBucketManifest(ref *metadata.RemoteClusterReference, bucketName string) (*metadata.CollectionsManifest, error) {
+	manifestInfo := make(map[string]interface{})
+	path := xdcrBase.DefaultPoolBucketsPath + bucketName + base.BucketScopesPath
+	err, statusCode := difftool.utils.QueryRestApiWithAuth(ref.HostName(), path, false, ref.UserName(), ref.Password(),
+		ref.HttpAuthMech(), ref.Certificates(), ref.SANInCertificate(), ref.ClientCertificate(), ref.ClientKey(),
+		xdcrBase.MethodGet, "", nil, 0, &manifestInfo, nil, false, difftool.logger)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v returned status %v", path, statusCode)
+	}
+	manifest, err := metadata.NewCollectionsManifestFromMap(manifestInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+	"xdcrDiffer/base"
+	"xdcrDiffer/scheduler"
+	"xdcrDiffer/utils"
+
+	xdcrBase "github.com/couchbase/goxdcr/base"
+	xdcrLog "github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+	xdcrUtils "github.com/couchbase/goxdcr/utils"
+)
+
+const scheduleCommand = "schedule"
+
+// Under the run directory of the schedule, rewritten whenever a pair starts or finishes
+const scheduleStatusFileName = "scheduleStatus.json"
+
+// Under the directory of each pair, the output of its run
+const scheduleRunLogFileName = "xdcrDiffer.log"
+
+const scheduleStatusPath = "/schedule/status"
+
+// Verifies several bucket pairs of the same clusters, each by a run of its own, i.e.
+//
+//	xdcrDiffer schedule -plan plan.json -order critical -maxConcurrent 2 -impactBudget 3
+//
+// The plan holds the options common to all runs, i.e. the clusters and the remote cluster reference, and the bucket
+// pairs. Each run is started in a directory named after its pair under runDir, so that its output is kept apart
+func runScheduleCommand(args []string) error {
+	flags := flag.NewFlagSet(scheduleCommand, flag.ExitOnError)
+	planFile := flags.String("plan", "", "JSON file of the bucket pairs to verify and the options common to their runs")
+	order := flags.String("order", scheduler.OrderSmallest,
+		fmt.Sprintf("order to run the pairs in, %v for the pairs of the fewest documents first or %v for the critical pairs first", scheduler.OrderSmallest, scheduler.OrderCritical))
+	maxConcurrent := flags.Int("maxConcurrent", 1, "number of pairs to run at once")
+	impactBudget := flags.Int("impactBudget", 0, "total Impact of the pairs run at once. Not limited if 0")
+	itemsPerSec := flags.Float64("itemsPerSec", 10000,
+		"documents per second a pair is assumed to be verified at, for the timeline, until one has been")
+	runDir := flags.String("runDir", "schedule", "directory the run of each pair is started in a directory of its own under")
+	statusListen := flags.String("statusListen", "", fmt.Sprintf("address to serve the timeline on as %v, i.e. localhost:8766", scheduleStatusPath))
+	flags.Parse(args)
+
+	if *planFile == "" {
+		return fmt.Errorf("%v requires a plan", scheduleCommand)
+	}
+	if *maxConcurrent < 1 || *impactBudget < 0 {
+		return fmt.Errorf("maxConcurrent has to be at least 1, and impactBudget at least 0")
+	}
+	planBytes, err := ioutil.ReadFile(*planFile)
+	if err != nil {
+		return err
+	}
+	plan, err := scheduler.ParsePlan(planBytes)
+	if err != nil {
+		return fmt.Errorf("Invalid plan %v: %v", *planFile, err)
+	}
+	if err = lookUpPairItems(plan); err != nil {
+		return err
+	}
+	if err = scheduler.SortPairs(plan.Pairs, *order); err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(*runDir, 0777); err != nil {
+		return err
+	}
+
+	pairScheduler := scheduler.NewScheduler(plan.Pairs, &scheduler.Budget{MaxConcurrent: *maxConcurrent, MaxImpact: *impactBudget}, *itemsPerSec)
+	statusFileName := filepath.Join(*runDir, scheduleStatusFileName)
+	onChange := func() {
+		timeline := pairScheduler.Timeline(time.Now())
+		fmt.Printf("%v: %v done, %v failed, %v running, %v pending. Estimated completion %v\n", time.Now().Format(time.RFC3339),
+			timeline.Done, timeline.Failed, timeline.Running, timeline.Pending, timeline.EstimatedCompletion.Format(time.RFC3339))
+		timelineBytes, err := json.MarshalIndent(timeline, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(statusFileName, timelineBytes, 0644)
+		}
+		if err != nil {
+			fmt.Printf("Unable to write %v. err=%v\n", statusFileName, err)
+		}
+	}
+	if *statusListen != "" {
+		go serveScheduleStatus(*statusListen, pairScheduler)
+	}
+
+	return pairScheduler.Run(func(pair *scheduler.Pair) error {
+		return runSchedulePair(executable, *runDir, plan.Args, pair)
+	}, onChange)
+}
+
+// Looks up the size of the source bucket of the pairs that do not give it, as an order or a timeline cannot do without.
+// The source cluster is taken from the options of the plan
+func lookUpPairItems(plan *scheduler.Plan) error {
+	var missing []*scheduler.Pair
+	for _, pair := range plan.Pairs {
+		if pair.Items == 0 {
+			missing = append(missing, pair)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	os.Args = append(os.Args[:1:1], plan.Args...)
+	argParse()
+	ref, err := metadata.NewRemoteClusterReference("", base.SelfReferenceName, options.sourceUrl, options.sourceUsername, options.sourcePassword,
+		"", false, "", nil, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	connStr, err := ref.MyConnectionStr()
+	if err != nil {
+		return err
+	}
+	clusterUtils := xdcrUtils.NewUtilities()
+	logger := xdcrLog.NewLogger("xdcrDiffTool", xdcrLog.DefaultLoggerContext)
+	for _, pair := range missing {
+		bucketInfo, err := clusterUtils.GetClusterInfo(connStr, xdcrBase.DefaultPoolBucketsPath+pair.SourceBucket, ref.UserName(),
+			ref.Password(), ref.HttpAuthMech(), ref.Certificates(), ref.SANInCertificate(), ref.ClientCertificate(), ref.ClientKey(), logger)
+		if err == nil {
+			_, pair.Items, err = utils.GetBucketSizeFromBucketInfo(pair.SourceBucket, bucketInfo)
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to look up the size of bucket %v of pair %v, which can also be given as its Items in the plan: %v",
+				pair.SourceBucket, pair.Name, err)
+		}
+	}
+	return nil
+}
+
+// Options given to the run of a pair come after those of the plan, so that they take precedence
+func runSchedulePair(executable, runDir string, planArgs []string, pair *scheduler.Pair) error {
+	pairDir := filepath.Join(runDir, pair.Name)
+	if err := os.MkdirAll(pairDir, 0777); err != nil {
+		return err
+	}
+	logFile, err := os.Create(filepath.Join(pairDir, scheduleRunLogFileName))
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	args := append([]string{}, planArgs...)
+	args = append(args, "-sourceBucketName", pair.SourceBucket, "-targetBucketName", pair.TargetBucket)
+	args = append(args, pair.Args...)
+	cmd := exec.Command(executable, args...)
+	cmd.Dir = pairDir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	return cmd.Run()
+}
+
+func serveScheduleStatus(listen string, pairScheduler *scheduler.Scheduler) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(scheduleStatusPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pairScheduler.Timeline(time.Now()))
+	})
+	if err := http.ListenAndServe(listen, mux); err != nil {
+		fmt.Printf("Schedule status endpoint stopped. err=%v\n", err)
+	}
+}
@@ -0,0 +1,361 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Orders bucket pairs are run in
+const (
+	// Fewest documents first, so that most pairs have an answer early
+	OrderSmallest = "smallest"
+	// Critical pairs first, each group fewest documents first
+	OrderCritical = "critical"
+)
+
+// States of a bucket pair
+const (
+	PairPending = "pending"
+	PairRunning = "running"
+	PairDone    = "done"
+	PairFailed  = "failed"
+)
+
+// A source and target bucket verified by a run of their own
+type Pair struct {
+	// Also the name of the directory the run is started in
+	Name         string
+	SourceBucket string
+	TargetBucket string
+	// Run ahead of the pairs that are not, with OrderCritical
+	Critical bool `json:",omitempty"`
+	// Documents in the source bucket. Looked up before scheduling if 0
+	Items uint64 `json:",omitempty"`
+	// Share of the impact budget the run takes from the clusters while it lasts. 1 if 0
+	Impact int `json:",omitempty"`
+	// Options of the run of this pair only, on top of those of the plan
+	Args []string `json:",omitempty"`
+}
+
+func (p *Pair) impact() int {
+	if p.Impact == 0 {
+		return 1
+	}
+	return p.Impact
+}
+
+// The bucket pairs to verify, and the options of the runs common to all of them, i.e. the clusters
+type Plan struct {
+	Args  []string
+	Pairs []*Pair
+}
+
+func ParsePlan(data []byte) (*Plan, error) {
+	plan := &Plan{}
+	if err := json.Unmarshal(data, plan); err != nil {
+		return nil, err
+	}
+	if len(plan.Pairs) == 0 {
+		return nil, fmt.Errorf("The plan has no bucket pairs")
+	}
+	names := make(map[string]bool)
+	for _, pair := range plan.Pairs {
+		if pair.Name == "" || pair.Name == "." || pair.Name == ".." || strings.ContainsAny(pair.Name, `/\`) {
+			return nil, fmt.Errorf("Invalid name %q of a bucket pair. It names the directory the pair is run in", pair.Name)
+		}
+		if names[pair.Name] {
+			return nil, fmt.Errorf("Bucket pair %v is given more than once", pair.Name)
+		}
+		names[pair.Name] = true
+		if pair.SourceBucket == "" || pair.TargetBucket == "" {
+			return nil, fmt.Errorf("Bucket pair %v needs both a SourceBucket and a TargetBucket", pair.Name)
+		}
+		if pair.Impact < 0 {
+			return nil, fmt.Errorf("Bucket pair %v has a negative Impact", pair.Name)
+		}
+	}
+	return plan, nil
+}
+
+// Sorts the pairs in the order they are to be run in. Pairs of the same size keep the order of the plan
+func SortPairs(pairs []*Pair, order string) error {
+	if order != OrderSmallest && order != OrderCritical {
+		return fmt.Errorf("Invalid order %v. Accepted values are %v and %v", order, OrderSmallest, OrderCritical)
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if order == OrderCritical && pairs[i].Critical != pairs[j].Critical {
+			return pairs[i].Critical
+		}
+		return pairs[i].Items < pairs[j].Items
+	})
+	return nil
+}
+
+// How much of the clusters the runs of all pairs may take at once
+type Budget struct {
+	// Runs at once
+	MaxConcurrent int
+	// Total impact of the runs at once. Not limited if 0
+	MaxImpact int
+}
+
+// Whether a run of the given impact can start next to those running. A run always starts if none are, so that a pair
+// of an impact beyond the budget runs on its own
+func (b *Budget) fits(running, runningImpact, impact int) bool {
+	if running == 0 {
+		return true
+	}
+	if running >= b.MaxConcurrent {
+		return false
+	}
+	return b.MaxImpact == 0 || runningImpact+impact <= b.MaxImpact
+}
+
+type pairState struct {
+	state    string
+	started  time.Time
+	finished time.Time
+	err      error
+}
+
+// Runs bucket pairs in order, starting each as soon as the budget allows. A pair does not start ahead of one before it
+// that is still waiting for room, so that the order holds
+type Scheduler struct {
+	mtx    sync.Mutex
+	pairs  []*Pair
+	states []*pairState
+	budget *Budget
+	// Documents per second a pair is assumed to be verified at, until one has been
+	assumedItemsPerSec float64
+	started            time.Time
+}
+
+func NewScheduler(pairs []*Pair, budget *Budget, assumedItemsPerSec float64) *Scheduler {
+	states := make([]*pairState, len(pairs))
+	for i := range states {
+		states[i] = &pairState{state: PairPending}
+	}
+	return &Scheduler{pairs: pairs, states: states, budget: budget, assumedItemsPerSec: assumedItemsPerSec}
+}
+
+// Runs every pair with runPair, and calls onChange whenever a pair starts or finishes. Pairs that fail do not stop the
+// others, and are returned in the error
+func (s *Scheduler) Run(runPair func(pair *Pair) error, onChange func()) error {
+	type result struct {
+		index int
+		err   error
+	}
+	resultCh := make(chan result)
+
+	s.mtx.Lock()
+	s.started = time.Now()
+	s.mtx.Unlock()
+	for {
+		s.mtx.Lock()
+		started := s.startNext()
+		running := s.count(PairRunning)
+		s.mtx.Unlock()
+		for _, i := range started {
+			go func(i int) {
+				resultCh <- result{i, runPair(s.pairs[i])}
+			}(i)
+		}
+		if len(started) > 0 {
+			onChange()
+		}
+		if running == 0 {
+			break
+		}
+
+		finished := <-resultCh
+		s.mtx.Lock()
+		state := s.states[finished.index]
+		state.finished = time.Now()
+		state.state = PairDone
+		if finished.err != nil {
+			state.state = PairFailed
+			state.err = finished.err
+		}
+		s.mtx.Unlock()
+		onChange()
+	}
+
+	var failed []string
+	for i, state := range s.states {
+		if state.state == PairFailed {
+			failed = append(failed, fmt.Sprintf("%v (%v)", s.pairs[i].Name, state.err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%v of %v bucket pairs failed: %v", len(failed), len(s.pairs), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Marks the pairs that can start now as running, and returns their indexes
+func (s *Scheduler) startNext() []int {
+	var running, runningImpact int
+	for i, state := range s.states {
+		if state.state == PairRunning {
+			running++
+			runningImpact += s.pairs[i].impact()
+		}
+	}
+	var started []int
+	for i, state := range s.states {
+		if state.state != PairPending {
+			continue
+		}
+		if !s.budget.fits(running, runningImpact, s.pairs[i].impact()) {
+			break
+		}
+		state.state = PairRunning
+		state.started = time.Now()
+		running++
+		runningImpact += s.pairs[i].impact()
+		started = append(started, i)
+	}
+	return started
+}
+
+func (s *Scheduler) count(state string) int {
+	var count int
+	for _, pairState := range s.states {
+		if pairState.state == state {
+			count++
+		}
+	}
+	return count
+}
+
+// Where a pair is at, and when it is expected to start and finish if it has not
+type PairStatus struct {
+	Name     string
+	Items    uint64
+	Critical bool `json:",omitempty"`
+	Impact   int
+	State    string
+	Started  *time.Time `json:",omitempty"`
+	Finished *time.Time `json:",omitempty"`
+	// Of pairs that have not finished
+	EstimatedStart  *time.Time `json:",omitempty"`
+	EstimatedFinish *time.Time `json:",omitempty"`
+	Error           string     `json:",omitempty"`
+}
+
+// The progress of all the pairs, and when all of them are expected to be done
+type Timeline struct {
+	Started time.Time
+	Pairs   []*PairStatus
+	Pending int
+	Running int
+	Done    int
+	Failed  int
+	// Documents per second a pair is verified at, as measured by the pairs finished so far or as assumed until then
+	ItemsPerSec         float64
+	ItemsPerSecMeasured bool
+	// When the last pair finished, once all have
+	EstimatedCompletion time.Time
+}
+
+// The timeline as of now. Pairs yet to finish are expected to take their items at the rate of those finished, and
+// pending pairs to start in order as the ones before them are expected to finish
+func (s *Scheduler) Timeline(now time.Time) *Timeline {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	timeline := &Timeline{Started: s.started, ItemsPerSec: s.assumedItemsPerSec}
+	var measuredItems uint64
+	var measuredSecs float64
+	for i, state := range s.states {
+		if state.state == PairDone {
+			measuredItems += s.pairs[i].Items
+			measuredSecs += state.finished.Sub(state.started).Seconds()
+		}
+	}
+	if measuredItems > 0 && measuredSecs > 0 {
+		timeline.ItemsPerSec = float64(measuredItems) / measuredSecs
+		timeline.ItemsPerSecMeasured = true
+	}
+	duration := func(pair *Pair) time.Duration {
+		if timeline.ItemsPerSec <= 0 {
+			return 0
+		}
+		return time.Duration(float64(pair.Items) / timeline.ItemsPerSec * float64(time.Second))
+	}
+
+	// Finish time and impact of the runs expected to be in progress, as pending pairs are started one by one
+	type run struct {
+		finish time.Time
+		impact int
+	}
+	var runs []run
+	var runningImpact int
+	at := now
+	for i, state := range s.states {
+		pair := s.pairs[i]
+		status := &PairStatus{Name: pair.Name, Items: pair.Items, Critical: pair.Critical, Impact: pair.impact(), State: state.state}
+		timeline.Pairs = append(timeline.Pairs, status)
+		if state.state != PairPending {
+			started := state.started
+			status.Started = &started
+		}
+		switch state.state {
+		case PairDone, PairFailed:
+			finished := state.finished
+			status.Finished = &finished
+			if state.err != nil {
+				status.Error = state.err.Error()
+			}
+			if state.state == PairDone {
+				timeline.Done++
+			} else {
+				timeline.Failed++
+			}
+			if finished.After(timeline.EstimatedCompletion) {
+				timeline.EstimatedCompletion = finished
+			}
+			continue
+		case PairRunning:
+			timeline.Running++
+			finish := state.started.Add(duration(pair))
+			if finish.Before(now) {
+				finish = now
+			}
+			status.EstimatedFinish = &finish
+		case PairPending:
+			timeline.Pending++
+			for !s.budget.fits(len(runs), runningImpact, pair.impact()) {
+				sort.Slice(runs, func(i, j int) bool { return runs[i].finish.Before(runs[j].finish) })
+				if runs[0].finish.After(at) {
+					at = runs[0].finish
+				}
+				runningImpact -= runs[0].impact
+				runs = runs[1:]
+			}
+			start := at
+			finish := start.Add(duration(pair))
+			status.EstimatedStart = &start
+			status.EstimatedFinish = &finish
+		}
+		runs = append(runs, run{*status.EstimatedFinish, pair.impact()})
+		runningImpact += pair.impact()
+		if status.EstimatedFinish.After(timeline.EstimatedCompletion) {
+			timeline.EstimatedCompletion = *status.EstimatedFinish
+		}
+	}
+	return timeline
+}
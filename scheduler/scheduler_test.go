@@ -0,0 +1,152 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package scheduler
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePlan(t *testing.T) {
+	fmt.Println("============== Test case start: TestParsePlan =================")
+	assert := assert.New(t)
+
+	plan, err := ParsePlan([]byte(`{"Args":["-sourceUrl","localhost:8091"],
+		"Pairs":[{"Name":"orders","SourceBucket":"orders","TargetBucket":"ordersCopy","Critical":true,"Impact":2}]}`))
+	assert.Nil(err)
+	assert.Equal([]string{"-sourceUrl", "localhost:8091"}, plan.Args)
+	assert.Equal(&Pair{Name: "orders", SourceBucket: "orders", TargetBucket: "ordersCopy", Critical: true, Impact: 2}, plan.Pairs[0])
+
+	for _, invalid := range []string{
+		`{"Pairs":[]}`,
+		`{"Pairs":[{"Name":"a/b","SourceBucket":"s","TargetBucket":"t"}]}`,
+		`{"Pairs":[{"Name":"a","SourceBucket":"s","TargetBucket":"t"},{"Name":"a","SourceBucket":"s","TargetBucket":"t"}]}`,
+		`{"Pairs":[{"Name":"a","SourceBucket":"s"}]}`,
+		`{"Pairs":[{"Name":"a","SourceBucket":"s","TargetBucket":"t","Impact":-1}]}`,
+	} {
+		_, err = ParsePlan([]byte(invalid))
+		assert.NotNil(err)
+	}
+	fmt.Println("============== Test case end: TestParsePlan =================")
+}
+
+func TestSortPairs(t *testing.T) {
+	fmt.Println("============== Test case start: TestSortPairs =================")
+	assert := assert.New(t)
+
+	pairs := func() []*Pair {
+		return []*Pair{{Name: "large", Items: 300}, {Name: "critical", Items: 200, Critical: true}, {Name: "small", Items: 100}}
+	}
+	names := func(pairs []*Pair) []string {
+		var names []string
+		for _, pair := range pairs {
+			names = append(names, pair.Name)
+		}
+		return names
+	}
+
+	smallest := pairs()
+	assert.Nil(SortPairs(smallest, OrderSmallest))
+	assert.Equal([]string{"small", "critical", "large"}, names(smallest))
+	critical := pairs()
+	assert.Nil(SortPairs(critical, OrderCritical))
+	assert.Equal([]string{"critical", "small", "large"}, names(critical))
+	assert.NotNil(SortPairs(pairs(), "largest"))
+	fmt.Println("============== Test case end: TestSortPairs =================")
+}
+
+func TestSchedulerRun(t *testing.T) {
+	fmt.Println("============== Test case start: TestSchedulerRun =================")
+	assert := assert.New(t)
+
+	pairs := []*Pair{{Name: "a"}, {Name: "b"}, {Name: "heavy", Impact: 3}, {Name: "c"}, {Name: "d"}}
+	scheduler := NewScheduler(pairs, &Budget{MaxConcurrent: 2, MaxImpact: 2}, 1000)
+
+	var mtx sync.Mutex
+	var running, runningImpact, maxRunning, maxImpact int
+	var order []string
+	err := scheduler.Run(func(pair *Pair) error {
+		mtx.Lock()
+		running++
+		runningImpact += pair.impact()
+		if running > maxRunning {
+			maxRunning = running
+		}
+		if runningImpact > maxImpact {
+			maxImpact = runningImpact
+		}
+		order = append(order, pair.Name)
+		mtx.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mtx.Lock()
+		running--
+		runningImpact -= pair.impact()
+		mtx.Unlock()
+		if pair.Name == "c" {
+			return fmt.Errorf("unreachable")
+		}
+		return nil
+	}, func() {})
+
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "1 of 5 bucket pairs failed: c (unreachable)")
+	// Pairs started together may get going in any order
+	assert.Equal(map[string]bool{"a": true, "b": true}, map[string]bool{order[0]: true, order[1]: true})
+	assert.Equal("heavy", order[2])
+	assert.Equal(2, maxRunning)
+	// The heavy pair is beyond the budget, and runs on its own
+	assert.Equal(3, maxImpact)
+
+	timeline := scheduler.Timeline(time.Now())
+	assert.Equal(4, timeline.Done)
+	assert.Equal(1, timeline.Failed)
+	assert.Equal("unreachable", timeline.Pairs[3].Error)
+	// Once every pair is over, the run completed with the last pair to finish, c or d as they run together
+	var lastFinished time.Time
+	for _, pair := range timeline.Pairs {
+		if pair.Finished.After(lastFinished) {
+			lastFinished = *pair.Finished
+		}
+	}
+	assert.Equal(lastFinished, timeline.EstimatedCompletion)
+	fmt.Println("============== Test case end: TestSchedulerRun =================")
+}
+
+func TestSchedulerTimeline(t *testing.T) {
+	fmt.Println("============== Test case start: TestSchedulerTimeline =================")
+	assert := assert.New(t)
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	pairs := []*Pair{{Name: "done", Items: 1000}, {Name: "running", Items: 6000}, {Name: "next", Items: 2000}, {Name: "last", Items: 1000}}
+	scheduler := NewScheduler(pairs, &Budget{MaxConcurrent: 2}, 1)
+	scheduler.states[0] = &pairState{state: PairDone, started: now.Add(-20 * time.Second), finished: now.Add(-10 * time.Second)}
+	scheduler.states[1] = &pairState{state: PairRunning, started: now.Add(-10 * time.Second)}
+
+	timeline := scheduler.Timeline(now)
+	// 1000 items in 10 seconds
+	assert.True(timeline.ItemsPerSecMeasured)
+	assert.Equal(100.0, timeline.ItemsPerSec)
+	assert.Equal(1, timeline.Done)
+	assert.Equal(1, timeline.Running)
+	assert.Equal(2, timeline.Pending)
+	assert.Equal(now.Add(50*time.Second), *timeline.Pairs[1].EstimatedFinish)
+	// There is room for the next pair next to the running one, and the last one waits for the next one to finish
+	assert.Equal(now, *timeline.Pairs[2].EstimatedStart)
+	assert.Equal(now.Add(20*time.Second), *timeline.Pairs[3].EstimatedStart)
+	assert.Equal(now.Add(30*time.Second), *timeline.Pairs[3].EstimatedFinish)
+	assert.Equal(now.Add(50*time.Second), timeline.EstimatedCompletion)
+	fmt.Println("============== Test case end: TestSchedulerTimeline =================")
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"xdcrDiffer/base"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/couchbase/gocbcore/v10"
+	xdcrLog "github.com/couchbase/goxdcr/log"
+)
+
+// Hands the messages of gocb, and of gocbcore, which gocb sets its logger for, to the router
+type gocbLogger struct {
+	router *base.SDKLogRouter
+}
+
+func (l *gocbLogger) Log(level gocb.LogLevel, offset int, format string, v ...interface{}) error {
+	return l.router.Log(gocbcore.LogLevel(level), offset+1, format, v...)
+}
+
+// Routes the SDK log messages up to level into the log of the tool. Messages less severe than warnings are logged
+// at info level, so that they are kept at the default log level of the tool
+func routeSDKLogs(level gocbcore.LogLevel) {
+	logger := xdcrLog.NewLogger("SDK", xdcrLog.DefaultLoggerContext)
+	base.SDKLogs = base.NewSDKLogRouter(level, func(level gocbcore.LogLevel, message string) {
+		switch level {
+		case gocbcore.LogError:
+			logger.Errorf("%v\n", message)
+		case gocbcore.LogWarn:
+			logger.Warnf("%v\n", message)
+		default:
+			logger.Infof("%v\n", message)
+		}
+	})
+	gocb.SetLogger(&gocbLogger{router: base.SDKLogs})
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+	"xdcrDiffer/base"
+	"xdcrDiffer/results"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// How long the seeded documents may take to be replicated before divergences are injected into the target
+const seedReplicationTimeout = 5 * time.Minute
+
+// Writes the seeded dataset to the source, waits for it to be replicated, and then injects the divergences into
+// the target. A document counts as replicated once the target has it at the CAS it was written to the source at,
+// so that what earlier seeded runs left on the target is not mistaken for it
+func (difftool *xdcrDiffTool) seedClusters() error {
+	sourceCol, closeSource, err := openCanaryCollection(options.sourceUrl, difftool.verificationRef(true), false,
+		difftool.specifiedSpec.SourceBucketName, options.seedCollection)
+	if err != nil {
+		return fmt.Errorf("source: %v", err)
+	}
+	defer closeSource()
+	targetCol, closeTarget, err := openCanaryCollection(difftool.specifiedRef.HostName_, difftool.verificationRef(false), true,
+		difftool.specifiedSpec.TargetBucketName, options.seedCollection)
+	if err != nil {
+		return fmt.Errorf("target: %v", err)
+	}
+	defer closeTarget()
+
+	dataset := difftool.seedDataset
+	written := make(map[string]gocb.Cas, dataset.Documents)
+	for i := 0; i < dataset.Documents; i++ {
+		result, err := sourceCol.Upsert(dataset.Key(i), dataset.Document(i), nil)
+		if err != nil {
+			return fmt.Errorf("writing %v to the source: %v", dataset.Key(i), err)
+		}
+		written[dataset.Key(i)] = result.Cas()
+	}
+	difftool.logger.Infof("Wrote %v seeded documents to %v of the source. Waiting for them to be replicated\n",
+		dataset.Documents, options.seedCollection)
+
+	deadline := time.Now().Add(seedReplicationTimeout)
+	for key, cas := range written {
+		for {
+			result, err := targetCol.Get(key, nil)
+			if err == nil && result.Cas() == cas {
+				break
+			}
+			if err != nil && !errors.Is(err, gocb.ErrDocumentNotFound) {
+				difftool.logger.Warnf("Error polling the target for seeded document %v. err=%v\n", key, err)
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("seeded document %v was not replicated within %v", key, seedReplicationTimeout)
+			}
+			time.Sleep(canaryPollInterval)
+		}
+	}
+
+	for _, key := range dataset.DeletedFromTarget() {
+		if _, err = targetCol.Remove(key, nil); err != nil {
+			return fmt.Errorf("removing %v from the target: %v", key, err)
+		}
+	}
+	for i := 0; i < dataset.Divergences; i++ {
+		if _, err = targetCol.Upsert(dataset.TargetOnlyKey(i), dataset.Document(i), nil); err != nil {
+			return fmt.Errorf("writing %v to the target: %v", dataset.TargetOnlyKey(i), err)
+		}
+	}
+	for key, doc := range dataset.ChangedOnTarget() {
+		if _, err = targetCol.Upsert(key, doc, nil); err != nil {
+			return fmt.Errorf("changing %v on the target: %v", key, err)
+		}
+	}
+	difftool.logger.Infof("Injected %v divergences of each kind into the target\n", dataset.Divergences)
+	return nil
+}
+
+// Returns what the mutation differ missed or found in excess among the seeded documents, if anything
+func checkSeededDivergences(dataset *results.SeedDataset) ([]string, error) {
+	query := &results.Query{KeyPrefix: results.SeedKeyPrefix}
+	page, err := results.Run(results.PhaseMutationDiff, filepath.Join(options.mutationDifferDir, base.MutationDiffFileName), query)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string][]string)
+	for _, entry := range page.Entries {
+		found[entry.Category] = append(found[entry.Category], entry.Key)
+	}
+	return dataset.Check(found), nil
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"xdcrDiffer/base"
+)
+
+const spotCheckCommand = "spot-check"
+
+// i.e. META().id or META(receipts).id
+var metaIdRegex = regexp.MustCompile(`(?i)\bMETA\s*\([^)]*\)\s*\.\s*id\b`)
+
+// Verifies exactly the documents a query on the source cluster returns against the target, i.e.
+//
+//	xdcrDiffer spot-check -query 'SELECT META().id FROM orders.sales.receipts WHERE region = "EU"' -collection sales.receipts -- -sourceUrl ... -remoteClusterName ...
+//
+// The options after "--" are those of the run. Neither cluster is captured: the mutation differ fetches the documents
+// from both, as it does the keys of diffKeysSource. Returns the options of the run to carry on with
+func spotCheckArgs(args []string) ([]string, error) {
+	flags := flag.NewFlagSet(spotCheckCommand, flag.ExitOnError)
+	query := flags.String("query", "", "N1QL statement on the source cluster that returns META().id of the documents to verify")
+	collection := flags.String("collection", "", "scope.collection of the source bucket the documents are of, if the keyspace of the query does not tell")
+	flags.Parse(args)
+
+	if *query == "" {
+		return nil, fmt.Errorf("%v requires a query", spotCheckCommand)
+	}
+	if !metaIdRegex.MatchString(*query) {
+		return nil, fmt.Errorf("The query of %v has to return META().id of the documents to verify, i.e. SELECT RAW META().id FROM ...", spotCheckCommand)
+	}
+	if flags.NArg() == 0 {
+		return nil, fmt.Errorf("%v takes the options of the run after \"--\"", spotCheckCommand)
+	}
+	// After the options of the run, so that they take precedence
+	runArgs := append([]string{}, flags.Args()...)
+	runArgs = append(runArgs, "-diffKeysSource", base.DiffKeysSourceN1QLPrefix+*query,
+		"-runDataGeneration=false", "-runFileDiffer=false", "-runMutationDiffer=true", "-fastMode=false")
+	if *collection != "" {
+		runArgs = append(runArgs, "-diffKeysCollection", *collection)
+	}
+	return runArgs, nil
+}
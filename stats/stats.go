@@ -0,0 +1,261 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package stats
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Names of the stats shared across modules. Those with %v are per cluster, i.e. source or target
+const (
+	DcpDocsReceived            = "dcp.%v.docsReceived"
+	DcpSysOrUnsubbedReceived   = "dcp.%v.sysOrUnsubbedEventsReceived"
+	DcpDocsSkipped             = "dcp.%v.docsSkipped"
+	DcpCaptureBuffersInFlight  = "dcp.%v.captureBuffersInFlight"
+	DcpStreamReconnects        = "dcp.%v.streamReconnects"
+	FileDiffVbsCompleted       = "fileDiff.vbucketsCompleted"
+	FileDiffSourceItems        = "fileDiff.sourceItems"
+	FileDiffTargetItems        = "fileDiff.targetItems"
+	MutationDiffKeysDone       = "mutationDiff.keysProcessed"
+	MutationDiffKeysErrored    = "mutationDiff.keysWithErrors"
+	MutationDiffKeysEquivalent = "mutationDiff.keysEquivalentByPlugin"
+	MutationDiffBatchLatency   = "mutationDiff.batchLatencyMs"
+	KvAgentsCreated            = "kv.%v.agentsCreated"
+	KvAgentsReused             = "kv.%v.agentsReused"
+	KvKeepAliveFailures        = "kv.%v.keepAliveFailures"
+	MutationDiffKeysReplayed   = "mutationDiff.keysReplayedAfterDisconnect"
+	ClusterBytesRead           = "cluster.%v.bytesRead"
+	MutationDiffJSONCacheHits  = "mutationDiff.jsonCompareCacheHits"
+	MutationDiffKeysLocked     = "mutationDiff.keysLocked"
+	MutationDiffQuarantined    = "mutationDiff.recordsQuarantined"
+	KvCircuitBreakerTrips      = "kv.%v.circuitBreakerTrips"
+	KvRetriesDenied            = "kv.%v.retriesDenied"
+	MutationDiffPanics         = "mutationDiff.panicsRecovered"
+	ClusterReadUnits           = "cluster.%v.readUnits"
+)
+
+// The registry shared by all modules of the tool
+var Default = NewRegistry()
+
+type Counter struct {
+	value int64
+}
+
+func (c *Counter) Add(delta int64) int64 {
+	return atomic.AddInt64(&c.value, delta)
+}
+
+func (c *Counter) Value() int64 {
+	return atomic.LoadInt64(&c.value)
+}
+
+type Gauge struct {
+	value int64
+}
+
+func (g *Gauge) Set(value int64) {
+	atomic.StoreInt64(&g.value, value)
+}
+
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.value)
+}
+
+// Values are counted in buckets of powers of 2, which is enough to tell the order of magnitude of percentiles
+const histogramBuckets = 64
+
+type Histogram struct {
+	lock    sync.Mutex
+	count   int64
+	sum     int64
+	min     int64
+	max     int64
+	buckets [histogramBuckets]int64
+}
+
+type HistogramSnapshot struct {
+	Count int64
+	Sum   int64
+	Min   int64
+	Max   int64
+	Mean  float64
+	// Upper bounds of the bucket each percentile falls in
+	P50 int64
+	P99 int64
+}
+
+// Negative values are counted as 0
+func (h *Histogram) Observe(value int64) {
+	if value < 0 {
+		value = 0
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.count == 0 || value < h.min {
+		h.min = value
+	}
+	if value > h.max {
+		h.max = value
+	}
+	h.count++
+	h.sum += value
+	h.buckets[bits.Len64(uint64(value))]++
+}
+
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	snapshot := HistogramSnapshot{
+		Count: h.count,
+		Sum:   h.sum,
+		Min:   h.min,
+		Max:   h.max,
+	}
+	if h.count > 0 {
+		snapshot.Mean = float64(h.sum) / float64(h.count)
+		snapshot.P50 = h.percentile(0.5)
+		snapshot.P99 = h.percentile(0.99)
+	}
+	return snapshot
+}
+
+func (h *Histogram) percentile(p float64) int64 {
+	target := int64(math.Ceil(p * float64(h.count)))
+	var seen int64
+	for i, cnt := range h.buckets {
+		seen += cnt
+		if seen >= target {
+			// Bucket i holds values of i bits, the largest of which is 2^i - 1
+			upperBound := int64(1)<<uint(i) - 1
+			if upperBound > h.max {
+				upperBound = h.max
+			}
+			return upperBound
+		}
+	}
+	return h.max
+}
+
+// Named stats that can be safely created and updated from any goroutine
+// Getting a stat by a name that already exists returns the existing one, so that modules
+// that are re-created, i.e. when a vbucket is re-streamed, keep adding to the same stats
+type Registry struct {
+	lock       sync.RWMutex
+	counters   map[string]*Counter
+	gauges     map[string]*Gauge
+	histograms map[string]*Histogram
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		counters:   make(map[string]*Counter),
+		gauges:     make(map[string]*Gauge),
+		histograms: make(map[string]*Histogram),
+	}
+}
+
+func (r *Registry) Counter(name string) *Counter {
+	r.lock.RLock()
+	counter, ok := r.counters[name]
+	r.lock.RUnlock()
+	if ok {
+		return counter
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if counter, ok = r.counters[name]; !ok {
+		counter = &Counter{}
+		r.counters[name] = counter
+	}
+	return counter
+}
+
+func (r *Registry) Gauge(name string) *Gauge {
+	r.lock.RLock()
+	gauge, ok := r.gauges[name]
+	r.lock.RUnlock()
+	if ok {
+		return gauge
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if gauge, ok = r.gauges[name]; !ok {
+		gauge = &Gauge{}
+		r.gauges[name] = gauge
+	}
+	return gauge
+}
+
+func (r *Registry) Histogram(name string) *Histogram {
+	r.lock.RLock()
+	histogram, ok := r.histograms[name]
+	r.lock.RUnlock()
+	if ok {
+		return histogram
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if histogram, ok = r.histograms[name]; !ok {
+		histogram = &Histogram{}
+		r.histograms[name] = histogram
+	}
+	return histogram
+}
+
+type Snapshot struct {
+	Counters   map[string]int64
+	Gauges     map[string]int64
+	Histograms map[string]HistogramSnapshot
+}
+
+func (r *Registry) Snapshot() *Snapshot {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	snapshot := &Snapshot{
+		Counters:   make(map[string]int64),
+		Gauges:     make(map[string]int64),
+		Histograms: make(map[string]HistogramSnapshot),
+	}
+	for name, counter := range r.counters {
+		snapshot.Counters[name] = counter.Value()
+	}
+	for name, gauge := range r.gauges {
+		snapshot.Gauges[name] = gauge.Value()
+	}
+	for name, histogram := range r.histograms {
+		snapshot.Histograms[name] = histogram.Snapshot()
+	}
+	return snapshot
+}
+
+// One stat per line, in the order of their names
+func (s *Snapshot) String() string {
+	var lines []string
+	for name, value := range s.Counters {
+		lines = append(lines, fmt.Sprintf("%v: %v", name, value))
+	}
+	for name, value := range s.Gauges {
+		lines = append(lines, fmt.Sprintf("%v: %v", name, value))
+	}
+	for name, h := range s.Histograms {
+		lines = append(lines, fmt.Sprintf("%v: count=%v mean=%.1f min=%v p50<=%v p99<=%v max=%v", name, h.Count, h.Mean, h.Min, h.P50, h.P99, h.Max))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package stats
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	fmt.Println("============== Test case start: TestRegistry =================")
+	assert := assert.New(t)
+
+	registry := NewRegistry()
+	waitGroup := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for j := 0; j < 100; j++ {
+				registry.Counter("counter").Add(1)
+			}
+		}()
+	}
+	waitGroup.Wait()
+	registry.Gauge("gauge").Set(7)
+	for i := int64(1); i <= 100; i++ {
+		registry.Histogram("histogram").Observe(i)
+	}
+
+	snapshot := registry.Snapshot()
+	assert.Equal(int64(1000), snapshot.Counters["counter"])
+	assert.Equal(int64(7), snapshot.Gauges["gauge"])
+	histogram := snapshot.Histograms["histogram"]
+	assert.Equal(int64(100), histogram.Count)
+	assert.Equal(int64(1), histogram.Min)
+	assert.Equal(int64(100), histogram.Max)
+	assert.Equal(50.5, histogram.Mean)
+	// 50 falls in the bucket of 32 to 63
+	assert.Equal(int64(63), histogram.P50)
+	assert.Equal(int64(100), histogram.P99)
+	fmt.Println("============== Test case end: TestRegistry =================")
+}
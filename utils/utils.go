@@ -18,6 +18,7 @@ import (
 	"io/ioutil"
 	"math"
 	mrand "math/rand"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -28,24 +29,20 @@ import (
 
 func GetFileName(fileDir string, vbno uint16, bucketIndex int) string {
 	var buffer bytes.Buffer
-	buffer.WriteString(fileDir)
-	buffer.WriteString(base.FileDirDelimiter)
 	buffer.WriteString(base.FileNamePrefix)
 	buffer.WriteString(base.FileNameDelimiter)
 	buffer.WriteString(fmt.Sprintf("%v", vbno))
 	buffer.WriteString(base.FileNameDelimiter)
 	buffer.WriteString(fmt.Sprintf("%v", bucketIndex))
-	return buffer.String()
+	return filepath.Join(fileDir, buffer.String())
 }
 
 func GetManifestFileName(fileDir string) string {
 	var buffer bytes.Buffer
-	buffer.WriteString(fileDir)
-	buffer.WriteString(base.FileDirDelimiter)
 	buffer.WriteString(base.FileNamePrefix)
 	buffer.WriteString(base.FileNameDelimiter)
 	buffer.WriteString(fmt.Sprintf("%v", base.ManifestFileName))
-	return buffer.String()
+	return filepath.Join(fileDir, buffer.String())
 }
 
 // hash key into a bucket index in range [0, NumberOfBucketsPerVbucket)
@@ -54,6 +51,80 @@ func GetBucketIndexFromKey(key []byte, numberOfBins int) int {
 	return int(math.Mod(float64(crc), float64(numberOfBins)))
 }
 
+// Returns the vbucket that a key belongs to, using the same hashing as KV
+func GetVbucketFromKey(key []byte) uint16 {
+	crc := crc32.ChecksumIEEE(key)
+	return uint16(((crc >> 16) & 0x7fff) % base.NumberOfVbuckets)
+}
+
+// Parses a list of vbuckets and vbucket ranges, i.e. "0-127,512"
+// Returns a sorted list without duplicates, or nil if the list is empty, which means all vbuckets
+func ParseVbucketList(vbList string) ([]uint16, error) {
+	vbList = strings.TrimSpace(vbList)
+	if vbList == "" {
+		return nil, nil
+	}
+
+	vbSet := make(map[uint16]bool)
+	for _, part := range strings.Split(vbList, ",") {
+		part = strings.TrimSpace(part)
+		bounds := strings.SplitN(part, "-", 2)
+		low, err := parseVbno(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		high := low
+		if len(bounds) == 2 {
+			high, err = parseVbno(bounds[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+		if low > high {
+			return nil, fmt.Errorf("invalid vbucket range %v", part)
+		}
+		for vbno := low; vbno <= high; vbno++ {
+			vbSet[vbno] = true
+		}
+	}
+
+	vbnos := make([]uint16, 0, len(vbSet))
+	for vbno := range vbSet {
+		vbnos = append(vbnos, vbno)
+	}
+	sort.Slice(vbnos, func(i, j int) bool { return vbnos[i] < vbnos[j] })
+	return vbnos, nil
+}
+
+// Formats sorted vbuckets as ParseVbucketList parses them, with consecutive vbuckets as ranges
+func FormatVbucketList(vbnos []uint16) string {
+	var parts []string
+	for i := 0; i < len(vbnos); {
+		j := i
+		for j+1 < len(vbnos) && vbnos[j+1] == vbnos[j]+1 {
+			j++
+		}
+		if j == i {
+			parts = append(parts, strconv.Itoa(int(vbnos[i])))
+		} else {
+			parts = append(parts, fmt.Sprintf("%v-%v", vbnos[i], vbnos[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseVbno(vbStr string) (uint16, error) {
+	vbno, err := strconv.ParseUint(strings.TrimSpace(vbStr), 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid vbucket %v", vbStr)
+	}
+	if vbno >= base.NumberOfVbuckets {
+		return 0, fmt.Errorf("vbucket %v is out of range [0, %v)", vbno, base.NumberOfVbuckets)
+	}
+	return uint16(vbno), nil
+}
+
 // evenly distribute load across workers
 // assumes that num_of_worker <= num_of_load
 // returns load_distribution [][]int, where
@@ -138,6 +209,28 @@ func ParseHighSeqnoStat(statsMap map[string]map[string]string, highSeqnoMap map[
 	return nil
 }
 
+// Seqnos up to which the vbuckets in the stats are persisted. A vbucket reported by more than one node is taken at the
+// furthest. Empty if the bucket does not persist its vbuckets
+func ParsePersistedSeqnoStat(statsMap map[string]map[string]string) (map[uint16]uint64, error) {
+	persistedSeqnoMap := make(map[uint16]uint64)
+	for _, statsMapPerServer := range statsMap {
+		for vbno := 0; vbno < base.NumberOfVbuckets; vbno++ {
+			persistedSeqnoStr, ok := statsMapPerServer[fmt.Sprintf(base.VbucketPersistedSeqnoStatsKey, vbno)]
+			if !ok || persistedSeqnoStr == "" {
+				continue
+			}
+			persistedSeqno, err := strconv.ParseUint(persistedSeqnoStr, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("persisted seqno for vbno=%v in stats map is not a valid uint64. persisted seqno=%v", vbno, persistedSeqnoStr)
+			}
+			if persistedSeqno > persistedSeqnoMap[uint16(vbno)] {
+				persistedSeqnoMap[uint16(vbno)] = persistedSeqno
+			}
+		}
+	}
+	return persistedSeqnoMap, nil
+}
+
 func WaitForWaitGroup(waitGroup *sync.WaitGroup, doneChan chan bool) {
 	waitGroup.Wait()
 	close(doneChan)
@@ -217,6 +310,121 @@ func GetBucketPasswordFromBucketInfo(bucketName string, bucketInfo map[string]in
 	return bucketPassword, nil
 }
 
+// Returns the RAM quota per node, in bytes, and the item count of a bucket
+func GetBucketSizeFromBucketInfo(bucketName string, bucketInfo map[string]interface{}) (uint64, uint64, error) {
+	quota, ok := bucketInfo[base.BucketQuotaKey].(map[string]interface{})
+	if !ok {
+		return 0, 0, fmt.Errorf("Error looking up quota of bucket %v", bucketName)
+	}
+	ramQuota, ok := quota[base.BucketRawRAMQuotaKey].(float64)
+	if !ok {
+		return 0, 0, fmt.Errorf("RAM quota of bucket %v is of wrong type", bucketName)
+	}
+	basicStats, ok := bucketInfo[base.BucketBasicStatsKey].(map[string]interface{})
+	if !ok {
+		return 0, 0, fmt.Errorf("Error looking up basic stats of bucket %v", bucketName)
+	}
+	itemCount, ok := basicStats[base.BucketItemCountKey].(float64)
+	if !ok {
+		return 0, 0, fmt.Errorf("Item count of bucket %v is of wrong type", bucketName)
+	}
+	return uint64(ramQuota), uint64(itemCount), nil
+}
+
+// Returns the bytes of data, the current ops per second and the number of nodes of a bucket
+func GetBucketUsageFromBucketInfo(bucketName string, bucketInfo map[string]interface{}) (dataUsed uint64, opsPerSec float64, numOfNodes int, err error) {
+	basicStats, ok := bucketInfo[base.BucketBasicStatsKey].(map[string]interface{})
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("Error looking up basic stats of bucket %v", bucketName)
+	}
+	dataUsedFloat, ok := basicStats[base.BucketDataUsedKey].(float64)
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("Data used of bucket %v is of wrong type", bucketName)
+	}
+	// Absent while the bucket is idle
+	opsPerSec, _ = basicStats[base.BucketOpsPerSecKey].(float64)
+	nodes, ok := bucketInfo[base.NodesKey].([]interface{})
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("Error looking up nodes of bucket %v", bucketName)
+	}
+	return uint64(dataUsedFloat), opsPerSec, len(nodes), nil
+}
+
+// Returns the KV nodes of a bucket, as host:port, and the indexes into them of the nodes of each vbucket, active first
+func GetVbucketServerMapFromBucketInfo(bucketName string, bucketInfo map[string]interface{}) ([]string, [][]int, error) {
+	serverMap, ok := bucketInfo[base.VbucketServerMapKey].(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("Error looking up vbucket server map of bucket %v", bucketName)
+	}
+	serverListObj, ok := serverMap[base.ServerListKey].([]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("Server list of bucket %v is of wrong type", bucketName)
+	}
+	serverList := make([]string, len(serverListObj))
+	for i, serverObj := range serverListObj {
+		if serverList[i], ok = serverObj.(string); !ok {
+			return nil, nil, fmt.Errorf("Server list of bucket %v is of wrong type", bucketName)
+		}
+	}
+	vbucketMapObj, ok := serverMap[base.VbucketMapKey].([]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("vbucket map of bucket %v is of wrong type", bucketName)
+	}
+	vbucketMap := make([][]int, len(vbucketMapObj))
+	for vbno, nodesObj := range vbucketMapObj {
+		nodes, ok := nodesObj.([]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("vbucket map of bucket %v is of wrong type", bucketName)
+		}
+		for _, nodeObj := range nodes {
+			index, ok := nodeObj.(float64)
+			if !ok {
+				return nil, nil, fmt.Errorf("vbucket map of bucket %v is of wrong type", bucketName)
+			}
+			vbucketMap[vbno] = append(vbucketMap[vbno], int(index))
+		}
+	}
+	return serverList, vbucketMap, nil
+}
+
+// Returns the compression mode of a bucket, i.e. passive
+func GetBucketCompressionModeFromBucketInfo(bucketName string, bucketInfo map[string]interface{}) (string, error) {
+	compressionMode, ok := bucketInfo[base.BucketCompressionModeKey].(string)
+	if !ok {
+		return "", fmt.Errorf("Error looking up compression mode of bucket %v", bucketName)
+	}
+	return compressionMode, nil
+}
+
+// Returns the metadata purge interval of bucket info, which has one if the bucket does not take that of the cluster,
+// or of the auto-compaction settings of the cluster
+func GetPurgeIntervalFromSettings(settings map[string]interface{}) (time.Duration, bool) {
+	days, ok := settings[base.BucketPurgeIntervalKey].(float64)
+	if !ok || days <= 0 {
+		return 0, false
+	}
+	return time.Duration(days * float64(24*time.Hour)), true
+}
+
+// Sizes DCP flow control from the size of a bucket
+// The buffer of each connection grows with the RAM quota, so that backfill on high-bandwidth links does not wait
+// on acknowledgements, and large buckets are streamed over more connections per node
+func DcpFlowControlForBucket(ramQuotaPerNode, itemCount uint64) (bufferSize, connectionsPerNode int) {
+	bufferSize = int(ramQuotaPerNode / base.DcpBufferSizeQuotaDivisor)
+	if bufferSize < base.DcpMinBufferSize {
+		bufferSize = base.DcpMinBufferSize
+	}
+	if bufferSize > base.DcpMaxBufferSize {
+		bufferSize = base.DcpMaxBufferSize
+	}
+
+	connectionsPerNode = int(itemCount/base.DcpItemsPerConnection) + 1
+	if connectionsPerNode > base.DcpMaxConnectionsPerNode {
+		connectionsPerNode = base.DcpMaxConnectionsPerNode
+	}
+	return
+}
+
 // check if a cluster (with specified clusterCompatibility) is compatible with version
 func IsClusterCompatible(clusterCompatibility int, version []int) bool {
 	return clusterCompatibility >= EncodeVersionToEffectiveVersion(version)
@@ -237,7 +445,8 @@ func EncodeVersionToEffectiveVersion(version []int) int {
 	return effectiveVersion
 }
 
-func PopulateCCCPConnectString(url string) string {
+// kvPort is the KV port of the cluster, if it is not the default
+func PopulateCCCPConnectString(url string, kvPort uint16) string {
 	var cccpUrl string
 	if strings.HasPrefix(url, base.HttpPrefix) {
 		cccpUrl = strings.TrimPrefix(url, base.HttpPrefix)
@@ -252,6 +461,9 @@ func PopulateCCCPConnectString(url string) string {
 	if portErr == nil && (portNo < base.ClusterRunMinPortNo || portNo > base.ClusterRunMaxPortNo) {
 		cccpUrl = xdcrBase.GetHostName(cccpUrl)
 	}
+	if kvPort > 0 {
+		cccpUrl = xdcrBase.GetHostAddr(xdcrBase.GetHostName(cccpUrl), kvPort)
+	}
 
 	if !strings.HasPrefix(cccpUrl, base.CouchbasePrefix) {
 		cccpUrl = fmt.Sprintf("%v%v", base.CouchbasePrefix, cccpUrl)
@@ -260,11 +472,11 @@ func PopulateCCCPConnectString(url string) string {
 }
 
 func DiffKeysFileName(isSource bool, diffFileDir, diffKeysFileName string) string {
-	suffix := base.SourceClusterName
+	suffix := base.SourceClusterLabel
 	if !isSource {
-		suffix = base.TargetClusterName
+		suffix = base.TargetClusterLabel
 	}
-	return diffFileDir + base.FileDirDelimiter + diffKeysFileName + base.FileNameDelimiter + suffix
+	return filepath.Join(diffFileDir, diffKeysFileName+base.FileNameDelimiter+suffix)
 }
 
 func GetCertificate(u xdcrUtils.UtilsIface, hostname string, username, password string, authMech xdcrBase.HttpAuthMech) ([]byte, error) {
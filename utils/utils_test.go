@@ -0,0 +1,90 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVbucketList(t *testing.T) {
+	fmt.Println("============== Test case start: TestParseVbucketList =================")
+	assert := assert.New(t)
+
+	testCases := []struct {
+		vbList   string
+		expected []uint16
+		isErr    bool
+	}{
+		{vbList: "", expected: nil},
+		{vbList: "  ", expected: nil},
+		{vbList: "5", expected: []uint16{5}},
+		{vbList: "0,1023", expected: []uint16{0, 1023}},
+		{vbList: "3-6", expected: []uint16{3, 4, 5, 6}},
+		{vbList: " 9 , 2 - 4 ", expected: []uint16{2, 3, 4, 9}},
+		{vbList: "7-7", expected: []uint16{7}},
+		// Duplicates and overlapping ranges are merged
+		{vbList: "4,2-5,4,5-6", expected: []uint16{2, 3, 4, 5, 6}},
+		// Out of range
+		{vbList: "1024", isErr: true},
+		{vbList: "1000-1024", isErr: true},
+		{vbList: "70000", isErr: true},
+		{vbList: "-1", isErr: true},
+		// Empty items
+		{vbList: "1,,2", isErr: true},
+		{vbList: "1,", isErr: true},
+		{vbList: "3-", isErr: true},
+		// Malformed
+		{vbList: "a", isErr: true},
+		{vbList: "1-2-3", isErr: true},
+		{vbList: "6-3", isErr: true},
+	}
+	for _, testCase := range testCases {
+		vbnos, err := ParseVbucketList(testCase.vbList)
+		if testCase.isErr {
+			assert.NotNil(err, testCase.vbList)
+			continue
+		}
+		assert.Nil(err, testCase.vbList)
+		assert.Equal(testCase.expected, vbnos, testCase.vbList)
+	}
+	fmt.Println("============== Test case end: TestParseVbucketList =================")
+}
+
+func TestFormatVbucketList(t *testing.T) {
+	fmt.Println("============== Test case start: TestFormatVbucketList =================")
+	assert := assert.New(t)
+
+	testCases := []struct {
+		vbnos    []uint16
+		expected string
+	}{
+		{vbnos: nil, expected: ""},
+		{vbnos: []uint16{5}, expected: "5"},
+		{vbnos: []uint16{1, 3}, expected: "1,3"},
+		{vbnos: []uint16{1, 2}, expected: "1-2"},
+		{vbnos: []uint16{0, 1, 2, 3, 7, 9, 10, 1023}, expected: "0-3,7,9-10,1023"},
+	}
+	for _, testCase := range testCases {
+		vbList := FormatVbucketList(testCase.vbnos)
+		assert.Equal(testCase.expected, vbList)
+		// What is formatted parses back to the same vbuckets
+		vbnos, err := ParseVbucketList(vbList)
+		assert.Nil(err)
+		if len(testCase.vbnos) == 0 {
+			assert.Empty(vbnos)
+		} else {
+			assert.Equal(testCase.vbnos, vbnos)
+		}
+	}
+	fmt.Println("============== Test case end: TestFormatVbucketList =================")
+}
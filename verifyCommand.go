@@ -0,0 +1,96 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"crypto"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+	"xdcrDiffer/results"
+)
+
+const verifyCommand = "verify"
+
+// Without run options, checks that the artifacts of a run were not altered since its artifactManifest was written, i.e.
+//
+//	xdcrDiffer verify -publicKeyFile signer.pub artifactManifest.json
+//
+// With run options after --, runs a whole verification stage by stage, resuming from the stage that failed if it
+// is run again, i.e.
+//
+//	xdcrDiffer verify -recheckPasses 1 -failThreshold MissingFromTarget=0 -- -sourceUrl ... -remoteClusterName ...
+func runVerifyCommand(args []string) error {
+	flags := flag.NewFlagSet(verifyCommand, flag.ExitOnError)
+	publicKeyFile := flags.String("publicKeyFile", "",
+		"PEM public key, or certificate, of the key the manifest was signed with")
+	stateDir := flags.String("stateDir", "verify",
+		"directory the state of the stages, their logs and the report of a whole verification are written to")
+	recheckPasses := flags.Int("recheckPasses", 0,
+		"number of times the mutation differ verifies the file differ output again after it first did, each time after recheckWaitSecs")
+	recheckWaitSecs := flags.Int("recheckWaitSecs", 60,
+		"seconds to wait before each re-check pass, for the replication to catch up")
+	failThreshold := flags.String("failThreshold", "0",
+		"maximum number of differences the verification passes with, or comma separated category=max pairs, i.e. MissingFromTarget=0,total=100")
+	restart := flags.Bool("restart", false,
+		"run every stage again, instead of resuming from the stage that failed")
+	flags.Parse(args)
+
+	if flags.NArg() == 1 && !strings.HasPrefix(flags.Arg(0), "-") {
+		return verifyArtifactManifest(flags.Arg(0), *publicKeyFile)
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("%v takes either the artifact manifest to verify, or the options of the run to verify after --", verifyCommand)
+	}
+	if *recheckPasses < 0 || *recheckWaitSecs < 0 {
+		return fmt.Errorf("recheckPasses and recheckWaitSecs cannot be negative")
+	}
+	thresholds, err := results.ParseFailThresholds(*failThreshold)
+	if err != nil {
+		return err
+	}
+	return runVerifyStages(flags.Args(), *stateDir, *recheckPasses, time.Duration(*recheckWaitSecs)*time.Second, thresholds, *restart)
+}
+
+// Without a publicKeyFile, only the digests are checked and the signature, if any, is not
+func verifyArtifactManifest(manifestPath, publicKeyFile string) error {
+	var publicKey crypto.PublicKey
+	if publicKeyFile != "" {
+		keyBytes, err := ioutil.ReadFile(publicKeyFile)
+		if err != nil {
+			return err
+		}
+		if publicKey, err = results.ParseVerificationKey(keyBytes); err != nil {
+			return err
+		}
+	}
+	manifest, err := results.ReadArtifactManifest(manifestPath, publicKey)
+	if err != nil {
+		return err
+	}
+	altered, err := manifest.Verify(manifestPath)
+	if err != nil {
+		return err
+	}
+	for _, artifact := range altered {
+		fmt.Printf("  %v\n", artifact)
+	}
+	if len(altered) > 0 {
+		return fmt.Errorf("%v of %v artifacts of %v were altered since %v", len(altered), len(manifest.Artifacts),
+			manifestPath, manifest.Created)
+	}
+	if publicKey != nil {
+		fmt.Printf("Signature of %v is valid\n", manifestPath)
+	}
+	fmt.Printf("All %v artifacts of %v match\n", len(manifest.Artifacts), manifestPath)
+	return nil
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+	"xdcrDiffer/pipeline"
+	"xdcrDiffer/results"
+)
+
+// Under the state directory of a verification
+const (
+	verifyStateFileName  = "verifyState.json"
+	verifyReportFileName = "verifyReport.json"
+)
+
+const (
+	verifyStageCapture      = "capture"
+	verifyStageFileDiff     = "fileDiff"
+	verifyStageMutationDiff = "mutationDiff"
+	// Followed by the number of the pass
+	verifyStageRecheck = "recheck"
+)
+
+// Written at the end of every verification, including one that stopped at a failed stage
+type verifyReport struct {
+	Stages []*pipeline.StageState
+	// Unset if a stage failed
+	Verdict *results.RunVerdict `json:",omitempty"`
+}
+
+// Each stage is a run of its own with the given run options, told which phases to run. Re-check passes write to a
+// mutation differ directory of their own, and the verdict is of the last of them
+func runVerifyStages(runArgs []string, stateDir string, recheckPasses int, recheckWait time.Duration, thresholds map[string]int, restart bool) error {
+	os.Args = append(os.Args[:1:1], runArgs...)
+	argParse()
+	if options.runVerdict != "" {
+		return fmt.Errorf("%v writes the verdict itself, as given by its own failThreshold, so the run options cannot have runVerdict", verifyCommand)
+	}
+
+	stages := []string{verifyStageCapture, verifyStageFileDiff, verifyStageMutationDiff}
+	stageArgs := map[string][]string{
+		verifyStageCapture:      {"-runDataGeneration=true", "-runFileDiffer=false", "-runMutationDiffer=false"},
+		verifyStageFileDiff:     {"-runDataGeneration=false", "-runFileDiffer=true", "-runMutationDiffer=false"},
+		verifyStageMutationDiff: {"-runDataGeneration=false", "-runFileDiffer=false", "-runMutationDiffer=true"},
+	}
+	mutationDifferDir := options.mutationDifferDir
+	for pass := 1; pass <= recheckPasses; pass++ {
+		stage := fmt.Sprintf("%v%v", verifyStageRecheck, pass)
+		stages = append(stages, stage)
+		mutationDifferDir = fmt.Sprintf("%v_%v", options.mutationDifferDir, stage)
+		stageArgs[stage] = append(append([]string{}, stageArgs[verifyStageMutationDiff]...), "-mutationDifferDir", mutationDifferDir)
+	}
+
+	if err := os.MkdirAll(stateDir, 0777); err != nil {
+		return err
+	}
+	stateFileName := filepath.Join(stateDir, verifyStateFileName)
+	state, err := pipeline.ReadState(stateFileName)
+	if err != nil {
+		return fmt.Errorf("Unable to read %v: %v", stateFileName, err)
+	}
+	if state != nil && !restart {
+		if err = state.CanResume(runArgs, stages); err != nil {
+			return fmt.Errorf("Unable to resume the verification of %v, as %v. Use -restart to run it over", stateDir, err)
+		}
+	} else {
+		state = pipeline.NewState(runArgs, stages)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	err = state.Run(func(stage *pipeline.StageState) error {
+		if strings.HasPrefix(stage.Name, verifyStageRecheck) {
+			fmt.Printf("Waiting %v before %v\n", recheckWait, stage.Name)
+			time.Sleep(recheckWait)
+		}
+		return runVerifyStage(executable, stateDir, stage.Name, append(append([]string{}, runArgs...), stageArgs[stage.Name]...))
+	}, func() {
+		for _, stage := range state.Stages {
+			if stage.Status == pipeline.StatusRunning {
+				fmt.Printf("%v: stage %v started\n", time.Now().Format(time.RFC3339), stage.Name)
+			}
+		}
+		if err := state.Write(stateFileName); err != nil {
+			fmt.Printf("Unable to write %v. err=%v\n", stateFileName, err)
+		}
+	})
+
+	report := &verifyReport{Stages: state.Stages}
+	if err == nil {
+		// Regenerated every time, as it only reads what the stages left
+		report.Verdict, err = results.NewRunVerdict(runVerdictPatterns(options.fileDifferDir, mutationDifferDir), thresholds)
+	}
+	reportFileName := filepath.Join(stateDir, verifyReportFileName)
+	if reportBytes, marshalErr := json.MarshalIndent(report, "", "  "); marshalErr != nil {
+		fmt.Printf("Unable to write %v. err=%v\n", reportFileName, marshalErr)
+	} else if writeErr := ioutil.WriteFile(reportFileName, reportBytes, 0644); writeErr != nil {
+		fmt.Printf("Unable to write %v. err=%v\n", reportFileName, writeErr)
+	}
+	for _, stage := range state.Stages {
+		fmt.Printf("  %-14v %v\n", stage.Name, stage.Status)
+	}
+
+	if failed := state.Failed(); failed != nil {
+		if failed.ExitCode == replicationInactiveExitCode {
+			fmt.Printf("Verification skipped at stage %v, as the replication is not running. Report written to %v\n", failed.Name, reportFileName)
+			os.Exit(replicationInactiveExitCode)
+		}
+		return fmt.Errorf("Verification stopped at stage %v, whose output is in %v: %v", failed.Name,
+			filepath.Join(stateDir, failed.Name+".log"), failed.Error)
+	}
+	if err != nil {
+		return err
+	}
+	if report.Verdict.Reason != "" {
+		fmt.Printf("Verification verdict: %v, as %v. Report written to %v\n", report.Verdict.Verdict, report.Verdict.Reason, reportFileName)
+	} else {
+		fmt.Printf("Verification verdict: %v with %v differences. Report written to %v\n", report.Verdict.Verdict, report.Verdict.Differences, reportFileName)
+	}
+	if report.Verdict.Verdict == results.RunVerdictFail {
+		os.Exit(runVerdictFailExitCode)
+	}
+	return nil
+}
+
+// The output of each stage is written to a log of its own under the state directory
+func runVerifyStage(executable, stateDir, stage string, args []string) error {
+	logFile, err := os.Create(filepath.Join(stateDir, stage+".log"))
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(executable, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	return cmd.Run()
+}
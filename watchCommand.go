@@ -0,0 +1,164 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"xdcrDiffer/base"
+)
+
+const watchCommand = "watch"
+
+// Clears the terminal and moves the cursor to its top, so that each refresh replaces the last
+const clearTerminal = "\033[H\033[2J"
+
+// Renders the live status of a running instance, through its control endpoints or by following its progress
+// events, i.e.
+//
+//	xdcrDiffer watch -control 127.0.0.1:8096
+//	xdcrDiffer watch -events run/progress.jsonl
+func runWatchCommand(args []string) error {
+	flags := flag.NewFlagSet(watchCommand, flag.ExitOnError)
+	control := flags.String("control", "", "controlListen address of the running instance")
+	events := flags.String("events", "", "progressEvents file of the running instance")
+	intervalSecs := flags.Int("intervalSecs", 2, "seconds between refreshes")
+	once := flags.Bool("once", false, "print the status once, without clearing the terminal, and exit")
+	flags.Parse(args)
+
+	if (*control == "") == (*events == "") {
+		return fmt.Errorf("Usage: %v %v -control <address> | -events <file> [OPTIONS]", os.Args[0], watchCommand)
+	}
+	if *intervalSecs <= 0 {
+		return fmt.Errorf("intervalSecs has to be positive")
+	}
+
+	var render func() ([]string, error)
+	if *control != "" {
+		render = func() ([]string, error) {
+			return watchControl(*control)
+		}
+	} else {
+		follower, err := newEventsFollower(*events)
+		if err != nil {
+			return err
+		}
+		defer follower.close()
+		render = follower.render
+	}
+
+	for {
+		lines, err := render()
+		if *once {
+			if err != nil {
+				return err
+			}
+			fmt.Println(strings.Join(lines, "\n"))
+			return nil
+		}
+		fmt.Print(clearTerminal)
+		fmt.Printf("%v  (every %vs, Ctrl-C to stop watching)\n\n", time.Now().Format(time.RFC3339), *intervalSecs)
+		if err != nil {
+			// The run may have finished or be restarting, so it is watched for again rather than given up on
+			fmt.Printf("Unable to get the status: %v\n", err)
+		} else {
+			fmt.Println(strings.Join(lines, "\n"))
+		}
+		time.Sleep(time.Duration(*intervalSecs) * time.Second)
+	}
+}
+
+func watchControl(address string) ([]string, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get("http://" + address + controlStatusPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v returned %v", controlStatusPath, resp.Status)
+	}
+	status := &controlStatus{}
+	if err = json.NewDecoder(resp.Body).Decode(status); err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	if len(status.Phases) > 0 {
+		lines = append(lines, fmt.Sprintf("running: %v", strings.Join(status.Phases, ", ")))
+	} else {
+		lines = append(lines, "running: no phase")
+	}
+	if status.Paused {
+		lines = append(lines, fmt.Sprintf("PAUSED, for %.0fs in total", status.PausedSecs))
+	} else if status.PausedSecs > 0 {
+		lines = append(lines, fmt.Sprintf("paused for %.0fs in total", status.PausedSecs))
+	}
+	if status.ThrottledBy != "" {
+		lines = append(lines, fmt.Sprintf("throttled to %.0f%% of the mutation differ concurrency by %v", status.ConcurrencyShare*100, status.ThrottledBy))
+	}
+	if status.Stats != nil {
+		lines = append(lines, "", status.Stats.String())
+	}
+	return lines, nil
+}
+
+// Reads the progress events written so far, and then those appended on each refresh
+type eventsFollower struct {
+	file   *os.File
+	reader *bufio.Reader
+	// A line whose end has not been written yet
+	partial string
+	tally   *base.ProgressTally
+}
+
+func newEventsFollower(fileName string) (*eventsFollower, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &eventsFollower{file: file, reader: bufio.NewReader(file), tally: base.NewProgressTally()}, nil
+}
+
+func (f *eventsFollower) render() ([]string, error) {
+	for {
+		line, err := f.reader.ReadString('\n')
+		if err == io.EOF {
+			f.partial += line
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, f.partial = f.partial+line, ""
+		event := &base.ProgressEvent{}
+		if err = json.Unmarshal([]byte(line), event); err != nil {
+			// i.e. output of the run other than events, when they are written to stdout
+			continue
+		}
+		f.tally.Add(event)
+	}
+	lines := f.tally.Lines()
+	if len(lines) == 0 {
+		lines = []string{"no events yet"}
+	}
+	return lines, nil
+}
+
+func (f *eventsFollower) close() {
+	f.file.Close()
+}